@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"bosun.org/_third_party/github.com/golang/groupcache/singleflight"
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.cache.hit", metadata.Counter, metadata.Count,
+		"Number of lookups answered from the cache.")
+	metadata.AddMetricMeta("bosun.cache.miss", metadata.Counter, metadata.Count,
+		"Number of lookups not in the cache or expired.")
+	metadata.AddMetricMeta("bosun.cache.evict", metadata.Counter, metadata.Count,
+		"Number of values removed from the cache to stay under its size limit.")
+	metadata.AddMetricMeta("bosun.cache.size", metadata.Gauge, metadata.Bytes,
+		"Estimated size of the values in the cache.")
+}
+
+// TTL is a cache of values that expire a fixed time after they are added.
+// When the total size of its values exceeds a limit, the least recently used
+// are evicted. Hits, misses, evictions, and size are reported under
+// bosun.cache.*, tagged with the cache's name.
+type TTL struct {
+	ttl     time.Duration
+	maxSize int64
+	tags    opentsdb.TagSet
+
+	g singleflight.Group
+
+	sync.Mutex
+	size    int64
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type ttlEntry struct {
+	key     string
+	value   interface{}
+	size    int64
+	expires time.Time
+}
+
+// NewTTL returns a cache whose values expire after ttl. If maxSize is
+// greater than 0, it limits the total size of values in the cache.
+func NewTTL(name string, ttl time.Duration, maxSize int64) *TTL {
+	return &TTL{
+		ttl:     ttl,
+		maxSize: maxSize,
+		tags:    opentsdb.TagSet{"cache": name},
+		ll:      list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Duration returns how long values are kept.
+func (c *TTL) Duration() time.Duration {
+	return c.ttl
+}
+
+// Get returns the value for key. If it is missing or expired, getFn is called
+// to get the value and its size, which is cached if getFn succeeds.
+func (c *TTL) Get(key string, getFn func() (interface{}, int64, error)) (interface{}, error) {
+	if c == nil {
+		v, _, err := getFn()
+		return v, err
+	}
+	c.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*ttlEntry)
+		if time.Now().Before(e.expires) {
+			c.ll.MoveToFront(el)
+			c.Unlock()
+			collect.Add("cache.hit", c.tags, 1)
+			return e.value, nil
+		}
+		c.remove(el)
+	}
+	c.Unlock()
+	collect.Add("cache.miss", c.tags, 1)
+	return c.g.Do(key, func() (interface{}, error) {
+		v, size, err := getFn()
+		if err == nil {
+			c.add(key, v, size)
+		}
+		return v, err
+	})
+}
+
+func (c *TTL) add(key string, v interface{}, size int64) {
+	if c.maxSize > 0 && size > c.maxSize {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.ll.PushFront(&ttlEntry{
+		key:     key,
+		value:   v,
+		size:    size,
+		expires: time.Now().Add(c.ttl),
+	})
+	c.size += size
+	for c.maxSize > 0 && c.size > c.maxSize {
+		c.remove(c.ll.Back())
+		collect.Add("cache.evict", c.tags, 1)
+	}
+	collect.Put("cache.size", c.tags, c.size)
+}
+
+// remove removes el. c must be locked.
+func (c *TTL) remove(el *list.Element) {
+	e := c.ll.Remove(el).(*ttlEntry)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
+
+// Clear removes all values.
+func (c *TTL) Clear() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.ll.Init()
+	c.entries = make(map[string]*list.Element)
+	c.size = 0
+	collect.Put("cache.size", c.tags, c.size)
+}
+
+// Len returns the number of values in the cache, including expired values
+// not yet removed.
+func (c *TTL) Len() int {
+	c.Lock()
+	defer c.Unlock()
+	return c.ll.Len()
+}
+
+// Size returns the estimated size of the values in the cache.
+func (c *TTL) Size() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.size
+}
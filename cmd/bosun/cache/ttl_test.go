@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTL(t *testing.T) {
+	c := NewTTL("test", time.Hour, 10)
+	calls := 0
+	get := func(key string, size int64) interface{} {
+		v, err := c.Get(key, func() (interface{}, int64, error) {
+			calls++
+			return key, size, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	get("a", 4)
+	get("a", 4)
+	if calls != 1 {
+		t.Fatalf("expected 1 call, got %d", calls)
+	}
+	get("b", 4)
+	get("a", 4) // a is now most recently used
+	get("c", 4) // evicts b
+	if calls != 3 || c.Len() != 2 || c.Size() != 8 {
+		t.Fatalf("unexpected cache: %d calls, %d entries, size %d", calls, c.Len(), c.Size())
+	}
+	get("a", 4)
+	if calls != 3 {
+		t.Fatal("expected a to be cached")
+	}
+	get("b", 4)
+	if calls != 4 {
+		t.Fatal("expected b to be evicted")
+	}
+	get("big", 11)
+	if _, ok := c.entries["big"]; ok {
+		t.Fatal("expected value larger than the cache to not be cached")
+	}
+
+	c.ttl = -time.Second
+	c.Clear()
+	get("a", 1)
+	get("a", 1)
+	if calls != 7 {
+		t.Fatalf("expected expired value to be fetched again, got %d calls", calls)
+	}
+}
@@ -0,0 +1,340 @@
+package conf
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf/parse"
+	"bosun.org/opentsdb"
+)
+
+// AlertTest is a test of an alert's logic: the alert is evaluated against
+// synthetic series instead of OpenTSDB, and the resulting status of each
+// alert key is compared to the expected status.
+type AlertTest struct {
+	Text   string
+	Name   string
+	Alert  *Alert
+	Step   time.Duration // Time between points of each series: 1m
+	Series []*TestSeries
+	Expect []*TestExpect
+}
+
+// TestSeries is a synthetic OpenTSDB series. Its last value is at the time the
+// test is run, each earlier value one step before the next.
+type TestSeries struct {
+	Metric string
+	Tags   opentsdb.TagSet
+	Values []float64
+}
+
+// TestExpect is the expected status of the alert key with Tags, or of every
+// alert key if Tags is nil. Status is normal, warning, or critical.
+type TestExpect struct {
+	Tags   opentsdb.TagSet
+	Status string
+}
+
+var testStatuses = map[string]string{
+	"normal":   "normal",
+	"warn":     "warning",
+	"warning":  "warning",
+	"crit":     "critical",
+	"critical": "critical",
+}
+
+func (c *Conf) loadTest(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.AlertTests[name]; ok {
+		c.errorf("duplicate test name: %s", name)
+	}
+	t := AlertTest{
+		Text: s.RawText,
+		Name: name,
+		Step: time.Minute,
+	}
+	saw := make(map[string]bool)
+	for _, n := range s.Nodes.Nodes {
+		c.at(n)
+		p, ok := n.(*parse.PairNode)
+		if !ok {
+			c.errorf("unexpected node")
+		}
+		v := c.Expand(p.Val.Text, nil, false)
+		// series and expect may be given more than once.
+		switch k := p.Key.Text; k {
+		case "alert":
+			c.seen(k, saw)
+			a, ok := c.Alerts[v]
+			if !ok {
+				c.errorf("unknown alert %s", v)
+			}
+			t.Alert = a
+		case "step":
+			c.seen(k, saw)
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if d <= 0 {
+				c.errorf("step must be positive")
+			}
+			t.Step = time.Duration(d)
+		case "series":
+			ts, err := parseTestSeries(v)
+			if err != nil {
+				c.error(err)
+			}
+			t.Series = append(t.Series, ts)
+		case "expect":
+			te, err := parseTestExpect(v)
+			if err != nil {
+				c.error(err)
+			}
+			t.Expect = append(t.Expect, te)
+		default:
+			c.errorf("unknown key %s", k)
+		}
+	}
+	c.at(s)
+	if t.Alert == nil {
+		c.errorf("missing alert")
+	}
+	if len(t.Expect) == 0 {
+		c.errorf("missing expect")
+	}
+	c.AlertTests[name] = &t
+}
+
+// parseTestSeries parses a series of the form metric{tags} v1 v2 ... with
+// values separated by spaces or commas.
+func parseTestSeries(s string) (*TestSeries, error) {
+	s = strings.TrimSpace(s)
+	ts := &TestSeries{
+		Tags: make(opentsdb.TagSet),
+	}
+	if i := strings.IndexAny(s, "{ \t,"); i < 0 {
+		return nil, fmt.Errorf("series requires a metric and at least one value")
+	} else if s[i] == '{' {
+		j := strings.Index(s, "}")
+		if j < i {
+			return nil, fmt.Errorf("missing } in series")
+		}
+		tags, err := parseTestTags(s[i+1 : j])
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range tags {
+			if strings.ContainsAny(v, "*|") {
+				return nil, fmt.Errorf("series tag values must be literal: %s=%s", k, v)
+			}
+		}
+		ts.Metric, ts.Tags, s = s[:i], tags, s[j+1:]
+	} else {
+		ts.Metric, s = s[:i], s[i:]
+	}
+	if ts.Metric == "" {
+		return nil, fmt.Errorf("series requires a metric")
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == ','
+	})
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("series requires at least one value")
+	}
+	for _, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		ts.Values = append(ts.Values, v)
+	}
+	return ts, nil
+}
+
+func parseTestTags(s string) (opentsdb.TagSet, error) {
+	if strings.TrimSpace(s) == "" {
+		return make(opentsdb.TagSet), nil
+	}
+	return opentsdb.ParseTags(s)
+}
+
+// parseTestExpect parses an expectation of the form [{tags}] status.
+func parseTestExpect(s string) (*TestExpect, error) {
+	s = strings.TrimSpace(s)
+	te := new(TestExpect)
+	if strings.HasPrefix(s, "{") {
+		i := strings.Index(s, "}")
+		if i < 0 {
+			return nil, fmt.Errorf("missing } in expect")
+		}
+		tags, err := parseTestTags(s[1:i])
+		if err != nil {
+			return nil, err
+		}
+		te.Tags = tags
+		s = strings.TrimSpace(s[i+1:])
+	}
+	status, ok := testStatuses[s]
+	if !ok {
+		return nil, fmt.Errorf("unknown status %q, expected normal, warn, or crit", s)
+	}
+	te.Status = status
+	return te, nil
+}
+
+// Context returns an OpenTSDB context that answers queries from t's series,
+// as if t was run at now. Series are filtered and grouped by the query's tags,
+// and aggregated with sum, min, max, or avg; downsampling is ignored.
+func (t *AlertTest) Context(now time.Time) opentsdb.Context {
+	return &testContext{t, now}
+}
+
+type testContext struct {
+	test *AlertTest
+	now  time.Time
+}
+
+func (tc *testContext) Query(r *opentsdb.Request) (opentsdb.ResponseSet, error) {
+	start, err := opentsdb.ParseTime(r.Start)
+	if err != nil {
+		return nil, err
+	}
+	end := tc.now
+	if r.End != nil {
+		if end, err = opentsdb.ParseTime(r.End); err != nil {
+			return nil, err
+		}
+	}
+	var rs opentsdb.ResponseSet
+	for _, q := range r.Queries {
+		resps, err := tc.query(q, start, end)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, resps...)
+	}
+	return rs, nil
+}
+
+func (tc *testContext) query(q *opentsdb.Query, start, end time.Time) (opentsdb.ResponseSet, error) {
+	var agg func([]float64) float64
+	switch q.Aggregator {
+	case "sum", "zimsum":
+		agg = func(vs []float64) (s float64) {
+			for _, v := range vs {
+				s += v
+			}
+			return
+		}
+	case "min", "mimmin":
+		agg = func(vs []float64) float64 {
+			m := math.Inf(1)
+			for _, v := range vs {
+				m = math.Min(m, v)
+			}
+			return m
+		}
+	case "max", "mimmax":
+		agg = func(vs []float64) float64 {
+			m := math.Inf(-1)
+			for _, v := range vs {
+				m = math.Max(m, v)
+			}
+			return m
+		}
+	case "avg", "":
+		agg = func(vs []float64) (s float64) {
+			for _, v := range vs {
+				s += v
+			}
+			return s / float64(len(vs))
+		}
+	default:
+		return nil, fmt.Errorf("test: unsupported aggregator %s", q.Aggregator)
+	}
+	groups := make(map[string]*opentsdb.Response)
+	points := make(map[string]map[int64][]float64)
+	for _, s := range tc.test.Series {
+		if s.Metric != q.Metric || !matchTestTags(q.Tags, s.Tags) {
+			continue
+		}
+		group := make(opentsdb.TagSet)
+		for k := range q.Tags {
+			group[k] = s.Tags[k]
+		}
+		key := group.String()
+		if groups[key] == nil {
+			groups[key] = &opentsdb.Response{
+				Metric:        q.Metric,
+				Tags:          group,
+				AggregateTags: []string{},
+				DPS:           make(map[string]opentsdb.Point),
+			}
+			points[key] = make(map[int64][]float64)
+		}
+		last := len(s.Values) - 1
+		for i, v := range s.Values {
+			ts := tc.now.Add(-time.Duration(last-i) * tc.test.Step)
+			if q.Rate {
+				if i == 0 {
+					continue
+				}
+				d := v - s.Values[i-1]
+				if d < 0 && q.RateOptions.Counter {
+					continue
+				}
+				v = d / tc.test.Step.Seconds()
+			}
+			// Requests have second precision.
+			t := ts.Unix()
+			if t < start.Unix() || t > end.Unix() {
+				continue
+			}
+			points[key][t] = append(points[key][t], v)
+		}
+	}
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var rs opentsdb.ResponseSet
+	for _, k := range keys {
+		resp := groups[k]
+		for ts, vs := range points[k] {
+			resp.DPS[strconv.FormatInt(ts, 10)] = opentsdb.Point(agg(vs))
+		}
+		rs = append(rs, resp)
+	}
+	return rs, nil
+}
+
+// matchTestTags returns true if tags match every filter in query, which are
+// * or |-separated literal values.
+func matchTestTags(query, tags opentsdb.TagSet) bool {
+	for k, filter := range query {
+		v, ok := tags[k]
+		if !ok {
+			return false
+		}
+		if filter == "*" {
+			continue
+		}
+		match := false
+		for _, f := range strings.Split(filter, "|") {
+			if strings.TrimSpace(f) == v {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
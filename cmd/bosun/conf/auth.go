@@ -0,0 +1,113 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Authentication types of authType.
+const (
+	AuthHeader = "header"
+	AuthLDAP   = "ldap"
+)
+
+// Role is what a user of the API may do. Each role may also do everything
+// the roles below it may.
+type Role int
+
+const (
+	// RoleNone may not use the API.
+	RoleNone Role = iota
+	// RoleViewer may read.
+	RoleViewer
+	// RoleOperator may also act on alerts: acknowledge, close, silence,
+	// and the like.
+	RoleOperator
+	// RoleAdmin may also change how bosun runs, see its configuration,
+	// and clear errors.
+	RoleAdmin
+)
+
+var roleNames = []string{"none", "viewer", "operator", "admin"}
+
+func (r Role) String() string {
+	if r < 0 || int(r) >= len(roleNames) {
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+	return roleNames[r]
+}
+
+func (r Role) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	for i, name := range roleNames {
+		if s == name {
+			return Role(i), nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %s, expected none, viewer, operator, or admin", s)
+}
+
+// UserRole returns the role of user.
+func (c *Conf) UserRole(user string) Role {
+	for _, u := range c.AuthAdmins {
+		if u == user {
+			return RoleAdmin
+		}
+	}
+	for _, u := range c.AuthOperators {
+		if u == user {
+			return RoleOperator
+		}
+	}
+	return c.AuthDefaultRole
+}
+
+// parseUsers parses a comma-separated list of user names.
+func parseUsers(v string) []string {
+	var users []string
+	for _, u := range strings.Split(v, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// checkAuth checks that the authentication settings are complete.
+func (c *Conf) checkAuth() {
+	switch c.AuthType {
+	case "":
+		if len(c.AuthAdmins) > 0 || len(c.AuthOperators) > 0 {
+			c.errorf("authAdmins and authOperators require authType")
+		}
+		return
+	case AuthHeader:
+		if c.AuthHeader == "" {
+			c.errorf("authType header requires authHeader")
+		}
+	case AuthLDAP:
+		if c.LDAPURL == "" || c.LDAPBindDN == "" {
+			c.errorf("authType ldap requires ldapURL and ldapBindDN")
+		}
+	}
+	if c.HTTPAuth != "" {
+		c.errorf("httpAuth cannot be used with authType")
+	}
+}
+
+// parseLDAPURL checks that v is an ldap:// or ldaps:// URL of a server.
+func parseLDAPURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("ldapURL must be an ldap:// or ldaps:// URL")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package conf
+
+import (
+	"strconv"
+	"strings"
+
+	"bosun.org/opentsdb"
+)
+
+// CardinalityLimits are the most distinct values of a tag key of a metric
+// the search index takes, and that a query's tag wildcards may expand to.
+// 0 is unlimited.
+type CardinalityLimits struct {
+	Default int
+	// TagKeys are limits of a tag key of all metrics.
+	TagKeys map[string]int
+	// Metrics are limits by metric, then tag key.
+	Metrics map[string]map[string]int
+}
+
+// Limit returns the limit of tagk of metric: the most specific of a limit of
+// the metric's tag key, of the tag key, or the default.
+func (l *CardinalityLimits) Limit(metric, tagk string) int {
+	if n, ok := l.Metrics[metric][tagk]; ok {
+		return n
+	}
+	if n, ok := l.TagKeys[tagk]; ok {
+		return n
+	}
+	return l.Default
+}
+
+// loadCardinalityLimit parses a cardinalityLimit value: a limit, "tagk
+// limit", or "metric tagk limit".
+func (c *Conf) loadCardinalityLimit(v string) {
+	f := strings.Fields(v)
+	if len(f) == 0 || len(f) > 3 {
+		c.errorf("cardinalityLimit must be limit, tagk limit, or metric tagk limit")
+	}
+	n, err := strconv.Atoi(f[len(f)-1])
+	if err != nil {
+		c.error(err)
+	}
+	if n < 0 {
+		c.errorf("cardinalityLimit must not be negative")
+	}
+	l := &c.CardinalityLimits
+	switch len(f) {
+	case 1:
+		l.Default = n
+	case 2:
+		if !opentsdb.ValidTag(f[0]) {
+			c.errorf("invalid tag key %s", f[0])
+		}
+		if l.TagKeys == nil {
+			l.TagKeys = make(map[string]int)
+		}
+		l.TagKeys[f[0]] = n
+	case 3:
+		if !opentsdb.ValidTag(f[0]) || !opentsdb.ValidTag(f[1]) {
+			c.errorf("invalid metric or tag key %s %s", f[0], f[1])
+		}
+		if l.Metrics == nil {
+			l.Metrics = make(map[string]map[string]int)
+		}
+		if l.Metrics[f[0]] == nil {
+			l.Metrics[f[0]] = make(map[string]int)
+		}
+		l.Metrics[f[0]][f[1]] = n
+	}
+}
@@ -12,16 +12,19 @@ import (
 	"os"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	ttemplate "text/template"
 	"time"
 
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
 	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
+	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf/parse"
-	"bosun.org/cmd/bosun/expr"
-	eparse "bosun.org/cmd/bosun/expr/parse"
+	"bosun.org/expr"
+	eparse "bosun.org/expr/parse"
 	"bosun.org/graphite"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
@@ -32,6 +35,7 @@ type Conf struct {
 	Name             string        // Config file name
 	CheckFrequency   time.Duration // Time between alert checks: 5m
 	DefaultRunEvery  int           // Default number of check intervals to run each alert: 1
+	DefaultMaxKeys   int           // Default maximum alert keys each alert may open per check, 0 for no limit
 	HTTPListen       string        // Web server listen address: :80
 	Hostname         string
 	RelayListen      string // OpenTSDB relay listen address: :4242
@@ -39,14 +43,22 @@ type Conf struct {
 	SMTPUsername     string // SMTP username
 	SMTPPassword     string // SMTP password
 	Ping             bool
-	PingDuration     time.Duration // Duration from now to stop pinging hosts based on time since the host tag was touched
+	PingDuration     time.Duration   // Duration from now to stop pinging hosts based on time since the host tag was touched
+	PingMetric       string          // Metric prefix of ping results: ping
+	PingTags         opentsdb.TagSet // Tags added to ping results: env=prod
+	PingCount        int             // Echo requests sent to each host per ping cycle
+	PingInterval     time.Duration   // Time between ping cycles
+	PingConcurrency  int             // Most hosts pinged at once
+	PingIPv6         bool            // Ping hosts without an IPv4 address over IPv6
+	PingDisableMeta  string          // Host tag metadata that disables pinging when true: noping
 	EmailFrom        string
 	StateFile        string
 	LedisDir         string
-	RedisHost        string
+	RedisHosts       []string
 	TimeAndDate      []int // timeanddate.com cities list
 	ResponseLimit    int64
 	SearchSince      opentsdb.Duration
+	SearchRetention  opentsdb.Duration // Time to keep unseen metrics and tags in the search index
 	UnknownTemplate  *Template
 	UnknownThreshold int
 	Templates        map[string]*Template
@@ -60,12 +72,106 @@ type Conf struct {
 	NoSleep          bool
 	ShortURLKey      string
 	MinGroupSize     int
+	CriticalExport   string          // File path or URL to export critical unacknowledged alerts to
+	ReasonCodes      []string        // Reasons that may be given when closing or forgetting alerts
+	CollectTags      opentsdb.TagSet // Tags added to all of bosun's own metrics: env=prod
+	StateChangeHook  string          // URL each alert status change is POSTed to as JSON
 
-	TSDBHost             string                    // OpenTSDB relay and query destination: ny-devtsdb04:4242
-	GraphiteHost         string                    // Graphite query host: foo.bar.baz
-	GraphiteHeaders      []string                  // extra http headers when querying graphite.
-	LogstashElasticHosts expr.LogstashElasticHosts // CSV Elastic Hosts (All part of the same cluster) that stores logstash documents, i.e http://ny-elastic01:9200
-	InfluxConfig         client.Config
+	// DryRun, if set, records notifications in the notification log
+	// instead of sending them.
+	DryRun bool
+
+	// UnknownDigestTemplate, if set, sends all unknown alerts pending for a
+	// notification as a single digest.
+	UnknownDigestTemplate *Template
+	UnknownBatchWindow    time.Duration // Time to collect unknown alerts before sending: 2 * checkFrequency
+	UnknownBatchSize      int           // Number of pending unknown alerts that sends a batch early; 0 for no limit
+
+	// UnknownGroupTemplate, if set, is used instead of UnknownTemplate for
+	// groups of more than one unknown alert.
+	UnknownGroupTemplate *Template
+
+	HTTPTLSCert   string // TLS certificate file for httpListen
+	HTTPTLSKey    string // TLS key file for httpListen
+	HTTPAuth      string // Basic auth credentials for httpListen: user:password
+	PublicListen  string // Read-only web server listen address: :8080
+	PublicTLSCert string // TLS certificate file for publicListen
+	PublicTLSKey  string // TLS key file for publicListen
+	PublicAuth    string // Basic auth credentials for publicListen: user:password
+
+	// AuthType, if set, authenticates users of httpListen and limits what
+	// they may do by their role: AuthHeader trusts the user name a reverse
+	// proxy puts in the AuthHeader request header, and AuthLDAP checks basic
+	// auth credentials with an LDAP simple bind to LDAPURL as the DN
+	// LDAPBindDN, in which %s is replaced by the user name.
+	AuthType   string
+	AuthHeader string
+	LDAPURL    string
+	LDAPBindDN string
+	// AuthAdmins and AuthOperators are the users with those roles. Other
+	// users have AuthDefaultRole.
+	AuthAdmins      []string
+	AuthOperators   []string
+	AuthDefaultRole Role
+
+	TSDBCacheTTL  time.Duration // How long OpenTSDB query results are shared between checks; 0 disables
+	TSDBCacheSize int64         // Maximum estimated size in bytes of cached OpenTSDB results
+	// TSDBCache caches OpenTSDB query results if TSDBCacheTTL is set.
+	TSDBCache *cache.TTL `json:"-"`
+
+	TemplateQueryLimit   int           // Maximum number of .Recent queries per template render: 5
+	TemplateQueryTimeout time.Duration // Maximum time for each .Recent query: 10s
+
+	// MetadataPutLimit is the number of metadata entries each source may put
+	// per minute. 0 is unlimited.
+	MetadataPutLimit int
+
+	// CardinalityLimits limit the values of each tag key of a metric that
+	// are indexed for search, and that queries may expand to.
+	CardinalityLimits CardinalityLimits
+
+	// ReadinessTimeout, if set, delays the first checks at startup until
+	// the configured datasources pass a health probe, or it elapses.
+	ReadinessTimeout time.Duration
+
+	// EventTTL is how long events pushed to /api/events are kept, and so the
+	// furthest back the events function can count: 7d.
+	EventTTL time.Duration
+
+	// AlertTests are the test sections, run by bosun -test-alerts.
+	AlertTests map[string]*AlertTest `json:"-"`
+
+	// SilenceExpiryNotification, if set, is sent SilenceExpiryWarning before
+	// a silence ends, listing the alerts it covers that are still abnormal.
+	SilenceExpiryNotification *Notification `json:"-"`
+	SilenceExpiryWarning      time.Duration // Time before a silence ends to send SilenceExpiryNotification: 15m
+
+	// HA enables active/standby high availability between instances sharing
+	// redisHost. Only the holder of the leader lease checks alerts and sends
+	// notifications; the others serve a read-only dashboard.
+	HA         bool
+	HAID       string        // Identifies this instance in the leader lease: hostname
+	HALeaseTTL time.Duration // Time the leader lease lasts without renewal: checkFrequency / 2
+
+	// CleanState removes stored states, pending notifications, and silences
+	// of alerts and notifications no longer defined when state is loaded.
+	CleanState bool
+
+	TSDBHost        string            // OpenTSDB relay and query destination: ny-devtsdb04:4242
+	TSDBAnnotations bool              // Write incident open and close markers to OpenTSDB's annotation API
+	GraphiteHost    string            // Graphite query host: foo.bar.baz
+	GraphiteHeaders []string          // extra http headers when querying graphite.
+	ElasticHosts    expr.ElasticHosts // Elastic clusters by name; logstashElasticHosts sets the default cluster
+	InfluxConfig    client.Config
+	SQLDatabases    expr.SQLDatabases       // SQL databases queried by the sql function, by name
+	CloudWatch      expr.CloudWatchAccounts // AWS accounts queried by the cloudwatch function, by name
+	Probes          map[string]*Probe       // Synthetic checks run alongside ping, by name
+	Anomalies       map[string]*Anomaly     // Series scored by the anomaly function, by name
+	Tickets         map[string]*Ticket      // Ticketing systems incidents open tickets in, by name
+
+	// OnCalls are the on-call schedules notifications resolve at send time,
+	// by name.
+	OnCalls map[string]*OnCall
 
 	tree            *parse.Tree
 	node            parse.Node
@@ -73,15 +179,44 @@ type Conf struct {
 	bodies          *htemplate.Template
 	subjects        *ttemplate.Template
 	squelch         []string
+
+	silenceExpiryNotification string
+}
+
+// DefaultReasonCodes are used when the reasonCodes setting is not specified.
+var DefaultReasonCodes = []string{"false positive", "known issue", "fixed", "duplicate", "expected maintenance"}
+
+// IsReasonCode returns true if r is one of the configured reason codes.
+func (c *Conf) IsReasonCode(r string) bool {
+	for _, code := range c.ReasonCodes {
+		if code == r {
+			return true
+		}
+	}
+	return false
 }
 
 // TSDBContext returns an OpenTSDB context limited to
-// c.ResponseLimit. A nil context is returned if TSDBHost is not set.
+// c.ResponseLimit, and cached in c.TSDBCache if it is set. A nil context is
+// returned if TSDBHost is not set.
 func (c *Conf) TSDBContext() opentsdb.Context {
+	return c.TSDBContextTimeout(0)
+}
+
+// TSDBContextTimeout is TSDBContext, but its queries fail after timeout
+// unless it is 0.
+func (c *Conf) TSDBContextTimeout(timeout time.Duration) opentsdb.Context {
 	if c.TSDBHost == "" {
 		return nil
 	}
-	return opentsdb.NewLimitContext(c.TSDBHost, c.ResponseLimit)
+	ctx := opentsdb.NewLimitContext(c.TSDBHost, c.ResponseLimit)
+	if timeout > 0 {
+		ctx.Client = &http.Client{Timeout: timeout}
+	}
+	if c.TSDBCache != nil {
+		return &cacheContext{ctx, c.TSDBHost, c.TSDBCache}
+	}
+	return ctx
 }
 
 // GraphiteContext returns a Graphite context. A nil context is returned if
@@ -164,6 +299,12 @@ func (c *Conf) at(node parse.Node) {
 	c.node = node
 }
 
+// loc returns the file and line of node n: dev.conf:12.
+func (c *Conf) loc(n parse.Node) string {
+	location, _ := c.tree.ErrorContext(n)
+	return location[:strings.LastIndex(location, ":")]
+}
+
 func (c *Conf) error(err error) {
 	c.errorf(err.Error())
 }
@@ -200,9 +341,21 @@ type Lookup struct {
 	Name    string
 	Tags    []string
 	Entries []*Entry
+	// Source is where the entries are read from while bosun runs, if set.
+	// The entries of the configuration are used until it is read, and when
+	// its key does not exist.
+	Source *KVSource `json:",omitempty"`
+
+	// defaults are the entries of the configuration, and notificationKeys
+	// the keys whose values are notifications.
+	defaults         []*Entry
+	notificationKeys map[string]bool
+	lock             sync.RWMutex
 }
 
 func (lookup *Lookup) ToExpr() *ExprLookup {
+	lookup.lock.RLock()
+	defer lookup.lock.RUnlock()
 	l := ExprLookup{
 		Tags: lookup.Tags,
 	}
@@ -229,24 +382,72 @@ type Alert struct {
 	Vars
 	*Template        `json:"-"`
 	Name             string
-	Crit             *expr.Expr `json:",omitempty"`
-	Warn             *expr.Expr `json:",omitempty"`
-	Depends          *expr.Expr `json:",omitempty"`
-	Squelch          Squelches  `json:"-"`
+	Namespace        string            `json:",omitempty"`
+	Crit             *expr.Expr        `json:",omitempty"`
+	Warn             *expr.Expr        `json:",omitempty"`
+	Depends          *expr.Expr        `json:",omitempty"`
+	Squelch          Squelches         `json:"-"`
+	Exclude          []opentsdb.TagSet `json:",omitempty"`
 	CritNotification *Notifications
 	WarnNotification *Notifications
-	Unknown          time.Duration
+	WarnSeverity     Severity
+	CritSeverity     Severity
+	Unknown          time.Duration // unknownAfter
 	MaxLogFrequency  time.Duration
 	IgnoreUnknown    bool
 	UnjoinedOK       bool `json:",omitempty"`
+	DryRun           bool `json:",omitempty"` // Record notifications instead of sending them
 	Log              bool
 	RunEvery         int
+	Interval         time.Duration `json:",omitempty"`
+	Jitter           time.Duration `json:",omitempty"`
 	returnType       eparse.FuncType
 
+	// RenotifyValue is the measured value of each alert key. If
+	// RenotifyWorsening is set, chained notifications are only sent when it
+	// has changed by more than that percentage since the last notification;
+	// a negative percentage means lower values are worse.
+	RenotifyValue     *expr.Expr `json:",omitempty"`
+	RenotifyWorsening float64    `json:",omitempty"`
+
+	// UnknownNotification, if set, is notified of unknown alert keys instead
+	// of CritNotification, so unknowns can go to a lower priority queue with
+	// its own escalation.
+	UnknownNotification *Notifications `json:",omitempty"`
+
+	// MaxKeys is the most alert keys that may open in one check. If more
+	// would, a single alert key for the whole alert opens instead. 0 is no
+	// limit.
+	MaxKeys int `json:",omitempty"`
+
+	// Warmup is how many intervals back the alert is replayed on its first
+	// check, if it has no alert keys yet, to seed their history.
+	Warmup int `json:",omitempty"`
+
+	// Loc is the file and line the alert is defined at: dev.conf:12.
+	Loc string `json:",omitempty"`
+
 	template string
 	squelch  []string
 }
 
+// UnknownNotifications returns the notifications of a's unknown alert keys.
+func (a *Alert) UnknownNotifications() *Notifications {
+	if a.UnknownNotification != nil {
+		return a.UnknownNotification
+	}
+	return a.CritNotification
+}
+
+// AlertInterval returns the time between runs of a, ignoring jitter. It is
+// the alert's interval if set, otherwise runEvery multiples of checkFrequency.
+func (c *Conf) AlertInterval(a *Alert) time.Duration {
+	if a.Interval != 0 {
+		return a.Interval
+	}
+	return c.CheckFrequency * time.Duration(a.RunEvery)
+}
+
 type Notifications struct {
 	Notifications map[string]*Notification `json:"-"`
 	// Table key -> table
@@ -277,6 +478,20 @@ func (ns *Notifications) Get(c *Conf, tags opentsdb.TagSet) map[string]*Notifica
 	return nots
 }
 
+// Chain returns the notifications of ns for tags and every notification that
+// follows them by next, each once.
+func (ns *Notifications) Chain(c *Conf, tags opentsdb.TagSet) []*Notification {
+	var chain []*Notification
+	seen := make(map[*Notification]bool)
+	for _, n := range ns.Get(c, tags) {
+		for ; n != nil && !seen[n]; n = n.Next {
+			seen[n] = true
+			chain = append(chain, n)
+		}
+	}
+	return chain
+}
+
 // parseNotifications parses the comma-separated string v for notifications and
 // returns them.
 func (c *Conf) parseNotifications(v string) (map[string]*Notification, error) {
@@ -305,20 +520,73 @@ type Template struct {
 type Notification struct {
 	Text string
 	Vars
-	Name         string
-	Email        []*mail.Address
-	Post, Get    *url.URL
-	Body         *ttemplate.Template
+	Name      string
+	Namespace string
+	Email     []*mail.Address
+	Post, Get *url.URL
+	Body      *ttemplate.Template
+	// BodyTemplate, if set, renders the post body from the notified alert
+	// key's state instead of from the subject.
+	BodyTemplate *ttemplate.Template
+	// Template, if set, overrides the subject or body of an alert's template
+	// when it is sent by this notification.
+	Template     *Template
 	Print        bool
 	Next         *Notification
 	Timeout      time.Duration
 	ContentType  string
 	RunOnActions bool
 
-	next      string
-	email     string
-	post, get string
-	body      string
+	// PagerDuty is the Events API v2 routing key of a PagerDuty service.
+	PagerDuty string
+
+	// MaxPerHour, if set, limits how many times the notification is sent in
+	// any hour. Notifications over the limit are dropped.
+	MaxPerHour int
+	// QuietHours are when the notification is not sent. Notifications are
+	// queued until they end, or dropped if QuietDrop is set.
+	QuietHours []*QuietHours
+	QuietDrop  bool
+
+	// MinSeverity, if set, is the lowest alert severity the notification is
+	// sent for.
+	MinSeverity Severity
+
+	// OnCall, if set, emails whoever is on duty in the on-call schedule
+	// when the notification is sent.
+	OnCall *OnCall
+
+	// Members, if set, make the notification a group that sends to all of
+	// them, or to one of them per incident if RoundRobin is set, instead of
+	// to destinations of its own.
+	Members    []*Notification
+	RoundRobin bool
+
+	// Retries is how many times a failed send is retried. The first retry
+	// is after RetryBackoff, and each one after waits twice as long as the
+	// one before, plus up to RetryJitter percent more at random.
+	Retries      int
+	RetryBackoff time.Duration
+	RetryJitter  float64
+	// SendTimeout, if set, limits how long each HTTP request or email send
+	// may take.
+	SendTimeout time.Duration
+
+	// CoalesceWindow, if set, holds the notification for up to that long so
+	// it is sent once for all alert keys notified in the window that have
+	// the same values of the CoalesceBy tag keys, rendered with
+	// CoalesceTemplate or a default digest.
+	CoalesceWindow   time.Duration
+	CoalesceBy       []string
+	CoalesceTemplate *Template
+
+	next         string
+	onCall       string
+	members      string
+	email        string
+	post, get    string
+	body         string
+	bodyTemplate string
 }
 
 func (n *Notification) MarshalJSON() ([]byte, error) {
@@ -342,13 +610,21 @@ func New(name, text string) (c *Conf, err error) {
 		CheckFrequency:   time.Minute * 5,
 		DefaultRunEvery:  1,
 		HTTPListen:       ":8070",
+		AuthDefaultRole:  RoleViewer,
 		StateFile:        "bosun.state",
 		LedisDir:         "ledis_data",
 		MinGroupSize:     5,
 		PingDuration:     time.Hour * 24,
+		PingMetric:       "ping",
+		PingCount:        1,
+		PingInterval:     time.Second * 15,
+		PingConcurrency:  100,
+		PingDisableMeta:  "noping",
+		EventTTL:         time.Hour * 24 * 7,
 		ResponseLimit:    1 << 20, // 1MB
 		SearchSince:      opentsdb.Day * 3,
 		UnknownThreshold: 5,
+		ReasonCodes:      DefaultReasonCodes,
 		Vars:             make(map[string]string),
 		Templates:        make(map[string]*Template),
 		Alerts:           make(map[string]*Alert),
@@ -357,7 +633,15 @@ func New(name, text string) (c *Conf, err error) {
 		bodies:           htemplate.New(name).Funcs(htemplate.FuncMap(defaultFuncs)),
 		subjects:         ttemplate.New(name).Funcs(defaultFuncs),
 		Lookups:          make(map[string]*Lookup),
+		ElasticHosts:     make(expr.ElasticHosts),
+		SQLDatabases:     make(expr.SQLDatabases),
+		CloudWatch:       make(expr.CloudWatchAccounts),
+		Probes:           make(map[string]*Probe),
+		Anomalies:        make(map[string]*Anomaly),
+		Tickets:          make(map[string]*Ticket),
+		OnCalls:          make(map[string]*OnCall),
 		Macros:           make(map[string]*Macro),
+		AlertTests:       make(map[string]*AlertTest),
 	}
 	c.tree, err = parse.Parse(name, text)
 	if err != nil {
@@ -376,6 +660,55 @@ func New(name, text string) (c *Conf, err error) {
 			c.errorf("unexpected parse node %s", n)
 		}
 	}
+	if (c.HTTPTLSCert == "") != (c.HTTPTLSKey == "") {
+		c.at(nil)
+		c.errorf("httpTLSCert and httpTLSKey must be specified together")
+	}
+	if (c.PublicTLSCert == "") != (c.PublicTLSKey == "") {
+		c.at(nil)
+		c.errorf("publicTLSCert and publicTLSKey must be specified together")
+	}
+	c.at(nil)
+	c.checkAuth()
+	if c.silenceExpiryNotification != "" {
+		n, ok := c.Notifications[c.silenceExpiryNotification]
+		if !ok {
+			c.at(nil)
+			c.errorf("silenceExpiryNotification: unknown notification %s", c.silenceExpiryNotification)
+		}
+		c.SilenceExpiryNotification = n
+	}
+	if c.SilenceExpiryWarning == 0 {
+		c.SilenceExpiryWarning = defaultSilenceExpiryWarning
+	}
+	if c.TSDBAnnotations && c.TSDBHost == "" {
+		c.at(nil)
+		c.errorf("tsdbAnnotations requires tsdbHost")
+	}
+	if c.HA {
+		c.at(nil)
+		if len(c.RedisHosts) == 0 {
+			c.errorf("ha requires redisHost")
+		}
+		if c.HALeaseTTL == 0 {
+			c.HALeaseTTL = c.CheckFrequency / 2
+		}
+		if c.HALeaseTTL < time.Second {
+			c.HALeaseTTL = time.Second
+		}
+	}
+	if c.TemplateQueryLimit == 0 {
+		c.TemplateQueryLimit = defaultTemplateQueryLimit
+	}
+	if c.TemplateQueryTimeout == 0 {
+		c.TemplateQueryTimeout = defaultTemplateQueryTimeout
+	}
+	if c.TSDBCacheTTL > 0 {
+		if c.TSDBCacheSize == 0 {
+			c.TSDBCacheSize = defaultTSDBCacheSize
+		}
+		c.TSDBCache = cache.NewTTL("tsdb", c.TSDBCacheTTL, c.TSDBCacheSize)
+	}
 	if c.Hostname == "" {
 		c.Hostname = c.HTTPListen
 		if strings.HasPrefix(c.Hostname, ":") {
@@ -387,9 +720,62 @@ func New(name, text string) (c *Conf, err error) {
 			c.Hostname = h + c.Hostname
 		}
 	}
+	if c.HA && c.HAID == "" {
+		c.HAID = c.Hostname
+	}
 	return
 }
 
+// defaultTemplateQueryLimit and defaultTemplateQueryTimeout bound template
+// queries when templateQueryLimit and templateQueryTimeout are not specified.
+const (
+	defaultTemplateQueryLimit   = 5
+	defaultTemplateQueryTimeout = time.Second * 10
+)
+
+// defaultSilenceExpiryWarning is the SilenceExpiryWarning when it is not
+// specified.
+const defaultSilenceExpiryWarning = time.Minute * 15
+
+// defaultRedisPort is used for redis hosts without a port.
+const defaultRedisPort = "6379"
+
+// maxPingCount bounds pingCount so all echo requests to a host fit in one
+// ping cycle.
+const maxPingCount = 10
+
+// parseRedisHosts parses a comma-separated list of redis hosts. Each host is
+// host:port, a hostname or IP address (IPv6 optionally in brackets) using the
+// default port, or srv:name for a DNS SRV record.
+func parseRedisHosts(s string) ([]string, error) {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		h = strings.TrimSpace(h)
+		switch {
+		case h == "":
+			return nil, fmt.Errorf("empty redis host in %q", s)
+		case strings.HasPrefix(h, "srv:"):
+			if strings.TrimPrefix(h, "srv:") == "" {
+				return nil, fmt.Errorf("missing SRV record name in redis host %q", h)
+			}
+		case net.ParseIP(strings.Trim(h, "[]")) != nil:
+			h = net.JoinHostPort(strings.Trim(h, "[]"), defaultRedisPort)
+		case !strings.Contains(h, ":"):
+			h = net.JoinHostPort(h, defaultRedisPort)
+		default:
+			host, port, err := net.SplitHostPort(h)
+			if err != nil {
+				return nil, fmt.Errorf("bad redis host %q: %v", h, err)
+			}
+			if host == "" || port == "" {
+				return nil, fmt.Errorf("bad redis host %q", h)
+			}
+		}
+		hosts = append(hosts, h)
+	}
+	return hosts, nil
+}
+
 func (c *Conf) loadGlobal(p *parse.PairNode) {
 	v := c.Expand(p.Val.Text, nil, false)
 	switch k := p.Key.Text; k {
@@ -416,7 +802,10 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 		}
 		c.GraphiteHeaders = append(c.GraphiteHeaders, v)
 	case "logstashElasticHosts":
-		c.LogstashElasticHosts = strings.Split(v, ",")
+		if _, ok := c.ElasticHosts[expr.DefaultElasticCluster]; ok {
+			c.errorf("duplicate elastic cluster: %s", expr.DefaultElasticCluster)
+		}
+		c.ElasticHosts[expr.DefaultElasticCluster] = expr.NewElasticCluster(strings.Split(v, ","))
 	case "influxHost":
 		c.InfluxConfig.URL.Host = v
 		c.InfluxConfig.UserAgent = "bosun"
@@ -449,6 +838,53 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 		c.Hostname = v
 	case "relayListen":
 		c.RelayListen = v
+	case "httpTLSCert":
+		c.HTTPTLSCert = v
+	case "httpTLSKey":
+		c.HTTPTLSKey = v
+	case "httpAuth":
+		if !strings.Contains(v, ":") {
+			c.errorf("httpAuth must be user:password")
+		}
+		c.HTTPAuth = v
+	case "authType":
+		if v != AuthHeader && v != AuthLDAP {
+			c.errorf("authType must be header or ldap")
+		}
+		c.AuthType = v
+	case "authHeader":
+		c.AuthHeader = http.CanonicalHeaderKey(v)
+	case "ldapURL":
+		if err := parseLDAPURL(v); err != nil {
+			c.error(err)
+		}
+		c.LDAPURL = v
+	case "ldapBindDN":
+		if strings.Count(v, "%s") != 1 {
+			c.errorf("ldapBindDN must contain %%s once, for the user name")
+		}
+		c.LDAPBindDN = v
+	case "authAdmins":
+		c.AuthAdmins = parseUsers(v)
+	case "authOperators":
+		c.AuthOperators = parseUsers(v)
+	case "authDefaultRole":
+		r, err := ParseRole(v)
+		if err != nil {
+			c.error(err)
+		}
+		c.AuthDefaultRole = r
+	case "publicListen":
+		c.PublicListen = v
+	case "publicTLSCert":
+		c.PublicTLSCert = v
+	case "publicTLSKey":
+		c.PublicTLSKey = v
+	case "publicAuth":
+		if !strings.Contains(v, ":") {
+			c.errorf("publicAuth must be user:password")
+		}
+		c.PublicAuth = v
 	case "smtpHost":
 		c.SMTPHost = v
 	case "smtpUsername":
@@ -467,8 +903,64 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 			c.errorf(err.Error())
 		}
 		c.PingDuration = d
+	case "pingMetric":
+		if !opentsdb.ValidTag(v) || strings.Trim(v, ".") != v {
+			c.errorf("invalid pingMetric %s", v)
+		}
+		c.PingMetric = v
+	case "pingTags":
+		tags, err := opentsdb.ParseTags(v)
+		if err != nil {
+			c.error(err)
+		}
+		for k, tv := range tags {
+			if !opentsdb.ValidTag(tv) {
+				c.errorf("invalid ping tag value %s=%s", k, tv)
+			}
+			if k == "dst_host" {
+				c.errorf("pingTags may not set dst_host")
+			}
+		}
+		c.PingTags = tags
+	case "pingCount":
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if i < 1 || i > maxPingCount {
+			c.errorf("pingCount must be between 1 and %d", maxPingCount)
+		}
+		c.PingCount = i
+	case "pingInterval":
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		if d < time.Second {
+			c.errorf("pingInterval must be at least 1s")
+		}
+		c.PingInterval = d
+	case "pingConcurrency":
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if i < 1 {
+			c.errorf("pingConcurrency must be at least 1")
+		}
+		c.PingConcurrency = i
+	case "pingIPv6":
+		c.PingIPv6 = true
+	case "pingDisableMeta":
+		c.PingDisableMeta = v
 	case "noSleep":
 		c.NoSleep = true
+	case "cleanState":
+		c.CleanState = true
+	case "tsdbAnnotations":
+		c.TSDBAnnotations = true
+	case "dryRun":
+		c.DryRun = true
 	case "unknownThreshold":
 		i, err := strconv.Atoi(v)
 		if err != nil {
@@ -504,12 +996,38 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 		if c.DefaultRunEvery <= 0 {
 			c.errorf("defaultRunEvery must be > 0")
 		}
+	case "defaultMaxKeys":
+		var err error
+		c.DefaultMaxKeys, err = strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if c.DefaultMaxKeys < 0 {
+			c.errorf("defaultMaxKeys must not be negative")
+		}
 	case "searchSince":
 		s, err := opentsdb.ParseDuration(v)
 		if err != nil {
 			c.error(err)
 		}
 		c.SearchSince = s
+	case "searchRetention":
+		d, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		if d < opentsdb.Day {
+			c.errorf("searchRetention must be at least 1d")
+		}
+		c.SearchRetention = d
+	case "readinessTimeout":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		c.ReadinessTimeout = time.Duration(od)
+	case "cardinalityLimit":
+		c.loadCardinalityLimit(v)
 	case "unknownTemplate":
 		c.unknownTemplate = v
 		t, ok := c.Templates[c.unknownTemplate]
@@ -517,6 +1035,94 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 			c.errorf("template not found: %s", c.unknownTemplate)
 		}
 		c.UnknownTemplate = t
+	case "unknownDigestTemplate":
+		t, ok := c.Templates[v]
+		if !ok {
+			c.errorf("template not found: %s", v)
+		}
+		c.UnknownDigestTemplate = t
+	case "unknownGroupTemplate":
+		t, ok := c.Templates[v]
+		if !ok {
+			c.errorf("template not found: %s", v)
+		}
+		c.UnknownGroupTemplate = t
+	case "unknownBatchWindow":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		d := time.Duration(od)
+		if d < time.Second {
+			c.errorf("unknownBatchWindow must be at least 1s")
+		}
+		c.UnknownBatchWindow = d
+	case "templateQueryLimit":
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if i < 1 {
+			c.errorf("templateQueryLimit must be at least 1")
+		}
+		c.TemplateQueryLimit = i
+	case "metadataPutLimit":
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if i < 0 {
+			c.errorf("metadataPutLimit must not be negative")
+		}
+		c.MetadataPutLimit = i
+	case "eventTTL":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		d := time.Duration(od)
+		if d <= 0 {
+			c.errorf("eventTTL must be positive")
+		}
+		c.EventTTL = d
+	case "templateQueryTimeout":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		d := time.Duration(od)
+		if d < time.Second {
+			c.errorf("templateQueryTimeout must be at least 1s")
+		}
+		c.TemplateQueryTimeout = d
+	case "tsdbCacheTTL":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		d := time.Duration(od)
+		if d < time.Second {
+			c.errorf("tsdbCacheTTL must be at least 1s")
+		}
+		c.TSDBCacheTTL = d
+	case "tsdbCacheSize":
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			c.error(err)
+		}
+		if i <= 0 {
+			c.errorf("tsdbCacheSize must be > 0")
+		}
+		c.TSDBCacheSize = i
+	case "unknownBatchSize":
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			c.error(err)
+		}
+		if i < 0 {
+			c.errorf("unknownBatchSize must be >= 0")
+		}
+		c.UnknownBatchSize = i
 	case "squelch":
 		c.squelch = append(c.squelch, v)
 		if err := c.Squelch.Add(v); err != nil {
@@ -527,7 +1133,67 @@ func (c *Conf) loadGlobal(p *parse.PairNode) {
 	case "ledisDir":
 		c.LedisDir = v
 	case "redisHost":
-		c.RedisHost = v
+		hosts, err := parseRedisHosts(v)
+		if err != nil {
+			c.error(err)
+		}
+		c.RedisHosts = hosts
+	case "criticalExport":
+		c.CriticalExport = v
+	case "stateChangeHook":
+		u, err := url.Parse(v)
+		if err != nil {
+			c.error(err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			c.errorf("stateChangeHook must be an http or https URL")
+		}
+		c.StateChangeHook = v
+	case "ha":
+		c.HA = true
+	case "haID":
+		c.HAID = v
+	case "haLeaseTTL":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		c.HALeaseTTL = time.Duration(od)
+		if c.HALeaseTTL < time.Second {
+			c.errorf("haLeaseTTL must be at least 1s")
+		}
+	case "silenceExpiryNotification":
+		c.silenceExpiryNotification = v
+	case "silenceExpiryWarning":
+		od, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		d := time.Duration(od)
+		if d < time.Minute {
+			c.errorf("silenceExpiryWarning must be at least 1m")
+		}
+		c.SilenceExpiryWarning = d
+	case "collectTags":
+		tags, err := opentsdb.ParseTags(v)
+		if err != nil {
+			c.error(err)
+		}
+		for k, tv := range tags {
+			if !opentsdb.ValidTag(tv) {
+				c.errorf("invalid collect tag value %s=%s", k, tv)
+			}
+		}
+		c.CollectTags = tags
+	case "reasonCodes":
+		c.ReasonCodes = nil
+		for _, r := range strings.Split(v, ",") {
+			r = strings.TrimSpace(r)
+			if r == "" {
+				c.errorf("empty reason code")
+			}
+			c.ReasonCodes = append(c.ReasonCodes, r)
+		}
 	case "minGroupSize":
 		i, err := strconv.Atoi(v)
 		if err != nil {
@@ -555,6 +1221,22 @@ func (c *Conf) loadSection(s *parse.SectionNode) {
 		c.loadMacro(s)
 	case "lookup":
 		c.loadLookup(s)
+	case "elastic":
+		c.loadElastic(s)
+	case "sql":
+		c.loadSQL(s)
+	case "cloudwatch":
+		c.loadCloudWatch(s)
+	case "probe":
+		c.loadProbe(s)
+	case "anomaly":
+		c.loadAnomaly(s)
+	case "ticket":
+		c.loadTicket(s)
+	case "oncall":
+		c.loadOnCall(s)
+	case "test":
+		c.loadTest(s)
 	default:
 		c.errorf("unknown section type: %s", s.SectionType.Text)
 	}
@@ -649,49 +1331,481 @@ func (c *Conf) loadLookup(s *parse.SectionNode) {
 			if tags == nil && err != nil {
 				c.error(err)
 			}
-			if _, ok := saw[tags.String()]; ok {
-				c.errorf("duplicate entry")
-			}
-			saw[tags.String()] = true
-			if len(tags) == 0 {
-				c.errorf("lookup entries require tags")
+			if _, ok := saw[tags.String()]; ok {
+				c.errorf("duplicate entry")
+			}
+			saw[tags.String()] = true
+			if len(tags) == 0 {
+				c.errorf("lookup entries require tags")
+			}
+			empty := make(opentsdb.TagSet)
+			for k := range tags {
+				empty[k] = ""
+			}
+			if len(lookupTags) == 0 {
+				lookupTags = empty
+				for k := range empty {
+					l.Tags = append(l.Tags, k)
+				}
+			} else if !lookupTags.Equal(empty) {
+				c.errorf("lookup tags mismatch, expected %v", lookupTags)
+			}
+			e := Entry{
+				Def:  n.RawText,
+				Name: n.Name.Text,
+				ExprEntry: &ExprEntry{
+					AlertKey: expr.NewAlertKey("", tags),
+					Values:   make(map[string]string),
+				},
+			}
+			for _, en := range n.Nodes.Nodes {
+				c.at(en)
+				switch en := en.(type) {
+				case *parse.PairNode:
+					e.Values[en.Key.Text] = en.Val.Text
+				default:
+					c.errorf("unexpected node")
+				}
+			}
+			l.Entries = append(l.Entries, &e)
+		case *parse.PairNode:
+			if n.Key.Text != "source" {
+				c.errorf("unknown key %s", n.Key.Text)
+			}
+			if l.Source != nil {
+				c.errorf("duplicate source")
+			}
+			src, err := ParseKVSource(n.Val.Text)
+			if err != nil {
+				c.error(err)
+			}
+			l.Source = src
+		default:
+			c.errorf("unexpected node")
+		}
+	}
+	c.at(s)
+	if l.Source != nil && len(l.Entries) == 0 {
+		c.errorf("lookups with a source require entries, used until it is read")
+	}
+	l.defaults = l.Entries
+	c.Lookups[name] = &l
+}
+
+// SetLookupEntries replaces the entries of l with those of text, the body of
+// a lookup section, as read from its source. If text is empty, the entries of
+// the configuration are restored. The entries must have the same tags as
+// those of the configuration, since expressions were checked against them.
+func (c *Conf) SetLookupEntries(l *Lookup, text string) error {
+	entries := l.defaults
+	if strings.TrimSpace(text) != "" {
+		nc, err := New(l.Name, fmt.Sprintf("lookup %s {\n%s\n}\n", l.Name, text))
+		if err != nil {
+			return err
+		}
+		nl := nc.Lookups[l.Name]
+		if nl.Source != nil {
+			return fmt.Errorf("lookup %s: source may not set a source", l.Name)
+		}
+		if len(nl.Entries) == 0 {
+			return fmt.Errorf("lookup %s: no entries", l.Name)
+		}
+		tags := make(map[string]bool)
+		for _, t := range l.Tags {
+			tags[t] = true
+		}
+		for _, t := range nl.Tags {
+			if !tags[t] {
+				return fmt.Errorf("lookup %s: tags mismatch, expected %v", l.Name, l.Tags)
+			}
+		}
+		if len(nl.Tags) != len(l.Tags) {
+			return fmt.Errorf("lookup %s: tags mismatch, expected %v", l.Name, l.Tags)
+		}
+		for _, e := range nl.Entries {
+			for k, v := range e.Values {
+				if !l.notificationKeys[k] {
+					continue
+				}
+				if _, err := c.parseNotifications(v); err != nil {
+					return fmt.Errorf("lookup %s: %v", l.Name, err)
+				}
+			}
+		}
+		entries = nl.Entries
+	}
+	l.lock.Lock()
+	l.Entries = entries
+	l.lock.Unlock()
+	return nil
+}
+
+func (c *Conf) loadElastic(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.ElasticHosts[name]; ok {
+		c.errorf("duplicate elastic cluster: %s", name)
+	}
+	e := expr.NewElasticCluster(nil)
+	for _, p := range c.getPairs(s, nil, sNormal) {
+		c.at(p.node)
+		v := p.val
+		switch p.key {
+		case "hosts":
+			e.Hosts = strings.Split(v, ",")
+		case "indexSeparator":
+			e.IndexSeparator = v
+		case "dateFormat":
+			// The layout must round trip to be used to parse index names.
+			if _, err := time.Parse(v, time.Now().Format(v)); err != nil || v == "" {
+				c.errorf("bad dateFormat %q, expected a Go time layout like 2006.01.02", v)
+			}
+			e.DateFormat = v
+		case "timeField":
+			e.TimeField = v
+		case "version":
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 {
+				c.errorf("bad elastic version %q, expected a major version like 5", v)
+			}
+			e.Version = i
+		default:
+			c.errorf("unknown key %s", p.key)
+		}
+	}
+	c.at(s)
+	if len(e.Hosts) == 0 {
+		c.errorf("elastic cluster requires hosts")
+	}
+	c.ElasticHosts[name] = e
+}
+
+func (c *Conf) loadSQL(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.SQLDatabases[name]; ok {
+		c.errorf("duplicate sql database: %s", name)
+	}
+	d := &expr.SQLDatabase{Timeout: expr.DefaultSQLTimeout}
+	for _, p := range c.getPairs(s, nil, sNormal) {
+		c.at(p.node)
+		v := p.val
+		switch p.key {
+		case "driver":
+			d.Driver = v
+		case "dsn":
+			d.DSN = v
+		case "timeout":
+			od, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if od <= 0 {
+				c.errorf("timeout must be positive")
+			}
+			d.Timeout = time.Duration(od)
+		default:
+			c.errorf("unknown key %s", p.key)
+		}
+	}
+	c.at(s)
+	if d.Driver == "" || d.DSN == "" {
+		c.errorf("sql database requires driver and dsn")
+	}
+	c.SQLDatabases[name] = d
+}
+
+func (c *Conf) loadCloudWatch(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.CloudWatch[name]; ok {
+		c.errorf("duplicate cloudwatch account: %s", name)
+	}
+	a := &expr.CloudWatchAccount{}
+	for _, p := range c.getPairs(s, nil, sNormal) {
+		c.at(p.node)
+		v := p.val
+		switch p.key {
+		case "region":
+			a.Region = v
+		case "accessKey":
+			a.AccessKey = v
+		case "secretKey":
+			a.SecretKey = v
+		default:
+			c.errorf("unknown key %s", p.key)
+		}
+	}
+	c.at(s)
+	if a.Region == "" {
+		c.errorf("cloudwatch account requires region")
+	}
+	if (a.AccessKey == "") != (a.SecretKey == "") {
+		c.errorf("cloudwatch account requires both or neither of accessKey and secretKey")
+	}
+	c.CloudWatch[name] = a
+}
+
+// Probe types.
+const (
+	ProbeTCP  = "tcp"
+	ProbeTLS  = "tls"
+	ProbeHTTP = "http"
+	ProbeDNS  = "dns"
+)
+
+// Default probe settings.
+const (
+	DefaultProbeInterval = time.Minute
+	DefaultProbeTimeout  = 10 * time.Second
+)
+
+// Probe is a synthetic check of a network service, run every Interval. Its
+// results are recorded as bosun.probe.<type>.* metrics tagged with the probe
+// name and Tags.
+type Probe struct {
+	Name     string
+	Type     string // tcp, tls, http, or dns
+	Target   string // host:port, a URL for http, or a name for dns
+	Interval time.Duration
+	Timeout  time.Duration
+	Tags     opentsdb.TagSet
+
+	// Servers are the host:port of the name servers a dns probe queries,
+	// or empty for the system resolver.
+	Servers []string `json:",omitempty"`
+	// Expect are the addresses a dns probe's target should resolve to.
+	Expect []string `json:",omitempty"`
+}
+
+func (c *Conf) loadOnCall(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.OnCalls[name]; ok {
+		c.errorf("duplicate oncall: %s", name)
+	}
+	o := &OnCall{
+		Name:    name,
+		Refresh: DefaultOnCallRefresh,
+	}
+	for _, pair := range c.getPairs(s, nil, sNormal) {
+		c.at(pair.node)
+		v := pair.val
+		switch pair.key {
+		case "members":
+			members, err := mail.ParseAddressList(v)
+			if err != nil {
+				c.error(err)
+			}
+			o.Members = members
+		case "start":
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				c.error(err)
+			}
+			o.Start = t
+		case "shift":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if d < opentsdb.Duration(time.Minute) {
+				c.errorf("shift must be at least 1m")
+			}
+			o.Shift = time.Duration(d)
+		case "calendar":
+			u, err := url.Parse(v)
+			if err != nil {
+				c.error(err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				c.errorf("calendar must be an http or https URL")
+			}
+			o.Calendar = v
+		case "refresh":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if d < opentsdb.Duration(time.Minute) {
+				c.errorf("refresh must be at least 1m")
+			}
+			o.Refresh = time.Duration(d)
+		default:
+			c.errorf("unknown key %s", pair.key)
+		}
+	}
+	c.at(s)
+	switch {
+	case o.Calendar != "" && len(o.Members) > 0:
+		c.errorf("oncall cannot have both members and calendar")
+	case o.Calendar == "" && (len(o.Members) == 0 || o.Start.IsZero() || o.Shift == 0):
+		c.errorf("oncall requires members, start, and shift, or calendar")
+	}
+	c.OnCalls[name] = o
+}
+
+func (c *Conf) loadProbe(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.Probes[name]; ok {
+		c.errorf("duplicate probe: %s", name)
+	}
+	if !opentsdb.ValidTag(name) {
+		c.errorf("invalid probe name %s", name)
+	}
+	p := &Probe{
+		Name:     name,
+		Interval: DefaultProbeInterval,
+		Timeout:  DefaultProbeTimeout,
+	}
+	duration := func(v string) time.Duration {
+		d, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			c.error(err)
+		}
+		if d < opentsdb.Duration(time.Second) {
+			c.errorf("duration must be at least 1s")
+		}
+		return time.Duration(d)
+	}
+	for _, pair := range c.getPairs(s, nil, sNormal) {
+		c.at(pair.node)
+		v := pair.val
+		switch pair.key {
+		case "type":
+			switch v {
+			case ProbeTCP, ProbeTLS, ProbeHTTP, ProbeDNS:
+				p.Type = v
+			default:
+				c.errorf("unknown probe type %s", v)
+			}
+		case "target":
+			p.Target = v
+		case "interval":
+			p.Interval = duration(v)
+		case "timeout":
+			p.Timeout = duration(v)
+		case "tags":
+			tags, err := opentsdb.ParseTags(v)
+			if err != nil {
+				c.error(err)
+			}
+			for k, tv := range tags {
+				if !opentsdb.ValidTag(tv) {
+					c.errorf("invalid probe tag value %s=%s", k, tv)
+				}
+				if k == "probe" {
+					c.errorf("probe tags may not set probe")
+				}
+			}
+			p.Tags = tags
+		case "servers":
+			for _, server := range strings.Split(v, ",") {
+				server = strings.TrimSpace(server)
+				if _, _, err := net.SplitHostPort(server); err != nil {
+					server = net.JoinHostPort(server, "53")
+				}
+				p.Servers = append(p.Servers, server)
+			}
+		case "expect":
+			for _, addr := range strings.Split(v, ",") {
+				ip := net.ParseIP(strings.TrimSpace(addr))
+				if ip == nil {
+					c.errorf("invalid expected address %s", addr)
+				}
+				p.Expect = append(p.Expect, ip.String())
+			}
+		default:
+			c.errorf("unknown key %s", pair.key)
+		}
+	}
+	c.at(s)
+	if p.Type == "" || p.Target == "" {
+		c.errorf("probe requires type and target")
+	}
+	if p.Timeout > p.Interval {
+		c.errorf("probe timeout must not be longer than its interval")
+	}
+	if p.Type != ProbeDNS && (p.Servers != nil || p.Expect != nil) {
+		c.errorf("servers and expect are only valid on dns probes")
+	}
+	switch p.Type {
+	case ProbeTCP, ProbeTLS:
+		if _, _, err := net.SplitHostPort(p.Target); err != nil {
+			c.errorf("%s probe target must be host:port: %v", p.Type, err)
+		}
+	case ProbeHTTP:
+		u, err := url.Parse(p.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.errorf("http probe target must be an http or https URL")
+		}
+	case ProbeDNS:
+		if strings.ContainsAny(p.Target, ":/ ") {
+			c.errorf("dns probe target must be a name")
+		}
+	}
+	c.Probes[name] = p
+}
+
+// DefaultAnomalyInterval is how often anomaly scores are computed by default.
+const DefaultAnomalyInterval = 5 * time.Minute
+
+// Anomaly is a scope of series that are scored every Interval by how far the
+// last value of each series Expr returns is from its other values. The scores
+// are read by the anomaly function, and recorded as the bosun.anomaly.score
+// metric tagged with the anomaly name and the series' tags.
+type Anomaly struct {
+	Name     string
+	Expr     *expr.Expr
+	Interval time.Duration
+}
+
+func (c *Conf) loadAnomaly(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.Anomalies[name]; ok {
+		c.errorf("duplicate anomaly: %s", name)
+	}
+	if !opentsdb.ValidTag(name) {
+		c.errorf("invalid anomaly name %s", name)
+	}
+	a := &Anomaly{
+		Name:     name,
+		Interval: DefaultAnomalyInterval,
+	}
+	for _, pair := range c.getPairs(s, nil, sNormal) {
+		c.at(pair.node)
+		v := pair.val
+		switch pair.key {
+		case "expression":
+			e, err := expr.New(v, c.Funcs())
+			if err != nil {
+				c.error(err)
+			}
+			if e.Root.Return() != eparse.TypeSeriesSet {
+				c.errorf("anomaly expression must return a series set")
 			}
-			empty := make(opentsdb.TagSet)
-			for k := range tags {
-				empty[k] = ""
+			tags, err := e.Root.Tags()
+			if err != nil {
+				c.error(err)
 			}
-			if len(lookupTags) == 0 {
-				lookupTags = empty
-				for k := range empty {
-					l.Tags = append(l.Tags, k)
-				}
-			} else if !lookupTags.Equal(empty) {
-				c.errorf("lookup tags mismatch, expected %v", lookupTags)
+			if _, ok := tags["anomaly"]; ok {
+				c.errorf("anomaly expression may not have an anomaly tag")
 			}
-			e := Entry{
-				Def:  n.RawText,
-				Name: n.Name.Text,
-				ExprEntry: &ExprEntry{
-					AlertKey: expr.NewAlertKey("", tags),
-					Values:   make(map[string]string),
-				},
+			a.Expr = e
+		case "interval":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
 			}
-			for _, en := range n.Nodes.Nodes {
-				c.at(en)
-				switch en := en.(type) {
-				case *parse.PairNode:
-					e.Values[en.Key.Text] = en.Val.Text
-				default:
-					c.errorf("unexpected node")
-				}
+			if d < opentsdb.Duration(time.Minute) {
+				c.errorf("interval must be at least 1m")
 			}
-			l.Entries = append(l.Entries, &e)
+			a.Interval = time.Duration(d)
 		default:
-			c.errorf("unexpected node")
+			c.errorf("unknown key %s", pair.key)
 		}
 	}
 	c.at(s)
-	c.Lookups[name] = &l
+	if a.Expr == nil {
+		c.errorf("anomaly requires expression")
+	}
+	c.Anomalies[name] = a
 }
 
 func (c *Conf) loadMacro(s *parse.SectionNode) {
@@ -738,6 +1852,16 @@ var defaultFuncs = ttemplate.FuncMap{
 	"parseDuration": time.ParseDuration,
 }
 
+// templateFuncs returns the functions alert templates are parsed with, with
+// V expanding variables from vars.
+func templateFuncs(vars Vars, c *Conf) ttemplate.FuncMap {
+	return ttemplate.FuncMap{
+		"V": func(v string) string {
+			return c.Expand(v, vars, false)
+		},
+	}
+}
+
 func (c *Conf) loadTemplate(s *parse.SectionNode) {
 	name := s.Name.Text
 	if _, ok := c.Templates[name]; ok {
@@ -748,11 +1872,7 @@ func (c *Conf) loadTemplate(s *parse.SectionNode) {
 		Name: name,
 	}
 	t.Text = s.RawText
-	funcs := ttemplate.FuncMap{
-		"V": func(v string) string {
-			return c.Expand(v, t.Vars, false)
-		},
-	}
+	funcs := templateFuncs(t.Vars, c)
 	saw := make(map[string]bool)
 	for _, p := range s.Nodes.Nodes {
 		c.at(p)
@@ -797,6 +1917,10 @@ func (c *Conf) loadTemplate(s *parse.SectionNode) {
 
 var lookupNotificationRE = regexp.MustCompile(`^lookup\("(.*)", "(.*)"\)$`)
 
+// maxWarmup bounds an alert's warmup, since each interval replayed is a
+// check of its expressions.
+const maxWarmup = 1000
+
 func (c *Conf) loadAlert(s *parse.SectionNode) {
 	name := s.Name.Text
 	if _, ok := c.Alerts[name]; ok {
@@ -807,8 +1931,11 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 		Name:             name,
 		CritNotification: new(Notifications),
 		WarnNotification: new(Notifications),
+		WarnSeverity:     DefaultWarnSeverity,
+		CritSeverity:     DefaultCritSeverity,
 	}
 	a.Text = s.RawText
+	a.Loc = c.loc(s)
 	procNotification := func(v string, ns *Notifications) {
 		if lookup := lookupNotificationRE.FindStringSubmatch(v); lookup != nil {
 			if ns.Lookups == nil {
@@ -818,6 +1945,10 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			if l == nil {
 				c.errorf("unknown lookup table %s", lookup[1])
 			}
+			if l.notificationKeys == nil {
+				l.notificationKeys = make(map[string]bool)
+			}
+			l.notificationKeys[lookup[2]] = true
 			for _, e := range l.Entries {
 				for k, v := range e.Values {
 					if k != lookup[2] {
@@ -847,6 +1978,8 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 		c.at(p.node)
 		v := p.val
 		switch p.key {
+		case "namespace":
+			a.Namespace = v
 		case "template":
 			a.template = v
 			t, ok := c.Templates[a.template]
@@ -865,18 +1998,36 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			if err := a.Squelch.Add(v); err != nil {
 				c.error(err)
 			}
+		case "exclude":
+			tags, err := opentsdb.ParseTags(v)
+			if tags == nil && err != nil {
+				c.error(err)
+				continue
+			}
+			if tags != nil {
+				a.Exclude = append(a.Exclude, tags)
+			}
 		case "critNotification":
 			procNotification(v, a.CritNotification)
 		case "warnNotification":
 			procNotification(v, a.WarnNotification)
-		case "unknown":
+		case "unknownNotification":
+			if a.UnknownNotification == nil {
+				a.UnknownNotification = new(Notifications)
+			}
+			procNotification(v, a.UnknownNotification)
+		case "unknown", "unknownAfter":
+			// unknown is the old name of unknownAfter.
+			if a.Unknown != 0 {
+				c.errorf("cannot specify both unknown and unknownAfter")
+			}
 			od, err := opentsdb.ParseDuration(v)
 			if err != nil {
 				c.error(err)
 			}
 			d := time.Duration(od)
 			if d < time.Second {
-				c.errorf("unknown duration must be at least 1s")
+				c.errorf("%s duration must be at least 1s", p.key)
 			}
 			a.Unknown = d
 		case "maxLogFrequency":
@@ -891,6 +2042,8 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			a.MaxLogFrequency = d
 		case "unjoinedOk":
 			a.UnjoinedOK = true
+		case "dryRun":
+			a.DryRun = true
 		case "ignoreUnknown":
 			a.IgnoreUnknown = true
 		case "log":
@@ -901,6 +2054,61 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			if err != nil {
 				c.error(err)
 			}
+		case "maxKeys":
+			var err error
+			a.MaxKeys, err = strconv.Atoi(v)
+			if err != nil {
+				c.error(err)
+			}
+			if a.MaxKeys < 0 {
+				c.errorf("maxKeys must not be negative")
+			}
+		case "interval":
+			od, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			d := time.Duration(od)
+			if d < time.Second {
+				c.errorf("interval must be at least 1s")
+			}
+			a.Interval = d
+		case "warmup":
+			var err error
+			a.Warmup, err = strconv.Atoi(v)
+			if err != nil {
+				c.error(err)
+			}
+			if a.Warmup < 0 || a.Warmup > maxWarmup {
+				c.errorf("warmup must be from 0 to %d", maxWarmup)
+			}
+		case "jitter":
+			od, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			a.Jitter = time.Duration(od)
+		case "renotifyValue":
+			a.RenotifyValue = c.NewExpr(v)
+		case "renotifyWorsening":
+			f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil {
+				c.error(err)
+			}
+			if f == 0 {
+				c.errorf("renotifyWorsening must not be 0")
+			}
+			a.RenotifyWorsening = f
+		case "warnSeverity", "critSeverity":
+			sev, err := ParseSeverity(v)
+			if err != nil {
+				c.error(err)
+			}
+			if p.key == "warnSeverity" {
+				a.WarnSeverity = sev
+			} else {
+				a.CritSeverity = sev
+			}
 		default:
 			c.errorf("unknown key %s", p.key)
 		}
@@ -909,6 +2117,16 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 		c.errorf("maxLogFrequency can only be used on alerts with `log = true`.")
 	}
 	c.at(s)
+	if a.WarnSeverity > a.CritSeverity {
+		c.errorf("warnSeverity %s is above critSeverity %s", a.WarnSeverity, a.CritSeverity)
+	}
+	for _, ns := range []*Notifications{a.CritNotification, a.WarnNotification, a.UnknownNotifications()} {
+		for _, n := range ns.Notifications {
+			if n.Namespace != "" && n.Namespace != a.Namespace {
+				c.errorf("notification %s is in namespace %s", n.Name, n.Namespace)
+			}
+		}
+	}
 	if a.Crit == nil && a.Warn == nil {
 		c.errorf("neither crit or warn specified")
 	}
@@ -946,15 +2164,24 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			c.errorf("Depends and crit/warn must share at least one tag.")
 		}
 	}
-	if a.Log {
-		for _, n := range a.CritNotification.Notifications {
-			if n.Next != nil {
-				c.errorf("cannot use log with a chained notification")
-			}
+	if (a.RenotifyValue == nil) != (a.RenotifyWorsening == 0) {
+		c.errorf("renotifyValue and renotifyWorsening must be specified together")
+	}
+	if a.RenotifyValue != nil {
+		vtags, err := a.RenotifyValue.Root.Tags()
+		if err != nil {
+			c.error(err)
+		}
+		if !tags.Equal(vtags) {
+			c.errorf("crit/warn tags (%v) and renotifyValue tags (%v) must be equal", tags, vtags)
 		}
-		for _, n := range a.WarnNotification.Notifications {
-			if n.Next != nil {
-				c.errorf("cannot use log with a chained notification")
+	}
+	if a.Log {
+		for _, ns := range []*Notifications{a.CritNotification, a.WarnNotification, a.UnknownNotifications()} {
+			for _, n := range ns.Notifications {
+				if n.Next != nil {
+					c.errorf("cannot use log with a chained notification")
+				}
 			}
 		}
 		if a.Crit != nil && len(a.CritNotification.Notifications) == 0 {
@@ -980,13 +2207,37 @@ func (c *Conf) loadAlert(s *parse.SectionNode) {
 			c.errorf("critNotification specified, but no template")
 		}
 	}
+	if a.Interval != 0 && a.RunEvery != 0 {
+		c.errorf("cannot specify both interval and runEvery")
+	}
+	if a.IgnoreUnknown && a.UnknownNotification != nil {
+		c.errorf("cannot specify both ignoreUnknown and unknownNotification")
+	}
+	if a.IgnoreUnknown && a.Unknown != 0 {
+		// Older configs set both, so don't reject them.
+		slog.Warningf("alert %s: unknownAfter is ignored because ignoreUnknown is set", a.Name)
+		a.Unknown = 0
+	}
 	if a.RunEvery == 0 {
 		a.RunEvery = c.DefaultRunEvery
 	}
+	if a.MaxKeys == 0 {
+		a.MaxKeys = c.DefaultMaxKeys
+	}
+	if a.Jitter < 0 || a.Jitter >= c.AlertInterval(&a) {
+		c.errorf("jitter must be less than the alert interval")
+	}
 	a.returnType = ret
 	c.Alerts[name] = &a
 }
 
+// Retry and timeout settings of notifications that don't specify them.
+const (
+	DefaultNotificationRetries      = 4
+	DefaultNotificationRetryBackoff = time.Minute
+	DefaultNotificationSendTimeout  = time.Minute
+)
+
 func (c *Conf) loadNotification(s *parse.SectionNode) {
 	name := s.Name.Text
 	if _, ok := c.Notifications[name]; ok {
@@ -997,6 +2248,9 @@ func (c *Conf) loadNotification(s *parse.SectionNode) {
 		ContentType:  "application/x-www-form-urlencoded",
 		Name:         name,
 		RunOnActions: true,
+		Retries:      DefaultNotificationRetries,
+		RetryBackoff: DefaultNotificationRetryBackoff,
+		SendTimeout:  DefaultNotificationSendTimeout,
 	}
 	n.Text = s.RawText
 	funcs := ttemplate.FuncMap{
@@ -1041,6 +2295,8 @@ func (c *Conf) loadNotification(s *parse.SectionNode) {
 				c.error(err)
 			}
 			n.Get = get
+		case "namespace":
+			n.Namespace = v
 		case "print":
 			n.Print = true
 		case "contentType":
@@ -1066,8 +2322,162 @@ func (c *Conf) loadNotification(s *parse.SectionNode) {
 				c.error(err)
 			}
 			n.Body = tmpl
+		case "bodyTemplate":
+			n.bodyTemplate = v
+			tmpl := ttemplate.New(name).Funcs(funcs)
+			_, err := tmpl.Parse(n.bodyTemplate)
+			if err != nil {
+				c.error(err)
+			}
+			n.BodyTemplate = tmpl
+		case "templateSubject":
+			if n.Template == nil {
+				n.Template = &Template{Name: name}
+			}
+			n.Template.subject = v
+			tmpl := c.subjects.New("notification." + name).Funcs(templateFuncs(n.Vars, c))
+			_, err := tmpl.Parse(v)
+			if err != nil {
+				c.error(err)
+			}
+			n.Template.Subject = tmpl
+		case "templateBody":
+			if n.Template == nil {
+				n.Template = &Template{Name: name}
+			}
+			n.Template.body = v
+			tmpl := c.bodies.New("notification." + name).Funcs(htemplate.FuncMap(templateFuncs(n.Vars, c)))
+			_, err := tmpl.Parse(v)
+			if err != nil {
+				c.error(err)
+			}
+			n.Template.Body = tmpl
 		case "runOnActions":
 			n.RunOnActions = v == "true"
+		case "pagerDuty":
+			n.PagerDuty = v
+		case "minSeverity":
+			sev, err := ParseSeverity(v)
+			if err != nil {
+				c.error(err)
+			}
+			n.MinSeverity = sev
+		case "maxPerHour":
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				c.error(err)
+			}
+			if i < 1 {
+				c.errorf("maxPerHour must be at least 1")
+			}
+			n.MaxPerHour = i
+		case "quietHours":
+			qs, err := parseQuietHours(v)
+			if err != nil {
+				c.error(err)
+			}
+			n.QuietHours = qs
+		case "onCall":
+			if c.SMTPHost == "" || c.EmailFrom == "" {
+				c.errorf("onCall notifications require both smtpHost and emailFrom to be set")
+			}
+			n.onCall = v
+			o, ok := c.OnCalls[v]
+			if !ok {
+				c.errorf("unknown oncall %s", v)
+			}
+			n.OnCall = o
+		case "members":
+			n.members = v
+			for _, m := range strings.Split(v, ",") {
+				m = strings.TrimSpace(m)
+				member, ok := c.Notifications[m]
+				if !ok {
+					c.errorf("unknown notification %s", m)
+				}
+				if len(member.Members) > 0 {
+					c.errorf("notification group %s cannot be a member", m)
+				}
+				n.Members = append(n.Members, member)
+			}
+		case "mode":
+			switch v {
+			case "fanout":
+				n.RoundRobin = false
+			case "roundrobin":
+				n.RoundRobin = true
+			default:
+				c.errorf("mode must be fanout or roundrobin")
+			}
+		case "retries":
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				c.error(err)
+			}
+			if i < 0 {
+				c.errorf("retries must not be negative")
+			}
+			n.Retries = i
+		case "retryBackoff":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if time.Duration(d) < time.Second {
+				c.errorf("retryBackoff must be at least 1s")
+			}
+			n.RetryBackoff = time.Duration(d)
+		case "retryJitter":
+			f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil {
+				c.error(err)
+			}
+			if f < 0 || f > 100 {
+				c.errorf("retryJitter must be between 0%% and 100%%")
+			}
+			n.RetryJitter = f
+		case "sendTimeout":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if time.Duration(d) < time.Second {
+				c.errorf("sendTimeout must be at least 1s")
+			}
+			n.SendTimeout = time.Duration(d)
+		case "coalesceWindow":
+			d, err := opentsdb.ParseDuration(v)
+			if err != nil {
+				c.error(err)
+			}
+			if time.Duration(d) < time.Second {
+				c.errorf("coalesceWindow must be at least 1s")
+			}
+			n.CoalesceWindow = time.Duration(d)
+		case "coalesceBy":
+			n.CoalesceBy = nil
+			for _, k := range strings.Split(v, ",") {
+				k = strings.TrimSpace(k)
+				if !opentsdb.ValidTag(k) {
+					c.errorf("invalid tag key in coalesceBy: %q", k)
+				}
+				n.CoalesceBy = append(n.CoalesceBy, k)
+			}
+		case "coalesceTemplate":
+			t, ok := c.Templates[v]
+			if !ok {
+				c.errorf("template not found: %s", v)
+			}
+			n.CoalesceTemplate = t
+		case "quietAction":
+			switch v {
+			case "queue":
+				n.QuietDrop = false
+			case "drop":
+				n.QuietDrop = true
+			default:
+				c.errorf("quietAction must be queue or drop")
+			}
 		default:
 			c.errorf("unknown key %s", k)
 		}
@@ -1076,6 +2486,21 @@ func (c *Conf) loadNotification(s *parse.SectionNode) {
 	if n.Timeout > 0 && n.Next == nil {
 		c.errorf("timeout specified without next")
 	}
+	if n.BodyTemplate != nil && n.Body != nil {
+		c.errorf("cannot specify both body and bodyTemplate")
+	}
+	if n.BodyTemplate != nil && n.Post == nil {
+		c.errorf("bodyTemplate specified without post")
+	}
+	if n.RoundRobin && len(n.Members) == 0 {
+		c.errorf("mode specified without members")
+	}
+	if n.CoalesceWindow == 0 && (len(n.CoalesceBy) > 0 || n.CoalesceTemplate != nil) {
+		c.errorf("coalesceBy or coalesceTemplate specified without coalesceWindow")
+	}
+	if len(n.Members) > 0 && (len(n.Email) > 0 || n.OnCall != nil || n.Post != nil || n.Get != nil || n.Print || n.PagerDuty != "" || n.MaxPerHour > 0 || len(n.QuietHours) > 0) {
+		c.errorf("notification group cannot have destinations, maxPerHour, or quietHours of its own")
+	}
 }
 
 var exRE = regexp.MustCompile(`\$(?:[\w.]+|\{[\w.]+\})`)
@@ -1105,7 +2530,7 @@ func (c *Conf) Expand(v string, vars map[string]string, ignoreBadExpand bool) st
 func (c *Conf) seen(v string, m map[string]bool) {
 	if m[v] {
 		switch v {
-		case "squelch", "critNotification", "warnNotification", "graphiteHeader":
+		case "squelch", "critNotification", "warnNotification", "unknownNotification", "graphiteHeader", "cardinalityLimit", "exclude", "header":
 			// ignore
 		default:
 			c.errorf("duplicate key: %s", v)
@@ -1289,6 +2714,33 @@ func (c *Conf) Funcs() map[string]eparse.Func {
 		return e.Root.Tags()
 	}
 
+	// tagAnomaly are the tags of the series of the named anomaly.
+	tagAnomaly := func(args []eparse.Node) (eparse.Tags, error) {
+		name := args[0].(*eparse.StringNode).Text
+		a := c.Anomalies[name]
+		if a == nil {
+			return nil, fmt.Errorf("bad anomaly name %v", name)
+		}
+		return a.Expr.Root.Tags()
+	}
+
+	// tagAlertKeys are the tags of the alert keys of the named alert.
+	tagAlertKeys := func(args []eparse.Node) (eparse.Tags, error) {
+		name := args[0].(*eparse.StringNode).Text
+		a := c.Alerts[name]
+		if a == nil {
+			return nil, fmt.Errorf("bad alert name %v", name)
+		}
+		e := a.Crit
+		if e == nil {
+			e = a.Warn
+		}
+		if e == nil {
+			return nil, fmt.Errorf("alert %v has no crit or warn", name)
+		}
+		return e.Root.Tags()
+	}
+
 	funcs := map[string]eparse.Func{
 		"alert": {
 			Args:   []eparse.FuncType{eparse.TypeString, eparse.TypeString},
@@ -1296,6 +2748,35 @@ func (c *Conf) Funcs() map[string]eparse.Func {
 			Tags:   tagAlert,
 			F:      c.alert,
 		},
+		"abnormalGrowth": {
+			Args:   []eparse.FuncType{eparse.TypeString, eparse.TypeString, eparse.TypeString},
+			Return: eparse.TypeScalar,
+			F:      c.abnormalGrowth,
+		},
+		"status": {
+			Args:   []eparse.FuncType{eparse.TypeString, eparse.TypeString},
+			Return: eparse.TypeNumberSet,
+			Tags:   tagAlertKeys,
+			F:      c.status,
+		},
+		"lastAckTime": {
+			Args:   []eparse.FuncType{eparse.TypeString},
+			Return: eparse.TypeNumberSet,
+			Tags:   tagAlertKeys,
+			F:      c.lastAckTime,
+		},
+		"incidentCount": {
+			Args:   []eparse.FuncType{eparse.TypeString, eparse.TypeString},
+			Return: eparse.TypeNumberSet,
+			Tags:   tagAlertKeys,
+			F:      c.incidentCount,
+		},
+		"anomaly": {
+			Args:   []eparse.FuncType{eparse.TypeString},
+			Return: eparse.TypeNumberSet,
+			Tags:   tagAnomaly,
+			F:      c.anomaly,
+		},
 		"lookup": {
 			Args:   []eparse.FuncType{eparse.TypeString, eparse.TypeString},
 			Return: eparse.TypeNumberSet,
@@ -1320,12 +2801,19 @@ func (c *Conf) Funcs() map[string]eparse.Func {
 	if c.GraphiteHost != "" {
 		merge(expr.Graphite)
 	}
-	if len(c.LogstashElasticHosts) != 0 {
+	if len(c.ElasticHosts) != 0 {
 		merge(expr.LogstashElastic)
 	}
 	if c.InfluxConfig.URL.Host != "" {
 		merge(expr.Influx)
 	}
+	if len(c.SQLDatabases) != 0 {
+		merge(expr.SQL)
+	}
+	if len(c.CloudWatch) != 0 {
+		merge(expr.CloudWatch)
+	}
+	merge(expr.Events)
 	return funcs
 }
 
@@ -1399,6 +2887,129 @@ func (c *Conf) alert(s *expr.State, T miniprofiler.Timer, name, key string) (res
 	return results, nil
 }
 
+// abnormalGrowth returns how many more alert keys of alert name are at status
+// (warn or crit) now than duration ago, to alert on spreading failures.
+func (c *Conf) abnormalGrowth(s *expr.State, T miniprofiler.Timer, name, status, duration string) (*expr.Results, error) {
+	if c.Alerts[name] == nil {
+		return nil, fmt.Errorf("abnormalGrowth: bad alert name %v", name)
+	}
+	var critical bool
+	switch status {
+	case "warn":
+	case "crit":
+		critical = true
+	default:
+		return nil, fmt.Errorf("abnormalGrowth: status must be warn or crit, got %v", status)
+	}
+	d, err := opentsdb.ParseDuration(duration)
+	if err != nil {
+		return nil, err
+	}
+	if s.History == nil {
+		return nil, fmt.Errorf("abnormalGrowth: no alert history")
+	}
+	now, before := s.History.CountAbnormalAlertKeys(name, critical, time.Duration(d))
+	return &expr.Results{
+		Results: []*expr.Result{
+			{Value: expr.Scalar(now - before)},
+		},
+	}, nil
+}
+
+// alertHistory returns s's alert history if name is an alert.
+func (c *Conf) alertHistory(s *expr.State, fn, name string) (expr.AlertStatusProvider, error) {
+	if c.Alerts[name] == nil {
+		return nil, fmt.Errorf("%s: bad alert name %v", fn, name)
+	}
+	if s.History == nil {
+		return nil, fmt.Errorf("%s: no alert history", fn)
+	}
+	return s.History, nil
+}
+
+// alertKeyResults returns a result for each alert key in values, sorted by
+// alert key.
+func alertKeyResults(values map[expr.AlertKey]float64) *expr.Results {
+	aks := make(expr.AlertKeys, 0, len(values))
+	for ak := range values {
+		aks = append(aks, ak)
+	}
+	sort.Sort(aks)
+	r := new(expr.Results)
+	for _, ak := range aks {
+		r.Results = append(r.Results, &expr.Result{
+			Value: expr.Number(values[ak]),
+			Group: ak.Group(),
+		})
+	}
+	return r
+}
+
+// status returns the best status of each alert key of alert name during the
+// duration before the check: 0 normal, 1 warning, 2 critical, or 3 unknown.
+// An empty duration returns the current status.
+func (c *Conf) status(s *expr.State, T miniprofiler.Timer, name, duration string) (*expr.Results, error) {
+	h, err := c.alertHistory(s, "status", name)
+	if err != nil {
+		return nil, err
+	}
+	var d opentsdb.Duration
+	if duration != "" {
+		if d, err = opentsdb.ParseDuration(duration); err != nil {
+			return nil, err
+		}
+	}
+	values := make(map[expr.AlertKey]float64)
+	for ak, st := range h.MinStatuses(name, time.Duration(d)) {
+		values[ak] = float64(st)
+	}
+	return alertKeyResults(values), nil
+}
+
+// lastAckTime returns the unix time each alert key of alert name was last
+// acknowledged. Alert keys never acknowledged are not included.
+func (c *Conf) lastAckTime(s *expr.State, T miniprofiler.Timer, name string) (*expr.Results, error) {
+	h, err := c.alertHistory(s, "lastAckTime", name)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[expr.AlertKey]float64)
+	for ak, t := range h.LastAckTimes(name) {
+		values[ak] = float64(t.Unix())
+	}
+	return alertKeyResults(values), nil
+}
+
+// incidentCount returns the number of incidents of each alert key of alert
+// name started within the duration before the check.
+func (c *Conf) incidentCount(s *expr.State, T miniprofiler.Timer, name, duration string) (*expr.Results, error) {
+	h, err := c.alertHistory(s, "incidentCount", name)
+	if err != nil {
+		return nil, err
+	}
+	d, err := opentsdb.ParseDuration(duration)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[expr.AlertKey]float64)
+	for ak, n := range h.IncidentCounts(name, time.Duration(d)) {
+		values[ak] = float64(n)
+	}
+	return alertKeyResults(values), nil
+}
+
+// anomaly returns the last anomaly score of each series of the anomaly
+// name. Series are left out until they have been scored.
+func (c *Conf) anomaly(s *expr.State, T miniprofiler.Timer, name string) (*expr.Results, error) {
+	if c.Anomalies[name] == nil {
+		return nil, fmt.Errorf("anomaly: bad anomaly name %v", name)
+	}
+	if s.History == nil {
+		return nil, fmt.Errorf("anomaly: no anomaly scores")
+	}
+	return &expr.Results{Results: s.History.AnomalyScores(name)}, nil
+}
+
 func (c *Conf) MakeLink(path string, v *url.Values) string {
 	u := url.URL{
 		Scheme:   "http",
@@ -1,11 +1,17 @@
 package conf
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"bosun.org/opentsdb"
 )
@@ -64,12 +70,36 @@ func checkMacroVarAlert(t *testing.T, a *Alert) {
 func TestInvalid(t *testing.T) {
 	names := map[string]string{
 		"lookup-key-pairs":     "conf: lookup-key-pairs:3:1: at <entry a=3 { }>: lookup tags mismatch, expected {a=,b=}",
-		"number-func-args":     `conf: number-func-args:4:1: at <warn = q("avg:o", ""...>: expr: parse: not enough arguments for q`,
+		"number-func-args":     `conf: number-func-args:4:1: at <warn = q("avg:o", ""...>: expr: 1:1: not enough arguments for q; usage: q(string, string, string)`,
 		"lookup-key-pairs-dup": `conf: lookup-key-pairs-dup:3:1: at <entry b=2,a=1 { }>: duplicate entry`,
 		"crit-warn-unmatching-tags": `conf: crit-warn-unmatching-tags:3:0: at <alert broken {\n	cri...>: crit tags (a,c) and warn tags (c) must be equal`,
 		"depends-no-overlap": `conf: depends-no-overlap:3:0: at <alert broken {\n	dep...>: Depends and crit/warn must share at least one tag.`,
 		"log-no-notification": `conf: log-no-notification:1:0: at <alert a {\n	crit = 1...>: log + crit specified, but no critNotification`,
 		"crit-notification-no-template": `conf: crit-notification-no-template:5:0: at <alert a {\n	crit = 1...>: critNotification specified, but no template`,
+		"notification-namespace":        `conf: notification-namespace:6:0: at <alert a {\n	namespac...>: notification n is in namespace ops`,
+		"jitter-interval":               `conf: jitter-interval:1:0: at <alert a {\n	crit = 1...>: jitter must be less than the alert interval`,
+		"test-unknown-alert":            `conf: test-unknown-alert:2:1: at <alert = a>: unknown alert a`,
+		"renotify-no-value":             `conf: renotify-no-value:1:0: at <alert a {\n	crit = 1...>: renotifyValue and renotifyWorsening must be specified together`,
+		"ha-no-redis":                   `conf: ha-no-redis: ha requires redisHost`,
+		"elastic-duplicate-default":     `conf: elastic-duplicate-default:3:0: at <elastic default {\n	...>: duplicate elastic cluster: default`,
+		"body-template-and-body":        `conf: body-template-and-body:1:0: at <notification n {\n	p...>: cannot specify both body and bodyTemplate`,
+		"ping-count":                    `conf: ping-count:1:0: at <pingCount = 20>: pingCount must be between 1 and 10`,
+		"ping-interval":                 `conf: ping-interval:1:0: at <pingInterval = 500ms>: pingInterval must be at least 1s`,
+		"ping-tags-dst-host":            `conf: ping-tags-dst-host:1:0: at <pingTags = dst_host=...>: pingTags may not set dst_host`,
+		"sql-no-dsn":                    `conf: sql-no-dsn:1:0: at <sql shop {\n	driver ...>: sql database requires driver and dsn`,
+		"lookup-source-no-entries":      `conf: lookup-source-no-entries:1:0: at <lookup cpu {\n	sourc...>: lookups with a source require entries, used until it is read`,
+		"cloudwatch-no-region":          `conf: cloudwatch-no-region:1:0: at <cloudwatch prod {\n	...>: cloudwatch account requires region`,
+		"probe-tcp-target":              `conf: probe-tcp-target:1:0: at <probe db {\n	type = ...>: tcp probe target must be host:port: address db01: missing port in address`,
+		"exclude-bad-tag":               `conf: exclude-bad-tag:3:1: at <exclude = host>: opentsdb: bad tag: host`,
+		"severity-order":                `conf: severity-order:1:0: at <alert a {\n	crit = 1...>: warnSeverity emergency is above critSeverity critical`,
+		"group-destinations":            `conf: group-destinations:5:0: at <notification g {\n	m...>: notification group cannot have destinations, maxPerHour, or quietHours of its own`,
+		"retries":                       `conf: retries:3:1: at <retries = -1>: retries must not be negative`,
+		"cardinality-limit":             `conf: cardinality-limit:1:0: at <cardinalityLimit = o...>: cardinalityLimit must be limit, tagk limit, or metric tagk limit`,
+		"ticket-no-close":               `conf: ticket-no-close:1:0: at <ticket jira {\n	post...>: ticket requires post, body, and close`,
+		"auth-header-no-header":         `conf: auth-header-no-header: authType header requires authHeader`,
+		"anomaly-no-expression":         `conf: anomaly-no-expression:1:0: at <anomaly cpu {\n	inte...>: anomaly requires expression`,
+		"coalesce-no-window":            `conf: coalesce-no-window:1:0: at <notification n {\n	p...>: coalesceBy or coalesceTemplate specified without coalesceWindow`,
+		"state-change-hook-scheme":      `conf: state-change-hook-scheme:1:0: at <stateChangeHook = ft...>: stateChangeHook must be an http or https URL`,
 	}
 	for fname, reason := range names {
 		path := filepath.Join("invalid", fname)
@@ -149,3 +179,440 @@ func TestSquelch(t *testing.T) {
 		}
 	}
 }
+
+func TestParseRedisHosts(t *testing.T) {
+	tests := []struct {
+		in     string
+		expect []string
+	}{
+		{"redis:6380", []string{"redis:6380"}},
+		{"redis", []string{"redis:6379"}},
+		{"10.0.0.1, redis2:6380", []string{"10.0.0.1:6379", "redis2:6380"}},
+		{"::1", []string{"[::1]:6379"}},
+		{"[2001:db8::1]", []string{"[2001:db8::1]:6379"}},
+		{"[2001:db8::1]:6380,srv:_redis._tcp.example.com", []string{"[2001:db8::1]:6380", "srv:_redis._tcp.example.com"}},
+		{"redis,", nil},
+		{"srv:", nil},
+		{"2001:db8::1:6379:x", nil},
+		{":6379", nil},
+	}
+	for _, test := range tests {
+		hosts, err := parseRedisHosts(test.in)
+		if test.expect == nil {
+			if err == nil {
+				t.Errorf("%s: expected error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(hosts, test.expect) {
+			t.Errorf("%s: got %v, expected %v", test.in, hosts, test.expect)
+		}
+	}
+}
+
+type countContext int
+
+func (c *countContext) Query(r *opentsdb.Request) (opentsdb.ResponseSet, error) {
+	*c++
+	return opentsdb.ResponseSet{{Metric: "m", DPS: map[string]opentsdb.Point{"0": 1}}}, nil
+}
+
+func TestTSDBCache(t *testing.T) {
+	c, err := New("", `
+		tsdbHost = localhost:4242
+		tsdbCacheTTL = 1h
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n countContext
+	ctx := c.TSDBContext().(*cacheContext)
+	ctx.Context = &n
+	now := time.Now()
+	query := func(at time.Time) {
+		r := &opentsdb.Request{
+			Start:   "1h-ago",
+			Queries: []*opentsdb.Query{{Metric: "m", Aggregator: "sum"}},
+		}
+		if err := r.SetTime(at); err != nil {
+			t.Fatal(err)
+		}
+		rs, err := ctx.Query(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs[0].Metric != "m" {
+			t.Fatalf("cached result was modified: %v", rs[0].Metric)
+		}
+		rs[0].Metric = "changed"
+	}
+	query(now)
+	query(now)
+	if n != 1 {
+		t.Fatalf("expected 1 query, got %d", n)
+	}
+	query(now.Add(-2 * time.Hour))
+	if n != 2 {
+		t.Fatalf("expected query for a different window, got %d", n)
+	}
+	c.TSDBCache.Clear()
+	query(now)
+	if n != 3 {
+		t.Fatalf("expected query after clear, got %d", n)
+	}
+}
+
+func TestAlertTestContext(t *testing.T) {
+	c, err := New("", `
+		alert a {
+			crit = 1
+		}
+		test t {
+			alert = a
+			series = m{host=a,dc=ny} 1 2 3
+			series = m{host=b,dc=ny} 5, 6, 7
+			series = m{host=c,dc=la} 9
+			expect = {host=a} crit
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test := c.AlertTests["t"]
+	if len(test.Series) != 3 || test.Series[1].Tags["host"] != "b" || len(test.Series[1].Values) != 3 {
+		t.Fatalf("unexpected series: %v", test.Series)
+	}
+	if e := test.Expect[0]; e.Status != "critical" || e.Tags["host"] != "a" {
+		t.Fatalf("unexpected expect: %v", e)
+	}
+	now := time.Unix(1000000, 0)
+	ctx := test.Context(now)
+	query := func(start int64, q *opentsdb.Query) opentsdb.ResponseSet {
+		rs, err := ctx.Query(&opentsdb.Request{
+			Start:   start,
+			End:     now.Unix(),
+			Queries: []*opentsdb.Query{q},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	rs := query(now.Unix()-60, &opentsdb.Query{Metric: "m", Aggregator: "sum", Tags: opentsdb.TagSet{"dc": "*"}})
+	if len(rs) != 2 || rs[0].Tags["dc"] != "la" || rs[1].Tags["dc"] != "ny" {
+		t.Fatalf("expected groups for each dc, got %v", rs)
+	}
+	if v := rs[1].DPS["1000000"]; v != 10 {
+		t.Fatalf("expected sum of 10, got %v", v)
+	}
+	if len(rs[1].DPS) != 2 {
+		t.Fatalf("expected 2 points in range, got %v", rs[1].DPS)
+	}
+	rs = query(0, &opentsdb.Query{Metric: "m", Aggregator: "max", Rate: true, Tags: opentsdb.TagSet{"host": "a|b"}})
+	if len(rs) != 2 || rs[0].DPS["1000000"] != 1.0/60 {
+		t.Fatalf("unexpected rate: %v", rs)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	c, err := New("", `
+		notification n {
+			print = true
+			quietHours = 22:00-07:00,06:30-08:00 America/New_York
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := c.Notifications["n"]
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		t     time.Time
+		until time.Time
+	}{
+		{time.Date(2016, 3, 1, 21, 59, 0, 0, ny), time.Time{}},
+		{time.Date(2016, 3, 1, 22, 0, 0, 0, ny), time.Date(2016, 3, 2, 8, 0, 0, 0, ny)},
+		{time.Date(2016, 3, 2, 3, 0, 0, 0, ny), time.Date(2016, 3, 2, 8, 0, 0, 0, ny)},
+		{time.Date(2016, 3, 2, 7, 30, 0, 0, ny), time.Date(2016, 3, 2, 8, 0, 0, 0, ny)},
+		{time.Date(2016, 3, 2, 8, 0, 0, 0, ny), time.Time{}},
+		// 03:00 UTC is 22:00 in New York.
+		{time.Date(2016, 3, 2, 3, 0, 0, 0, time.UTC), time.Date(2016, 3, 2, 8, 0, 0, 0, ny)},
+	}
+	for _, test := range tests {
+		until, quiet := n.QuietUntil(test.t)
+		if quiet != !test.until.IsZero() || !until.Equal(test.until) {
+			t.Errorf("%v: expected quiet until %v, got %v (quiet %v)", test.t, test.until, until, quiet)
+		}
+	}
+	for _, bad := range []string{"22:00", "22:00-22:00", "25:00-07:00", "22:00-07:00 Nowhere/Nothing"} {
+		if _, err := parseQuietHours(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	c, err := New("", `
+		tsdbHost = localhost:4242
+		checkFrequency = 1m
+		alert a {
+			runEvery = 5
+			$q = avg(q("avg:5m-avg:os.cpu", "1h", ""))
+			crit = $q > 90
+			warn = $q > 80 || avg(q("avg:os.mem", "1h", "")) > 90
+		}
+		alert b {
+			crit = avg(band("avg:1h-avg:os.cpu", "1d", "1d", 2)) > 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	costs := c.Lint()
+	if len(costs) != 2 || costs[0].Alert != "a" || costs[1].Alert != "b" {
+		t.Fatalf("unexpected costs: %v", costs)
+	}
+	a, b := costs[0], costs[1]
+	if a.Requests != 2 || a.PerCycle != 0.4 {
+		t.Errorf("a: expected 2 requests and 0.4 per cycle, got %v and %v", a.Requests, a.PerCycle)
+	}
+	if len(a.Warnings) != 1 || !strings.HasPrefix(a.Warnings[0], "warn: ") {
+		t.Errorf("a: unexpected warnings %q", a.Warnings)
+	}
+	if b.Requests != 2 || b.PerCycle != 2 || len(b.Warnings) != 0 {
+		t.Errorf("b: unexpected cost %+v", b)
+	}
+}
+
+func TestOnCall(t *testing.T) {
+	calendar := strings.Replace(`BEGIN:VCALENDAR
+BEGIN:VEVENT
+DTSTART:20160301T090000Z
+DTEND:20160308T090000Z
+SUMMARY:alice@example.com
+END:VEVENT
+BEGIN:VEVENT
+DTSTART;TZID=America/New_York:20160302T000000
+DTEND;TZID=America/New_York:20160303T000000
+SUMMARY:Bob covering for Alice
+ATTENDEE;CN=Bob:mailto:bob@example.com
+END:VEVENT
+BEGIN:VEVENT
+DTSTART:20160308T090000Z
+DTEND:20160315T090000Z
+SUMMARY:not an address
+END:VEVENT
+END:VCALENDAR
+`, "\n", "\r\n", -1)
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		fmt.Fprint(w, calendar)
+	}))
+	defer ts.Close()
+	c, err := New("", fmt.Sprintf(`
+		smtpHost = localhost:25
+		emailFrom = bosun@example.com
+		oncall rotation {
+			members = alice@example.com,Bob <bob@example.com>,carol@example.com
+			start = 2016-03-01T09:00:00Z
+			shift = 1w
+		}
+		oncall calendar {
+			calendar = %s
+		}
+		notification oncall {
+			onCall = rotation
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Notifications["oncall"].OnCall != c.OnCalls["rotation"] {
+		t.Fatal("expected notification oncall to use the rotation")
+	}
+	start := time.Date(2016, 3, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		oncall string
+		t      time.Time
+		who    string
+	}{
+		{"rotation", start, "alice@example.com"},
+		{"rotation", start.Add(7 * 24 * time.Hour), "bob@example.com"},
+		{"rotation", start.Add(21*24*time.Hour - time.Second), "carol@example.com"},
+		{"rotation", start.Add(21 * 24 * time.Hour), "alice@example.com"},
+		{"rotation", start.Add(-time.Second), "carol@example.com"},
+		{"calendar", start, "alice@example.com"},
+		// The override for March 2nd in New York starts at 05:00 UTC.
+		{"calendar", time.Date(2016, 3, 2, 5, 0, 0, 0, time.UTC), "bob@example.com"},
+		{"calendar", time.Date(2016, 3, 3, 5, 0, 0, 0, time.UTC), "alice@example.com"},
+		{"calendar", time.Date(2016, 3, 9, 0, 0, 0, 0, time.UTC), ""},
+	}
+	for _, test := range tests {
+		who, err := c.OnCalls[test.oncall].OnDuty(test.t)
+		if test.who == "" {
+			if err == nil {
+				t.Errorf("%s at %v: expected no one on call, got %v", test.oncall, test.t, who)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s at %v: %v", test.oncall, test.t, err)
+		} else if who.Address != test.who {
+			t.Errorf("%s at %v: expected %s, got %s", test.oncall, test.t, test.who, who.Address)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected the calendar to be fetched once within its refresh, got %d", fetches)
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	c, err := New("test.conf", `
+		smtpHost = mail:25
+		smtpPassword = hunter2
+		emailFrom = bosun@example.com
+		notification n {
+			post = https://hooks.example.com/services?token=abc
+			pagerDuty = 0123456789
+		}
+		template t {
+			subject = a
+		}
+		alert a {
+			template = t
+			crit = 1
+			critNotification = n
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := c.Runtime()
+	if r.Settings["SMTPPassword"] != Redacted || r.Settings["SMTPHost"] != "mail:25" {
+		t.Errorf("unexpected smtp settings: %v, %v", r.Settings["SMTPHost"], r.Settings["SMTPPassword"])
+	}
+	if r.Settings["CheckFrequency"] != "5m0s" {
+		t.Errorf("expected default checkFrequency, got %v", r.Settings["CheckFrequency"])
+	}
+	a := r.Alerts["a"]
+	if a == nil || a.Loc != "test.conf:12" || a.Crit != "1" || !reflect.DeepEqual(a.CritNotification, []string{"n"}) {
+		t.Fatalf("unexpected alert: %+v", a)
+	}
+	n := r.Notifications["n"]
+	if n.PagerDuty != Redacted || strings.Contains(n.Post, "abc") || n.Retries != DefaultNotificationRetries {
+		t.Fatalf("unexpected notification: %+v", n)
+	}
+}
+
+func TestLookupSource(t *testing.T) {
+	consul := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/bosun/cpu" || r.Header.Get("X-Consul-Token") != "secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "7")
+		fmt.Fprint(w, "entry host=web-* {\n\thigh = 0.9\n}")
+	}))
+	defer consul.Close()
+	etcd := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Etcd-Index", "12")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errorCode":100,"message":"Key not found"}`)
+	}))
+	defer etcd.Close()
+	c, err := New("test", fmt.Sprintf(`
+		notification a {
+			print = true
+		}
+		lookup cpu {
+			source = consul://secret@%s/bosun/cpu
+			entry host=* {
+				high = 0.5
+				notify = a
+			}
+		}
+		lookup mem {
+			source = etcd://%s/bosun/mem
+			entry host=* {
+				high = 0.5
+			}
+		}
+		alert cpu {
+			crit = 1
+			critNotification = lookup("cpu", "notify")
+		}
+	`, strings.TrimPrefix(consul.URL, "http://"), strings.TrimPrefix(etcd.URL, "http://")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := c.Lookups["cpu"]
+	if strings.Contains(l.Source.URL, "secret") {
+		t.Errorf("token shown in source URL %s", l.Source.URL)
+	}
+	value, found, index, err := l.Source.Get(0)
+	if err != nil || !found || index != 7 {
+		t.Fatalf("unexpected consul get: %v %v %v", found, index, err)
+	}
+	if err := c.SetLookupEntries(l, value); err != nil {
+		t.Fatal(err)
+	}
+	tags := opentsdb.TagSet{"host": "web-1"}
+	if v, _ := l.ToExpr().Get("high", tags); v != "0.9" {
+		t.Errorf("expected 0.9 from the source, got %q", v)
+	}
+	for _, bad := range []string{
+		"entry dc=* {\n\thigh = 1\n}",
+		"entry host=* {\n\tnotify = b\n}",
+		"entry host=* {",
+	} {
+		if err := c.SetLookupEntries(l, bad); err == nil {
+			t.Errorf("expected error setting %q", bad)
+		}
+	}
+	if v, _ := l.ToExpr().Get("high", tags); v != "0.9" {
+		t.Errorf("expected rejected values to be ignored, got %q", v)
+	}
+	if err := c.SetLookupEntries(l, ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := l.ToExpr().Get("high", tags); v != "0.5" {
+		t.Errorf("expected the configured 0.5 once the key is gone, got %q", v)
+	}
+
+	if _, found, index, err := c.Lookups["mem"].Source.Get(0); err != nil || found || index != 12 {
+		t.Errorf("unexpected etcd get: %v %v %v", found, index, err)
+	}
+	for _, bad := range []string{
+		"http://localhost/key",
+		"consul://localhost:8500/",
+		"etcd://token@localhost:2379/key",
+	} {
+		if _, err := ParseKVSource(bad); err == nil {
+			t.Errorf("expected error parsing %s", bad)
+		}
+	}
+}
+
+func TestIgnoreUnknownAfter(t *testing.T) {
+	c, err := New("", `
+		alert a {
+			crit = 1
+			ignoreUnknown = true
+			unknown = 1h
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := c.Alerts["a"]; !a.IgnoreUnknown || a.Unknown != 0 {
+		t.Fatalf("expected ignoreUnknown to win, got IgnoreUnknown %v, Unknown %v", a.IgnoreUnknown, a.Unknown)
+	}
+}
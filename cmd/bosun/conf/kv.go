@@ -0,0 +1,140 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KVSource is a key in Consul's KV store or etcd (v2 API) that a lookup's
+// entries are read from, written as consul://[token@]host:port/key or
+// etcd://host:port/key.
+type KVSource struct {
+	URL   string
+	kind  string
+	host  string
+	key   string
+	token string
+}
+
+// kvWaitTime is how long a watch of a KVSource waits for a change before
+// asking again.
+const kvWaitTime = 5 * time.Minute
+
+var kvClient = &http.Client{Timeout: kvWaitTime + 30*time.Second}
+
+// ParseKVSource parses a KVSource URL.
+func ParseKVSource(s string) (*KVSource, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "consul" && u.Scheme != "etcd" {
+		return nil, fmt.Errorf("source must be a consul:// or etcd:// URL")
+	}
+	key := strings.Trim(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, fmt.Errorf("source requires a host and key")
+	}
+	k := &KVSource{
+		URL:  s,
+		kind: u.Scheme,
+		host: u.Host,
+		key:  key,
+	}
+	if u.User != nil {
+		if k.kind != "consul" {
+			return nil, fmt.Errorf("only consul sources take a token")
+		}
+		k.token = u.User.Username()
+		// Don't show the token in the configuration API.
+		u.User = nil
+		k.URL = u.String()
+	}
+	return k, nil
+}
+
+// Get returns the value of the key, and whether it exists, once it has
+// changed since index, or after a while if it doesn't. index is 0 to return
+// it at once. The returned index is passed to the next Get to wait for the
+// next change; it is index if nothing changed.
+func (k *KVSource) Get(index uint64) (value string, found bool, next uint64, err error) {
+	var u string
+	switch k.kind {
+	case "consul":
+		u = fmt.Sprintf("http://%s/v1/kv/%s?raw", k.host, k.key)
+		if index > 0 {
+			u += fmt.Sprintf("&index=%d&wait=%s", index, kvWaitTime)
+		}
+	case "etcd":
+		u = fmt.Sprintf("http://%s/v2/keys/%s", k.host, k.key)
+		if index > 0 {
+			u += fmt.Sprintf("?wait=true&waitIndex=%d", index+1)
+		}
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return "", false, 0, err
+	}
+	if k.token != "" {
+		req.Header.Set("X-Consul-Token", k.token)
+	}
+	resp, err := kvClient.Do(req)
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() && index > 0 {
+		// etcd watches have no timeout of their own.
+		return "", false, index, nil
+	}
+	if err != nil {
+		return "", false, 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", false, 0, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return "", false, 0, fmt.Errorf("%s: %s: %s", k.URL, resp.Status, strings.TrimSpace(string(b)))
+	}
+	found = resp.StatusCode == http.StatusOK
+	if k.kind == "consul" {
+		next, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+		if err != nil {
+			return "", false, 0, fmt.Errorf("%s: bad X-Consul-Index: %v", k.URL, err)
+		}
+		if found {
+			value = string(b)
+		}
+		return value, found, next, nil
+	}
+	var r struct {
+		Node struct {
+			Value         string
+			Dir           bool
+			ModifiedIndex uint64
+		}
+		Action string
+	}
+	if found {
+		if err := json.Unmarshal(b, &r); err != nil {
+			return "", false, 0, fmt.Errorf("%s: %v", k.URL, err)
+		}
+		if r.Node.Dir {
+			return "", false, 0, fmt.Errorf("%s: is a directory", k.URL)
+		}
+		if r.Action == "delete" || r.Action == "expire" {
+			found = false
+		}
+		return r.Node.Value, found, r.Node.ModifiedIndex, nil
+	}
+	next, err = strconv.ParseUint(resp.Header.Get("X-Etcd-Index"), 10, 64)
+	if err != nil {
+		return "", false, 0, fmt.Errorf("%s: bad X-Etcd-Index: %v", k.URL, err)
+	}
+	return "", false, next, nil
+}
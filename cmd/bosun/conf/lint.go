@@ -0,0 +1,64 @@
+package conf
+
+import (
+	"sort"
+
+	"bosun.org/expr"
+)
+
+// AlertCost is the static cost report for one alert.
+type AlertCost struct {
+	Alert string
+	// Requests is the number of datasource requests one run of the alert
+	// makes. Queries shared between its expressions are counted once.
+	Requests int
+	// PerCycle is the average number of requests the alert makes per check
+	// cycle, accounting for runEvery and interval.
+	PerCycle float64
+	Warnings []string
+}
+
+// Lint statically analyzes the expressions of every alert and returns a cost
+// report for each, sorted by alert name.
+func (c *Conf) Lint() []*AlertCost {
+	var costs []*AlertCost
+	for name, a := range c.Alerts {
+		ac := &AlertCost{Alert: name}
+		seen := make(map[string]bool)
+		for _, le := range []struct {
+			key string
+			e   *expr.Expr
+		}{
+			{"crit", a.Crit},
+			{"warn", a.Warn},
+			{"depends", a.Depends},
+			{"renotifyValue", a.RenotifyValue},
+		} {
+			if le.e == nil {
+				continue
+			}
+			l := le.e.Lint()
+			for q, n := range l.Queries {
+				if !seen[q] {
+					seen[q] = true
+					ac.Requests += n
+				}
+			}
+			for _, w := range l.Warnings {
+				ac.Warnings = append(ac.Warnings, le.key+": "+w)
+			}
+		}
+		if interval := c.AlertInterval(a); interval > 0 {
+			ac.PerCycle = float64(ac.Requests) * float64(c.CheckFrequency) / float64(interval)
+		}
+		costs = append(costs, ac)
+	}
+	sort.Sort(alertCosts(costs))
+	return costs
+}
+
+type alertCosts []*AlertCost
+
+func (a alertCosts) Len() int           { return len(a) }
+func (a alertCosts) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a alertCosts) Less(i, j int) bool { return a[i].Alert < a[j].Alert }
@@ -1,8 +1,8 @@
 package conf
 
 import (
-	"bosun.org/cmd/bosun/expr"
 	"bosun.org/cmd/bosun/search"
+	"bosun.org/expr"
 	"bosun.org/opentsdb"
 )
 
@@ -3,11 +3,15 @@ package conf
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/mail"
 	"net/smtp"
 	"strings"
+	"time"
 
 	"bosun.org/_third_party/github.com/jordan-wright/email"
 	"bosun.org/collect"
@@ -25,12 +29,20 @@ func init() {
 		"The number of email notifications that Bosun failed to send.")
 }
 
-func (n *Notification) Notify(subject, body string, emailsubject, emailbody []byte, c *Conf, ak string, attachments ...*Attachment) {
-	if len(n.Email) > 0 {
-		go n.DoEmail(emailsubject, emailbody, c, ak, attachments...)
+// KeyHeader is the header of notification emails and posts that holds the
+// notification's key. The key is the same for every send of one
+// notification, so receivers can drop duplicates.
+const KeyHeader = "X-Bosun-Notification-Key"
+
+// Notify sends the notification to all of its destinations without waiting.
+// postBody, if not nil, is posted instead of the subject; see PostBody. key,
+// if not empty, is sent in the KeyHeader.
+func (n *Notification) Notify(subject, body string, emailsubject, emailbody, postBody []byte, c *Conf, ak, key string, attachments ...*Attachment) {
+	if len(n.Email) > 0 || n.OnCall != nil {
+		go n.DoEmail(emailsubject, emailbody, c, ak, key, attachments...)
 	}
 	if n.Post != nil {
-		go n.DoPost([]byte(subject))
+		go n.DoPost([]byte(subject), postBody, key)
 	}
 	if n.Get != nil {
 		go n.DoGet()
@@ -38,43 +50,231 @@ func (n *Notification) Notify(subject, body string, emailsubject, emailbody []by
 	if n.Print {
 		go n.DoPrint(subject)
 	}
+	if n.PagerDuty != "" && ak != ActionAlertKey {
+		go n.DoPagerDuty(PagerDutyTrigger, ak, subject)
+	}
+}
+
+// Destinations of a notification. Deliveries track which of them are still
+// to be sent.
+const (
+	DestEmail     = "email"
+	DestPost      = "post"
+	DestGet       = "get"
+	DestPrint     = "print"
+	DestPagerDuty = "pagerduty"
+)
+
+// Destinations returns the destinations a notification of n about ak is
+// sent to.
+func (n *Notification) Destinations(ak string) []string {
+	var dests []string
+	if len(n.Email) > 0 || n.OnCall != nil {
+		dests = append(dests, DestEmail)
+	}
+	if n.Post != nil {
+		dests = append(dests, DestPost)
+	}
+	if n.Get != nil {
+		dests = append(dests, DestGet)
+	}
+	if n.Print {
+		dests = append(dests, DestPrint)
+	}
+	if n.PagerDuty != "" && ak != ActionAlertKey {
+		dests = append(dests, DestPagerDuty)
+	}
+	return dests
+}
+
+// Deliver sends the notification to dests, which are some of its
+// Destinations, and waits for them. It returns the error of each destination
+// that failed, or nil if all were sent.
+func (n *Notification) Deliver(dests []string, subject, body string, emailsubject, emailbody, postBody []byte, c *Conf, ak, key string, attachments ...*Attachment) map[string]error {
+	type result struct {
+		dest string
+		err  error
+	}
+	results := make(chan result, len(dests))
+	for _, dest := range dests {
+		var f func() error
+		switch dest {
+		case DestEmail:
+			f = func() error { return n.DoEmail(emailsubject, emailbody, c, ak, key, attachments...) }
+		case DestPost:
+			f = func() error { return n.DoPost([]byte(subject), postBody, key) }
+		case DestGet:
+			f = n.DoGet
+		case DestPrint:
+			f = func() error {
+				n.DoPrint(subject)
+				return nil
+			}
+		case DestPagerDuty:
+			f = func() error { return n.DoPagerDuty(PagerDutyTrigger, ak, subject) }
+		default:
+			err := fmt.Errorf("unknown destination %s", dest)
+			f = func() error { return err }
+		}
+		go func(dest string, f func() error) { results <- result{dest, f()} }(dest, f)
+	}
+	var errs map[string]error
+	for range dests {
+		if r := <-results; r.err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[r.dest] = r.err
+		}
+	}
+	return errs
+}
+
+// httpClient returns the client n sends HTTP requests with, which times out
+// after n's SendTimeout.
+func (n *Notification) httpClient() *http.Client {
+	if n.SendTimeout == 0 {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: http.DefaultClient.Transport,
+		Timeout:   n.SendTimeout,
+	}
 }
 
 func (n *Notification) DoPrint(subject string) {
 	slog.Infoln(subject)
 }
 
-func (n *Notification) DoPost(subject []byte) {
-	if n.Body != nil {
+// PostBody renders n's bodyTemplate with data. It returns nil if n has no
+// bodyTemplate.
+func (n *Notification) PostBody(data interface{}) ([]byte, error) {
+	if n.BodyTemplate == nil {
+		return nil, nil
+	}
+	buf := new(bytes.Buffer)
+	if err := n.BodyTemplate.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DoPost posts postBody, if not nil, or else the subject rendered with n's
+// body template. key, if not empty, is sent in the KeyHeader.
+func (n *Notification) DoPost(subject, postBody []byte, key string) error {
+	if postBody != nil {
+		subject = postBody
+	} else if n.Body != nil {
 		buf := new(bytes.Buffer)
 		if err := n.Body.Execute(buf, string(subject)); err != nil {
 			slog.Errorln(err)
-			return
+			return err
 		}
 		subject = buf.Bytes()
 	}
-	resp, err := http.Post(n.Post.String(), n.ContentType, bytes.NewBuffer(subject))
+	req, err := http.NewRequest("POST", n.Post.String(), bytes.NewBuffer(subject))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", n.ContentType)
+	if key != "" {
+		req.Header.Set(KeyHeader, key)
+	}
+	resp, err := n.httpClient().Do(req)
 	if resp != nil && resp.Body != nil {
 		defer resp.Body.Close()
 	}
 	if err != nil {
 		slog.Error(err)
-		return
+		return err
 	}
 	if resp.StatusCode >= 300 {
 		slog.Errorln("bad response on notification post:", resp.Status)
+		return fmt.Errorf("bad response on notification post: %s", resp.Status)
 	}
+	return nil
 }
 
-func (n *Notification) DoGet() {
-	resp, err := http.Get(n.Get.String())
+func (n *Notification) DoGet() error {
+	resp, err := n.httpClient().Get(n.Get.String())
+	if resp != nil && resp.Body != nil {
+		defer resp.Body.Close()
+	}
 	if err != nil {
 		slog.Error(err)
-		return
+		return err
 	}
 	if resp.StatusCode >= 300 {
 		slog.Error("bad response on notification get:", resp.Status)
+		return fmt.Errorf("bad response on notification get: %s", resp.Status)
 	}
+	return nil
+}
+
+// ActionAlertKey is the alert key action notifications are sent with. They
+// are not sent to PagerDuty, which is instead told about actions directly.
+const ActionAlertKey = "actionNotification"
+
+// PagerDuty event actions.
+const (
+	PagerDutyTrigger     = "trigger"
+	PagerDutyAcknowledge = "acknowledge"
+	PagerDutyResolve     = "resolve"
+)
+
+// PagerDutyURL is the PagerDuty Events API v2 endpoint.
+var PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary  string `json:"summary"`
+	Source   string `json:"source"`
+	Severity string `json:"severity"`
+}
+
+// DoPagerDuty sends an event for alert key ak to PagerDuty. The alert key is
+// the dedup key, so acknowledge and resolve events apply to the incident
+// opened by the trigger. summary is only used by trigger events.
+func (n *Notification) DoPagerDuty(action, ak, summary string) error {
+	e := pagerDutyEvent{
+		RoutingKey:  n.PagerDuty,
+		EventAction: action,
+		DedupKey:    ak,
+	}
+	if action == PagerDutyTrigger {
+		// PagerDuty limits summaries to 1024 characters.
+		if len(summary) > 1024 {
+			summary = summary[:1024]
+		}
+		e.Payload = &pagerDutyPayload{
+			Summary:  summary,
+			Source:   util.Hostname,
+			Severity: "error",
+		}
+	}
+	b, err := json.Marshal(&e)
+	if err != nil {
+		return err
+	}
+	resp, err := n.httpClient().Post(PagerDutyURL, "application/json", bytes.NewBuffer(b))
+	if resp != nil && resp.Body != nil {
+		defer resp.Body.Close()
+	}
+	if err != nil {
+		slog.Error(err)
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		slog.Errorln("bad response on pagerduty", action, "for", ak, resp.Status)
+		return fmt.Errorf("bad response on pagerduty %s: %s", action, resp.Status)
+	}
+	return nil
 }
 
 type Attachment struct {
@@ -83,32 +283,45 @@ type Attachment struct {
 	ContentType string
 }
 
-func (n *Notification) DoEmail(subject, body []byte, c *Conf, ak string, attachments ...*Attachment) {
+func (n *Notification) DoEmail(subject, body []byte, c *Conf, ak, key string, attachments ...*Attachment) error {
 	e := email.NewEmail()
 	e.From = c.EmailFrom
 	for _, a := range n.Email {
 		e.To = append(e.To, a.Address)
 	}
+	if n.OnCall != nil {
+		a, err := n.OnCall.OnDuty(time.Now())
+		if err != nil {
+			collect.Add("email.sent_failed", nil, 1)
+			slog.Errorf("failed to send alert %v: %v", ak, err)
+			return err
+		}
+		e.To = append(e.To, a.Address)
+	}
 	e.Subject = string(subject)
 	e.HTML = body
 	for _, a := range attachments {
 		e.Attach(bytes.NewBuffer(a.Data), a.Filename, a.ContentType)
 	}
 	e.Headers.Add("X-Bosun-Server", util.Hostname)
-	if err := Send(e, c.SMTPHost, c.SMTPUsername, c.SMTPPassword); err != nil {
+	if key != "" {
+		e.Headers.Add(KeyHeader, key)
+	}
+	if err := Send(e, c.SMTPHost, c.SMTPUsername, c.SMTPPassword, n.SendTimeout); err != nil {
 		collect.Add("email.sent_failed", nil, 1)
 		slog.Errorf("failed to send alert %v to %v %v\n", ak, e.To, err)
-		return
+		return err
 	}
 	collect.Add("email.sent", nil, 1)
 	slog.Infof("relayed alert %v to %v sucessfully\n", ak, e.To)
+	return nil
 }
 
 // Send an email using the given host and SMTP auth (optional), returns any
 // error thrown by smtp.SendMail. This function merges the To, Cc, and Bcc
 // fields and calls the smtp.SendMail function using the Email.Bytes() output as
-// the message.
-func Send(e *email.Email, addr, username, password string) error {
+// the message. If timeout is not 0, sending fails after it.
+func Send(e *email.Email, addr, username, password string, timeout time.Duration) error {
 	// Merge the To, Cc, and Bcc fields
 	to := make([]string, 0, len(e.To)+len(e.Cc)+len(e.Bcc))
 	to = append(append(append(to, e.To...), e.Cc...), e.Bcc...)
@@ -124,16 +337,35 @@ func Send(e *email.Email, addr, username, password string) error {
 	if err != nil {
 		return err
 	}
-	return SendMail(addr, username, password, from.Address, to, raw)
+	return SendMail(addr, username, password, from.Address, to, raw, timeout)
 }
 
 // SendMail connects to the server at addr, switches to TLS if
 // possible, authenticates with the optional mechanism a if possible,
 // and then sends an email from address from, to addresses to, with
-// message msg.
-func SendMail(addr, username, password string, from string, to []string, msg []byte) error {
-	c, err := smtp.Dial(addr)
+// message msg. If timeout is not 0, the whole exchange must complete
+// within it.
+func SendMail(addr, username, password string, from string, to []string, msg []byte, timeout time.Duration) error {
+	var conn net.Conn
+	var err error
+	if timeout > 0 {
+		conn, err = net.DialTimeout("tcp", addr, timeout)
+		if err == nil {
+			err = conn.SetDeadline(time.Now().Add(timeout))
+		}
+	} else {
+		conn, err = net.Dial("tcp", addr)
+	}
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return err
+	}
+	host, _, _ := net.SplitHostPort(addr)
+	c, err := smtp.NewClient(conn, host)
 	if err != nil {
+		conn.Close()
 		return err
 	}
 	defer c.Close()
@@ -0,0 +1,199 @@
+package conf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/slog"
+)
+
+// DefaultOnCallRefresh is how often an on-call calendar is fetched if its
+// refresh is not specified.
+const DefaultOnCallRefresh = 10 * time.Minute
+
+// OnCall is an on-call schedule that notifications resolve to whoever is on
+// duty when they are sent. It is either a rotation of Members, each on duty
+// for Shift in turn from Start, or an iCalendar feed at Calendar.
+type OnCall struct {
+	Name     string
+	Members  []*mail.Address `json:",omitempty"`
+	Start    time.Time
+	Shift    time.Duration
+	Calendar string        `json:",omitempty"`
+	Refresh  time.Duration // How often Calendar is fetched
+
+	lock    sync.Mutex
+	shifts  []*onCallShift
+	fetched time.Time
+}
+
+// onCallShift is an event of an on-call calendar.
+type onCallShift struct {
+	Start, End time.Time
+	Who        *mail.Address
+}
+
+// OnDuty returns who is on duty at now.
+func (o *OnCall) OnDuty(now time.Time) (*mail.Address, error) {
+	if o.Calendar == "" {
+		d := now.Sub(o.Start)
+		n := int64(d / o.Shift)
+		if d < 0 && d%o.Shift != 0 {
+			n--
+		}
+		i := n % int64(len(o.Members))
+		if i < 0 {
+			i += int64(len(o.Members))
+		}
+		return o.Members[i], nil
+	}
+	shifts, err := o.calendarShifts()
+	if err != nil {
+		return nil, err
+	}
+	// Later shifts override earlier ones, so overrides added to a calendar
+	// take precedence over the regular rotation.
+	var who *mail.Address
+	var start time.Time
+	for _, s := range shifts {
+		if !now.Before(s.Start) && now.Before(s.End) && !s.Start.Before(start) {
+			who, start = s.Who, s.Start
+		}
+	}
+	if who == nil {
+		return nil, fmt.Errorf("no one is on call for %s", o.Name)
+	}
+	return who, nil
+}
+
+// calendarShifts returns the shifts of o's calendar, fetching it if it was
+// last fetched more than o.Refresh ago. If the fetch fails, the shifts last
+// fetched are used.
+func (o *OnCall) calendarShifts() ([]*onCallShift, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if !o.fetched.IsZero() && time.Since(o.fetched) < o.Refresh {
+		return o.shifts, nil
+	}
+	shifts, err := fetchCalendar(o.Calendar)
+	if err != nil {
+		if o.fetched.IsZero() {
+			return nil, fmt.Errorf("oncall %s: %v", o.Name, err)
+		}
+		slog.Errorf("oncall %s: using calendar fetched at %v: %v", o.Name, o.fetched, err)
+		return o.shifts, nil
+	}
+	o.shifts = shifts
+	o.fetched = time.Now()
+	return shifts, nil
+}
+
+func fetchCalendar(u string) ([]*onCallShift, error) {
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bad response fetching calendar: %s", resp.Status)
+	}
+	return parseCalendar(resp.Body)
+}
+
+// parseCalendar returns the events of an iCalendar feed that name who is on
+// call, by an ATTENDEE email address or a SUMMARY that is an email address.
+// Recurring events are not expanded.
+func parseCalendar(r io.Reader) ([]*onCallShift, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// Long lines are folded onto lines starting with whitespace.
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	var shifts []*onCallShift
+	var s *onCallShift
+	var summary string
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		name, value := line[:i], line[i+1:]
+		params := strings.Split(name, ";")
+		name = strings.ToUpper(params[0])
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			s = &onCallShift{}
+			summary = ""
+		case s == nil:
+			continue
+		case name == "DTSTART", name == "DTEND":
+			t, err := parseCalendarTime(value, params[1:])
+			if err != nil {
+				return nil, err
+			}
+			if name == "DTSTART" {
+				s.Start = t
+			} else {
+				s.End = t
+			}
+		case name == "ATTENDEE":
+			if strings.HasPrefix(strings.ToLower(value), "mailto:") {
+				if a, err := mail.ParseAddress(value[len("mailto:"):]); err == nil {
+					s.Who = a
+				}
+			}
+		case name == "SUMMARY":
+			summary = value
+		case name == "END" && value == "VEVENT":
+			if s.Who == nil {
+				s.Who, _ = mail.ParseAddress(summary)
+			}
+			if s.Who != nil && !s.Start.IsZero() && s.End.After(s.Start) {
+				shifts = append(shifts, s)
+			}
+			s = nil
+		}
+	}
+	return shifts, nil
+}
+
+// parseCalendarTime parses an iCalendar date or date-time: 20160104T090000Z
+// in UTC, 20160104T090000 in the zone of the TZID parameter or UTC, or the
+// date 20160104.
+func parseCalendarTime(value string, params []string) (time.Time, error) {
+	loc := time.UTC
+	for _, p := range params {
+		if strings.HasPrefix(strings.ToUpper(p), "TZID=") {
+			l, err := time.LoadLocation(strings.Trim(p[len("TZID="):], `"`))
+			if err != nil {
+				return time.Time{}, err
+			}
+			loc = l
+		}
+	}
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+	}
+	for _, layout := range []string{"20060102T150405Z", "20060102T150405", "20060102"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad calendar time %q", value)
+}
@@ -0,0 +1,101 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// QuietHours is a daily time range during which a notification is not sent.
+type QuietHours struct {
+	// Start and End are times of day. If End is before Start, the range
+	// spans midnight.
+	Start, End time.Duration
+	Location   *time.Location
+}
+
+// parseQuietHours parses comma-separated ranges of the form 22:00-07:00,
+// followed by an optional time zone name: 22:00-07:00,12:00-13:00 Europe/Paris.
+// Times are in UTC if no zone is given.
+func parseQuietHours(s string) ([]*QuietHours, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("quietHours must be ranges like 22:00-07:00 and an optional time zone")
+	}
+	loc := time.UTC
+	if len(fields) == 2 {
+		var err error
+		if loc, err = time.LoadLocation(fields[1]); err != nil {
+			return nil, err
+		}
+	}
+	var qs []*QuietHours
+	for _, r := range strings.Split(fields[0], ",") {
+		sp := strings.Split(r, "-")
+		if len(sp) != 2 {
+			return nil, fmt.Errorf("bad quietHours range %q, expected start-end", r)
+		}
+		start, err := parseTimeOfDay(sp[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimeOfDay(sp[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("empty quietHours range %q", r)
+		}
+		qs = append(qs, &QuietHours{Start: start, End: end, Location: loc})
+	}
+	return qs, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("bad time of day %q, expected hh:mm", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Until returns when q ends if t is within it, or the zero time if it is not.
+func (q *QuietHours) Until(t time.Time) time.Time {
+	t = t.In(q.Location)
+	y, m, d := t.Date()
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	// end is the time of day End, days after t's date, so it is correct
+	// across daylight saving changes.
+	end := func(days int) time.Time {
+		return time.Date(y, m, d+days, int(q.End/time.Hour), int(q.End%time.Hour/time.Minute), 0, 0, q.Location)
+	}
+	switch {
+	case q.Start < q.End && tod >= q.Start && tod < q.End:
+		return end(0)
+	case q.Start > q.End && tod >= q.Start:
+		return end(1)
+	case q.Start > q.End && tod < q.End:
+		return end(0)
+	}
+	return time.Time{}
+}
+
+// QuietUntil returns when n's quiet hours end if t is within them. Adjacent
+// or overlapping ranges are treated as one.
+func (n *Notification) QuietUntil(t time.Time) (until time.Time, quiet bool) {
+	// Each pass can only extend the end by another range, and ranges that
+	// cover the whole day must not loop forever.
+	for i := 0; i <= len(n.QuietHours); i++ {
+		extended := false
+		for _, q := range n.QuietHours {
+			if u := q.Until(t); u.After(t) {
+				t, until, quiet, extended = u, u, true, true
+			}
+		}
+		if !extended {
+			break
+		}
+	}
+	return until, quiet
+}
@@ -0,0 +1,296 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+// Redacted replaces secrets in a RuntimeConfig.
+const Redacted = "<redacted>"
+
+// RuntimeConfig is the effective configuration bosun is running with:
+// settings after defaults are applied, and the parsed alerts and
+// notifications. Secrets are redacted, so it can be shown to anyone
+// debugging bosun without access to the config file.
+type RuntimeConfig struct {
+	Name string
+	// Settings are the global settings by field name.
+	Settings      map[string]interface{}
+	Alerts        map[string]*RuntimeAlert
+	Notifications map[string]*RuntimeNotification
+	Templates     []string
+	Lookups       []string
+	Macros        []string
+	Probes        []string
+	Anomalies     []string
+	Tickets       []string
+	OnCalls       []string
+	SQLDatabases  []string
+	CloudWatch    []string
+	ElasticHosts  []string
+}
+
+// RuntimeAlert is an alert as parsed.
+type RuntimeAlert struct {
+	Name              string
+	Loc               string
+	Namespace         string `json:",omitempty"`
+	Template          string `json:",omitempty"`
+	Crit              string `json:",omitempty"`
+	Warn              string `json:",omitempty"`
+	Depends           string `json:",omitempty"`
+	CritNotification  []string
+	WarnNotification  []string
+	CritSeverity      Severity
+	WarnSeverity      Severity
+	Squelch           []string `json:",omitempty"`
+	Interval          string
+	Jitter            string `json:",omitempty"`
+	UnknownAfter      string
+	IgnoreUnknown     bool
+	Log               bool
+	MaxLogFrequency   string  `json:",omitempty"`
+	RenotifyWorsening float64 `json:",omitempty"`
+
+	// UnknownNotification is empty if unknowns go to CritNotification.
+	UnknownNotification []string `json:",omitempty"`
+}
+
+// RuntimeNotification is a notification as parsed.
+type RuntimeNotification struct {
+	Name         string
+	Namespace    string   `json:",omitempty"`
+	Email        []string `json:",omitempty"`
+	Post         string   `json:",omitempty"`
+	Get          string   `json:",omitempty"`
+	PagerDuty    string   `json:",omitempty"`
+	OnCall       string   `json:",omitempty"`
+	Print        bool
+	Next         string   `json:",omitempty"`
+	Timeout      string   `json:",omitempty"`
+	Members      []string `json:",omitempty"`
+	RoundRobin   bool
+	MinSeverity  Severity
+	MaxPerHour   int
+	RunOnActions bool
+	Retries      int
+	RetryBackoff string
+	RetryJitter  float64
+	SendTimeout  string
+}
+
+// redactedSettings are the global settings that hold secrets.
+var redactedSettings = map[string]bool{
+	"SMTPPassword":    true,
+	"HTTPAuth":        true,
+	"PublicAuth":      true,
+	"ShortURLKey":     true,
+	"GraphiteHeaders": true,
+}
+
+// Runtime returns the effective configuration of c.
+func (c *Conf) Runtime() *RuntimeConfig {
+	r := &RuntimeConfig{
+		Name:          c.Name,
+		Settings:      runtimeSettings(c),
+		Alerts:        make(map[string]*RuntimeAlert),
+		Notifications: make(map[string]*RuntimeNotification),
+	}
+	for name, a := range c.Alerts {
+		ra := &RuntimeAlert{
+			Name:              name,
+			Loc:               a.Loc,
+			Namespace:         a.Namespace,
+			Crit:              exprString(a.Crit),
+			Warn:              exprString(a.Warn),
+			Depends:           exprString(a.Depends),
+			CritNotification:  notificationNames(a.CritNotification),
+			WarnNotification:  notificationNames(a.WarnNotification),
+			CritSeverity:      a.CritSeverity,
+			WarnSeverity:      a.WarnSeverity,
+			Squelch:           a.squelch,
+			Interval:          c.AlertInterval(a).String(),
+			UnknownAfter:      a.Unknown.String(),
+			IgnoreUnknown:     a.IgnoreUnknown,
+			Log:               a.Log,
+			RenotifyWorsening: a.RenotifyWorsening,
+		}
+		if a.Template != nil {
+			ra.Template = a.Template.Name
+		}
+		if a.Unknown == 0 {
+			ra.UnknownAfter = c.CheckFrequency.String()
+		}
+		if a.Jitter != 0 {
+			ra.Jitter = a.Jitter.String()
+		}
+		if a.MaxLogFrequency != 0 {
+			ra.MaxLogFrequency = a.MaxLogFrequency.String()
+		}
+		if a.UnknownNotification != nil {
+			ra.UnknownNotification = notificationNames(a.UnknownNotification)
+		}
+		r.Alerts[name] = ra
+	}
+	for name, n := range c.Notifications {
+		rn := &RuntimeNotification{
+			Name:         name,
+			Namespace:    n.Namespace,
+			Print:        n.Print,
+			RoundRobin:   n.RoundRobin,
+			MinSeverity:  n.MinSeverity,
+			MaxPerHour:   n.MaxPerHour,
+			RunOnActions: n.RunOnActions,
+			Retries:      n.Retries,
+			RetryBackoff: n.RetryBackoff.String(),
+			RetryJitter:  n.RetryJitter,
+			SendTimeout:  n.SendTimeout.String(),
+		}
+		for _, a := range n.Email {
+			rn.Email = append(rn.Email, a.String())
+		}
+		rn.Post = redactURL(n.Post)
+		rn.Get = redactURL(n.Get)
+		if n.PagerDuty != "" {
+			rn.PagerDuty = Redacted
+		}
+		if n.OnCall != nil {
+			rn.OnCall = n.OnCall.Name
+		}
+		if n.Next != nil {
+			rn.Next = n.Next.Name
+			rn.Timeout = n.Timeout.String()
+		}
+		for _, m := range n.Members {
+			rn.Members = append(rn.Members, m.Name)
+		}
+		r.Notifications[name] = rn
+	}
+	for name := range c.Templates {
+		r.Templates = append(r.Templates, name)
+	}
+	for name := range c.Lookups {
+		r.Lookups = append(r.Lookups, name)
+	}
+	for name := range c.Macros {
+		r.Macros = append(r.Macros, name)
+	}
+	for name := range c.Probes {
+		r.Probes = append(r.Probes, name)
+	}
+	for name := range c.Anomalies {
+		r.Anomalies = append(r.Anomalies, name)
+	}
+	for name := range c.Tickets {
+		r.Tickets = append(r.Tickets, name)
+	}
+	for name := range c.OnCalls {
+		r.OnCalls = append(r.OnCalls, name)
+	}
+	for name := range c.SQLDatabases {
+		r.SQLDatabases = append(r.SQLDatabases, name)
+	}
+	for name := range c.CloudWatch {
+		r.CloudWatch = append(r.CloudWatch, name)
+	}
+	for name := range c.ElasticHosts {
+		r.ElasticHosts = append(r.ElasticHosts, name)
+	}
+	for _, names := range [][]string{r.Templates, r.Lookups, r.Macros, r.Probes, r.Anomalies, r.Tickets, r.OnCalls, r.SQLDatabases, r.CloudWatch, r.ElasticHosts} {
+		sort.Strings(names)
+	}
+	return r
+}
+
+// runtimeSettings returns the exported global settings of c whose values
+// are strings, numbers, booleans, durations, tags, or lists of them.
+// Sections, like alerts and notifications, are not included.
+func runtimeSettings(c *Conf) map[string]interface{} {
+	settings := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" || f.Name == "RawText" || f.Name == "Name" {
+			continue
+		}
+		fv := v.Field(i)
+		if redactedSettings[f.Name] {
+			if !fv.IsZero() {
+				settings[f.Name] = Redacted
+			}
+			continue
+		}
+		switch x := fv.Interface().(type) {
+		case time.Duration:
+			settings[f.Name] = x.String()
+			continue
+		case opentsdb.Duration:
+			settings[f.Name] = x.String()
+			continue
+		case opentsdb.TagSet:
+			if len(x) > 0 {
+				settings[f.Name] = x.String()
+			}
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+			settings[f.Name] = fv.Interface()
+		case reflect.Slice:
+			switch fv.Type().Elem().Kind() {
+			case reflect.String, reflect.Int:
+				settings[f.Name] = fv.Interface()
+			}
+		}
+	}
+	return settings
+}
+
+func exprString(e *expr.Expr) string {
+	if e == nil {
+		return ""
+	}
+	return e.String()
+}
+
+// notificationNames returns the names of the notifications in ns, and its
+// lookups as lookup("table", "key").
+func notificationNames(ns *Notifications) []string {
+	names := []string{}
+	for name := range ns.Notifications {
+		names = append(names, name)
+	}
+	for key, l := range ns.Lookups {
+		names = append(names, fmt.Sprintf("lookup(%q, %q)", l.Name, key))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// redactURL returns u without its user info or query string values, which
+// often hold credentials.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	r := *u
+	if r.User != nil {
+		r.User = url.User(Redacted)
+	}
+	if r.RawQuery != "" {
+		q := r.Query()
+		for k := range q {
+			q[k] = []string{Redacted}
+		}
+		r.RawQuery = q.Encode()
+	}
+	return strings.Replace(r.String(), url.QueryEscape(Redacted), Redacted, -1)
+}
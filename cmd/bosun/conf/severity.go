@@ -0,0 +1,73 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Severity ranks how urgently an abnormal alert needs attention, from info
+// ("look tomorrow") to emergency ("wake someone up"). Each alert maps its warn
+// and crit status to a severity, and notifications can require a minimum one.
+type Severity int
+
+const (
+	SevNone Severity = iota
+	SevInfo
+	SevWarn
+	SevError
+	SevCritical
+	SevEmergency
+)
+
+// Default severities of an alert's warn and crit status.
+const (
+	DefaultWarnSeverity = SevWarn
+	DefaultCritSeverity = SevCritical
+)
+
+var severityNames = map[Severity]string{
+	SevNone:      "none",
+	SevInfo:      "info",
+	SevWarn:      "warn",
+	SevError:     "error",
+	SevCritical:  "critical",
+	SevEmergency: "emergency",
+}
+
+func (s Severity) String() string {
+	if n, ok := severityNames[s]; ok {
+		return n
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s *Severity) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	if name == severityNames[SevNone] {
+		*s = SevNone
+		return nil
+	}
+	sev, err := ParseSeverity(name)
+	if err != nil {
+		return err
+	}
+	*s = sev
+	return nil
+}
+
+// ParseSeverity returns the severity named s.
+func ParseSeverity(s string) (Severity, error) {
+	for sev, n := range severityNames {
+		if sev != SevNone && n == s {
+			return sev, nil
+		}
+	}
+	return SevNone, fmt.Errorf("unknown severity %s, expected info, warn, error, critical, or emergency", s)
+}
@@ -0,0 +1,118 @@
+package conf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	ttemplate "text/template"
+
+	"bosun.org/cmd/bosun/conf/parse"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+// Ticket is an external ticketing system, like Jira or ServiceNow, that
+// incidents open tickets in once their severity reaches MinSeverity. The
+// tickets are closed when the incidents are.
+type Ticket struct {
+	Name        string
+	MinSeverity Severity
+	// Post is the URL tickets are created at. The JSON response has the
+	// ticket's id at IDField, a dotted path like result.sys_id.
+	Post    *url.URL
+	Body    *ttemplate.Template
+	IDField string
+	// Close is the URL a ticket is closed at with CloseMethod and
+	// CloseBody. They are rendered with the ticket's id.
+	Close       *ttemplate.Template
+	CloseMethod string
+	CloseBody   *ttemplate.Template
+	ContentType string
+	Headers     http.Header `json:"-"`
+}
+
+func (c *Conf) loadTicket(s *parse.SectionNode) {
+	name := s.Name.Text
+	if _, ok := c.Tickets[name]; ok {
+		c.errorf("duplicate ticket: %s", name)
+	}
+	if !opentsdb.ValidTag(name) {
+		c.errorf("invalid ticket name %s", name)
+	}
+	t := &Ticket{
+		Name:        name,
+		MinSeverity: SevCritical,
+		IDField:     "id",
+		CloseMethod: "POST",
+		ContentType: "application/json",
+		Headers:     make(http.Header),
+	}
+	funcs := ttemplate.FuncMap{
+		"json": func(v interface{}) string {
+			b, err := json.Marshal(v)
+			if err != nil {
+				slog.Errorln(err)
+			}
+			return string(b)
+		},
+	}
+	parseTemplate := func(v string) *ttemplate.Template {
+		tmpl, err := ttemplate.New(name).Funcs(funcs).Parse(v)
+		if err != nil {
+			c.error(err)
+		}
+		return tmpl
+	}
+	for _, pair := range c.getPairs(s, nil, sNormal) {
+		c.at(pair.node)
+		v := pair.val
+		switch pair.key {
+		case "minSeverity":
+			sev, err := ParseSeverity(v)
+			if err != nil {
+				c.error(err)
+			}
+			t.MinSeverity = sev
+		case "post":
+			u, err := url.Parse(v)
+			if err != nil {
+				c.error(err)
+			}
+			if u.Scheme != "http" && u.Scheme != "https" {
+				c.errorf("ticket post must be an http or https URL")
+			}
+			t.Post = u
+		case "body":
+			t.Body = parseTemplate(v)
+		case "idField":
+			t.IDField = v
+		case "close":
+			t.Close = parseTemplate(v)
+		case "closeMethod":
+			switch v {
+			case "POST", "PUT", "PATCH":
+				t.CloseMethod = v
+			default:
+				c.errorf("closeMethod must be POST, PUT, or PATCH")
+			}
+		case "closeBody":
+			t.CloseBody = parseTemplate(v)
+		case "contentType":
+			t.ContentType = v
+		case "header":
+			kv := strings.SplitN(v, ":", 2)
+			if len(kv) != 2 {
+				c.errorf("header must be in key:value form")
+			}
+			t.Headers.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		default:
+			c.errorf("unknown key %s", pair.key)
+		}
+	}
+	c.at(s)
+	if t.Post == nil || t.Body == nil || t.Close == nil {
+		c.errorf("ticket requires post, body, and close")
+	}
+	c.Tickets[name] = t
+}
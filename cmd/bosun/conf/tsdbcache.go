@@ -0,0 +1,68 @@
+package conf
+
+import (
+	"encoding/json"
+
+	"bosun.org/cmd/bosun/cache"
+	"bosun.org/opentsdb"
+)
+
+// defaultTSDBCacheSize is the TSDBCacheSize when it is not specified.
+const defaultTSDBCacheSize = 100 << 20
+
+// cacheContext is an OpenTSDB context whose results are cached. Requests are
+// keyed with their start and end times truncated to the cache's TTL, so
+// checks with the same query share a result until it expires.
+type cacheContext struct {
+	opentsdb.Context
+	host  string
+	cache *cache.TTL
+}
+
+func (c *cacheContext) Query(r *opentsdb.Request) (opentsdb.ResponseSet, error) {
+	key, err := c.key(r)
+	if err != nil {
+		return nil, err
+	}
+	v, err := c.cache.Get(key, func() (interface{}, int64, error) {
+		rs, err := c.Context.Query(r)
+		return rs, responseSize(rs), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(opentsdb.ResponseSet).Copy(), nil
+}
+
+func (c *cacheContext) key(r *opentsdb.Request) (string, error) {
+	k := *r
+	start, err := opentsdb.ParseTime(r.Start)
+	if err != nil {
+		return "", err
+	}
+	k.Start = start.Truncate(c.cache.Duration()).Unix()
+	if r.End != nil {
+		end, err := opentsdb.ParseTime(r.End)
+		if err != nil {
+			return "", err
+		}
+		k.End = end.Truncate(c.cache.Duration()).Unix()
+	}
+	b, err := json.Marshal(&k)
+	if err != nil {
+		return "", err
+	}
+	return c.host + string(b), nil
+}
+
+// responseSize estimates the memory used by rs.
+func responseSize(rs opentsdb.ResponseSet) int64 {
+	var size int64
+	for _, r := range rs {
+		size += int64(len(r.Metric)) + int64(len(r.DPS))*(int64(len("1234567890"))+24)
+		for k, v := range r.Tags {
+			size += int64(len(k) + len(v))
+		}
+	}
+	return size
+}
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+tempConfig:{hash} = text of a config tested on the rule page, expiring tempConfigTTL after it was last used
+
+alertVersions:{name} = list of json alert versions, newest first, at most maxAlertVersions
+
+*/
+
+// ConfigDataAccess stores configs tested on the rule page, so links to the
+// rule page can refer to them by hash, and the versions of each alert's
+// definition that were loaded.
+type ConfigDataAccess interface {
+	// PutTempConfig stores text under hash.
+	PutTempConfig(hash, text string) error
+	// GetTempConfig returns the text stored under hash, or "" if there is
+	// none, and keeps it for another tempConfigTTL.
+	GetTempConfig(hash string) (string, error)
+
+	// AddAlertVersion records v as the newest version of alert, unless it
+	// already is. It returns whether v was added.
+	AddAlertVersion(alert string, v *models.AlertVersion) (bool, error)
+	// GetAlertVersions returns the versions of alert, newest first.
+	GetAlertVersions(alert string) ([]*models.AlertVersion, error)
+}
+
+func (d *dataAccess) Configs() ConfigDataAccess {
+	return d
+}
+
+// tempConfigTTL is how long a temporary config is kept after it was last
+// stored or loaded.
+const tempConfigTTL = 90 * 24 * time.Hour
+
+func tempConfigKey(hash string) string {
+	return "tempConfig:" + hash
+}
+
+func (d *dataAccess) PutTempConfig(hash, text string) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutTempConfig"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	if _, err := conn.Do("SET", tempConfigKey(hash), text); err != nil {
+		return err
+	}
+	_, err := conn.Do("EXPIRE", tempConfigKey(hash), int64(tempConfigTTL/time.Second))
+	return err
+}
+
+func (d *dataAccess) GetTempConfig(hash string) (string, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetTempConfig"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	text, err := redis.String(conn.Do("GET", tempConfigKey(hash)))
+	if err == redis.ErrNil {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	_, err = conn.Do("EXPIRE", tempConfigKey(hash), int64(tempConfigTTL/time.Second))
+	return text, err
+}
+
+// maxAlertVersions is the number of versions of each alert kept.
+const maxAlertVersions = 100
+
+func alertVersionsKey(alert string) string {
+	return "alertVersions:" + alert
+}
+
+func (d *dataAccess) AddAlertVersion(alert string, v *models.AlertVersion) (bool, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AddAlertVersion"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("LINDEX", alertVersionsKey(alert), "0"))
+	if err != nil && err != redis.ErrNil {
+		return false, err
+	}
+	if err == nil {
+		last := &models.AlertVersion{}
+		if err := json.Unmarshal(b, last); err != nil {
+			return false, err
+		}
+		if last.Hash == v.Hash {
+			return false, nil
+		}
+	}
+	if b, err = json.Marshal(v); err != nil {
+		return false, err
+	}
+	if _, err := conn.Do("LPUSH", alertVersionsKey(alert), b); err != nil {
+		return false, err
+	}
+	// Ledis has no LTRIM.
+	n, err := redis.Int(conn.Do("LLEN", alertVersionsKey(alert)))
+	for ; err == nil && n > maxAlertVersions; n-- {
+		_, err = conn.Do("RPOP", alertVersionsKey(alert))
+	}
+	return true, err
+}
+
+func (d *dataAccess) GetAlertVersions(alert string) ([]*models.AlertVersion, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetAlertVersions"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	rows, err := redis.Strings(conn.Do("LRANGE", alertVersionsKey(alert), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]*models.AlertVersion, len(rows))
+	for i, row := range rows {
+		v := &models.AlertVersion{}
+		if err := json.Unmarshal([]byte(row), v); err != nil {
+			return nil, err
+		}
+		versions[i] = v
+	}
+	return versions, nil
+}
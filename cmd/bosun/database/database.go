@@ -4,7 +4,11 @@
 package database
 
 import (
+	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
@@ -20,6 +24,17 @@ type DataAccess interface {
 	Metadata() MetadataDataAccess
 	Search() SearchDataAccess
 	Errors() ErrorDataAccess
+	Deliveries() DeliveryDataAccess
+	AlertNotes() AlertNoteDataAccess
+	Incidents() IncidentDataAccess
+	Notifications() NotificationDataAccess
+	HA() HADataAccess
+	State() StateDataAccess
+	Events() EventDataAccess
+	Reasons() ReasonDataAccess
+	Configs() ConfigDataAccess
+	Profiles() ProfileDataAccess
+	Subscriptions() SubscriptionDataAccess
 }
 
 type MetadataDataAccess interface {
@@ -31,6 +46,11 @@ type MetadataDataAccess interface {
 	PutTagMetadata(tags opentsdb.TagSet, name string, value string, updated time.Time) error
 	GetTagMetadata(tags opentsdb.TagSet, name string) ([]*TagMetadata, error)
 	DeleteTagMetadata(tags opentsdb.TagSet, name string) error
+
+	// PutMetadataBatch stores ms as of updated, pipelining the writes. Entries
+	// named "desc", "rate", or "unit" are metric metadata, others are tag
+	// metadata.
+	PutMetadataBatch(ms []metadata.Metasend, updated time.Time) error
 }
 
 type SearchDataAccess interface {
@@ -46,11 +66,28 @@ type SearchDataAccess interface {
 	AddTagValue(metric, tagK, tagV string, time int64) error
 	GetTagValues(metric, tagK string) (map[string]int64, error)
 
+	// GetFirstSeenMetrics returns when each metric was first indexed, as
+	// Unix times, and GetFirstSeenTagValues when each value of tagK was.
+	// Those indexed before first seen times were kept are 0.
+	GetFirstSeenMetrics() (map[string]int64, error)
+	GetFirstSeenTagValues(tagK string) (map[string]int64, error)
+
 	AddMetricTagSet(metric, tagSet string, time int64) error
 	GetMetricTagSets(metric string, tags opentsdb.TagSet) (map[string]int64, error)
 
 	BackupLastInfos(map[string]map[string]*LastInfo) error
 	LoadLastInfos() (map[string]map[string]*LastInfo, error)
+
+	// PruneMetric removes index entries of metric last seen before the
+	// given time, and the metric itself if none remain. It returns the
+	// number of entries removed.
+	PruneMetric(metric string, before int64) (int, error)
+	// PruneTagKey removes values of tagK last seen before the given time
+	// from the index of all tag values, and metrics last seen with them
+	// before it from the metrics by tag index.
+	PruneTagKey(tagK string, before int64) (int, error)
+	// PurgeTagKey removes tagK from the index entirely.
+	PurgeTagKey(tagK string) (int, error)
 }
 
 type dataAccess struct {
@@ -60,12 +97,18 @@ type dataAccess struct {
 
 // Create a new data access object pointed at the specified address. isRedis parameter used to distinguish true redis from ledis in-proc.
 func NewDataAccess(addr string, isRedis bool) DataAccess {
-	return newDataAccess(addr, isRedis)
+	return newDataAccess([]string{addr}, isRedis)
+}
+
+// Create a new data access object that connects to the first reachable of hosts, tried in order.
+// A host of the form srv:name is resolved as a DNS SRV record each time a connection is made, and its targets are tried in priority order.
+func NewFailoverDataAccess(hosts []string, isRedis bool) DataAccess {
+	return newDataAccess(hosts, isRedis)
 }
 
-func newDataAccess(addr string, isRedis bool) *dataAccess {
+func newDataAccess(hosts []string, isRedis bool) *dataAccess {
 	return &dataAccess{
-		pool:    newPool(addr, "", 0, isRedis, 1000, true),
+		pool:    newPool(hosts, "", 0, isRedis, 1000, true),
 		isRedis: isRedis,
 	}
 }
@@ -95,14 +138,14 @@ func (d *dataAccess) GetConnection() redis.Conn {
 	return d.pool.Get()
 }
 
-func newPool(server, password string, database int, isRedis bool, maxActive int, wait bool) *redis.Pool {
+func newPool(hosts []string, password string, database int, isRedis bool, maxActive int, wait bool) *redis.Pool {
 	return &redis.Pool{
 		MaxIdle:     50,
 		MaxActive:   maxActive,
 		Wait:        wait,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", server, redis.DialDatabase(database))
+			c, err := dialHosts(hosts, redis.DialDatabase(database))
 			if err != nil {
 				return nil, err
 			}
@@ -123,6 +166,45 @@ func newPool(server, password string, database int, isRedis bool, maxActive int,
 	}
 }
 
+// dialTimeout is the connect timeout for each host, so an unreachable host
+// doesn't hold up failover to the next one.
+const dialTimeout = 5 * time.Second
+
+// dialHosts returns a connection to the first of hosts that accepts one.
+func dialHosts(hosts []string, options ...redis.DialOption) (redis.Conn, error) {
+	options = append(options, redis.DialConnectTimeout(dialTimeout))
+	err := fmt.Errorf("no redis hosts")
+	for _, h := range hosts {
+		addrs := []string{h}
+		if strings.HasPrefix(h, "srv:") {
+			if addrs, err = lookupSRV(strings.TrimPrefix(h, "srv:")); err != nil {
+				continue
+			}
+		}
+		for _, addr := range addrs {
+			var c redis.Conn
+			if c, err = redis.Dial("tcp", addr, options...); err == nil {
+				return c, nil
+			}
+		}
+	}
+	return nil, err
+}
+
+// lookupSRV returns the host:port targets of the SRV record name, sorted by
+// priority and randomized by weight.
+func lookupSRV(name string) ([]string, error) {
+	_, srvs, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, len(srvs))
+	for i, s := range srvs {
+		addrs[i] = net.JoinHostPort(strings.TrimSuffix(s.Target, "."), strconv.Itoa(int(s.Port)))
+	}
+	return addrs, nil
+}
+
 func init() {
 	collect.AggregateMeta("bosun.redis", metadata.MilliSecond, "time in milliseconds per redis call.")
 }
@@ -136,6 +218,13 @@ func (d *dataAccess) LCLEAR() string {
 	return "LCLEAR"
 }
 
+func (d *dataAccess) HCLEAR() string {
+	if d.isRedis {
+		return "DEL"
+	}
+	return "HCLEAR"
+}
+
 func (d *dataAccess) SCLEAR() string {
 	if d.isRedis {
 		return "DEL"
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+eventId = counter for event ids
+events = sorted set of json events, scored by unix time of the event
+
+*/
+
+// EventDataAccess stores events pushed by external systems.
+type EventDataAccess interface {
+	// PutEvent assigns e a new id and stores it. Events that happened more
+	// than ttl ago are removed.
+	PutEvent(e *models.Event, ttl time.Duration) error
+	// GetEvents returns the events of type typ that happened from start to
+	// end, oldest first. Events of all types are returned if typ is empty.
+	GetEvents(typ string, start, end time.Time) ([]*models.Event, error)
+}
+
+func (d *dataAccess) Events() EventDataAccess {
+	return d
+}
+
+const (
+	eventId = "eventId"
+	events  = "events"
+)
+
+func (d *dataAccess) PutEvent(e *models.Event, ttl time.Duration) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutEvent"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	id, err := redis.Int64(conn.Do("INCR", eventId))
+	if err != nil {
+		return err
+	}
+	e.Id = id
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("ZADD", events, e.Time.Unix(), data); err != nil {
+		return err
+	}
+	_, err = conn.Do("ZREMRANGEBYSCORE", events, "-inf", time.Now().Add(-ttl).Unix()-1)
+	return err
+}
+
+func (d *dataAccess) GetEvents(typ string, start, end time.Time) ([]*models.Event, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetEvents"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	values, err := redis.Strings(conn.Do("ZRANGEBYSCORE", events, start.Unix(), end.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	var es []*models.Event
+	for _, v := range values {
+		e := &models.Event{}
+		if err := json.Unmarshal([]byte(v), e); err != nil {
+			return nil, err
+		}
+		if typ != "" && e.Type != typ {
+			continue
+		}
+		if e.Time.Before(start) || e.Time.After(end) {
+			continue
+		}
+		es = append(es, e)
+	}
+	return es, nil
+}
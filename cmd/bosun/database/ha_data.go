@@ -0,0 +1,132 @@
+package database
+
+import (
+	"time"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+haLeader = id of the instance holding the leader lease, expiring with the lease
+
+*/
+
+// HADataAccess coordinates bosun instances sharing a redis server, so only
+// one of them checks alerts and sends notifications.
+type HADataAccess interface {
+	// AcquireLease takes the leader lease for id if it is free, or renews it
+	// if id already holds it. It returns whether id holds the lease.
+	AcquireLease(id string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up the lease if id holds it.
+	ReleaseLease(id string) error
+	// GetLeader returns the id holding the lease, or "" if it is free.
+	GetLeader() (string, error)
+}
+
+func (d *dataAccess) HA() HADataAccess {
+	return d
+}
+
+const haLeader = "haLeader"
+
+// leaseSeconds is ttl rounded up to seconds, which ledis and redis expiry
+// both support.
+func leaseSeconds(ttl time.Duration) int64 {
+	s := int64((ttl + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+var (
+	// renewLease extends the lease of ARGV[1] by ARGV[2] seconds, if it
+	// holds it.
+	renewLease = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("EXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// releaseLease deletes the lease if ARGV[1] holds it.
+	releaseLease = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+func (d *dataAccess) AcquireLease(id string, ttl time.Duration) (bool, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AcquireLease"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	secs := leaseSeconds(ttl)
+	if !d.isRedis {
+		return acquireLedisLease(conn, id, secs)
+	}
+	_, err := redis.String(conn.Do("SET", haLeader, id, "NX", "EX", secs))
+	if err == nil {
+		return true, nil
+	} else if err != redis.ErrNil {
+		return false, err
+	}
+	renewed, err := redis.Int(renewLease.Do(conn, haLeader, id, secs))
+	return renewed == 1, err
+}
+
+// acquireLedisLease is AcquireLease for ledis, which lacks SET options and
+// scripts. Ledis runs in process, so no other instance competes for the
+// lease.
+func acquireLedisLease(conn redis.Conn, id string, secs int64) (bool, error) {
+	set, err := redis.Int(conn.Do("SETNX", haLeader, id))
+	if err != nil {
+		return false, err
+	}
+	if set == 0 {
+		leader, err := redis.String(conn.Do("GET", haLeader))
+		if err == redis.ErrNil {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		if leader != id {
+			return false, nil
+		}
+	}
+	_, err = conn.Do("EXPIRE", haLeader, secs)
+	return err == nil, err
+}
+
+func (d *dataAccess) ReleaseLease(id string) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "ReleaseLease"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	if d.isRedis {
+		_, err := releaseLease.Do(conn, haLeader, id)
+		return err
+	}
+	leader, err := redis.String(conn.Do("GET", haLeader))
+	if err == redis.ErrNil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if leader != id {
+		return nil
+	}
+	_, err = conn.Do("DEL", haLeader)
+	return err
+}
+
+func (d *dataAccess) GetLeader() (string, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetLeader"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	leader, err := redis.String(conn.Do("GET", haLeader))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return leader, err
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+incidentNotes:{id} = list of json note objects for an incident, oldest first
+snapshotId = counter for snapshot ids
+snapshot:{id} = json snapshot object, shared by the incidents that opened in the same check
+incidentSnapshot:{id} = snapshot id of an incident
+
+*/
+
+type IncidentDataAccess interface {
+	AddIncidentNote(id uint64, note *models.IncidentNote) error
+	// GetIncidentNotes returns the notes of an incident, oldest first.
+	GetIncidentNotes(id uint64) ([]*models.IncidentNote, error)
+	// SetIncidentNotes replaces the notes of an incident with notes.
+	SetIncidentNotes(id uint64, notes []*models.IncidentNote) error
+	// SetIncidentSnapshot stores snapshot as the snapshot of incidents ids.
+	SetIncidentSnapshot(snapshot *models.IncidentSnapshot, ids []uint64) error
+	// GetIncidentSnapshot returns the snapshot of an incident, or nil if it has none.
+	GetIncidentSnapshot(id uint64) (*models.IncidentSnapshot, error)
+}
+
+func (d *dataAccess) Incidents() IncidentDataAccess {
+	return d
+}
+
+func incidentNotesKey(id uint64) string {
+	return fmt.Sprintf("incidentNotes:%d", id)
+}
+
+func (d *dataAccess) AddIncidentNote(id uint64, note *models.IncidentNote) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AddIncidentNote"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("RPUSH", incidentNotesKey(id), b)
+	return err
+}
+
+func (d *dataAccess) SetIncidentNotes(id uint64, notes []*models.IncidentNote) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "SetIncidentNotes"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	args := []interface{}{incidentNotesKey(id)}
+	for _, note := range notes {
+		b, err := json.Marshal(note)
+		if err != nil {
+			return err
+		}
+		args = append(args, b)
+	}
+	if _, err := conn.Do(d.LCLEAR(), incidentNotesKey(id)); err != nil {
+		return err
+	}
+	if len(notes) == 0 {
+		return nil
+	}
+	_, err := conn.Do("RPUSH", args...)
+	return err
+}
+
+func (d *dataAccess) GetIncidentNotes(id uint64) ([]*models.IncidentNote, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetIncidentNotes"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	vals, err := redis.Strings(conn.Do("LRANGE", incidentNotesKey(id), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	notes := make([]*models.IncidentNote, len(vals))
+	for i, v := range vals {
+		note := &models.IncidentNote{}
+		if err := json.Unmarshal([]byte(v), note); err != nil {
+			return nil, err
+		}
+		notes[i] = note
+	}
+	return notes, nil
+}
+
+const snapshotId = "snapshotId"
+
+func snapshotKey(id int64) string {
+	return fmt.Sprintf("snapshot:%d", id)
+}
+
+func incidentSnapshotKey(id uint64) string {
+	return fmt.Sprintf("incidentSnapshot:%d", id)
+}
+
+func (d *dataAccess) SetIncidentSnapshot(snapshot *models.IncidentSnapshot, ids []uint64) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "SetIncidentSnapshot"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	sid, err := redis.Int64(conn.Do("INCR", snapshotId))
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("SET", snapshotKey(sid), b); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := conn.Do("SET", incidentSnapshotKey(id), sid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dataAccess) GetIncidentSnapshot(id uint64) (*models.IncidentSnapshot, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetIncidentSnapshot"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	sid, err := redis.Int64(conn.Do("GET", incidentSnapshotKey(id)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	b, err := redis.Bytes(conn.Do("GET", snapshotKey(sid)))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	snapshot := &models.IncidentSnapshot{}
+	if err := json.Unmarshal(b, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
 	"bosun.org/collect"
+	"bosun.org/metadata"
 	"bosun.org/opentsdb"
 )
 
@@ -29,9 +30,13 @@ func (d *dataAccess) Metadata() MetadataDataAccess {
 	return d
 }
 
+func isMetricMetaField(field string) bool {
+	return field == "desc" || field == "unit" || field == "rate"
+}
+
 func (d *dataAccess) PutMetricMetadata(metric string, field string, value string) error {
 	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutMetricMeta"})()
-	if field != "desc" && field != "unit" && field != "rate" {
+	if !isMetricMetaField(field) {
 		return fmt.Errorf("Unknown metric metadata field: %s", field)
 	}
 	conn := d.GetConnection()
@@ -40,6 +45,94 @@ func (d *dataAccess) PutMetricMetadata(metric string, field string, value string
 	return err
 }
 
+// metadataBatchSize is the number of commands PutMetadataBatch sends before
+// reading their replies.
+const metadataBatchSize = 500
+
+func (d *dataAccess) PutMetadataBatch(ms []metadata.Metasend, updated time.Time) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutMetadataBatch"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	pending := 0
+	flush := func() error {
+		if pending == 0 {
+			return nil
+		}
+		pending = 0
+		replies, err := redis.Values(conn.Do(""))
+		if err != nil {
+			return err
+		}
+		for _, r := range replies {
+			if err, ok := r.(redis.Error); ok {
+				return err
+			}
+		}
+		return nil
+	}
+	send := func(cmd string, args ...interface{}) error {
+		if err := conn.Send(cmd, args...); err != nil {
+			return err
+		}
+		pending++
+		if pending < metadataBatchSize {
+			return nil
+		}
+		return flush()
+	}
+	// Read the stored tag metadata so only changes are written.
+	var keys []interface{}
+	for _, m := range ms {
+		if !isMetricMetaField(m.Name) {
+			keys = append(keys, tagMetaKey(m.Tags, m.Name))
+		}
+	}
+	stored := make(map[string]string, len(keys))
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > metadataBatchSize {
+			n = metadataBatchSize
+		}
+		values, err := redis.Strings(conn.Do("MGET", keys[:n]...))
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			stored[keys[i].(string)] = v
+		}
+		keys = keys[n:]
+	}
+	for _, m := range ms {
+		value := fmt.Sprint(m.Value)
+		if isMetricMetaField(m.Name) {
+			if err := send("HMSET", metricMetaKey(m.Metric), m.Name, value, "lastTouched", updated.UTC().Unix()); err != nil {
+				return err
+			}
+			continue
+		}
+		key := tagMetaKey(m.Tags, m.Name)
+		write, changed := tagMetaWrite(stored[key], value, updated)
+		if !write {
+			continue
+		}
+		keyValue := fmt.Sprintf("%d:%s", updated.UTC().Unix(), value)
+		// Later entries for the same key compare against this one.
+		stored[key] = keyValue
+		if err := send("SET", key, keyValue); err != nil {
+			return err
+		}
+		if !changed {
+			continue
+		}
+		for tagK, tagV := range m.Tags {
+			if err := send("SADD", tagMetaIdxKey(tagK, tagV), key); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
 func (d *dataAccess) GetMetricMetadata(metric string) (*MetricMetadata, error) {
 	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetMetricMeta"})()
 	conn := d.GetConnection()
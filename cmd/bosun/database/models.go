@@ -12,9 +12,13 @@ type MetricMetadata struct {
 }
 
 type TagMetadata struct {
-	Tags        opentsdb.TagSet
-	Name        string
-	Value       string
+	Tags  opentsdb.TagSet
+	Name  string
+	Value string
+	// LastTouched is the unix time the value was last written. Unchanged
+	// values are only rewritten every tagMetaTouchInterval, so it is coarse:
+	// the value may have been sent up to a day later. Record the time in a
+	// value of its own where it must be exact.
 	LastTouched int64
 }
 
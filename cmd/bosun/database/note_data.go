@@ -0,0 +1,87 @@
+package database
+
+import (
+	"encoding/json"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+alertNotes = hash of alert name to json note object
+
+*/
+
+type AlertNoteDataAccess interface {
+	SetAlertNote(alert string, note *models.AlertNote) error
+	// GetAlertNote returns the note for an alert, or nil if it has none.
+	GetAlertNote(alert string) (*models.AlertNote, error)
+	GetAlertNotes() (map[string]*models.AlertNote, error)
+	ClearAlertNote(alert string) error
+}
+
+func (d *dataAccess) AlertNotes() AlertNoteDataAccess {
+	return d
+}
+
+const alertNotes = "alertNotes"
+
+func (d *dataAccess) SetAlertNote(alert string, note *models.AlertNote) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "SetAlertNote"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := json.Marshal(note)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", alertNotes, alert, b)
+	return err
+}
+
+func (d *dataAccess) GetAlertNote(alert string) (*models.AlertNote, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetAlertNote"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("HGET", alertNotes, alert))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	note := &models.AlertNote{}
+	if err := json.Unmarshal(b, note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (d *dataAccess) GetAlertNotes() (map[string]*models.AlertNote, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetAlertNotes"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	m, err := redis.StringMap(conn.Do("HGETALL", alertNotes))
+	if err != nil {
+		return nil, err
+	}
+	notes := make(map[string]*models.AlertNote, len(m))
+	for alert, v := range m {
+		note := &models.AlertNote{}
+		if err := json.Unmarshal([]byte(v), note); err != nil {
+			return nil, err
+		}
+		notes[alert] = note
+	}
+	return notes, nil
+}
+
+func (d *dataAccess) ClearAlertNote(alert string) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "ClearAlertNote"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	_, err := conn.Do("HDEL", alertNotes, alert)
+	return err
+}
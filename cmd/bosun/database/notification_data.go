@@ -0,0 +1,335 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+deliveryId = counter for delivery ids
+delivery:{id} = json object for a single notification delivery
+deliveryLog = sorted set of delivery ids, scored by id
+deliveryRetries = sorted set of delivery ids awaiting retry, scored by unix time of next attempt
+
+notifications:{ak} = hash of notification name to unix time it is next due for an alert key
+pendingNotifications = sorted set of alert keys with notifications, scored by unix time of the soonest one
+
+*/
+
+// maxDeliveryLog is the number of deliveries kept in the log. Older
+// deliveries are removed as new ones are queued.
+const maxDeliveryLog = 1000
+
+type DeliveryDataAccess interface {
+	// QueueDelivery assigns d a new id and stores it.
+	QueueDelivery(d *models.NotificationDelivery) error
+	// UpdateDelivery stores d, scheduling it for retry if it is pending.
+	UpdateDelivery(d *models.NotificationDelivery) error
+	GetDelivery(id int64) (*models.NotificationDelivery, error)
+	// GetDeliveryLog returns up to count of the most recent deliveries, newest first.
+	GetDeliveryLog(count int) ([]*models.NotificationDelivery, error)
+	// GetDueDeliveries returns pending deliveries whose next attempt is at or before t.
+	GetDueDeliveries(t time.Time) ([]*models.NotificationDelivery, error)
+}
+
+func (d *dataAccess) Deliveries() DeliveryDataAccess {
+	return d
+}
+
+// NotificationDataAccess tracks notifications waiting for their timeout
+// before being sent (or sent again) for an alert key.
+type NotificationDataAccess interface {
+	// InsertNotification schedules the named notification to be sent for ak at due.
+	InsertNotification(ak, notification string, due time.Time) error
+	// GetDueNotifications returns the notifications due at or before t, by alert key and notification name.
+	GetDueNotifications(t time.Time) (map[string]map[string]time.Time, error)
+	// GetNotifications returns all scheduled notifications, by alert key and notification name.
+	GetNotifications() (map[string]map[string]time.Time, error)
+	// GetNextNotificationTime returns when the soonest notification is due, or the zero time if there are none.
+	GetNextNotificationTime() (time.Time, error)
+	HasNotifications(ak string) (bool, error)
+	ClearNotification(ak, notification string) error
+	ClearNotifications(ak string) error
+}
+
+func (d *dataAccess) Notifications() NotificationDataAccess {
+	return d
+}
+
+const (
+	deliveryId           = "deliveryId"
+	deliveryLog          = "deliveryLog"
+	deliveryRetries      = "deliveryRetries"
+	pendingNotifications = "pendingNotifications"
+)
+
+func deliveryKey(id int64) string {
+	return fmt.Sprintf("delivery:%d", id)
+}
+
+func (d *dataAccess) QueueDelivery(n *models.NotificationDelivery) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "QueueDelivery"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	id, err := redis.Int64(conn.Do("INCR", deliveryId))
+	if err != nil {
+		return err
+	}
+	n.Id = id
+	if err := d.setDelivery(conn, n); err != nil {
+		return err
+	}
+	if _, err := conn.Do("ZADD", deliveryLog, id, id); err != nil {
+		return err
+	}
+	// drop the oldest deliveries beyond maxDeliveryLog
+	old, err := int64s(conn.Do("ZRANGE", deliveryLog, 0, -maxDeliveryLog-1))
+	if err != nil {
+		return err
+	}
+	for _, o := range old {
+		if _, err := conn.Do("DEL", deliveryKey(o)); err != nil {
+			return err
+		}
+		if _, err := conn.Do("ZREM", deliveryRetries, o); err != nil {
+			return err
+		}
+	}
+	if len(old) > 0 {
+		if _, err := conn.Do("ZREMRANGEBYRANK", deliveryLog, 0, -maxDeliveryLog-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dataAccess) UpdateDelivery(n *models.NotificationDelivery) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "UpdateDelivery"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	return d.setDelivery(conn, n)
+}
+
+func (d *dataAccess) setDelivery(conn redis.Conn, n *models.NotificationDelivery) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("SET", deliveryKey(n.Id), b); err != nil {
+		return err
+	}
+	if n.Status == models.DeliveryPending && !n.NextAttempt.IsZero() {
+		_, err = conn.Do("ZADD", deliveryRetries, n.NextAttempt.Unix(), n.Id)
+	} else {
+		_, err = conn.Do("ZREM", deliveryRetries, n.Id)
+	}
+	return err
+}
+
+func (d *dataAccess) GetDelivery(id int64) (*models.NotificationDelivery, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetDelivery"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	return d.getDelivery(conn, id)
+}
+
+func int64s(reply interface{}, err error) ([]int64, error) {
+	strs, err := redis.Strings(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	ints := make([]int64, len(strs))
+	for i, s := range strs {
+		if ints[i], err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	return ints, nil
+}
+
+func (d *dataAccess) getDelivery(conn redis.Conn, id int64) (*models.NotificationDelivery, error) {
+	b, err := redis.Bytes(conn.Do("GET", deliveryKey(id)))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, fmt.Errorf("unknown delivery: %d", id)
+		}
+		return nil, err
+	}
+	n := &models.NotificationDelivery{}
+	if err := json.Unmarshal(b, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (d *dataAccess) getDeliveries(conn redis.Conn, ids []int64) ([]*models.NotificationDelivery, error) {
+	list := make([]*models.NotificationDelivery, 0, len(ids))
+	for _, id := range ids {
+		n, err := d.getDelivery(conn, id)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (d *dataAccess) GetDeliveryLog(count int) ([]*models.NotificationDelivery, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetDeliveryLog"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	ids, err := int64s(conn.Do("ZREVRANGE", deliveryLog, 0, count-1))
+	if err != nil {
+		return nil, err
+	}
+	return d.getDeliveries(conn, ids)
+}
+
+func (d *dataAccess) GetDueDeliveries(t time.Time) ([]*models.NotificationDelivery, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetDueDeliveries"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	ids, err := int64s(conn.Do("ZRANGEBYSCORE", deliveryRetries, "-inf", t.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	return d.getDeliveries(conn, ids)
+}
+
+func notificationsKey(ak string) string {
+	return fmt.Sprintf("notifications:%s", ak)
+}
+
+func (d *dataAccess) InsertNotification(ak, notification string, due time.Time) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "InsertNotification"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	if _, err := conn.Do("HSET", notificationsKey(ak), notification, due.Unix()); err != nil {
+		return err
+	}
+	return d.updatePending(conn, ak)
+}
+
+// updatePending scores ak in pendingNotifications by its soonest
+// notification, or removes it if it has none.
+func (d *dataAccess) updatePending(conn redis.Conn, ak string) error {
+	ns, err := stringInt64Map(conn.Do("HGETALL", notificationsKey(ak)))
+	if err != nil {
+		return err
+	}
+	if len(ns) == 0 {
+		_, err = conn.Do("ZREM", pendingNotifications, ak)
+		return err
+	}
+	var min int64
+	first := true
+	for _, due := range ns {
+		if first || due < min {
+			min = due
+			first = false
+		}
+	}
+	_, err = conn.Do("ZADD", pendingNotifications, min, ak)
+	return err
+}
+
+func (d *dataAccess) GetDueNotifications(t time.Time) (map[string]map[string]time.Time, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetDueNotifications"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	aks, err := redis.Strings(conn.Do("ZRANGEBYSCORE", pendingNotifications, "-inf", t.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	due := make(map[string]map[string]time.Time)
+	for _, ak := range aks {
+		ns, err := stringInt64Map(conn.Do("HGETALL", notificationsKey(ak)))
+		if err != nil {
+			return nil, err
+		}
+		for name, ts := range ns {
+			if ts > t.Unix() {
+				continue
+			}
+			if due[ak] == nil {
+				due[ak] = make(map[string]time.Time)
+			}
+			due[ak][name] = time.Unix(ts, 0).UTC()
+		}
+	}
+	return due, nil
+}
+
+func (d *dataAccess) GetNotifications() (map[string]map[string]time.Time, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetNotifications"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	aks, err := redis.Strings(conn.Do("ZRANGE", pendingNotifications, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]map[string]time.Time, len(aks))
+	for _, ak := range aks {
+		ns, err := stringInt64Map(conn.Do("HGETALL", notificationsKey(ak)))
+		if err != nil {
+			return nil, err
+		}
+		all[ak] = make(map[string]time.Time, len(ns))
+		for name, ts := range ns {
+			all[ak][name] = time.Unix(ts, 0).UTC()
+		}
+	}
+	return all, nil
+}
+
+func (d *dataAccess) GetNextNotificationTime() (time.Time, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetNextNotificationTime"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	m, err := stringInt64Map(conn.Do("ZRANGE", pendingNotifications, 0, 0, "WITHSCORES"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, ts := range m {
+		return time.Unix(ts, 0).UTC(), nil
+	}
+	return time.Time{}, nil
+}
+
+func (d *dataAccess) HasNotifications(ak string) (bool, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "HasNotifications"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	n, err := redis.Int(conn.Do("HLEN", notificationsKey(ak)))
+	return n > 0, err
+}
+
+func (d *dataAccess) ClearNotification(ak, notification string) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "ClearNotification"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	if _, err := conn.Do("HDEL", notificationsKey(ak), notification); err != nil {
+		return err
+	}
+	return d.updatePending(conn, ak)
+}
+
+func (d *dataAccess) ClearNotifications(ak string) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "ClearNotifications"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	if _, err := conn.Do(d.HCLEAR(), notificationsKey(ak)); err != nil {
+		return err
+	}
+	_, err := conn.Do("ZREM", pendingNotifications, ak)
+	return err
+}
@@ -0,0 +1,59 @@
+package database
+
+import (
+	"encoding/json"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+alertProfiles = hash of alert name to json profile of its last check
+
+*/
+
+type ProfileDataAccess interface {
+	SetAlertProfile(alert string, p *models.ExprProfile) error
+	// GetAlertProfile returns the profile of an alert's last check, or nil
+	// if it has none.
+	GetAlertProfile(alert string) (*models.ExprProfile, error)
+}
+
+func (d *dataAccess) Profiles() ProfileDataAccess {
+	return d
+}
+
+const alertProfiles = "alertProfiles"
+
+func (d *dataAccess) SetAlertProfile(alert string, p *models.ExprProfile) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "SetAlertProfile"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", alertProfiles, alert, b)
+	return err
+}
+
+func (d *dataAccess) GetAlertProfile(alert string) (*models.ExprProfile, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetAlertProfile"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := redis.Bytes(conn.Do("HGET", alertProfiles, alert))
+	if err != nil {
+		if err == redis.ErrNil {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &models.ExprProfile{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,77 @@
+package database
+
+import (
+	"encoding/json"
+	"time"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+reasonActions = sorted set of json close and forget actions, scored by unix time of the action
+
+*/
+
+// ReasonDataAccess stores the close and forget actions taken on alerts.
+type ReasonDataAccess interface {
+	// AddReasonAction stores a. Actions older than ReasonRetention are
+	// removed.
+	AddReasonAction(a *models.ReasonAction) error
+	// GetReasonActions returns the actions on alert taken from start to
+	// end, oldest first. Actions on all alerts are returned if alert is
+	// empty.
+	GetReasonActions(alert string, start, end time.Time) ([]*models.ReasonAction, error)
+}
+
+func (d *dataAccess) Reasons() ReasonDataAccess {
+	return d
+}
+
+const reasonActions = "reasonActions"
+
+// ReasonRetention is how long close and forget actions are kept.
+const ReasonRetention = 366 * 24 * time.Hour
+
+func (d *dataAccess) AddReasonAction(a *models.ReasonAction) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AddReasonAction"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("ZADD", reasonActions, a.Time.Unix(), data); err != nil {
+		return err
+	}
+	_, err = conn.Do("ZREMRANGEBYSCORE", reasonActions, "-inf", time.Now().Add(-ReasonRetention).Unix()-1)
+	return err
+}
+
+func (d *dataAccess) GetReasonActions(alert string, start, end time.Time) ([]*models.ReasonAction, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetReasonActions"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	values, err := redis.Strings(conn.Do("ZRANGEBYSCORE", reasonActions, start.Unix(), end.Unix()))
+	if err != nil {
+		return nil, err
+	}
+	var as []*models.ReasonAction
+	for _, v := range values {
+		a := &models.ReasonAction{}
+		if err := json.Unmarshal([]byte(v), a); err != nil {
+			return nil, err
+		}
+		if alert != "" && a.Alert != alert {
+			continue
+		}
+		if a.Time.Before(start) || a.Time.After(end) {
+			continue
+		}
+		as = append(as, a)
+	}
+	return as, nil
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 
 	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
@@ -25,10 +26,15 @@ metric "__all__" is a special key that will hold all values for the tag key, reg
 
 All Metrics:
 search:allMetrics -> hash of metric name to timestamp
+
+First seen times, 0 for those indexed before they were kept:
+search:firstSeen:metrics -> hash of metric name to timestamp
+search:firstSeen:tagv:{tagk} -> hash of tag value to timestamp
 */
 
 const Search_All = "__all__"
 const searchAllMetricsKey = "search:allMetrics"
+const searchFirstSeenMetricsKey = "search:firstSeen:metrics"
 
 func searchMetricKey(tagK, tagV string) string {
 	return fmt.Sprintf("search:metrics:%s=%s", tagK, tagV)
@@ -42,6 +48,9 @@ func searchTagvKey(metric, tagK string) string {
 func searchMetricTagSetKey(metric string) string {
 	return fmt.Sprintf("search:mts:%s", metric)
 }
+func searchFirstSeenTagvKey(tagK string) string {
+	return fmt.Sprintf("search:firstSeen:tagv:%s", tagK)
+}
 
 func (d *dataAccess) Search() SearchDataAccess {
 	return d
@@ -99,9 +108,37 @@ func (d *dataAccess) AddMetric(metric string, time int64) error {
 	conn := d.GetConnection()
 	defer conn.Close()
 
+	if err := d.setFirstSeen(conn, searchAllMetricsKey, searchFirstSeenMetricsKey, metric, time); err != nil {
+		return err
+	}
 	_, err := conn.Do("HSET", searchAllMetricsKey, metric, time)
 	return err
 }
+
+// setFirstSeen records field of the first seen hash firstKey as first seen at
+// time, unless it already has a first seen time. Fields already in the last
+// seen hash lastKey were first seen before first seen times were kept, and
+// are recorded as 0.
+func (d *dataAccess) setFirstSeen(conn redis.Conn, lastKey, firstKey, field string, time int64) error {
+	if _, err := redis.Int64(conn.Do("HGET", firstKey, field)); err != redis.ErrNil {
+		return err
+	}
+	if _, err := redis.Int64(conn.Do("HGET", lastKey, field)); err == nil {
+		time = 0
+	} else if err != redis.ErrNil {
+		return err
+	}
+	_, err := conn.Do("HSET", firstKey, field, time)
+	return err
+}
+
+func (d *dataAccess) GetFirstSeenMetrics() (map[string]int64, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetFirstSeenMetrics"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	return stringInt64Map(conn.Do("HGETALL", searchFirstSeenMetricsKey))
+}
 func (d *dataAccess) GetAllMetrics() (map[string]int64, error) {
 	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetAllMetrics"})()
 	conn := d.GetConnection()
@@ -115,9 +152,22 @@ func (d *dataAccess) AddTagValue(metric, tagK, tagV string, time int64) error {
 	conn := d.GetConnection()
 	defer conn.Close()
 
+	if metric == Search_All {
+		if err := d.setFirstSeen(conn, searchTagvKey(metric, tagK), searchFirstSeenTagvKey(tagK), tagV, time); err != nil {
+			return err
+		}
+	}
 	_, err := conn.Do("HSET", searchTagvKey(metric, tagK), tagV, time)
 	return err
 }
+
+func (d *dataAccess) GetFirstSeenTagValues(tagK string) (map[string]int64, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetFirstSeenTagValues"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	return stringInt64Map(conn.Do("HGETALL", searchFirstSeenTagvKey(tagK)))
+}
 func (d *dataAccess) GetTagValues(metric, tagK string) (map[string]int64, error) {
 	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetTagValues"})()
 	conn := d.GetConnection()
@@ -184,3 +234,174 @@ func (d *dataAccess) LoadLastInfos() (map[string]map[string]*LastInfo, error) {
 	}
 	return m, nil
 }
+
+// pruneHash removes the fields of the hash at key with timestamps before
+// before. It returns the removed fields and the number remaining.
+func (d *dataAccess) pruneHash(conn redis.Conn, key string, before int64) ([]string, int, error) {
+	m, err := stringInt64Map(conn.Do("HGETALL", key))
+	if err != nil {
+		return nil, 0, err
+	}
+	var removed []string
+	for f, t := range m {
+		if t < before {
+			removed = append(removed, f)
+		}
+	}
+	left := len(m) - len(removed)
+	switch {
+	case len(removed) == 0:
+		return nil, left, nil
+	case left == 0:
+		_, err = conn.Do(d.HCLEAR(), key)
+	default:
+		args := []interface{}{key}
+		for _, f := range removed {
+			args = append(args, f)
+		}
+		_, err = conn.Do("HDEL", args...)
+	}
+	sort.Strings(removed)
+	return removed, left, err
+}
+
+func (d *dataAccess) PruneMetric(metric string, before int64) (int, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PruneMetric"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	tagks, err := stringInt64Map(conn.Do("HGETALL", searchTagkKey(metric)))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for tagK := range tagks {
+		tagvs, left, err := d.pruneHash(conn, searchTagvKey(metric, tagK), before)
+		if err != nil {
+			return removed, err
+		}
+		for _, tagV := range tagvs {
+			if _, err := conn.Do("HDEL", searchMetricKey(tagK, tagV), metric); err != nil {
+				return removed, err
+			}
+		}
+		removed += len(tagvs)
+		if left == 0 {
+			if _, err := conn.Do("HDEL", searchTagkKey(metric), tagK); err != nil {
+				return removed, err
+			}
+			delete(tagks, tagK)
+			removed++
+		}
+	}
+	tagsets, _, err := d.pruneHash(conn, searchMetricTagSetKey(metric), before)
+	if err != nil {
+		return removed, err
+	}
+	removed += len(tagsets)
+	if len(tagks) > 0 {
+		return removed, nil
+	}
+	seen, err := redis.Int64(conn.Do("HGET", searchAllMetricsKey, metric))
+	if err != nil && err != redis.ErrNil {
+		return removed, err
+	}
+	if seen < before {
+		if _, err := conn.Do("HDEL", searchAllMetricsKey, metric); err != nil {
+			return removed, err
+		}
+		if _, err := conn.Do("HDEL", searchFirstSeenMetricsKey, metric); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+func (d *dataAccess) PruneTagKey(tagK string, before int64) (int, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PruneTagKey"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	tagvs, err := stringInt64Map(conn.Do("HGETALL", searchTagvKey(Search_All, tagK)))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for tagV, t := range tagvs {
+		metrics, _, err := d.pruneHash(conn, searchMetricKey(tagK, tagV), before)
+		if err != nil {
+			return removed, err
+		}
+		removed += len(metrics)
+		if t < before {
+			if _, err := conn.Do("HDEL", searchTagvKey(Search_All, tagK), tagV); err != nil {
+				return removed, err
+			}
+			if _, err := conn.Do("HDEL", searchFirstSeenTagvKey(tagK), tagV); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (d *dataAccess) PurgeTagKey(tagK string) (int, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PurgeTagKey"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+
+	metrics, err := stringInt64Map(conn.Do("HGETALL", searchAllMetricsKey))
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for metric := range metrics {
+		n, err := redis.Int(conn.Do("HDEL", searchTagkKey(metric), tagK))
+		if err != nil {
+			return removed, err
+		}
+		if n == 0 {
+			continue
+		}
+		removed++
+		if _, err := conn.Do(d.HCLEAR(), searchTagvKey(metric, tagK)); err != nil {
+			return removed, err
+		}
+		tagsets, err := stringInt64Map(conn.Do("HGETALL", searchMetricTagSetKey(metric)))
+		if err != nil {
+			return removed, err
+		}
+		for tagset := range tagsets {
+			ts, err := opentsdb.ParseTags(tagset)
+			if err != nil {
+				return removed, err
+			}
+			if _, ok := ts[tagK]; !ok {
+				continue
+			}
+			if _, err := conn.Do("HDEL", searchMetricTagSetKey(metric), tagset); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	tagvs, err := stringInt64Map(conn.Do("HGETALL", searchTagvKey(Search_All, tagK)))
+	if err != nil {
+		return removed, err
+	}
+	for tagV := range tagvs {
+		if _, err := conn.Do(d.HCLEAR(), searchMetricKey(tagK, tagV)); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	if _, err := conn.Do(d.HCLEAR(), searchTagvKey(Search_All, tagK)); err != nil {
+		return removed, err
+	}
+	if _, err := conn.Do(d.HCLEAR(), searchFirstSeenTagvKey(tagK)); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
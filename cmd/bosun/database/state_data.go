@@ -0,0 +1,176 @@
+package database
+
+import (
+	"encoding/json"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+alertStates = hash of alert key to its encoded state
+schedObjects = hash of schedule object name (silences, incidents, ...) to its encoded value
+cycleSummaries = list of json CycleSummary, newest first
+
+*/
+
+// StateDataAccess stores the state of the schedule. The state of each alert
+// key is its own record, so changed states can be written without rewriting
+// all of them. Other objects, like silences and incidents, are stored whole.
+type StateDataAccess interface {
+	// PutStates stores the encoded state of each alert key, removing those
+	// with a nil value.
+	PutStates(states map[string][]byte) error
+	GetStates() (map[string][]byte, error)
+
+	// PutObjects stores encoded schedule objects by name, leaving other
+	// objects as they are.
+	PutObjects(objects map[string][]byte) error
+	GetObjects() (map[string][]byte, error)
+
+	// AddCycleSummary records c as the newest check cycle summary.
+	AddCycleSummary(c *models.CycleSummary) error
+	// GetCycleSummaries returns the last n check cycle summaries, newest
+	// first.
+	GetCycleSummaries(n int) ([]*models.CycleSummary, error)
+}
+
+func (d *dataAccess) State() StateDataAccess {
+	return d
+}
+
+const (
+	alertStates    = "alertStates"
+	schedObjects   = "schedObjects"
+	cycleSummaries = "cycleSummaries"
+)
+
+// maxCycleSummaries is the number of check cycle summaries kept, a day of
+// cycles at the default checkFrequency.
+const maxCycleSummaries = 1440
+
+func (d *dataAccess) PutStates(states map[string][]byte) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutStates"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	set := []interface{}{alertStates}
+	del := []interface{}{alertStates}
+	for ak, data := range states {
+		if data == nil {
+			del = append(del, ak)
+		} else {
+			set = append(set, ak, data)
+		}
+	}
+	if len(set) > 1 {
+		if err := conn.Send("HMSET", set...); err != nil {
+			return err
+		}
+	}
+	if len(del) > 1 {
+		if err := conn.Send("HDEL", del...); err != nil {
+			return err
+		}
+	}
+	replies, err := redis.Values(conn.Do(""))
+	if err != nil {
+		return err
+	}
+	for _, r := range replies {
+		if err, ok := r.(redis.Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *dataAccess) GetStates() (map[string][]byte, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetStates"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	return bytesMap(conn.Do("HGETALL", alertStates))
+}
+
+func (d *dataAccess) PutObjects(objects map[string][]byte) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutObjects"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	args := []interface{}{schedObjects}
+	for name, data := range objects {
+		args = append(args, name, data)
+	}
+	if len(args) == 1 {
+		return nil
+	}
+	_, err := conn.Do("HMSET", args...)
+	return err
+}
+
+func (d *dataAccess) GetObjects() (map[string][]byte, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetObjects"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	return bytesMap(conn.Do("HGETALL", schedObjects))
+}
+
+func (d *dataAccess) AddCycleSummary(c *models.CycleSummary) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AddCycleSummary"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Do("LPUSH", cycleSummaries, b); err != nil {
+		return err
+	}
+	// Ledis has no LTRIM.
+	n, err := redis.Int(conn.Do("LLEN", cycleSummaries))
+	for ; err == nil && n > maxCycleSummaries; n-- {
+		_, err = conn.Do("RPOP", cycleSummaries)
+	}
+	return err
+}
+
+func (d *dataAccess) GetCycleSummaries(n int) ([]*models.CycleSummary, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetCycleSummaries"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	rows, err := redis.Strings(conn.Do("LRANGE", cycleSummaries, 0, n-1))
+	if err != nil {
+		return nil, err
+	}
+	summaries := make([]*models.CycleSummary, len(rows))
+	for i, row := range rows {
+		c := &models.CycleSummary{}
+		if err := json.Unmarshal([]byte(row), c); err != nil {
+			return nil, err
+		}
+		summaries[i] = c
+	}
+	return summaries, nil
+}
+
+// bytesMap converts the reply of HGETALL to a map of field to value.
+func bytesMap(reply interface{}, err error) (map[string][]byte, error) {
+	values, err := redis.Values(reply, err)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		k, err := redis.String(values[i], nil)
+		if err != nil {
+			return nil, err
+		}
+		data, err := redis.Bytes(values[i+1], nil)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = data
+	}
+	return m, nil
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"encoding/json"
+	"sort"
+
+	"bosun.org/_third_party/github.com/garyburd/redigo/redis"
+	"bosun.org/collect"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+/*
+
+subscriptionId = counter of subscription ids
+subscriptions = hash of subscription id to json subscription
+
+*/
+
+type SubscriptionDataAccess interface {
+	// AddSubscription assigns s a new id and stores it.
+	AddSubscription(s *models.Subscription) error
+	// GetSubscriptions returns all subscriptions, in the order they were added.
+	GetSubscriptions() ([]*models.Subscription, error)
+	DeleteSubscription(id int64) error
+}
+
+func (d *dataAccess) Subscriptions() SubscriptionDataAccess {
+	return d
+}
+
+const (
+	subscriptionId = "subscriptionId"
+	subscriptions  = "subscriptions"
+)
+
+func (d *dataAccess) AddSubscription(s *models.Subscription) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "AddSubscription"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	id, err := redis.Int64(conn.Do("INCR", subscriptionId))
+	if err != nil {
+		return err
+	}
+	s.Id = id
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Do("HSET", subscriptions, id, b)
+	return err
+}
+
+func (d *dataAccess) GetSubscriptions() ([]*models.Subscription, error) {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "GetSubscriptions"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	m, err := redis.StringMap(conn.Do("HGETALL", subscriptions))
+	if err != nil {
+		return nil, err
+	}
+	subs := make([]*models.Subscription, 0, len(m))
+	for _, v := range m {
+		s := &models.Subscription{}
+		if err := json.Unmarshal([]byte(v), s); err != nil {
+			return nil, err
+		}
+		subs = append(subs, s)
+	}
+	sort.Sort(subscriptionsById(subs))
+	return subs, nil
+}
+
+type subscriptionsById []*models.Subscription
+
+func (s subscriptionsById) Len() int           { return len(s) }
+func (s subscriptionsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s subscriptionsById) Less(i, j int) bool { return s[i].Id < s[j].Id }
+
+func (d *dataAccess) DeleteSubscription(id int64) error {
+	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "DeleteSubscription"})()
+	conn := d.GetConnection()
+	defer conn.Close()
+	_, err := conn.Do("HDEL", subscriptions, id)
+	return err
+}
@@ -15,6 +15,8 @@ import (
 	Tag metadata gets stored in various ways:
 
 	Metadata itself gets stored as a simple key (tmeta:tags:name) -> "timestamp:value".
+	Unchanged values are only rewritten, to refresh the timestamp, every tagMetaTouchInterval,
+	so the timestamp is only accurate to within that interval.
 
 	To facilitate subset lookups, there will be index sets for each possible subset of inserted tags.
 	tmeta:idx:{subset} -> set of tmeta keys
@@ -28,16 +30,47 @@ func tagMetaIdxKey(tagK, tagV string) string {
 	return fmt.Sprintf("tmeta:idx:%s=%s", tagK, tagV)
 }
 
+// tagMetaTouchInterval is how often unchanged tag metadata is rewritten to
+// refresh its timestamp. Agents send static values, like hardware models,
+// every interval, so writing only changes saves most writes.
+const tagMetaTouchInterval = time.Hour * 24
+
+// tagMetaWrite returns whether value, updated at updated, must be written
+// over stored, the current "timestamp:value" (or "" if none), and whether
+// the value changed, so its index sets must be written too.
+func tagMetaWrite(stored, value string, updated time.Time) (write, changed bool) {
+	parts := strings.SplitN(stored, ":", 2)
+	if len(parts) != 2 || parts[1] != value {
+		return true, true
+	}
+	touched, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return true, true
+	}
+	return updated.Sub(time.Unix(touched, 0)) >= tagMetaTouchInterval, false
+}
+
 func (d *dataAccess) PutTagMetadata(tags opentsdb.TagSet, name string, value string, updated time.Time) error {
 	defer collect.StartTimer("redis", opentsdb.TagSet{"op": "PutTagMeta"})()
 	conn := d.GetConnection()
 	defer conn.Close()
 	key := tagMetaKey(tags, name)
+	stored, err := redis.String(conn.Do("GET", key))
+	if err != nil && err != redis.ErrNil {
+		return err
+	}
+	write, changed := tagMetaWrite(stored, value, updated)
+	if !write {
+		return nil
+	}
 	keyValue := fmt.Sprintf("%d:%s", updated.UTC().Unix(), value)
-	_, err := conn.Do("SET", key, keyValue)
+	_, err = conn.Do("SET", key, keyValue)
 	if err != nil {
 		return err
 	}
+	if !changed {
+		return nil
+	}
 	for tagK, tagV := range tags {
 		_, err := conn.Do("SADD", tagMetaIdxKey(tagK, tagV), key)
 		if err != nil {
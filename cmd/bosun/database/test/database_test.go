@@ -37,3 +37,13 @@ func check(t *testing.T, err error) {
 		t.Fatal(err)
 	}
 }
+
+func TestFailover(t *testing.T) {
+	// Nothing listens on the discard port, so the connection fails over to testAddr.
+	d := database.NewFailoverDataAccess([]string{"127.0.0.1:9", testAddr}, *flagReddisHost != "")
+	c := d.(database.Connector).GetConnection()
+	defer c.Close()
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+}
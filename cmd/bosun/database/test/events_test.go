@@ -0,0 +1,37 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+func TestEvents(t *testing.T) {
+	ev := testData.Events()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	old := &models.Event{Type: "deploy", Time: now.Add(-2 * time.Hour)}
+	check(t, ev.PutEvent(old, time.Hour))
+	deploy := &models.Event{Type: "deploy", Time: now, Tags: opentsdb.TagSet{"app": "web"}, Message: "v2"}
+	check(t, ev.PutEvent(deploy, time.Hour))
+	if deploy.Id == 0 || deploy.Id == old.Id {
+		t.Fatalf("Expected a new event id. Got %d", deploy.Id)
+	}
+	check(t, ev.PutEvent(&models.Event{Type: "backup", Time: now}, time.Hour))
+
+	es, err := ev.GetEvents("deploy", now.Add(-3*time.Hour), now)
+	check(t, err)
+	if len(es) != 1 {
+		t.Fatalf("Expected the old deploy to expire, leaving 1 event. Got %d", len(es))
+	}
+	if es[0].Id != deploy.Id || es[0].Tags["app"] != "web" || es[0].Message != "v2" || !es[0].Time.Equal(now) {
+		t.Fatalf("Unexpected event %+v", es[0])
+	}
+	es, err = ev.GetEvents("", now.Add(-time.Minute), now)
+	check(t, err)
+	if len(es) != 2 {
+		t.Fatalf("Expected 2 events of any type. Got %d", len(es))
+	}
+}
@@ -0,0 +1,42 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHALease(t *testing.T) {
+	ha := testData.HA()
+
+	ok, err := ha.AcquireLease("a", time.Minute)
+	check(t, err)
+	if !ok {
+		t.Fatal("Expected a to acquire free lease")
+	}
+	ok, err = ha.AcquireLease("b", time.Minute)
+	check(t, err)
+	if ok {
+		t.Fatal("Expected b not to acquire lease held by a")
+	}
+	ok, err = ha.AcquireLease("a", time.Minute)
+	check(t, err)
+	if !ok {
+		t.Fatal("Expected a to renew its lease")
+	}
+	leader, err := ha.GetLeader()
+	check(t, err)
+	if leader != "a" {
+		t.Fatalf("Expected leader a. Got %q", leader)
+	}
+	check(t, ha.ReleaseLease("b"))
+	if leader, _ = ha.GetLeader(); leader != "a" {
+		t.Fatalf("Expected release by b to be ignored. Got leader %q", leader)
+	}
+	check(t, ha.ReleaseLease("a"))
+	ok, err = ha.AcquireLease("b", time.Minute)
+	check(t, err)
+	if !ok {
+		t.Fatal("Expected b to acquire released lease")
+	}
+	check(t, ha.ReleaseLease("b"))
+}
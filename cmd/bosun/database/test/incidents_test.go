@@ -0,0 +1,58 @@
+package dbtest
+
+import (
+	"testing"
+
+	"bosun.org/models"
+)
+
+func TestIncidentNotes(t *testing.T) {
+	id := uint64(42)
+	id2 := uint64(43)
+	inc := testData.Incidents()
+
+	notes, err := inc.GetIncidentNotes(id)
+	check(t, err)
+	if len(notes) != 0 {
+		t.Fatalf("Expected no notes. Got %v", notes)
+	}
+	check(t, inc.AddIncidentNote(id, &models.IncidentNote{Author: "a", Body: "first"}))
+	check(t, inc.AddIncidentNote(id, &models.IncidentNote{Author: "b", Body: "second"}))
+	check(t, inc.AddIncidentNote(id2, &models.IncidentNote{Author: "c", Body: "other"}))
+	notes, err = inc.GetIncidentNotes(id)
+	check(t, err)
+	if len(notes) != 2 || notes[0].Body != "first" || notes[1].Author != "b" {
+		t.Fatalf("Unexpected notes %v", notes)
+	}
+	check(t, inc.SetIncidentNotes(id, []*models.IncidentNote{{Author: "d", Body: "only"}}))
+	notes, err = inc.GetIncidentNotes(id)
+	check(t, err)
+	if len(notes) != 1 || notes[0].Body != "only" {
+		t.Fatalf("Expected notes to be replaced. Got %v", notes)
+	}
+}
+
+func TestIncidentSnapshot(t *testing.T) {
+	inc := testData.Incidents()
+
+	snap, err := inc.GetIncidentSnapshot(142)
+	check(t, err)
+	if snap != nil {
+		t.Fatalf("Expected no snapshot. Got %v", snap)
+	}
+	s := &models.IncidentSnapshot{
+		Alert: "a",
+		Results: map[string]*models.SnapshotResult{
+			"a{host=x}": {Status: "critical", Crit: []byte(`{"Value":2}`)},
+			"a{host=y}": {Status: "normal"},
+		},
+	}
+	check(t, inc.SetIncidentSnapshot(s, []uint64{142, 143}))
+	for _, id := range []uint64{142, 143} {
+		snap, err = inc.GetIncidentSnapshot(id)
+		check(t, err)
+		if snap == nil || len(snap.Results) != 2 || string(snap.Results["a{host=x}"].Crit) != `{"Value":2}` {
+			t.Fatalf("Unexpected snapshot for %d: %v", id, snap)
+		}
+	}
+}
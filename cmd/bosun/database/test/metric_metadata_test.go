@@ -1,7 +1,12 @@
 package dbtest
 
 import (
+	"fmt"
 	"testing"
+	"time"
+
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
 )
 
 func TestMetricMetadata_RoundTrip(t *testing.T) {
@@ -42,3 +47,43 @@ func TestMetricMetadata_BadField(t *testing.T) {
 		t.Fatal("Expected failure to set bad metric metadata field")
 	}
 }
+
+func TestMetadataBatch(t *testing.T) {
+	metric := randString(5)
+	ms := []metadata.Metasend{
+		{Metric: metric, Name: "desc", Value: "cpu of a server"},
+		{Metric: metric, Name: "unit", Value: "pct"},
+	}
+	// Enough entries to span several pipelines.
+	for i := 0; i < 300; i++ {
+		ms = append(ms, metadata.Metasend{
+			Tags:  opentsdb.TagSet{"host": metric, "iface": fmt.Sprint(i)},
+			Name:  "speed",
+			Value: i,
+		})
+	}
+	if err := testData.Metadata().PutMetadataBatch(ms, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := testData.Metadata().GetMetricMetadata(metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil || meta.Desc != "cpu of a server" || meta.Unit != "pct" {
+		t.Fatalf("unexpected metric metadata: %+v", meta)
+	}
+	metas, err := testData.Metadata().GetTagMetadata(opentsdb.TagSet{"host": metric}, "speed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 300 {
+		t.Fatalf("expected 300 tag metadata, got %d", len(metas))
+	}
+	metas, err = testData.Metadata().GetTagMetadata(opentsdb.TagSet{"host": metric, "iface": "42"}, "speed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].Value != "42" {
+		t.Fatalf("unexpected tag metadata: %+v", metas)
+	}
+}
@@ -0,0 +1,34 @@
+package dbtest
+
+import (
+	"testing"
+
+	"bosun.org/models"
+)
+
+func TestAlertNotes_RoundTrip(t *testing.T) {
+	nd := testData.AlertNotes()
+
+	note, err := nd.GetAlertNote("noted")
+	check(t, err)
+	if note != nil {
+		t.Fatalf("Expected no note. Got %v", note)
+	}
+	check(t, nd.SetAlertNote("noted", &models.AlertNote{Text: "known issue", User: "me"}))
+	note, err = nd.GetAlertNote("noted")
+	check(t, err)
+	if note == nil || note.Text != "known issue" || note.User != "me" {
+		t.Fatalf("Unexpected note %v", note)
+	}
+	notes, err := nd.GetAlertNotes()
+	check(t, err)
+	if notes["noted"] == nil || notes["noted"].Text != "known issue" {
+		t.Fatalf("Expected note in all notes. Got %v", notes)
+	}
+	check(t, nd.ClearAlertNote("noted"))
+	note, err = nd.GetAlertNote("noted")
+	check(t, err)
+	if note != nil {
+		t.Fatalf("Expected note to be cleared. Got %v", note)
+	}
+}
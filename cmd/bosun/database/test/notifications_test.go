@@ -0,0 +1,104 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/models"
+)
+
+func TestDeliveries_RoundTrip(t *testing.T) {
+	dd := testData.Deliveries()
+	now := time.Now().UTC()
+
+	d1 := &models.NotificationDelivery{Notification: "n", Status: models.DeliveryPending, Subject: "first"}
+	check(t, dd.QueueDelivery(d1))
+	d2 := &models.NotificationDelivery{Notification: "n", Status: models.DeliveryPending, Subject: "second"}
+	check(t, dd.QueueDelivery(d2))
+	if d2.Id <= d1.Id {
+		t.Fatalf("Expected increasing ids. Got %d then %d", d1.Id, d2.Id)
+	}
+
+	d1.Status = models.DeliverySent
+	check(t, dd.UpdateDelivery(d1))
+	d2.Attempts = 1
+	d2.NextAttempt = now.Add(-time.Second)
+	check(t, dd.UpdateDelivery(d2))
+
+	due, err := dd.GetDueDeliveries(now)
+	check(t, err)
+	if len(due) != 1 || due[0].Id != d2.Id {
+		t.Fatalf("Expected delivery %d to be due. Got %v", d2.Id, due)
+	}
+	due, err = dd.GetDueDeliveries(now.Add(-time.Minute))
+	check(t, err)
+	if len(due) != 0 {
+		t.Fatalf("Expected no due deliveries. Got %d", len(due))
+	}
+
+	log, err := dd.GetDeliveryLog(2)
+	check(t, err)
+	if len(log) != 2 || log[0].Id != d2.Id || log[1].Id != d1.Id {
+		t.Fatalf("Expected newest deliveries first. Got %v", log)
+	}
+	if log[1].Status != models.DeliverySent || log[1].Subject != "first" {
+		t.Fatalf("Unexpected stored delivery %+v", log[1])
+	}
+
+	d2.Status = models.DeliveryFailed
+	d2.NextAttempt = time.Time{}
+	check(t, dd.UpdateDelivery(d2))
+	due, err = dd.GetDueDeliveries(now)
+	check(t, err)
+	if len(due) != 0 {
+		t.Fatalf("Expected failed delivery to not be due. Got %d", len(due))
+	}
+}
+
+func TestNotifications_DueAndClear(t *testing.T) {
+	nd := testData.Notifications()
+	ak := "a{host=" + randString(5) + "}"
+	now := time.Now().UTC().Truncate(time.Second)
+
+	check(t, nd.InsertNotification(ak, "soon", now.Add(-time.Minute)))
+	check(t, nd.InsertNotification(ak, "later", now.Add(time.Hour)))
+	has, err := nd.HasNotifications(ak)
+	check(t, err)
+	if !has {
+		t.Fatal("Expected notifications for", ak)
+	}
+	next, err := nd.GetNextNotificationTime()
+	check(t, err)
+	if next.After(now.Add(-time.Minute)) {
+		t.Fatalf("Expected next notification at or before %v. Got %v", now.Add(-time.Minute), next)
+	}
+	due, err := nd.GetDueNotifications(now)
+	check(t, err)
+	if len(due[ak]) != 1 || !due[ak]["soon"].Equal(now.Add(-time.Minute)) {
+		t.Fatalf("Expected only soon to be due. Got %v", due[ak])
+	}
+	all, err := nd.GetNotifications()
+	check(t, err)
+	if len(all[ak]) != 2 || !all[ak]["later"].Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected soon and later to be scheduled. Got %v", all[ak])
+	}
+
+	check(t, nd.ClearNotification(ak, "soon"))
+	due, err = nd.GetDueNotifications(now)
+	check(t, err)
+	if len(due[ak]) != 0 {
+		t.Fatalf("Expected no due notifications. Got %v", due[ak])
+	}
+	due, err = nd.GetDueNotifications(now.Add(2 * time.Hour))
+	check(t, err)
+	if _, ok := due[ak]["later"]; !ok {
+		t.Fatalf("Expected later to be due. Got %v", due[ak])
+	}
+
+	check(t, nd.ClearNotifications(ak))
+	has, err = nd.HasNotifications(ak)
+	check(t, err)
+	if has {
+		t.Fatal("Expected no notifications for", ak)
+	}
+}
@@ -0,0 +1,32 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/models"
+)
+
+func TestAlertProfiles_RoundTrip(t *testing.T) {
+	pd := testData.Profiles()
+
+	p, err := pd.GetAlertProfile("profiled")
+	check(t, err)
+	if p != nil {
+		t.Fatalf("Expected no profile. Got %v", p)
+	}
+	check(t, pd.SetAlertProfile("profiled", &models.ExprProfile{
+		Alert:    "profiled",
+		Duration: time.Second,
+		Steps: []*models.ExprStep{
+			{Name: "crit", Duration: time.Second, Steps: []*models.ExprStep{
+				{Name: "tsdb query", Command: "q", Duration: time.Second},
+			}},
+		},
+	}))
+	p, err = pd.GetAlertProfile("profiled")
+	check(t, err)
+	if p == nil || p.Duration != time.Second || len(p.Steps) != 1 || len(p.Steps[0].Steps) != 1 || p.Steps[0].Steps[0].Command != "q" {
+		t.Fatalf("Unexpected profile %v", p)
+	}
+}
@@ -0,0 +1,28 @@
+package dbtest
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/models"
+)
+
+func TestReasonActions(t *testing.T) {
+	rd := testData.Reasons()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	check(t, rd.AddReasonAction(&models.ReasonAction{Alert: "ra", AlertKey: "ra{host=a}", Type: "Closed", Reason: "fixed", Time: now.Add(-time.Hour)}))
+	check(t, rd.AddReasonAction(&models.ReasonAction{Alert: "ra", AlertKey: "ra{host=b}", Type: "Forgotten", Time: now}))
+	check(t, rd.AddReasonAction(&models.ReasonAction{Alert: "rb", AlertKey: "rb{}", Type: "Closed", Reason: "fixed", Time: now}))
+
+	as, err := rd.GetReasonActions("ra", now.Add(-2*time.Hour), now)
+	check(t, err)
+	if len(as) != 2 || as[0].Reason != "fixed" || as[1].AlertKey != "ra{host=b}" {
+		t.Fatalf("Unexpected actions for ra: %+v", as)
+	}
+	as, err = rd.GetReasonActions("", now.Add(-time.Minute), now)
+	check(t, err)
+	if len(as) != 2 {
+		t.Fatalf("Expected 2 recent actions on any alert. Got %d", len(as))
+	}
+}
@@ -3,6 +3,7 @@ package dbtest
 import (
 	"testing"
 
+	"bosun.org/cmd/bosun/database"
 	"bosun.org/opentsdb"
 )
 
@@ -45,3 +46,33 @@ func TestSearch_MetricTagSets(t *testing.T) {
 		t.Fatalf("Expected 2 tagsets. Found %d.", len(tagsets))
 	}
 }
+
+func TestSearch_FirstSeen(t *testing.T) {
+	metric, host := randString(5), randString(5)
+	if err := testData.Search().AddMetric(metric, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := testData.Search().AddMetric(metric, 50); err != nil {
+		t.Fatal(err)
+	}
+	if err := testData.Search().AddTagValue(database.Search_All, "host", host, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := testData.Search().AddTagValue(database.Search_All, "host", host, 50); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := testData.Search().GetFirstSeenMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics[metric] != 42 {
+		t.Errorf("expected %s first seen at 42, got %d", metric, metrics[metric])
+	}
+	hosts, err := testData.Search().GetFirstSeenTagValues("host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hosts[host] != 42 {
+		t.Errorf("expected %s first seen at 42, got %d", host, hosts[host])
+	}
+}
@@ -0,0 +1,82 @@
+package dbtest
+
+import (
+	"testing"
+
+	"bosun.org/models"
+)
+
+func TestStates(t *testing.T) {
+	sd := testData.State()
+
+	check(t, sd.PutStates(map[string][]byte{"a{host=x}": {1}, "a{host=y}": {2}}))
+	check(t, sd.PutStates(map[string][]byte{"a{host=x}": nil, "a{host=y}": {3}}))
+	states, err := sd.GetStates()
+	check(t, err)
+	if len(states) != 1 || string(states["a{host=y}"]) != "\x03" {
+		t.Fatalf("Unexpected states %v", states)
+	}
+}
+
+func TestStateObjects(t *testing.T) {
+	sd := testData.State()
+
+	check(t, sd.PutObjects(map[string][]byte{"incidents": {1, 2, 3}, "silence": {0}}))
+	check(t, sd.PutObjects(map[string][]byte{"incidents": {4}}))
+	objects, err := sd.GetObjects()
+	check(t, err)
+	if len(objects) != 2 || string(objects["incidents"]) != "\x04" || string(objects["silence"]) != "\x00" {
+		t.Fatalf("Unexpected objects %v", objects)
+	}
+}
+
+func TestTempConfig(t *testing.T) {
+	cd := testData.Configs()
+
+	text, err := cd.GetTempConfig("missing")
+	check(t, err)
+	if text != "" {
+		t.Fatalf("Expected no config. Got %q", text)
+	}
+	check(t, cd.PutTempConfig("abc", "alert a {\n\tcrit = 1\n}"))
+	text, err = cd.GetTempConfig("abc")
+	check(t, err)
+	if text != "alert a {\n\tcrit = 1\n}" {
+		t.Fatalf("Unexpected config %q", text)
+	}
+}
+
+func TestAlertVersions(t *testing.T) {
+	cd := testData.Configs()
+
+	versions, err := cd.GetAlertVersions("versioned")
+	check(t, err)
+	if len(versions) != 0 {
+		t.Fatalf("Expected no versions. Got %v", versions)
+	}
+	for i, hash := range []string{"a", "a", "b"} {
+		added, err := cd.AddAlertVersion("versioned", &models.AlertVersion{Hash: hash, Text: "text " + hash, User: "me"})
+		check(t, err)
+		if added != (i != 1) {
+			t.Fatalf("Version %d: expected added %v", i, i != 1)
+		}
+	}
+	versions, err = cd.GetAlertVersions("versioned")
+	check(t, err)
+	if len(versions) != 2 || versions[0].Hash != "b" || versions[1].Text != "text a" || versions[1].User != "me" {
+		t.Fatalf("Unexpected versions %v", versions)
+	}
+}
+
+func TestCycleSummaries(t *testing.T) {
+	sd := testData.State()
+
+	for i := 1; i <= 3; i++ {
+		check(t, sd.AddCycleSummary(&models.CycleSummary{Critical: i, NewCritical: 1}))
+	}
+	summaries, err := sd.GetCycleSummaries(2)
+	check(t, err)
+	if len(summaries) != 2 || summaries[0].Critical != 3 || summaries[1].Critical != 2 || summaries[1].NewCritical != 1 {
+		t.Fatalf("Unexpected summaries %v", summaries)
+	}
+}
@@ -0,0 +1,32 @@
+package dbtest
+
+import (
+	"testing"
+
+	"bosun.org/models"
+)
+
+func TestSubscriptions_RoundTrip(t *testing.T) {
+	sd := testData.Subscriptions()
+
+	s1 := &models.Subscription{User: "me", Alert: "a", Email: "me@example.com"}
+	check(t, sd.AddSubscription(s1))
+	s2 := &models.Subscription{User: "you", Tags: "host=web*", Post: "http://example.com/hook"}
+	check(t, sd.AddSubscription(s2))
+	if s2.Id <= s1.Id {
+		t.Fatalf("Expected increasing ids. Got %d then %d", s1.Id, s2.Id)
+	}
+	subs, err := sd.GetSubscriptions()
+	check(t, err)
+	if len(subs) < 2 || subs[len(subs)-2].Id != s1.Id || subs[len(subs)-1].Tags != "host=web*" {
+		t.Fatalf("Unexpected subscriptions %v", subs)
+	}
+	check(t, sd.DeleteSubscription(s1.Id))
+	subs, err = sd.GetSubscriptions()
+	check(t, err)
+	for _, s := range subs {
+		if s.Id == s1.Id {
+			t.Fatalf("Expected subscription %d to be deleted", s1.Id)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 
+	"bosun.org/metadata"
 	"bosun.org/opentsdb"
 )
 
@@ -74,3 +75,51 @@ func TestTagMetadata_Delete(t *testing.T) {
 		t.Fatalf("Expected 1 metadata entry for empty key. Got %d", len(metas))
 	}
 }
+
+func TestTagMetadata_OnlyChanges(t *testing.T) {
+	tagset := opentsdb.TagSet{"host": randString(4)}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	touched := func() (string, int64) {
+		metas, err := testData.Metadata().GetTagMetadata(tagset, "model")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(metas) != 1 {
+			t.Fatalf("expected 1 metadata result, got %d", len(metas))
+		}
+		return metas[0].Value, metas[0].LastTouched
+	}
+	for i, put := range []struct {
+		value   string
+		after   time.Duration
+		touched time.Duration
+	}{
+		{"r720", 0, 0},
+		// Unchanged values aren't written until the touch interval passes.
+		{"r720", time.Hour, 0},
+		{"r720", 25 * time.Hour, 25 * time.Hour},
+		{"r730", 26 * time.Hour, 26 * time.Hour},
+	} {
+		if err := testData.Metadata().PutTagMetadata(tagset, "model", put.value, start.Add(put.after)); err != nil {
+			t.Fatal(err)
+		}
+		v, ts := touched()
+		if v != put.value || ts != start.Add(put.touched).Unix() {
+			t.Errorf("put %d: got %s at %d, expected %s at %d", i, v, ts, put.value, start.Add(put.touched).Unix())
+		}
+	}
+	ms := []metadata.Metasend{{Tags: tagset, Name: "model", Value: "r730"}}
+	if err := testData.Metadata().PutMetadataBatch(ms, start.Add(27*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ts := touched(); ts != start.Add(26*time.Hour).Unix() {
+		t.Errorf("batch rewrote unchanged value")
+	}
+	ms[0].Value = "r740"
+	if err := testData.Metadata().PutMetadataBatch(ms, start.Add(28*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := touched(); v != "r740" {
+		t.Errorf("batch didn't write changed value, got %s", v)
+	}
+}
@@ -14,10 +14,14 @@ import (
 var flagReddisHost = flag.String("redis", "", "redis server to test against")
 var flagFlushRedis = flag.Bool("flush", false, "flush database before tests. DANGER!")
 
+// testAddr is the address of the server the tests run against.
+var testAddr string
+
 func StartTestRedis() (database.DataAccess, func()) {
 	flag.Parse()
 	// For redis tests we just point at an external server.
 	if *flagReddisHost != "" {
+		testAddr = *flagReddisHost
 		testData := database.NewDataAccess(*flagReddisHost, true)
 		if *flagFlushRedis {
 			log.Println("FLUSHING REDIS")
@@ -32,6 +36,7 @@ func StartTestRedis() (database.DataAccess, func()) {
 	}
 	// To test ledis, start a local instance in a new tmp dir. We will attempt to delete it when we're done.
 	addr := "127.0.0.1:9876"
+	testAddr = addr
 	testPath := filepath.Join(os.TempDir(), "bosun_ledis_test", fmt.Sprint(time.Now().Unix()))
 	log.Println(testPath)
 	stop, err := database.StartLedis(testPath, addr)
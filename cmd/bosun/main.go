@@ -5,6 +5,8 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/pprof"
@@ -20,6 +22,7 @@ import (
 	"bosun.org/_third_party/github.com/facebookgo/httpcontrol"
 	"bosun.org/_third_party/gopkg.in/fsnotify.v1"
 	"bosun.org/cmd/bosun/conf"
+	"bosun.org/cmd/bosun/database"
 	"bosun.org/cmd/bosun/sched"
 	"bosun.org/cmd/bosun/web"
 	"bosun.org/collect"
@@ -70,7 +73,17 @@ var (
 	flagDev      = flag.Bool("dev", false, "enable dev mode: use local resources; no syslog")
 	flagVersion  = flag.Bool("version", false, "Prints the version and exits")
 
+	flagExportState  = flag.String("export-state", "", "write alert states, incidents, silences, notes, metadata, and the search index to the given JSON file and exit; bosun should not be running")
+	flagImportState  = flag.String("import-state", "", "load a file written by -export-state into the database and exit; bosun should not be running")
+	flagMigrateState = flag.Bool("migrate-state", false, "import the state file of an older version into the database and exit; this is also done at startup")
+	flagTestAlerts   = flag.Bool("test-alerts", false, "run the test sections of the config against their synthetic series; exits with 0 if all pass, else 1")
+	flagValidate     = flag.Bool("validate", false, "test for valid config and print a per-alert query cost report with warnings about expensive expressions; exits with 0 if there are no warnings, else 1")
+
 	mains []func()
+	// started and stopping are called once the web server and scheduler
+	// have been started, and before bosun exits on shutdown.
+	started  []func()
+	stopping []func()
 )
 
 func main() {
@@ -90,6 +103,29 @@ func main() {
 	if *flagTest {
 		os.Exit(0)
 	}
+	if *flagValidate {
+		if validate(c) > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *flagTestAlerts {
+		failed, err := testAlerts(c)
+		if err != nil {
+			slog.Fatal(err)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	if *flagExportState != "" || *flagImportState != "" || *flagMigrateState {
+		if err := migrateState(c); err != nil {
+			slog.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	web.TailLogs()
 	httpListen := &url.URL{
 		Scheme: "http",
 		Host:   c.HTTPListen,
@@ -97,12 +133,21 @@ func main() {
 	if strings.HasPrefix(httpListen.Host, ":") {
 		httpListen.Host = "localhost" + httpListen.Host
 	}
-	if err := metadata.Init(httpListen, false); err != nil {
-		slog.Fatal(err)
+	if c.HTTPTLSCert != "" {
+		// The certificate is for bosun's hostname, not localhost.
+		httpListen.Scheme = "https"
+		host := c.Hostname
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if _, port, err := net.SplitHostPort(c.HTTPListen); err == nil {
+			httpListen.Host = net.JoinHostPort(host, port)
+		}
 	}
 	if err := sched.Load(c); err != nil {
 		slog.Fatal(err)
 	}
+	metadata.InitFunc(sched.DefaultSched.PutMetadataBatch, false)
 	if c.RelayListen != "" {
 		go func() {
 			mux := http.NewServeMux()
@@ -115,6 +160,7 @@ func main() {
 		}()
 	}
 	if c.TSDBHost != "" {
+		collect.DefaultTags = c.CollectTags
 		if err := collect.Init(httpListen, "bosun"); err != nil {
 			slog.Fatal(err)
 		}
@@ -157,12 +203,14 @@ func main() {
 			killing = true
 			go func() {
 				slog.Infoln("Interrupt: closing down...")
-				sched.Close()
-				slog.Infoln("done")
+				shutdown()
 				os.Exit(1)
 			}()
 		}
 	}()
+	for _, f := range started {
+		f()
+	}
 	if *flagWatch {
 		watch(".", "*.go", quit)
 		watch(filepath.Join("web", "static", "templates"), "*.html", web.RunEsc)
@@ -172,6 +220,113 @@ func main() {
 	select {}
 }
 
+// shutdown saves state and closes the scheduler so bosun can exit.
+func shutdown() {
+	for _, f := range stopping {
+		f()
+	}
+	sched.Close()
+	slog.Infoln("done")
+}
+
+// migrateState runs -migrate-state, -export-state, or -import-state. The
+// state file, if any, is migrated first so an export includes it.
+func migrateState(c *conf.Conf) error {
+	s := sched.DefaultSched
+	if err := s.Init(c); err != nil {
+		return err
+	}
+	if err := s.MigrateStateFile(); err != nil {
+		return err
+	}
+	if *flagMigrateState {
+		slog.Infoln("migrated state file", c.StateFile)
+		return nil
+	}
+	if *flagExportState != "" {
+		f, err := os.Create(*flagExportState)
+		if err != nil {
+			return err
+		}
+		if err := s.ExportState(f); err != nil {
+			f.Close()
+			return err
+		}
+		slog.Infoln("exported state to", *flagExportState)
+		return f.Close()
+	}
+	f, err := os.Open(*flagImportState)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.ImportState(f); err != nil {
+		return err
+	}
+	slog.Infoln("imported state from", *flagImportState)
+	return nil
+}
+
+// testAlerts runs -test-alerts and returns the number of failed tests. The
+// tests use a temporary ledis instead of the configured database and state
+// file, so they may run alongside bosun.
+func testAlerts(c *conf.Conf) (int, error) {
+	dir, err := ioutil.TempDir("", "bosun-test-alerts")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	addr := l.Addr().String()
+	l.Close()
+	stop, err := database.StartLedis(dir, addr)
+	if err != nil {
+		return 0, err
+	}
+	defer stop()
+	c.StateFile = ""
+	s := new(sched.Schedule)
+	s.DataAccess = database.NewDataAccess(addr, false)
+	if err := s.Init(c); err != nil {
+		return 0, err
+	}
+	results := s.TestAlerts(time.Now().UTC())
+	failed := 0
+	for _, r := range results {
+		if len(r.Failures) == 0 {
+			fmt.Printf("ok\t%s (alert %s)\n", r.Name, r.Alert)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL\t%s (alert %s)\n", r.Name, r.Alert)
+		for _, f := range r.Failures {
+			fmt.Printf("\t%s\n", f)
+		}
+	}
+	fmt.Printf("%d of %d tests failed\n", failed, len(results))
+	return failed, nil
+}
+
+// validate prints the cost report of c's alerts and returns the number of
+// warnings.
+func validate(c *conf.Conf) int {
+	warnings := 0
+	total := 0.0
+	for _, ac := range c.Lint() {
+		fmt.Printf("%s\t%d requests per run, %.2f per check cycle\n", ac.Alert, ac.Requests, ac.PerCycle)
+		for _, w := range ac.Warnings {
+			fmt.Printf("\t%s\n", w)
+		}
+		warnings += len(ac.Warnings)
+		total += ac.PerCycle
+	}
+	fmt.Printf("%.2f requests per check cycle, %d warnings\n", total, warnings)
+	return warnings
+}
+
 func quit() {
 	os.Exit(0)
 }
@@ -2,24 +2,53 @@ package sched
 
 import (
 	"fmt"
+	"math/rand"
 	"time"
 
 	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
 	"bosun.org/slog"
 )
 
+// searchCompactInterval is the time between compactions of the search index
+// when searchRetention is set.
+const searchCompactInterval = 24 * time.Hour
+
 // Run should be called once (and only once) to start all schedule activity.
 func (s *Schedule) Run() error {
 	if s.Conf == nil {
 		return fmt.Errorf("sched: nil configuration")
 	}
 	s.nc = make(chan interface{}, 1)
+	s.ready = make(chan struct{})
+	go s.gateReadiness()
 	if s.Conf.Ping {
 		go s.PingHosts()
 	}
+	if len(s.Conf.Probes) > 0 {
+		go s.RunProbes()
+	}
+	if len(s.Conf.Anomalies) > 0 {
+		s.RunAnomalies()
+	}
+	s.WatchLookups()
 	go s.dispatchNotifications()
+	go s.retryDeliveries()
+	if s.Conf.CriticalExport != "" {
+		go s.exportCritical()
+	}
+	if s.Conf.SilenceExpiryNotification != nil {
+		go s.checkSilenceExpiry()
+	}
+	if s.Conf.HA {
+		go s.runHA()
+	}
+	if s.Conf.SearchRetention > 0 {
+		go s.Search.CompactEvery(searchCompactInterval, time.Duration(s.Conf.SearchRetention), s.IsLeader)
+	}
 	go s.performSave()
+	go s.performFlush()
 	go s.updateCheckContext()
 	for _, a := range s.Conf.Alerts {
 		go s.RunAlert(a)
@@ -33,25 +62,139 @@ func (s *Schedule) updateCheckContext() {
 		time.Sleep(s.Conf.CheckFrequency)
 		s.Lock("CollectStates")
 		s.CollectStates()
+		summary := s.summarizeCycle(time.Now())
 		s.Unlock()
+		if s.IsLeader() {
+			s.recordCycle(summary)
+		}
 	}
 }
 func (s *Schedule) RunAlert(a *conf.Alert) {
+	if s.ready != nil {
+		<-s.ready
+	}
+	interval := s.Conf.AlertInterval(a)
+	// Start at a random offset within the jitter so alerts with the same
+	// interval don't all hit the backend at once.
+	base := time.Now()
+	next := base.Add(jitter(a.Jitter))
+	warmup := a.Warmup > 0
 	for {
-		wait := time.After(s.Conf.CheckFrequency * time.Duration(a.RunEvery))
-		s.checkAlert(a)
-		s.LastCheck = time.Now()
-		<-wait
+		s.setNextRun(a.Name, next)
+		time.Sleep(next.Sub(time.Now()))
+		if s.IsLeader() {
+			s.checkAlert(a, warmup)
+			warmup = false
+			s.LastCheck = time.Now()
+		}
+		base, next = nextRun(base, interval, a.Jitter, time.Now())
 	}
 }
 
-func (s *Schedule) checkAlert(a *conf.Alert) {
-	checkTime := s.ctx.runTime
-	checkCache := s.ctx.checkCache
+// nextRun returns the next base time and jittered run time of an alert that
+// last ran at base. The base advances by interval so that jitter does not
+// accumulate, but never falls behind now.
+func nextRun(base time.Time, interval, j time.Duration, now time.Time) (time.Time, time.Time) {
+	base = base.Add(interval)
+	if base.Before(now) {
+		base = now
+	}
+	return base, base.Add(jitter(j))
+}
+
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// ChecksRunning reports whether an alert has been checked within twice the
+// shortest alert interval. It is false if the scheduler is wedged.
+func (s *Schedule) ChecksRunning() bool {
+	var min time.Duration
+	for _, a := range s.Conf.Alerts {
+		if d := s.Conf.AlertInterval(a) + a.Jitter; min == 0 || d < min {
+			min = d
+		}
+	}
+	if min == 0 {
+		return true
+	}
+	return time.Since(s.LastCheck) < min*2
+}
+
+func (s *Schedule) setNextRun(alert string, t time.Time) {
+	s.nextRunLock.Lock()
+	s.nextRuns[alert] = t
+	s.nextRunLock.Unlock()
+}
+
+// NextRuns returns the time each alert is next scheduled to run.
+func (s *Schedule) NextRuns() map[string]time.Time {
+	s.nextRunLock.Lock()
+	defer s.nextRunLock.Unlock()
+	runs := make(map[string]time.Time, len(s.nextRuns))
+	for k, v := range s.nextRuns {
+		runs[k] = v
+	}
+	return runs
+}
+
+// AlertRun describes the last check of an alert.
+type AlertRun struct {
+	Time time.Time
+	// Duration is how long the check took in seconds.
+	Duration float64
+	// Results is the number of alert keys with events, and the others count
+	// them by status.
+	Results     int
+	Normal      int
+	Warning     int
+	Critical    int
+	Unknown     int
+	Unevaluated int
+	Error       string `json:",omitempty"`
+	// Queries are the OpenTSDB queries issued.
+	Queries []string
+}
+
+func (s *Schedule) setLastRun(alert string, run *AlertRun) {
+	s.lastRunLock.Lock()
+	s.lastRuns[alert] = run
+	s.lastRunLock.Unlock()
+}
+
+// LastRuns returns the details of each alert's last check.
+func (s *Schedule) LastRuns() map[string]*AlertRun {
+	s.lastRunLock.Lock()
+	defer s.lastRunLock.Unlock()
+	runs := make(map[string]*AlertRun, len(s.lastRuns))
+	for k, v := range s.lastRuns {
+		runs[k] = v
+	}
+	return runs
+}
+
+// checkAlert checks a, first replaying its warmup if warmup is set.
+func (s *Schedule) checkAlert(a *conf.Alert, warmup bool) {
+	ctx := s.ctx
+	if a.Interval != 0 || a.Jitter != 0 {
+		// Alerts off the global check cycle can't share its run time or cache.
+		ctx = &checkContext{time.Now(), cache.New(0)}
+	}
+	checkTime := ctx.runTime
+	checkCache := ctx.checkCache
+	var warmed []expr.AlertKey
+	if warmup {
+		warmed = s.warmup(a, checkTime)
+	}
 	rh := s.NewRunHistory(checkTime, checkCache)
 	s.CheckAlert(nil, rh, a)
 
 	start := time.Now()
 	s.RunHistory(rh)
 	slog.Infof("runHistory on %s took %v\n", a.Name, time.Since(start))
+	s.endWarmup(warmed, checkTime)
+	s.runHooks(func(h Hook) { h.OnCheckCycleEnd(a, rh) })
 }
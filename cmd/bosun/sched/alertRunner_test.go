@@ -0,0 +1,29 @@
+package sched
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	interval := 15 * time.Minute
+	j := time.Minute
+	base := start
+	for i := 1; i <= 100; i++ {
+		var next time.Time
+		base, next = nextRun(base, interval, j, base)
+		if want := start.Add(time.Duration(i) * interval); !base.Equal(want) {
+			t.Fatalf("run %d: base %v, expected %v", i, base, want)
+		}
+		if next.Before(base) || !next.Before(base.Add(j)) {
+			t.Fatalf("run %d: next %v not within jitter of %v", i, next, base)
+		}
+	}
+	// A run that overran its interval is rescheduled from now.
+	now := base.Add(2 * interval)
+	base, next := nextRun(base, interval, 0, now)
+	if !base.Equal(now) || !next.Equal(now) {
+		t.Errorf("overrun: got base %v next %v, expected %v", base, next, now)
+	}
+}
@@ -0,0 +1,113 @@
+package sched
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"bosun.org/cmd/bosun/cache"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+// AlertTestResult is the outcome of a conf test section.
+type AlertTestResult struct {
+	Name  string
+	Alert string
+	// Status is the status of each alert key the alert returned.
+	Status map[expr.AlertKey]Status
+	// Failures describe each expectation not met, or the error evaluating
+	// the alert. The test passed if there are none.
+	Failures []string
+}
+
+// TestAlerts runs every test section of s's conf as if at now, ordered by
+// test name.
+func (s *Schedule) TestAlerts(now time.Time) []*AlertTestResult {
+	names := make([]string, 0, len(s.Conf.AlertTests))
+	for name := range s.Conf.AlertTests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	results := make([]*AlertTestResult, len(names))
+	for i, name := range names {
+		results[i] = s.TestAlert(s.Conf.AlertTests[name], now)
+	}
+	return results
+}
+
+// TestAlert evaluates t's alert against t's series and compares the status of
+// each alert key to t's expectations. Alert history is not used or changed.
+func (s *Schedule) TestAlert(t *conf.AlertTest, now time.Time) *AlertTestResult {
+	a := t.Alert
+	r := &AlertTestResult{
+		Name:   t.Name,
+		Alert:  a.Name,
+		Status: make(map[expr.AlertKey]Status),
+	}
+	fail := func(format string, args ...interface{}) {
+		r.Failures = append(r.Failures, fmt.Sprintf(format, args...))
+	}
+	// Index the series so tag wildcards in queries can be expanded.
+	for _, series := range t.Series {
+		for k, v := range series.Tags {
+			if err := s.DataAccess.Search().AddTagValue(series.Metric, k, v, now.Unix()); err != nil {
+				fail("error: %v", err)
+				return r
+			}
+		}
+	}
+	rh := &RunHistory{
+		Cache:    cache.New(0),
+		Start:    now,
+		Events:   make(map[expr.AlertKey]*Event),
+		Context:  t.Context(now),
+		schedule: s,
+	}
+	var crits expr.AlertKeys
+	d, err := s.executeExpr(nil, rh, a, a.Depends)
+	if err == nil {
+		crits, err = s.CheckExpr(nil, rh, a, a.Crit, StCritical, nil)
+		if err == nil {
+			_, err = s.CheckExpr(nil, rh, a, a.Warn, StWarning, crits)
+		}
+	}
+	if err != nil {
+		fail("error: %v", err)
+		return r
+	}
+	markDependenciesUnevaluated(rh.Events, filterDependencyResults(d), a.Name)
+	for ak, event := range rh.Events {
+		if !event.Unevaluated {
+			r.Status[ak] = event.Status
+		}
+	}
+	for _, e := range t.Expect {
+		if e.Tags == nil {
+			if len(r.Status) == 0 && e.Status != StNormal.String() {
+				fail("expected %s, got no results", e.Status)
+			}
+			var aks expr.AlertKeys
+			for ak := range r.Status {
+				aks = append(aks, ak)
+			}
+			sort.Sort(aks)
+			for _, ak := range aks {
+				if st := r.Status[ak]; st.String() != e.Status {
+					fail("%s: expected %s, got %s", ak, e.Status, st)
+				}
+			}
+			continue
+		}
+		ak := expr.NewAlertKey(a.Name, e.Tags)
+		st, ok := r.Status[ak]
+		if !ok {
+			// Alert keys without a result are normal.
+			st = StNormal
+		}
+		if st.String() != e.Status {
+			fail("%s: expected %s, got %s", ak, e.Status, st)
+		}
+	}
+	return r
+}
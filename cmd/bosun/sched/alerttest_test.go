@@ -0,0 +1,53 @@
+package sched
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+)
+
+func TestAlertTests(t *testing.T) {
+	c, err := conf.New("", `
+		tsdbHost = localhost:4242
+		alert cpu {
+			$q = avg(q("avg:os.cpu{host=*}", "5m", ""))
+			crit = $q > 90
+			warn = $q > 70
+		}
+		test high {
+			alert = cpu
+			series = os.cpu{host=a} 95 96 97
+			series = os.cpu{host=b} 80 80 80
+			series = os.cpu{host=c} 10 10 10
+			expect = {host=a} crit
+			expect = {host=b} warn
+			expect = {host=c} normal
+			expect = {host=d} normal
+		}
+		test wrong {
+			alert = cpu
+			series = os.cpu{host=a} 60 90
+			expect = normal
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := s.TestAlerts(time.Now())
+	if len(results) != 2 || results[0].Name != "high" || results[1].Name != "wrong" {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if f := results[0].Failures; len(f) != 0 {
+		t.Fatalf("expected high to pass, got %v", f)
+	}
+	expected := []string{"cpu{host=a}: expected normal, got warning"}
+	if f := results[1].Failures; !reflect.DeepEqual(f, expected) {
+		t.Fatalf("expected %v, got %v", expected, f)
+	}
+}
@@ -0,0 +1,81 @@
+package sched
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/slog"
+)
+
+// tsdbAnnotator is a Hook that writes incident open and close markers to
+// OpenTSDB's annotation API, so graphs in any tool show when bosun fired and
+// recovered.
+type tsdbAnnotator struct {
+	NopHook
+	conf *conf.Conf
+}
+
+// tsdbAnnotation is a global OpenTSDB annotation. OpenTSDB identifies it by
+// StartTime, so the close of an incident updates the annotation of its open.
+// Times are in milliseconds, so incidents opened in the same run can each be
+// given their own StartTime.
+type tsdbAnnotation struct {
+	StartTime   int64             `json:"startTime"`
+	EndTime     int64             `json:"endTime,omitempty"`
+	Description string            `json:"description"`
+	Notes       string            `json:"notes"`
+	Custom      map[string]string `json:"custom"`
+}
+
+func (t *tsdbAnnotator) OnIncidentOpen(incident Incident)  { go t.write(incident) }
+func (t *tsdbAnnotator) OnIncidentClose(incident Incident) { go t.write(incident) }
+
+func (t *tsdbAnnotator) annotation(incident Incident) *tsdbAnnotation {
+	a := &tsdbAnnotation{
+		StartTime:   annotationStart(incident),
+		Description: fmt.Sprintf("bosun incident #%d: %s", incident.Id, incident.AlertKey),
+		Notes:       t.conf.MakeLink("/incident", &url.Values{"id": []string{fmt.Sprint(incident.Id)}}),
+		Custom: map[string]string{
+			"alertKey":   string(incident.AlertKey),
+			"alert":      incident.AlertKey.Name(),
+			"incidentId": fmt.Sprint(incident.Id),
+		},
+	}
+	if incident.End != nil {
+		a.EndTime = incident.End.UnixNano() / int64(time.Millisecond)
+	}
+	for k, v := range incident.AlertKey.Group() {
+		a.Custom["tag."+k] = v
+	}
+	return a
+}
+
+// annotationStart returns the StartTime of the annotation of incident: the
+// second it started, offset by its id in milliseconds. Incidents opened by
+// the same run start at the same time, and would otherwise overwrite each
+// other's annotation.
+func annotationStart(incident Incident) int64 {
+	return incident.Start.Unix()*1000 + int64(incident.Id%1000)
+}
+
+func (t *tsdbAnnotator) write(incident Incident) {
+	b, err := json.Marshal(t.annotation(incident))
+	if err != nil {
+		slog.Errorln(err)
+		return
+	}
+	resp, err := http.Post("http://"+t.conf.TSDBHost+"/api/annotation", "application/json", bytes.NewReader(b))
+	if err != nil {
+		slog.Errorf("writing annotation of incident %d: %v", incident.Id, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Errorf("writing annotation of incident %d: bad response: %s", incident.Id, resp.Status)
+	}
+}
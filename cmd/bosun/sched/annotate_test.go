@@ -0,0 +1,69 @@
+package sched
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestTSDBAnnotator(t *testing.T) {
+	got := make(chan tsdbAnnotation, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/annotation" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		var a tsdbAnnotation
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		got <- a
+	}))
+	defer ts.Close()
+	c, err := conf.New("", "tsdbHost = "+ts.Listener.Addr().String()+"\ntsdbAnnotations = true\nhostname = bosun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	incident := Incident{
+		Id:       3,
+		Start:    start,
+		End:      &end,
+		AlertKey: expr.NewAlertKey("a", opentsdb.TagSet{"host": "ny-1"}),
+	}
+	(&tsdbAnnotator{conf: c}).write(incident)
+	expected := tsdbAnnotation{
+		StartTime:   start.Unix()*1000 + 3,
+		EndTime:     end.Unix() * 1000,
+		Description: "bosun incident #3: a{host=ny-1}",
+		Notes:       "http://bosun/incident?id=3",
+		Custom: map[string]string{
+			"alertKey":   "a{host=ny-1}",
+			"alert":      "a",
+			"incidentId": "3",
+			"tag.host":   "ny-1",
+		},
+	}
+	if a := <-got; !reflect.DeepEqual(a, expected) {
+		t.Fatalf("got %+v, expected %+v", a, expected)
+	}
+	// Another incident opened in the same run must not overwrite the first.
+	other := incident
+	other.Id = 4
+	other.End = nil
+	(&tsdbAnnotator{conf: c}).write(other)
+	if a := <-got; a.StartTime != start.Unix()*1000+4 {
+		t.Fatalf("got start time %d for incident 4, expected %d", a.StartTime, start.Unix()*1000+4)
+	}
+	if _, err := conf.New("", "tsdbAnnotations = true"); err == nil {
+		t.Fatal("expected error without tsdbHost")
+	}
+}
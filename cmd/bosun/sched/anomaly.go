@@ -0,0 +1,150 @@
+package sched
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.anomaly.score", metadata.Gauge, metadata.Score,
+		"How many robust standard deviations the last value of a series is from its median, negative if below it.")
+	metadata.AddMetricMeta("bosun.anomaly.errors", metadata.Counter, metadata.Error,
+		"Number of failed evaluations of the expressions of anomalies.")
+}
+
+// minAnomalyPoints is the fewest values before the last a series needs to be
+// scored.
+const minAnomalyPoints = 5
+
+// RunAnomalies scores the series of each anomaly in the configuration on its
+// interval.
+func (s *Schedule) RunAnomalies() {
+	for _, a := range s.Conf.Anomalies {
+		go func(a *conf.Anomaly) {
+			s.everyAsLeader(a.Interval, func() {
+				if err := s.scoreAnomaly(a, time.Now()); err != nil {
+					slog.Errorf("anomaly %s: %v", a.Name, err)
+					collect.Add("anomaly.errors", opentsdb.TagSet{"anomaly": a.Name}, 1)
+				}
+			})
+		}(a)
+	}
+}
+
+// scoreAnomaly evaluates a's expression at now, and stores and records the
+// score of each series it returns.
+func (s *Schedule) scoreAnomaly(a *conf.Anomaly, now time.Time) error {
+	rh := s.NewRunHistory(now, nil)
+	results, _, err := a.Expr.Execute(rh.Context, rh.GraphiteContext, rh.Logstash, rh.InfluxConfig, rh.SQL, rh.CloudWatch, rh.Cache, nil, now, 0, false, s.Search, nil, rh, s.DataAccess.Events())
+	if err != nil {
+		return err
+	}
+	var scores []*expr.Result
+	for _, r := range results.Results {
+		series, ok := r.Value.(expr.Series)
+		if !ok {
+			return fmt.Errorf("expected series, got %v", r.Value.Type())
+		}
+		score, ok := anomalyScore(series)
+		if !ok {
+			continue
+		}
+		scores = append(scores, &expr.Result{
+			Value: expr.Number(score),
+			Group: r.Group,
+		})
+		tags := r.Group.Copy()
+		tags["anomaly"] = a.Name
+		if err := collect.Put("anomaly.score", tags, score); err != nil {
+			slog.Errorf("anomaly %s: %v", a.Name, err)
+		}
+	}
+	s.anomalyLock.Lock()
+	if s.anomalies == nil {
+		s.anomalies = make(map[string][]*expr.Result)
+	}
+	s.anomalies[a.Name] = scores
+	s.anomalyLock.Unlock()
+	return nil
+}
+
+// anomalyScore returns how far the last value of series is from its median
+// in robust standard deviations: the median absolute deviation of the other
+// values, scaled to estimate the standard deviation. If over half the values
+// are equal, the mean absolute deviation is used instead. Series with fewer
+// than minAnomalyPoints other values, or whose other values are all equal and
+// unlike the last, are not scored.
+func anomalyScore(series expr.Series) (float64, bool) {
+	if len(series) <= minAnomalyPoints {
+		return 0, false
+	}
+	times := make([]time.Time, 0, len(series))
+	for t := range series {
+		times = append(times, t)
+	}
+	sort.Sort(timeSlice(times))
+	last := series[times[len(times)-1]]
+	values := make([]float64, 0, len(times)-1)
+	for _, t := range times[:len(times)-1] {
+		values = append(values, series[t])
+	}
+	median := medianOf(values)
+	deviations := make([]float64, len(values))
+	var sum float64
+	for i, v := range values {
+		deviations[i] = math.Abs(v - median)
+		sum += deviations[i]
+	}
+	scale := 1.4826 * medianOf(deviations)
+	if scale == 0 {
+		scale = 1.2533 * sum / float64(len(deviations))
+	}
+	switch {
+	case last == median:
+		return 0, true
+	case scale == 0:
+		return 0, false
+	}
+	return (last - median) / scale, true
+}
+
+// medianOf returns the median of values, which it sorts.
+func medianOf(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+type timeSlice []time.Time
+
+func (t timeSlice) Len() int           { return len(t) }
+func (t timeSlice) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t timeSlice) Less(i, j int) bool { return t[i].Before(t[j]) }
+
+// AnomalyScores returns the last scores of the series of anomaly name.
+func (r *RunHistory) AnomalyScores(name string) []*expr.Result {
+	s := r.schedule
+	s.anomalyLock.Lock()
+	defer s.anomalyLock.Unlock()
+	scores := s.anomalies[name]
+	results := make([]*expr.Result, len(scores))
+	for i, res := range scores {
+		results[i] = &expr.Result{
+			Value: res.Value,
+			Group: res.Group.Copy(),
+		}
+	}
+	return results
+}
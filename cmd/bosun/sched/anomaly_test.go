@@ -0,0 +1,107 @@
+package sched
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/cache"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestAnomalyScore(t *testing.T) {
+	series := func(values ...float64) expr.Series {
+		s := make(expr.Series)
+		start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, v := range values {
+			s[start.Add(time.Duration(i)*time.Minute)] = v
+		}
+		return s
+	}
+	for i, test := range []struct {
+		series expr.Series
+		score  float64
+		ok     bool
+	}{
+		// The median is 10 and the median absolute deviation 1.
+		{series(9, 10, 11, 10, 9, 11, 10, 14), 4 / 1.4826, true},
+		{series(9, 10, 11, 10, 9, 11, 10, 7), -3 / 1.4826, true},
+		// Over half the values are 10, so the mean absolute deviation of
+		// 2/7 is used.
+		{series(10, 10, 10, 10, 10, 11, 11, 12), 2 / (1.2533 * 2 / 7), true},
+		{series(5, 5, 5, 5, 5, 5), 0, true},
+		{series(5, 5, 5, 5, 5, 6), 0, false},
+		{series(1, 2, 3, 4, 5), 0, false},
+	} {
+		score, ok := anomalyScore(test.series)
+		if ok != test.ok || math.Abs(score-test.score) > 1e-9 {
+			t.Errorf("%d: got %v %v, expected %v %v", i, score, ok, test.score, test.ok)
+		}
+	}
+}
+
+func TestAnomalies(t *testing.T) {
+	now := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := opentsdb.ResponseSet{
+			{Metric: "m", Tags: opentsdb.TagSet{"host": "a"}, DPS: map[string]opentsdb.Point{}},
+			{Metric: "m", Tags: opentsdb.TagSet{"host": "b"}, DPS: map[string]opentsdb.Point{}},
+		}
+		for i, v := range []opentsdb.Point{9, 10, 11, 10, 9, 11, 10} {
+			ts := fmt.Sprint(now.Add(time.Duration(i-7) * time.Minute).Unix())
+			resp[0].DPS[ts] = v
+			resp[1].DPS[ts] = v
+		}
+		resp[0].DPS[fmt.Sprint(now.Unix())] = 20
+		resp[1].DPS[fmt.Sprint(now.Unix())] = 10
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := conf.New("", fmt.Sprintf(`
+		tsdbHost = %s
+		anomaly cpu {
+			expression = q("avg:m{host=*}", "10m", "")
+			interval = 1m
+		}
+		alert a {
+			crit = anomaly("cpu") > 3
+		}
+	`, u.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.Alerts["a"]
+	rh := s.NewRunHistory(now, cache.New(0))
+	if results, err := s.executeExpr(nil, rh, a, a.Crit); err != nil || len(results.Results) != 0 {
+		t.Fatalf("expected no results before scoring, got %v %v", results, err)
+	}
+	if err := s.scoreAnomaly(c.Anomalies["cpu"], now); err != nil {
+		t.Fatal(err)
+	}
+	results, err := s.executeExpr(nil, rh, a, a.Crit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]expr.Value)
+	for _, r := range results.Results {
+		got[r.Group.String()] = r.Value
+	}
+	if len(got) != 2 || got["{host=a}"] != expr.Number(1) || got["{host=b}"] != expr.Number(0) {
+		t.Errorf("unexpected results %v", got)
+	}
+}
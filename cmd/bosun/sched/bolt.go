@@ -3,107 +3,52 @@ package sched
 import (
 	"bytes"
 	"compress/gzip"
-	"crypto/md5"
-	"encoding/base64"
 	"encoding/gob"
-	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"time"
 
 	"bosun.org/_third_party/github.com/boltdb/bolt"
-	"bosun.org/cmd/bosun/conf"
 	"bosun.org/cmd/bosun/database"
-	"bosun.org/cmd/bosun/expr"
-	"bosun.org/collect"
 	"bosun.org/metadata"
-	"bosun.org/opentsdb"
 	"bosun.org/slog"
 )
 
-func (s *Schedule) performSave() {
-	for {
-		time.Sleep(60 * 10 * time.Second) // wait 10 minutes to throttle.
-		s.save()
-	}
-}
-
-type counterWriter struct {
-	written int
-	w       io.Writer
-}
-
-func (c *counterWriter) Write(p []byte) (n int, err error) {
-	n, err = c.w.Write(p)
-	c.written += n
-	return n, err
-}
+// The state file is only read to import the state of older versions, which
+// kept everything but metadata and the search index in it.
 
 const (
-	dbBucket           = "bindata"
-	dbConfigTextBucket = "configText"
-	dbNotifications    = "notifications"
-	dbSilence          = "silence"
-	dbStatus           = "status"
-	dbIncidents        = "incidents"
-	dbErrors           = "errors"
+	dbBucket        = "bindata"
+	dbNotifications = "notifications"
+	dbSilence       = "silence"
+	dbStatus        = "status"
+	dbIncidents     = "incidents"
+	dbExclusions    = "exclusions"
+	dbMaintenance   = "maintenance"
+	dbLastRuns      = "lastRuns"
 )
 
-func (s *Schedule) save() {
-	if s.db == nil {
-		return
-	}
-	s.Lock("Save")
-	store := map[string]interface{}{
-		dbNotifications: s.Notifications,
-		dbSilence:       s.Silence,
-		dbStatus:        s.status,
-		dbIncidents:     s.Incidents,
-	}
-	tostore := make(map[string][]byte)
-	for name, data := range store {
-		f := new(bytes.Buffer)
-		gz := gzip.NewWriter(f)
-		cw := &counterWriter{w: gz}
-		enc := gob.NewEncoder(cw)
-		if err := enc.Encode(data); err != nil {
-			slog.Errorf("error saving %s: %v", name, err)
-			s.Unlock()
-			return
-		}
-		if err := gz.Flush(); err != nil {
-			slog.Errorf("gzip flush error saving %s: %v", name, err)
-		}
-		if err := gz.Close(); err != nil {
-			slog.Errorf("gzip close error saving %s: %v", name, err)
-		}
-		tostore[name] = f.Bytes()
-		slog.Infof("wrote %s: %v", name, conf.ByteSize(cw.written))
-		collect.Put("statefile.size", opentsdb.TagSet{"object": name}, cw.written)
-	}
-	s.Unlock()
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(dbBucket))
-		if err != nil {
-			return err
-		}
-		for name, data := range tostore {
-			if err := b.Put([]byte(name), data); err != nil {
-				return err
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		slog.Errorf("save db update error: %v", err)
-		return
+// stateFileObjects are the state file entries imported into the database.
+var stateFileObjects = []string{
+	dbNotifications,
+	dbSilence,
+	dbStatus,
+	dbIncidents,
+	dbExclusions,
+	dbMaintenance,
+	dbLastRuns,
+}
+
+// openStateFile opens the state file at path if it exists. Nil is returned
+// if it doesn't, since a new state file is never created.
+func openStateFile(path string) (*bolt.DB, error) {
+	if path == "" {
+		return nil, nil
 	}
-	fi, err := os.Stat(s.Conf.StateFile)
-	if err == nil {
-		collect.Put("statefile.size", opentsdb.TagSet{"object": "total"}, fi.Size())
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
 	}
-	slog.Infoln("save to db complete")
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
 }
 
 func decode(db *bolt.DB, name string, dst interface{}) error {
@@ -119,6 +64,11 @@ func decode(db *bolt.DB, name string, dst interface{}) error {
 	if err != nil {
 		return err
 	}
+	return decodeGzip(data, dst)
+}
+
+// decodeGzip decodes gzipped gob data into dst.
+func decodeGzip(data []byte, dst interface{}) error {
 	gr, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return err
@@ -127,159 +77,44 @@ func decode(db *bolt.DB, name string, dst interface{}) error {
 	return gob.NewDecoder(gr).Decode(dst)
 }
 
-// RestoreState restores notification and alert state from the file on disk.
-func (s *Schedule) RestoreState() error {
-	defer func() {
-		bosunStartupTime = time.Now()
-	}()
-	slog.Infoln("RestoreState")
-	start := time.Now()
-	s.Lock("RestoreState")
-	defer s.Unlock()
-	s.Search.Lock()
-	defer s.Search.Unlock()
-
-	s.Notifications = nil
-	db := s.db
-	notifications := make(map[expr.AlertKey]map[string]time.Time)
-	if err := decode(db, dbNotifications, &notifications); err != nil {
-		slog.Errorln(dbNotifications, err)
-	}
-	if err := decode(db, dbSilence, &s.Silence); err != nil {
-		slog.Errorln(dbSilence, err)
-	}
-	if err := decode(db, dbIncidents, &s.Incidents); err != nil {
-		slog.Errorln(dbIncidents, err)
-	}
-
-	// Calculate next incident id.
-	for _, i := range s.Incidents {
-		if i.Id > s.maxIncidentId {
-			s.maxIncidentId = i.Id
-		}
-	}
-	status := make(States)
-	if err := decode(db, dbStatus, &status); err != nil {
-		slog.Errorln(dbStatus, err)
-	}
-	clear := func(r *Result) {
-		if r == nil {
-			return
-		}
-		r.Computations = nil
+// MigrateStateFile imports the state file of an older version into the
+// database: alert states, pending notifications, silences, incidents,
+// exclusions, maintenance, and last runs, as well as the metadata and search
+// index of even older versions. Anything the database already has is kept.
+// The state file is marked as migrated, so it is only imported once. Nothing
+// is done if there is no state file.
+func (s *Schedule) MigrateStateFile() error {
+	if s.db == nil {
+		return nil
 	}
-	for ak, st := range status {
-		a, present := s.Conf.Alerts[ak.Name()]
-		if !present {
-			slog.Errorln("sched: alert no longer present, ignoring:", ak)
-			continue
-		} else if s.Conf.Squelched(a, st.Group) {
-			slog.Infoln("sched: alert now squelched:", ak)
-			continue
-		} else {
-			t := a.Unknown
-			if t == 0 {
-				t = s.Conf.CheckFrequency
-			}
-			if t == 0 && st.Last().Status == StUnknown {
-				st.Append(&Event{Status: StNormal, IncidentId: st.Last().IncidentId})
-			}
-		}
-		clear(st.Result)
-		newHistory := []Event{}
-		for _, e := range st.History {
-			clear(e.Warn)
-			clear(e.Crit)
-			// Remove error events which no longer are a thing.
-			if e.Status <= StUnknown {
-				newHistory = append(newHistory, e)
-			}
-		}
-		st.History = newHistory
-		s.status[ak] = st
-		if a.Log && st.Open {
-			st.Open = false
-			slog.Infof("sched: alert %s is now log, closing, was %s", ak, st.Status())
+	pending := false
+	objects := make(map[string][]byte)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(dbBucket))
+		if b == nil {
+			return nil
 		}
-		for name, t := range notifications[ak] {
-			n, present := s.Conf.Notifications[name]
-			if !present {
-				slog.Infoln("sched: notification not present during restore:", name)
-				continue
-			}
-			if a.Log {
-				slog.Infoln("sched: alert is now log, removing notification:", ak)
-				continue
+		pending = b.Get([]byte("isMigrated:state")) == nil
+		for _, name := range stateFileObjects {
+			if data := b.Get([]byte(name)); data != nil {
+				objects[name] = append([]byte(nil), data...)
 			}
-			s.AddNotification(ak, n, t)
 		}
-	}
-	if s.maxIncidentId == 0 {
-		s.createHistoricIncidents()
-	}
-	migrateOldDataToRedis(db, s.DataAccess)
-	// delete metrictags if they exist.
-	deleteKey(s.db, "metrictags")
-	slog.Infoln("RestoreState done in", time.Since(start))
-	return nil
-}
-
-type storedConfig struct {
-	Text     string
-	LastUsed time.Time
-}
-
-// Saves the provided config text in state file for later access.
-// Returns a hash of the file to be used as a retreival key.
-func (s *Schedule) SaveTempConfig(text string) (hash string, err error) {
-	sig := md5.Sum([]byte(text))
-	b64 := base64.StdEncoding.EncodeToString(sig[0:5])
-	data := storedConfig{Text: text, LastUsed: time.Now()}
-	bindata, err := json.Marshal(data)
-	if err != nil {
-		return "", err
-	}
-	err = s.db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(dbConfigTextBucket))
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(b64), bindata)
-	})
-	if err != nil {
-		return "", err
-	}
-	return b64, nil
-}
-
-// Retreive the specified config text from state file.
-func (s *Schedule) LoadTempConfig(hash string) (text string, err error) {
-	config := storedConfig{}
-	err = s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(dbConfigTextBucket))
-		data := b.Get([]byte(hash))
-		if data == nil || len(data) == 0 {
-			return fmt.Errorf("Config text '%s' not found", hash)
-		}
-		return json.Unmarshal(data, &config)
+		return nil
 	})
-	if err != nil {
-		return "", err
+	if err != nil || !pending {
+		return err
 	}
-	go s.SaveTempConfig(config.Text) //refresh timestamp.
-	return config.Text, nil
-}
-
-func (s *Schedule) GetStateFileBackup() ([]byte, error) {
-	buf := bytes.Buffer{}
-	err := s.db.View(func(tx *bolt.Tx) error {
-		_, err := tx.WriteTo(&buf)
+	slog.Infof("migrating state file %s to the database", s.Conf.StateFile)
+	if err := s.importObjects(objects, false); err != nil {
 		return err
-	})
-	if err != nil {
-		return nil, err
 	}
-	return buf.Bytes(), nil
+	if err := migrateOldDataToRedis(s.db, s.DataAccess); err != nil {
+		slog.Errorln("migrate metadata and search:", err)
+	}
+	// delete metrictags if they exist.
+	deleteKey(s.db, "metrictags")
+	return setMigrated(s.db, "state")
 }
 
 func migrateOldDataToRedis(db *bolt.DB, data database.DataAccess) error {
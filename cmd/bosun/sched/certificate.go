@@ -0,0 +1,122 @@
+package sched
+
+import (
+	"crypto/x509"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/bradfitz/slice"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+// Names of the certificate metadata of tls probes, stored with the tag
+// probe=<name>.
+const (
+	certSubject  = "certSubject"
+	certIssuer   = "certIssuer"
+	certSANs     = "certSANs"
+	certNotAfter = "certNotAfter"
+	// certChecked is when the probe found the certificate. Unchanged
+	// metadata is only rewritten daily, so its touch time is not this.
+	certChecked = "certChecked"
+)
+
+// Certificate is the last certificate a tls probe found on its target.
+type Certificate struct {
+	Probe    string
+	Target   string
+	Subject  string
+	Issuer   string
+	SANs     []string `json:",omitempty"`
+	NotAfter time.Time
+	// DaysLeft is the number of days until NotAfter, negative once it has
+	// passed.
+	DaysLeft float64
+	// Checked is when the probe last found the certificate.
+	Checked time.Time
+}
+
+// certDays returns the number of days from now until cert expires.
+func certDays(cert *x509.Certificate, now time.Time) float64 {
+	return cert.NotAfter.Sub(now).Hours() / 24
+}
+
+// putCertificate stores the details of cert, found by p at now, as metadata
+// of the probe.
+func (s *Schedule) putCertificate(p *conf.Probe, cert *x509.Certificate, now time.Time) error {
+	sans := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	tags := opentsdb.TagSet{"probe": p.Name}
+	ms := []metadata.Metasend{
+		{Tags: tags, Name: certSubject, Value: cert.Subject.String()},
+		{Tags: tags, Name: certIssuer, Value: cert.Issuer.String()},
+		{Tags: tags, Name: certSANs, Value: strings.Join(sans, ",")},
+		{Tags: tags, Name: certNotAfter, Value: cert.NotAfter.UTC().Format(time.RFC3339)},
+		{Tags: tags, Name: certChecked, Value: now.UTC().Format(time.RFC3339)},
+	}
+	return s.DataAccess.Metadata().PutMetadataBatch(ms, now)
+}
+
+// Certificates returns the certificates last found by each tls probe, in
+// order of expiry.
+func (s *Schedule) Certificates() ([]*Certificate, error) {
+	now := time.Now()
+	certs := []*Certificate{}
+	for _, p := range s.Conf.Probes {
+		if p.Type != conf.ProbeTLS {
+			continue
+		}
+		tms, err := s.DataAccess.Metadata().GetTagMetadata(opentsdb.TagSet{"probe": p.Name}, "")
+		if err != nil {
+			return nil, err
+		}
+		c := &Certificate{
+			Probe:  p.Name,
+			Target: p.Target,
+		}
+		found := false
+		for _, tm := range tms {
+			if len(tm.Tags) != 1 {
+				continue
+			}
+			switch tm.Name {
+			case certSubject:
+				c.Subject = tm.Value
+			case certIssuer:
+				c.Issuer = tm.Value
+			case certSANs:
+				if tm.Value != "" {
+					c.SANs = strings.Split(tm.Value, ",")
+				}
+			case certNotAfter:
+				t, err := time.Parse(time.RFC3339, tm.Value)
+				if err != nil {
+					return nil, err
+				}
+				c.NotAfter = t
+				c.DaysLeft = t.Sub(now).Hours() / 24
+				found = true
+			case certChecked:
+				t, err := time.Parse(time.RFC3339, tm.Value)
+				if err != nil {
+					return nil, err
+				}
+				c.Checked = t
+			}
+		}
+		if found {
+			certs = append(certs, c)
+		}
+	}
+	slice.Sort(certs, func(i, j int) bool {
+		if !certs[i].NotAfter.Equal(certs[j].NotAfter) {
+			return certs[i].NotAfter.Before(certs[j].NotAfter)
+		}
+		return certs[i].Probe < certs[j].Probe
+	})
+	return certs, nil
+}
@@ -5,6 +5,8 @@ import (
 	"image/color"
 	"image/png"
 	"io"
+	"math"
+	"time"
 
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
 	"bosun.org/_third_party/github.com/ajstarks/svgo"
@@ -12,7 +14,8 @@ import (
 	"bosun.org/_third_party/github.com/vdobler/chart"
 	"bosun.org/_third_party/github.com/vdobler/chart/imgg"
 	"bosun.org/_third_party/github.com/vdobler/chart/svgg"
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
+	"bosun.org/expr/parse"
 )
 
 var chartColors = []color.Color{
@@ -47,12 +50,7 @@ func (s *Schedule) ExprSVG(t miniprofiler.Timer, w io.Writer, width, height int,
 	if err != nil {
 		return err
 	}
-	g := svg.New(w)
-	g.StartviewUnit(100, 100, "%", 0, 0, width, height)
-	g.Rect(0, 0, width, height, "fill: #ffffff")
-	sgr := svgg.AddTo(g, 0, 0, width, height, "", 12, white)
-	ch.Plot(sgr)
-	g.End()
+	chartSVG(w, width, height, ch)
 	return nil
 }
 
@@ -61,6 +59,19 @@ func (s *Schedule) ExprPNG(t miniprofiler.Timer, w io.Writer, width, height int,
 	if err != nil {
 		return err
 	}
+	return chartPNG(w, width, height, ch)
+}
+
+func chartSVG(w io.Writer, width, height int, ch chart.Chart) {
+	g := svg.New(w)
+	g.StartviewUnit(100, 100, "%", 0, 0, width, height)
+	g.Rect(0, 0, width, height, "fill: #ffffff")
+	sgr := svgg.AddTo(g, 0, 0, width, height, "", 12, white)
+	ch.Plot(sgr)
+	g.End()
+}
+
+func chartPNG(w io.Writer, width, height int, ch chart.Chart) error {
 	g := image.NewRGBA(image.Rectangle{Min: image.ZP, Max: image.Pt(width, height)})
 	sgr := imgg.AddTo(g, 0, 0, width, height, white, nil, nil)
 	ch.Plot(sgr)
@@ -68,11 +79,80 @@ func (s *Schedule) ExprPNG(t miniprofiler.Timer, w io.Writer, width, height int,
 }
 
 func (s *Schedule) ExprGraph(t miniprofiler.Timer, unit string, res []*expr.Result) (chart.Chart, error) {
+	labels := make([]string, len(res))
+	for i, r := range res {
+		labels[i] = r.Group.String()
+	}
+	return exprChart(unit, res, labels, nil), nil
+}
+
+// graphMarks annotate a graph with an alert's thresholds and the time it
+// triggered.
+type graphMarks struct {
+	// Warn and Crit are NaN if the alert has no such threshold.
+	Warn, Crit float64
+	// Trigger is zero if the alert isn't abnormal.
+	Trigger time.Time
+}
+
+var (
+	warnColor = color.NRGBA{0xff, 0xa5, 0x00, 0xff}
+	critColor = color.NRGBA{0xe4, 0x1a, 0x1c, 0xff}
+)
+
+// bandStyle draws a threshold as a wide translucent band.
+func bandStyle(c color.NRGBA) chart.Style {
+	c.A = 0x60
+	return chart.Style{
+		LineStyle: chart.SolidLine,
+		LineWidth: 8,
+		LineColor: c,
+	}
+}
+
+// boundsStyle shades the expected range of series i with vertical bars in a
+// translucent version of its color.
+func boundsStyle(i int) chart.Style {
+	c := chartColors[i%len(chartColors)].(color.NRGBA)
+	c.A = 0x40
+	return chart.Style{
+		LineStyle: chart.SolidLine,
+		LineWidth: 1,
+		LineColor: color.NRGBA{},
+		FillColor: c,
+	}
+}
+
+// boundsPoints returns points whose error bars span b, sorted by time.
+func boundsPoints(b *expr.Bounds) []chart.EPoint {
+	var pts []chart.EPoint
+	for t, lo := range b.Lower {
+		hi, ok := b.Upper[t]
+		if !ok {
+			continue
+		}
+		pts = append(pts, chart.EPoint{
+			X:      float64(t.Unix()),
+			Y:      (lo + hi) / 2,
+			DeltaX: math.NaN(),
+			DeltaY: hi - lo,
+		})
+	}
+	slice.Sort(pts, func(i, j int) bool {
+		return pts[i].X < pts[j].X
+	})
+	return pts
+}
+
+// exprChart plots the series results res, labeled by labels, and marks.
+func exprChart(unit string, res []*expr.Result, labels []string, marks *graphMarks) *chart.ScatterChart {
 	c := chart.ScatterChart{
 		Key:    chart.Key{Pos: "itl"},
 		YRange: chart.Range{Label: unit},
 	}
 	c.XRange.Time = true
+	xmin, xmax := math.Inf(1), math.Inf(-1)
+	ymin, ymax := math.Inf(1), math.Inf(-1)
 	for ri, r := range res {
 		rv := r.Value.(expr.Series)
 		pts := make([]chart.EPoint, len(rv))
@@ -80,12 +160,71 @@ func (s *Schedule) ExprGraph(t miniprofiler.Timer, unit string, res []*expr.Resu
 		for k, v := range rv {
 			pts[idx].X = float64(k.Unix())
 			pts[idx].Y = v
+			xmin, xmax = math.Min(xmin, pts[idx].X), math.Max(xmax, pts[idx].X)
+			ymin, ymax = math.Min(ymin, v), math.Max(ymax, v)
 			idx++
 		}
 		slice.Sort(pts, func(i, j int) bool {
 			return pts[i].X < pts[j].X
 		})
-		c.AddData(r.Group.String(), pts, chart.PlotStyleLinesPoints, Autostyle(ri))
+		if r.Bounds != nil {
+			bpts := boundsPoints(r.Bounds)
+			for _, p := range bpts {
+				ymin, ymax = math.Min(ymin, p.Y-p.DeltaY/2), math.Max(ymax, p.Y+p.DeltaY/2)
+			}
+			c.AddData("", bpts, chart.PlotStyleLines, boundsStyle(ri))
+		}
+		c.AddData(labels[ri], pts, chart.PlotStyleLinesPoints, Autostyle(ri))
+	}
+	if marks == nil || xmin > xmax {
+		return &c
+	}
+	for _, t := range []struct {
+		name  string
+		value float64
+		color color.NRGBA
+	}{
+		{"warn", marks.Warn, warnColor},
+		{"crit", marks.Crit, critColor},
+	} {
+		if math.IsNaN(t.value) {
+			continue
+		}
+		ymin, ymax = math.Min(ymin, t.value), math.Max(ymax, t.value)
+		pts := []chart.EPoint{{X: xmin, Y: t.value}, {X: xmax, Y: t.value}}
+		c.AddData(t.name, pts, chart.PlotStyleLines, bandStyle(t.color))
+	}
+	if !marks.Trigger.IsZero() {
+		x := float64(marks.Trigger.Unix())
+		pts := []chart.EPoint{{X: x, Y: ymin}, {X: x, Y: ymax}}
+		c.AddData("triggered", pts, chart.PlotStyleLines, chart.Style{
+			LineStyle: chart.DashedLine,
+			LineWidth: 2,
+			LineColor: critColor,
+		})
+	}
+	return &c
+}
+
+// threshold returns the number an alert expression like "avg(q(...)) > 80"
+// compares against, or NaN if e isn't a comparison with a number.
+func threshold(e *expr.Expr) float64 {
+	if e == nil {
+		return math.NaN()
+	}
+	b, ok := e.Root.(*parse.BinaryNode)
+	if !ok {
+		return math.NaN()
+	}
+	switch b.OpStr {
+	case ">", ">=", "<", "<=":
+	default:
+		return math.NaN()
+	}
+	for _, a := range b.Args {
+		if n, ok := a.(*parse.NumberNode); ok {
+			return n.Float64
+		}
 	}
-	return &c, nil
+	return math.NaN()
 }
@@ -2,6 +2,7 @@ package sched
 
 import (
 	"fmt"
+	"html"
 	"math"
 	"time"
 
@@ -9,8 +10,8 @@ import (
 	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
 	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
 	"bosun.org/collect"
+	"bosun.org/expr"
 	"bosun.org/graphite"
 	"bosun.org/metadata"
 	"bosun.org/opentsdb"
@@ -34,6 +35,8 @@ func init() {
 		"The number of alerts by acknowledgement status and notification. Does not reflect escalation chains.")
 	metadata.AddMetricMeta("alerts.oldest_unacked_by_notification", metadata.Gauge, metadata.Second,
 		"How old the oldest unacknowledged notification is by notification.. Does not reflect escalation chains.")
+	metadata.AddMetricMeta("bosun.check.maxkeys_exceeded", metadata.Counter, metadata.Count,
+		"The number of checks of an alert that opened its maxKeys alert key instead of more alert keys than its maxKeys.")
 	collect.AggregateMeta("bosun.template.render", metadata.MilliSecond, "The amount of time it takes to render the specified alert template.")
 }
 
@@ -58,9 +61,18 @@ func (s *Schedule) GetStatus(ak expr.AlertKey) *State {
 }
 
 func (s *Schedule) SetStatus(ak expr.AlertKey, st *State) {
+	s.setStatus(ak, st, true)
+}
+
+// setStatus stores st as the state of ak, marking it to be written to the
+// database if dirty.
+func (s *Schedule) setStatus(ak expr.AlertKey, st *State, dirty bool) {
 	s.Lock("SetStatus")
 	s.status[ak] = st
 	s.Unlock()
+	if dirty {
+		s.markDirty(ak)
+	}
 }
 
 func (s *Schedule) GetOrCreateStatus(ak expr.AlertKey) *State {
@@ -69,6 +81,7 @@ func (s *Schedule) GetOrCreateStatus(ak expr.AlertKey) *State {
 	if state == nil {
 		state = NewStatus(ak)
 		s.status[ak] = state
+		s.markDirty(ak)
 	}
 	s.Unlock()
 	return state
@@ -80,9 +93,18 @@ type RunHistory struct {
 	Context         opentsdb.Context
 	GraphiteContext graphite.Context
 	InfluxConfig    client.Config
-	Logstash        expr.LogstashElasticHosts
+	Logstash        expr.ElasticHosts
+	SQL             expr.SQLDatabases
+	CloudWatch      expr.CloudWatchAccounts
 	Events          map[expr.AlertKey]*Event
 	schedule        *Schedule
+	// queries are the OpenTSDB queries issued in this run.
+	queries []opentsdb.Request
+	// opened are the alert keys that opened incidents in this run.
+	opened []expr.AlertKey
+	// overflow are the number of alert keys that would have opened, by the
+	// maxKeys alert key that opened instead.
+	overflow map[expr.AlertKey]int
 }
 
 // AtTime creates a new RunHistory starting at t with the same context and
@@ -101,7 +123,9 @@ func (s *Schedule) NewRunHistory(start time.Time, cache *cache.Cache) *RunHistor
 		Context:         s.Conf.TSDBContext(),
 		GraphiteContext: s.Conf.GraphiteContext(),
 		InfluxConfig:    s.Conf.InfluxConfig,
-		Logstash:        s.Conf.LogstashElasticHosts,
+		Logstash:        s.Conf.ElasticHosts,
+		SQL:             s.Conf.SQLDatabases,
+		CloudWatch:      s.Conf.CloudWatch,
 		schedule:        s,
 	}
 }
@@ -113,6 +137,9 @@ func (s *Schedule) RunHistory(r *RunHistory) {
 	for ak, event := range r.Events {
 		checkNotify = s.runHistory(r, ak, event, silenced) || checkNotify
 	}
+	if len(r.opened) > 0 {
+		s.snapshotIncidents(r)
+	}
 	if checkNotify && s.nc != nil {
 		select {
 		case s.nc <- true:
@@ -130,7 +157,9 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 		state = NewStatus(ak)
 		s.SetStatus(ak, state)
 	}
-	defer s.SetStatus(ak, state)
+	// Only write the state if the check changed more than its touch.
+	prior := state.Copy()
+	defer func() { s.setStatus(ak, state, state.changed(prior)) }()
 	// make sure we always touch the state.
 	state.Touched = r.Start
 	// set state.Result according to event result
@@ -146,6 +175,8 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 	}
 	// assign incident id to new event if applicable
 	prev := state.Last()
+	actions := len(state.Actions)
+	var opened *Incident
 	event.Time = r.Start
 	if prev.IncidentId != 0 {
 		// If last event has incident id and is not closed, we continue it.
@@ -157,16 +188,23 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 	}
 	if event.IncidentId == 0 && event.Status != StNormal {
 		// Otherwise, create new incident on first non-normal event.
-		event.IncidentId = s.createIncident(ak, event.Time).Id
+		opened = s.createIncident(ak, event.Time)
+		event.IncidentId = opened.Id
+		r.opened = append(r.opened, ak)
 	}
 	// add new event to state
 	last := state.AbnormalStatus()
 	state.Append(event)
 	a := s.Conf.Alerts[ak.Name()]
+	state.Severity = severity(a, state.AbnormalStatus())
 	wasOpen := state.Open
 	// render templates and open alert key if abnormal
 	if event.Status > StNormal {
-		s.executeTemplates(state, event, a, r)
+		if n, ok := r.overflow[ak]; ok {
+			overflowTemplates(state, a, n)
+		} else {
+			s.executeTemplates(state, event, a, r)
+		}
 		state.Open = true
 		if a.Log {
 			state.Open = false
@@ -186,39 +224,38 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 			state.LastLogTime = now
 		}
 		nots := ns.Get(s.Conf, state.Group)
+		sev := severity(a, event.Status)
 		for _, n := range nots {
+			if sev < n.MinSeverity {
+				continue
+			}
 			s.Notify(state, n)
 			checkNotify = true
 		}
 	}
 	notifyCurrent := func() {
-		// Auto close ignoreUnknowns.
-		if a.IgnoreUnknown && event.Status == StUnknown {
-			state.Open = false
-			state.Forgotten = true
-			state.NeedAck = false
-			state.Action("bosun", "Auto close because alert has ignoreUnknown.", ActionClose, event.Time)
-			slog.Infof("auto close %s because alert has ignoreUnknown", ak)
-			return
-		} else if silenced[ak].Forget && event.Status == StUnknown {
+		if silenced[ak].Forget && event.Status == StUnknown {
 			state.Open = false
 			state.Forgotten = true
 			state.NeedAck = false
-			state.Action("bosun", "Auto close because alert is silenced and marked auto forget.", ActionClose, event.Time)
+			state.Action("bosun", "Auto close because alert is silenced and marked auto forget.", "", ActionClose, event.Time)
 			slog.Infof("auto close %s because alert is silenced and marked auto forget", ak)
 			return
 		}
 		state.NeedAck = true
+		state.SnoozedUntil = nil
 		switch event.Status {
-		case StCritical, StUnknown:
+		case StCritical:
 			notify(a.CritNotification)
+		case StUnknown:
+			notify(a.UnknownNotifications())
 		case StWarning:
 			notify(a.WarnNotification)
 		}
 	}
 	clearOld := func() {
 		state.NeedAck = false
-		delete(s.Notifications, ak)
+		s.clearNotifications(ak)
 	}
 	// lock while we change notifications.
 	s.Lock("RunHistory")
@@ -232,14 +269,18 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 		clearOld()
 		notifyCurrent()
 	} else if event.Status < last {
-		if _, hasOld := s.Notifications[ak]; hasOld {
+		hasOld, err := s.DataAccess.Notifications().HasNotifications(string(ak))
+		if err != nil {
+			slog.Errorln("error checking notifications:", err)
+		}
+		if hasOld {
 			notifyCurrent()
 		}
 		// Auto close silenced alerts.
 		if _, ok := silenced[ak]; ok && event.Status == StNormal {
 			go func(ak expr.AlertKey) {
 				slog.Infof("auto close %s because was silenced", ak)
-				err := s.Action("bosun", "Auto close because was silenced.", ActionClose, ak)
+				err := s.Action("bosun", "Auto close because was silenced.", "", ActionClose, ak)
 				if err != nil {
 					slog.Errorln(err)
 				}
@@ -247,60 +288,101 @@ func (s *Schedule) runHistory(r *RunHistory, ak expr.AlertKey, event *Event, sil
 		}
 	}
 	s.Unlock()
+	if opened != nil {
+		incident := *opened
+		s.runHooks(func(h Hook) { h.OnIncidentOpen(incident) })
+	}
+	if event.Status != prev.Status {
+		s.countTransition(prev.Status, event.Status)
+		s.runHooks(func(h Hook) { h.OnStateChange(ak, prev.Status, *event) })
+	}
+	for _, action := range state.Actions[actions:] {
+		s.runHooks(func(h Hook) { h.OnAction(ak, action) })
+	}
 	return checkNotify
 }
 
+// executeTemplates renders the subject of state and snapshots it for its
+// notifications to be rendered from.
 func (s *Schedule) executeTemplates(state *State, event *Event, a *conf.Alert, r *RunHistory) {
 	state.Subject = ""
 	state.Body = ""
 	state.EmailBody = nil
 	state.EmailSubject = nil
 	state.Attachments = nil
+	state.rendering = nil
 	if event.Status != StUnknown {
-		metric := "template.render"
-		//Render subject
-		endTiming := collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "subject"})
-		subject, serr := s.ExecuteSubject(r, a, state, false)
-		if serr != nil {
-			slog.Infof("%s: %v", state.AlertKey(), serr)
-		}
-		endTiming()
-		//Render body
-		endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "body"})
-		body, _, berr := s.ExecuteBody(r, a, state, false)
-		if berr != nil {
-			slog.Infof("%s: %v", state.AlertKey(), berr)
-		}
-		endTiming()
-		//Render email body
-		endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "emailbody"})
-		emailbody, attachments, merr := s.ExecuteBody(r, a, state, true)
-		if merr != nil {
-			slog.Infof("%s: %v", state.AlertKey(), merr)
-		}
-		endTiming()
-		//Render email subject
-		endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "emailsubject"})
-		emailsubject, eserr := s.ExecuteSubject(r, a, state, true)
-		endTiming()
-		if serr != nil || berr != nil || merr != nil || eserr != nil {
-			var err error
+		state.Subject = s.renderSubject(state, a, r)
+		state.rendering = newRendering(state, a, r)
+	}
+}
 
-			endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "bad"})
-			subject, body, err = s.ExecuteBadTemplate(serr, berr, r, a, state)
-			endTiming()
+// overflowTemplates sets the subject and body of the maxKeys alert key of a,
+// since the alert's template expects the tags of its other alert keys.
+func overflowTemplates(state *State, a *conf.Alert, n int) {
+	state.Subject = fmt.Sprintf("alert %s matched %d groups, more than its maxKeys of %d", a.Name, n, a.MaxKeys)
+	state.Body = fmt.Sprintf("<p>%s. Only this alert key was opened for them; check the alert's expression.", html.EscapeString(state.Subject))
+	state.EmailSubject = []byte(state.Subject)
+	state.EmailBody = []byte(state.Body)
+	state.Attachments = nil
+	state.rendering = nil
+}
 
-			if err != nil {
-				subject = []byte(fmt.Sprintf("unable to create template error notification: %v", err))
-			}
-			emailbody = body
-			attachments = nil
+// message is the rendered subject and body of an alert key's notification.
+type message struct {
+	Subject, Body           string
+	EmailSubject, EmailBody []byte
+	Attachments             []*conf.Attachment
+}
+
+// renderTemplates renders the template of a for state. Template errors are
+// reported in the message instead.
+func (s *Schedule) renderTemplates(state *State, a *conf.Alert, r *RunHistory) *message {
+	metric := "template.render"
+	//Render subject
+	endTiming := collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "subject"})
+	subject, serr := s.ExecuteSubject(r, a, state, false)
+	if serr != nil {
+		slog.Infof("%s: %v", state.AlertKey(), serr)
+	}
+	endTiming()
+	//Render body
+	endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "body"})
+	body, _, berr := s.ExecuteBody(r, a, state, false)
+	if berr != nil {
+		slog.Infof("%s: %v", state.AlertKey(), berr)
+	}
+	endTiming()
+	//Render email body
+	endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "emailbody"})
+	emailbody, attachments, merr := s.ExecuteBody(r, a, state, true)
+	if merr != nil {
+		slog.Infof("%s: %v", state.AlertKey(), merr)
+	}
+	endTiming()
+	//Render email subject
+	endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "emailsubject"})
+	emailsubject, eserr := s.ExecuteSubject(r, a, state, true)
+	endTiming()
+	if serr != nil || berr != nil || merr != nil || eserr != nil {
+		var err error
+
+		endTiming = collect.StartTimer(metric, opentsdb.TagSet{"alert": a.Name, "type": "bad"})
+		subject, body, err = s.ExecuteBadTemplate(serr, berr, r, a, state)
+		endTiming()
+
+		if err != nil {
+			subject = []byte(fmt.Sprintf("unable to create template error notification: %v", err))
 		}
-		state.Subject = string(subject)
-		state.Body = string(body)
-		state.EmailBody = emailbody
-		state.EmailSubject = emailsubject
-		state.Attachments = attachments
+		emailbody = body
+		attachments = nil
+	}
+	return &message{
+		Subject:      string(subject),
+		Body:         string(body),
+		EmailSubject: emailsubject,
+		EmailBody:    emailbody,
+		Attachments:  attachments,
 	}
 }
 
@@ -348,6 +430,9 @@ func (s *Schedule) CollectStates() {
 		for name := range alertDef.CritNotification.Get(s.Conf, state.Group) {
 			nots[name] = true
 		}
+		for name := range alertDef.UnknownNotifications().Get(s.Conf, state.Group) {
+			nots[name] = true
+		}
 		incident, err := s.GetIncident(state.Last().IncidentId)
 		if err != nil {
 			slog.Errorln(err)
@@ -455,6 +540,95 @@ func (r *RunHistory) GetUnknownAndUnevaluatedAlertKeys(alert string) (unknown, u
 	return unknown, uneval
 }
 
+func (r *RunHistory) CountAbnormalAlertKeys(alert string, critical bool, d time.Duration) (now, before int) {
+	min := StWarning
+	if critical {
+		min = StCritical
+	}
+	abnormal := func(s Status) bool {
+		return s >= min && s != StUnknown
+	}
+	then := r.Start.Add(-d)
+	r.schedule.Lock("CountAbnormal")
+	for ak, st := range r.schedule.status {
+		if ak.Name() != alert {
+			continue
+		}
+		if abnormal(st.Last().Status) {
+			now++
+		}
+		for i := len(st.History) - 1; i >= 0; i-- {
+			if !st.History[i].Time.After(then) {
+				if abnormal(st.History[i].Status) {
+					before++
+				}
+				break
+			}
+		}
+	}
+	r.schedule.Unlock()
+	return now, before
+}
+
+func (r *RunHistory) MinStatuses(alert string, d time.Duration) map[expr.AlertKey]int {
+	then := r.Start.Add(-d)
+	statuses := make(map[expr.AlertKey]int)
+	r.schedule.Lock("MinStatuses")
+	for ak, st := range r.schedule.status {
+		if ak.Name() != alert || len(st.History) == 0 {
+			continue
+		}
+		min := StUnknown
+		for i := len(st.History) - 1; i >= 0; i-- {
+			e := st.History[i]
+			if e.Status != StNone && e.Status < min {
+				min = e.Status
+			}
+			// The event in effect at the start of the window is the last
+			// one considered.
+			if !e.Time.After(then) {
+				break
+			}
+		}
+		statuses[ak] = int(min - StNormal)
+	}
+	r.schedule.Unlock()
+	return statuses
+}
+
+func (r *RunHistory) LastAckTimes(alert string) map[expr.AlertKey]time.Time {
+	acks := make(map[expr.AlertKey]time.Time)
+	r.schedule.Lock("LastAckTimes")
+	for ak, st := range r.schedule.status {
+		if ak.Name() != alert {
+			continue
+		}
+		for _, a := range st.Actions {
+			if a.Type == ActionAcknowledge && a.Time.After(acks[ak]) {
+				acks[ak] = a.Time
+			}
+		}
+	}
+	r.schedule.Unlock()
+	return acks
+}
+
+func (r *RunHistory) IncidentCounts(alert string, d time.Duration) map[expr.AlertKey]int {
+	then := r.Start.Add(-d)
+	counts := make(map[expr.AlertKey]int)
+	s := r.schedule
+	s.Lock("IncidentCounts")
+	s.incidentLock.Lock()
+	for _, i := range s.Incidents {
+		if i.AlertKey.Name() == alert && i.Start.After(then) && !i.Start.After(r.Start) {
+			counts[i.AlertKey]++
+		}
+	}
+	s.incidentLock.Unlock()
+	s.Unlock()
+	return counts
+}
+
 var bosunStartupTime = time.Now()
 
 func (s *Schedule) findUnknownAlerts(now time.Time, alert string) []expr.AlertKey {
@@ -462,20 +636,25 @@ func (s *Schedule) findUnknownAlerts(now time.Time, alert string) []expr.AlertKe
 	if time.Now().Sub(bosunStartupTime) < s.Conf.CheckFrequency {
 		return keys
 	}
+	a := s.Conf.Alerts[alert]
+	if a == nil || a.IgnoreUnknown {
+		return keys
+	}
+	t := a.Unknown
+	if t == 0 {
+		t = s.Conf.AlertInterval(a) * 2
+	}
 	s.Lock("FindUnknown")
 	for ak, st := range s.status {
-		name := ak.Name()
-		if name != alert || st.Forgotten || !s.AlertSuccessful(ak.Name()) {
+		if ak.Name() != alert || st.Forgotten || !s.AlertSuccessful(alert) {
 			continue
 		}
-		a := s.Conf.Alerts[name]
-		t := a.Unknown
-		if t == 0 {
-			t = s.Conf.CheckFrequency * 2 * time.Duration(a.RunEvery)
-		}
 		if now.Sub(st.Touched) < t {
 			continue
 		}
+		if s.inMaintenance(now, ak.Group()) != nil {
+			continue
+		}
 		keys = append(keys, ak)
 	}
 	s.Unlock()
@@ -485,6 +664,12 @@ func (s *Schedule) findUnknownAlerts(now time.Time, alert string) []expr.AlertKe
 func (s *Schedule) CheckAlert(T miniprofiler.Timer, r *RunHistory, a *conf.Alert) {
 	slog.Infof("check alert %v start", a.Name)
 	start := time.Now()
+	queries := len(r.queries)
+	var prof *exprProfiler
+	if T == nil {
+		prof = newExprProfiler()
+		T = &prof.root
+	}
 	for _, ak := range s.findUnknownAlerts(r.Start, a.Name) {
 		r.Events[ak] = &Event{Status: StUnknown}
 	}
@@ -497,19 +682,103 @@ func (s *Schedule) CheckAlert(T miniprofiler.Timer, r *RunHistory, a *conf.Alert
 		if err == nil {
 			warns, err = s.CheckExpr(T, r, a, a.Warn, StWarning, crits)
 		}
+		if err == nil && a.RenotifyValue != nil {
+			err = s.setRenotifyValues(T, r, a)
+		}
 	}
 	unevalCount, unknownCount := markDependenciesUnevaluated(r.Events, deps, a.Name)
+	s.limitKeys(r, a)
+	run := &AlertRun{Time: r.Start}
 	if err != nil {
 		slog.Errorf("Error checking alert %s: %s", a.Name, err.Error())
 		removeUnknownEvents(r.Events, a.Name)
 		s.markAlertError(a.Name, err)
+		run.Error = err.Error()
 	} else {
 		s.markAlertSuccessful(a.Name)
 	}
+	for ak, ev := range r.Events {
+		if ak.Name() != a.Name {
+			continue
+		}
+		run.Results++
+		if ev.Unevaluated {
+			run.Unevaluated++
+		}
+		switch ev.Status {
+		case StNormal:
+			run.Normal++
+		case StWarning:
+			run.Warning++
+		case StCritical:
+			run.Critical++
+		case StUnknown:
+			run.Unknown++
+		}
+	}
+	for _, q := range r.queries[queries:] {
+		run.Queries = append(run.Queries, q.String())
+	}
+	run.Duration = time.Since(start).Seconds()
+	s.setLastRun(a.Name, run)
+	if prof != nil {
+		s.saveProfile(a, r.Start, prof)
+	}
 	collect.Put("check.duration", opentsdb.TagSet{"name": a.Name}, time.Since(start).Seconds())
 	slog.Infof("check alert %v done (%s): %v crits, %v warns, %v unevaluated, %v unknown", a.Name, time.Since(start), len(crits), len(warns), unevalCount, unknownCount)
 }
 
+// maxKeysGroup is the group of the alert key opened for an alert when more
+// than its maxKeys alert keys would.
+var maxKeysGroup = opentsdb.TagSet{"maxKeys": "exceeded"}
+
+// limitKeys replaces the events of a's alert keys that would open in r with
+// an event for a's maxKeys alert key, if there are more than a.MaxKeys of
+// them, so a bad expression can't flood states and notifications. The maxKeys
+// alert key is normal while fewer would open.
+func (s *Schedule) limitKeys(r *RunHistory, a *conf.Alert) {
+	if a.MaxKeys == 0 {
+		return
+	}
+	overflow := expr.NewAlertKey(a.Name, maxKeysGroup)
+	var opening expr.AlertKeys
+	s.Lock("LimitKeys")
+	defer s.Unlock()
+	for ak, ev := range r.Events {
+		if ak.Name() != a.Name || ak == overflow || ev.Status <= StNormal || ev.Status == StUnknown || ev.Unevaluated {
+			continue
+		}
+		if st := s.status[ak]; st != nil && st.Open {
+			continue
+		}
+		opening = append(opening, ak)
+	}
+	if len(opening) <= a.MaxKeys {
+		if s.status[overflow] != nil {
+			r.Events[overflow] = &Event{Status: StNormal}
+		}
+		return
+	}
+	status := StNormal
+	for _, ak := range opening {
+		if ev := r.Events[ak]; ev.Status > status {
+			status = ev.Status
+		}
+		delete(r.Events, ak)
+		// Keep known alert keys from going unknown while they are limited.
+		if st := s.status[ak]; st != nil {
+			st.Touched = r.Start
+		}
+	}
+	r.Events[overflow] = &Event{Status: status}
+	if r.overflow == nil {
+		r.overflow = make(map[expr.AlertKey]int)
+	}
+	r.overflow[overflow] = len(opening)
+	collect.Add("check.maxkeys_exceeded", opentsdb.TagSet{"alert": a.Name}, 1)
+	slog.Warningf("alert %s matched %d groups, more than its maxKeys of %d", a.Name, len(opening), a.MaxKeys)
+}
+
 func removeUnknownEvents(evs map[expr.AlertKey]*Event, alert string) {
 	for k, v := range evs {
 		if v.Status == StUnknown && k.Name() == alert {
@@ -562,7 +831,19 @@ func (s *Schedule) executeExpr(T miniprofiler.Timer, rh *RunHistory, a *conf.Ale
 	if e == nil {
 		return nil, nil
 	}
-	results, _, err := e.Execute(rh.Context, rh.GraphiteContext, rh.Logstash, rh.InfluxConfig, rh.Cache, T, rh.Start, 0, a.UnjoinedOK, s.Search, s.Conf.AlertSquelched(a), rh)
+	var results *expr.Results
+	var queries []opentsdb.Request
+	var err error
+	execute := func(T miniprofiler.Timer) {
+		results, queries, err = e.Execute(rh.Context, rh.GraphiteContext, rh.Logstash, rh.InfluxConfig, rh.SQL, rh.CloudWatch, rh.Cache, T, rh.Start, 0, a.UnjoinedOK, s.Search, s.Conf.AlertSquelched(a), rh, s.DataAccess.Events())
+	}
+	// Profiled checks record each of the alert's expressions as a step.
+	if st, ok := T.(*stepTimer); ok {
+		st.Step(exprName(a, e), execute)
+	} else {
+		execute(T)
+	}
+	rh.queries = append(rh.queries, queries...)
 	return results, err
 }
 
@@ -583,7 +864,7 @@ func (s *Schedule) CheckExpr(T miniprofiler.Timer, rh *RunHistory, a *conf.Alert
 	}
 Loop:
 	for _, r := range results.Results {
-		if s.Conf.Squelched(a, r.Group) {
+		if s.Conf.Squelched(a, r.Group) || s.Excluded(a, r.Group) {
 			continue
 		}
 		ak := expr.NewAlertKey(a.Name, r.Group)
@@ -6,11 +6,15 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/opentsdb"
 )
 
@@ -87,7 +91,7 @@ func TestCheckFlapping(t *testing.T) {
 	}
 	s.RunHistory(r)
 	// Close the alert, so it should notify next time.
-	if err := s.Action("", "", ActionClose, ak); err != nil {
+	if err := s.Action("", "", "", ActionClose, ak); err != nil {
 		t.Fatal(err)
 	}
 	r.Events[ak].Status = StWarning
@@ -129,7 +133,7 @@ func TestCheckSilence(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_, err = s.AddSilence(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), "a", "", false, true, "", "user", "message")
+	_, err = s.AddSilence(time.Now().Add(-time.Hour), time.Now().Add(time.Hour), "a", "", "", "", false, true, "", "user", "message")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -141,6 +145,17 @@ func TestCheckSilence(t *testing.T) {
 	case <-time.After(time.Second * 2):
 		// Timeout *probably* means the silence worked
 	}
+	effects := s.SilenceEffects("")
+	if len(effects) != 1 {
+		t.Fatalf("expected 1 silence, got %d", len(effects))
+	}
+	e := effects[0]
+	if len(e.AlertKeys) != 1 || e.AlertKeys[0] != "a{}" || e.Matched != 1 {
+		t.Errorf("expected silence of a{}, got %v of %d", e.AlertKeys, e.Matched)
+	}
+	if e.Silence.Suppressed != 1 {
+		t.Errorf("expected 1 suppressed notification, got %d", e.Silence.Suppressed)
+	}
 }
 
 func TestIncidentIds(t *testing.T) {
@@ -180,7 +195,7 @@ func TestIncidentIds(t *testing.T) {
 	r.Events[ak].Status = StNormal
 	r.Events[ak].IncidentId = 0
 	s.RunHistory(r)
-	err = s.Action("", "", ActionClose, ak)
+	err = s.Action("", "", "", ActionClose, ak)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -233,6 +248,69 @@ func TestCheckNotify(t *testing.T) {
 	}
 }
 
+func TestCheckNotifyChainPersisted(t *testing.T) {
+	nc := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nc <- r.URL.Path
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := conf.New("", fmt.Sprintf(`
+		template t {
+			subject = s
+		}
+		notification n {
+			post = http://%s/n
+			next = n
+			timeout = 10m
+		}
+		alert a {
+			template = t
+			warnNotification = n
+			warn = 1
+		}
+	`, u.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s, time.Now())
+	s.CheckNotifications()
+	if p := <-nc; p != "/n" {
+		t.Fatalf("expected /n, got %v", p)
+	}
+	da := s.DataAccess.(*nopDataAccess)
+	due, ok := da.notifications["a{}"]["n"]
+	if !ok || due.Sub(time.Now()) < 9*time.Minute {
+		t.Fatalf("expected n to be scheduled in 10m, got %v", da.notifications)
+	}
+
+	// A new schedule, as after a restart, sends n again from the database
+	// once it is due.
+	s2 := new(Schedule)
+	s2.DataAccess = da
+	if err := s2.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	s2.status = s.status
+	da.InsertNotification("a{}", "n", time.Now().Add(-time.Minute))
+	s2.CheckNotifications()
+	select {
+	case p := <-nc:
+		if p != "/n" {
+			t.Fatalf("expected /n, got %v", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("failed to receive notification before timeout")
+	}
+}
+
 func TestCheckNotifyUnknown(t *testing.T) {
 	nc := make(chan string, 1)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -295,6 +373,74 @@ Loop:
 	}
 }
 
+func TestCheckNotifyUnknownDigest(t *testing.T) {
+	nc := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		nc <- string(b)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := conf.New("", fmt.Sprintf(`
+		minGroupSize = 2
+		template t {
+			subject = template
+		}
+		template digest {
+			subject = {{.Total}} unknown alerts in {{len .Groups}} groups
+		}
+		unknownDigestTemplate = digest
+		unknownBatchSize = 3
+		notification n {
+			post = http://%s/
+		}
+		alert a {
+			template = t
+			critNotification = n
+			crit = 1
+		}
+		alert b {
+			template = t
+			critNotification = n
+			crit = 1
+		}
+	`, u.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RunHistory{
+		Events: map[expr.AlertKey]*Event{
+			expr.NewAlertKey("a", opentsdb.TagSet{"h": "x"}): {Status: StUnknown},
+			expr.NewAlertKey("a", opentsdb.TagSet{"h": "y"}): {Status: StUnknown},
+			expr.NewAlertKey("b", opentsdb.TagSet{"h": "z"}): {Status: StUnknown},
+		},
+	}
+	s.RunHistory(r)
+	// The batch size is reached, so the digest is sent without waiting for
+	// the batch window.
+	s.CheckNotifications()
+	select {
+	case r := <-nc:
+		if r != "3 unknown alerts in 2 groups" {
+			t.Fatalf("unexpected: %v", r)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("digest not sent")
+	}
+	select {
+	case r := <-nc:
+		t.Fatalf("unexpected second notification: %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 // TestCheckNotifyUnknownDefault tests the default unknownTemplate.
 func TestCheckNotifyUnknownDefault(t *testing.T) {
 	nc := make(chan string, 1)
@@ -357,6 +503,59 @@ Loop:
 	}
 }
 
+func TestCheckNotifyUnknownNotification(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = template
+		}
+		notification page {
+			print = true
+		}
+		notification queue {
+			print = true
+		}
+		alert a {
+			template = t
+			critNotification = page
+			unknownNotification = queue
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crit := expr.NewAlertKey("a", opentsdb.TagSet{"h": "x"})
+	unknown := expr.NewAlertKey("a", opentsdb.TagSet{"h": "y"})
+	s.RunHistory(&RunHistory{
+		Events: map[expr.AlertKey]*Event{
+			crit:    {Status: StCritical},
+			unknown: {Status: StUnknown},
+		},
+	})
+	for name, ak := range map[string]expr.AlertKey{"page": crit, "queue": unknown} {
+		states := s.pendingNotifications[c.Notifications[name]]
+		if len(states) != 1 || states[0].AlertKey() != ak {
+			t.Errorf("%s: expected only %s pending, got %v", name, ak, states)
+		}
+	}
+	if _, err := conf.New("", `
+		notification queue {
+			print = true
+		}
+		alert a {
+			crit = 1
+			ignoreUnknown = true
+			unknownNotification = queue
+		}
+	`); err == nil {
+		t.Error("expected error with ignoreUnknown")
+	}
+}
+
 func TestCheckNotifyLog(t *testing.T) {
 	nc := make(chan string, 1)
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -462,6 +661,7 @@ func TestCheckCritUnknownEmpty(t *testing.T) {
 	}
 	verify := func(empty bool) {
 		st := s.GetStatus(ak)
+		s.RenderBody(st)
 		if empty {
 			if st.Body != "" || st.Subject != "" {
 				t.Fatalf("expected empty body and subject")
@@ -484,3 +684,375 @@ func TestCheckCritUnknownEmpty(t *testing.T) {
 	s.RunHistory(r)
 	verify(true)
 }
+
+func TestAbnormalGrowth(t *testing.T) {
+	c, err := conf.New("", `
+		alert cpu {
+			crit = 1
+		}
+		alert spreading {
+			crit = abnormalGrowth("cpu", "crit", "5m")
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	add := func(host string, events ...Event) {
+		ak := expr.NewAlertKey("cpu", opentsdb.TagSet{"host": host})
+		st := NewStatus(ak)
+		st.History = events
+		s.status[ak] = st
+	}
+	normal := Event{Status: StNormal, Time: now.Add(-time.Hour)}
+	for _, host := range []string{"a", "b", "c"} {
+		add(host, normal, Event{Status: StCritical, Time: now.Add(-time.Minute)})
+	}
+	add("d", Event{Status: StCritical, Time: now.Add(-time.Hour)})
+	add("e", Event{Status: StCritical, Time: now.Add(-time.Hour)}, Event{Status: StNormal, Time: now.Add(-2 * time.Minute)})
+	add("f", normal, Event{Status: StWarning, Time: now.Add(-time.Minute)})
+	rh := s.NewRunHistory(now, cache.New(0))
+	a := c.Alerts["spreading"]
+	results, err := s.executeExpr(nil, rh, a, a.Crit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a, b, and c became critical, and e recovered.
+	if v := results.Results[0].Value; v != expr.Scalar(2) {
+		t.Fatalf("expected growth of 2, got %v", v)
+	}
+}
+
+func TestAlertStateFuncs(t *testing.T) {
+	c, err := conf.New("", `
+		alert cpu {
+			crit = 1
+		}
+		alert warned {
+			crit = status("cpu", "30m")
+		}
+		alert acked {
+			crit = lastAckTime("cpu")
+		}
+		alert flapping {
+			crit = incidentCount("cpu", "1d")
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ack := now.Add(-time.Hour).Truncate(time.Second)
+	add := func(host string, events ...Event) *State {
+		ak := expr.NewAlertKey("cpu", opentsdb.TagSet{"host": host})
+		st := NewStatus(ak)
+		st.History = events
+		s.status[ak] = st
+		return st
+	}
+	// a has been warning or worse for 40 minutes, b became warning 10
+	// minutes ago, and c is normal.
+	add("a", Event{Status: StWarning, Time: now.Add(-40 * time.Minute)}, Event{Status: StCritical, Time: now.Add(-5 * time.Minute)})
+	add("b", Event{Status: StNormal, Time: now.Add(-time.Hour)}, Event{Status: StWarning, Time: now.Add(-10 * time.Minute)})
+	st := add("c", Event{Status: StNormal, Time: now.Add(-time.Hour)})
+	st.Actions = []Action{{Type: ActionAcknowledge, Time: ack.Add(-time.Hour)}, {Type: ActionAcknowledge, Time: ack}, {Type: ActionClose, Time: now}}
+	for _, start := range []time.Duration{2 * time.Hour, 3 * time.Hour, 5 * time.Hour, 48 * time.Hour} {
+		s.createIncident(expr.NewAlertKey("cpu", opentsdb.TagSet{"host": "c"}), now.Add(-start))
+	}
+	rh := s.NewRunHistory(now, cache.New(0))
+	values := func(alert string) map[string]expr.Value {
+		a := c.Alerts[alert]
+		results, err := s.executeExpr(nil, rh, a, a.Crit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]expr.Value)
+		for _, r := range results.Results {
+			m[r.Group["host"]] = r.Value
+		}
+		return m
+	}
+	if v := values("warned"); v["a"] != expr.Number(1) || v["b"] != expr.Number(0) || v["c"] != expr.Number(0) {
+		t.Errorf("unexpected statuses: %v", v)
+	}
+	if v := values("acked"); len(v) != 1 || v["c"] != expr.Number(ack.Unix()) {
+		t.Errorf("unexpected ack times: %v", v)
+	}
+	if v := values("flapping"); len(v) != 1 || v["c"] != expr.Number(3) {
+		t.Errorf("unexpected incident counts: %v", v)
+	}
+}
+
+func TestLastRuns(t *testing.T) {
+	c, err := conf.New("", `
+		alert cpu {
+			crit = 1
+		}
+		alert warned {
+			crit = status("cpu", "30m")
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for host, status := range map[string]Status{"a": StCritical, "b": StNormal, "c": StNormal} {
+		ak := expr.NewAlertKey("cpu", opentsdb.TagSet{"host": host})
+		st := NewStatus(ak)
+		st.History = []Event{{Status: status, Time: now.Add(-time.Hour)}}
+		s.status[ak] = st
+	}
+	rh := s.NewRunHistory(now, cache.New(0))
+	s.CheckAlert(nil, rh, c.Alerts["warned"])
+	run := s.LastRuns()["warned"]
+	if run == nil {
+		t.Fatal("missing last run")
+	}
+	if !run.Time.Equal(now) || run.Results != 3 || run.Critical != 1 || run.Normal != 2 || run.Error != "" {
+		t.Errorf("unexpected last run: %+v", run)
+	}
+	if _, ok := s.LastRuns()["cpu"]; ok {
+		t.Errorf("unexpected last run of unchecked alert")
+	}
+}
+
+func TestAlertProfile(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1 + 1
+			warn = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	rh := s.NewRunHistory(now, cache.New(0))
+	s.CheckAlert(nil, rh, c.Alerts["a"])
+	p, err := s.DataAccess.Profiles().GetAlertProfile("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.Alert != "a" || !p.Time.Equal(now) {
+		t.Fatalf("unexpected profile: %+v", p)
+	}
+	var names []string
+	for _, step := range p.Steps {
+		names = append(names, step.Name)
+		if len(step.Steps) != 1 || step.Steps[0].Name != "expr execute" {
+			t.Errorf("unexpected steps of %s: %+v", step.Name, step.Steps)
+		}
+	}
+	if fmt.Sprint(names) != "[crit warn]" {
+		t.Errorf("unexpected steps: %v", names)
+	}
+	// Profiling must not record computations as the web UI's timer does.
+	ev := rh.Events[expr.NewAlertKey("a", nil)]
+	if ev == nil || ev.Crit == nil || len(ev.Crit.Computations) != 0 {
+		t.Errorf("unexpected crit result: %+v", ev)
+	}
+}
+
+func TestUnknownAfter(t *testing.T) {
+	c, err := conf.New("", `
+		alert late {
+			crit = 1
+			unknownAfter = 1h
+		}
+		alert ignored {
+			crit = 1
+			ignoreUnknown = true
+		}
+		alert default {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(t time.Time) { bosunStartupTime = t }(bosunStartupTime)
+	bosunStartupTime = time.Date(1900, 0, 0, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+	for name := range c.Alerts {
+		ak := expr.NewAlertKey(name, nil)
+		st := NewStatus(ak)
+		st.Touched = now.Add(-30 * time.Minute)
+		s.status[ak] = st
+	}
+	for name, expected := range map[string]int{"late": 0, "ignored": 0, "default": 1} {
+		if got := len(s.findUnknownAlerts(now, name)); got != expected {
+			t.Errorf("%s: got %d unknown, expected %d", name, got, expected)
+		}
+	}
+	if got := len(s.findUnknownAlerts(now.Add(time.Hour), "late")); got != 1 {
+		t.Errorf("late: got %d unknown after an hour, expected 1", got)
+	}
+}
+
+func TestHostMaintenance(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(t time.Time) { bosunStartupTime = t }(bosunStartupTime)
+	bosunStartupTime = time.Date(1900, 0, 0, 0, 0, 0, 0, time.UTC)
+	now := time.Now()
+	down := expr.NewAlertKey("a", opentsdb.TagSet{"host": "down"})
+	up := expr.NewAlertKey("a", opentsdb.TagSet{"host": "up"})
+	for _, ak := range []expr.AlertKey{down, up} {
+		st := NewStatus(ak)
+		st.Touched = now.Add(-time.Hour)
+		s.status[ak] = st
+	}
+	if _, err := s.SetMaintenance("down", now.Add(-time.Minute), "user", "reboot"); err == nil {
+		t.Error("expected error for maintenance ending in the past")
+	}
+	if _, err := s.SetMaintenance("down", time.Time{}, "user", "reboot"); err != nil {
+		t.Fatal(err)
+	}
+	now = time.Now()
+	if unknown := s.findUnknownAlerts(now, "a"); len(unknown) != 1 || unknown[0] != up {
+		t.Errorf("expected only %s unknown, got %v", up, unknown)
+	}
+	silenced := s.Silenced()
+	if _, ok := silenced[down]; !ok {
+		t.Errorf("expected %s to be silenced", down)
+	}
+	if _, ok := silenced[up]; ok {
+		t.Errorf("expected %s not to be silenced", up)
+	}
+	if m := s.GetMaintenance()["down"]; m == nil || m.User != "user" {
+		t.Errorf("unexpected maintenance %v", m)
+	}
+	if err := s.ClearMaintenance("down"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ClearMaintenance("down"); err == nil {
+		t.Error("expected error clearing a host not in maintenance")
+	}
+	if unknown := s.findUnknownAlerts(now, "a"); len(unknown) != 2 {
+		t.Errorf("expected 2 unknown after maintenance ended, got %v", unknown)
+	}
+}
+
+func TestCheckSeverity(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = s
+		}
+		notification mail {
+			print = true
+		}
+		notification page {
+			print = true
+			minSeverity = error
+		}
+		alert a {
+			template = t
+			warn = 1
+			warnSeverity = info
+			warnNotification = mail,page
+		}
+		alert b {
+			template = t
+			crit = 1
+			critSeverity = emergency
+			critNotification = mail,page
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(s, time.Now())
+	if sev := s.status["a{}"].Severity; sev != conf.SevInfo {
+		t.Errorf("expected a{} to be info, got %s", sev)
+	}
+	sent := make(map[string][]string)
+	for n, states := range s.pendingNotifications {
+		for _, st := range states {
+			sent[n.Name] = append(sent[n.Name], string(st.AlertKey()))
+		}
+	}
+	sort.Strings(sent["mail"])
+	expected := map[string][]string{
+		"mail": {"a{}", "b{}"},
+		"page": {"b{}"},
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("got notifications %v, expected %v", sent, expected)
+	}
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := groups.Groups.NeedAck; len(g) != 2 || g[0].Severity != conf.SevEmergency || g[1].Severity != conf.SevInfo {
+		t.Errorf("expected emergency group before info group, got %v", g)
+	}
+}
+
+func TestCheckMaxKeys(t *testing.T) {
+	series := opentsdb.ResponseSet{}
+	for _, h := range []string{"a", "b", "c"} {
+		series = append(series, &opentsdb.Response{
+			Metric: "m",
+			Tags:   opentsdb.TagSet{"h": h},
+			DPS:    map[string]opentsdb.Point{"0": 1},
+		})
+	}
+	s := testSched(t, &schedTest{
+		conf: `
+		defaultMaxKeys = 2
+		alert a {
+			crit = avg(q("avg:m{h=*}", "5m", "")) > 0
+		}
+		alert b {
+			crit = avg(q("avg:m{h=*}", "5m", "")) > 0
+			maxKeys = 3
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{h=*}", ` + window5Min + `)`: series,
+		},
+		state: map[schedState]bool{
+			schedState{"a{maxKeys=exceeded}", "critical"}: true,
+			schedState{"b{h=a}", "critical"}:              true,
+			schedState{"b{h=b}", "critical"}:              true,
+			schedState{"b{h=c}", "critical"}:              true,
+		},
+	})
+	st := s.status["a{maxKeys=exceeded}"]
+	if expected := "alert a matched 3 groups, more than its maxKeys of 2"; st.Subject != expected {
+		t.Errorf("got subject %q, expected %q", st.Subject, expected)
+	}
+}
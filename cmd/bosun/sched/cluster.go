@@ -0,0 +1,151 @@
+package sched
+
+import (
+	"sort"
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+// IncidentCluster is a set of incidents whose alert keys share tags and that
+// started close together, which may have a common cause.
+type IncidentCluster struct {
+	// Tags are the tags the alert keys of all the incidents have.
+	Tags opentsdb.TagSet
+	// From and To are when the first and last of the incidents started.
+	From, To time.Time
+	// Alerts are the names of the incidents' alerts.
+	Alerts    []string
+	Incidents []*Incident
+}
+
+// ClusterIncidents returns clusters of at least min incidents started between
+// from and to whose alert keys have the same value of a tag key in by, or of
+// any tag key if by is empty, and where each incident started within gap of
+// the one before it. Clusters of the same incidents are merged, and clusters
+// whose incidents are all in a larger cluster are left out. They are sorted
+// by size, largest first.
+func (s *Schedule) ClusterIncidents(by []string, gap time.Duration, min int, from, to time.Time) []*IncidentCluster {
+	incidents := s.GetIncidents("", "", from, to)
+	sort.Sort(incidentsByStart(incidents))
+	// Candidates are the incidents of each tag pair, in order of start.
+	candidates := make(map[string][]*Incident)
+	pairs := make(map[string]opentsdb.TagSet)
+	for _, i := range incidents {
+		tags := i.AlertKey.Group()
+		keys := by
+		if len(keys) == 0 {
+			keys = make([]string, 0, len(tags))
+			for k := range tags {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			v, ok := tags[k]
+			if !ok {
+				continue
+			}
+			pair := opentsdb.TagSet{k: v}
+			candidates[pair.String()] = append(candidates[pair.String()], i)
+			pairs[pair.String()] = pair
+		}
+	}
+	// Split each tag pair's incidents at gaps, and merge the clusters of the
+	// same incidents.
+	clusters := make(map[string]*IncidentCluster)
+	for id, list := range candidates {
+		start := 0
+		for j := 1; j <= len(list); j++ {
+			if j < len(list) && list[j].Start.Sub(list[j-1].Start) <= gap {
+				continue
+			}
+			if members := list[start:j]; len(members) >= min {
+				key := clusterKey(members)
+				c := clusters[key]
+				if c == nil {
+					c = newIncidentCluster(members)
+					clusters[key] = c
+				}
+				c.Tags = c.Tags.Merge(pairs[id])
+			}
+			start = j
+		}
+	}
+	result := []*IncidentCluster{}
+	for _, c := range clusters {
+		if !containedCluster(c, clusters) {
+			result = append(result, c)
+		}
+	}
+	sort.Sort(clusterList(result))
+	return result
+}
+
+type clusterList []*IncidentCluster
+
+func (c clusterList) Len() int      { return len(c) }
+func (c clusterList) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c clusterList) Less(i, j int) bool {
+	a, b := c[i], c[j]
+	if len(a.Incidents) != len(b.Incidents) {
+		return len(a.Incidents) > len(b.Incidents)
+	}
+	if !a.From.Equal(b.From) {
+		return a.From.Before(b.From)
+	}
+	return a.Tags.String() < b.Tags.String()
+}
+
+func newIncidentCluster(incidents []*Incident) *IncidentCluster {
+	c := &IncidentCluster{
+		Tags:      make(opentsdb.TagSet),
+		From:      incidents[0].Start,
+		To:        incidents[len(incidents)-1].Start,
+		Incidents: make([]*Incident, len(incidents)),
+	}
+	alerts := make(map[string]bool)
+	for i, incident := range incidents {
+		c.Incidents[i] = incident
+		if name := incident.AlertKey.Name(); !alerts[name] {
+			alerts[name] = true
+			c.Alerts = append(c.Alerts, name)
+		}
+	}
+	sort.Strings(c.Alerts)
+	return c
+}
+
+// clusterKey identifies a cluster by its incidents.
+func clusterKey(incidents []*Incident) string {
+	b := make([]byte, 0, len(incidents)*8)
+	for _, i := range incidents {
+		for shift := uint(0); shift < 64; shift += 8 {
+			b = append(b, byte(i.Id>>shift))
+		}
+	}
+	return string(b)
+}
+
+// containedCluster returns whether all the incidents of c are in a larger
+// cluster of clusters.
+func containedCluster(c *IncidentCluster, clusters map[string]*IncidentCluster) bool {
+	ids := make(map[uint64]bool, len(c.Incidents))
+	for _, i := range c.Incidents {
+		ids[i.Id] = true
+	}
+	for _, o := range clusters {
+		if len(o.Incidents) <= len(c.Incidents) {
+			continue
+		}
+		n := 0
+		for _, i := range o.Incidents {
+			if ids[i.Id] {
+				n++
+			}
+		}
+		if n == len(c.Incidents) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package sched
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"bosun.org/expr"
+)
+
+func TestClusterIncidents(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2016, 3, 1, hour, min, 0, 0, time.UTC)
+	}
+	s := &Schedule{Incidents: make(map[uint64]*Incident)}
+	for i, incident := range []struct {
+		ak    string
+		start time.Time
+	}{
+		{"a{host=h1,rack=R7}", at(2, 10)},
+		{"b{host=h2,rack=R7}", at(2, 15)},
+		{"c{host=h3,rack=R7}", at(2, 25)},
+		{"d{host=h1}", at(2, 12)},
+		{"a{host=h9,rack=R7}", at(5, 0)},
+		{"e{dc=ny,host=x}", at(3, 0)},
+		{"f{dc=ny,host=x}", at(3, 5)},
+	} {
+		id := uint64(i + 1)
+		s.Incidents[id] = &Incident{Id: id, AlertKey: expr.AlertKey(incident.ak), Start: incident.start}
+	}
+	ids := func(c *IncidentCluster) []uint64 {
+		var ids []uint64
+		for _, i := range c.Incidents {
+			ids = append(ids, i.Id)
+		}
+		return ids
+	}
+	for _, test := range []struct {
+		by       []string
+		min      int
+		expected []string
+	}{
+		{nil, 2, []string{
+			"{rack=R7} [1 2 3] [a b c]",
+			"{host=h1} [1 4] [a d]",
+			"{dc=ny,host=x} [6 7] [e f]",
+		}},
+		{[]string{"rack"}, 2, []string{"{rack=R7} [1 2 3] [a b c]"}},
+		{nil, 4, nil},
+	} {
+		clusters := s.ClusterIncidents(test.by, 10*time.Minute, test.min, at(0, 0), at(23, 0))
+		if len(clusters) != len(test.expected) {
+			t.Errorf("by %v min %d: got %d clusters, expected %d", test.by, test.min, len(clusters), len(test.expected))
+			continue
+		}
+		for i, c := range clusters {
+			if got := c.Tags.String() + " " + fmt.Sprint(ids(c)) + " " + fmt.Sprint(c.Alerts); got != test.expected[i] {
+				t.Errorf("by %v: cluster %d: got %s, expected %s", test.by, i, got, test.expected[i])
+			}
+		}
+	}
+	if c := s.ClusterIncidents(nil, 10*time.Minute, 2, at(0, 0), at(23, 0))[0]; !c.From.Equal(at(2, 10)) || !c.To.Equal(at(2, 25)) {
+		t.Errorf("got cluster from %v to %v", c.From, c.To)
+	}
+}
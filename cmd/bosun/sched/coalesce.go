@@ -0,0 +1,196 @@
+package sched
+
+import (
+	"bytes"
+	"fmt"
+	htemplate "html/template"
+	"net/url"
+	"sort"
+	ttemplate "text/template"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+// coalesced are the states held for a notification with a coalesceWindow
+// whose alert keys have the same values of its coalesceBy tag keys.
+type coalesced struct {
+	group opentsdb.TagSet
+	// due is when the states are sent: the coalesceWindow after the first
+	// of them was held.
+	due    time.Time
+	states map[expr.AlertKey]*State
+}
+
+// coalesceGroup returns the tags of ak that n's notifications are coalesced
+// by. Alert keys without some of the tag keys are coalesced with the others
+// that lack them.
+func coalesceGroup(n *conf.Notification, ak expr.AlertKey) opentsdb.TagSet {
+	tags := ak.Group()
+	g := make(opentsdb.TagSet)
+	for _, k := range n.CoalesceBy {
+		if v, ok := tags[k]; ok {
+			g[k] = v
+		}
+	}
+	return g
+}
+
+// coalesce holds n for st until its group is due. s must be locked.
+func (s *Schedule) coalesce(st *State, n *conf.Notification, now time.Time) {
+	ak := st.AlertKey()
+	st.NotifiedValue = st.Last().Value
+	s.markDirty(ak)
+	if s.coalescing == nil {
+		s.coalescing = make(map[*conf.Notification]map[string]*coalesced)
+	}
+	groups := s.coalescing[n]
+	if groups == nil {
+		groups = make(map[string]*coalesced)
+		s.coalescing[n] = groups
+	}
+	g := coalesceGroup(n, ak)
+	c := groups[g.String()]
+	if c == nil {
+		c = &coalesced{
+			group:  g,
+			due:    now.Add(n.CoalesceWindow),
+			states: make(map[expr.AlertKey]*State),
+		}
+		groups[g.String()] = c
+	}
+	c.states[ak] = st
+}
+
+// sendCoalesced sends the held notifications that are due at now, and
+// returns when the next are due, or the zero time if none are held. Alert
+// keys acknowledged, closed, or silenced while they were held are left out.
+// A group of one is sent as a normal notification. s must be locked.
+func (s *Schedule) sendCoalesced(now time.Time, silenced map[expr.AlertKey]Silence) time.Time {
+	var next time.Time
+	for n, groups := range s.coalescing {
+		for id, c := range groups {
+			if c.due.After(now) {
+				if next.IsZero() || c.due.Before(next) {
+					next = c.due
+				}
+				continue
+			}
+			delete(groups, id)
+			var states []*State
+			for ak := range c.states {
+				st := s.status[ak]
+				if si, isSilenced := silenced[ak]; isSilenced {
+					s.countSuppressed(si, n.Name)
+					continue
+				}
+				if st == nil || !st.NeedAck {
+					continue
+				}
+				// Alerts in dry run mode record their notifications
+				// themselves.
+				if s.isDryRun(string(ak)) {
+					s.notify(st, n)
+					continue
+				}
+				states = append(states, st)
+			}
+			switch len(states) {
+			case 0:
+			case 1:
+				s.notify(states[0], n)
+			default:
+				s.notifyCoalesced(n, c.group, states, now)
+			}
+		}
+		if len(groups) == 0 {
+			delete(s.coalescing, n)
+		}
+	}
+	return next
+}
+
+var defaultCoalesceTemplate = &conf.Template{
+	Subject: ttemplate.Must(ttemplate.New("").Parse(`{{len .States}} alerts{{if .Group}} for {{.Group}}{{end}}`)),
+	Body: htemplate.Must(htemplate.New("").Parse(`
+		<p>{{len .States}} alerts{{if .Group}} for {{.Group}}{{end}} at {{.Time}}:
+		<ul>
+		{{range .States}}
+			<li><a href="{{$.IncidentLink .Last.IncidentId}}">#{{.Last.IncidentId}}</a> {{.Last.Status}}: {{.Subject}}</li>
+		{{end}}
+		</ul>
+	`)),
+}
+
+// notifyCoalesced sends n once for states, whose alert keys have the tags of
+// group. Like notify, rendering and delivery are done by a worker.
+func (s *Schedule) notifyCoalesced(n *conf.Notification, group opentsdb.TagSet, states []*State, now time.Time) {
+	sort.Sort(coalescedStates(states))
+	copies := make([]*State, len(states))
+	for i, st := range states {
+		st.NotifiedValue = st.Last().Value
+		s.markDirty(st.AlertKey())
+		s.restoreRendering(st)
+		copies[i] = st.Copy()
+	}
+	go func() {
+		renderSem <- true
+		defer func() { <-renderSem }()
+		for _, st := range copies {
+			m := s.stateMessage(st, n)
+			st.Subject, st.Body = m.Subject, m.Body
+		}
+		t := n.CoalesceTemplate
+		if t == nil {
+			t = defaultCoalesceTemplate
+		}
+		data := &coalescedContext{
+			Notification: n.Name,
+			Group:        group,
+			States:       copies,
+			Time:         now.UTC(),
+			schedule:     s,
+		}
+		subject := new(bytes.Buffer)
+		body := new(bytes.Buffer)
+		if t.Subject != nil {
+			if err := t.Subject.Execute(subject, data); err != nil {
+				slog.Infoln("coalesce template error:", err)
+			}
+		}
+		if t.Body != nil {
+			if err := t.Body.Execute(body, data); err != nil {
+				slog.Infoln("coalesce template error:", err)
+			}
+		}
+		s.deliver(n, nil, "coalesced"+group.String(), subject.String(), body.String(), subject.Bytes(), body.Bytes())
+	}()
+}
+
+type coalescedStates []*State
+
+func (c coalescedStates) Len() int           { return len(c) }
+func (c coalescedStates) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c coalescedStates) Less(i, j int) bool { return c[i].AlertKey() < c[j].AlertKey() }
+
+// coalescedContext is the data a notification's coalesceTemplate is rendered
+// with.
+type coalescedContext struct {
+	Notification string
+	// Group are the coalesceBy tags of the alert keys.
+	Group  opentsdb.TagSet
+	States []*State
+	Time   time.Time
+
+	schedule *Schedule
+}
+
+// IncidentLink returns the URL of incident i.
+func (c *coalescedContext) IncidentLink(i uint64) string {
+	return c.schedule.Conf.MakeLink("/incident", &url.Values{
+		"id": []string{fmt.Sprint(i)},
+	})
+}
@@ -0,0 +1,128 @@
+package sched
+
+import (
+	"sort"
+	"time"
+
+	"bosun.org/expr"
+	"bosun.org/slog"
+)
+
+// ConsistencyReport lists stored state that refers to alerts or
+// notifications that are no longer in the configuration.
+type ConsistencyReport struct {
+	Time time.Time
+
+	// States are the alert keys with state whose alert is not defined.
+	States expr.AlertKeys
+
+	// Notifications are the pending notifications, by alert key, that are
+	// not defined.
+	Notifications map[expr.AlertKey][]string
+
+	// Silences are the ids of silences for alerts that are not defined.
+	Silences []string
+
+	// Cleaned is true if the above were removed.
+	Cleaned bool
+}
+
+// Empty returns true if r found nothing inconsistent.
+func (r *ConsistencyReport) Empty() bool {
+	return len(r.States) == 0 && len(r.Notifications) == 0 && len(r.Silences) == 0
+}
+
+// CheckConsistency compares the stored alert states, pending notifications,
+// and silences with the configuration. If clean is true, those referring to
+// alerts or notifications that no longer exist are removed.
+func (s *Schedule) CheckConsistency(clean bool) (*ConsistencyReport, error) {
+	r := &ConsistencyReport{
+		Time:          time.Now().UTC(),
+		Notifications: make(map[expr.AlertKey][]string),
+		Cleaned:       clean,
+	}
+	stored, err := s.DataAccess.State().GetStates()
+	if err != nil {
+		return nil, err
+	}
+	pending, err := s.DataAccess.Notifications().GetDueNotifications(time.Now().AddDate(100, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+	s.Lock("CheckConsistency")
+	states := make(map[expr.AlertKey]bool)
+	for k := range stored {
+		states[expr.AlertKey(k)] = true
+	}
+	for ak := range s.status {
+		states[ak] = true
+	}
+	for ak := range states {
+		if s.Conf.Alerts[ak.Name()] != nil {
+			continue
+		}
+		r.States = append(r.States, ak)
+		if clean {
+			delete(s.status, ak)
+			s.markDirty(ak)
+		}
+	}
+	s.Unlock()
+	sort.Sort(r.States)
+	nd := s.DataAccess.Notifications()
+	for key, ns := range pending {
+		ak := expr.AlertKey(key)
+		for name := range ns {
+			if s.Conf.Notifications[name] != nil {
+				continue
+			}
+			r.Notifications[ak] = append(r.Notifications[ak], name)
+			if clean {
+				if err := nd.ClearNotification(key, name); err != nil {
+					return nil, err
+				}
+			}
+		}
+		sort.Strings(r.Notifications[ak])
+	}
+	silenceLock.Lock()
+	for id, si := range s.Silence {
+		if si.Alert == "" || s.Conf.Alerts[si.Alert] != nil {
+			continue
+		}
+		r.Silences = append(r.Silences, id)
+		if clean {
+			delete(s.Silence, id)
+		}
+	}
+	silenceLock.Unlock()
+	sort.Strings(r.Silences)
+	return r, nil
+}
+
+// logConsistency checks consistency at startup, removing what is
+// inconsistent if cleanState is set, and logs a summary.
+func (s *Schedule) logConsistency() {
+	r, err := s.CheckConsistency(s.Conf.CleanState)
+	if err != nil {
+		slog.Errorln("consistency check:", err)
+		return
+	}
+	if r.Empty() {
+		return
+	}
+	action := "found"
+	if r.Cleaned {
+		action = "removed"
+	}
+	slog.Warningf("consistency check %s %d states of unknown alerts, %d alert keys with unknown notifications pending, and %d silences of unknown alerts; see /api/consistency", action, len(r.States), len(r.Notifications), len(r.Silences))
+	for _, ak := range r.States {
+		slog.Warningln("state of unknown alert:", ak)
+	}
+	for ak, names := range r.Notifications {
+		slog.Warningln("unknown notifications pending for", ak, names)
+	}
+	for _, id := range r.Silences {
+		slog.Warningln("silence of unknown alert:", id)
+	}
+}
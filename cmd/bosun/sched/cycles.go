@@ -0,0 +1,91 @@
+package sched
+
+import (
+	"time"
+
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.cycle.status", metadata.Gauge, metadata.Alert,
+		"The number of alert keys by status at the end of a check cycle.")
+	metadata.AddMetricMeta("bosun.cycle.transitions", metadata.Gauge, metadata.Alert,
+		"The number of alert keys that became critical, unknown, or recovered during a check cycle.")
+}
+
+// countTransition counts the change of an alert key's status from prev to
+// cur in the summary of the current check cycle.
+func (s *Schedule) countTransition(prev, cur Status) {
+	if prev == cur {
+		return
+	}
+	s.cycleLock.Lock()
+	defer s.cycleLock.Unlock()
+	switch {
+	case cur == StCritical:
+		s.cycle.NewCritical++
+	case cur == StUnknown:
+		s.cycle.NewUnknown++
+	case cur == StNormal && prev > StNormal:
+		s.cycle.Recovered++
+	}
+}
+
+// summarizeCycle returns the summary of the check cycle ending at now, and
+// starts the next. s must be locked.
+func (s *Schedule) summarizeCycle(now time.Time) *models.CycleSummary {
+	s.cycleLock.Lock()
+	c := s.cycle
+	s.cycle = models.CycleSummary{}
+	s.cycleLock.Unlock()
+	c.Time = now.UTC()
+	for _, st := range s.status {
+		switch st.Status() {
+		case StNormal:
+			c.Normal++
+		case StWarning:
+			c.Warning++
+		case StCritical:
+			c.Critical++
+		case StUnknown:
+			c.Unknown++
+		}
+	}
+	return &c
+}
+
+// recordCycle sends c as metrics and stores it in the database.
+func (s *Schedule) recordCycle(c *models.CycleSummary) {
+	for status, n := range map[string]int{
+		"normal":   c.Normal,
+		"warning":  c.Warning,
+		"critical": c.Critical,
+		"unknown":  c.Unknown,
+	} {
+		if err := collect.Put("cycle.status", opentsdb.TagSet{"status": status}, n); err != nil {
+			slog.Errorln(err)
+		}
+	}
+	for transition, n := range map[string]int{
+		"new_critical": c.NewCritical,
+		"new_unknown":  c.NewUnknown,
+		"recovered":    c.Recovered,
+	} {
+		if err := collect.Put("cycle.transitions", opentsdb.TagSet{"transition": transition}, n); err != nil {
+			slog.Errorln(err)
+		}
+	}
+	if err := s.DataAccess.State().AddCycleSummary(c); err != nil {
+		slog.Errorln("error recording cycle summary:", err)
+	}
+}
+
+// CycleSummaries returns the summaries of the last n check cycles, newest
+// first.
+func (s *Schedule) CycleSummaries(n int) ([]*models.CycleSummary, error) {
+	return s.DataAccess.State().GetCycleSummaries(n)
+}
@@ -0,0 +1,62 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+func TestCycleSummary(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web1 := expr.NewAlertKey("a", opentsdb.TagSet{"host": "web1"})
+	web2 := expr.NewAlertKey("a", opentsdb.TagSet{"host": "web2"})
+	web3 := expr.NewAlertKey("a", opentsdb.TagSet{"host": "web3"})
+	cycle := func(statuses map[expr.AlertKey]Status) *models.CycleSummary {
+		r := &RunHistory{Start: time.Now(), Events: make(map[expr.AlertKey]*Event)}
+		for ak, status := range statuses {
+			r.Events[ak] = &Event{Status: status}
+		}
+		s.RunHistory(r)
+		s.Lock("Test")
+		defer s.Unlock()
+		return s.summarizeCycle(time.Now())
+	}
+	first := cycle(map[expr.AlertKey]Status{web1: StCritical, web2: StUnknown, web3: StWarning})
+	if first.Critical != 1 || first.Unknown != 1 || first.Warning != 1 || first.Normal != 0 {
+		t.Errorf("unexpected counts in first cycle: %+v", first)
+	}
+	if first.NewCritical != 1 || first.NewUnknown != 1 || first.Recovered != 0 {
+		t.Errorf("unexpected transitions in first cycle: %+v", first)
+	}
+	second := cycle(map[expr.AlertKey]Status{web1: StNormal, web2: StNormal, web3: StWarning})
+	if second.Normal != 2 || second.Warning != 1 || second.Critical != 0 {
+		t.Errorf("unexpected counts in second cycle: %+v", second)
+	}
+	if second.NewCritical != 0 || second.NewUnknown != 0 || second.Recovered != 2 {
+		t.Errorf("unexpected transitions in second cycle: %+v", second)
+	}
+	s.recordCycle(first)
+	s.recordCycle(second)
+	summaries, err := s.CycleSummaries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0] != second {
+		t.Errorf("expected the second cycle, got %v", summaries)
+	}
+}
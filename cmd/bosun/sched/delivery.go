@@ -0,0 +1,425 @@
+package sched
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.notifications.dryrun", metadata.Counter, metadata.Count,
+		"Number of notifications recorded instead of sent because of dry run mode.")
+	metadata.AddMetricMeta("bosun.notifications.suppressed", metadata.Counter, metadata.Count,
+		"Number of notifications dropped by a notification's quiet hours or maxPerHour, or because their incident closed while they were postponed.")
+	metadata.AddMetricMeta("bosun.notifications.silenced", metadata.Counter, metadata.Count,
+		"Number of notifications not sent because their alert key was silenced.")
+}
+
+const (
+	deliveryRetryInterval = 30 * time.Second
+	// maxAttemptLog is the number of attempts kept in a delivery's
+	// AttemptLog.
+	maxAttemptLog = 20
+	// maxRetryBackoff caps the wait before a retry, however many retries a
+	// notification allows.
+	maxRetryBackoff = 24 * time.Hour
+)
+
+// deliver records a notification in the delivery log and sends it. Failed
+// destinations are retried with exponential backoff by retryDeliveries, as
+// configured by the notification's retries, retryBackoff, and retryJitter.
+// st is the state of the notified alert key, or nil if the notification
+// isn't about one. A notification group delivers to its members instead.
+func (s *Schedule) deliver(n *conf.Notification, st *State, ak, subject, body string, emailSubject, emailBody []byte, attachments ...*conf.Attachment) {
+	if len(n.Members) > 0 {
+		for _, m := range s.groupMembers(n, st) {
+			s.deliver(m, st, ak, subject, body, emailSubject, emailBody, attachments...)
+		}
+		return
+	}
+	d := &models.NotificationDelivery{
+		Notification: n.Name,
+		AlertKey:     ak,
+		Status:       models.DeliveryPending,
+		Created:      time.Now().UTC(),
+		Subject:      subject,
+		Body:         body,
+		EmailSubject: emailSubject,
+		EmailBody:    emailBody,
+		Pending:      n.Destinations(ak),
+	}
+	if st != nil {
+		d.IncidentId = st.Last().IncidentId
+	}
+	for _, a := range attachments {
+		d.Attachments = append(d.Attachments, models.DeliveryAttachment{
+			Data:        a.Data,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+		})
+	}
+	d.Key = s.notificationKey(n, st, ak, d.Created)
+	if n.BodyTemplate != nil {
+		pb, err := n.PostBody(newPostData(st, ak, d.Key, subject, body))
+		if err != nil {
+			slog.Errorf("error rendering bodyTemplate of notification %s: %v", n.Name, err)
+		}
+		d.PostBody = pb
+	}
+	if s.isDryRun(ak) {
+		d.Status = models.DeliveryDryRun
+		slog.Infof("dry run: not sending notification %s for %s: %s", n.Name, ak, subject)
+		collect.Add("notifications.dryrun", opentsdb.TagSet{"notification": n.Name}, 1)
+		if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+			slog.Errorln("error recording dry run notification:", err)
+		}
+		return
+	}
+	if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+		// Send it anyway, subject to quiet hours and maxPerHour, but it
+		// can't be postponed or retried.
+		slog.Errorln("error queueing notification delivery:", err)
+		d.Id = 0
+		if s.allowDelivery(n, d, d.Created) {
+			go s.attemptDelivery(n, d)
+		}
+		return
+	}
+	if !s.allowDelivery(n, d, d.Created) {
+		return
+	}
+	s.claimDelivery(d.Id)
+	go func() {
+		defer s.releaseDelivery(d.Id)
+		s.attemptDelivery(n, d)
+	}()
+}
+
+// claimDelivery marks the delivery with id as being attempted and returns
+// true, or returns false if it already is.
+func (s *Schedule) claimDelivery(id int64) bool {
+	s.sendingLock.Lock()
+	defer s.sendingLock.Unlock()
+	if s.sending == nil {
+		s.sending = make(map[int64]bool)
+	}
+	if s.sending[id] {
+		return false
+	}
+	s.sending[id] = true
+	return true
+}
+
+func (s *Schedule) releaseDelivery(id int64) {
+	s.sendingLock.Lock()
+	delete(s.sending, id)
+	s.sendingLock.Unlock()
+}
+
+// postData is the data a notification's bodyTemplate is rendered with.
+type postData struct {
+	// State is nil for notifications not about a single alert key, like
+	// unknown groups and actions.
+	State      *State
+	AlertKey   string
+	IncidentId uint64
+	Status     string
+	Subject    string
+	Body       string
+	// NotificationKey is the key of the notification; see notificationKey.
+	NotificationKey string
+}
+
+func newPostData(st *State, ak, key, subject, body string) *postData {
+	d := &postData{
+		State:           st,
+		AlertKey:        ak,
+		Subject:         subject,
+		Body:            body,
+		NotificationKey: key,
+	}
+	if st != nil {
+		last := st.Last()
+		d.IncidentId = last.IncidentId
+		d.Status = last.Status.String()
+	}
+	return d
+}
+
+// notificationKey returns the key of a notification of n about st, or about
+// ak if st is nil or has no incident, created at t. It is a hash of the
+// incident, the notification, which is the step of the incident's
+// notification chain, and t truncated to the check frequency. Retries of a
+// delivery send the same key, as do both bosuns of an HA pair if they send
+// the same notification in one check period during a failover.
+func (s *Schedule) notificationKey(n *conf.Notification, st *State, ak string, t time.Time) string {
+	subject := "ak:" + ak
+	if st != nil {
+		if id := st.Last().IncidentId; id != 0 {
+			subject = fmt.Sprintf("incident:%d", id)
+		}
+	}
+	bucket := t.Truncate(s.Conf.CheckFrequency).Unix()
+	h := sha1.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%d", subject, n.Name, bucket)))
+	return hex.EncodeToString(h[:])
+}
+
+// allowDelivery returns true if d may be sent at now under n's quiet hours
+// and rate limit. Otherwise d is postponed until the quiet hours end or
+// marked suppressed, and stored.
+func (s *Schedule) allowDelivery(n *conf.Notification, d *models.NotificationDelivery, now time.Time) bool {
+	reason := ""
+	if until, quiet := n.QuietUntil(now); quiet {
+		if !n.QuietDrop {
+			d.Status = models.DeliveryPending
+			d.NextAttempt = until.UTC()
+			s.updateDelivery(d)
+			return false
+		}
+		reason = "quietHours"
+	} else if d.Attempts == 0 && !s.takeRate(n, now) {
+		// Only the first attempt counts toward the rate limit, so retries
+		// of a failed delivery are not dropped.
+		reason = "maxPerHour"
+	}
+	if reason == "" {
+		return true
+	}
+	s.suppressDelivery(n, d, reason)
+	return false
+}
+
+// suppressDelivery marks d as not to be sent because of reason, and stores
+// it.
+func (s *Schedule) suppressDelivery(n *conf.Notification, d *models.NotificationDelivery, reason string) {
+	d.Status = models.DeliverySuppressed
+	d.LastError = "suppressed by " + reason
+	d.NextAttempt = time.Time{}
+	s.updateDelivery(d)
+	collect.Add("notifications.suppressed", opentsdb.TagSet{"notification": n.Name, "reason": reason}, 1)
+}
+
+// incidentClosed returns true if the incident with id has ended or no longer
+// exists.
+func (s *Schedule) incidentClosed(id uint64) bool {
+	s.incidentLock.Lock()
+	defer s.incidentLock.Unlock()
+	incident, ok := s.Incidents[id]
+	return !ok || incident.End != nil
+}
+
+// takeRate records a send of n at now and returns true, or returns false if
+// n has been sent maxPerHour times in the hour before now.
+func (s *Schedule) takeRate(n *conf.Notification, now time.Time) bool {
+	if n.MaxPerHour == 0 {
+		return true
+	}
+	s.sentLock.Lock()
+	defer s.sentLock.Unlock()
+	if s.sent == nil {
+		s.sent = make(map[string][]time.Time)
+	}
+	sent := s.sent[n.Name]
+	i := 0
+	for i < len(sent) && !sent[i].After(now.Add(-time.Hour)) {
+		i++
+	}
+	sent = sent[i:]
+	if len(sent) >= n.MaxPerHour {
+		s.sent[n.Name] = sent
+		return false
+	}
+	s.sent[n.Name] = append(sent, now)
+	return true
+}
+
+// attemptDelivery sends d to its pending destinations and records the
+// outcome. The caller must have claimed d, unless it could not be queued.
+func (s *Schedule) attemptDelivery(n *conf.Notification, d *models.NotificationDelivery) {
+	// Deliveries recorded before destinations were tracked are sent to all
+	// of them.
+	if d.Pending == nil {
+		d.Pending = n.Destinations(d.AlertKey)
+	}
+	var attachments []*conf.Attachment
+	for _, a := range d.Attachments {
+		attachments = append(attachments, &conf.Attachment{
+			Data:        a.Data,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+		})
+	}
+	start := time.Now().UTC()
+	errs := n.Deliver(d.Pending, d.Subject, d.Body, d.EmailSubject, d.EmailBody, d.PostBody, s.Conf, d.AlertKey, d.Key, attachments...)
+	now := time.Now().UTC()
+	var failed, msgs []string
+	for _, dest := range d.Pending {
+		if e := errs[dest]; e != nil {
+			failed = append(failed, dest)
+			msgs = append(msgs, dest+": "+e.Error())
+		}
+	}
+	d.Pending = failed
+	var err error
+	if len(msgs) > 0 {
+		err = errors.New(strings.Join(msgs, "; "))
+	}
+	s.recordDelivery(n.Name, now, err)
+	attempt := models.DeliveryAttempt{Time: start, Duration: now.Sub(start)}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	d.AttemptLog = append(d.AttemptLog, attempt)
+	if len(d.AttemptLog) > maxAttemptLog {
+		d.AttemptLog = d.AttemptLog[len(d.AttemptLog)-maxAttemptLog:]
+	}
+	d.Attempts++
+	d.LastAttempt = now
+	d.NextAttempt = time.Time{}
+	switch {
+	case err == nil:
+		d.Status = models.DeliverySent
+		d.LastError = ""
+	case d.Attempts <= n.Retries:
+		d.Status = models.DeliveryPending
+		d.LastError = err.Error()
+		d.NextAttempt = now.Add(retryBackoff(n, d.Attempts))
+	default:
+		d.Status = models.DeliveryFailed
+		d.LastError = err.Error()
+	}
+	s.updateDelivery(d)
+}
+
+// updateDelivery stores the changes to d. A delivery that could not be
+// queued has no id, so is not stored.
+func (s *Schedule) updateDelivery(d *models.NotificationDelivery) {
+	if d.Id == 0 {
+		return
+	}
+	if err := s.DataAccess.Deliveries().UpdateDelivery(d); err != nil {
+		slog.Errorln("error updating notification delivery:", err)
+	}
+}
+
+// retryBackoff returns how long to wait before retrying a delivery of n that
+// failed attempts times: n's RetryBackoff, doubled for each retry after the
+// first, plus up to RetryJitter percent of that at random.
+func retryBackoff(n *conf.Notification, attempts int) time.Duration {
+	d := n.RetryBackoff
+	for i := 1; i < attempts && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	if n.RetryJitter > 0 {
+		if j := int64(float64(d) * n.RetryJitter / 100); j > 0 {
+			d += time.Duration(rand.Int63n(j))
+		}
+	}
+	return d
+}
+
+// retryDeliveries periodically resends pending deliveries whose backoff has
+// elapsed.
+func (s *Schedule) retryDeliveries() {
+	for range time.Tick(deliveryRetryInterval) {
+		if s.Conf.Quiet || !s.IsLeader() {
+			continue
+		}
+		due, err := s.DataAccess.Deliveries().GetDueDeliveries(time.Now().UTC())
+		if err != nil {
+			slog.Errorln("error getting notification deliveries:", err)
+			continue
+		}
+		for _, d := range due {
+			n := s.Conf.Notifications[d.Notification]
+			if n == nil {
+				d.Status = models.DeliveryFailed
+				d.LastError = fmt.Sprintf("unknown notification: %s", d.Notification)
+				d.NextAttempt = time.Time{}
+				if err := s.DataAccess.Deliveries().UpdateDelivery(d); err != nil {
+					slog.Errorln("error updating notification delivery:", err)
+				}
+				continue
+			}
+			s.retryDelivery(n, d.Id)
+		}
+	}
+}
+
+// retryDelivery resends the delivery with id if it is still due and no other
+// attempt of it is in progress.
+func (s *Schedule) retryDelivery(n *conf.Notification, id int64) {
+	if !s.claimDelivery(id) {
+		return
+	}
+	defer s.releaseDelivery(id)
+	// Reload the delivery, since a manual retry may have sent it since it
+	// was found due.
+	d, err := s.DataAccess.Deliveries().GetDelivery(id)
+	if err != nil {
+		slog.Errorln("error getting notification delivery:", err)
+		return
+	}
+	now := time.Now()
+	if d.Status != models.DeliveryPending || d.NextAttempt.After(now) {
+		return
+	}
+	// A delivery never attempted was postponed by quiet hours, and is
+	// stale if its incident closed since.
+	if d.Attempts == 0 && d.IncidentId != 0 && s.incidentClosed(d.IncidentId) {
+		s.suppressDelivery(n, d, "incidentClosed")
+		return
+	}
+	if s.allowDelivery(n, d, now) {
+		s.attemptDelivery(n, d)
+	}
+}
+
+// RetryDelivery immediately resends the delivery with the given id to the
+// destinations it has not been sent to. Deliveries recorded by dry run or
+// suppressed are not sent, nor is any while its alert key is in dry run.
+func (s *Schedule) RetryDelivery(id int64) error {
+	if !s.claimDelivery(id) {
+		return fmt.Errorf("delivery %d is already being sent", id)
+	}
+	d, err := s.DataAccess.Deliveries().GetDelivery(id)
+	if err == nil {
+		switch {
+		case d.Status == models.DeliverySent:
+			err = fmt.Errorf("delivery %d was already sent", id)
+		case d.Status == models.DeliveryDryRun, d.Status == models.DeliverySuppressed:
+			err = fmt.Errorf("delivery %d was %s, not sent", id, d.Status)
+		case s.isDryRun(d.AlertKey):
+			err = fmt.Errorf("delivery %d is in dry run", id)
+		}
+	}
+	var n *conf.Notification
+	if err == nil {
+		if n = s.Conf.Notifications[d.Notification]; n == nil {
+			err = fmt.Errorf("unknown notification: %s", d.Notification)
+		}
+	}
+	if err != nil {
+		s.releaseDelivery(id)
+		return err
+	}
+	go func() {
+		defer s.releaseDelivery(id)
+		s.attemptDelivery(n, d)
+	}()
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"testing"
 	"time"
 
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/opentsdb"
 )
 
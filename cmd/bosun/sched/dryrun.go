@@ -0,0 +1,74 @@
+package sched
+
+import (
+	"fmt"
+	"sort"
+
+	"bosun.org/expr"
+)
+
+// DryRun is which notifications are recorded in the notification log
+// instead of being sent.
+type DryRun struct {
+	// Enabled is set if all notifications are.
+	Enabled bool
+	// Alerts are the alerts whose notifications are.
+	Alerts []string
+}
+
+// SetDryRun puts the named alert, or all notifications if alert is "", into
+// or out of dry run mode until bosun restarts, overriding the configuration.
+func (s *Schedule) SetDryRun(alert string, enabled bool) error {
+	if alert != "" && s.Conf.Alerts[alert] == nil {
+		return fmt.Errorf("unknown alert: %s", alert)
+	}
+	s.dryRunLock.Lock()
+	defer s.dryRunLock.Unlock()
+	if s.dryRun == nil {
+		s.dryRun = make(map[string]bool)
+	}
+	s.dryRun[alert] = enabled
+	return nil
+}
+
+// DryRun returns which notifications are in dry run mode.
+func (s *Schedule) DryRun() *DryRun {
+	d := &DryRun{
+		Enabled: s.dryRunEnabled(""),
+		Alerts:  []string{},
+	}
+	for name := range s.Conf.Alerts {
+		if s.dryRunEnabled(name) {
+			d.Alerts = append(d.Alerts, name)
+		}
+	}
+	sort.Strings(d.Alerts)
+	return d
+}
+
+// dryRunEnabled returns whether the named alert, or all notifications if
+// alert is "", are in dry run mode.
+func (s *Schedule) dryRunEnabled(alert string) bool {
+	s.dryRunLock.Lock()
+	enabled, ok := s.dryRun[alert]
+	s.dryRunLock.Unlock()
+	if ok {
+		return enabled
+	}
+	if alert == "" {
+		return s.Conf.DryRun
+	}
+	a := s.Conf.Alerts[alert]
+	return a != nil && a.DryRun
+}
+
+// isDryRun returns whether notifications about ak are recorded instead of
+// sent. ak may also name a notification about no single alert key, such as
+// an unknown digest, which only the global dry run mode applies to.
+func (s *Schedule) isDryRun(ak string) bool {
+	if s.dryRunEnabled("") {
+		return true
+	}
+	name := expr.AlertKey(ak).Name()
+	return s.Conf.Alerts[name] != nil && s.dryRunEnabled(name)
+}
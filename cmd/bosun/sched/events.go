@@ -0,0 +1,49 @@
+package sched
+
+import (
+	"fmt"
+	"time"
+
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+// PutEvents validates and stores events pushed by external systems, to be
+// counted by the events expression function. Events without a time
+// happened now.
+func (s *Schedule) PutEvents(es []*models.Event) error {
+	now := time.Now().UTC()
+	for _, e := range es {
+		if e.Type == "" {
+			return fmt.Errorf("event type required")
+		}
+		if !opentsdb.ValidTag(e.Type) {
+			return fmt.Errorf("invalid event type %q", e.Type)
+		}
+		for k, v := range e.Tags {
+			if !opentsdb.ValidTag(k) || !opentsdb.ValidTag(v) {
+				return fmt.Errorf("invalid event tag %s=%s", k, v)
+			}
+		}
+		if e.Time.IsZero() {
+			e.Time = now
+		}
+	}
+	for _, e := range es {
+		if err := s.DataAccess.Events().PutEvent(e, s.Conf.EventTTL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecentEvents returns the events of type typ, or of all types if typ is
+// empty, that happened in the last d, oldest first.
+func (s *Schedule) RecentEvents(typ string, d time.Duration) ([]*models.Event, error) {
+	now := time.Now().UTC()
+	es, err := s.DataAccess.Events().GetEvents(typ, now.Add(-d), now)
+	if es == nil {
+		es = []*models.Event{}
+	}
+	return es, err
+}
@@ -0,0 +1,61 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+func TestEvents(t *testing.T) {
+	c, err := conf.New("test.conf", `
+		template t {
+			subject = s
+		}
+		alert deploys {
+			template = t
+			crit = events("deploy", "app=*", "1h", "") > 1
+		}
+		alert backups {
+			template = t
+			crit = events("backup_failed", "", "1h", "") > 0
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutEvents([]*models.Event{{Tags: opentsdb.TagSet{"app": "web"}}}); err == nil {
+		t.Fatal("expected an error for an event without a type")
+	}
+	if err := s.PutEvents([]*models.Event{{Type: "deploy", Tags: opentsdb.TagSet{"app": "a b"}}}); err == nil {
+		t.Fatal("expected an error for an event with an invalid tag")
+	}
+	deploy := func(app string, ago time.Duration) *models.Event {
+		return &models.Event{Type: "deploy", Time: queryTime.Add(-ago), Tags: opentsdb.TagSet{"app": app}}
+	}
+	if err := s.PutEvents([]*models.Event{
+		deploy("web", time.Minute),
+		deploy("web", 10*time.Minute),
+		deploy("db", time.Minute),
+		deploy("db", 2*time.Hour),
+		{Type: "deploy", Time: queryTime},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	check(s, queryTime)
+	if st := s.status["deploys{app=web}"]; st == nil || st.Status() != StCritical {
+		t.Errorf("expected deploys{app=web} critical, got %v", st)
+	}
+	if st := s.status["deploys{app=db}"]; st == nil || st.Status() != StNormal {
+		t.Errorf("expected deploys{app=db} normal, got %v", st)
+	}
+	if st := s.status["backups{}"]; st == nil || st.Status() != StNormal {
+		t.Errorf("expected backups{} normal without events, got %v", st)
+	}
+}
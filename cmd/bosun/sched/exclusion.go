@@ -0,0 +1,158 @@
+package sched
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/opentsdb"
+)
+
+// Exclusion is a runtime addition to an alert's exclude list. Tag sets
+// matching an exclusion are never alerted on until the exclusion expires.
+type Exclusion struct {
+	Alert   string
+	Tags    opentsdb.TagSet
+	Expires time.Time // zero means never
+	User    string
+	Message string
+}
+
+func (e *Exclusion) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Alert   string
+		Tags    string
+		Expires *time.Time `json:",omitempty"`
+		User    string
+		Message string
+	}{
+		Alert:   e.Alert,
+		Tags:    e.Tags.Tags(),
+		Expires: e.expires(),
+		User:    e.User,
+		Message: e.Message,
+	})
+}
+
+func (e *Exclusion) expires() *time.Time {
+	if e.Expires.IsZero() {
+		return nil
+	}
+	return &e.Expires
+}
+
+func (e *Exclusion) ActiveAt(now time.Time) bool {
+	return e.Expires.IsZero() || now.Before(e.Expires)
+}
+
+func (e *Exclusion) Matches(alert string, tags opentsdb.TagSet) bool {
+	if e.Alert != alert {
+		return false
+	}
+	return excludeMatches(e.Tags, tags)
+}
+
+func (e Exclusion) ID() string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%s", e.Alert, e.Tags)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// excludeMatches returns true if every tag in pattern is present in tags with
+// a value matching the pattern's glob.
+func excludeMatches(pattern, tags opentsdb.TagSet) bool {
+	if len(pattern) == 0 {
+		return false
+	}
+	for k, p := range pattern {
+		tagv, ok := tags[k]
+		if !ok {
+			return false
+		}
+		if matched, _ := Match(p, tagv); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// exclusionLock guards s.Exclusions. When held with other locks, it is taken
+// after the schedule lock and before maintenanceLock.
+var exclusionLock = sync.RWMutex{}
+
+// Excluded returns true if the tag set is excluded from alert a, either by the
+// alert's exclude list or by an active runtime exclusion.
+func (s *Schedule) Excluded(a *conf.Alert, tags opentsdb.TagSet) bool {
+	for _, p := range a.Exclude {
+		if excludeMatches(p, tags) {
+			return true
+		}
+	}
+	now := time.Now()
+	exclusionLock.RLock()
+	defer exclusionLock.RUnlock()
+	for _, e := range s.Exclusions {
+		if e.ActiveAt(now) && e.Matches(a.Name, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetExclusions returns all active runtime exclusions, removing any that have
+// expired.
+func (s *Schedule) GetExclusions() map[string]*Exclusion {
+	now := time.Now()
+	exclusionLock.Lock()
+	defer exclusionLock.Unlock()
+	exclusions := make(map[string]*Exclusion, len(s.Exclusions))
+	for id, e := range s.Exclusions {
+		if !e.ActiveAt(now) {
+			delete(s.Exclusions, id)
+			continue
+		}
+		exclusions[id] = e
+	}
+	return exclusions
+}
+
+func (s *Schedule) AddExclusion(alert, tagList string, expires time.Time, user, message string) (string, error) {
+	if alert == "" || tagList == "" {
+		return "", fmt.Errorf("both alert and tags must be specified")
+	}
+	if _, ok := s.Conf.Alerts[alert]; !ok {
+		return "", fmt.Errorf("unknown alert: %s", alert)
+	}
+	if !expires.IsZero() && time.Since(expires) > 0 {
+		return "", fmt.Errorf("expiry must be in the future")
+	}
+	tags, err := opentsdb.ParseTags(tagList)
+	if tags == nil && err != nil {
+		return "", err
+	}
+	e := &Exclusion{
+		Alert:   alert,
+		Tags:    tags,
+		Expires: expires,
+		User:    user,
+		Message: message,
+	}
+	id := e.ID()
+	exclusionLock.Lock()
+	defer exclusionLock.Unlock()
+	s.Exclusions[id] = e
+	return id, nil
+}
+
+func (s *Schedule) ClearExclusion(id string) error {
+	exclusionLock.Lock()
+	defer exclusionLock.Unlock()
+	if _, ok := s.Exclusions[id]; !ok {
+		return fmt.Errorf("unknown exclusion: %s", id)
+	}
+	delete(s.Exclusions, id)
+	return nil
+}
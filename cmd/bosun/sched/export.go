@@ -0,0 +1,216 @@
+package sched
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/slog"
+)
+
+// CriticalAlert is a minimal summary of an unacknowledged critical alert,
+// suitable for a fallback pager polling an export of them.
+type CriticalAlert struct {
+	AlertKey expr.AlertKey
+	Subject  string
+	Since    time.Time
+	Age      int64 // seconds since Since
+}
+
+// CriticalUnacked returns all open, unsilenced alerts that are currently
+// critical and need acknowledgement, oldest first.
+func (s *Schedule) CriticalUnacked() []*CriticalAlert {
+	silenced := s.Silenced()
+	now := time.Now().UTC()
+	s.Lock("CriticalUnacked")
+	defer s.Unlock()
+	list := []*CriticalAlert{}
+	for ak, st := range s.status {
+		if !st.Open || !st.NeedAck || st.Status() != StCritical {
+			continue
+		}
+		if _, ok := silenced[ak]; ok {
+			continue
+		}
+		since := st.Last().Time
+		for i := len(st.History) - 1; i >= 0 && st.History[i].Status == StCritical; i-- {
+			since = st.History[i].Time
+		}
+		list = append(list, &CriticalAlert{
+			AlertKey: ak,
+			Subject:  st.Subject,
+			Since:    since,
+			Age:      int64(now.Sub(since) / time.Second),
+		})
+	}
+	sort.Sort(criticalAlerts(list))
+	return list
+}
+
+type criticalAlerts []*CriticalAlert
+
+func (c criticalAlerts) Len() int      { return len(c) }
+func (c criticalAlerts) Swap(i, j int) { c[i], c[j] = c[j], c[i] }
+func (c criticalAlerts) Less(i, j int) bool {
+	if !c[i].Since.Equal(c[j].Since) {
+		return c[i].Since.Before(c[j].Since)
+	}
+	return c[i].AlertKey < c[j].AlertKey
+}
+
+// exportCritical writes the list of critical unacknowledged alerts to the
+// configured criticalExport destination every check interval.
+func (s *Schedule) exportCritical() {
+	for {
+		if s.IsLeader() {
+			if err := s.writeCriticalExport(s.Conf.CriticalExport); err != nil {
+				slog.Errorln("critical export:", err)
+			}
+		}
+		time.Sleep(s.Conf.CheckFrequency)
+	}
+}
+
+// writeCriticalExport writes the export to dest. URLs are sent an HTTP PUT,
+// which also works with pre-signed S3 URLs. Anything else is treated as a
+// file path and replaced atomically.
+func (s *Schedule) writeCriticalExport(dest string) error {
+	b, err := json.Marshal(struct {
+		Time   time.Time
+		Alerts []*CriticalAlert
+	}{
+		time.Now().UTC(),
+		s.CriticalUnacked(),
+	})
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(dest, "http://") || strings.HasPrefix(dest, "https://") {
+		req, err := http.NewRequest("PUT", dest, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("bad response: %s", resp.Status)
+		}
+		return nil
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dest), filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
+
+// AlertmanagerAlert is a firing alert key in the alert format of version 2
+// of the Prometheus Alertmanager API.
+type AlertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// alertmanagerResends is how many checks an exported alert lasts for if it
+// is not exported again, so Alertmanager resolves alerts that stop firing.
+const alertmanagerResends = 4
+
+// AlertmanagerAlerts returns the open, unsilenced, abnormal alert keys as
+// Alertmanager alerts. Their labels are their tags, named alertname, status,
+// and severity labels, and their annotations their subject as summary and
+// body as description.
+func (s *Schedule) AlertmanagerAlerts() []*AlertmanagerAlert {
+	silenced := s.Silenced()
+	now := time.Now().UTC()
+	s.Lock("AlertmanagerAlerts")
+	var states []*State
+	for ak, st := range s.status {
+		if !st.Open || !st.IsActive() || st.Unevaluated {
+			continue
+		}
+		if _, ok := silenced[ak]; ok {
+			continue
+		}
+		states = append(states, st.Copy())
+	}
+	s.Unlock()
+	sort.Sort(statesByAlertKey(states))
+	list := []*AlertmanagerAlert{}
+	for _, st := range states {
+		ak := st.AlertKey()
+		last := st.Last()
+		labels := make(map[string]string, len(st.Group)+3)
+		for k, v := range st.Group {
+			labels[alertmanagerLabel(k)] = v
+		}
+		labels["alertname"] = ak.Name()
+		labels["status"] = last.Status.String()
+		if st.Severity != conf.SevNone {
+			labels["severity"] = st.Severity.String()
+		}
+		a := &AlertmanagerAlert{
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":     st.Subject,
+				"description": s.stateMessage(st, nil).Body,
+			},
+			StartsAt: last.Time,
+			EndsAt:   now.Add(alertmanagerResends * s.Conf.CheckFrequency),
+		}
+		if last.IncidentId != 0 {
+			if incident, err := s.GetIncident(last.IncidentId); err == nil {
+				a.StartsAt = incident.Start
+			}
+			a.GeneratorURL = s.Conf.MakeLink("/incident", &url.Values{"id": []string{fmt.Sprint(last.IncidentId)}})
+		}
+		list = append(list, a)
+	}
+	return list
+}
+
+type statesByAlertKey []*State
+
+func (s statesByAlertKey) Len() int           { return len(s) }
+func (s statesByAlertKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statesByAlertKey) Less(i, j int) bool { return s[i].AlertKey() < s[j].AlertKey() }
+
+// alertmanagerLabel returns the tag key k as a Prometheus label name, which
+// may only have letters, digits, and underscores, and not start with a digit.
+func alertmanagerLabel(k string) string {
+	b := []byte(k)
+	for i, c := range b {
+		if !(c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || '0' <= b[0] && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
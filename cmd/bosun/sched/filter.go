@@ -3,8 +3,10 @@ package sched
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"bosun.org/cmd/bosun/conf"
+	"bosun.org/opentsdb"
 )
 
 func makeFilter(filter string) (func(*conf.Conf, *conf.Alert, *State) bool, error) {
@@ -57,6 +59,10 @@ func makeFilter(filter string) (func(*conf.Conf, *conf.Alert, *State) bool, erro
 			add(func(c *conf.Conf, a *conf.Alert, s *State) bool {
 				return s.NeedAck != v
 			})
+		case "namespace":
+			add(func(c *conf.Conf, a *conf.Alert, s *State) bool {
+				return a.Namespace == value
+			})
 		case "notify":
 			add(func(c *conf.Conf, a *conf.Alert, s *State) bool {
 				r := false
@@ -70,8 +76,20 @@ func makeFilter(filter string) (func(*conf.Conf, *conf.Alert, *State) bool, erro
 				}
 				f(a.CritNotification)
 				f(a.WarnNotification)
+				if a.UnknownNotification != nil {
+					f(a.UnknownNotification)
+				}
 				return r
 			})
+		case "since":
+			d, err := opentsdb.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("bad %s value: %s", key, value)
+			}
+			add(func(c *conf.Conf, a *conf.Alert, s *State) bool {
+				ev := s.AbnormalEvent()
+				return ev != nil && time.Since(ev.Time) <= time.Duration(d)
+			})
 		case "status":
 			var v Status
 			switch value {
@@ -3,7 +3,7 @@ package sched
 import (
 	"testing"
 
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/opentsdb"
 )
 
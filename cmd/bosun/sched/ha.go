@@ -0,0 +1,140 @@
+package sched
+
+import (
+	"sync/atomic"
+	"time"
+
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.ha.leader", metadata.Gauge, metadata.Bool,
+		"1 if this instance holds the HA leader lease and is checking alerts.")
+}
+
+// IsLeader returns true if s should check alerts and send notifications: it
+// holds the HA leader lease, or HA is not enabled.
+func (s *Schedule) IsLeader() bool {
+	if s.Conf == nil || !s.Conf.HA {
+		return true
+	}
+	return atomic.LoadInt32(&s.leader) == 1
+}
+
+// Leader returns the HA id of the instance holding the leader lease, or "" if
+// HA is not enabled or the lease is free.
+func (s *Schedule) Leader() (string, error) {
+	if !s.Conf.HA {
+		return "", nil
+	}
+	return s.DataAccess.HA().GetLeader()
+}
+
+func (s *Schedule) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&s.leader, v)
+	collect.Put("ha.leader", nil, v)
+}
+
+// runHA acquires or renews the leader lease every third of its TTL, so a
+// standby takes over within the TTL of the leader failing. The leader saves
+// its state to redis every check frequency. A standby loads it, both to keep
+// its read-only dashboard current and so it is up to date on takeover.
+func (s *Schedule) runHA() {
+	var synced time.Time
+	for {
+		synced = s.haTick(synced)
+		time.Sleep(s.Conf.HALeaseTTL / 3)
+	}
+}
+
+// haTick runs one round of runHA, returning when state was last synced with
+// redis.
+func (s *Schedule) haTick(synced time.Time) time.Time {
+	ha := s.DataAccess.HA()
+	leader, err := ha.AcquireLease(s.Conf.HAID, s.Conf.HALeaseTTL)
+	if err != nil {
+		// Without redis the lease can't be renewed and may be taken by
+		// another instance, so stop checking.
+		slog.Errorln("ha: lease:", err)
+		leader = false
+	}
+	switch was := s.IsLeader(); {
+	case leader && !was:
+		slog.Infoln("ha: acquired leader lease as", s.Conf.HAID)
+		// Take over from the latest state the previous leader saved.
+		if err := s.loadHAState(); err != nil {
+			slog.Errorln("ha: load state:", err)
+		}
+		s.setLeader(true)
+		// Wake the notification dispatcher to send anything that came due
+		// while this instance was standby.
+		select {
+		case s.nc <- true:
+		default:
+		}
+		return time.Now()
+	case !leader && was:
+		slog.Infoln("ha: lost leader lease, now standby")
+		s.setLeader(false)
+	}
+	if time.Since(synced) < s.Conf.CheckFrequency {
+		return synced
+	}
+	if leader {
+		err = s.saveHAState()
+	} else {
+		err = s.loadHAState()
+	}
+	if err != nil {
+		slog.Errorln("ha: sync state:", err)
+		return synced
+	}
+	return time.Now()
+}
+
+// saveHAState stores s's state in redis for a standby to load. Alert states
+// are already shared through redis, so only changes not yet flushed are
+// written.
+func (s *Schedule) saveHAState() error {
+	if err := s.flushStates(); err != nil {
+		return err
+	}
+	return s.saveObjects()
+}
+
+// loadHAState replaces s's state with the last state saved to redis by the
+// leader. Nothing is changed if none has been saved.
+func (s *Schedule) loadHAState() error {
+	objects, err := s.DataAccess.State().GetObjects()
+	if err != nil || len(objects) == 0 {
+		return err
+	}
+	silenceLock.Lock()
+	defer silenceLock.Unlock()
+	s.Lock("LoadHAState")
+	defer s.Unlock()
+	exclusionLock.Lock()
+	defer exclusionLock.Unlock()
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	s.Search.Lock()
+	defer s.Search.Unlock()
+	s.Silence = make(map[string]*Silence)
+	s.Exclusions = make(map[string]*Exclusion)
+	s.Maintenance = make(map[string]*Maintenance)
+	s.Incidents = make(map[uint64]*Incident)
+	s.status = make(States)
+	s.dirtyLock.Lock()
+	s.dirty = make(map[expr.AlertKey]bool)
+	s.dirtyLock.Unlock()
+	s.Group = make(map[time.Time]expr.AlertKeys)
+	s.restoreState(objects)
+	return nil
+}
@@ -0,0 +1,103 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+type fakeHA struct {
+	leader string
+}
+
+func (f *fakeHA) AcquireLease(id string, ttl time.Duration) (bool, error) {
+	if f.leader == "" {
+		f.leader = id
+	}
+	return f.leader == id, nil
+}
+func (f *fakeHA) ReleaseLease(id string) error {
+	if f.leader == id {
+		f.leader = ""
+	}
+	return nil
+}
+func (f *fakeHA) GetLeader() (string, error) { return f.leader, nil }
+
+func TestHA(t *testing.T) {
+	ha := &fakeHA{}
+	// State is shared through the database.
+	states := make(map[string][]byte)
+	objects := make(map[string][]byte)
+	newSched := func(id string) *Schedule {
+		c, err := conf.New("", `
+			ha = true
+			haID = `+id+`
+			redisHost = localhost:6379
+			alert a {
+				crit = 1
+			}
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := initSched(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.DataAccess.(*nopDataAccess).HADataAccess = ha
+		s.DataAccess.(*nopDataAccess).states = states
+		s.DataAccess.(*nopDataAccess).objects = objects
+		return s
+	}
+	a, b := newSched("a"), newSched("b")
+
+	a.haTick(time.Time{})
+	b.haTick(time.Time{})
+	if !a.IsLeader() || b.IsLeader() {
+		t.Fatalf("expected a to lead, got leaders a: %v, b: %v", a.IsLeader(), b.IsLeader())
+	}
+	if d := b.CheckNotifications(); d != b.Conf.CheckFrequency {
+		t.Fatalf("expected standby not to check notifications, got timeout %v", d)
+	}
+	ak := expr.NewAlertKey("a", opentsdb.TagSet{"host": "x"})
+	st := NewStatus(ak)
+	st.History = []Event{{Status: StCritical, Time: time.Now().UTC()}}
+	a.SetStatus(ak, st)
+	a.haTick(time.Time{})
+	b.haTick(time.Time{})
+	if b.status[ak] == nil || b.status[ak].Status() != StCritical {
+		t.Fatalf("expected standby to load leader state, got %v", b.status[ak])
+	}
+
+	// a fails and its lease expires.
+	ha.leader = ""
+	delete(a.status, ak)
+	b.haTick(time.Now())
+	a.haTick(time.Now())
+	if a.IsLeader() || !b.IsLeader() {
+		t.Fatalf("expected b to take over, got leaders a: %v, b: %v", a.IsLeader(), b.IsLeader())
+	}
+	if b.status[ak] == nil {
+		t.Fatal("expected new leader to keep state")
+	}
+
+	// Loading state while exclusions and maintenance are read must not race.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			a.GetExclusions()
+			a.HostMaintenance(time.Now(), "x")
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		if err := a.loadHAState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
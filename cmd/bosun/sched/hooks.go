@@ -0,0 +1,54 @@
+package sched
+
+import (
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+// Hook is notified of schedule events, so extensions like auditing and
+// webhooks don't need changes to the check loop. Hooks are called
+// synchronously without the schedule locked: they may call Schedule methods,
+// but should return quickly since they delay checks. Embed NopHook to
+// implement only some of the methods.
+type Hook interface {
+	// OnStateChange is called when the status of an alert key changes. from
+	// is StNone for new alert keys.
+	OnStateChange(ak expr.AlertKey, from Status, event Event)
+	// OnIncidentOpen is called when an alert key becomes abnormal without
+	// an open incident.
+	OnIncidentOpen(incident Incident)
+	// OnIncidentClose is called when the incident of an alert key is
+	// closed, with End set.
+	OnIncidentClose(incident Incident)
+	// OnAction is called after an action, by a user or bosun, is applied.
+	OnAction(ak expr.AlertKey, action Action)
+	// OnCheckCycleEnd is called after an alert is checked and its results
+	// applied.
+	OnCheckCycleEnd(alert *conf.Alert, r *RunHistory)
+}
+
+// NopHook implements Hook by doing nothing.
+type NopHook struct{}
+
+func (NopHook) OnStateChange(expr.AlertKey, Status, Event) {}
+func (NopHook) OnIncidentOpen(Incident)                    {}
+func (NopHook) OnIncidentClose(Incident)                   {}
+func (NopHook) OnAction(expr.AlertKey, Action)             {}
+func (NopHook) OnCheckCycleEnd(*conf.Alert, *RunHistory)   {}
+
+// AddHook registers h to be notified of events.
+func (s *Schedule) AddHook(h Hook) {
+	s.hookLock.Lock()
+	s.hooks = append(s.hooks, h)
+	s.hookLock.Unlock()
+}
+
+// runHooks calls f with each registered hook.
+func (s *Schedule) runHooks(f func(Hook)) {
+	s.hookLock.RLock()
+	hooks := s.hooks
+	s.hookLock.RUnlock()
+	for _, h := range hooks {
+		f(h)
+	}
+}
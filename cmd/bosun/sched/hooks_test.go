@@ -0,0 +1,67 @@
+package sched
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+type recordHook struct {
+	NopHook
+	events []string
+}
+
+func (r *recordHook) OnStateChange(ak expr.AlertKey, from Status, event Event) {
+	r.events = append(r.events, fmt.Sprintf("state %s %v->%v", ak, from, event.Status))
+}
+
+func (r *recordHook) OnIncidentOpen(incident Incident) {
+	r.events = append(r.events, fmt.Sprintf("incident %d %s", incident.Id, incident.AlertKey))
+}
+
+func (r *recordHook) OnIncidentClose(incident Incident) {
+	r.events = append(r.events, fmt.Sprintf("close %d %s", incident.Id, incident.AlertKey))
+}
+
+func (r *recordHook) OnAction(ak expr.AlertKey, action Action) {
+	r.events = append(r.events, fmt.Sprintf("action %s %v %s", ak, action.Type, action.User))
+}
+
+func TestHooks(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	h := new(recordHook)
+	s.AddHook(h)
+	ak := expr.NewAlertKey("a", nil)
+	run := func(st Status) {
+		s.RunHistory(&RunHistory{
+			Events: map[expr.AlertKey]*Event{ak: {Status: st}},
+		})
+	}
+	run(StCritical)
+	run(StCritical)
+	run(StNormal)
+	if err := s.Action("u", "", "", ActionClose, ak); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"incident 1 a{}",
+		"state a{} none->critical",
+		"state a{} critical->normal",
+		"action a{} Closed u",
+		"close 1 a{}",
+	}
+	if !reflect.DeepEqual(h.events, expected) {
+		t.Fatalf("got events %q, expected %q", h.events, expected)
+	}
+}
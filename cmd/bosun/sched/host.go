@@ -5,7 +5,7 @@ import (
 	"strconv"
 	"time"
 
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/metadata"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
@@ -121,9 +121,9 @@ func (s *Schedule) Host(filter string) (map[string]*HostData, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Will make the assumption that the metric bosun.ping.timeout, resolved, and rtt
+	// Will make the assumption that the ping timeout, resolved, and rtt metrics
 	// all share the same tagset
-	icmpTimeOutTags, err := tagsByKey("bosun.ping.timeout", "dst_host")
+	icmpTimeOutTags, err := tagsByKey(s.pingMetric("timeout"), "dst_host")
 	if err != nil {
 		return nil, err
 	}
@@ -134,6 +134,7 @@ func (s *Schedule) Host(filter string) (map[string]*HostData, error) {
 		if err != nil {
 			slog.Error(err)
 		}
+		host.Maintenance = s.HostMaintenance(time.Now(), host.Name)
 		processHostIncidents(host, states, silences)
 		for _, ts := range icmpTimeOutTags[host.Name] {
 			// The host tag represents the polling source for these set of metrics
@@ -142,13 +143,13 @@ func (s *Schedule) Host(filter string) (map[string]*HostData, error) {
 				slog.Errorf("couldn't find source tag for icmp data for host %s", host.Name)
 			}
 			// 1 Means it timed out
-			timeout, timestamp, err := s.Search.GetLast("bosun.ping.timeout", ts.String(), false)
+			timeout, timestamp, err := s.Search.GetLast(s.pingMetric("timeout"), ts.String(), false)
 			if err != nil || timestamp <= 0 {
 				continue
 			}
-			rtt, rttTimestamp, _ := s.Search.GetLast("bosun.ping.rtt", ts.String(), false)
+			rtt, rttTimestamp, _ := s.Search.GetLast(s.pingMetric("rtt"), ts.String(), false)
 			// 1 means dns resolution was successful
-			dnsLookup, dnsTimestamp, dnsErr := s.Search.GetLast("bosun.ping.resolved", ts.String(), false)
+			dnsLookup, dnsTimestamp, dnsErr := s.Search.GetLast(s.pingMetric("resolved"), ts.String(), false)
 			host.ICMPData[source] = &ICMPData{
 				TimedOut:               timeout == 1 && err == nil,
 				TimedOutLastUpdated:    timestamp,
@@ -807,7 +808,8 @@ type HostData struct {
 		Caption string `json:",omitempty"`
 		Version string `json:",omitempty"`
 	}
-	SerialNumber string   `json:",omitempty"`
-	VM           *VM      `json:",omitempty"`
-	Guests       []string `json:",omitempty"`
+	SerialNumber string       `json:",omitempty"`
+	VM           *VM          `json:",omitempty"`
+	Guests       []string     `json:",omitempty"`
+	Maintenance  *Maintenance `json:",omitempty"`
 }
@@ -0,0 +1,117 @@
+package sched
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/opentsdb"
+)
+
+const (
+	// maxIncidentOwner is the longest owner an incident may have.
+	maxIncidentOwner = 100
+	// maxIncidentTags is the most tags an incident may have.
+	maxIncidentTags = 20
+)
+
+// IncidentUpdate changes the triage fields of an incident. Nil fields are
+// left unchanged.
+type IncidentUpdate struct {
+	// Owner is who has claimed the incident, or "" to unclaim it.
+	Owner *string
+	// Severity is the name of a severity, or "" to clear it.
+	Severity *string
+	// Tags replace the incident's tags.
+	Tags *[]string
+}
+
+// UpdateIncident applies u to the incident with the given id and returns a
+// copy of the result. The change is recorded as a note on the incident by
+// user.
+func (s *Schedule) UpdateIncident(id uint64, user string, u *IncidentUpdate) (*Incident, error) {
+	var owner string
+	var sev conf.Severity
+	var tags []string
+	var changes []string
+	if u.Owner != nil {
+		owner = strings.TrimSpace(*u.Owner)
+		if len(owner) > maxIncidentOwner || strings.ContainsAny(owner, "\r\n") {
+			return nil, fmt.Errorf("owner must be one line of at most %d characters", maxIncidentOwner)
+		}
+		if owner == "" {
+			changes = append(changes, "unclaimed")
+		} else {
+			changes = append(changes, "claimed by "+owner)
+		}
+	}
+	if u.Severity != nil {
+		if *u.Severity != "" {
+			var err error
+			if sev, err = conf.ParseSeverity(*u.Severity); err != nil {
+				return nil, err
+			}
+		}
+		changes = append(changes, "severity set to "+sev.String())
+	}
+	if u.Tags != nil {
+		seen := make(map[string]bool)
+		for _, tag := range *u.Tags {
+			if !opentsdb.ValidTag(tag) {
+				return nil, fmt.Errorf("invalid incident tag: %q", tag)
+			}
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+		if len(tags) > maxIncidentTags {
+			return nil, fmt.Errorf("incidents may have at most %d tags", maxIncidentTags)
+		}
+		sort.Strings(tags)
+		changes = append(changes, fmt.Sprintf("tags set to %v", tags))
+	}
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no incident fields to update")
+	}
+	s.incidentLock.Lock()
+	incident, ok := s.Incidents[id]
+	if ok {
+		if u.Owner != nil {
+			incident.Owner = owner
+		}
+		if u.Severity != nil {
+			incident.Severity = sev
+		}
+		if u.Tags != nil {
+			incident.Tags = tags
+		}
+		c := *incident
+		incident = &c
+	}
+	s.incidentLock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("incident %d not found", id)
+	}
+	s.markChanged()
+	if user != "" {
+		if err := s.AddIncidentNote(id, user, strings.Join(changes, ", ")); err != nil {
+			return nil, err
+		}
+	}
+	return incident, nil
+}
+
+// incidentOwner returns the owner of the incident with the given id.
+func (s *Schedule) incidentOwner(id uint64) string {
+	if id == 0 {
+		return ""
+	}
+	s.incidentLock.Lock()
+	defer s.incidentLock.Unlock()
+	if incident, ok := s.Incidents[id]; ok {
+		return incident.Owner
+	}
+	return ""
+}
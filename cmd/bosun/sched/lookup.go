@@ -0,0 +1,59 @@
+package sched
+
+import (
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.lookup.source_errors", metadata.Counter, metadata.Error,
+		"Number of failed reads, or rejected values, of the sources of lookups.")
+}
+
+// lookupSourceRetry is how long to wait after failing to read a lookup's
+// source before reading it again.
+var lookupSourceRetry = time.Minute
+
+// WatchLookups keeps the entries of each lookup with a source up to date
+// with it.
+func (s *Schedule) WatchLookups() {
+	for _, l := range s.Conf.Lookups {
+		if l.Source != nil {
+			go s.watchLookup(l)
+		}
+	}
+}
+
+// watchLookup applies each change of l's source to l. Values that fail to
+// parse are logged and l keeps its entries until the next change.
+func (s *Schedule) watchLookup(l *conf.Lookup) {
+	var index uint64
+	for {
+		value, found, next, err := l.Source.Get(index)
+		if err != nil {
+			slog.Errorf("lookup %s: reading %s: %v", l.Name, l.Source.URL, err)
+			collect.Add("lookup.source_errors", opentsdb.TagSet{"lookup": l.Name}, 1)
+			index = 0
+			time.Sleep(lookupSourceRetry)
+			continue
+		}
+		if next == index {
+			continue
+		}
+		index = next
+		if !found {
+			value = ""
+		}
+		if err := s.Conf.SetLookupEntries(l, value); err != nil {
+			slog.Errorf("lookup %s: ignoring value of %s: %v", l.Name, l.Source.URL, err)
+			collect.Add("lookup.source_errors", opentsdb.TagSet{"lookup": l.Name}, 1)
+			continue
+		}
+		slog.Infof("lookup %s: updated from %s", l.Name, l.Source.URL)
+	}
+}
@@ -0,0 +1,153 @@
+package sched
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+)
+
+// Maintenance marks a host as under maintenance. Alerts tagged with the host
+// are silenced and it is excluded from the unknown sweep until the
+// maintenance ends or is cleared.
+type Maintenance struct {
+	Host    string
+	Start   time.Time
+	End     time.Time `json:",omitempty"` // zero means until cleared
+	User    string
+	Message string
+}
+
+func (m *Maintenance) ActiveAt(now time.Time) bool {
+	return !now.Before(m.Start) && (m.End.IsZero() || now.Before(m.End))
+}
+
+func (m *Maintenance) String() string {
+	s := "in maintenance"
+	if m.User != "" {
+		s += " by " + m.User
+	}
+	if !m.End.IsZero() {
+		s += " until " + m.End.Format(time.RFC3339)
+	}
+	if m.Message != "" {
+		s += ": " + m.Message
+	}
+	return s
+}
+
+// maintenanceLock guards s.Maintenance. When held with other locks, it is
+// taken after the schedule lock and exclusionLock.
+var maintenanceLock = sync.RWMutex{}
+
+// SetMaintenance puts host into maintenance until end, or until cleared if
+// end is zero. It replaces any existing maintenance of the host.
+func (s *Schedule) SetMaintenance(host string, end time.Time, user, message string) (*Maintenance, error) {
+	if host == "" {
+		return nil, fmt.Errorf("host must be specified")
+	}
+	now := time.Now().UTC()
+	if !end.IsZero() && !end.After(now) {
+		return nil, fmt.Errorf("end time must be in the future")
+	}
+	m := &Maintenance{
+		Host:    host,
+		Start:   now,
+		End:     end,
+		User:    user,
+		Message: message,
+	}
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	s.Maintenance[host] = m
+	return m, nil
+}
+
+func (s *Schedule) ClearMaintenance(host string) error {
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	if _, ok := s.Maintenance[host]; !ok {
+		return fmt.Errorf("host not in maintenance: %s", host)
+	}
+	delete(s.Maintenance, host)
+	return nil
+}
+
+// GetMaintenance returns the active maintenance of all hosts, removing any
+// that has ended.
+func (s *Schedule) GetMaintenance() map[string]*Maintenance {
+	now := time.Now()
+	maintenanceLock.Lock()
+	defer maintenanceLock.Unlock()
+	hosts := make(map[string]*Maintenance, len(s.Maintenance))
+	for host, m := range s.Maintenance {
+		if !m.ActiveAt(now) {
+			delete(s.Maintenance, host)
+			continue
+		}
+		hosts[host] = m
+	}
+	return hosts
+}
+
+// HostMaintenance returns the active maintenance of host, or nil if it is not
+// in maintenance.
+func (s *Schedule) HostMaintenance(now time.Time, host string) *Maintenance {
+	maintenanceLock.RLock()
+	defer maintenanceLock.RUnlock()
+	if m := s.Maintenance[host]; m != nil && m.ActiveAt(now) {
+		return m
+	}
+	return nil
+}
+
+// inMaintenance returns the active maintenance of the host in tags, if any.
+func (s *Schedule) inMaintenance(now time.Time, tags opentsdb.TagSet) *Maintenance {
+	host, ok := tags["host"]
+	if !ok {
+		return nil
+	}
+	return s.HostMaintenance(now, host)
+}
+
+// maintenanceSilences returns silences for the alert keys of hosts in
+// maintenance. s must be locked.
+func (s *Schedule) maintenanceSilences(now time.Time) map[expr.AlertKey]Silence {
+	aks := make(map[expr.AlertKey]Silence)
+	for ak := range s.status {
+		m := s.inMaintenance(now, ak.Group())
+		if m == nil {
+			continue
+		}
+		aks[ak] = Silence{
+			Start:   m.Start,
+			End:     m.End,
+			Tags:    opentsdb.TagSet{"host": m.Host},
+			User:    m.User,
+			Message: m.String(),
+		}
+	}
+	return aks
+}
+
+// maintenanceMetadata returns the maintenance of the host in subset as a
+// metadata entry, so it is shown with the host's other metadata.
+func (s *Schedule) maintenanceMetadata(subset opentsdb.TagSet) (metadata.Metasend, bool) {
+	if len(subset) != 1 {
+		return metadata.Metasend{}, false
+	}
+	m := s.inMaintenance(time.Now(), subset)
+	if m == nil {
+		return metadata.Metasend{}, false
+	}
+	start := m.Start
+	return metadata.Metasend{
+		Tags:  opentsdb.TagSet{"host": m.Host},
+		Name:  "maintenance",
+		Value: m.String(),
+		Time:  &start,
+	}, true
+}
@@ -0,0 +1,82 @@
+package sched
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"time"
+
+	"bosun.org/models"
+	"bosun.org/slog"
+)
+
+// SetAlertNote attaches a note to the named alert. An empty text removes the
+// note.
+func (s *Schedule) SetAlertNote(alert, text, user string) error {
+	if _, ok := s.Conf.Alerts[alert]; !ok {
+		return fmt.Errorf("unknown alert: %s", alert)
+	}
+	defer s.markChanged()
+	if text == "" {
+		return s.DataAccess.AlertNotes().ClearAlertNote(alert)
+	}
+	return s.DataAccess.AlertNotes().SetAlertNote(alert, &models.AlertNote{
+		Text: text,
+		User: user,
+		Time: time.Now().UTC(),
+	})
+}
+
+// GetAlertNote returns the note for the named alert, or nil if there is none.
+func (s *Schedule) GetAlertNote(alert string) *models.AlertNote {
+	note, err := s.DataAccess.AlertNotes().GetAlertNote(alert)
+	if err != nil {
+		slog.Errorln("error getting alert note:", err)
+		return nil
+	}
+	return note
+}
+
+// AddIncidentNote records a note on the incident with the given id. body is
+// markdown.
+func (s *Schedule) AddIncidentNote(id uint64, author, body string) error {
+	if _, err := s.GetIncident(id); err != nil {
+		return err
+	}
+	if body == "" {
+		return fmt.Errorf("note body must not be empty")
+	}
+	return s.DataAccess.Incidents().AddIncidentNote(id, &models.IncidentNote{
+		Author: author,
+		Time:   time.Now().UTC(),
+		Body:   body,
+	})
+}
+
+// GetIncidentNotes returns the notes of the incident with the given id,
+// oldest first.
+func (s *Schedule) GetIncidentNotes(id uint64) ([]*models.IncidentNote, error) {
+	if _, err := s.GetIncident(id); err != nil {
+		return nil, err
+	}
+	return s.DataAccess.Incidents().GetIncidentNotes(id)
+}
+
+var alertNoteTemplate = template.Must(template.New("").Parse(
+	`<p><strong>Note:</strong> {{.Text}}{{if .User}} ({{.User}}){{end}}</p>`))
+
+// withAlertNote prepends the note for the named alert, if any, to an email
+// body.
+func (s *Schedule) withAlertNote(alert string, body []byte) []byte {
+	note := s.GetAlertNote(alert)
+	if note == nil {
+		return body
+	}
+	buf := new(bytes.Buffer)
+	if err := alertNoteTemplate.Execute(buf, note); err != nil {
+		slog.Errorln(err)
+		return body
+	}
+	buf.Write(body)
+	return buf.Bytes()
+}
@@ -2,11 +2,23 @@ package sched
 
 import (
 	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/cmd/bosun/database"
+	"bosun.org/expr"
+	"bosun.org/models"
 	"bosun.org/opentsdb"
 )
 
@@ -141,3 +153,1188 @@ func TestActionNotificationGrouping(t *testing.T) {
 	expect("n2", acrit, bwarn, cA)
 	expect("n3", bcrit, cB)
 }
+
+func TestDeliveryRetry(t *testing.T) {
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	n := c.Notifications["n"]
+	d := &models.NotificationDelivery{Notification: "n", Status: models.DeliveryPending}
+	if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliveryPending || d.Attempts != 1 || d.LastError == "" {
+		t.Fatalf("expected pending delivery with error after first attempt, got %+v", d)
+	}
+	if wait := d.NextAttempt.Sub(d.LastAttempt); wait != conf.DefaultNotificationRetryBackoff {
+		t.Fatalf("expected first retry after %v, got %v", conf.DefaultNotificationRetryBackoff, wait)
+	}
+	s.attemptDelivery(n, d)
+	if wait := d.NextAttempt.Sub(d.LastAttempt); wait != 2*conf.DefaultNotificationRetryBackoff {
+		t.Fatalf("expected second retry after %v, got %v", 2*conf.DefaultNotificationRetryBackoff, wait)
+	}
+	for d.Attempts <= conf.DefaultNotificationRetries {
+		s.attemptDelivery(n, d)
+	}
+	if d.Status != models.DeliveryFailed || !d.NextAttempt.IsZero() {
+		t.Fatalf("expected failed delivery after %d retries, got %+v", conf.DefaultNotificationRetries, d)
+	}
+	fail = false
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliverySent || d.LastError != "" {
+		t.Fatalf("expected sent delivery, got %+v", d)
+	}
+	if len(d.AttemptLog) != d.Attempts || d.AttemptLog[0].Error == "" || d.AttemptLog[len(d.AttemptLog)-1].Error != "" {
+		t.Fatalf("unexpected attempt log: %+v", d.AttemptLog)
+	}
+}
+
+func TestRetryDeliveryDryRun(t *testing.T) {
+	var posts int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posts++
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	n := c.Notifications["n"]
+	for _, status := range []models.DeliveryStatus{models.DeliveryDryRun, models.DeliverySuppressed} {
+		d := &models.NotificationDelivery{
+			Notification: "n",
+			Status:       status,
+			Pending:      n.Destinations(""),
+		}
+		if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.RetryDelivery(d.Id); err == nil {
+			t.Errorf("expected retry of a %s delivery to be refused", status)
+		}
+	}
+	d := &models.NotificationDelivery{
+		Notification: "n",
+		Status:       models.DeliveryFailed,
+		Pending:      n.Destinations(""),
+	}
+	if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDryRun("", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RetryDelivery(d.Id); err == nil {
+		t.Error("expected retry to be refused in dry run")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if posts != 0 {
+		t.Errorf("expected nothing sent, got %d posts", posts)
+	}
+}
+
+func TestDeliveryRetryDestinations(t *testing.T) {
+	var posts, gets int
+	postFail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+			return
+		}
+		posts++
+		if postFail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			get = %s
+		}
+	`, ts.URL, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	n := c.Notifications["n"]
+	d := &models.NotificationDelivery{
+		Notification: "n",
+		Status:       models.DeliveryPending,
+		Pending:      n.Destinations(""),
+		Attachments:  []models.DeliveryAttachment{{Data: []byte("png"), Filename: "1.png"}},
+	}
+	if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliveryPending || !reflect.DeepEqual(d.Pending, []string{conf.DestPost}) {
+		t.Fatalf("expected only post pending, got %+v", d)
+	}
+	postFail = false
+	s.claimDelivery(d.Id)
+	if err := s.RetryDelivery(d.Id); err == nil {
+		t.Fatal("expected retry of a delivery being sent to be refused")
+	}
+	s.releaseDelivery(d.Id)
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliverySent || len(d.Pending) != 0 {
+		t.Fatalf("expected sent delivery, got %+v", d)
+	}
+	if posts != 2 || gets != 1 {
+		t.Errorf("expected the retry to post only, got %d posts and %d gets", posts, gets)
+	}
+	if len(d.Attachments) != 1 {
+		t.Errorf("expected attachments kept for retries, got %+v", d.Attachments)
+	}
+}
+
+func TestDeliveryRetryPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Second)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			retries = 1
+			retryBackoff = 10s
+			retryJitter = 50%%
+			sendTimeout = 1s
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	n := c.Notifications["n"]
+	d := &models.NotificationDelivery{Notification: "n", Status: models.DeliveryPending}
+	if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+		t.Fatal(err)
+	}
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliveryPending || d.LastError == "" {
+		t.Fatalf("expected send to time out, got %+v", d)
+	}
+	if wait := d.NextAttempt.Sub(d.LastAttempt); wait < 10*time.Second || wait >= 15*time.Second {
+		t.Fatalf("expected retry after 10s plus up to 50%%, got %v", wait)
+	}
+	s.attemptDelivery(n, d)
+	if d.Status != models.DeliveryFailed || len(d.AttemptLog) != 2 {
+		t.Fatalf("expected failed delivery after one retry, got %+v", d)
+	}
+}
+
+func TestDeliveryBodyTemplate(t *testing.T) {
+	bodies := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- r.Header.Get("Content-Type") + " " + string(b)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			contentType = application/json
+			bodyTemplate = {"key": {{.AlertKey | json}}, "incident": {{.IncidentId}}, "status": "{{.Status}}", "host": "{{.State.Group.host}}"}
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	st := &State{
+		Group:   opentsdb.TagSet{"host": "h"},
+		History: []Event{{Status: StCritical, IncidentId: 3}},
+	}
+	s.deliver(c.Notifications["n"], st, "a{host=h}", "subject", "body", nil, nil)
+	select {
+	case b := <-bodies:
+		expected := `application/json {"key": "a{host=h}", "incident": 3, "status": "critical", "host": "h"}`
+		if b != expected {
+			t.Errorf("expected %s, got %s", expected, b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for post")
+	}
+}
+
+func TestNotificationKey(t *testing.T) {
+	headers := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		headers <- r.Header.Get(conf.KeyHeader) + " " + string(b)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			bodyTemplate = {{.NotificationKey}}
+		}
+		notification m {
+			print = true
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	st := &State{History: []Event{{Status: StCritical, IncidentId: 3}}}
+	n, m := c.Notifications["n"], c.Notifications["m"]
+	now := time.Date(2016, 3, 1, 12, 1, 0, 0, time.UTC)
+	key := s.notificationKey(n, st, "a", now)
+	if s.notificationKey(n, st, "b", now.Add(time.Minute)) != key {
+		t.Error("expected the same key for the same incident and step in one check period")
+	}
+	if s.notificationKey(n, st, "a", now.Add(c.CheckFrequency)) == key {
+		t.Error("expected a different key in the next check period")
+	}
+	if s.notificationKey(m, st, "a", now) == key {
+		t.Error("expected a different key for another notification")
+	}
+	if s.notificationKey(n, nil, "a", now) == key || s.notificationKey(n, nil, "a", now) != s.notificationKey(n, &State{}, "a", now) {
+		t.Error("expected notifications without an incident to be keyed by alert key")
+	}
+	s.deliver(n, st, "a", "subject", "body", nil, nil)
+	select {
+	case h := <-headers:
+		f := strings.Fields(h)
+		if len(f) != 2 || f[0] != f[1] || len(f[0]) != 40 {
+			t.Errorf("expected the key in the header and body, got %q", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for post")
+	}
+}
+
+func TestDeliverySuppression(t *testing.T) {
+	c, err := conf.New("", `
+		notification limited {
+			print = true
+			maxPerHour = 2
+		}
+		notification quiet {
+			print = true
+			quietHours = 00:00-23:59
+		}
+		notification dropped {
+			print = true
+			quietHours = 00:00-23:59
+			quietAction = drop
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	limited := c.Notifications["limited"]
+	for i := 0; i < 3; i++ {
+		d := &models.NotificationDelivery{Notification: "limited"}
+		if allowed := s.allowDelivery(limited, d, now); allowed != (i < 2) {
+			t.Fatalf("delivery %d: expected allowed %v", i, i < 2)
+		}
+		if i == 2 && d.Status != models.DeliverySuppressed {
+			t.Fatalf("expected suppressed delivery, got %+v", d)
+		}
+	}
+	if !s.allowDelivery(limited, &models.NotificationDelivery{}, now.Add(time.Hour+time.Second)) {
+		t.Fatal("expected delivery to be allowed after an hour")
+	}
+	quiet := c.Notifications["quiet"]
+	d := &models.NotificationDelivery{Notification: "quiet"}
+	if s.allowDelivery(quiet, d, now) || d.Status != models.DeliveryPending || !d.NextAttempt.After(now) {
+		t.Fatalf("expected delivery to be postponed, got %+v", d)
+	}
+	d = &models.NotificationDelivery{Notification: "dropped"}
+	if s.allowDelivery(c.Notifications["dropped"], d, now) || d.Status != models.DeliverySuppressed {
+		t.Fatalf("expected delivery to be dropped, got %+v", d)
+	}
+}
+
+// queueFailure fails to queue deliveries, like a database outage.
+type queueFailure struct {
+	*nopDataAccess
+}
+
+func (q queueFailure) Deliveries() database.DeliveryDataAccess {
+	return q
+}
+
+func (q queueFailure) QueueDelivery(d *models.NotificationDelivery) error {
+	d.Id = 1
+	return fmt.Errorf("database down")
+}
+
+func TestDeliverQueueFailure(t *testing.T) {
+	posts := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		posts <- string(b)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			maxPerHour = 1
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	nop := s.DataAccess.(*nopDataAccess)
+	s.DataAccess = queueFailure{nop}
+	n := c.Notifications["n"]
+	s.deliver(n, nil, "a{}", "1", "", nil, nil)
+	s.deliver(n, nil, "a{}", "2", "", nil, nil)
+	select {
+	case b := <-posts:
+		if b != "1" {
+			t.Errorf("unexpected post: %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for post")
+	}
+	select {
+	case b := <-posts:
+		t.Errorf("expected maxPerHour to hold without the database, got post %q", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if len(nop.deliveries) != 0 {
+		t.Errorf("expected unqueued deliveries not to be stored, got %v", nop.deliveries)
+	}
+}
+
+func TestPostponedDeliveryClosedIncident(t *testing.T) {
+	c, err := conf.New("", `
+		notification n {
+			print = true
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	end := time.Now().UTC()
+	s.Incidents = map[uint64]*Incident{
+		1: {Id: 1},
+		2: {Id: 2, End: &end},
+	}
+	n := c.Notifications["n"]
+	for id, expected := range map[uint64]models.DeliveryStatus{
+		1: models.DeliverySent,
+		2: models.DeliverySuppressed,
+		3: models.DeliverySuppressed,
+	} {
+		// Postponed by quiet hours that have ended.
+		d := &models.NotificationDelivery{
+			Notification: "n",
+			IncidentId:   id,
+			Status:       models.DeliveryPending,
+			Pending:      []string{},
+		}
+		if err := s.DataAccess.Deliveries().QueueDelivery(d); err != nil {
+			t.Fatal(err)
+		}
+		s.retryDelivery(n, d.Id)
+		d, err := s.DataAccess.Deliveries().GetDelivery(d.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Status != expected {
+			t.Errorf("incident %d: got status %v, expected %v", id, d.Status, expected)
+		}
+	}
+}
+
+func TestTemplateRecent(t *testing.T) {
+	var requests int
+	slow := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow {
+			time.Sleep(time.Second)
+		}
+		requests++
+		var req opentsdb.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		if host := req.Queries[0].Tags["host"]; host != "a" {
+			t.Errorf("expected query filtered to host a, got %v", host)
+		}
+		now := time.Now().Unix()
+		fmt.Fprintf(w, `[{"metric":"m","tags":{"host":"a"},"aggregateTags":[],"dps":{"%d":1,"%d":7}}]`, now-120, now-60)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		tsdbHost = %s
+		templateQueryLimit = 2
+		alert a {
+			crit = 1
+		}
+	`, ts.Listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rh := s.NewRunHistory(time.Now(), cache.New(0))
+	st := &State{Group: opentsdb.TagSet{"host": "a"}}
+	ctx := s.Data(rh, st, c.Alerts["a"], false)
+	for i := 0; i < 2; i++ {
+		v, err := ctx.Recent("sum:m{host=*}", "5m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != expr.Number(7) {
+			t.Fatalf("expected most recent value 7, got %v", v)
+		}
+	}
+	if _, err := ctx.Recent("sum:m{host=*}", "5m"); err == nil {
+		t.Fatal("expected error over the query limit")
+	}
+	if _, err := s.Data(rh, st, c.Alerts["a"], false).Recent("sum:m{host=*}", "5m"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request in the check cycle, got %d", requests)
+	}
+	slow = true
+	c.TemplateQueryTimeout = 10 * time.Millisecond
+	rh = s.NewRunHistory(time.Now(), cache.New(0))
+	if _, err := s.Data(rh, st, c.Alerts["a"], false).Recent("sum:m{host=*}", "5m"); err == nil {
+		t.Fatal("expected timeout error")
+	}
+}
+
+func TestUnknownGroupTemplate(t *testing.T) {
+	c, err := conf.New("", `
+		template ug {
+			subject = {{len .Group}} {{.CommonTags}} {{.Min}}-{{.Max}} {{index .StatusCounts "unknown"}}
+		}
+		unknownGroupTemplate = ug
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := 7.0
+	states := make(States)
+	group := expr.AlertKeys{
+		expr.NewAlertKey("a", opentsdb.TagSet{"host": "h1", "dc": "ny"}),
+		expr.NewAlertKey("a", opentsdb.TagSet{"host": "h2", "dc": "ny"}),
+		expr.NewAlertKey("a", opentsdb.TagSet{"host": "h3", "dc": "ny"}),
+	}
+	states[group[0]] = &State{Group: group[0].Group(), History: []Event{
+		{Status: StWarning, Warn: &Result{Result: &expr.Result{Value: expr.Number(3)}}},
+		{Status: StUnknown},
+	}}
+	states[group[1]] = &State{Group: group[1].Group(), History: []Event{
+		{Status: StCritical, Value: &v},
+		{Status: StUnknown},
+	}}
+	states[group[2]] = &State{Group: group[2].Group(), History: []Event{
+		{Status: StNormal},
+	}}
+	data := s.unknownGroupData(time.Now(), "g", group, states)
+	buf := new(bytes.Buffer)
+	if err := c.UnknownGroupTemplate.Subject.Execute(buf, data); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "3 {dc=ny} 3-7 2"; buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestPagerDuty(t *testing.T) {
+	events := make(chan map[string]interface{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Error(err)
+		}
+		events <- e
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+	defer func(u string) { conf.PagerDutyURL = u }(conf.PagerDutyURL)
+	conf.PagerDutyURL = ts.URL
+	c, err := conf.New("", `
+		template t {
+			subject = {{.Last.Status}}
+		}
+		notification email {
+			print = true
+		}
+		notification pd {
+			pagerDuty = abc123
+			runOnActions = false
+		}
+		alert a {
+			template = t
+			warnNotification = email,pd
+			warn = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := expr.NewAlertKey("a", nil)
+	expect := func(action string) {
+		select {
+		case e := <-events:
+			if e["event_action"] != action || e["routing_key"] != "abc123" || e["dedup_key"] != string(ak) {
+				t.Fatalf("expected %s event for %s, got %v", action, ak, e)
+			}
+			if _, ok := e["payload"]; ok != (action == conf.PagerDutyTrigger) {
+				t.Fatalf("unexpected payload in %s event: %v", action, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("failed to receive %s event before timeout", action)
+		}
+	}
+	check(s, time.Now())
+	s.CheckNotifications()
+	expect(conf.PagerDutyTrigger)
+	if err := s.Action("u", "m", "", ActionAcknowledge, ak); err != nil {
+		t.Fatal(err)
+	}
+	expect(conf.PagerDutyAcknowledge)
+	s.status[ak].Append(&Event{Status: StNormal})
+	if err := s.Action("u", "m", "", ActionClose, ak); err != nil {
+		t.Fatal(err)
+	}
+	expect(conf.PagerDutyResolve)
+}
+
+func TestGraphMulti(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req opentsdb.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		q := req.Queries[0]
+		now := time.Now().Unix()
+		fmt.Fprintf(w, `[{"metric":"%s","tags":{"host":"%s"},"aggregateTags":[],"dps":{"%d":60,"%d":90}}]`, q.Metric, q.Tags["host"], now-120, now-60)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		tsdbHost = %s
+		alert a {
+			warn = avg(q("sum:m{host=*}", "5m", "")) > 50
+			crit = avg(q("sum:m{host=*}", "5m", "")) >= 80
+		}
+	`, ts.Listener.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := c.Alerts["a"]
+	if w, c := threshold(a.Warn), threshold(a.Crit); w != 50 || c != 80 {
+		t.Fatalf("got thresholds %v, %v", w, c)
+	}
+	now := time.Now()
+	rh := s.NewRunHistory(now, cache.New(0))
+	st := &State{Group: opentsdb.TagSet{"host": "a"}, History: []Event{
+		{Status: StNormal, Time: now.Add(-time.Hour)},
+		{Status: StCritical, Time: now.Add(-2 * time.Minute)},
+		{Status: StCritical, Time: now.Add(-time.Minute)},
+	}}
+	ctx := s.Data(rh, st, a, false)
+	if tt := ctx.triggerTime(); !tt.Equal(now.Add(-2 * time.Minute)) {
+		t.Fatalf("got trigger time %v", tt)
+	}
+	v, err := ctx.GraphMulti("ms", `q("sum:m{host=*}", "5m", "")`, `q("sum:n{host=*}", "5m", "")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(v.(template.HTML))
+	for _, s := range []string{"<svg", "1: {host=a}", "2: {host=a}", "warn", "crit", "triggered", "sum:n{host=a}"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("graph missing %q", s)
+		}
+	}
+	ctx = s.Data(rh, st, a, true)
+	if _, err := ctx.GraphMulti("", `q("sum:m{host=*}", "5m", "")`); err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.Attachments) != 1 {
+		t.Fatalf("expected a png attachment, got %d", len(ctx.Attachments))
+	}
+}
+
+func TestExprChartBounds(t *testing.T) {
+	d := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	res := []*expr.Result{{
+		Value: expr.Series{d: 5, d.Add(time.Minute): 6},
+		Bounds: &expr.Bounds{
+			Lower: expr.Series{d: 4, d.Add(time.Minute): 5},
+			Upper: expr.Series{d: 7, d.Add(time.Minute): 8},
+		},
+	}}
+	c := exprChart("", res, []string{"a"}, nil)
+	if len(c.Data) != 2 {
+		t.Fatalf("expected bounds and series data, got %d", len(c.Data))
+	}
+	b := c.Data[0]
+	if b.Name != "" || len(b.Samples) != 2 {
+		t.Fatalf("unexpected bounds data: %+v", b)
+	}
+	if p := b.Samples[1]; p.Y != 6.5 || p.DeltaY != 3 {
+		t.Errorf("expected bounds 5 to 8, got %v +/- %v", p.Y, p.DeltaY/2)
+	}
+}
+
+func TestNotificationStepTemplates(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = {{.Alert.Name}} is {{.Last.Status}}
+			body = full body
+		}
+		notification sms {
+			print = true
+			templateSubject = SMS {{.Alert.Name}}
+		}
+		notification email {
+			print = true
+			next = sms
+			timeout = 1h
+		}
+		alert a {
+			template = t
+			crit = 1
+			critNotification = email
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	st := &State{Alert: "a", Group: opentsdb.TagSet{}}
+	ev := &Event{Status: StCritical}
+	st.Append(ev)
+	rh := s.NewRunHistory(time.Now(), cache.New(0))
+	s.executeTemplates(st, ev, c.Alerts["a"], rh)
+	if st.Subject != "a is critical" || st.Body != "" {
+		t.Fatalf("expected only the subject rendered by the check, got %q, %q", st.Subject, st.Body)
+	}
+	body := s.stateMessage(st, nil).Body
+	if !strings.Contains(body, "full body") {
+		t.Fatalf("unexpected default body: %q", body)
+	}
+	if m := s.stateMessage(st, c.Notifications["email"]); m != s.stateMessage(st, nil) {
+		t.Error("expected a notification without overrides to use the cached default message")
+	}
+	m := s.stateMessage(st, c.Notifications["sms"])
+	if m.Subject != "SMS a" || m.Body != body {
+		t.Errorf("expected overridden subject and default body, got %q, %q", m.Subject, m.Body)
+	}
+	if s.stateMessage(st, c.Notifications["sms"]) != m {
+		t.Error("expected the sms message to be cached")
+	}
+	st.Append(&Event{Status: StCritical})
+	if st.rendering.state.History[len(st.rendering.state.History)-1] != st.History[0] || len(st.rendering.state.History) != 1 {
+		t.Error("expected the rendering's snapshot to be unchanged by later events")
+	}
+}
+
+func TestRestoreRendering(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = s
+			body = restored {{.Last.Status}}
+		}
+		alert a {
+			template = t
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	st := &State{Alert: "a", Group: opentsdb.TagSet{}, Subject: "s"}
+	st.Append(&Event{Status: StCritical, Time: time.Now()})
+	if m := s.stateMessage(st, nil); m.Body != "" {
+		t.Fatalf("expected no body before restoring, got %q", m.Body)
+	}
+	s.restoreRendering(st)
+	if m := s.stateMessage(st, nil); !strings.Contains(m.Body, "restored critical") {
+		t.Fatalf("expected restored body, got %q", m.Body)
+	}
+	stored := &State{Alert: "a", Group: opentsdb.TagSet{}, Body: "stored"}
+	stored.Append(&Event{Status: StCritical})
+	s.restoreRendering(stored)
+	if m := s.stateMessage(stored, nil); m.Body != "stored" {
+		t.Fatalf("expected stored body, got %q", m.Body)
+	}
+}
+
+func TestNotificationGroups(t *testing.T) {
+	c, err := conf.New("", `
+		notification a {
+			print = true
+		}
+		notification b {
+			print = true
+		}
+		notification c {
+			print = true
+		}
+		notification all {
+			members = a,b
+		}
+		notification rotation {
+			members = a,b,c
+			mode = roundrobin
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	names := func(ns []*conf.Notification) string {
+		var l []string
+		for _, n := range ns {
+			l = append(l, n.Name)
+		}
+		return strings.Join(l, ",")
+	}
+	incident := func(id uint64) *State {
+		return &State{History: []Event{{Status: StCritical, IncidentId: id}}}
+	}
+	if m := names(s.groupMembers(c.Notifications["all"], incident(1))); m != "a,b" {
+		t.Errorf("expected fanout to a,b, got %s", m)
+	}
+	rotation := c.Notifications["rotation"]
+	for _, test := range []struct {
+		id     uint64
+		member string
+	}{
+		{4, "b"},
+		{4, "b"},
+		{5, "c"},
+		{6, "a"},
+	} {
+		if m := names(s.groupMembers(rotation, incident(test.id))); m != test.member {
+			t.Errorf("incident %d: expected %s, got %s", test.id, test.member, m)
+		}
+	}
+	var turns []string
+	for i := 0; i < 4; i++ {
+		turns = append(turns, names(s.groupMembers(rotation, nil)))
+	}
+	if strings.Join(turns, " ") != "a b c a" {
+		t.Errorf("expected notifications without incidents to take turns, got %v", turns)
+	}
+
+	// c is passed over once it is unhealthy, and used again once it recovers.
+	now := time.Now()
+	for i := 0; i < unhealthyFailures; i++ {
+		s.recordDelivery("c", now, fmt.Errorf("connection refused"))
+	}
+	if m := names(s.groupMembers(rotation, incident(5))); m != "a" {
+		t.Errorf("expected unhealthy c to be passed over for a, got %s", m)
+	}
+	groups := s.NotificationGroups()
+	if len(groups) != 2 || groups[1].Name != "rotation" || groups[1].Mode != "roundrobin" {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+	if h := groups[1].Members[2]; h.Name != "c" || h.Healthy || h.Failures != unhealthyFailures || h.LastError != "connection refused" {
+		t.Errorf("expected c unhealthy, got %+v", h)
+	}
+	if !groups[1].Members[0].Healthy {
+		t.Error("expected a, which has not been sent to, to be healthy")
+	}
+	s.recordDelivery("c", now, nil)
+	if m := names(s.groupMembers(rotation, incident(5))); m != "c" {
+		t.Errorf("expected recovered c, got %s", m)
+	}
+}
+
+func TestRenderTemplateTest(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = {{.Last.Status}} {{.Group.host}} {{.Eval .Alert.Vars.v}}
+			body = {{if .IsEmail}}email{{else}}web{{end}} {{.Result.Value}}
+		}
+		template bad {
+			subject = {{index .Alert.Name 100}}
+		}
+		alert a {
+			template = t
+			$v = 2
+			crit = 1
+		}
+		alert b {
+			template = bad
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.RenderTemplateTest(c.Alerts["a"], &TemplateTest{
+		Group:  opentsdb.TagSet{"host": "x"},
+		Status: "critical",
+		Value:  5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "critical x 2" || !strings.Contains(r.Body, "web 5") || !strings.Contains(r.EmailBody, "email 5") || len(r.Errors) != 0 {
+		t.Fatalf("unexpected result: %+v", r)
+	}
+	r, err = s.RenderTemplateTest(c.Alerts["a"], &TemplateTest{Group: opentsdb.TagSet{"host": "x"}, Status: "unknown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "a: 1 unknown alerts" {
+		t.Fatalf("unexpected unknown subject: %q", r.Subject)
+	}
+	r, err = s.RenderTemplateTest(c.Alerts["b"], &TemplateTest{Status: "warning"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Errors) == 0 || !strings.Contains(r.Subject, "error") {
+		t.Fatalf("expected template error, got %+v", r)
+	}
+	if _, err := s.RenderTemplateTest(c.Alerts["a"], &TemplateTest{Status: "bogus"}); err == nil {
+		t.Fatal("expected error for unknown status")
+	}
+}
+
+func TestUpcomingNotifications(t *testing.T) {
+	c, err := conf.New("", `
+		smtpHost = localhost:25
+		emailFrom = bosun@example.com
+		notification b {
+			post = http://example.com/page?key=secret
+			next = b
+			timeout = 20m
+		}
+		notification a {
+			email = a@example.com
+			next = b
+			timeout = 10m
+		}
+		alert x {
+			crit = 1
+		}
+		alert y {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	x := expr.NewAlertKey("x", nil)
+	y := expr.NewAlertKey("y", nil)
+	s.AddNotification(x, c.Notifications["a"], now)
+	s.AddNotification(y, c.Notifications["b"], now.Add(time.Hour))
+	l, err := s.UpcomingNotifications("", now, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, u := range l {
+		got = append(got, fmt.Sprintf("%s %s %v %v %v", u.AlertKey, u.Notification, u.In, u.Chained, u.Recipients))
+	}
+	// a's chain ends in b repeating; y's notification is past the horizon.
+	expected := []string{
+		"x{} a 10m0s false [a@example.com]",
+		"x{} b 30m0s true [post example.com]",
+		"x{} b 50m0s true [post example.com]",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected upcoming notifications:\n%s", strings.Join(got, "\n"))
+	}
+	l, err = s.UpcomingNotifications("y", now, 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l) != 1 || l[0].AlertKey != y || l[0].In != 80*time.Minute || l[0].Chained {
+		t.Errorf("unexpected upcoming notifications of y: %+v", l)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		posts <- string(b)
+	}))
+	defer ts.Close()
+	for _, sub := range []*models.Subscription{
+		{Alert: "a", Post: ts.URL},
+		{User: "u", Post: ts.URL},
+		{User: "u", Alert: "b", Post: ts.URL},
+		{User: "u", Alert: "a"},
+		{User: "u", Alert: "a", Post: "ftp://example.com"},
+		{User: "u", Tags: "host", Post: ts.URL},
+	} {
+		if err := s.AddSubscription(sub); err == nil {
+			t.Errorf("expected error adding %+v", sub)
+		}
+	}
+	for _, sub := range []*models.Subscription{
+		{User: "u", Alert: "a", Tags: "host=web*", Post: ts.URL},
+		{User: "v", Tags: "host=db*", Post: ts.URL},
+	} {
+		if err := s.AddSubscription(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if subs, err := s.Subscriptions("u"); err != nil || len(subs) != 1 || subs[0].Tags != "host=web*" {
+		t.Fatalf("unexpected subscriptions of u: %v %v", subs, err)
+	}
+
+	h := &subscriptionHook{s: s}
+	h.OnStateChange(expr.NewAlertKey("a", opentsdb.TagSet{"host": "web1"}), StNormal, Event{Status: StCritical, Time: time.Now()})
+	select {
+	case b := <-posts:
+		if b != "[watch] a{host=web1} is critical" {
+			t.Errorf("unexpected post: %q", b)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for subscription post")
+	}
+	// Neither subscription watches app hosts, and new normal alert keys
+	// don't notify.
+	h.OnStateChange(expr.NewAlertKey("a", opentsdb.TagSet{"host": "app1"}), StNormal, Event{Status: StCritical, Time: time.Now()})
+	h.OnStateChange(expr.NewAlertKey("a", opentsdb.TagSet{"host": "db1"}), StNone, Event{Status: StNormal, Time: time.Now()})
+	select {
+	case b := <-posts:
+		t.Errorf("unexpected post: %q", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := s.DeleteSubscription(1, "v"); err == nil {
+		t.Fatal("expected error deleting another user's subscription")
+	}
+	if err := s.DeleteSubscription(1, "u"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteSubscription(1, ""); err == nil {
+		t.Fatal("expected error deleting missing subscription")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	posts := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		posts <- string(b)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+		}
+		alert a {
+			crit = 1
+			dryRun = true
+		}
+		alert b {
+			crit = 1
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := c.Notifications["n"]
+	deliver := func(alert, subject string) {
+		s.deliver(n, nil, string(expr.NewAlertKey(alert, nil)), subject, "", nil, nil)
+	}
+	expectPost := func(want string) {
+		select {
+		case b := <-posts:
+			if b != want {
+				t.Errorf("unexpected post: %q, want %q", b, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for post %q", want)
+		}
+	}
+	expectNoPost := func() {
+		select {
+		case b := <-posts:
+			t.Errorf("unexpected post: %q", b)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	deliveries := s.DataAccess.(*nopDataAccess).deliveries
+	expectStatus := func(id int64, want models.DeliveryStatus) {
+		deliveryLock.Lock()
+		defer deliveryLock.Unlock()
+		if d := deliveries[id]; d == nil || d.Status != want {
+			t.Errorf("expected delivery %d to be %s, got %+v", id, want, d)
+		}
+	}
+
+	if d := s.DryRun(); d.Enabled || !reflect.DeepEqual(d.Alerts, []string{"a"}) {
+		t.Fatalf("unexpected dry run: %+v", d)
+	}
+	deliver("a", "1")
+	expectNoPost()
+	expectStatus(1, models.DeliveryDryRun)
+	deliver("b", "2")
+	expectPost("2")
+
+	if err := s.SetDryRun("c", true); err == nil {
+		t.Error("expected error for unknown alert")
+	}
+	if err := s.SetDryRun("", true); err != nil {
+		t.Fatal(err)
+	}
+	deliver("b", "3")
+	expectNoPost()
+	expectStatus(3, models.DeliveryDryRun)
+
+	// Runtime changes override the configuration.
+	if err := s.SetDryRun("", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDryRun("a", false); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.DryRun(); d.Enabled || len(d.Alerts) != 0 {
+		t.Fatalf("unexpected dry run: %+v", d)
+	}
+	deliver("a", "4")
+	expectPost("4")
+}
+
+func TestCoalesce(t *testing.T) {
+	posts := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		posts <- string(b)
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		notification n {
+			post = %s
+			coalesceWindow = 1m
+			coalesceBy = host
+		}
+		alert a {
+			crit = 1
+		}
+		alert b {
+			crit = 1
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := c.Notifications["n"]
+	now := time.Now()
+	hold := func(alert, host string, needAck bool) {
+		st := &State{
+			Alert:   alert,
+			Group:   opentsdb.TagSet{"host": host},
+			Subject: alert + " on " + host,
+			Body:    "body",
+			NeedAck: needAck,
+			History: []Event{{Status: StCritical, IncidentId: 1}},
+		}
+		s.status[st.AlertKey()] = st
+		s.coalesce(st, n, now)
+	}
+	hold("a", "x", true)
+	hold("b", "x", true)
+	hold("a", "y", true)
+	// Acknowledged while held.
+	hold("b", "z", false)
+	if next := s.sendCoalesced(now, nil); !next.Equal(now.Add(time.Minute)) {
+		t.Fatalf("expected notifications due in 1m, got %v", next)
+	}
+	select {
+	case b := <-posts:
+		t.Fatalf("unexpected post before the window ended: %q", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if next := s.sendCoalesced(now.Add(time.Minute), nil); !next.IsZero() {
+		t.Errorf("expected no more notifications held, got %v", next)
+	}
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case b := <-posts:
+			got = append(got, b)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for posts, got %q", got)
+		}
+	}
+	sort.Strings(got)
+	if expected := []string{"2 alerts for {host=x}", "a on y"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("got posts %q, expected %q", got, expected)
+	}
+	select {
+	case b := <-posts:
+		t.Errorf("unexpected post: %q", b)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -9,12 +9,16 @@ import (
 	"time"
 
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/slog"
 )
 
 func (s *Schedule) dispatchNotifications() {
-	ticker := time.NewTicker(s.Conf.CheckFrequency * 2)
+	window := s.Conf.UnknownBatchWindow
+	if window == 0 {
+		window = s.Conf.CheckFrequency * 2
+	}
+	ticker := time.NewTicker(window)
 	timeout := s.CheckNotifications()
 	for {
 		select {
@@ -39,54 +43,69 @@ func (s *Schedule) Notify(st *State, n *conf.Notification) {
 // CheckNotifications processes past notification events. It returns the
 // duration until the soonest notification triggers.
 func (s *Schedule) CheckNotifications() time.Duration {
+	if !s.IsLeader() {
+		return s.Conf.CheckFrequency
+	}
 	silenced := s.Silenced()
+	defer s.recordSuppressed()
 	s.Lock("CheckNotifications")
 	defer s.Unlock()
-	notifications := s.Notifications
-	s.Notifications = nil
-	for ak, ns := range notifications {
-		if _, present := silenced[ak]; present {
+	nd := s.DataAccess.Notifications()
+	now := time.Now()
+	due, err := nd.GetDueNotifications(now)
+	if err != nil {
+		slog.Errorln("error getting notifications:", err)
+		return time.Minute
+	}
+	for key, ns := range due {
+		ak := expr.AlertKey(key)
+		if si, present := silenced[ak]; present {
 			slog.Infoln("silencing", ak)
+			for name := range ns {
+				s.countSuppressed(si, name)
+			}
+			s.clearNotifications(ak)
 			continue
 		}
-		for name, t := range ns {
+		for name := range ns {
 			n, present := s.Conf.Notifications[name]
-			if !present {
-				continue
-			}
-			remaining := t.Add(n.Timeout).Sub(time.Now())
-			if remaining > 0 {
-				s.AddNotification(ak, n, t)
-				continue
-			}
 			st := s.status[ak]
-			if st == nil {
-				continue
-			}
 			// If alert is currently unevaluated because of a dependency,
-			// simply requeue it until the dependency resolves itself.
-			if st.Unevaluated {
-				s.AddNotification(ak, n, t)
+			// simply requeue it until the dependency resolves itself. Do
+			// the same if the alert only renotifies when its value worsens
+			// and it has not.
+			if present && st != nil && (st.Unevaluated || !s.worsened(st)) {
+				if err := nd.InsertNotification(key, name, now.Add(s.Conf.CheckFrequency)); err != nil {
+					slog.Errorln("error requeueing notification:", err)
+				}
 				continue
 			}
-			s.Notify(st, n)
+			if err := nd.ClearNotification(key, name); err != nil {
+				slog.Errorln("error clearing notification:", err)
+			}
+			if present && st != nil {
+				s.Notify(st, n)
+			}
 		}
 	}
+	nextSnooze := s.expireSnoozes(now)
 	s.sendNotifications(silenced)
 	s.pendingNotifications = nil
+	nextCoalesced := s.sendCoalesced(now, silenced)
 	timeout := time.Hour
-	now := time.Now()
-	for _, ns := range s.Notifications {
-		for name, t := range ns {
-			n, present := s.Conf.Notifications[name]
-			if !present {
-				continue
-			}
-			remaining := t.Add(n.Timeout).Sub(now)
-			if remaining < timeout {
-				timeout = remaining
-			}
-		}
+	next, err := nd.GetNextNotificationTime()
+	if err != nil {
+		slog.Errorln("error getting next notification time:", err)
+		return time.Minute
+	}
+	if !next.IsZero() && next.Sub(now) < timeout {
+		timeout = next.Sub(now)
+	}
+	if !nextSnooze.IsZero() && nextSnooze.Sub(now) < timeout {
+		timeout = nextSnooze.Sub(now)
+	}
+	if !nextCoalesced.IsZero() && nextCoalesced.Sub(now) < timeout {
+		timeout = nextCoalesced.Sub(now)
 	}
 	return timeout
 }
@@ -99,15 +118,19 @@ func (s *Schedule) sendNotifications(silenced map[expr.AlertKey]Silence) {
 	for n, states := range s.pendingNotifications {
 		for _, st := range states {
 			ak := st.AlertKey()
-			_, silenced := silenced[ak]
+			si, isSilenced := silenced[ak]
 			if st.Last().Status == StUnknown {
-				if silenced {
+				if isSilenced {
 					slog.Infoln("silencing unknown", ak)
+					s.countSuppressed(si, n.Name)
 					continue
 				}
 				s.pendingUnknowns[n] = append(s.pendingUnknowns[n], st)
-			} else if silenced {
+			} else if isSilenced {
 				slog.Infoln("silencing", ak)
+				s.countSuppressed(si, n.Name)
+			} else if n.CoalesceWindow > 0 {
+				s.coalesce(st, n, time.Now())
 			} else {
 				s.notify(st, n)
 			}
@@ -116,6 +139,16 @@ func (s *Schedule) sendNotifications(silenced map[expr.AlertKey]Silence) {
 			}
 		}
 	}
+	// Send unknowns early instead of waiting for the batch window if there
+	// are too many pending.
+	if size := s.Conf.UnknownBatchSize; size > 0 {
+		for _, states := range s.pendingUnknowns {
+			if len(states) >= size {
+				s.sendUnknownNotifications()
+				break
+			}
+		}
+	}
 }
 
 func (s *Schedule) sendUnknownNotifications() {
@@ -126,22 +159,26 @@ func (s *Schedule) sendUnknownNotifications() {
 		for _, st := range states {
 			ustates[st.AlertKey()] = st
 		}
+		groupSets := ustates.GroupSets(s.Conf.MinGroupSize)
+		if s.Conf.UnknownDigestTemplate != nil {
+			s.udigest(groupSets, n)
+			continue
+		}
 		var c int
 		tHit := false
 		oTSets := make(map[string]expr.AlertKeys)
-		groupSets := ustates.GroupSets(s.Conf.MinGroupSize)
 		for name, group := range groupSets {
 			c++
 			if c >= s.Conf.UnknownThreshold && s.Conf.UnknownThreshold > 0 {
 				if !tHit && len(groupSets) == 0 {
 					// If the threshold is hit but only 1 email remains, just send the normal unknown
-					s.unotify(name, group, n)
+					s.unotify(name, group, ustates, n)
 					break
 				}
 				tHit = true
 				oTSets[name] = group
 			} else {
-				s.unotify(name, group, n)
+				s.unotify(name, group, ustates, n)
 			}
 		}
 		if len(oTSets) > 0 {
@@ -168,8 +205,20 @@ var unknownMultiGroup = ttemplate.Must(ttemplate.New("unknownMultiGroup").Parse(
 	</ul>
 	`))
 
+// notify sends n for st. The message is rendered and delivered by a worker,
+// so the schedule lock is not held while templates run.
 func (s *Schedule) notify(st *State, n *conf.Notification) {
-	n.Notify(st.Subject, st.Body, st.EmailSubject, st.EmailBody, s.Conf, string(st.AlertKey()), st.Attachments...)
+	st.NotifiedValue = st.Last().Value
+	s.markDirty(st.AlertKey())
+	s.restoreRendering(st)
+	st = st.Copy()
+	go func() {
+		renderSem <- true
+		defer func() { <-renderSem }()
+		m := s.stateMessage(st, n)
+		emailBody := s.withAlertNote(st.Alert, m.EmailBody)
+		s.deliver(n, st, string(st.AlertKey()), m.Subject, m.Body, m.EmailSubject, emailBody, m.Attachments...)
+	}()
 }
 
 // utnotify is single notification for N unknown groups into a single notification
@@ -192,7 +241,7 @@ func (s *Schedule) utnotify(groups map[string]expr.AlertKeys, n *conf.Notificati
 	}); err != nil {
 		slog.Errorln(err)
 	}
-	n.Notify(subject, body.String(), []byte(subject), body.Bytes(), s.Conf, "unknown_treshold")
+	s.deliver(n, nil, "unknown_treshold", subject, body.String(), []byte(subject), body.Bytes())
 }
 
 var defaultUnknownTemplate = &conf.Template{
@@ -207,7 +256,7 @@ var defaultUnknownTemplate = &conf.Template{
 	Subject: ttemplate.Must(ttemplate.New("").Parse(`{{.Name}}: {{.Group | len}} unknown alerts`)),
 }
 
-func (s *Schedule) unotify(name string, group expr.AlertKeys, n *conf.Notification) {
+func (s *Schedule) unotify(name string, group expr.AlertKeys, states States, n *conf.Notification) {
 	subject := new(bytes.Buffer)
 	body := new(bytes.Buffer)
 	now := time.Now().UTC()
@@ -216,28 +265,63 @@ func (s *Schedule) unotify(name string, group expr.AlertKeys, n *conf.Notificati
 	if t == nil {
 		t = defaultUnknownTemplate
 	}
-	data := s.unknownData(now, name, group)
+	var data interface{} = s.unknownData(now, name, group)
+	if s.Conf.UnknownGroupTemplate != nil && len(group) > 1 {
+		t = s.Conf.UnknownGroupTemplate
+		data = s.unknownGroupData(now, name, group, states)
+	}
 	if t.Body != nil {
-		if err := t.Body.Execute(body, &data); err != nil {
+		if err := t.Body.Execute(body, data); err != nil {
 			slog.Infoln("unknown template error:", err)
 		}
 	}
 	if t.Subject != nil {
-		if err := t.Subject.Execute(subject, &data); err != nil {
+		if err := t.Subject.Execute(subject, data); err != nil {
 			slog.Infoln("unknown template error:", err)
 		}
 	}
-	n.Notify(subject.String(), body.String(), subject.Bytes(), body.Bytes(), s.Conf, name)
+	s.deliver(n, nil, name, subject.String(), body.String(), subject.Bytes(), body.Bytes())
+}
+
+// udigest sends all unknown groups for n as a single notification using the
+// unknownDigestTemplate.
+func (s *Schedule) udigest(groups map[string]expr.AlertKeys, n *conf.Notification) {
+	subject := new(bytes.Buffer)
+	body := new(bytes.Buffer)
+	now := time.Now().UTC()
+	var all expr.AlertKeys
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	s.Group[now] = all
+	t := s.Conf.UnknownDigestTemplate
+	data := s.unknownDigestData(now, groups)
+	if t.Body != nil {
+		if err := t.Body.Execute(body, data); err != nil {
+			slog.Infoln("unknown digest template error:", err)
+		}
+	}
+	if t.Subject != nil {
+		if err := t.Subject.Execute(subject, data); err != nil {
+			slog.Infoln("unknown digest template error:", err)
+		}
+	}
+	s.deliver(n, nil, "unknown_digest", subject.String(), body.String(), subject.Bytes(), body.Bytes())
 }
 
+// AddNotification schedules n to be sent for ak once its timeout has passed
+// since started.
 func (s *Schedule) AddNotification(ak expr.AlertKey, n *conf.Notification, started time.Time) {
-	if s.Notifications == nil {
-		s.Notifications = make(map[expr.AlertKey]map[string]time.Time)
+	if err := s.DataAccess.Notifications().InsertNotification(string(ak), n.Name, started.Add(n.Timeout)); err != nil {
+		slog.Errorln("error adding notification:", err)
 	}
-	if s.Notifications[ak] == nil {
-		s.Notifications[ak] = make(map[string]time.Time)
+}
+
+// clearNotifications stops all scheduled notifications for ak.
+func (s *Schedule) clearNotifications(ak expr.AlertKey) {
+	if err := s.DataAccess.Notifications().ClearNotifications(string(ak)); err != nil {
+		slog.Errorln("error clearing notifications:", err)
 	}
-	s.Notifications[ak][n.Name] = started
 }
 
 var actionNotificationSubjectTemplate *ttemplate.Template
@@ -287,7 +371,33 @@ func (s *Schedule) ActionNotify(at ActionType, user, message string, aks []expr.
 			slog.Error("Error rendering action notification body", err)
 		}
 
-		notification.Notify(subject, buf.String(), []byte(subject), buf.Bytes(), s.Conf, "actionNotification")
+		s.deliver(notification, nil, conf.ActionAlertKey, subject, buf.String(), []byte(subject), buf.Bytes())
+	}
+}
+
+// pagerDuty sends action for ak to every PagerDuty service the alert's
+// notifications, including chained notifications and group members, might
+// have paged.
+func (s *Schedule) pagerDuty(action string, ak expr.AlertKey) {
+	alert := s.Conf.Alerts[ak.Name()]
+	if alert == nil || s.isDryRun(string(ak)) {
+		return
+	}
+	seen := make(map[string]bool)
+	for _, ns := range []*conf.Notifications{alert.WarnNotification, alert.CritNotification, alert.UnknownNotification} {
+		if ns == nil {
+			continue
+		}
+		for _, n := range ns.Get(s.Conf, ak.Group()) {
+			for ; n != nil && !seen[n.Name]; n = n.Next {
+				seen[n.Name] = true
+				for _, m := range append([]*conf.Notification{n}, n.Members...) {
+					if m.PagerDuty != "" {
+						go m.DoPagerDuty(action, string(ak), "")
+					}
+				}
+			}
+		}
 	}
 }
 
@@ -300,9 +410,12 @@ func (s *Schedule) groupActionNotifications(aks []expr.AlertKey) map[*conf.Notif
 			continue
 		}
 		var n *conf.Notifications
-		if status.Status() == StWarning {
+		switch status.Status() {
+		case StWarning:
 			n = alert.WarnNotification
-		} else {
+		case StUnknown:
+			n = alert.UnknownNotifications()
+		default:
 			n = alert.CritNotification
 		}
 		if n == nil {
@@ -0,0 +1,433 @@
+package sched
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.state.pending_writes", metadata.Gauge, metadata.Count,
+		"The number of alert states changed since they were last written to the database.")
+}
+
+// stateFlushInterval is how often changed alert states are written to the
+// database.
+const stateFlushInterval = 10 * time.Second
+
+// stateFlushBatch is the most alert states encoded while holding the
+// schedule lock, so checks are not held up by a large flush.
+const stateFlushBatch = 500
+
+// markDirty records that the state of ak changed and must be written to the
+// database.
+func (s *Schedule) markDirty(ak expr.AlertKey) {
+	s.dirtyLock.Lock()
+	s.dirty[ak] = true
+	s.dirtyLock.Unlock()
+	s.markChanged()
+}
+
+// pendingWrites returns the number of alert states waiting to be written.
+func (s *Schedule) pendingWrites() int {
+	s.dirtyLock.Lock()
+	defer s.dirtyLock.Unlock()
+	return len(s.dirty)
+}
+
+// performFlush writes changed alert states to the database. Only the leader
+// writes, since a standby's states are loaded from the leader's.
+func (s *Schedule) performFlush() {
+	for {
+		time.Sleep(stateFlushInterval)
+		if !s.IsLeader() {
+			continue
+		}
+		if err := s.flushStates(); err != nil {
+			slog.Errorln("flush states:", err)
+		}
+	}
+}
+
+// flushStates writes the alert states changed since the last flush to the
+// database, removing those that no longer exist. States that fail to be
+// written are kept to be written by the next flush.
+func (s *Schedule) flushStates() error {
+	s.dirtyLock.Lock()
+	aks := make([]expr.AlertKey, 0, len(s.dirty))
+	for ak := range s.dirty {
+		aks = append(aks, ak)
+	}
+	s.dirty = make(map[expr.AlertKey]bool)
+	s.dirtyLock.Unlock()
+	defer func() {
+		collect.Put("state.pending_writes", nil, s.pendingWrites())
+	}()
+	for len(aks) > 0 {
+		n := len(aks)
+		if n > stateFlushBatch {
+			n = stateFlushBatch
+		}
+		s.Lock("FlushStates")
+		states, err := s.encodeStates(aks[:n])
+		s.Unlock()
+		if err == nil {
+			err = s.DataAccess.State().PutStates(states)
+		}
+		if err != nil {
+			for _, ak := range aks {
+				s.markDirty(ak)
+			}
+			return err
+		}
+		aks = aks[n:]
+	}
+	return nil
+}
+
+// encodeStates returns the gob encoding of the state of each of aks, or nil
+// for those without state. s must be locked.
+func (s *Schedule) encodeStates(aks []expr.AlertKey) (map[string][]byte, error) {
+	states := make(map[string][]byte, len(aks))
+	for _, ak := range aks {
+		st := s.status[ak]
+		if st == nil {
+			states[string(ak)] = nil
+			continue
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+			return nil, fmt.Errorf("error encoding state of %s: %v", ak, err)
+		}
+		states[string(ak)] = buf.Bytes()
+	}
+	return states, nil
+}
+
+// loadStates returns the alert states stored in the database.
+func (s *Schedule) loadStates() (States, error) {
+	stored, err := s.DataAccess.State().GetStates()
+	if err != nil {
+		return nil, err
+	}
+	status := make(States, len(stored))
+	for k, data := range stored {
+		st := new(State)
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(st); err != nil {
+			slog.Errorf("error decoding state of %s: %v", k, err)
+			continue
+		}
+		status[expr.AlertKey(k)] = st
+	}
+	return status, nil
+}
+
+func (s *Schedule) performSave() {
+	for {
+		time.Sleep(60 * 10 * time.Second) // wait 10 minutes to throttle.
+		s.save()
+	}
+}
+
+type counterWriter struct {
+	written int
+	w       io.Writer
+}
+
+func (c *counterWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.written += n
+	return n, err
+}
+
+// save writes silences, incidents, exclusions, maintenance, and last runs to
+// the database. A standby doesn't save over the leader's state.
+func (s *Schedule) save() {
+	if !s.IsLeader() {
+		return
+	}
+	if err := s.saveObjects(); err != nil {
+		slog.Errorf("save error: %v", err)
+		return
+	}
+	slog.Infoln("save to database complete")
+}
+
+func (s *Schedule) saveObjects() error {
+	objects, err := s.encodeState()
+	if err != nil {
+		return err
+	}
+	return s.DataAccess.State().PutObjects(objects)
+}
+
+// encodeState returns the gzipped gob encoding of each state object. Alert
+// states are not included: they are written to the database as they change
+// by flushStates.
+func (s *Schedule) encodeState() (map[string][]byte, error) {
+	s.Lock("Save")
+	defer s.Unlock()
+	exclusionLock.RLock()
+	defer exclusionLock.RUnlock()
+	maintenanceLock.RLock()
+	defer maintenanceLock.RUnlock()
+	return encodeObjects(map[string]interface{}{
+		dbSilence:     s.Silence,
+		dbIncidents:   s.Incidents,
+		dbExclusions:  s.Exclusions,
+		dbMaintenance: s.Maintenance,
+		dbLastRuns:    s.LastRuns(),
+	})
+}
+
+// encodeObjects returns the gzipped gob encoding of each of the state
+// objects in store.
+func encodeObjects(store map[string]interface{}) (map[string][]byte, error) {
+	tostore := make(map[string][]byte)
+	for name, data := range store {
+		f := new(bytes.Buffer)
+		gz := gzip.NewWriter(f)
+		cw := &counterWriter{w: gz}
+		enc := gob.NewEncoder(cw)
+		if err := enc.Encode(data); err != nil {
+			return nil, fmt.Errorf("error saving %s: %v", name, err)
+		}
+		if err := gz.Flush(); err != nil {
+			slog.Errorf("gzip flush error saving %s: %v", name, err)
+		}
+		if err := gz.Close(); err != nil {
+			slog.Errorf("gzip close error saving %s: %v", name, err)
+		}
+		tostore[name] = f.Bytes()
+		slog.Infof("wrote %s: %v", name, conf.ByteSize(cw.written))
+		collect.Put("statefile.size", opentsdb.TagSet{"object": name}, cw.written)
+	}
+	return tostore, nil
+}
+
+// decodeObject decodes the state object name into dst. dst is left
+// unchanged if there is no such object.
+func decodeObject(objects map[string][]byte, name string, dst interface{}) error {
+	data, ok := objects[name]
+	if !ok {
+		return nil
+	}
+	return decodeGzip(data, dst)
+}
+
+// importObjects stores state objects, encoded as by encodeState, in the
+// database. Alert states and notifications, which older versions kept with
+// the other objects, are written to their own keys. Unless overwrite is set,
+// nothing the database already has is replaced.
+func (s *Schedule) importObjects(objects map[string][]byte, overwrite bool) error {
+	sd := s.DataAccess.State()
+	rest := make(map[string][]byte)
+	for name, data := range objects {
+		switch name {
+		case dbStatus:
+			if err := s.importStates(data, overwrite); err != nil {
+				return err
+			}
+		case dbNotifications:
+			notifications := make(map[expr.AlertKey]map[string]time.Time)
+			if err := decodeGzip(data, &notifications); err != nil {
+				slog.Errorln(dbNotifications, err)
+				continue
+			}
+			for ak, ns := range notifications {
+				for name, due := range ns {
+					if err := s.DataAccess.Notifications().InsertNotification(string(ak), name, due); err != nil {
+						return err
+					}
+				}
+			}
+		default:
+			rest[name] = data
+		}
+	}
+	if !overwrite {
+		existing, err := sd.GetObjects()
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return nil
+		}
+	}
+	return sd.PutObjects(rest)
+}
+
+// importStates stores alert states, encoded as the whole status map was by
+// older versions, in the database.
+func (s *Schedule) importStates(data []byte, overwrite bool) error {
+	sd := s.DataAccess.State()
+	if !overwrite {
+		existing, err := sd.GetStates()
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return nil
+		}
+	}
+	status := make(States)
+	if err := decodeGzip(data, &status); err != nil {
+		slog.Errorln(dbStatus, err)
+		return nil
+	}
+	states := make(map[string][]byte, len(status))
+	for ak, st := range status {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+			return fmt.Errorf("error encoding state of %s: %v", ak, err)
+		}
+		states[string(ak)] = buf.Bytes()
+	}
+	slog.Infof("migrating %d alert states to the database", len(states))
+	return sd.PutStates(states)
+}
+
+// RestoreState loads notification and alert state from the database,
+// importing the state file first if it has not been migrated.
+func (s *Schedule) RestoreState() error {
+	defer func() {
+		bosunStartupTime = time.Now()
+	}()
+	slog.Infoln("RestoreState")
+	start := time.Now()
+	if err := s.MigrateStateFile(); err != nil {
+		slog.Errorln("migrate state file:", err)
+	}
+	objects, err := s.DataAccess.State().GetObjects()
+	if err != nil {
+		slog.Errorln("load state:", err)
+	}
+	s.Lock("RestoreState")
+	s.Search.Lock()
+	s.restoreState(objects)
+	s.Search.Unlock()
+	s.Unlock()
+	s.logConsistency()
+	slog.Infoln("RestoreState done in", time.Since(start))
+	return nil
+}
+
+// restoreState loads the state objects and the alert states in the database
+// into s. s and s.Search must be locked.
+func (s *Schedule) restoreState(objects map[string][]byte) {
+	if err := decodeObject(objects, dbSilence, &s.Silence); err != nil {
+		slog.Errorln(dbSilence, err)
+	}
+	if err := decodeObject(objects, dbIncidents, &s.Incidents); err != nil {
+		slog.Errorln(dbIncidents, err)
+	}
+	if err := decodeObject(objects, dbExclusions, &s.Exclusions); err != nil {
+		slog.Errorln(dbExclusions, err)
+	}
+	if err := decodeObject(objects, dbMaintenance, &s.Maintenance); err != nil {
+		slog.Errorln(dbMaintenance, err)
+	}
+	lastRuns := make(map[string]*AlertRun)
+	if err := decodeObject(objects, dbLastRuns, &lastRuns); err != nil {
+		slog.Errorln(dbLastRuns, err)
+	}
+	s.lastRunLock.Lock()
+	for k, v := range lastRuns {
+		s.lastRuns[k] = v
+	}
+	s.lastRunLock.Unlock()
+
+	// Calculate next incident id.
+	for _, i := range s.Incidents {
+		if i.Id > s.maxIncidentId {
+			s.maxIncidentId = i.Id
+		}
+		if i.Namespace == "" {
+			i.Namespace = s.namespace(i.AlertKey.Name())
+		}
+	}
+	status, err := s.loadStates()
+	if err != nil {
+		slog.Errorln("load states:", err)
+	}
+	clear := func(r *Result) {
+		if r == nil {
+			return
+		}
+		r.Computations = nil
+	}
+	for ak, st := range status {
+		a, present := s.Conf.Alerts[ak.Name()]
+		if !present {
+			slog.Errorln("sched: alert no longer present, ignoring:", ak)
+			s.markDirty(ak)
+			continue
+		} else if s.Conf.Squelched(a, st.Group) {
+			slog.Infoln("sched: alert now squelched:", ak)
+			s.markDirty(ak)
+			continue
+		} else {
+			t := a.Unknown
+			if t == 0 {
+				t = s.Conf.CheckFrequency
+			}
+			if t == 0 && st.Last().Status == StUnknown {
+				st.Append(&Event{Status: StNormal, IncidentId: st.Last().IncidentId})
+			}
+		}
+		clear(st.Result)
+		newHistory := []Event{}
+		for _, e := range st.History {
+			clear(e.Warn)
+			clear(e.Crit)
+			// Remove error events which no longer are a thing.
+			if e.Status <= StUnknown {
+				newHistory = append(newHistory, e)
+			}
+		}
+		st.History = newHistory
+		s.status[ak] = st
+		if a.Log && st.Open {
+			st.Open = false
+			slog.Infof("sched: alert %s is now log, closing, was %s", ak, st.Status())
+		}
+	}
+	if s.maxIncidentId == 0 {
+		s.createHistoricIncidents()
+	}
+}
+
+// SaveTempConfig saves the provided config text in the database for later
+// access. It returns a hash of the text to be used as a retrieval key.
+func (s *Schedule) SaveTempConfig(text string) (hash string, err error) {
+	sig := md5.Sum([]byte(text))
+	b64 := base64.StdEncoding.EncodeToString(sig[0:5])
+	if err := s.DataAccess.Configs().PutTempConfig(b64, text); err != nil {
+		return "", err
+	}
+	return b64, nil
+}
+
+// LoadTempConfig retrieves the config text saved under hash.
+func (s *Schedule) LoadTempConfig(hash string) (text string, err error) {
+	text, err = s.DataAccess.Configs().GetTempConfig(hash)
+	if err != nil {
+		return "", err
+	}
+	if text == "" {
+		return "", fmt.Errorf("Config text '%s' not found", hash)
+	}
+	return text, nil
+}
@@ -0,0 +1,391 @@
+package sched
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.probe.tcp.up", metadata.Gauge, metadata.Bool,
+		"1=The probe connected to its target. 0=It did not connect before its timeout.")
+	metadata.AddMetricMeta("bosun.probe.tcp.connect", metadata.Gauge, metadata.MilliSecond,
+		"The number of milliseconds it took to connect to the probe's target.")
+	metadata.AddMetricMeta("bosun.probe.tls.up", metadata.Gauge, metadata.Bool,
+		"1=The probe completed a TLS handshake with its target. 0=It did not.")
+	metadata.AddMetricMeta("bosun.probe.tls.valid", metadata.Gauge, metadata.Bool,
+		"1=The target's certificate is trusted and matches its host name. 0=It is not.")
+	metadata.AddMetricMeta("bosun.probe.tls.expiry", metadata.Gauge, metadata.Second,
+		"The number of seconds until the target's certificate expires. Negative once it has expired.")
+	metadata.AddMetricMeta("bosun.probe.tls.expiry_days", metadata.Gauge, metadata.Day,
+		"The number of days until the target's certificate expires. Negative once it has expired.")
+	metadata.AddMetricMeta("bosun.probe.http.up", metadata.Gauge, metadata.Bool,
+		"1=The probe's request got a response with a status below 400. 0=It did not.")
+	metadata.AddMetricMeta("bosun.probe.http.status", metadata.Gauge, metadata.StatusCode,
+		"The HTTP status code of the response to the probe's request, or 0 if there was none.")
+	metadata.AddMetricMeta("bosun.probe.http.latency", metadata.Gauge, metadata.MilliSecond,
+		"The number of milliseconds it took to receive the response to the probe's request.")
+	metadata.AddMetricMeta("bosun.probe.dns.up", metadata.Gauge, metadata.Bool,
+		"1=The probe's name resolved to at least one address. 0=It did not.")
+	metadata.AddMetricMeta("bosun.probe.dns.latency", metadata.Gauge, metadata.MilliSecond,
+		"The number of milliseconds it took to resolve the probe's name.")
+	metadata.AddMetricMeta("bosun.probe.dns.nxdomain", metadata.Gauge, metadata.Bool,
+		"1=The name server answered that the probe's name does not exist. 0=It did not.")
+	metadata.AddMetricMeta("bosun.probe.dns.mismatch", metadata.Gauge, metadata.Bool,
+		"1=The probe's name resolved to addresses other than those expected. 0=It resolved to the expected addresses.")
+}
+
+// everyAsLeader calls f every d while s is the leader.
+func (s *Schedule) everyAsLeader(d time.Duration, f func()) {
+	for range time.Tick(d) {
+		if s.IsLeader() {
+			f()
+		}
+	}
+}
+
+// RunProbes runs each probe in the configuration on its interval.
+func (s *Schedule) RunProbes() {
+	for _, p := range s.Conf.Probes {
+		go func(p *conf.Probe) {
+			s.everyAsLeader(p.Interval, func() {
+				s.probe(p, time.Now())
+			})
+		}(p)
+	}
+}
+
+// probe runs p once and records its results. The certificate found by a tls
+// probe is stored as metadata of the probe, for the certificate inventory.
+func (s *Schedule) probe(p *conf.Probe, now time.Time) {
+	if p.Type == conf.ProbeTLS {
+		r, cert := probeTLS(p, now)
+		putProbe(p, nil, r)
+		if cert != nil {
+			if err := s.putCertificate(p, cert, now); err != nil {
+				slog.Errorf("probe %s: %v", p.Name, err)
+			}
+		}
+		return
+	}
+	checkProbe(p, now, func(tags opentsdb.TagSet, r probeResult) {
+		putProbe(p, tags, r)
+	})
+}
+
+// probeResult holds the values of a probe's metrics, by the metric name
+// under bosun.probe.<type>.
+type probeResult map[string]interface{}
+
+func putProbe(p *conf.Probe, extra opentsdb.TagSet, r probeResult) {
+	tags := p.Tags.Copy().Merge(extra)
+	tags["probe"] = p.Name
+	for name, v := range r {
+		if err := collect.Put("probe."+p.Type+"."+name, tags, v); err != nil {
+			slog.Errorf("probe %s: %v", p.Name, err)
+		}
+	}
+}
+
+// checkProbe runs p, calling put with the result of each check and the tags
+// that tell it apart: dns probes check each of their servers, tagged with
+// server.
+func checkProbe(p *conf.Probe, now time.Time, put func(opentsdb.TagSet, probeResult)) {
+	if p.Type != conf.ProbeDNS || len(p.Servers) == 0 {
+		put(nil, runProbe(p, now))
+		return
+	}
+	for _, server := range p.Servers {
+		host, _, _ := net.SplitHostPort(server)
+		tag, err := opentsdb.Clean(host)
+		if err != nil {
+			tag = "unknown"
+		}
+		put(opentsdb.TagSet{"server": tag}, probeDNS(p, server))
+	}
+}
+
+// runProbe checks the target of p and returns the results. dns probes use
+// the system resolver.
+func runProbe(p *conf.Probe, now time.Time) probeResult {
+	switch p.Type {
+	case conf.ProbeTCP:
+		return probeTCP(p)
+	case conf.ProbeTLS:
+		r, _ := probeTLS(p, now)
+		return r
+	case conf.ProbeHTTP:
+		return probeHTTP(p)
+	case conf.ProbeDNS:
+		return probeDNS(p, "")
+	}
+	return nil
+}
+
+func probeTCP(p *conf.Probe) probeResult {
+	start := time.Now()
+	c, err := net.DialTimeout("tcp", p.Target, p.Timeout)
+	if err != nil {
+		return probeResult{"up": 0}
+	}
+	c.Close()
+	return probeResult{
+		"up":      1,
+		"connect": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+}
+
+// probeTLS reports the expiry of the target's certificate whether or not it
+// is trusted, so certificates can be replaced before they expire. It returns
+// the certificate, if any.
+func probeTLS(p *conf.Probe, now time.Time) (probeResult, *x509.Certificate) {
+	host, _, _ := net.SplitHostPort(p.Target)
+	d := &net.Dialer{Timeout: p.Timeout}
+	c, err := tls.DialWithDialer(d, "tcp", p.Target, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return probeResult{"up": 0}, nil
+	}
+	defer c.Close()
+	certs := c.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return probeResult{"up": 0}, nil
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   now,
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	valid := 0
+	if _, err := certs[0].Verify(opts); err == nil {
+		valid = 1
+	}
+	return probeResult{
+		"up":          1,
+		"valid":       valid,
+		"expiry":      int64(certs[0].NotAfter.Sub(now) / time.Second),
+		"expiry_days": certDays(certs[0], now),
+	}, certs[0]
+}
+
+func probeHTTP(p *conf.Probe) probeResult {
+	client := &http.Client{Timeout: p.Timeout}
+	start := time.Now()
+	resp, err := client.Get(p.Target)
+	if err != nil {
+		return probeResult{"up": 0, "status": 0}
+	}
+	resp.Body.Close()
+	up := 0
+	if resp.StatusCode < 400 {
+		up = 1
+	}
+	return probeResult{
+		"up":      up,
+		"status":  resp.StatusCode,
+		"latency": float64(time.Since(start)) / float64(time.Millisecond),
+	}
+}
+
+// probeDNS resolves the target of p using server, or the system resolver if
+// server is empty. The target is resolved as a fully qualified name, without
+// the resolver's search domains.
+func probeDNS(p *conf.Probe, server string) probeResult {
+	name := p.Target
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	start := time.Now()
+	var addrs []string
+	var nxdomain bool
+	var err error
+	if server == "" {
+		addrs, nxdomain, err = lookupHost(name, p.Timeout)
+	} else {
+		addrs, nxdomain, err = queryHost(server, name, p.Timeout)
+	}
+	latency := float64(time.Since(start)) / float64(time.Millisecond)
+	if nxdomain {
+		return probeResult{"up": 0, "nxdomain": 1}
+	}
+	if err != nil || len(addrs) == 0 {
+		return probeResult{"up": 0, "nxdomain": 0}
+	}
+	res := probeResult{
+		"up":       1,
+		"nxdomain": 0,
+		"latency":  latency,
+	}
+	if len(p.Expect) > 0 {
+		res["mismatch"] = 0
+		if !sameAddrs(addrs, p.Expect) {
+			res["mismatch"] = 1
+		}
+	}
+	return res
+}
+
+// lookupHost resolves name with the system resolver, giving up after
+// timeout.
+func lookupHost(name string, timeout time.Duration) (addrs []string, nxdomain bool, err error) {
+	type result struct {
+		addrs []string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		addrs, err := net.LookupHost(name)
+		done <- result{addrs, err}
+	}()
+	select {
+	case r := <-done:
+		if e, ok := r.err.(*net.DNSError); ok && e.Err == "no such host" {
+			return nil, true, r.err
+		}
+		return r.addrs, false, r.err
+	case <-time.After(timeout):
+		return nil, false, fmt.Errorf("dns: lookup of %s timed out", name)
+	}
+}
+
+// queryHost resolves name with A and AAAA queries to the name server at
+// server over UDP.
+func queryHost(server, name string, timeout time.Duration) (addrs []string, nxdomain bool, err error) {
+	c, err := net.DialTimeout("udp", server, timeout)
+	if err != nil {
+		return nil, false, err
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(timeout))
+	for _, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		a, nx, err := dnsQuery(c, name, qtype)
+		if err != nil {
+			return nil, false, err
+		}
+		if nx {
+			return nil, true, nil
+		}
+		addrs = append(addrs, a...)
+	}
+	return addrs, false, nil
+}
+
+const (
+	dnsTypeA    = 1
+	dnsTypeAAAA = 28
+)
+
+// dnsQuery sends a query for the qtype records of name on c and returns the
+// addresses in the answer, or whether the server answered NXDOMAIN.
+func dnsQuery(c net.Conn, name string, qtype uint16) (addrs []string, nxdomain bool, err error) {
+	id := uint16(rand.Intn(1 << 16))
+	// Header: id, recursion desired, one question.
+	q := []byte{byte(id >> 8), byte(id), 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, false, fmt.Errorf("dns: bad name %s", name)
+		}
+		q = append(q, byte(len(label)))
+		q = append(q, label...)
+	}
+	q = append(q, 0, byte(qtype>>8), byte(qtype), 0, 1)
+	if _, err := c.Write(q); err != nil {
+		return nil, false, err
+	}
+	buf := make([]byte, 1500)
+	var m []byte
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return nil, false, err
+		}
+		m = buf[:n]
+		if n >= 12 && binary.BigEndian.Uint16(m) == id && m[2]&0x80 != 0 {
+			break
+		}
+	}
+	switch m[3] & 0xf {
+	case 0:
+	case 3:
+		return nil, true, nil
+	default:
+		return nil, false, fmt.Errorf("dns: server returned rcode %d", m[3]&0xf)
+	}
+	qdcount := int(binary.BigEndian.Uint16(m[4:]))
+	ancount := int(binary.BigEndian.Uint16(m[6:]))
+	i := 12
+	for ; qdcount > 0; qdcount-- {
+		if i, err = dnsSkipName(m, i); err != nil {
+			return nil, false, err
+		}
+		i += 4
+	}
+	for ; ancount > 0; ancount-- {
+		if i, err = dnsSkipName(m, i); err != nil {
+			return nil, false, err
+		}
+		if i+10 > len(m) {
+			return nil, false, fmt.Errorf("dns: short answer")
+		}
+		typ := binary.BigEndian.Uint16(m[i:])
+		rdlen := int(binary.BigEndian.Uint16(m[i+8:]))
+		i += 10
+		if i+rdlen > len(m) {
+			return nil, false, fmt.Errorf("dns: short answer")
+		}
+		if typ == qtype && (rdlen == net.IPv4len || rdlen == net.IPv6len) {
+			addrs = append(addrs, net.IP(m[i:i+rdlen]).String())
+		}
+		i += rdlen
+	}
+	return addrs, false, nil
+}
+
+// dnsSkipName returns the offset after the name at offset i of message m.
+func dnsSkipName(m []byte, i int) (int, error) {
+	for i < len(m) {
+		switch l := int(m[i]); {
+		case l == 0:
+			return i + 1, nil
+		case l&0xc0 == 0xc0:
+			// A pointer to a name elsewhere ends this one.
+			return i + 2, nil
+		default:
+			i += 1 + l
+		}
+	}
+	return 0, fmt.Errorf("dns: bad name in message")
+}
+
+// sameAddrs returns whether a and b are the same set of IP addresses.
+func sameAddrs(a, b []string) bool {
+	set := func(addrs []string) map[string]bool {
+		m := make(map[string]bool)
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil {
+				addr = ip.String()
+			}
+			m[addr] = true
+		}
+		return m
+	}
+	return reflect.DeepEqual(set(a), set(b))
+}
@@ -0,0 +1,195 @@
+package sched
+
+import (
+	"encoding/binary"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/opentsdb"
+)
+
+func TestProbes(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+	// A closed listener's address refuses connections.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.Addr().String()
+	l.Close()
+	u, err := url.Parse(secure.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expiry := int64(secure.Certificate().NotAfter.Sub(now) / time.Second)
+	for _, test := range []struct {
+		typ, target string
+		expected    probeResult
+	}{
+		{conf.ProbeTCP, ok.Listener.Addr().String(), probeResult{"up": 1}},
+		{conf.ProbeTCP, closed, probeResult{"up": 0}},
+		{conf.ProbeHTTP, ok.URL, probeResult{"up": 1, "status": 200}},
+		{conf.ProbeHTTP, missing.URL, probeResult{"up": 0, "status": 404}},
+		{conf.ProbeHTTP, "http://" + closed, probeResult{"up": 0, "status": 0}},
+		// The test server's certificate is not trusted.
+		{conf.ProbeTLS, u.Host, probeResult{"up": 1, "valid": 0, "expiry": expiry}},
+		{conf.ProbeTLS, closed, probeResult{"up": 0}},
+	} {
+		p := &conf.Probe{Name: "p", Type: test.typ, Target: test.target, Timeout: time.Second}
+		r := runProbe(p, now)
+		for name, v := range test.expected {
+			if r[name] != v {
+				t.Errorf("%s %s: got %s %v, expected %v", test.typ, test.target, name, r[name], v)
+			}
+		}
+	}
+}
+
+// dnsServer answers A queries for the names in hosts, NXDOMAIN for other
+// names, and AAAA queries with no records. It stops when its connection is
+// closed.
+func dnsServer(t *testing.T, hosts map[string]net.IP) net.PacketConn {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			q := buf[:n]
+			// The question follows the 12 byte header: the labels of the
+			// name, then its type and class.
+			i := 12
+			var labels []string
+			for i < len(q) && q[i] != 0 {
+				labels = append(labels, string(q[i+1:i+1+int(q[i])]))
+				i += 1 + int(q[i])
+			}
+			question := q[12 : i+5]
+			qtype := binary.BigEndian.Uint16(q[i+1:])
+			ip, ok := hosts[strings.Join(labels, ".")]
+			resp := append([]byte(nil), q[:2]...)
+			flags := uint16(0x8180) // response, recursion desired and available
+			if !ok {
+				flags |= 3 // NXDOMAIN
+			}
+			answers := uint16(0)
+			if ok && qtype == 1 {
+				answers = 1
+			}
+			resp = append(resp, byte(flags>>8), byte(flags), 0, 1, 0, byte(answers), 0, 0, 0, 0)
+			resp = append(resp, question...)
+			if answers > 0 {
+				// Name pointer to the question, type A, class IN, ttl 60.
+				resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4)
+				resp = append(resp, ip.To4()...)
+			}
+			c.WriteTo(resp, addr)
+		}
+	}()
+	return c
+}
+
+func TestProbeDNS(t *testing.T) {
+	c := dnsServer(t, map[string]net.IP{"db.example.com": net.ParseIP("10.0.0.1")})
+	defer c.Close()
+	server := c.LocalAddr().String()
+	for _, test := range []struct {
+		target   string
+		expect   []string
+		expected probeResult
+	}{
+		{"db.example.com", nil, probeResult{"up": 1, "nxdomain": 0}},
+		{"db.example.com", []string{"10.0.0.1"}, probeResult{"up": 1, "mismatch": 0}},
+		{"db.example.com", []string{"10.0.0.2"}, probeResult{"up": 1, "mismatch": 1}},
+		{"missing.example.com", nil, probeResult{"up": 0, "nxdomain": 1}},
+	} {
+		p := &conf.Probe{
+			Name:    "p",
+			Type:    conf.ProbeDNS,
+			Target:  test.target,
+			Timeout: time.Second,
+			Servers: []string{server},
+			Expect:  test.expect,
+		}
+		var results []probeResult
+		checkProbe(p, time.Now(), func(tags opentsdb.TagSet, r probeResult) {
+			if tags["server"] != "127.0.0.1" {
+				t.Errorf("%s: expected server tag 127.0.0.1, got %v", test.target, tags)
+			}
+			results = append(results, r)
+		})
+		if len(results) != 1 {
+			t.Fatalf("%s: expected one result, got %v", test.target, results)
+		}
+		for name, v := range test.expected {
+			if results[0][name] != v {
+				t.Errorf("%s %v: got %s %v, expected %v", test.target, test.expect, name, results[0][name], v)
+			}
+		}
+	}
+}
+
+func TestProbeCertificates(t *testing.T) {
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+	u, err := url.Parse(secure.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := conf.New("", `
+		probe web {
+			type = tls
+			target = `+u.Host+`
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	s.probe(c.Probes["web"], now)
+	// The certificate is unchanged, but must still be recorded as checked.
+	now = now.Add(time.Hour)
+	s.probe(c.Probes["web"], now)
+	certs, err := s.Certificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("expected one certificate, got %v", certs)
+	}
+	cert := certs[0]
+	expected := secure.Certificate()
+	if cert.Probe != "web" || !cert.NotAfter.Equal(expected.NotAfter) || cert.Issuer != expected.Issuer.String() {
+		t.Errorf("got %+v, expected certificate of %s", cert, secure.URL)
+	}
+	if !cert.Checked.Equal(now) {
+		t.Errorf("got checked %v, expected %v", cert.Checked, now)
+	}
+	if cert.DaysLeft <= 0 {
+		t.Errorf("expected certificate not to have expired, got %v days left", cert.DaysLeft)
+	}
+	if len(cert.SANs) == 0 || cert.SANs[0] != "example.com" {
+		t.Errorf("expected SAN example.com, got %v", cert.SANs)
+	}
+}
@@ -0,0 +1,109 @@
+package sched
+
+import (
+	"html/template"
+	"sync"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.check.expr_duration", metadata.Gauge, metadata.Second,
+		"The number of seconds the expressions of each alert took in its last check, in total and by datasource queried.")
+}
+
+// exprProfiler records the steps of an alert's expressions as they are
+// checked.
+type exprProfiler struct {
+	sync.Mutex
+	root stepTimer
+	// queries is the time spent querying each type of datasource.
+	queries map[string]time.Duration
+}
+
+func newExprProfiler() *exprProfiler {
+	p := &exprProfiler{queries: make(map[string]time.Duration)}
+	p.root = stepTimer{p: p, step: new(models.ExprStep)}
+	return p
+}
+
+// stepTimer is an expr.StepTimer that records steps as children of step.
+type stepTimer struct {
+	p    *exprProfiler
+	step *models.ExprStep
+}
+
+func (t *stepTimer) add(s *models.ExprStep) {
+	t.p.Lock()
+	t.step.Steps = append(t.step.Steps, s)
+	t.p.Unlock()
+}
+
+func (t *stepTimer) Step(name string, f func(miniprofiler.Timer)) {
+	s := &models.ExprStep{Name: name}
+	start := time.Now()
+	f(&stepTimer{p: t.p, step: s})
+	s.Duration = time.Since(start)
+	t.add(s)
+}
+
+func (t *stepTimer) StepCustomTiming(callType, executeType, command string, f func()) {
+	start := time.Now()
+	f()
+	t.AddCustomTiming(callType, executeType, start, time.Now(), command)
+}
+
+func (t *stepTimer) AddCustomTiming(callType, executeType string, start, end time.Time, command string) {
+	d := end.Sub(start)
+	t.add(&models.ExprStep{Name: callType + " " + executeType, Command: command, Duration: d})
+	t.p.Lock()
+	t.p.queries[callType] += d
+	t.p.Unlock()
+}
+
+func (t *stepTimer) AddCustomLink(name, URL string) {}
+func (t *stepTimer) SetName(string)                 {}
+func (t *stepTimer) Includes() template.HTML        { return "" }
+func (t *stepTimer) StepsOnly()                     {}
+
+// exprName is the name of a's expression e in its profile.
+func exprName(a *conf.Alert, e *expr.Expr) string {
+	switch e {
+	case a.Depends:
+		return "depends"
+	case a.Crit:
+		return "crit"
+	case a.Warn:
+		return "warn"
+	case a.RenotifyValue:
+		return "renotifyValue"
+	}
+	return "expr"
+}
+
+// saveProfile stores the profile of a's check at t and sends its totals.
+func (s *Schedule) saveProfile(a *conf.Alert, t time.Time, p *exprProfiler) {
+	prof := &models.ExprProfile{
+		Alert: a.Name,
+		Time:  t,
+		Steps: p.root.step.Steps,
+	}
+	for _, step := range prof.Steps {
+		prof.Duration += step.Duration
+	}
+	collect.Put("check.expr_duration", opentsdb.TagSet{"name": a.Name, "type": "total"}, prof.Duration.Seconds())
+	for typ, d := range p.queries {
+		collect.Put("check.expr_duration", opentsdb.TagSet{"name": a.Name, "type": typ}, d.Seconds())
+	}
+	if err := s.DataAccess.Profiles().SetAlertProfile(a.Name, prof); err != nil {
+		slog.Errorf("saving profile of alert %s: %v", a.Name, err)
+	}
+}
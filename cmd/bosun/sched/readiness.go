@@ -0,0 +1,151 @@
+package sched
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/slog"
+)
+
+const (
+	// readinessInterval is the time between probes of datasources that are
+	// not ready.
+	readinessInterval = 5 * time.Second
+	// readinessProbeTimeout is how long each probe may take.
+	readinessProbeTimeout = 10 * time.Second
+)
+
+// gateReadiness closes s.ready once the datasources pass their probes or
+// readinessTimeout elapses. Alert states restored from the database are
+// marked unevaluated until then, so a restart while a datasource is down
+// doesn't turn them all unknown.
+func (s *Schedule) gateReadiness() {
+	defer close(s.ready)
+	if s.Conf.ReadinessTimeout <= 0 {
+		return
+	}
+	probes := datasourceProbes(s.Conf)
+	if len(probes) == 0 {
+		return
+	}
+	s.markUnevaluated()
+	if waiting := s.probeDatasources(probes, s.Conf.ReadinessTimeout, readinessInterval); len(waiting) > 0 {
+		slog.Warningf("readinessTimeout elapsed, starting checks with datasources not ready: %s", strings.Join(waiting, ", "))
+	} else {
+		slog.Infoln("datasources ready, starting checks")
+	}
+	s.LastCheck = time.Now()
+}
+
+// probeDatasources probes each datasource every interval until all have
+// passed once or timeout elapses. It returns those that did not pass.
+func (s *Schedule) probeDatasources(probes map[string]func() error, timeout, interval time.Duration) []string {
+	deadline := time.Now().Add(timeout)
+	for {
+		waiting := []string{}
+		for name, probe := range probes {
+			if err := probe(); err != nil {
+				slog.Warningf("datasource %s not ready: %v", name, err)
+				waiting = append(waiting, name)
+				continue
+			}
+			delete(probes, name)
+		}
+		sort.Strings(waiting)
+		s.readyLock.Lock()
+		s.notReady = waiting
+		s.readyLock.Unlock()
+		if len(waiting) == 0 || time.Now().Add(interval).After(deadline) {
+			return waiting
+		}
+		time.Sleep(interval)
+	}
+}
+
+// markUnevaluated marks the states of all alerts unevaluated. Their next
+// check clears it.
+func (s *Schedule) markUnevaluated() {
+	s.Lock("MarkUnevaluated")
+	defer s.Unlock()
+	for ak, st := range s.status {
+		if s.Conf.Alerts[ak.Name()] != nil {
+			st.Unevaluated = true
+		}
+	}
+}
+
+// Ready returns whether checks have started, and the datasources that have
+// not passed their readiness probes.
+func (s *Schedule) Ready() (bool, []string) {
+	s.readyLock.Lock()
+	notReady := s.notReady
+	s.readyLock.Unlock()
+	if s.ready == nil {
+		return true, notReady
+	}
+	select {
+	case <-s.ready:
+		return true, notReady
+	default:
+		return false, notReady
+	}
+}
+
+// datasourceProbes returns a health probe of each configured datasource, by
+// name.
+func datasourceProbes(c *conf.Conf) map[string]func() error {
+	probes := make(map[string]func() error)
+	if c.TSDBHost != "" {
+		probes["opentsdb"] = httpProbe("http://"+c.TSDBHost+"/api/version", nil)
+	}
+	if c.GraphiteHost != "" {
+		header := make(http.Header)
+		for _, h := range c.GraphiteHeaders {
+			kv := strings.SplitN(h, ":", 2)
+			header.Add(kv[0], kv[1])
+		}
+		probes["graphite"] = httpProbe("http://"+c.GraphiteHost+"/metrics/find?query=*", header)
+	}
+	if c.InfluxConfig.URL.Host != "" {
+		u := c.InfluxConfig.URL
+		u.Path = "/ping"
+		probes["influx"] = httpProbe(u.String(), nil)
+	}
+	for name, cluster := range c.ElasticHosts {
+		if len(cluster.Hosts) > 0 {
+			probes["elastic:"+name] = httpProbe(cluster.Hosts[0], nil)
+		}
+	}
+	for name, db := range c.SQLDatabases {
+		probes["sql:"+name] = db.Ping
+	}
+	return probes
+}
+
+var probeClient = &http.Client{Timeout: readinessProbeTimeout}
+
+// httpProbe returns a probe that passes if a GET of u returns a 2xx status.
+func httpProbe(u string, header http.Header) func() error {
+	return func() error {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := probeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("bad response: %s", resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,66 @@
+package sched
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestReadiness(t *testing.T) {
+	ak := expr.NewAlertKey("a", opentsdb.TagSet{"host": "h"})
+	var s *Schedule
+	probes := 0
+	unevaluated := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes++
+		if r.URL.Path != "/api/version" {
+			t.Errorf("unexpected probe of %s", r.URL.Path)
+		}
+		unevaluated = s.GetStatus(ak).Unevaluated
+		if probes < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		tsdbHost = %s
+		readinessTimeout = 1m
+		alert a {
+			crit = 1
+		}
+	`, strings.TrimPrefix(ts.URL, "http://")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.status[ak] = &State{Group: ak.Group(), History: []Event{{Status: StCritical}}}
+	s.ready = make(chan struct{})
+	s.markUnevaluated()
+	if ready, _ := s.Ready(); ready {
+		t.Fatal("expected schedule not ready")
+	}
+	waiting := s.probeDatasources(datasourceProbes(c), time.Minute, time.Millisecond)
+	if len(waiting) != 0 || probes != 3 || !unevaluated {
+		t.Errorf("got waiting %v after %d probes, unevaluated %v", waiting, probes, unevaluated)
+	}
+	close(s.ready)
+	if ready, notReady := s.Ready(); !ready || len(notReady) != 0 {
+		t.Errorf("expected schedule ready, got %v", notReady)
+	}
+
+	ts.Close()
+	waiting = s.probeDatasources(datasourceProbes(c), 5*time.Millisecond, time.Millisecond)
+	if len(waiting) != 1 || waiting[0] != "opentsdb" {
+		t.Errorf("expected opentsdb not ready, got %v", waiting)
+	}
+}
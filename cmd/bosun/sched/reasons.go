@@ -0,0 +1,53 @@
+package sched
+
+import (
+	"time"
+)
+
+// ReasonCounts counts the close and forget actions on an alert by reason code.
+type ReasonCounts struct {
+	Actions int
+	Reasons map[string]int
+}
+
+func (r *ReasonCounts) add(reason string) {
+	if reason == "" {
+		reason = "none"
+	}
+	r.Actions++
+	r.Reasons[reason]++
+}
+
+// ReasonReport aggregates the reason codes given when closing and forgetting
+// alerts, so alert quality can be measured by how often alerts are closed as,
+// for example, false positives.
+type ReasonReport struct {
+	Total  *ReasonCounts
+	Alerts map[string]*ReasonCounts
+}
+
+func newReasonCounts() *ReasonCounts {
+	return &ReasonCounts{Reasons: make(map[string]int)}
+}
+
+// ReasonReport returns a report of close and forget actions taken between from
+// and to, optionally limited to a single alert. Actions are kept in the
+// database, so forgotten alert keys are included.
+func (s *Schedule) ReasonReport(alert string, from, to time.Time) (*ReasonReport, error) {
+	actions, err := s.DataAccess.Reasons().GetReasonActions(alert, from, to)
+	if err != nil {
+		return nil, err
+	}
+	r := &ReasonReport{
+		Total:  newReasonCounts(),
+		Alerts: make(map[string]*ReasonCounts),
+	}
+	for _, a := range actions {
+		if r.Alerts[a.Alert] == nil {
+			r.Alerts[a.Alert] = newReasonCounts()
+		}
+		r.Alerts[a.Alert].add(a.Reason)
+		r.Total.add(a.Reason)
+	}
+	return r, nil
+}
@@ -0,0 +1,135 @@
+package sched
+
+import (
+	"fmt"
+	"sync"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+// renderWorkers is the most notifications rendered at once.
+const renderWorkers = 10
+
+var renderSem = make(chan bool, renderWorkers)
+
+// rendering is what the notifications of an alert key's incident are
+// rendered from: a snapshot of its state as of the check that last found it
+// abnormal. Checks only render the subject shown on the dashboard; messages
+// are rendered the first time a notification or the UI needs them, outside
+// the schedule lock, and kept by template until the next check replaces the
+// rendering.
+type rendering struct {
+	state *State
+	alert *conf.Alert
+	rh    *RunHistory
+
+	sync.Mutex
+	// messages are the rendered messages by the name of the notification
+	// whose template they use, or "" for the alert's template.
+	messages map[string]*message
+}
+
+// newRendering snapshots state, as checked by r, for rendering later. The
+// check's query cache is not kept, so templates that query run their own.
+func newRendering(state *State, a *conf.Alert, r *RunHistory) *rendering {
+	rh := *r
+	rh.Cache = nil
+	rh.queries = nil
+	rh.opened = nil
+	rh.overflow = nil
+	snapshot := state.Copy()
+	snapshot.rendering = nil
+	return &rendering{
+		state: snapshot,
+		alert: a,
+		rh:    &rh,
+	}
+}
+
+// message returns the message for notification n, rendering it if needed.
+// If n has no template of its own, the alert's template is used.
+func (r *rendering) message(s *Schedule, n *conf.Notification) *message {
+	name, a := "", r.alert
+	if n != nil && n.Template != nil {
+		name, a = n.Name, stepAlert(r.alert, n)
+	}
+	r.Lock()
+	defer r.Unlock()
+	if m, ok := r.messages[name]; ok {
+		return m
+	}
+	m := s.renderTemplates(r.state, a, r.rh)
+	if r.messages == nil {
+		r.messages = make(map[string]*message)
+	}
+	r.messages[name] = m
+	return m
+}
+
+// stepAlert returns a copy of a using the subject and body templates of n
+// where it has them.
+func stepAlert(a *conf.Alert, n *conf.Notification) *conf.Alert {
+	t := &conf.Template{Name: a.Name}
+	if a.Template != nil {
+		*t = *a.Template
+	}
+	if n.Template.Subject != nil {
+		t.Subject = n.Template.Subject
+	}
+	if n.Template.Body != nil {
+		t.Body = n.Template.Body
+	}
+	na := *a
+	na.Template = t
+	return &na
+}
+
+// renderSubject renders the dashboard subject of state.
+func (s *Schedule) renderSubject(state *State, a *conf.Alert, r *RunHistory) string {
+	endTiming := collect.StartTimer("template.render", opentsdb.TagSet{"alert": a.Name, "type": "subject"})
+	defer endTiming()
+	subject, err := s.ExecuteSubject(r, a, state, false)
+	if err != nil {
+		slog.Infof("%s: %v", state.AlertKey(), err)
+		return fmt.Sprintf("error: template rendering error in the subject for alert %v", state.AlertKey())
+	}
+	return string(subject)
+}
+
+// restoreRendering gives st a rendering as of its last event if it has
+// neither a rendering nor a stored message, as is the case for abnormal
+// states restored from before a restart. s must be locked.
+func (s *Schedule) restoreRendering(st *State) {
+	if st.rendering != nil || st.Body != "" || st.EmailBody != nil {
+		return
+	}
+	a := s.Conf.Alerts[st.Alert]
+	last := st.Last()
+	if a == nil || last.Status <= StNormal || last.Status == StUnknown {
+		return
+	}
+	st.rendering = newRendering(st, a, s.NewRunHistory(last.Time, nil))
+}
+
+// stateMessage returns the message of st for notification n, or for the
+// UI if n is nil. States without a rendering, like those restored from
+// before a restart or of maxKeys alert keys, use the message stored in them.
+func (s *Schedule) stateMessage(st *State, n *conf.Notification) *message {
+	if st.rendering == nil {
+		return &message{st.Subject, st.Body, st.EmailSubject, st.EmailBody, st.Attachments}
+	}
+	return st.rendering.message(s, n)
+}
+
+// RenderBody sets the Body, EmailSubject, and EmailBody of st, a copy of an
+// alert key's state, rendering them if no notification has yet.
+func (s *Schedule) RenderBody(st *State) {
+	m := s.stateMessage(st, nil)
+	st.Body = m.Body
+	st.EmailSubject = m.EmailSubject
+	st.EmailBody = m.EmailBody
+	st.Attachments = m.Attachments
+}
@@ -0,0 +1,57 @@
+package sched
+
+import (
+	"fmt"
+	"math"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+// setRenotifyValues records the result of a's renotifyValue expression on
+// the events for a in r.
+func (s *Schedule) setRenotifyValues(T miniprofiler.Timer, r *RunHistory, a *conf.Alert) error {
+	results, err := s.executeExpr(T, r, a, a.RenotifyValue)
+	if err != nil {
+		return err
+	}
+	for _, res := range results.Results {
+		event := r.Events[expr.NewAlertKey(a.Name, res.Group)]
+		if event == nil {
+			continue
+		}
+		var v float64
+		switch n := res.Value.(type) {
+		case expr.Number:
+			v = float64(n)
+		case expr.Scalar:
+			v = float64(n)
+		default:
+			return fmt.Errorf("renotifyValue: expected number or scalar")
+		}
+		event.Value = &v
+	}
+	return nil
+}
+
+// worsened returns false if st's alert only renotifies when its value
+// worsens, and the value has not worsened by more than the alert's
+// renotifyWorsening percentage since st was last notified.
+func (s *Schedule) worsened(st *State) bool {
+	a := s.Conf.Alerts[st.Alert]
+	if a == nil || a.RenotifyWorsening == 0 {
+		return true
+	}
+	v := st.Last().Value
+	if v == nil || st.NotifiedValue == nil {
+		return true
+	}
+	last := *st.NotifiedValue
+	// A change from 0 is infinite, or NaN if the value is still 0.
+	change := (*v - last) / math.Abs(last) * 100
+	if a.RenotifyWorsening > 0 {
+		return change > a.RenotifyWorsening
+	}
+	return change < a.RenotifyWorsening
+}
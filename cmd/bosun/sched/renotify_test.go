@@ -0,0 +1,61 @@
+package sched
+
+import (
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+func TestRenotifyWorsening(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = s
+		}
+		notification n {
+			print = true
+			next = n
+			timeout = 1h
+		}
+		alert a {
+			template = t
+			crit = 1
+			critNotification = n
+			renotifyValue = 100
+			renotifyWorsening = 10%
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := expr.NewAlertKey("a", nil)
+	st := NewStatus(ak)
+	s.status[ak] = st
+	notified := 100.0
+	st.NotifiedValue = &notified
+	notifications := s.DataAccess.(*nopDataAccess).notifications
+	renotify := func(value float64) time.Duration {
+		st.History = []Event{{Status: StCritical, Value: &value}}
+		now := time.Now()
+		s.AddNotification(ak, c.Notifications["n"], now.Add(-2*time.Hour))
+		s.CheckNotifications()
+		return notifications[string(ak)]["n"].Sub(now)
+	}
+	if d := renotify(105); d > c.CheckFrequency+time.Second {
+		t.Fatalf("expected notification to wait for a worse value, due in %v", d)
+	}
+	if *st.NotifiedValue != 100 {
+		t.Fatalf("expected notified value to be unchanged, got %v", *st.NotifiedValue)
+	}
+	if d := renotify(115); d < 59*time.Minute {
+		t.Fatalf("expected notification to be sent and chained, due in %v", d)
+	}
+	if *st.NotifiedValue != 115 {
+		t.Fatalf("expected notified value 115, got %v", *st.NotifiedValue)
+	}
+}
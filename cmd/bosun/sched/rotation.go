@@ -0,0 +1,130 @@
+package sched
+
+import (
+	"time"
+
+	"bosun.org/_third_party/github.com/bradfitz/slice"
+	"bosun.org/cmd/bosun/conf"
+)
+
+// unhealthyFailures is the number of consecutive failed delivery attempts
+// after which a notification is unhealthy. Round-robin groups skip unhealthy
+// members while any member is healthy.
+const unhealthyFailures = 3
+
+// NotificationHealth is the delivery record of a notification.
+type NotificationHealth struct {
+	Healthy bool
+	// Failures is the number of delivery attempts that failed since the
+	// last one that succeeded.
+	Failures    int
+	LastSuccess time.Time
+	LastFailure time.Time
+	LastError   string `json:",omitempty"`
+}
+
+// recordDelivery updates the health of the notification named name with the
+// result of a delivery attempt at now.
+func (s *Schedule) recordDelivery(name string, now time.Time, err error) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	if s.health == nil {
+		s.health = make(map[string]*NotificationHealth)
+	}
+	h := s.health[name]
+	if h == nil {
+		h = &NotificationHealth{}
+		s.health[name] = h
+	}
+	if err == nil {
+		h.Failures = 0
+		h.LastSuccess = now
+		h.LastError = ""
+	} else {
+		h.Failures++
+		h.LastFailure = now
+		h.LastError = err.Error()
+	}
+	h.Healthy = h.Failures < unhealthyFailures
+}
+
+// healthy returns whether the notification named name is healthy. s.healthLock
+// must be held.
+func (s *Schedule) healthy(name string) bool {
+	h := s.health[name]
+	return h == nil || h.Healthy
+}
+
+// groupMembers returns the members of the notification group n to send to. A
+// round-robin group sends to one member: that of the incident of st, chosen by
+// incident id so every notification of an incident goes to the same member,
+// or the next in turn if st is nil. An unhealthy member is passed over for
+// the next healthy one.
+func (s *Schedule) groupMembers(n *conf.Notification, st *State) []*conf.Notification {
+	if !n.RoundRobin {
+		return n.Members
+	}
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	var i int
+	if st != nil && st.Last().IncidentId != 0 {
+		i = int(st.Last().IncidentId % uint64(len(n.Members)))
+	} else {
+		if s.rotation == nil {
+			s.rotation = make(map[string]int)
+		}
+		i = s.rotation[n.Name] % len(n.Members)
+		s.rotation[n.Name] = i + 1
+	}
+	for j := range n.Members {
+		m := n.Members[(i+j)%len(n.Members)]
+		if s.healthy(m.Name) {
+			return []*conf.Notification{m}
+		}
+	}
+	return []*conf.Notification{n.Members[i]}
+}
+
+// NotificationGroup is a notification group and the health of its members.
+type NotificationGroup struct {
+	Name    string
+	Mode    string
+	Members []*NotificationMember
+}
+
+// NotificationMember is a member of a notification group.
+type NotificationMember struct {
+	Name string
+	NotificationHealth
+}
+
+// NotificationGroups returns the notification groups by name, with the
+// health of their members. Members that have not been sent to are healthy.
+func (s *Schedule) NotificationGroups() []*NotificationGroup {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	groups := []*NotificationGroup{}
+	for _, n := range s.Conf.Notifications {
+		if len(n.Members) == 0 {
+			continue
+		}
+		g := &NotificationGroup{Name: n.Name, Mode: "fanout"}
+		if n.RoundRobin {
+			g.Mode = "roundrobin"
+		}
+		for _, m := range n.Members {
+			nm := &NotificationMember{Name: m.Name}
+			if h := s.health[m.Name]; h != nil {
+				nm.NotificationHealth = *h
+			} else {
+				nm.Healthy = true
+			}
+			g.Members = append(g.Members, nm)
+		}
+		groups = append(groups, g)
+	}
+	slice.Sort(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
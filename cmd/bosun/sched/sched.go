@@ -7,6 +7,8 @@ import (
 	"net"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -17,10 +19,11 @@ import (
 	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
 	"bosun.org/cmd/bosun/database"
-	"bosun.org/cmd/bosun/expr"
 	"bosun.org/cmd/bosun/search"
 	"bosun.org/collect"
+	"bosun.org/expr"
 	"bosun.org/metadata"
+	"bosun.org/models"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
 )
@@ -41,6 +44,17 @@ type Schedule struct {
 	Silence map[string]*Silence
 	Group   map[time.Time]expr.AlertKeys
 
+	// dirty are the alert keys whose state changed since it was last
+	// written to the database.
+	dirty     map[expr.AlertKey]bool
+	dirtyLock sync.Mutex
+
+	// Exclusions are runtime additions to alert exclude lists, keyed by ID.
+	Exclusions map[string]*Exclusion
+
+	// Maintenance is the maintenance of hosts, keyed by host name.
+	Maintenance map[string]*Maintenance
+
 	Incidents map[uint64]*Incident
 	Search    *search.Search
 
@@ -48,10 +62,11 @@ type Schedule struct {
 	nc chan interface{}
 	//notifications to be sent immediately
 	pendingNotifications map[*conf.Notification][]*State
-	//notifications we are currently tracking, potentially with future or repeated actions.
-	Notifications map[expr.AlertKey]map[string]time.Time
 	//unknown states that need to be notified about. Collected and sent in batches.
 	pendingUnknowns map[*conf.Notification][]*State
+	// coalescing are the states held for notifications with a
+	// coalesceWindow, by notification and coalesceBy tags.
+	coalescing map[*conf.Notification]map[string]*coalesced
 
 	maxIncidentId uint64
 	incidentLock  sync.Mutex
@@ -59,8 +74,75 @@ type Schedule struct {
 
 	LastCheck time.Time
 
+	// nextRuns is the time each alert is next scheduled to run.
+	nextRuns    map[string]time.Time
+	nextRunLock sync.Mutex
+
+	// lastRuns are the details of each alert's last check.
+	lastRuns    map[string]*AlertRun
+	lastRunLock sync.Mutex
+
 	ctx *checkContext
 
+	// silenceWarned are the silences the silenceExpiryNotification has
+	// been sent for.
+	silenceWarned map[string]bool
+
+	// suppressed are the notifications suppressed by each silence, by id,
+	// not yet added to the silence's count. They are guarded by the
+	// schedule lock, since silenceLock may not be taken while it is held.
+	suppressed map[string]int64
+
+	// sent is when each notification was sent in the last hour, for
+	// notifications with maxPerHour.
+	sent     map[string][]time.Time
+	sentLock sync.Mutex
+
+	// sending are the ids of the deliveries being attempted, so a delivery
+	// is not sent by a manual and an automatic retry at once.
+	sending     map[int64]bool
+	sendingLock sync.Mutex
+
+	// health is the delivery record of each notification, and rotation the
+	// next member of each round-robin group for notifications that are not
+	// about an incident.
+	health     map[string]*NotificationHealth
+	rotation   map[string]int
+	healthLock sync.Mutex
+
+	// dryRun are the runtime changes to the dry run mode of the
+	// configuration, by alert name, or "" for all notifications. They are
+	// lost on restart.
+	dryRun     map[string]bool
+	dryRunLock sync.Mutex
+
+	// anomalies are the last scores of the series of each anomaly, by name.
+	anomalies   map[string][]*expr.Result
+	anomalyLock sync.Mutex
+
+	// cycle counts the status changes of alert keys during the current
+	// check cycle.
+	cycle     models.CycleSummary
+	cycleLock sync.Mutex
+
+	// ready is closed once checks may start, after the datasources passed
+	// their readiness probes or readinessTimeout elapsed. notReady are the
+	// datasources that have not passed yet.
+	ready     chan struct{}
+	notReady  []string
+	readyLock sync.Mutex
+
+	// leader is 1 if s holds the HA leader lease. Use IsLeader.
+	leader int32
+
+	hooks    []Hook
+	hookLock sync.RWMutex
+
+	// changed is closed and replaced when what the dashboard shows may have
+	// changed. Use Changed.
+	changed     chan struct{}
+	changedLock sync.Mutex
+
 	DataAccess database.DataAccess
 }
 
@@ -72,15 +154,20 @@ func (s *Schedule) Init(c *conf.Conf) error {
 	var err error
 	s.Conf = c
 	s.Silence = make(map[string]*Silence)
+	s.Exclusions = make(map[string]*Exclusion)
+	s.Maintenance = make(map[string]*Maintenance)
 	s.Group = make(map[time.Time]expr.AlertKeys)
 	s.Incidents = make(map[uint64]*Incident)
 	s.pendingUnknowns = make(map[*conf.Notification][]*State)
 	s.status = make(States)
+	s.dirty = make(map[expr.AlertKey]bool)
 	s.LastCheck = time.Now()
+	s.nextRuns = make(map[string]time.Time)
+	s.lastRuns = make(map[string]*AlertRun)
 	s.ctx = &checkContext{time.Now(), cache.New(0)}
 	if s.DataAccess == nil {
-		if c.RedisHost != "" {
-			s.DataAccess = database.NewDataAccess(c.RedisHost, true)
+		if len(c.RedisHosts) > 0 {
+			s.DataAccess = database.NewFailoverDataAccess(c.RedisHosts, true)
 		} else {
 			bind := "127.0.0.1:9565"
 			_, err := database.StartLedis(c.LedisDir, bind)
@@ -93,11 +180,9 @@ func (s *Schedule) Init(c *conf.Conf) error {
 	if s.Search == nil {
 		s.Search = search.NewSearch(s.DataAccess)
 	}
-	if c.StateFile != "" {
-		s.db, err = bolt.Open(c.StateFile, 0600, nil)
-		if err != nil {
-			return err
-		}
+	// The state file of older versions is only read to be imported.
+	if s.db, err = openStateFile(c.StateFile); err != nil {
+		return err
 	}
 	return nil
 }
@@ -140,24 +225,44 @@ func (s *Schedule) GetLockStatus() (holder string, since time.Time) {
 }
 
 func (s *Schedule) PutMetadata(k metadata.Metakey, v interface{}) error {
-
+	if err := checkMetadata(k.Metric, k.Name, v); err != nil {
+		slog.Error(err)
+		return err
+	}
 	isCoreMeta := (k.Name == "desc" || k.Name == "unit" || k.Name == "rate")
 	if !isCoreMeta {
 		s.DataAccess.Metadata().PutTagMetadata(k.TagSet(), k.Name, fmt.Sprint(v), time.Now().UTC())
 		return nil
 	}
-	if k.Metric == "" {
-		err := fmt.Errorf("desc, rate, and unit require metric name")
-		slog.Error(err)
-		return err
+	return s.DataAccess.Metadata().PutMetricMetadata(k.Metric, k.Name, v.(string))
+}
+
+// PutMetadataBatch stores ms in pipelined database writes, so agents can put
+// all their metadata in one request. Nothing is stored if any entry is
+// invalid.
+func (s *Schedule) PutMetadataBatch(ms []metadata.Metasend) error {
+	for i, m := range ms {
+		if err := checkMetadata(m.Metric, m.Name, m.Value); err != nil {
+			return fmt.Errorf("metadata entry %d: %v", i, err)
+		}
 	}
-	strVal, ok := v.(string)
-	if !ok {
-		err := fmt.Errorf("desc, rate, and unit require value to be string. Found: %s", reflect.TypeOf(v))
-		slog.Error(err)
-		return err
+	return s.DataAccess.Metadata().PutMetadataBatch(ms, time.Now().UTC())
+}
+
+// checkMetadata returns an error if metric metadata (desc, rate, and unit)
+// lacks a metric or has a non-string value.
+func checkMetadata(metric, name string, v interface{}) error {
+	isCoreMeta := (name == "desc" || name == "unit" || name == "rate")
+	if !isCoreMeta {
+		return nil
+	}
+	if metric == "" {
+		return fmt.Errorf("desc, rate, and unit require metric name")
 	}
-	return s.DataAccess.Metadata().PutMetricMetadata(k.Metric, k.Name, strVal)
+	if _, ok := v.(string); !ok {
+		return fmt.Errorf("desc, rate, and unit require value to be string. Found: %s", reflect.TypeOf(v))
+	}
+	return nil
 }
 
 func (s *Schedule) DeleteMetadata(tags opentsdb.TagSet, name string) error {
@@ -214,6 +319,9 @@ func (s *Schedule) GetMetadata(metric string, subset opentsdb.TagSet) ([]metadat
 				Time:  &tm,
 			})
 		}
+		if m, ok := s.maintenanceMetadata(subset); ok {
+			ms = append(ms, m)
+		}
 	}
 	return ms, nil
 }
@@ -225,9 +333,10 @@ type StateTuple struct {
 	Active   bool
 	Status   Status
 	Silenced bool
+	Severity conf.Severity
 }
 
-// GroupStates groups by NeedAck, Active, Status, and Silenced.
+// GroupStates groups by NeedAck, Active, Status, Silenced, and Severity.
 func (states States) GroupStates(silenced map[expr.AlertKey]Silence) map[StateTuple]States {
 	r := make(map[StateTuple]States)
 	for ak, st := range states {
@@ -237,6 +346,7 @@ func (states States) GroupStates(silenced map[expr.AlertKey]Silence) map[StateTu
 			st.IsActive(),
 			st.AbnormalStatus(),
 			sil,
+			st.Severity,
 		}
 		if _, present := r[t]; !present {
 			r[t] = make(States)
@@ -341,16 +451,23 @@ func (s *Schedule) GetOpenStates() States {
 }
 
 type StateGroup struct {
-	Active   bool `json:",omitempty"`
-	Status   Status
-	Silenced bool
-	IsError  bool          `json:",omitempty"`
-	Subject  string        `json:",omitempty"`
-	Alert    string        `json:",omitempty"`
-	AlertKey expr.AlertKey `json:",omitempty"`
-	Ago      string        `json:",omitempty"`
-	State    *State        `json:",omitempty"`
-	Children []*StateGroup `json:",omitempty"`
+	Active    bool `json:",omitempty"`
+	Status    Status
+	Severity  conf.Severity `json:",omitempty"`
+	Silenced  bool
+	IsError   bool              `json:",omitempty"`
+	Subject   string            `json:",omitempty"`
+	Alert     string            `json:",omitempty"`
+	Namespace string            `json:",omitempty"`
+	Note      *models.AlertNote `json:",omitempty"`
+	AlertKey  expr.AlertKey     `json:",omitempty"`
+	Ago       string            `json:",omitempty"`
+	State     *State            `json:",omitempty"`
+	Children  []*StateGroup     `json:",omitempty"`
+
+	// Owner is the owner of the group's incident, or of all its children's
+	// incidents if they have the same one.
+	Owner string `json:",omitempty"`
 }
 
 type StateGroups struct {
@@ -358,11 +475,48 @@ type StateGroups struct {
 		NeedAck      []*StateGroup `json:",omitempty"`
 		Acknowledged []*StateGroup `json:",omitempty"`
 	}
+	// Total is the number of groups matching the filter and search, of
+	// which Groups holds the requested page.
+	Total                         int
 	TimeAndDate                   []int
 	FailingAlerts, UnclosedErrors int
 }
 
-func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGroups, error) {
+// GroupsPage selects part of the dashboard's groups, so large dashboards can
+// be loaded a page at a time. The zero GroupsPage selects all groups in the
+// default order.
+type GroupsPage struct {
+	// Search, if set, keeps only alert keys whose alert key or subject
+	// contains it, ignoring case.
+	Search string
+	// Sort is the order of groups: "" for active first, then by severity,
+	// status, and subject; "ago" for the most recently changed first;
+	// "alert" by alert key; or "subject".
+	Sort string
+	// Offset is the number of groups skipped, counting groups that need
+	// acknowledgement before those that don't. Limit, if not 0, is the
+	// most groups returned.
+	Offset, Limit int
+}
+
+// groupSorts are the valid values of GroupsPage.Sort.
+var groupSorts = map[string]bool{"": true, "ago": true, "alert": true, "subject": true}
+
+// unmarshaledGroup is a group of alert keys whose children have not been
+// built yet, so only groups on the requested page are built.
+type unmarshaledGroup struct {
+	*StateGroup
+	aks  expr.AlertKeys
+	last time.Time
+}
+
+func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string, page GroupsPage) (*StateGroups, error) {
+	if !groupSorts[page.Sort] {
+		return nil, fmt.Errorf("unknown sort: %s", page.Sort)
+	}
+	if page.Offset < 0 || page.Limit < 0 {
+		return nil, fmt.Errorf("offset and limit must not be negative")
+	}
 	var silenced map[expr.AlertKey]Silence
 	T.Step("Silenced", func(miniprofiler.Timer) {
 		silenced = s.Silenced()
@@ -374,6 +528,10 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 		TimeAndDate: s.Conf.TimeAndDate,
 	}
 	t.FailingAlerts, t.UnclosedErrors = s.getErrorCounts()
+	notes, nerr := s.DataAccess.AlertNotes().GetAlertNotes()
+	if nerr != nil {
+		slog.Errorln("error getting alert notes:", nerr)
+	}
 	s.Lock("MarshallGroups")
 	defer s.Unlock()
 	T.Step("Setup", func(miniprofiler.Timer) {
@@ -382,6 +540,7 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 			err = err2
 			return
 		}
+		search := strings.ToLower(page.Search)
 		for k, v := range s.status {
 			if !v.Open {
 				continue
@@ -391,6 +550,9 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 				err = fmt.Errorf("unknown alert %s", k.Name())
 				return
 			}
+			if search != "" && !strings.Contains(strings.ToLower(string(k)), search) && !strings.Contains(strings.ToLower(v.Subject), search) {
+				continue
+			}
 			if matches(s.Conf, a, v) {
 				status[k] = v
 			}
@@ -403,9 +565,10 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 	T.Step("GroupStates", func(T miniprofiler.Timer) {
 		groups = status.GroupStates(silenced)
 	})
+	var needAck, acknowledged []*unmarshaledGroup
 	T.Step("groups", func(T miniprofiler.Timer) {
 		for tuple, states := range groups {
-			var grouped []*StateGroup
+			var grouped []*unmarshaledGroup
 			switch tuple.Status {
 			case StWarning, StCritical, StUnknown:
 				var sets map[string]expr.AlertKeys
@@ -413,61 +576,63 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 					sets = states.GroupSets(s.Conf.MinGroupSize)
 				})
 				for name, group := range sets {
-					g := StateGroup{
-						Active:   tuple.Active,
-						Status:   tuple.Status,
-						Silenced: tuple.Silenced,
-						Subject:  fmt.Sprintf("%s - %s", tuple.Status, name),
-					}
-					for _, ak := range group {
-						st := s.status[ak].Copy()
-						// remove some of the larger bits of state to reduce wire size
-						st.Body = ""
-						st.EmailBody = []byte{}
-						if len(st.History) > 1 {
-							st.History = st.History[len(st.History)-1:]
-						}
-						if len(st.Actions) > 1 {
-							st.Actions = st.Actions[len(st.Actions)-1:]
-						}
-
-						g.Children = append(g.Children, &StateGroup{
+					g := &unmarshaledGroup{
+						StateGroup: &StateGroup{
 							Active:   tuple.Active,
 							Status:   tuple.Status,
+							Severity: tuple.Severity,
 							Silenced: tuple.Silenced,
-							AlertKey: ak,
-							Alert:    ak.Name(),
-							Subject:  string(st.Subject),
-							Ago:      marshalTime(st.Last().Time),
-							State:    st,
-							IsError:  !s.AlertSuccessful(ak.Name()),
-						})
+							Subject:  fmt.Sprintf("%s - %s", tuple.Status, name),
+						},
+						aks: group,
 					}
-					if len(g.Children) == 1 && g.Children[0].Subject != "" {
-						g.Subject = g.Children[0].Subject
+					for _, ak := range group {
+						if last := s.status[ak].Last().Time; last.After(g.last) {
+							g.last = last
+						}
 					}
-					grouped = append(grouped, &g)
+					if len(group) == 1 && s.status[group[0]].Subject != "" {
+						g.Subject = s.status[group[0]].Subject
+					}
+					grouped = append(grouped, g)
 				}
 			default:
 				continue
 			}
 			if tuple.NeedAck {
-				t.Groups.NeedAck = append(t.Groups.NeedAck, grouped...)
+				needAck = append(needAck, grouped...)
 			} else {
-				t.Groups.Acknowledged = append(t.Groups.Acknowledged, grouped...)
+				acknowledged = append(acknowledged, grouped...)
 			}
 		}
 	})
 	T.Step("sort", func(T miniprofiler.Timer) {
-		gsort := func(grp []*StateGroup) func(i, j int) bool {
+		gsort := func(grp []*unmarshaledGroup) func(i, j int) bool {
 			return func(i, j int) bool {
 				a := grp[i]
 				b := grp[j]
+				switch page.Sort {
+				case "ago":
+					if !a.last.Equal(b.last) {
+						return a.last.After(b.last)
+					}
+				case "alert":
+					if a.aks[0] != b.aks[0] {
+						return a.aks[0] < b.aks[0]
+					}
+				case "subject":
+					if a.Subject != b.Subject {
+						return a.Subject < b.Subject
+					}
+				}
 				if a.Active && !b.Active {
 					return true
 				} else if !a.Active && b.Active {
 					return false
 				}
+				if a.Severity != b.Severity {
+					return a.Severity > b.Severity
+				}
 				if a.Status != b.Status {
 					return a.Status > b.Status
 				}
@@ -477,12 +642,85 @@ func (s *Schedule) MarshalGroups(T miniprofiler.Timer, filter string) (*StateGro
 				return a.Subject < b.Subject
 			}
 		}
-		slice.Sort(t.Groups.NeedAck, gsort(t.Groups.NeedAck))
-		slice.Sort(t.Groups.Acknowledged, gsort(t.Groups.Acknowledged))
+		slice.Sort(needAck, gsort(needAck))
+		slice.Sort(acknowledged, gsort(acknowledged))
+	})
+	t.Total = len(needAck) + len(acknowledged)
+	T.Step("page", func(T miniprofiler.Timer) {
+		end := t.Total
+		if page.Limit > 0 && page.Offset+page.Limit < end {
+			end = page.Offset + page.Limit
+		}
+		for i := page.Offset; i < end; i++ {
+			if i < len(needAck) {
+				t.Groups.NeedAck = append(t.Groups.NeedAck, s.marshalGroup(needAck[i], notes))
+			} else {
+				t.Groups.Acknowledged = append(t.Groups.Acknowledged, s.marshalGroup(acknowledged[i-len(needAck)], notes))
+			}
+		}
 	})
 	return &t, nil
 }
 
+// marshalGroup builds the children of g. s must be locked.
+func (s *Schedule) marshalGroup(g *unmarshaledGroup, notes map[string]*models.AlertNote) *StateGroup {
+	for i, ak := range g.aks {
+		st := s.status[ak].Copy()
+		// remove some of the larger bits of state to reduce wire size
+		st.Body = ""
+		st.EmailBody = []byte{}
+		if len(st.History) > 1 {
+			st.History = st.History[len(st.History)-1:]
+		}
+		if len(st.Actions) > 1 {
+			st.Actions = st.Actions[len(st.Actions)-1:]
+		}
+
+		g.Children = append(g.Children, &StateGroup{
+			Active:    g.Active,
+			Status:    g.Status,
+			Severity:  g.Severity,
+			Silenced:  g.Silenced,
+			AlertKey:  ak,
+			Alert:     ak.Name(),
+			Namespace: s.namespace(ak.Name()),
+			Note:      notes[ak.Name()],
+			Subject:   string(st.Subject),
+			Ago:       marshalTime(st.Last().Time),
+			State:     st,
+			IsError:   !s.AlertSuccessful(ak.Name()),
+			Owner:     s.incidentOwner(st.Last().IncidentId),
+		})
+		if owner := g.Children[len(g.Children)-1].Owner; i == 0 {
+			g.Owner = owner
+		} else if owner != g.Owner {
+			g.Owner = ""
+		}
+	}
+	return g.StateGroup
+}
+
+// Changed returns a channel that is closed the next time alert states,
+// silences, or alert notes change.
+func (s *Schedule) Changed() <-chan struct{} {
+	s.changedLock.Lock()
+	defer s.changedLock.Unlock()
+	if s.changed == nil {
+		s.changed = make(chan struct{})
+	}
+	return s.changed
+}
+
+// markChanged wakes those waiting on Changed.
+func (s *Schedule) markChanged() {
+	s.changedLock.Lock()
+	if s.changed != nil {
+		close(s.changed)
+		s.changed = nil
+	}
+	s.changedLock.Unlock()
+}
+
 func marshalTime(t time.Time) string {
 	if t.IsZero() {
 		return ""
@@ -507,9 +745,18 @@ func (s *Schedule) Load(c *conf.Conf) error {
 	if err := s.Init(c); err != nil {
 		return err
 	}
-	if s.db == nil {
-		return nil
+	s.Search.SetCardinalityLimit(c.CardinalityLimits.Limit)
+	s.recordAlertVersions(time.Now())
+	if c.TSDBAnnotations {
+		s.AddHook(&tsdbAnnotator{conf: c})
 	}
+	if c.StateChangeHook != "" {
+		s.AddHook(newStateChangeHook(c.StateChangeHook))
+	}
+	if len(c.Tickets) > 0 {
+		s.AddHook(newTicketHook(s))
+	}
+	s.AddHook(&subscriptionHook{s: s})
 	return s.RestoreState()
 }
 
@@ -519,6 +766,11 @@ func Close() {
 
 func (s *Schedule) Close() {
 	s.save()
+	if s.IsLeader() {
+		if err := s.flushStates(); err != nil {
+			slog.Errorln("flush states:", err)
+		}
+	}
 	s.Lock("Close")
 	if s.db != nil {
 		s.db.Close()
@@ -530,63 +782,168 @@ func (s *Schedule) Close() {
 	}
 }
 
-const pingFreq = time.Second * 15
+// pingMaxRTT is how long to wait for each echo reply.
+const pingMaxRTT = time.Second * 5
 
+// PingHosts pings every recently seen host each pingInterval, at most
+// pingConcurrency at once. Hosts still waiting when the next cycle is due are
+// pinged late, and that cycle is skipped.
 func (s *Schedule) PingHosts() {
-	for range time.Tick(pingFreq) {
+	if s.Conf.PingMetric != "ping" {
+		pingMeta(s.Conf.PingMetric)
+	}
+	sem := make(chan bool, s.Conf.PingConcurrency)
+	s.everyAsLeader(s.Conf.PingInterval, func() {
 		hosts, err := s.Search.TagValuesByTagKey("host", s.Conf.PingDuration)
 		if err != nil {
 			slog.Error(err)
-			continue
+			return
 		}
 		for _, host := range hosts {
-			go pingHost(host)
+			sem <- true
+			go func(host string) {
+				defer func() { <-sem }()
+				if s.pingDisabled(host) {
+					return
+				}
+				s.pingHost(host)
+			}(host)
 		}
+	})
+}
+
+// pingDisabled returns true if host has the pingDisableMeta tag metadata set
+// to a true value.
+func (s *Schedule) pingDisabled(host string) bool {
+	if s.Conf.PingDisableMeta == "" {
+		return false
 	}
+	tms, err := s.DataAccess.Metadata().GetTagMetadata(opentsdb.TagSet{"host": host}, s.Conf.PingDisableMeta)
+	if err != nil {
+		slog.Errorln("ping:", err)
+		return false
+	}
+	for _, tm := range tms {
+		if tm.Tags["host"] != host || len(tm.Tags) != 1 {
+			continue
+		}
+		if b, err := strconv.ParseBool(tm.Value); err == nil && b {
+			return true
+		}
+	}
+	return false
+}
+
+// pingResolve returns the address to ping host at: its IPv4 address, or its
+// IPv6 address if it has none and pingIPv6 is set.
+func (s *Schedule) pingResolve(host string) (*net.IPAddr, error) {
+	ra, err := net.ResolveIPAddr("ip4", host)
+	if err != nil && s.Conf.PingIPv6 {
+		ra, err = net.ResolveIPAddr("ip6", host)
+	}
+	return ra, err
+}
+
+// pingMetric returns the full name of the ping metric with the given suffix,
+// as it is stored in OpenTSDB.
+func (s *Schedule) pingMetric(suffix string) string {
+	return "bosun." + s.Conf.PingMetric + "." + suffix
 }
 
-func pingHost(host string) {
+func (s *Schedule) pingHost(host string) {
 	p := fastping.NewPinger()
-	tags := opentsdb.TagSet{"dst_host": host}
+	tags := s.Conf.PingTags.Copy()
+	tags["dst_host"] = host
+	metric := s.Conf.PingMetric
 	resolved := 0
 	defer func() {
-		collect.Put("ping.resolved", tags, resolved)
+		collect.Put(metric+".resolved", tags, resolved)
 	}()
-	ra, err := net.ResolveIPAddr("ip4:icmp", host)
+	ra, err := s.pingResolve(host)
 	if err != nil {
 		return
 	}
 	resolved = 1
 	p.AddIPAddr(ra)
-	p.MaxRTT = time.Second * 5
-	timeout := 1
+	count := s.Conf.PingCount
+	p.MaxRTT = pingMaxRTT
+	if time.Duration(count)*p.MaxRTT > s.Conf.PingInterval {
+		p.MaxRTT = s.Conf.PingInterval / time.Duration(count)
+	}
+	var rtts []time.Duration
 	p.OnRecv = func(addr *net.IPAddr, t time.Duration) {
-		collect.Put("ping.rtt", tags, float64(t)/float64(time.Millisecond))
-		timeout = 0
+		rtts = append(rtts, t)
 	}
-	if err := p.Run(); err != nil {
-		slog.Errorln(err)
+	for i := 0; i < count; i++ {
+		if err := p.Run(); err != nil {
+			slog.Errorln(err)
+			break
+		}
+	}
+	r := newPingResult(count, rtts)
+	if r.rtt != nil {
+		collect.Put(metric+".rtt", tags, *r.rtt)
+	}
+	collect.Put(metric+".timeout", tags, r.timeout)
+	if count > 1 {
+		collect.Put(metric+".loss", tags, r.loss)
 	}
-	collect.Put("ping.timeout", tags, timeout)
+}
+
+// pingResult summarizes the echo replies received from a host in one cycle.
+type pingResult struct {
+	// rtt is the average round trip time in milliseconds, or nil if no
+	// replies were received.
+	rtt *float64
+	// timeout is 1 if no replies were received, otherwise 0.
+	timeout int
+	// loss is the percent of echo requests without a reply.
+	loss float64
+}
+
+func newPingResult(sent int, rtts []time.Duration) pingResult {
+	r := pingResult{timeout: 1, loss: 100}
+	if len(rtts) == 0 || sent == 0 {
+		return r
+	}
+	var total time.Duration
+	for _, t := range rtts {
+		total += t
+	}
+	rtt := float64(total) / float64(len(rtts)) / float64(time.Millisecond)
+	r.rtt = &rtt
+	r.timeout = 0
+	r.loss = 100 * float64(sent-len(rtts)) / float64(sent)
+	if r.loss < 0 {
+		r.loss = 0
+	}
+	return r
 }
 
 func init() {
 	metadata.AddMetricMeta("bosun.statefile.size", metadata.Gauge, metadata.Bytes,
-		"The total size of the Bosun state file.")
+		"The size of each state object saved to the database.")
 	metadata.AddMetricMeta("bosun.check.duration", metadata.Gauge, metadata.Second,
 		"The number of seconds it took Bosun to check each alert rule.")
 	metadata.AddMetricMeta("bosun.check.err", metadata.Gauge, metadata.Error,
 		"The running count of the number of errors Bosun has received while trying to evaluate an alert expression.")
-	metadata.AddMetricMeta("bosun.ping.resolved", metadata.Gauge, metadata.Bool,
-		"1=Ping resolved to an IP Address. 0=Ping failed to resolve to an IP Address.")
-	metadata.AddMetricMeta("bosun.ping.rtt", metadata.Gauge, metadata.MilliSecond,
-		"The number of milliseconds for the echo reply to be received. Also known as Round Trip Time.")
-	metadata.AddMetricMeta("bosun.ping.timeout", metadata.Gauge, metadata.Ok,
-		"0=Ping responded before timeout. 1=Ping did not respond before 5 second timeout.")
+	pingMeta("ping")
 	metadata.AddMetricMeta("bosun.actions", metadata.Gauge, metadata.Count,
 		"The running count of actions performed by individual users (Closed alert, Acknowledged alert, etc).")
 }
 
+// pingMeta adds the metadata of the ping metrics with the given prefix.
+func pingMeta(prefix string) {
+	metadata.AddMetricMeta("bosun."+prefix+".resolved", metadata.Gauge, metadata.Bool,
+		"1=Ping resolved to an IP Address. 0=Ping failed to resolve to an IP Address.")
+	metadata.AddMetricMeta("bosun."+prefix+".rtt", metadata.Gauge, metadata.MilliSecond,
+		"The average number of milliseconds for echo replies to be received. Also known as Round Trip Time.")
+	metadata.AddMetricMeta("bosun."+prefix+".timeout", metadata.Gauge, metadata.Ok,
+		"0=Ping responded before timeout. 1=Ping did not respond to any echo request before timeout.")
+	metadata.AddMetricMeta("bosun."+prefix+".loss", metadata.Gauge, metadata.Pct,
+		"The percent of echo requests without a reply, when pingCount is more than 1.")
+}
+
 type State struct {
 	*Result
 
@@ -607,6 +964,20 @@ type State struct {
 	Forgotten    bool
 	Unevaluated  bool
 	LastLogTime  time.Time
+
+	// Severity is the severity of the last abnormal status.
+	Severity conf.Severity `json:",omitempty"`
+
+	// NotifiedValue is the alert's renotifyValue when it was last notified.
+	NotifiedValue *float64 `json:",omitempty"`
+
+	// SnoozedUntil is when a snooze of the alert key ends and it needs
+	// acknowledging again.
+	SnoozedUntil *time.Time `json:",omitempty"`
+
+	// rendering is what the messages of this state's notifications are
+	// rendered from, or nil if its stored message is used.
+	rendering *rendering
 }
 
 func (s *State) Copy() *State {
@@ -629,9 +1000,30 @@ func (s *State) Copy() *State {
 		LastLogTime:  s.LastLogTime,
 	}
 	newState.Result = s.Result
+	newState.Severity = s.Severity
+	newState.NotifiedValue = s.NotifiedValue
+	newState.SnoozedUntil = s.SnoozedUntil
+	newState.rendering = s.rendering
 	return newState
 }
 
+// changed reports whether s differs from prev, a Copy of it, in more than
+// its Touched time and Result, which every check updates.
+func (s *State) changed(prev *State) bool {
+	return len(s.History) != len(prev.History) ||
+		len(s.Actions) != len(prev.Actions) ||
+		s.Subject != prev.Subject ||
+		s.Body != prev.Body ||
+		s.NeedAck != prev.NeedAck ||
+		s.Open != prev.Open ||
+		s.Forgotten != prev.Forgotten ||
+		s.Unevaluated != prev.Unevaluated ||
+		!s.LastLogTime.Equal(prev.LastLogTime) ||
+		s.Severity != prev.Severity ||
+		s.NotifiedValue != prev.NotifiedValue ||
+		s.SnoozedUntil != prev.SnoozedUntil
+}
+
 func (s *State) AlertKey() expr.AlertKey {
 	return expr.NewAlertKey(s.Alert, s.Group)
 }
@@ -664,28 +1056,195 @@ func (s *State) IsActive() bool {
 	return s.Status() > StNormal
 }
 
-func (s *State) Action(user, message string, t ActionType, timestamp time.Time) {
+func (s *State) Action(user, message, reason string, t ActionType, timestamp time.Time) {
 	s.Actions = append(s.Actions, Action{
 		User:    user,
 		Message: message,
+		Reason:  reason,
 		Type:    t,
 		Time:    timestamp,
 	})
+	if t == ActionSnooze {
+		s.Actions[len(s.Actions)-1].Until = s.SnoozedUntil
+	}
+}
+
+// Action performs an action on an alert key. reason is an optional reason
+// code from the configured reasonCodes, only valid for close and forget.
+func (s *Schedule) Action(user, message, reason string, t ActionType, ak expr.AlertKey) error {
+	errs, err := s.BatchAction(user, message, reason, t, []expr.AlertKey{ak}, true)
+	if err != nil {
+		return err
+	}
+	return errs[ak]
+}
+
+// BatchAction applies an action to each of keys under one lock. If atomic
+// is true, no alert key is changed unless the action is valid for all of
+// them. It returns the error of each alert key the action was not applied
+// to, or an error if the action is not valid at all.
+func (s *Schedule) BatchAction(user, message, reason string, t ActionType, keys []expr.AlertKey, atomic bool) (map[expr.AlertKey]error, error) {
+	switch t {
+	case ActionAcknowledge, ActionClose, ActionForget:
+	case ActionSnooze:
+		return nil, fmt.Errorf("snoozes need a duration")
+	default:
+		return nil, fmt.Errorf("unknown action type: %v", t)
+	}
+	_, errs, err := s.batchAction(user, message, reason, t, 0, keys, nil, atomic)
+	return errs, err
+}
+
+// BatchSnooze acknowledges each of keys for d, after which they need
+// acknowledging again and notify again if they are still abnormal. See
+// BatchAction.
+func (s *Schedule) BatchSnooze(user, message string, d time.Duration, keys []expr.AlertKey, atomic bool) (map[expr.AlertKey]error, error) {
+	if d <= 0 {
+		return nil, fmt.Errorf("snooze duration must be positive")
+	}
+	_, errs, err := s.batchAction(user, message, "", ActionSnooze, d, keys, nil, atomic)
+	return errs, err
+}
+
+// BatchAcknowledgeRelated acknowledges keys like BatchAction, and with them
+// the other alert keys of the same alerts that need acknowledging and have
+// the same values of the tag keys in by, such as all those of a cluster. The
+// related alert keys are found and acknowledged under the same lock, so
+// atomic applies to them too. It returns keys followed by the related alert
+// keys. An alert key without one of the tag keys is not acknowledged.
+func (s *Schedule) BatchAcknowledgeRelated(user, message string, keys []expr.AlertKey, by []string, atomic bool) ([]expr.AlertKey, map[expr.AlertKey]error, error) {
+	if len(by) == 0 {
+		return nil, nil, fmt.Errorf("no tag keys to acknowledge related alert keys by")
+	}
+	return s.batchAction(user, message, "", ActionAcknowledge, 0, keys, by, atomic)
+}
+
+// relatedKeys returns the alert keys related to keys by the tag keys in by,
+// as for BatchAcknowledgeRelated, and the errors of keys without them. s must
+// be locked.
+func (s *Schedule) relatedKeys(keys []expr.AlertKey, by []string) ([]expr.AlertKey, map[expr.AlertKey]error) {
+	errs := make(map[expr.AlertKey]error)
+	seen := make(map[expr.AlertKey]bool)
+	for _, ak := range keys {
+		seen[ak] = true
+	}
+	var related expr.AlertKeys
+Keys:
+	for _, ak := range keys {
+		tags := ak.Group()
+		for _, k := range by {
+			if _, ok := tags[k]; !ok {
+				errs[ak] = fmt.Errorf("alert key has no %s tag", k)
+				continue Keys
+			}
+		}
+	States:
+		for other, st := range s.status {
+			if seen[other] || other.Name() != ak.Name() || !st.Open || !st.NeedAck {
+				continue
+			}
+			group := other.Group()
+			for _, k := range by {
+				if group[k] != tags[k] {
+					continue States
+				}
+			}
+			seen[other] = true
+			related = append(related, other)
+		}
+	}
+	sort.Sort(related)
+	return related, errs
 }
 
-func (s *Schedule) Action(user, message string, t ActionType, ak expr.AlertKey) error {
+// batchAction applies t to keys, and to the alert keys related to them by
+// the tag keys in related, if any. snooze is how long snoozes last. It
+// returns the alert keys the action was applied to or failed for.
+func (s *Schedule) batchAction(user, message, reason string, t ActionType, snooze time.Duration, keys []expr.AlertKey, related []string, atomic bool) ([]expr.AlertKey, map[expr.AlertKey]error, error) {
+	if reason != "" {
+		if t != ActionClose && t != ActionForget {
+			return nil, nil, fmt.Errorf("reason codes are only valid for close and forget actions")
+		}
+		if !s.Conf.IsReasonCode(reason) {
+			return nil, nil, fmt.Errorf("unknown reason code: %s", reason)
+		}
+	}
 	s.Lock("Action")
-	defer s.Unlock()
+	errs := make(map[expr.AlertKey]error)
+	if len(related) != 0 {
+		var more []expr.AlertKey
+		more, errs = s.relatedKeys(keys, related)
+		keys = append(append([]expr.AlertKey{}, keys...), more...)
+	}
+	var valid []expr.AlertKey
+	seen := make(map[expr.AlertKey]bool)
+	for _, ak := range keys {
+		if seen[ak] {
+			continue
+		}
+		seen[ak] = true
+		if errs[ak] != nil {
+			continue
+		}
+		if err := s.checkAction(t, ak); err != nil {
+			errs[ak] = err
+		} else {
+			valid = append(valid, ak)
+		}
+	}
+	if atomic && len(errs) != 0 {
+		s.Unlock()
+		return keys, errs, nil
+	}
+	timestamp := time.Now().UTC()
+	until := timestamp.Add(snooze)
+	var closed []Incident
+	for _, ak := range valid {
+		if incident := s.action(user, message, reason, t, ak, timestamp, until); incident != nil {
+			closed = append(closed, *incident)
+		}
+	}
+	s.Unlock()
+	action := Action{User: user, Message: message, Reason: reason, Type: t, Time: timestamp}
+	if t == ActionSnooze {
+		action.Until = &until
+	}
+	for _, ak := range valid {
+		s.runHooks(func(h Hook) { h.OnAction(ak, action) })
+	}
+	if t == ActionClose || t == ActionForget {
+		for _, ak := range valid {
+			ra := &models.ReasonAction{
+				Alert:    ak.Name(),
+				AlertKey: string(ak),
+				Type:     t.String(),
+				Reason:   reason,
+				Time:     timestamp,
+			}
+			if err := s.DataAccess.Reasons().AddReasonAction(ra); err != nil {
+				slog.Errorln("error recording action reason:", err)
+			}
+		}
+	}
+	for _, incident := range closed {
+		s.runHooks(func(h Hook) { h.OnIncidentClose(incident) })
+	}
+	// Wake the notification dispatcher to wait for the snooze to end.
+	if t == ActionSnooze && len(valid) != 0 && s.nc != nil {
+		select {
+		case s.nc <- true:
+		default:
+		}
+	}
+	return keys, errs, nil
+}
+
+// checkAction returns why t can't be applied to ak. s must be locked.
+func (s *Schedule) checkAction(t ActionType, ak expr.AlertKey) error {
 	st := s.status[ak]
 	if st == nil {
 		return fmt.Errorf("no such alert key: %v", ak)
 	}
-	ack := func() {
-		delete(s.Notifications, ak)
-		st.NeedAck = false
-	}
-	isUnknown := st.AbnormalStatus() == StUnknown
-	timestamp := time.Now().UTC()
 	switch t {
 	case ActionAcknowledge:
 		if !st.NeedAck {
@@ -694,43 +1253,73 @@ func (s *Schedule) Action(user, message string, t ActionType, ak expr.AlertKey)
 		if !st.Open {
 			return fmt.Errorf("cannot acknowledge closed alert")
 		}
-		ack()
-	case ActionClose:
-		if st.NeedAck {
-			ack()
+	case ActionSnooze:
+		if !st.Open {
+			return fmt.Errorf("cannot snooze closed alert")
 		}
+	case ActionClose:
 		if st.IsActive() {
 			return fmt.Errorf("cannot close active alert")
 		}
+	case ActionForget:
+		if st.AbnormalStatus() != StUnknown {
+			return fmt.Errorf("can only forget unknowns")
+		}
+	}
+	return nil
+}
+
+// action applies t to ak, which checkAction allowed, and returns a copy of
+// the incident it closed, if any. Snoozes last until until. s must be locked.
+func (s *Schedule) action(user, message, reason string, t ActionType, ak expr.AlertKey, timestamp, until time.Time) (closed *Incident) {
+	st := s.status[ak]
+	ack := func() {
+		s.clearNotifications(ak)
+		st.NeedAck = false
+		st.SnoozedUntil = nil
+	}
+	switch t {
+	case ActionAcknowledge:
+		ack()
+	case ActionSnooze:
+		ack()
+		st.SnoozedUntil = &until
+	case ActionClose:
+		if st.NeedAck || st.SnoozedUntil != nil {
+			ack()
+		}
 		st.Open = false
 		last := st.Last()
 		if last.IncidentId != 0 {
 			s.incidentLock.Lock()
 			if incident, ok := s.Incidents[last.IncidentId]; ok {
 				incident.End = &timestamp
+				c := *incident
+				closed = &c
 			}
 			s.incidentLock.Unlock()
 		}
 	case ActionForget:
-		if !isUnknown {
-			return fmt.Errorf("can only forget unknowns")
-		}
-		if st.NeedAck {
+		if st.NeedAck || st.SnoozedUntil != nil {
 			ack()
 		}
 		st.Open = false
 		st.Forgotten = true
 		delete(s.status, ak)
-	default:
-		return fmt.Errorf("unknown action type: %v", t)
 	}
-	st.Action(user, message, t, timestamp)
+	st.Action(user, message, reason, t, timestamp)
+	s.markDirty(ak)
+	if t == ActionAcknowledge || t == ActionSnooze {
+		s.pagerDuty(conf.PagerDutyAcknowledge, ak)
+	} else {
+		s.pagerDuty(conf.PagerDutyResolve, ak)
+	}
 	// Would like to also track the alert group, but I believe this is impossible because any character
 	// that could be used as a delimiter could also be a valid tag key or tag value character
 	if err := collect.Add("actions", opentsdb.TagSet{"user": user, "alert": ak.Name(), "type": t.String()}, 1); err != nil {
 		slog.Errorln(err)
 	}
-	return nil
+	return closed
 }
 
 func (s *State) Touch() {
@@ -761,6 +1350,11 @@ type Event struct {
 	Time        time.Time
 	Unevaluated bool
 	IncidentId  uint64
+	// Value is the result of the alert's renotifyValue.
+	Value *float64 `json:",omitempty"`
+	// Warmup is set on events replayed by the alert's warmup, before bosun
+	// checked it.
+	Warmup bool `json:",omitempty"`
 }
 
 type Result struct {
@@ -801,16 +1395,45 @@ func (s Status) MarshalJSON() ([]byte, error) {
 	return json.Marshal(s.String())
 }
 
+func (s *Status) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for st := StNone; st <= StUnknown; st++ {
+		if st.String() == name {
+			*s = st
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown status %s", name)
+}
+
 func (s Status) IsNormal() bool   { return s == StNormal }
 func (s Status) IsWarning() bool  { return s == StWarning }
 func (s Status) IsCritical() bool { return s == StCritical }
 func (s Status) IsUnknown() bool  { return s == StUnknown }
 
+// severity returns the severity of an abnormal status of a: warning is its
+// warnSeverity, and critical and unknown its critSeverity.
+func severity(a *conf.Alert, st Status) conf.Severity {
+	switch st {
+	case StWarning:
+		return a.WarnSeverity
+	case StCritical, StUnknown:
+		return a.CritSeverity
+	}
+	return conf.SevNone
+}
+
 type Action struct {
 	User    string
 	Message string
+	Reason  string `json:",omitempty"`
 	Time    time.Time
 	Type    ActionType
+	// Until is when a snooze ends.
+	Until *time.Time `json:",omitempty"`
 }
 
 type ActionType int
@@ -820,6 +1443,7 @@ const (
 	ActionAcknowledge
 	ActionClose
 	ActionForget
+	ActionSnooze
 )
 
 func (a ActionType) String() string {
@@ -830,6 +1454,8 @@ func (a ActionType) String() string {
 		return "Closed"
 	case ActionForget:
 		return "Forgotten"
+	case ActionSnooze:
+		return "Snoozed"
 	default:
 		return "none"
 	}
@@ -839,11 +1465,48 @@ func (a ActionType) MarshalJSON() ([]byte, error) {
 	return json.Marshal(a.String())
 }
 
+func (a *ActionType) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	for t := ActionNone; t <= ActionSnooze; t++ {
+		if t.String() == name {
+			*a = t
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown action %s", name)
+}
+
 type Incident struct {
-	Id       uint64
-	Start    time.Time
-	End      *time.Time
-	AlertKey expr.AlertKey
+	Id        uint64
+	Start     time.Time
+	End       *time.Time
+	AlertKey  expr.AlertKey
+	Namespace string `json:",omitempty"`
+
+	// Owner, Severity, and Tags are set by on-call to triage the incident;
+	// see UpdateIncident.
+	Owner    string        `json:",omitempty"`
+	Severity conf.Severity `json:",omitempty"`
+	Tags     []string      `json:",omitempty"`
+
+	// Tickets are the tickets opened for the incident, by ticketing system.
+	Tickets map[string]*IncidentTicket `json:",omitempty"`
+
+	// AlertVersion is the version of the alert's definition the incident
+	// was opened under; see AlertHistory.
+	AlertVersion string `json:",omitempty"`
+}
+
+// namespace returns the namespace of the named alert, or "" if the alert
+// is unknown or not in a namespace.
+func (s *Schedule) namespace(alert string) string {
+	if a := s.Conf.Alerts[alert]; a != nil {
+		return a.Namespace
+	}
+	return ""
 }
 
 func (s *Schedule) createIncident(ak expr.AlertKey, start time.Time) *Incident {
@@ -852,9 +1515,13 @@ func (s *Schedule) createIncident(ak expr.AlertKey, start time.Time) *Incident {
 	s.maxIncidentId++
 	id := s.maxIncidentId
 	incident := &Incident{
-		Id:       id,
-		Start:    start,
-		AlertKey: ak,
+		Id:        id,
+		Start:     start,
+		AlertKey:  ak,
+		Namespace: s.namespace(ak.Name()),
+	}
+	if a := s.Conf.Alerts[ak.Name()]; a != nil {
+		incident.AlertVersion = alertVersion(a)
 	}
 
 	s.Incidents[id] = incident
@@ -893,7 +1560,7 @@ func (s *Schedule) createHistoricIncidents() {
 				continue
 			}
 			// New incident
-			currentIncident = &Incident{AlertKey: ak, Start: ev.Time}
+			currentIncident = &Incident{AlertKey: ak, Start: ev.Time, Namespace: s.namespace(ak.Name())}
 			indexes[currentIncident] = i
 			incidents = append(incidents, currentIncident)
 			// Find end time for incident
@@ -927,7 +1594,9 @@ func (s *Schedule) createHistoricIncidents() {
 	}
 }
 
-func (s *Schedule) GetIncidents(alert string, from, to time.Time) []*Incident {
+// GetIncidents returns incidents started between from and to, optionally
+// limited to those of a single alert or namespace.
+func (s *Schedule) GetIncidents(alert, namespace string, from, to time.Time) []*Incident {
 	s.incidentLock.Lock()
 	defer s.incidentLock.Unlock()
 	list := []*Incident{}
@@ -935,6 +1604,9 @@ func (s *Schedule) GetIncidents(alert string, from, to time.Time) []*Incident {
 		if alert != "" && i.AlertKey.Name() != alert {
 			continue
 		}
+		if namespace != "" && i.Namespace != namespace {
+			continue
+		}
 		if i.Start.Before(from) || i.Start.After(to) {
 			continue
 		}
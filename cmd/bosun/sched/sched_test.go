@@ -8,20 +8,27 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sort"
+	"sync"
+	"strings"
 	"testing"
 	"time"
 
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
 	"bosun.org/cmd/bosun/conf"
 	"bosun.org/cmd/bosun/database"
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/models"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
 )
 
 func init() {
-	slog.Set(&slog.StdLog{log.New(ioutil.Discard, "", log.LstdFlags)})
+	slog.Set(&slog.StdLog{Log: log.New(ioutil.Discard, "", log.LstdFlags)})
 	log.SetOutput(ioutil.Discard)
 }
 
@@ -47,7 +54,7 @@ func check(s *Schedule, t time.Time) {
 	for _, n := range names {
 		a := s.Conf.Alerts[n]
 		s.ctx.runTime = t
-		s.checkAlert(a)
+		s.checkAlert(a, false)
 	}
 }
 
@@ -57,17 +64,129 @@ type nopDataAccess struct {
 	database.MetadataDataAccess
 	database.SearchDataAccess
 	database.ErrorDataAccess
+	database.DeliveryDataAccess
+	database.AlertNoteDataAccess
+	database.IncidentDataAccess
+	database.NotificationDataAccess
+	database.HADataAccess
+	database.ConfigDataAccess
+	states        map[string][]byte
+	objects       map[string][]byte
+	events        []*models.Event
+	tagMetadata   []*database.TagMetadata
 	failingAlerts map[string]bool
+	deliveries    map[int64]*models.NotificationDelivery
+	notes         map[string]*models.AlertNote
+	incidentNotes map[uint64][]*models.IncidentNote
+	notifications map[string]map[string]time.Time
+	snapshots     map[uint64]*models.IncidentSnapshot
+	profiles      map[string]*models.ExprProfile
+	subscriptions []*models.Subscription
+	versions      map[string][]*models.AlertVersion
+	cycles        []*models.CycleSummary
+	reasons       []*models.ReasonAction
 }
 
 func (n *nopDataAccess) Search() database.SearchDataAccess     { return n }
 func (n *nopDataAccess) Metadata() database.MetadataDataAccess { return n }
 func (n *nopDataAccess) Errors() database.ErrorDataAccess      { return n }
+func (n *nopDataAccess) Deliveries() database.DeliveryDataAccess {
+	return n
+}
+func (n *nopDataAccess) AlertNotes() database.AlertNoteDataAccess {
+	return n
+}
+func (n *nopDataAccess) Incidents() database.IncidentDataAccess {
+	return n
+}
+func (n *nopDataAccess) Notifications() database.NotificationDataAccess {
+	return n
+}
+func (n *nopDataAccess) HA() database.HADataAccess {
+	return n
+}
+func (n *nopDataAccess) State() database.StateDataAccess {
+	return n
+}
+func (n *nopDataAccess) Events() database.EventDataAccess {
+	return n
+}
+func (n *nopDataAccess) Reasons() database.ReasonDataAccess {
+	return n
+}
+func (n *nopDataAccess) Configs() database.ConfigDataAccess {
+	return n
+}
+func (n *nopDataAccess) Profiles() database.ProfileDataAccess {
+	return n
+}
+func (n *nopDataAccess) Subscriptions() database.SubscriptionDataAccess {
+	return n
+}
 
+func (n *nopDataAccess) GetAllMetrics() (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+func (n *nopDataAccess) AddTagValue(metric, tagK, tagV string, time int64) error { return nil }
 func (n *nopDataAccess) BackupLastInfos(map[string]map[string]*database.LastInfo) error { return nil }
 func (n *nopDataAccess) LoadLastInfos() (map[string]map[string]*database.LastInfo, error) {
 	return map[string]map[string]*database.LastInfo{}, nil
 }
+func (n *nopDataAccess) PutStates(states map[string][]byte) error {
+	for ak, data := range states {
+		if data == nil {
+			delete(n.states, ak)
+		} else {
+			n.states[ak] = data
+		}
+	}
+	return nil
+}
+func (n *nopDataAccess) GetStates() (map[string][]byte, error) { return n.states, nil }
+func (n *nopDataAccess) PutObjects(objects map[string][]byte) error {
+	for name, data := range objects {
+		n.objects[name] = data
+	}
+	return nil
+}
+func (n *nopDataAccess) GetObjects() (map[string][]byte, error) { return n.objects, nil }
+func (n *nopDataAccess) AddCycleSummary(c *models.CycleSummary) error {
+	n.cycles = append([]*models.CycleSummary{c}, n.cycles...)
+	return nil
+}
+func (n *nopDataAccess) GetCycleSummaries(count int) ([]*models.CycleSummary, error) {
+	if count < len(n.cycles) {
+		return n.cycles[:count], nil
+	}
+	return n.cycles, nil
+}
+func (n *nopDataAccess) PutEvent(e *models.Event, ttl time.Duration) error {
+	e.Id = int64(len(n.events) + 1)
+	n.events = append(n.events, e)
+	return nil
+}
+func (n *nopDataAccess) GetEvents(typ string, start, end time.Time) ([]*models.Event, error) {
+	var es []*models.Event
+	for _, e := range n.events {
+		if (typ == "" || e.Type == typ) && !e.Time.Before(start) && !e.Time.After(end) {
+			es = append(es, e)
+		}
+	}
+	return es, nil
+}
+func (n *nopDataAccess) AddReasonAction(a *models.ReasonAction) error {
+	n.reasons = append(n.reasons, a)
+	return nil
+}
+func (n *nopDataAccess) GetReasonActions(alert string, start, end time.Time) ([]*models.ReasonAction, error) {
+	var as []*models.ReasonAction
+	for _, a := range n.reasons {
+		if (alert == "" || a.Alert == alert) && !a.Time.Before(start) && !a.Time.After(end) {
+			as = append(as, a)
+		}
+	}
+	return as, nil
+}
 func (n *nopDataAccess) MarkAlertSuccess(name string) error {
 	n.failingAlerts[name] = false
 	return nil
@@ -77,12 +196,184 @@ func (n *nopDataAccess) MarkAlertFailure(name string, msg string) error {
 	return nil
 }
 func (n *nopDataAccess) GetFailingAlertCounts() (int, int, error) { return 0, 0, nil }
+func (n *nopDataAccess) PutMetadataBatch(ms []metadata.Metasend, updated time.Time) error {
+	for _, m := range ms {
+		n.tagMetadata = append(n.tagMetadata, &database.TagMetadata{
+			Tags:        m.Tags,
+			Name:        m.Name,
+			Value:       fmt.Sprint(m.Value),
+			LastTouched: updated.Unix(),
+		})
+	}
+	return nil
+}
+func (n *nopDataAccess) GetTagMetadata(tags opentsdb.TagSet, name string) ([]*database.TagMetadata, error) {
+	var tms []*database.TagMetadata
+	for _, tm := range n.tagMetadata {
+		if tm.Tags.Subset(tags) && (name == "" || tm.Name == name) {
+			tms = append(tms, tm)
+		}
+	}
+	return tms, nil
+}
 func (n *nopDataAccess) IsAlertFailing(name string) (bool, error) { return n.failingAlerts[name], nil }
+func (n *nopDataAccess) SetAlertNote(alert string, note *models.AlertNote) error {
+	n.notes[alert] = note
+	return nil
+}
+func (n *nopDataAccess) GetAlertNote(alert string) (*models.AlertNote, error) {
+	return n.notes[alert], nil
+}
+func (n *nopDataAccess) GetAlertNotes() (map[string]*models.AlertNote, error) {
+	return n.notes, nil
+}
+func (n *nopDataAccess) ClearAlertNote(alert string) error {
+	delete(n.notes, alert)
+	return nil
+}
+func (n *nopDataAccess) SetAlertProfile(alert string, p *models.ExprProfile) error {
+	n.profiles[alert] = p
+	return nil
+}
+func (n *nopDataAccess) GetAlertProfile(alert string) (*models.ExprProfile, error) {
+	return n.profiles[alert], nil
+}
+func (n *nopDataAccess) AddAlertVersion(alert string, v *models.AlertVersion) (bool, error) {
+	if l := n.versions[alert]; len(l) > 0 && l[0].Hash == v.Hash {
+		return false, nil
+	}
+	n.versions[alert] = append([]*models.AlertVersion{v}, n.versions[alert]...)
+	return true, nil
+}
+func (n *nopDataAccess) GetAlertVersions(alert string) ([]*models.AlertVersion, error) {
+	return n.versions[alert], nil
+}
+func (n *nopDataAccess) AddSubscription(s *models.Subscription) error {
+	s.Id = 1
+	if len(n.subscriptions) > 0 {
+		s.Id = n.subscriptions[len(n.subscriptions)-1].Id + 1
+	}
+	n.subscriptions = append(n.subscriptions, s)
+	return nil
+}
+func (n *nopDataAccess) GetSubscriptions() ([]*models.Subscription, error) {
+	return n.subscriptions, nil
+}
+func (n *nopDataAccess) DeleteSubscription(id int64) error {
+	for i, s := range n.subscriptions {
+		if s.Id == id {
+			n.subscriptions = append(n.subscriptions[:i], n.subscriptions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+func (n *nopDataAccess) AddIncidentNote(id uint64, note *models.IncidentNote) error {
+	n.incidentNotes[id] = append(n.incidentNotes[id], note)
+	return nil
+}
+func (n *nopDataAccess) GetIncidentNotes(id uint64) ([]*models.IncidentNote, error) {
+	return n.incidentNotes[id], nil
+}
+func (n *nopDataAccess) SetIncidentNotes(id uint64, notes []*models.IncidentNote) error {
+	n.incidentNotes[id] = notes
+	return nil
+}
+func (n *nopDataAccess) SetIncidentSnapshot(snapshot *models.IncidentSnapshot, ids []uint64) error {
+	for _, id := range ids {
+		n.snapshots[id] = snapshot
+	}
+	return nil
+}
+func (n *nopDataAccess) GetIncidentSnapshot(id uint64) (*models.IncidentSnapshot, error) {
+	return n.snapshots[id], nil
+}
+func (n *nopDataAccess) InsertNotification(ak, notification string, due time.Time) error {
+	if n.notifications[ak] == nil {
+		n.notifications[ak] = map[string]time.Time{}
+	}
+	n.notifications[ak][notification] = due
+	return nil
+}
+func (n *nopDataAccess) GetDueNotifications(t time.Time) (map[string]map[string]time.Time, error) {
+	due := map[string]map[string]time.Time{}
+	for ak, ns := range n.notifications {
+		for name, d := range ns {
+			if !d.After(t) {
+				if due[ak] == nil {
+					due[ak] = map[string]time.Time{}
+				}
+				due[ak][name] = d
+			}
+		}
+	}
+	return due, nil
+}
+func (n *nopDataAccess) GetNotifications() (map[string]map[string]time.Time, error) {
+	return n.notifications, nil
+}
+func (n *nopDataAccess) GetNextNotificationTime() (time.Time, error) {
+	var next time.Time
+	for _, ns := range n.notifications {
+		for _, d := range ns {
+			if next.IsZero() || d.Before(next) {
+				next = d
+			}
+		}
+	}
+	return next, nil
+}
+func (n *nopDataAccess) HasNotifications(ak string) (bool, error) {
+	return len(n.notifications[ak]) > 0, nil
+}
+func (n *nopDataAccess) ClearNotification(ak, notification string) error {
+	delete(n.notifications[ak], notification)
+	return nil
+}
+func (n *nopDataAccess) ClearNotifications(ak string) error {
+	delete(n.notifications, ak)
+	return nil
+}
+func (n *nopDataAccess) QueueDelivery(d *models.NotificationDelivery) error {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	d.Id = int64(len(n.deliveries) + 1)
+	n.deliveries[d.Id] = d
+	return nil
+}
+func (n *nopDataAccess) UpdateDelivery(d *models.NotificationDelivery) error {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	n.deliveries[d.Id] = d
+	return nil
+}
+func (n *nopDataAccess) GetDelivery(id int64) (*models.NotificationDelivery, error) {
+	deliveryLock.Lock()
+	defer deliveryLock.Unlock()
+	d, ok := n.deliveries[id]
+	if !ok {
+		return nil, fmt.Errorf("delivery %d not found", id)
+	}
+	return d, nil
+}
+
+var deliveryLock sync.Mutex
 
 func initSched(c *conf.Conf) (*Schedule, error) {
 	c.StateFile = ""
 	s := new(Schedule)
-	s.DataAccess = &nopDataAccess{failingAlerts: map[string]bool{}}
+	s.DataAccess = &nopDataAccess{
+		states:        map[string][]byte{},
+		objects:       map[string][]byte{},
+		failingAlerts: map[string]bool{},
+		deliveries:    map[int64]*models.NotificationDelivery{},
+		notes:         map[string]*models.AlertNote{},
+		incidentNotes: map[uint64][]*models.IncidentNote{},
+		notifications: map[string]map[string]time.Time{},
+		snapshots:     map[uint64]*models.IncidentSnapshot{},
+		profiles:      map[string]*models.ExprProfile{},
+		versions:      map[string][]*models.AlertVersion{},
+	}
 	err := s.Init(c)
 	return s, err
 }
@@ -130,7 +421,7 @@ func testSched(t *testing.T, st *schedTest) (s *Schedule) {
 		s.status = st.previous
 	}
 	check(s, queryTime)
-	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "")
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{})
 	if err != nil {
 		t.Error(err)
 		return
@@ -366,3 +657,877 @@ func TestRename(t *testing.T) {
 		},
 	})
 }
+
+func TestExclude(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+			exclude = a=b*
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "bc"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "c"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=c}", "critical"}: true,
+		},
+	})
+	if _, err := s.AddExclusion("a", "a=c", time.Now().Add(time.Hour), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Excluded(s.Conf.Alerts["a"], opentsdb.TagSet{"a": "c"}) {
+		t.Fatal("expected a{a=c} to be excluded")
+	}
+	if _, err := s.AddExclusion("a", "a=d", time.Now().Add(-time.Hour), "", ""); err == nil {
+		t.Fatal("expected error for expired exclusion")
+	}
+
+	// Saving while exclusions change must not race.
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 100; i++ {
+			id, err := s.AddExclusion("a", fmt.Sprintf("a=x%d", i), time.Time{}, "", "")
+			if err == nil {
+				err = s.ClearExclusion(id)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		close(done)
+	}()
+	for i := 0; i < 10; i++ {
+		if _, err := s.encodeState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestNamespace(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			namespace = web
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}
+		alert b {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+			schedState{"b{a=b}", "critical"}: true,
+		},
+	})
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "namespace:web", GroupsPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups.Groups.NeedAck) != 1 || groups.Groups.NeedAck[0].Children[0].Namespace != "web" {
+		t.Fatalf("expected only namespace web alerts, got %v", groups.Groups.NeedAck)
+	}
+	incidents := s.GetIncidents("", "web", time.Time{}, time.Now().Add(time.Hour))
+	if len(incidents) != 1 || incidents[0].AlertKey != "a{a=b}" {
+		t.Fatalf("expected one incident for a{a=b}, got %v", incidents)
+	}
+	aks, err := s.AddSilence(time.Now(), time.Now().Add(time.Hour), "", "web", "", "", false, false, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aks) != 1 || !aks["a{a=b}"] {
+		t.Fatalf("expected silence to match only a{a=b}, got %v", aks)
+	}
+}
+
+func TestMarshalGroupsPage(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}
+		alert b {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+			schedState{"b{a=b}", "critical"}: true,
+		},
+	})
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{Sort: "alert", Offset: 1, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups.Total != 2 || len(groups.Groups.NeedAck) != 1 || groups.Groups.NeedAck[0].Children[0].AlertKey != "b{a=b}" {
+		t.Fatalf("expected second of 2 groups to be b{a=b}, got %d: %v", groups.Total, groups.Groups.NeedAck)
+	}
+	groups, err = s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{Search: "A{A="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if groups.Total != 1 || len(groups.Groups.NeedAck) != 1 || groups.Groups.NeedAck[0].Children[0].AlertKey != "a{a=b}" {
+		t.Fatalf("expected only a{a=b} to match search, got %v", groups.Groups.NeedAck)
+	}
+	if _, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{Sort: "size"}); err == nil {
+		t.Fatal("expected error for unknown sort")
+	}
+}
+
+func TestCriticalExport(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 1
+			warn = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 2},
+				},
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "c"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+			schedState{"a{a=c}", "warning"}:  true,
+		},
+	})
+	dir, err := ioutil.TempDir("", "bosun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "critical.json")
+	if err := s.writeCriticalExport(fname); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export struct {
+		Alerts []*CriticalAlert
+	}
+	if err := json.Unmarshal(b, &export); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Alerts) != 1 || export.Alerts[0].AlertKey != "a{a=b}" {
+		t.Fatalf("expected only a{a=b} to be exported, got %s", b)
+	}
+	if err := s.Action("", "", "", ActionAcknowledge, "a{a=b}"); err != nil {
+		t.Fatal(err)
+	}
+	if c := s.CriticalUnacked(); len(c) != 0 {
+		t.Fatalf("expected no critical unacked alerts after ack, got %v", c)
+	}
+}
+
+func TestAlertmanagerAlerts(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `
+		template t {
+			subject = {{.Group.a}} is high
+			body = <p>body</p>
+		}
+		alert a {
+			crit = avg(q("avg:m{a=*,host.name=*}", "5m", "")) > 1
+			critSeverity = emergency
+			template = t
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*,host.name=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b", "host.name": "h"},
+					DPS:    map[string]opentsdb.Point{"0": 2},
+				},
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "c", "host.name": "h"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b,host.name=h}", "critical"}: true,
+		},
+	})
+	alerts := s.AlertmanagerAlerts()
+	if len(alerts) != 1 {
+		t.Fatalf("expected one alert, got %v", alerts)
+	}
+	a := alerts[0]
+	labels := map[string]string{"alertname": "a", "a": "b", "host_name": "h", "status": "critical", "severity": "emergency"}
+	if !reflect.DeepEqual(a.Labels, labels) {
+		t.Errorf("unexpected labels: %v", a.Labels)
+	}
+	if a.Annotations["summary"] != "b is high" || !strings.Contains(a.Annotations["description"], "<p>body</p>") {
+		t.Errorf("unexpected annotations: %v", a.Annotations)
+	}
+	if a.StartsAt.IsZero() || !a.EndsAt.After(time.Now()) || !strings.Contains(a.GeneratorURL, "/incident?id=") {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+	for k, expected := range map[string]string{"a.b-c": "a_b_c", "1x": "_1x", "": "_"} {
+		if l := alertmanagerLabel(k); l != expected {
+			t.Errorf("label of %q: expected %q, got %q", k, expected, l)
+		}
+	}
+}
+
+func TestAlertNote(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	if err := s.SetAlertNote("nope", "x", ""); err == nil {
+		t.Fatal("expected error for unknown alert")
+	}
+	if err := s.SetAlertNote("a", "fix rolling out <Thursday>", "user"); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if note := groups.Groups.NeedAck[0].Children[0].Note; note == nil || note.Text != "fix rolling out <Thursday>" {
+		t.Fatalf("expected note on group, got %v", note)
+	}
+	body := string(s.withAlertNote("a", []byte("body")))
+	if body != "<p><strong>Note:</strong> fix rolling out &lt;Thursday&gt; (user)</p>body" {
+		t.Fatalf("unexpected email body: %s", body)
+	}
+	if err := s.SetAlertNote("a", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if s.GetAlertNote("a") != nil {
+		t.Fatal("expected note to be cleared")
+	}
+}
+
+func TestIncidentNotes(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	var id uint64
+	for id = range s.Incidents {
+	}
+	if err := s.AddIncidentNote(id+1, "me", "x"); err == nil {
+		t.Fatal("expected error for unknown incident")
+	}
+	if err := s.AddIncidentNote(id, "me", ""); err == nil {
+		t.Fatal("expected error for empty note")
+	}
+	for _, body := range []string{"disk full on *b*", "cleaned up /tmp"} {
+		if err := s.AddIncidentNote(id, "me", body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	notes, err := s.GetIncidentNotes(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 2 || notes[0].Body != "disk full on *b*" || notes[1].Author != "me" {
+		t.Fatalf("unexpected notes: %v", notes)
+	}
+}
+
+func TestUpdateIncident(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	var id uint64
+	for id = range s.Incidents {
+	}
+	str := func(s string) *string { return &s }
+	for _, u := range []*IncidentUpdate{
+		{},
+		{Owner: str("two\nlines")},
+		{Severity: str("dire")},
+		{Tags: &[]string{"has space"}},
+	} {
+		if _, err := s.UpdateIncident(id, "me", u); err == nil {
+			t.Errorf("expected error for %+v", u)
+		}
+	}
+	if _, err := s.UpdateIncident(id+1, "me", &IncidentUpdate{Owner: str("me")}); err == nil {
+		t.Error("expected error for unknown incident")
+	}
+	incident, err := s.UpdateIncident(id, "me", &IncidentUpdate{
+		Owner:    str(" alice "),
+		Severity: str("critical"),
+		Tags:     &[]string{"network", "db", "network"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if incident.Owner != "alice" || incident.Severity != conf.SevCritical || strings.Join(incident.Tags, ",") != "db,network" {
+		t.Fatalf("unexpected incident: %+v", incident)
+	}
+	if incident, _ = s.UpdateIncident(id, "", &IncidentUpdate{Severity: str("")}); incident.Severity != conf.SevNone || incident.Owner != "alice" {
+		t.Fatalf("expected only severity cleared: %+v", incident)
+	}
+	notes, err := s.GetIncidentNotes(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Body != "claimed by alice, severity set to critical, tags set to [db network]" {
+		t.Fatalf("unexpected notes: %v", notes)
+	}
+	groups, err := s.MarshalGroups(new(miniprofiler.Profile), "", GroupsPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := groups.Groups.NeedAck[0]; g.Owner != "alice" || g.Children[0].Owner != "alice" {
+		t.Fatalf("expected owner on group, got %q", g.Owner)
+	}
+}
+
+func TestReasonReport(t *testing.T) {
+	c, err := conf.New("", `
+		reasonCodes = false positive, fixed
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	if err := s.Action("", "", "bogus", ActionClose, "a{}"); err == nil {
+		t.Fatal("expected error for unknown reason code")
+	}
+	if err := s.Action("", "", "fixed", ActionAcknowledge, "a{}"); err == nil {
+		t.Fatal("expected error for reason code on acknowledge")
+	}
+	s.status["a{}"] = &State{Alert: "a", Group: opentsdb.TagSet{}}
+	if err := s.Action("", "", "false positive", ActionClose, "a{}"); err != nil {
+		t.Fatal(err)
+	}
+	s.status["a{x=1}"] = &State{Alert: "a", Group: opentsdb.TagSet{"x": "1"}, History: []Event{{Status: StUnknown}}}
+	if err := s.Action("", "", "fixed", ActionForget, "a{x=1}"); err != nil {
+		t.Fatal(err)
+	}
+	if s.status["a{x=1}"] != nil {
+		t.Fatal("expected forgotten alert key to be removed")
+	}
+	nop := s.DataAccess.(*nopDataAccess)
+	nop.reasons = append(nop.reasons, &models.ReasonAction{
+		Alert: "a", Type: "Closed", Reason: "fixed", Time: time.Now().Add(-30 * 24 * time.Hour),
+	})
+	now := time.Now().UTC()
+	r, err := s.ReasonReport("", now.Add(-24*time.Hour), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Total.Actions != 2 || r.Total.Reasons["false positive"] != 1 || r.Total.Reasons["fixed"] != 1 {
+		t.Fatalf("unexpected report totals: %+v", r.Total)
+	}
+	if r.Alerts["a"] == nil || r.Alerts["a"].Actions != 2 {
+		t.Fatalf("unexpected report for alert a: %+v", r.Alerts)
+	}
+}
+
+func TestBatchAction(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	reset := func() {
+		s.status = States{
+			"a{h=1}": {Alert: "a", Group: opentsdb.TagSet{"h": "1"}, NeedAck: true, Open: true, History: []Event{{Status: StNormal}}},
+			"a{h=2}": {Alert: "a", Group: opentsdb.TagSet{"h": "2"}, NeedAck: true, Open: true, History: []Event{{Status: StCritical}}},
+		}
+	}
+	keys := []expr.AlertKey{"a{h=1}", "a{h=2}", "a{h=3}"}
+
+	reset()
+	errs, err := s.BatchAction("u", "m", "fixed", ActionClose, keys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 || errs["a{h=2}"] == nil || errs["a{h=3}"] == nil {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if st := s.status["a{h=1}"]; !st.Open || len(st.Actions) != 0 {
+		t.Fatal("atomic batch changed an alert key despite errors")
+	}
+
+	reset()
+	errs, err = s.BatchAction("u", "m", "fixed", ActionClose, append(keys, "a{h=1}"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 2 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	st := s.status["a{h=1}"]
+	if st.Open || st.NeedAck || len(st.Actions) != 1 || st.Actions[0].Reason != "fixed" {
+		t.Fatalf("expected a{h=1} closed once with reason, got %+v", st)
+	}
+	if st := s.status["a{h=2}"]; !st.Open || !st.NeedAck {
+		t.Fatal("failed alert key was changed")
+	}
+
+	if _, err := s.BatchAction("u", "m", "bogus", ActionClose, keys, false); err == nil {
+		t.Fatal("expected error for unknown reason code")
+	}
+}
+
+func TestBatchAcknowledgeRelated(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+		alert b {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	open := func(g opentsdb.TagSet, needAck bool) *State {
+		return &State{Group: g, NeedAck: needAck, Open: true, History: []Event{{Status: StCritical}}}
+	}
+	s.status = States{
+		"a{cluster=c1,h=1}": open(opentsdb.TagSet{"cluster": "c1", "h": "1"}, true),
+		"a{cluster=c1,h=2}": open(opentsdb.TagSet{"cluster": "c1", "h": "2"}, true),
+		"a{cluster=c1,h=3}": open(opentsdb.TagSet{"cluster": "c1", "h": "3"}, false),
+		"a{cluster=c2,h=4}": open(opentsdb.TagSet{"cluster": "c2", "h": "4"}, true),
+		"b{cluster=c1,h=5}": open(opentsdb.TagSet{"cluster": "c1", "h": "5"}, true),
+		"a{h=6}":            open(opentsdb.TagSet{"h": "6"}, true),
+	}
+	if _, _, err := s.BatchAcknowledgeRelated("u", "m", []expr.AlertKey{"a{h=6}"}, nil, true); err == nil {
+		t.Fatal("expected error without tag keys")
+	}
+	keys, errs, err := s.BatchAcknowledgeRelated("u", "m", []expr.AlertKey{"a{h=6}"}, []string{"cluster"}, true)
+	if err != nil || len(errs) != 1 || errs["a{h=6}"] == nil || !s.status["a{h=6}"].NeedAck {
+		t.Fatalf("expected a{h=6} refused, got %v %v %v", keys, errs, err)
+	}
+	keys, errs, err = s.BatchAcknowledgeRelated("u", "m", []expr.AlertKey{"a{cluster=c1,h=1}"}, []string{"cluster"}, true)
+	if err != nil || len(errs) != 0 {
+		t.Fatal(err, errs)
+	}
+	if len(keys) != 2 || keys[0] != "a{cluster=c1,h=1}" || keys[1] != "a{cluster=c1,h=2}" {
+		t.Fatalf("unexpected alert keys %v", keys)
+	}
+	for _, ak := range keys {
+		st := s.status[ak]
+		if st.NeedAck || len(st.Actions) != 1 || st.Actions[0].User != "u" || st.Actions[0].Message != "m" || st.Actions[0].Type != ActionAcknowledge {
+			t.Errorf("expected %s acknowledged by u, got %+v", ak, st.Actions)
+		}
+	}
+	for _, ak := range []expr.AlertKey{"a{cluster=c2,h=4}", "b{cluster=c1,h=5}", "a{h=6}"} {
+		if st := s.status[ak]; !st.NeedAck || len(st.Actions) != 0 {
+			t.Errorf("expected %s unchanged", ak)
+		}
+	}
+}
+
+func TestSnooze(t *testing.T) {
+	c, err := conf.New("", `
+		template t {
+			subject = s
+		}
+		notification n {
+			print = true
+		}
+		alert a {
+			crit = 1
+			critNotification = n
+			template = t
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, _ := initSched(c)
+	s.status = States{
+		"a{h=1}": {Alert: "a", Group: opentsdb.TagSet{"h": "1"}, NeedAck: true, Open: true, History: []Event{{Status: StCritical}}},
+		"a{h=2}": {Alert: "a", Group: opentsdb.TagSet{"h": "2"}, NeedAck: true, Open: true, History: []Event{{Status: StCritical}}},
+	}
+	keys := []expr.AlertKey{"a{h=1}", "a{h=2}"}
+	if _, err := s.BatchAction("u", "m", "", ActionSnooze, keys, true); err == nil {
+		t.Fatal("expected error for snooze without a duration")
+	}
+	if _, err := s.BatchSnooze("u", "m", 0, keys, true); err == nil {
+		t.Fatal("expected error for zero snooze")
+	}
+	errs, err := s.BatchSnooze("u", "m", time.Hour, keys, true)
+	if err != nil || len(errs) != 0 {
+		t.Fatal(err, errs)
+	}
+	st := s.status["a{h=1}"]
+	if st.NeedAck || st.SnoozedUntil == nil || len(st.Actions) != 1 || st.Actions[0].Until == nil || !st.Actions[0].Until.Equal(*st.SnoozedUntil) {
+		t.Fatalf("expected a{h=1} snoozed, got %+v", st)
+	}
+	until := *st.SnoozedUntil
+	// a{h=2} goes normal while snoozed, so it needs acking but doesn't notify.
+	s.status["a{h=2}"].History = append(s.status["a{h=2}"].History, Event{Status: StNormal})
+
+	if next := s.expireSnoozes(until.Add(-time.Second)); !next.Equal(until) || len(s.pendingNotifications) != 0 {
+		t.Fatalf("snooze ended early: next %v, pending %v", next, s.pendingNotifications)
+	}
+	if next := s.expireSnoozes(until); !next.IsZero() {
+		t.Fatalf("unexpected next snooze end %v", next)
+	}
+	for ak, st := range s.status {
+		if !st.NeedAck || st.SnoozedUntil != nil {
+			t.Errorf("%s: expected snooze over, got %+v", ak, st)
+		}
+	}
+	pending := s.pendingNotifications[c.Notifications["n"]]
+	if len(pending) != 1 || pending[0].AlertKey() != "a{h=1}" {
+		t.Fatalf("expected notification of a{h=1}, got %v", s.pendingNotifications)
+	}
+}
+
+func TestSilencePreview(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "c"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+			schedState{"a{a=c}", "critical"}: true,
+		},
+	})
+	if _, err := NewSilence(time.Now(), time.Now().Add(time.Hour), "", "", "a", "", false, "", ""); err == nil {
+		t.Fatal("expected error for bad tags")
+	}
+	si, err := NewSilence(time.Now(), time.Now().Add(time.Hour), "a", "", "a=b", "", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aks := s.PreviewSilence(si)
+	if len(aks) != 1 || aks[0] != "a{a=b}" {
+		t.Fatalf("expected preview to match a{a=b}, got %v", aks)
+	}
+	if len(s.Silence) != 0 {
+		t.Fatal("expected preview to not create a silence")
+	}
+	changed := s.Changed()
+	id := s.CreateSilence(si)
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected creating a silence to signal a change")
+	}
+	if _, ok := s.Silenced()["a{a=b}"]; !ok {
+		t.Fatal("expected a{a=b} to be silenced")
+	}
+	if err := s.ClearSilence(id); err != nil {
+		t.Fatal(err)
+	}
+	changed = s.Changed()
+	if err := s.ClearSilence(id); err != nil {
+		t.Fatal("expected clearing an unknown silence to do nothing, got", err)
+	}
+	select {
+	case <-changed:
+		t.Fatal("expected clearing an unknown silence to not signal a change")
+	default:
+	}
+}
+
+func TestSilenceFilter(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `
+		notification team-x {
+			print = true
+		}
+		notification team-y {
+			print = true
+		}
+		template t {
+			subject = s
+		}
+		alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+			critNotification = team-x
+			template = t
+		}
+		alert b {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+			critNotification = team-y
+			template = t
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+			schedState{"b{a=b}", "critical"}: true,
+		},
+	})
+	if _, err := NewSilence(time.Now(), time.Now().Add(time.Hour), "", "", "", "bogus:x", false, "", ""); err == nil {
+		t.Fatal("expected error for bad filter")
+	}
+	si, err := NewSilence(time.Now(), time.Now().Add(2*time.Hour), "", "", "", "notify:team-x", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateSilence(si)
+	silenced := s.Silenced()
+	if _, ok := silenced["a{a=b}"]; !ok {
+		t.Fatal("expected a{a=b} to be silenced")
+	}
+	if _, ok := silenced["b{a=b}"]; ok {
+		t.Fatal("expected b{a=b} to not be silenced")
+	}
+
+	si, err = NewSilence(time.Now(), time.Now().Add(time.Hour), "", "", "", "since:1h", false, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events are at queryTime, so move a's to now.
+	st := s.status["a{a=b}"]
+	for i := range st.History {
+		st.History[i].Time = time.Now()
+	}
+	if aks := s.PreviewSilence(si); len(aks) != 1 || aks[0] != "a{a=b}" {
+		t.Fatalf("expected since to only match a{a=b}, got %v", aks)
+	}
+}
+
+func TestSilenceExpiry(t *testing.T) {
+	c, err := conf.New("", `
+		hostname = bosun.example.com
+		silenceExpiryNotification = n
+		notification n {
+			print = true
+		}
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, status := range map[string]Status{"x": StCritical, "y": StNormal} {
+		ak := expr.NewAlertKey("a", opentsdb.TagSet{"host": host})
+		st := NewStatus(ak)
+		st.History = []Event{{Status: status}}
+		s.status[ak] = st
+	}
+	now := time.Now()
+	ending, err := NewSilence(now, now.Add(10*time.Minute), "a", "", "", "", false, "u", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	later, err := NewSilence(now, now.Add(time.Hour), "a", "", "", "", false, "u", "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.CreateSilence(ending)
+	s.CreateSilence(later)
+	deliveries := s.DataAccess.(*nopDataAccess).deliveries
+	s.notifyExpiringSilences(now)
+	s.notifyExpiringSilences(now.Add(time.Minute))
+	if len(deliveries) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(deliveries))
+	}
+	d := deliveries[1]
+	expected := "Silence by u on alert a expires in 10m0s, 1 alert still abnormal"
+	if d.Subject != expected {
+		t.Fatalf("expected subject %q, got %q", expected, d.Subject)
+	}
+	if !strings.Contains(d.Body, "a{host=x}") || strings.Contains(d.Body, "a{host=y}") {
+		t.Fatalf("expected body to list only a{host=x}: %s", d.Body)
+	}
+}
+
+func TestIncidentSnapshot(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 1
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 2},
+				},
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "c"},
+					DPS:    map[string]opentsdb.Point{"0": 0},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	var id uint64
+	for id = range s.Incidents {
+	}
+	snapshot, err := s.GetIncidentSnapshot(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot == nil || snapshot.Alert != "a" || len(snapshot.Results) != 2 {
+		t.Fatalf("unexpected snapshot: %v", snapshot)
+	}
+	if r := snapshot.Results["a{a=b}"]; r.Status != "critical" || !strings.Contains(string(r.Crit), `"Value":1`) {
+		t.Fatalf("unexpected result for a{a=b}: %s %s", r.Status, r.Crit)
+	}
+	if r := snapshot.Results["a{a=c}"]; r.Status != "normal" {
+		t.Fatalf("unexpected result for a{a=c}: %s", r.Status)
+	}
+	if _, err := s.GetIncidentSnapshot(id + 1); err == nil {
+		t.Fatal("expected error for unknown incident")
+	}
+}
+
+func TestPingResult(t *testing.T) {
+	ms := func(f float64) time.Duration { return time.Duration(f * float64(time.Millisecond)) }
+	tests := []struct {
+		sent    int
+		rtts    []time.Duration
+		rtt     float64
+		timeout int
+		loss    float64
+	}{
+		{1, nil, 0, 1, 100},
+		{1, []time.Duration{ms(4)}, 4, 0, 0},
+		{4, []time.Duration{ms(2), ms(4), ms(6)}, 4, 0, 25},
+		{5, nil, 0, 1, 100},
+	}
+	for i, test := range tests {
+		r := newPingResult(test.sent, test.rtts)
+		if r.timeout != test.timeout || r.loss != test.loss {
+			t.Errorf("%v: got timeout %v loss %v, expected %v and %v", i, r.timeout, r.loss, test.timeout, test.loss)
+		}
+		if (r.rtt == nil) != (len(test.rtts) == 0) || (r.rtt != nil && *r.rtt != test.rtt) {
+			t.Errorf("%v: got rtt %v, expected %v", i, r.rtt, test.rtt)
+		}
+	}
+}
+
+func TestPingDisabled(t *testing.T) {
+	c, err := conf.New("", `pingDisableMeta = skipping`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.DataAccess.Metadata().PutMetadataBatch([]metadata.Metasend{
+		{Tags: opentsdb.TagSet{"host": "a"}, Name: "skipping", Value: "true"},
+		{Tags: opentsdb.TagSet{"host": "b"}, Name: "skipping", Value: "false"},
+		{Tags: opentsdb.TagSet{"host": "c", "iface": "eth0"}, Name: "skipping", Value: "true"},
+		{Tags: opentsdb.TagSet{"host": "d"}, Name: "noping", Value: "true"},
+	}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, expected := range map[string]bool{"a": true, "b": false, "c": false, "d": false, "e": false} {
+		if got := s.pingDisabled(host); got != expected {
+			t.Errorf("%s: expected disabled %v, got %v", host, expected, got)
+		}
+	}
+}
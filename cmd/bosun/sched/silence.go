@@ -1,41 +1,65 @@
 package sched
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
+	htemplate "html/template"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
+	ttemplate "text/template"
 	"time"
 
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/expr"
 	"bosun.org/opentsdb"
+	"bosun.org/slog"
 )
 
 type Silence struct {
 	Start, End time.Time
 	Alert      string
+	Namespace  string
 	Tags       opentsdb.TagSet
 	Forget     bool
 	User       string
 	Message    string
+
+	// Filter, if set, further limits the silence to alert keys matching this
+	// dashboard filter, such as "notify:team-x status:critical".
+	Filter string `json:",omitempty"`
+
+	// Suppressed is the number of notifications not sent because of the
+	// silence.
+	Suppressed int64
 }
 
 func (s *Silence) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
 		Start, End time.Time
 		Alert      string
+		Namespace  string `json:",omitempty"`
 		Tags       string
 		Forget     bool
 		User       string
 		Message    string
+		Filter     string `json:",omitempty"`
+		Suppressed int64
 	}{
-		Start:   s.Start,
-		End:     s.End,
-		Alert:   s.Alert,
-		Tags:    s.Tags.Tags(),
-		Forget:  s.Forget,
-		User:    s.User,
-		Message: s.Message,
+		Start:      s.Start,
+		End:        s.End,
+		Alert:      s.Alert,
+		Namespace:  s.Namespace,
+		Tags:       s.Tags.Tags(),
+		Forget:     s.Forget,
+		User:       s.User,
+		Message:    s.Message,
+		Filter:     s.Filter,
+		Suppressed: s.Suppressed,
 	})
 }
 
@@ -73,6 +97,12 @@ func (s *Silence) Matches(alert string, tags opentsdb.TagSet) bool {
 func (s Silence) ID() string {
 	h := sha1.New()
 	fmt.Fprintf(h, "%s|%s|%s%s", s.Start, s.End, s.Alert, s.Tags)
+	if s.Namespace != "" {
+		fmt.Fprintf(h, "|%s", s.Namespace)
+	}
+	if s.Filter != "" {
+		fmt.Fprintf(h, "|filter:%s", s.Filter)
+	}
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
@@ -87,9 +117,14 @@ func (s *Schedule) Silenced() map[expr.AlertKey]Silence {
 		if !si.ActiveAt(now) {
 			continue
 		}
+		matches, err := makeFilter(si.Filter)
+		if err != nil {
+			slog.Errorf("silence %s: %v", si.ID(), err)
+			continue
+		}
 		s.Lock("Silence")
-		for ak := range s.status {
-			if si.Silenced(now, ak.Name(), ak.Group()) {
+		for ak, st := range s.status {
+			if s.silenceMatch(si, matches, ak, st) {
 				if aks[ak].End.Before(si.End) {
 					aks[ak] = *si
 				}
@@ -97,12 +132,22 @@ func (s *Schedule) Silenced() map[expr.AlertKey]Silence {
 		}
 		s.Unlock()
 	}
+	s.Lock("Silence")
+	for ak, si := range s.maintenanceSilences(now) {
+		if _, ok := aks[ak]; !ok {
+			aks[ak] = si
+		}
+	}
+	s.Unlock()
 	return aks
 }
 
 var silenceLock = sync.RWMutex{}
 
-func (s *Schedule) AddSilence(start, end time.Time, alert, tagList string, forget, confirm bool, edit, user, message string) (map[expr.AlertKey]bool, error) {
+// NewSilence validates and returns a new silence. tagList is a comma-separated
+// list of tagk=tagv pairs where tagv may be a glob. filter is a dashboard
+// filter that matched alert keys must also satisfy.
+func NewSilence(start, end time.Time, alert, namespace, tagList, filter string, forget bool, user, message string) (*Silence, error) {
 	if start.IsZero() || end.IsZero() {
 		return nil, fmt.Errorf("both start and end must be specified")
 	}
@@ -112,17 +157,22 @@ func (s *Schedule) AddSilence(start, end time.Time, alert, tagList string, forge
 	if time.Since(end) > 0 {
 		return nil, fmt.Errorf("end time must be in the future")
 	}
-	if alert == "" && tagList == "" {
-		return nil, fmt.Errorf("must specify either alert or tags")
+	if alert == "" && tagList == "" && namespace == "" && strings.TrimSpace(filter) == "" {
+		return nil, fmt.Errorf("must specify either alert, namespace, tags, or filter")
+	}
+	if _, err := makeFilter(filter); err != nil {
+		return nil, err
 	}
 	si := &Silence{
-		Start:   start,
-		End:     end,
-		Alert:   alert,
-		Tags:    make(opentsdb.TagSet),
-		Forget:  forget,
-		User:    user,
-		Message: message,
+		Start:     start,
+		End:       end,
+		Alert:     alert,
+		Namespace: namespace,
+		Tags:      make(opentsdb.TagSet),
+		Forget:    forget,
+		User:      user,
+		Message:   message,
+		Filter:    strings.TrimSpace(filter),
 	}
 	if tagList != "" {
 		tags, err := opentsdb.ParseTags(tagList)
@@ -131,25 +181,283 @@ func (s *Schedule) AddSilence(start, end time.Time, alert, tagList string, forge
 		}
 		si.Tags = tags
 	}
-	silenceLock.Lock()
-	defer silenceLock.Unlock()
+	return si, nil
+}
+
+func (s *Schedule) AddSilence(start, end time.Time, alert, namespace, tagList, filter string, forget, confirm bool, edit, user, message string) (map[expr.AlertKey]bool, error) {
+	si, err := NewSilence(start, end, alert, namespace, tagList, filter, forget, user, message)
+	if err != nil {
+		return nil, err
+	}
 	if confirm {
+		silenceLock.Lock()
+		defer silenceLock.Unlock()
+		if old := s.Silence[edit]; old != nil {
+			si.Suppressed = old.Suppressed
+		}
 		delete(s.Silence, edit)
 		s.Silence[si.ID()] = si
+		s.markChanged()
 		return nil, nil
 	}
 	aks := make(map[expr.AlertKey]bool)
-	for ak := range s.status {
-		if si.Matches(ak.Name(), ak.Group()) {
-			aks[ak] = s.status[ak].IsActive()
-		}
+	for ak, st := range s.silenceMatches(si) {
+		aks[ak] = st.IsActive()
 	}
 	return aks, nil
 }
 
+// silenceMatch returns true if si, whose compiled filter is matches, applies
+// to ak. The schedule must be locked.
+func (s *Schedule) silenceMatch(si *Silence, matches func(*conf.Conf, *conf.Alert, *State) bool, ak expr.AlertKey, st *State) bool {
+	if si.Namespace != "" && si.Namespace != s.namespace(ak.Name()) {
+		return false
+	}
+	if !si.Matches(ak.Name(), ak.Group()) {
+		return false
+	}
+	if si.Filter == "" {
+		return true
+	}
+	a := s.Conf.Alerts[ak.Name()]
+	return a != nil && matches(s.Conf, a, st)
+}
+
+// silenceMatches returns the states of all alert keys matched by si.
+func (s *Schedule) silenceMatches(si *Silence) map[expr.AlertKey]*State {
+	states := make(map[expr.AlertKey]*State)
+	matches, err := makeFilter(si.Filter)
+	if err != nil {
+		slog.Errorf("silence %s: %v", si.ID(), err)
+		return states
+	}
+	s.Lock("SilenceMatches")
+	defer s.Unlock()
+	for ak, st := range s.status {
+		if s.silenceMatch(si, matches, ak, st) {
+			states[ak] = st
+		}
+	}
+	return states
+}
+
+// PreviewSilence returns the currently open alert keys that si would match.
+func (s *Schedule) PreviewSilence(si *Silence) expr.AlertKeys {
+	aks := expr.AlertKeys{}
+	for ak, st := range s.silenceMatches(si) {
+		if st.Open {
+			aks = append(aks, ak)
+		}
+	}
+	sort.Sort(aks)
+	return aks
+}
+
+// CreateSilence stores si and returns its id.
+func (s *Schedule) CreateSilence(si *Silence) string {
+	id := si.ID()
+	silenceLock.Lock()
+	defer silenceLock.Unlock()
+	s.Silence[id] = si
+	s.markChanged()
+	return id
+}
+
+// ClearSilence removes the silence with id. Clearing a silence that doesn't
+// exist, such as one that already expired, does nothing.
 func (s *Schedule) ClearSilence(id string) error {
 	silenceLock.Lock()
 	defer silenceLock.Unlock()
+	if _, ok := s.Silence[id]; !ok {
+		return nil
+	}
 	delete(s.Silence, id)
+	s.markChanged()
 	return nil
 }
+
+// countSuppressed records that notification was not sent because of si. s
+// must be locked.
+func (s *Schedule) countSuppressed(si Silence, notification string) {
+	collect.Add("notifications.silenced", opentsdb.TagSet{"notification": notification}, 1)
+	if s.suppressed == nil {
+		s.suppressed = make(map[string]int64)
+	}
+	s.suppressed[si.ID()]++
+}
+
+// recordSuppressed adds the notifications counted by countSuppressed to their
+// silences. Silences of maintenance, which are not stored, are not counted.
+func (s *Schedule) recordSuppressed() {
+	s.Lock("RecordSuppressed")
+	counts := s.suppressed
+	s.suppressed = nil
+	s.Unlock()
+	if len(counts) == 0 {
+		return
+	}
+	silenceLock.Lock()
+	defer silenceLock.Unlock()
+	for id, n := range counts {
+		if si := s.Silence[id]; si != nil {
+			si.Suppressed += n
+		}
+	}
+}
+
+// SilenceEffect is what an active silence is suppressing.
+type SilenceEffect struct {
+	Id      string
+	Silence *Silence
+	// AlertKeys are the open alert keys the silence matches, and Matched
+	// the number of alert keys, open or not, it matches.
+	AlertKeys expr.AlertKeys
+	Matched   int
+}
+
+// SilenceEffects returns the effect of each active silence in namespace, or
+// of all if namespace is empty. Silences suppressing the fewest open alert
+// keys and notifications, the likeliest to be stale, are first.
+func (s *Schedule) SilenceEffects(namespace string) []*SilenceEffect {
+	now := time.Now()
+	var silences []*SilenceEffect
+	silenceLock.RLock()
+	for id, si := range s.Silence {
+		if !si.ActiveAt(now) || (namespace != "" && si.Namespace != namespace) {
+			continue
+		}
+		c := *si
+		silences = append(silences, &SilenceEffect{Id: id, Silence: &c})
+	}
+	silenceLock.RUnlock()
+	effects := []*SilenceEffect{}
+	for _, e := range silences {
+		e.AlertKeys = expr.AlertKeys{}
+		for ak, st := range s.silenceMatches(e.Silence) {
+			e.Matched++
+			if st.Open {
+				e.AlertKeys = append(e.AlertKeys, ak)
+			}
+		}
+		sort.Sort(e.AlertKeys)
+		effects = append(effects, e)
+	}
+	sort.Sort(byEffect(effects))
+	return effects
+}
+
+type byEffect []*SilenceEffect
+
+func (b byEffect) Len() int      { return len(b) }
+func (b byEffect) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byEffect) Less(i, j int) bool {
+	if len(b[i].AlertKeys) != len(b[j].AlertKeys) {
+		return len(b[i].AlertKeys) < len(b[j].AlertKeys)
+	}
+	if b[i].Silence.Suppressed != b[j].Silence.Suppressed {
+		return b[i].Silence.Suppressed < b[j].Silence.Suppressed
+	}
+	return b[i].Id < b[j].Id
+}
+
+var (
+	silenceExpirySubjectTemplate = ttemplate.Must(ttemplate.New("").Parse(
+		`Silence by {{.Silence.User}} on {{.Target}} expires in {{.Remaining}}, {{len .AlertKeys}} alert{{if gt (len .AlertKeys) 1}}s{{end}} still abnormal`))
+	silenceExpiryBodyTemplate = htemplate.Must(htemplate.New("").Parse(`<p>The <a href="{{.SilenceLink}}">silence</a> on {{.Target}} by {{.Silence.User}} ends at {{.Silence.End}}.
+{{if .Silence.Message}}<p><strong>Message:</strong> {{.Silence.Message}}{{end}}
+<p>These alerts are still abnormal and will notify once it ends:
+<ul>
+{{range .AlertKeys}}	<li>{{.}}</li>
+{{end}}</ul>`))
+)
+
+type silenceExpiryContext struct {
+	Silence   *Silence
+	AlertKeys expr.AlertKeys
+	Remaining time.Duration
+	schedule  *Schedule
+}
+
+// Target describes what the silence matches.
+func (c *silenceExpiryContext) Target() string {
+	var target []string
+	if c.Silence.Alert != "" {
+		target = append(target, "alert "+c.Silence.Alert)
+	}
+	if c.Silence.Namespace != "" {
+		target = append(target, "namespace "+c.Silence.Namespace)
+	}
+	if len(c.Silence.Tags) > 0 {
+		target = append(target, c.Silence.Tags.Tags())
+	}
+	if c.Silence.Filter != "" {
+		target = append(target, "filter "+c.Silence.Filter)
+	}
+	return strings.Join(target, ", ")
+}
+
+func (c *silenceExpiryContext) SilenceLink() string {
+	return c.schedule.Conf.MakeLink("/silence", &url.Values{})
+}
+
+// checkSilenceExpiry sends the silenceExpiryNotification every check interval
+// for silences that are about to end.
+func (s *Schedule) checkSilenceExpiry() {
+	for {
+		if s.IsLeader() {
+			s.notifyExpiringSilences(time.Now())
+		}
+		time.Sleep(s.Conf.CheckFrequency)
+	}
+}
+
+// notifyExpiringSilences sends the silenceExpiryNotification for each silence
+// ending within silenceExpiryWarning of now that covers abnormal alerts. Each
+// silence is only notified once.
+func (s *Schedule) notifyExpiringSilences(now time.Time) {
+	n := s.Conf.SilenceExpiryNotification
+	if n == nil {
+		return
+	}
+	if s.silenceWarned == nil {
+		s.silenceWarned = make(map[string]bool)
+	}
+	expiring := make(map[string]*Silence)
+	silenceLock.RLock()
+	for id := range s.silenceWarned {
+		if _, ok := s.Silence[id]; !ok {
+			delete(s.silenceWarned, id)
+		}
+	}
+	for id, si := range s.Silence {
+		if si.ActiveAt(now) && si.End.Sub(now) <= s.Conf.SilenceExpiryWarning && !s.silenceWarned[id] {
+			expiring[id] = si
+		}
+	}
+	silenceLock.RUnlock()
+	for id, si := range expiring {
+		s.silenceWarned[id] = true
+		data := &silenceExpiryContext{
+			Silence:   si,
+			Remaining: si.End.Sub(now) - si.End.Sub(now)%time.Minute,
+			schedule:  s,
+		}
+		for ak, st := range s.silenceMatches(si) {
+			if st.IsActive() {
+				data.AlertKeys = append(data.AlertKeys, ak)
+			}
+		}
+		if len(data.AlertKeys) == 0 {
+			continue
+		}
+		sort.Sort(data.AlertKeys)
+		subject, body := new(bytes.Buffer), new(bytes.Buffer)
+		if err := silenceExpirySubjectTemplate.Execute(subject, data); err != nil {
+			slog.Errorln("error rendering silence expiry subject:", err)
+		}
+		if err := silenceExpiryBodyTemplate.Execute(body, data); err != nil {
+			slog.Errorln("error rendering silence expiry body:", err)
+		}
+		s.deliver(n, nil, "silenceExpiry", subject.String(), body.String(), subject.Bytes(), body.Bytes())
+	}
+}
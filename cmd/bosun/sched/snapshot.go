@@ -0,0 +1,61 @@
+package sched
+
+import (
+	"encoding/json"
+
+	"bosun.org/models"
+	"bosun.org/slog"
+)
+
+// snapshotIncidents stores the results of r as the snapshot of the incidents
+// opened in it. Incidents of the same alert share one snapshot of all of the
+// alert's keys.
+func (s *Schedule) snapshotIncidents(r *RunHistory) {
+	ids := make(map[string][]uint64)
+	for _, ak := range r.opened {
+		ids[ak.Name()] = append(ids[ak.Name()], r.Events[ak].IncidentId)
+	}
+	for alert, alertIds := range ids {
+		snapshot := &models.IncidentSnapshot{
+			Alert:   alert,
+			Time:    r.Start,
+			Results: make(map[string]*models.SnapshotResult),
+		}
+		for ak, event := range r.Events {
+			if ak.Name() != alert {
+				continue
+			}
+			snapshot.Results[string(ak)] = snapshotResult(event)
+		}
+		if err := s.DataAccess.Incidents().SetIncidentSnapshot(snapshot, alertIds); err != nil {
+			slog.Errorln("error saving incident snapshot:", err)
+		}
+	}
+}
+
+func snapshotResult(e *Event) *models.SnapshotResult {
+	sr := &models.SnapshotResult{Status: e.Status.String()}
+	marshal := func(r *Result) json.RawMessage {
+		if r == nil || r.Result == nil {
+			return nil
+		}
+		b, err := json.Marshal(r)
+		if err != nil {
+			slog.Errorln("error marshaling incident snapshot:", err)
+			return nil
+		}
+		return b
+	}
+	sr.Warn = marshal(e.Warn)
+	sr.Crit = marshal(e.Crit)
+	return sr
+}
+
+// GetIncidentSnapshot returns what bosun saw when incident id opened, or nil
+// if no snapshot was taken.
+func (s *Schedule) GetIncidentSnapshot(id uint64) (*models.IncidentSnapshot, error) {
+	if _, err := s.GetIncident(id); err != nil {
+		return nil, err
+	}
+	return s.DataAccess.Incidents().GetIncidentSnapshot(id)
+}
@@ -0,0 +1,63 @@
+package sched
+
+import (
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+)
+
+// expireSnoozes ends the snoozes that are over by now. Their alert keys need
+// acknowledging again, and notify again if they are still abnormal. It
+// returns when the next snooze ends, or the zero time if none are. s must be
+// locked.
+func (s *Schedule) expireSnoozes(now time.Time) (next time.Time) {
+	for ak, st := range s.status {
+		if st.SnoozedUntil == nil {
+			continue
+		}
+		if until := *st.SnoozedUntil; until.After(now) {
+			if next.IsZero() || until.Before(next) {
+				next = until
+			}
+			continue
+		}
+		st.SnoozedUntil = nil
+		s.markDirty(ak)
+		if !st.Open {
+			continue
+		}
+		st.NeedAck = true
+		a := s.Conf.Alerts[st.Alert]
+		if a == nil || !st.IsActive() {
+			continue
+		}
+		for _, n := range s.statusNotifications(a, st, st.Status()) {
+			s.Notify(st, n)
+		}
+	}
+	return next
+}
+
+// statusNotifications returns the notifications of a for st at status.
+func (s *Schedule) statusNotifications(a *conf.Alert, st *State, status Status) []*conf.Notification {
+	var ns *conf.Notifications
+	switch status {
+	case StCritical:
+		ns = a.CritNotification
+	case StUnknown:
+		ns = a.UnknownNotifications()
+	case StWarning:
+		ns = a.WarnNotification
+	}
+	if ns == nil {
+		return nil
+	}
+	sev := severity(a, status)
+	var l []*conf.Notification
+	for _, n := range ns.Get(s.Conf, st.Group) {
+		if sev >= n.MinSeverity {
+			l = append(l, n)
+		}
+	}
+	return l
+}
@@ -0,0 +1,520 @@
+package sched
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/cmd/bosun/database"
+	"bosun.org/expr"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+// stateExportVersion is the version of the StateExport format. It must be
+// incremented when the format changes incompatibly.
+const stateExportVersion = 2
+
+// StateExport is everything bosun stores about alerts and metrics, used to
+// move bosun to a new server or between the ledis and redis backends.
+type StateExport struct {
+	Version     int
+	Time        time.Time
+	Silences    map[string]*SilenceExport
+	Incidents   map[uint64]*Incident
+	Exclusions  map[string]*ExclusionExport
+	Maintenance map[string]*Maintenance
+	LastRuns    map[string]*AlertRun
+	AlertStates map[expr.AlertKey]*AlertStateExport
+	// Notifications maps alert key to notification name to the time it is
+	// next due.
+	Notifications  map[string]map[string]time.Time
+	MetricMetadata map[string]*database.MetricMetadata
+	TagMetadata    []*database.TagMetadata
+	AlertNotes     map[string]*models.AlertNote
+	IncidentNotes  map[uint64][]*models.IncidentNote
+	// IncidentSnapshots are stored once per incident when imported.
+	IncidentSnapshots map[uint64]*models.IncidentSnapshot
+	Search            *SearchExport
+}
+
+// SilenceExport is a Silence with its tags as a map, rather than the string
+// of its API form.
+type SilenceExport Silence
+
+// ExclusionExport is an Exclusion with its tags as a map, rather than the
+// string of its API form.
+type ExclusionExport Exclusion
+
+// AlertStateExport is the State of an alert key. Results keep their
+// expression, group, and value, but not their computations, which are
+// dropped when states are restored anyway.
+type AlertStateExport struct {
+	Result        *ResultExport `json:",omitempty"`
+	History       []EventExport
+	Actions       []Action
+	Touched       time.Time
+	Alert         string
+	Tags          string
+	Group         opentsdb.TagSet
+	Subject       string
+	Body          string
+	EmailBody     []byte
+	EmailSubject  []byte
+	Attachments   []*conf.Attachment
+	NeedAck       bool
+	Open          bool
+	Forgotten     bool
+	Unevaluated   bool
+	LastLogTime   time.Time
+	Severity      conf.Severity
+	NotifiedValue *float64   `json:",omitempty"`
+	SnoozedUntil  *time.Time `json:",omitempty"`
+}
+
+// EventExport is an Event of an AlertStateExport.
+type EventExport struct {
+	Warn, Crit  *ResultExport `json:",omitempty"`
+	Status      Status
+	Time        time.Time
+	Unevaluated bool
+	IncidentId  uint64
+	Value       *float64 `json:",omitempty"`
+	Warmup      bool     `json:",omitempty"`
+}
+
+// ResultExport is a Result. Value is formatted by strconv, so NaN and
+// infinities survive.
+type ResultExport struct {
+	Expr   string
+	Group  opentsdb.TagSet
+	Value  string `json:",omitempty"`
+	Scalar bool   `json:",omitempty"`
+}
+
+func exportResult(r *Result) *ResultExport {
+	if r == nil {
+		return nil
+	}
+	e := &ResultExport{Expr: r.Expr}
+	if r.Result == nil {
+		return e
+	}
+	e.Group = r.Group
+	switch v := r.Value.(type) {
+	case expr.Number:
+		e.Value = strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case expr.Scalar:
+		e.Value = strconv.FormatFloat(float64(v), 'g', -1, 64)
+		e.Scalar = true
+	}
+	return e
+}
+
+func (e *ResultExport) result() (*Result, error) {
+	if e == nil {
+		return nil, nil
+	}
+	r := &Result{Result: &expr.Result{Group: e.Group}, Expr: e.Expr}
+	if e.Value != "" {
+		f, err := strconv.ParseFloat(e.Value, 64)
+		if err != nil {
+			return nil, err
+		}
+		r.Value = expr.Number(f)
+		if e.Scalar {
+			r.Value = expr.Scalar(f)
+		}
+	}
+	return r, nil
+}
+
+func exportAlertState(st *State) *AlertStateExport {
+	e := &AlertStateExport{
+		Result:        exportResult(st.Result),
+		Actions:       st.Actions,
+		Touched:       st.Touched,
+		Alert:         st.Alert,
+		Tags:          st.Tags,
+		Group:         st.Group,
+		Subject:       st.Subject,
+		Body:          st.Body,
+		EmailBody:     st.EmailBody,
+		EmailSubject:  st.EmailSubject,
+		Attachments:   st.Attachments,
+		NeedAck:       st.NeedAck,
+		Open:          st.Open,
+		Forgotten:     st.Forgotten,
+		Unevaluated:   st.Unevaluated,
+		LastLogTime:   st.LastLogTime,
+		Severity:      st.Severity,
+		NotifiedValue: st.NotifiedValue,
+		SnoozedUntil:  st.SnoozedUntil,
+	}
+	for _, ev := range st.History {
+		e.History = append(e.History, EventExport{
+			Warn:        exportResult(ev.Warn),
+			Crit:        exportResult(ev.Crit),
+			Status:      ev.Status,
+			Time:        ev.Time,
+			Unevaluated: ev.Unevaluated,
+			IncidentId:  ev.IncidentId,
+			Value:       ev.Value,
+			Warmup:      ev.Warmup,
+		})
+	}
+	return e
+}
+
+func (e *AlertStateExport) state() (*State, error) {
+	st := &State{
+		Actions:       e.Actions,
+		Touched:       e.Touched,
+		Alert:         e.Alert,
+		Tags:          e.Tags,
+		Group:         e.Group,
+		Subject:       e.Subject,
+		Body:          e.Body,
+		EmailBody:     e.EmailBody,
+		EmailSubject:  e.EmailSubject,
+		Attachments:   e.Attachments,
+		NeedAck:       e.NeedAck,
+		Open:          e.Open,
+		Forgotten:     e.Forgotten,
+		Unevaluated:   e.Unevaluated,
+		LastLogTime:   e.LastLogTime,
+		Severity:      e.Severity,
+		NotifiedValue: e.NotifiedValue,
+		SnoozedUntil:  e.SnoozedUntil,
+	}
+	var err error
+	if st.Result, err = e.Result.result(); err != nil {
+		return nil, err
+	}
+	for _, ee := range e.History {
+		ev := Event{
+			Status:      ee.Status,
+			Time:        ee.Time,
+			Unevaluated: ee.Unevaluated,
+			IncidentId:  ee.IncidentId,
+			Value:       ee.Value,
+			Warmup:      ee.Warmup,
+		}
+		if ev.Warn, err = ee.Warn.result(); err != nil {
+			return nil, err
+		}
+		if ev.Crit, err = ee.Crit.result(); err != nil {
+			return nil, err
+		}
+		st.History = append(st.History, ev)
+	}
+	return st, nil
+}
+
+// SearchExport is the contents of the search index.
+type SearchExport struct {
+	Metrics map[string]int64
+	// TagKeys maps metric to tag key.
+	TagKeys map[string]map[string]int64
+	// TagValues maps metric to tag key to tag value. Values for all metrics
+	// are under database.Search_All.
+	TagValues map[string]map[string]map[string]int64
+	// TagSets maps metric to tag set.
+	TagSets map[string]map[string]int64
+	// MetricsForTag maps a tagk=tagv pair to metric.
+	MetricsForTag map[string]map[string]int64
+}
+
+// ExportState writes a StateExport of s as JSON to w. s must be initialized.
+// If s is running, changes not yet saved to the database are not included.
+func (s *Schedule) ExportState(w io.Writer) error {
+	e := &StateExport{
+		Version:           stateExportVersion,
+		Time:              time.Now().UTC(),
+		MetricMetadata:    make(map[string]*database.MetricMetadata),
+		IncidentNotes:     make(map[uint64][]*models.IncidentNote),
+		IncidentSnapshots: make(map[uint64]*models.IncidentSnapshot),
+	}
+	objects, err := s.DataAccess.State().GetObjects()
+	if err != nil {
+		return err
+	}
+	var silences map[string]*Silence
+	var exclusions map[string]*Exclusion
+	for name, dst := range map[string]interface{}{
+		dbSilence:     &silences,
+		dbIncidents:   &e.Incidents,
+		dbExclusions:  &exclusions,
+		dbMaintenance: &e.Maintenance,
+		dbLastRuns:    &e.LastRuns,
+	} {
+		if err := decodeObject(objects, name, dst); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	e.Silences = make(map[string]*SilenceExport, len(silences))
+	for id, si := range silences {
+		e.Silences[id] = (*SilenceExport)(si)
+	}
+	e.Exclusions = make(map[string]*ExclusionExport, len(exclusions))
+	for id, ex := range exclusions {
+		e.Exclusions[id] = (*ExclusionExport)(ex)
+	}
+	states, err := s.loadStates()
+	if err != nil {
+		return err
+	}
+	e.AlertStates = make(map[expr.AlertKey]*AlertStateExport, len(states))
+	for ak, st := range states {
+		e.AlertStates[ak] = exportAlertState(st)
+	}
+	if e.Search, err = s.exportSearch(); err != nil {
+		return err
+	}
+	for metric := range e.Search.Metrics {
+		m, err := s.DataAccess.Metadata().GetMetricMetadata(metric)
+		if err != nil {
+			return err
+		}
+		if m != nil && (m.Desc != "" || m.Unit != "" || m.Rate != "") {
+			e.MetricMetadata[metric] = m
+		}
+	}
+	// Tag metadata is only indexed by tag, so find it through every tag in
+	// the search index.
+	seen := make(map[string]bool)
+	for tagk, values := range e.Search.TagValues[database.Search_All] {
+		for tagv := range values {
+			tms, err := s.DataAccess.Metadata().GetTagMetadata(opentsdb.TagSet{tagk: tagv}, "")
+			if err != nil {
+				return err
+			}
+			for _, tm := range tms {
+				key := tm.Tags.Tags() + ":" + tm.Name
+				if !seen[key] {
+					seen[key] = true
+					e.TagMetadata = append(e.TagMetadata, tm)
+				}
+			}
+		}
+	}
+	// All notifications are due by the end of time.
+	if e.Notifications, err = s.DataAccess.Notifications().GetDueNotifications(time.Unix(1<<62, 0)); err != nil {
+		return err
+	}
+	if e.AlertNotes, err = s.DataAccess.AlertNotes().GetAlertNotes(); err != nil {
+		return err
+	}
+	for id := range e.Incidents {
+		notes, err := s.DataAccess.Incidents().GetIncidentNotes(id)
+		if err != nil {
+			return err
+		}
+		if len(notes) > 0 {
+			e.IncidentNotes[id] = notes
+		}
+		snapshot, err := s.DataAccess.Incidents().GetIncidentSnapshot(id)
+		if err != nil {
+			return err
+		}
+		if snapshot != nil {
+			e.IncidentSnapshots[id] = snapshot
+		}
+	}
+	enc := json.NewEncoder(w)
+	return enc.Encode(e)
+}
+
+func (s *Schedule) exportSearch() (*SearchExport, error) {
+	search := s.DataAccess.Search()
+	metrics, err := search.GetAllMetrics()
+	if err != nil {
+		return nil, err
+	}
+	e := &SearchExport{
+		Metrics:       metrics,
+		TagKeys:       make(map[string]map[string]int64),
+		TagValues:     make(map[string]map[string]map[string]int64),
+		TagSets:       make(map[string]map[string]int64),
+		MetricsForTag: make(map[string]map[string]int64),
+	}
+	all := make(map[string]map[string]int64)
+	for metric := range metrics {
+		tagks, err := search.GetTagKeysForMetric(metric)
+		if err != nil {
+			return nil, err
+		}
+		e.TagKeys[metric] = tagks
+		e.TagValues[metric] = make(map[string]map[string]int64)
+		for tagk := range tagks {
+			if e.TagValues[metric][tagk], err = search.GetTagValues(metric, tagk); err != nil {
+				return nil, err
+			}
+			if all[tagk] == nil {
+				if all[tagk], err = search.GetTagValues(database.Search_All, tagk); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if e.TagSets[metric], err = search.GetMetricTagSets(metric, nil); err != nil {
+			return nil, err
+		}
+	}
+	e.TagValues[database.Search_All] = all
+	for tagk, values := range all {
+		for tagv := range values {
+			if e.MetricsForTag[tagk+"="+tagv], err = search.GetMetricsForTag(tagk, tagv); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return e, nil
+}
+
+// ImportState reads a StateExport as JSON from r and stores it in s's
+// database. s must be initialized but should not be running; the imported
+// state is used once it is loaded.
+func (s *Schedule) ImportState(r io.Reader) error {
+	var e StateExport
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return err
+	}
+	if e.Version != stateExportVersion {
+		return fmt.Errorf("unsupported state export version %d, expected %d", e.Version, stateExportVersion)
+	}
+	silences := make(map[string]*Silence, len(e.Silences))
+	for id, si := range e.Silences {
+		silences[id] = (*Silence)(si)
+	}
+	exclusions := make(map[string]*Exclusion, len(e.Exclusions))
+	for id, ex := range e.Exclusions {
+		exclusions[id] = (*Exclusion)(ex)
+	}
+	objects, err := encodeObjects(map[string]interface{}{
+		dbSilence:     silences,
+		dbIncidents:   e.Incidents,
+		dbExclusions:  exclusions,
+		dbMaintenance: e.Maintenance,
+		dbLastRuns:    e.LastRuns,
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.importObjects(objects, true); err != nil {
+		return err
+	}
+	states := make(map[string][]byte, len(e.AlertStates))
+	for ak, se := range e.AlertStates {
+		st, err := se.state()
+		if err != nil {
+			return fmt.Errorf("state of %s: %v", ak, err)
+		}
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(st); err != nil {
+			return fmt.Errorf("error encoding state of %s: %v", ak, err)
+		}
+		states[string(ak)] = buf.Bytes()
+	}
+	if len(states) > 0 {
+		if err := s.DataAccess.State().PutStates(states); err != nil {
+			return err
+		}
+	}
+	md := s.DataAccess.Metadata()
+	for metric, m := range e.MetricMetadata {
+		for field, v := range map[string]string{"desc": m.Desc, "unit": m.Unit, "rate": m.Rate} {
+			if v == "" {
+				continue
+			}
+			if err := md.PutMetricMetadata(metric, field, v); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tm := range e.TagMetadata {
+		if err := md.PutTagMetadata(tm.Tags, tm.Name, tm.Value, time.Unix(tm.LastTouched, 0)); err != nil {
+			return err
+		}
+	}
+	for ak, ns := range e.Notifications {
+		for name, due := range ns {
+			if err := s.DataAccess.Notifications().InsertNotification(ak, name, due); err != nil {
+				return err
+			}
+		}
+	}
+	for alert, n := range e.AlertNotes {
+		if err := s.DataAccess.AlertNotes().SetAlertNote(alert, n); err != nil {
+			return err
+		}
+	}
+	// Notes replace those already stored, so importing twice doesn't
+	// duplicate them.
+	for id, notes := range e.IncidentNotes {
+		if err := s.DataAccess.Incidents().SetIncidentNotes(id, notes); err != nil {
+			return err
+		}
+	}
+	for id, snapshot := range e.IncidentSnapshots {
+		if err := s.DataAccess.Incidents().SetIncidentSnapshot(snapshot, []uint64{id}); err != nil {
+			return err
+		}
+	}
+	if e.Search != nil {
+		if err := s.importSearch(e.Search); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schedule) importSearch(e *SearchExport) error {
+	search := s.DataAccess.Search()
+	for metric, t := range e.Metrics {
+		if err := search.AddMetric(metric, t); err != nil {
+			return err
+		}
+	}
+	for metric, tagks := range e.TagKeys {
+		for tagk, t := range tagks {
+			if err := search.AddTagKeyForMetric(metric, tagk, t); err != nil {
+				return err
+			}
+		}
+	}
+	for metric, tagks := range e.TagValues {
+		for tagk, values := range tagks {
+			for tagv, t := range values {
+				if err := search.AddTagValue(metric, tagk, tagv, t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for metric, sets := range e.TagSets {
+		for ts, t := range sets {
+			if err := search.AddMetricTagSet(metric, ts, t); err != nil {
+				return err
+			}
+		}
+	}
+	for tag, metrics := range e.MetricsForTag {
+		tags, err := opentsdb.ParseTags(tag)
+		if err != nil {
+			return err
+		}
+		for tagk, tagv := range tags {
+			for metric, t := range metrics {
+				if err := search.AddMetricForTag(tagk, tagv, metric, t); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
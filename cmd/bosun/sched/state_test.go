@@ -0,0 +1,303 @@
+package sched
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bosun.org/_third_party/github.com/boltdb/bolt"
+	"bosun.org/cmd/bosun/cache"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestExportImportState(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	var id uint64
+	for id = range s.Incidents {
+	}
+	if err := s.AddIncidentNote(id, "me", "disk full"); err != nil {
+		t.Fatal(err)
+	}
+	s.save()
+	if err := s.flushStates(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := s.ExportState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	export := buf.Bytes()
+
+	s2, err := initSched(s.Conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Importing twice must not duplicate anything.
+	for i := 0; i < 2; i++ {
+		if err := s2.ImportState(bytes.NewReader(export)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s2.RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+	st := s2.status["a{a=b}"]
+	if st == nil || st.Status() != StCritical {
+		t.Fatalf("expected a{a=b} to be critical, got %v", st)
+	}
+	if s2.Incidents[id] == nil {
+		t.Fatalf("expected incident %d", id)
+	}
+	notes, err := s2.GetIncidentNotes(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notes) != 1 || notes[0].Body != "disk full" {
+		t.Fatalf("unexpected incident notes: %v", notes)
+	}
+}
+
+func TestFlushStates(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	if n := s.pendingWrites(); n != 1 {
+		t.Fatalf("expected 1 pending write, got %d", n)
+	}
+	if err := s.flushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.pendingWrites(); n != 0 {
+		t.Fatalf("expected no pending writes after flush, got %d", n)
+	}
+	status, err := s.loadStates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := status["a{a=b}"]; st == nil || st.Status() != StCritical {
+		t.Fatalf("expected a{a=b} to be stored critical, got %v", st)
+	}
+
+	// A check that only touches the state doesn't write it.
+	rh := s.NewRunHistory(time.Now(), cache.New(0))
+	rh.Events["a{a=b}"] = &Event{Status: StCritical}
+	s.RunHistory(rh)
+	if n := s.pendingWrites(); n != 0 {
+		t.Fatalf("expected no pending writes after an unchanged check, got %d", n)
+	}
+	if st := s.GetStatus("a{a=b}"); !st.Touched.Equal(rh.Start) {
+		t.Fatalf("expected a{a=b} to be touched at %v, got %v", rh.Start, st.Touched)
+	}
+	rh = s.NewRunHistory(time.Now(), cache.New(0))
+	rh.Events["a{a=b}"] = &Event{Status: StNormal}
+	s.RunHistory(rh)
+	if n := s.pendingWrites(); n != 1 {
+		t.Fatalf("expected 1 pending write after a change, got %d", n)
+	}
+	if err := s.flushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = s.loadStates(); err != nil {
+		t.Fatal(err)
+	}
+	if st := status["a{a=b}"]; st == nil || st.Status() != StNormal {
+		t.Fatalf("expected a{a=b} to be stored normal, got %v", st)
+	}
+
+	// Forgotten states are removed.
+	s.Lock("test")
+	delete(s.status, "a{a=b}")
+	s.Unlock()
+	s.markDirty("a{a=b}")
+	if err := s.flushStates(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.DataAccess.(*nopDataAccess).states["a{a=b}"]; ok {
+		t.Fatal("expected a{a=b} to be removed from the database")
+	}
+}
+
+func TestMigrateStateFile(t *testing.T) {
+	c, err := conf.New("", `
+		alert a {
+			crit = 1
+		}
+		notification n {
+			print = true
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ak := expr.AlertKey("a{a=b}")
+	st := NewStatus(ak)
+	st.Append(&Event{Status: StCritical, Time: time.Now().UTC()})
+	due := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	objects := make(map[string][]byte)
+	for name, v := range map[string]interface{}{
+		dbStatus:        States{ak: st},
+		dbNotifications: map[expr.AlertKey]map[string]time.Time{ak: {"n": due}},
+		dbSilence:       map[string]*Silence{"x": {Start: time.Now(), End: time.Now().Add(time.Hour), Tags: opentsdb.TagSet{"a": "b"}}},
+	} {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if err := gob.NewEncoder(gz).Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		gz.Close()
+		objects[name] = buf.Bytes()
+	}
+	dir, err := ioutil.TempDir("", "bosun-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if s.db, err = bolt.Open(filepath.Join(dir, "old.state"), 0600, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.db.Close()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(dbBucket))
+		if err != nil {
+			return err
+		}
+		for name, data := range objects {
+			if err := b.Put([]byte(name), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RestoreState(); err != nil {
+		t.Fatal(err)
+	}
+	nop := s.DataAccess.(*nopDataAccess)
+	if _, ok := nop.states[string(ak)]; !ok {
+		t.Fatal("expected a{a=b} to be migrated to the database")
+	}
+	if !nop.notifications[string(ak)]["n"].Equal(due) {
+		t.Fatalf("expected notification to be migrated, got %v", nop.notifications)
+	}
+	if _, ok := nop.objects[dbSilence]; !ok {
+		t.Fatal("expected silences to be migrated to the database")
+	}
+	if st := s.status[ak]; st == nil || st.Status() != StCritical {
+		t.Fatalf("expected a{a=b} to be restored critical, got %v", st)
+	}
+	if s.Silence["x"] == nil {
+		t.Fatal("expected silence to be restored")
+	}
+
+	// The state file is only migrated once.
+	delete(nop.states, string(ak))
+	if err := s.MigrateStateFile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nop.states[string(ak)]; ok {
+		t.Fatal("expected the state file not to be migrated again")
+	}
+}
+
+func TestCheckConsistency(t *testing.T) {
+	s := testSched(t, &schedTest{
+		conf: `alert a {
+			crit = avg(q("avg:m{a=*}", "5m", "")) > 0
+		}`,
+		queries: map[string]opentsdb.ResponseSet{
+			`q("avg:m{a=*}", ` + window5Min + `)`: {
+				{
+					Metric: "m",
+					Tags:   opentsdb.TagSet{"a": "b"},
+					DPS:    map[string]opentsdb.Point{"0": 1},
+				},
+			},
+		},
+		state: map[schedState]bool{
+			schedState{"a{a=b}", "critical"}: true,
+		},
+	})
+	da := s.DataAccess.(*nopDataAccess)
+	da.states["gone{a=b}"] = []byte{}
+	s.status["removed{a=b}"] = &State{Alert: "removed", Group: opentsdb.TagSet{"a": "b"}}
+	da.InsertNotification("a{a=b}", "old", time.Now())
+	s.Silence["x"] = &Silence{Alert: "gone", End: time.Now().Add(time.Hour)}
+	s.Silence["y"] = &Silence{Alert: "a", End: time.Now().Add(time.Hour)}
+
+	r, err := s.CheckConsistency(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.States) != 2 || r.States[0] != "gone{a=b}" || r.States[1] != "removed{a=b}" {
+		t.Errorf("unexpected states: %v", r.States)
+	}
+	if ns := r.Notifications["a{a=b}"]; len(r.Notifications) != 1 || len(ns) != 1 || ns[0] != "old" {
+		t.Errorf("unexpected notifications: %v", r.Notifications)
+	}
+	if len(r.Silences) != 1 || r.Silences[0] != "x" {
+		t.Errorf("unexpected silences: %v", r.Silences)
+	}
+	if s.status["removed{a=b}"] == nil || s.Silence["x"] == nil {
+		t.Fatal("expected check without clean to change nothing")
+	}
+
+	if _, err := s.CheckConsistency(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.flushStates(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = s.CheckConsistency(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Empty() {
+		t.Fatalf("expected clean to remove everything, got %+v", r)
+	}
+	if s.Silence["y"] == nil || s.status["a{a=b}"] == nil {
+		t.Fatal("expected known silence and state to be kept")
+	}
+}
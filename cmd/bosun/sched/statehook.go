@@ -0,0 +1,122 @@
+package sched
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.statechangehook.sent", metadata.Counter, metadata.Count,
+		"Number of state changes POSTed to the stateChangeHook.")
+	metadata.AddMetricMeta("bosun.statechangehook.failed", metadata.Counter, metadata.Count,
+		"Number of state changes not POSTed to the stateChangeHook after all retries.")
+	metadata.AddMetricMeta("bosun.statechangehook.dropped", metadata.Counter, metadata.Count,
+		"Number of state changes dropped because the stateChangeHook queue was full.")
+}
+
+const (
+	// stateChangeBuffer is how many state changes are queued for the
+	// stateChangeHook before new ones are dropped.
+	stateChangeBuffer = 10000
+	// stateChangeRetries is how many times a failed POST is retried, waiting
+	// stateChangeBackoff, doubled after each attempt, in between.
+	stateChangeRetries = 5
+	stateChangeBackoff = time.Second
+)
+
+// StateChange is the JSON document POSTed to the stateChangeHook.
+type StateChange struct {
+	AlertKey   expr.AlertKey
+	Alert      string
+	Tags       opentsdb.TagSet
+	From       Status
+	To         Status
+	IncidentId uint64
+	Time       time.Time
+}
+
+// stateChangeHook is a Hook that POSTs every alert status change to a URL,
+// in order. Changes are queued so slow or failing receivers don't delay
+// checks, and failed POSTs are retried.
+type stateChangeHook struct {
+	NopHook
+	url     string
+	client  *http.Client
+	backoff time.Duration
+	queue   chan *StateChange
+}
+
+func newStateChangeHook(url string) *stateChangeHook {
+	h := &stateChangeHook{
+		url:     url,
+		client:  &http.Client{Timeout: time.Minute},
+		backoff: stateChangeBackoff,
+		queue:   make(chan *StateChange, stateChangeBuffer),
+	}
+	go h.run()
+	return h
+}
+
+func (h *stateChangeHook) OnStateChange(ak expr.AlertKey, from Status, event Event) {
+	sc := &StateChange{
+		AlertKey:   ak,
+		Alert:      ak.Name(),
+		Tags:       ak.Group(),
+		From:       from,
+		To:         event.Status,
+		IncidentId: event.IncidentId,
+		Time:       event.Time,
+	}
+	select {
+	case h.queue <- sc:
+	default:
+		slog.Errorf("state change hook queue full, dropping change of %s to %s", ak, event.Status)
+		collect.Add("statechangehook.dropped", nil, 1)
+	}
+}
+
+func (h *stateChangeHook) run() {
+	for sc := range h.queue {
+		backoff := h.backoff
+		for attempt := 0; ; attempt++ {
+			err := h.post(sc)
+			if err == nil {
+				collect.Add("statechangehook.sent", nil, 1)
+				break
+			}
+			if attempt == stateChangeRetries {
+				slog.Errorf("state change hook: giving up on change of %s to %s: %v", sc.AlertKey, sc.To, err)
+				collect.Add("statechangehook.failed", nil, 1)
+				break
+			}
+			slog.Warningf("state change hook: retrying change of %s to %s in %v: %v", sc.AlertKey, sc.To, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+}
+
+func (h *stateChangeHook) post(sc *StateChange) error {
+	b, err := json.Marshal(sc)
+	if err != nil {
+		return err
+	}
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bad response: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package sched
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestStateChangeHook(t *testing.T) {
+	type stateChange struct {
+		StateChange
+		From, To string
+	}
+	got := make(chan stateChange, 2)
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var sc stateChange
+		if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+			t.Error(err)
+		}
+		got <- sc
+	}))
+	defer ts.Close()
+	h := &stateChangeHook{
+		url:     ts.URL,
+		client:  http.DefaultClient,
+		backoff: time.Millisecond,
+		queue:   make(chan *StateChange, 2),
+	}
+	go h.run()
+	ak := expr.NewAlertKey("a", opentsdb.TagSet{"host": "ny-1"})
+	now := time.Now().UTC().Truncate(time.Second)
+	h.OnStateChange(ak, StNormal, Event{Status: StCritical, IncidentId: 2, Time: now})
+	h.OnStateChange(ak, StCritical, Event{Status: StNormal, IncidentId: 2, Time: now})
+	for i, c := range []struct{ from, to string }{{"normal", "critical"}, {"critical", "normal"}} {
+		select {
+		case sc := <-got:
+			if sc.AlertKey != ak || sc.Alert != "a" || sc.Tags["host"] != "ny-1" || sc.From != c.from || sc.To != c.to || sc.IncidentId != 2 || !sc.Time.Equal(now) {
+				t.Errorf("%d: unexpected state change %+v", i, sc)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: timed out waiting for state change", i)
+		}
+	}
+}
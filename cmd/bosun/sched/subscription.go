@@ -0,0 +1,174 @@
+package sched
+
+import (
+	"fmt"
+	"html"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+// AddSubscription validates sub and stores it, assigning its Id.
+func (s *Schedule) AddSubscription(sub *models.Subscription) error {
+	if sub.User == "" {
+		return fmt.Errorf("subscriptions need a user")
+	}
+	if sub.Alert == "" && sub.Tags == "" {
+		return fmt.Errorf("subscriptions need an alert or tags")
+	}
+	if sub.Alert != "" && s.Conf.Alerts[sub.Alert] == nil {
+		return fmt.Errorf("unknown alert: %s", sub.Alert)
+	}
+	if sub.Tags != "" {
+		// As for silences, tag values are patterns, so only malformed tag
+		// lists are errors.
+		if tags, err := opentsdb.ParseTags(sub.Tags); tags == nil {
+			return err
+		}
+	}
+	if sub.Email == "" && sub.Post == "" {
+		return fmt.Errorf("subscriptions need an email or post")
+	}
+	if _, err := subscriptionNotification(sub); err != nil {
+		return err
+	}
+	sub.Id = 0
+	sub.Created = time.Now().UTC()
+	return s.DataAccess.Subscriptions().AddSubscription(sub)
+}
+
+// Subscriptions returns the subscriptions of user, or of all users if user
+// is "".
+func (s *Schedule) Subscriptions(user string) ([]*models.Subscription, error) {
+	subs, err := s.DataAccess.Subscriptions().GetSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	l := []*models.Subscription{}
+	for _, sub := range subs {
+		if user == "" || sub.User == user {
+			l = append(l, sub)
+		}
+	}
+	return l, nil
+}
+
+// DeleteSubscription removes the subscription with the given id. If user is
+// not "", the subscription must be theirs.
+func (s *Schedule) DeleteSubscription(id int64, user string) error {
+	subs, err := s.DataAccess.Subscriptions().GetSubscriptions()
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		if sub.Id != id {
+			continue
+		}
+		if user != "" && sub.User != user {
+			return fmt.Errorf("subscription %d belongs to %s", id, sub.User)
+		}
+		return s.DataAccess.Subscriptions().DeleteSubscription(id)
+	}
+	return fmt.Errorf("subscription %d not found", id)
+}
+
+// subscriptionMatches returns whether sub watches ak.
+func subscriptionMatches(sub *models.Subscription, ak expr.AlertKey) bool {
+	if sub.Alert != "" && sub.Alert != ak.Name() {
+		return false
+	}
+	if sub.Tags == "" {
+		return true
+	}
+	patterns, _ := opentsdb.ParseTags(sub.Tags)
+	if patterns == nil {
+		return false
+	}
+	tags := ak.Group()
+	for k, pattern := range patterns {
+		tagv, ok := tags[k]
+		if !ok {
+			return false
+		}
+		if matched, _ := Match(pattern, tagv); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// subscriptionNotification returns the notification sub is sent with.
+func subscriptionNotification(sub *models.Subscription) (*conf.Notification, error) {
+	n := &conf.Notification{Name: fmt.Sprintf("subscription:%d", sub.Id)}
+	if sub.Email != "" {
+		a, err := mail.ParseAddress(sub.Email)
+		if err != nil {
+			return nil, err
+		}
+		n.Email = []*mail.Address{a}
+	}
+	if sub.Post != "" {
+		u, err := url.Parse(sub.Post)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("subscription post URLs must be http or https")
+		}
+		n.Post = u
+	}
+	return n, nil
+}
+
+// subscriptionHook notifies subscribers of the state changes of the alert
+// keys they watch.
+type subscriptionHook struct {
+	NopHook
+	s *Schedule
+}
+
+func (h *subscriptionHook) OnStateChange(ak expr.AlertKey, from Status, event Event) {
+	s := h.s
+	if s.Conf.Quiet || !s.IsLeader() || (from == StNone && event.Status == StNormal) {
+		return
+	}
+	subs, err := s.DataAccess.Subscriptions().GetSubscriptions()
+	if err != nil {
+		slog.Errorln("error getting subscriptions:", err)
+		return
+	}
+	var subject, body string
+	for _, sub := range subs {
+		if !subscriptionMatches(sub, ak) {
+			continue
+		}
+		n, err := subscriptionNotification(sub)
+		if err != nil {
+			slog.Errorf("subscription %d: %v", sub.Id, err)
+			continue
+		}
+		if subject == "" {
+			subject, body = s.subscriptionMessage(ak, from, event)
+		}
+		s.deliver(n, nil, string(ak), subject, body, []byte(subject), []byte(body))
+	}
+}
+
+// subscriptionMessage returns the subject and body that tell subscribers of
+// a change of ak from from to event's status.
+func (s *Schedule) subscriptionMessage(ak expr.AlertKey, from Status, event Event) (subject, body string) {
+	subject = fmt.Sprintf("[watch] %s is %s", ak, event.Status)
+	body = fmt.Sprintf("<p>%s changed from %s to %s at %s.</p>",
+		html.EscapeString(string(ak)), from, event.Status, event.Time.UTC().Format(time.RFC1123))
+	if event.IncidentId != 0 {
+		link := s.Conf.MakeLink("/incident", &url.Values{"id": []string{fmt.Sprint(event.IncidentId)}})
+		body += fmt.Sprintf(`<p><a href="%s">Incident #%d</a></p>`, html.EscapeString(link), event.IncidentId)
+	}
+	return subject, body
+}
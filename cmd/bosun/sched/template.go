@@ -15,9 +15,10 @@ import (
 
 	"bosun.org/_third_party/github.com/aymerick/douceur/inliner"
 	"bosun.org/_third_party/github.com/jmoiron/jsonq"
+	"bosun.org/_third_party/github.com/vdobler/chart"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
-	"bosun.org/cmd/bosun/expr/parse"
+	"bosun.org/expr"
+	"bosun.org/expr/parse"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
 )
@@ -30,6 +31,9 @@ type Context struct {
 	schedule    *Schedule
 	runHistory  *RunHistory
 	Attachments []*conf.Attachment
+
+	// queries is the number of Recent queries run by this render.
+	queries int
 }
 
 func (s *Schedule) Data(rh *RunHistory, st *State, a *conf.Alert, isEmail bool) *Context {
@@ -60,6 +64,118 @@ func (s *Schedule) unknownData(t time.Time, name string, group expr.AlertKeys) *
 	}
 }
 
+type unknownGroupContext struct {
+	unknownContext
+	// States are the states of the alert keys in Group.
+	States []*State
+}
+
+func (s *Schedule) unknownGroupData(t time.Time, name string, group expr.AlertKeys, states States) *unknownGroupContext {
+	c := &unknownGroupContext{
+		unknownContext: *s.unknownData(t, name, group),
+	}
+	for _, ak := range group {
+		if st := states[ak]; st != nil {
+			c.States = append(c.States, st)
+		}
+	}
+	return c
+}
+
+// StatusCounts returns the number of states with each current status.
+func (c *unknownGroupContext) StatusCounts() map[string]int {
+	counts := make(map[string]int)
+	for _, st := range c.States {
+		counts[st.Status().String()]++
+	}
+	return counts
+}
+
+// CommonTags returns the tags shared by every state in the group.
+func (c *unknownGroupContext) CommonTags() opentsdb.TagSet {
+	if len(c.States) == 0 {
+		return opentsdb.TagSet{}
+	}
+	tags := c.States[0].Group.Copy()
+	for _, st := range c.States[1:] {
+		tags = tags.Intersection(st.Group)
+	}
+	return tags
+}
+
+// Min returns the smallest last known value of the states, or NaN if none
+// have one.
+func (c *unknownGroupContext) Min() float64 {
+	return c.reduce(math.Min)
+}
+
+// Max returns the largest last known value of the states, or NaN if none
+// have one.
+func (c *unknownGroupContext) Max() float64 {
+	return c.reduce(math.Max)
+}
+
+func (c *unknownGroupContext) reduce(f func(a, b float64) float64) float64 {
+	r := math.NaN()
+	for _, st := range c.States {
+		v, ok := st.lastValue()
+		if !ok {
+			continue
+		}
+		if math.IsNaN(r) {
+			r = v
+		} else {
+			r = f(r, v)
+		}
+	}
+	return r
+}
+
+// lastValue returns the most recent renotifyValue, critical, or warning value
+// of st.
+func (st *State) lastValue() (float64, bool) {
+	for i := len(st.History) - 1; i >= 0; i-- {
+		e := st.History[i]
+		if e.Value != nil {
+			return *e.Value, true
+		}
+		for _, r := range []*Result{e.Crit, e.Warn} {
+			if r == nil || r.Result == nil {
+				continue
+			}
+			switch v := r.Value.(type) {
+			case expr.Number:
+				return float64(v), true
+			case expr.Scalar:
+				return float64(v), true
+			}
+		}
+	}
+	return 0, false
+}
+
+type unknownDigestContext struct {
+	Time time.Time
+	// Groups maps each group name to its unknown alert keys.
+	Groups map[string]expr.AlertKeys
+	// Total is the number of unknown alert keys in all groups.
+	Total int
+
+	schedule *Schedule
+}
+
+func (s *Schedule) unknownDigestData(t time.Time, groups map[string]expr.AlertKeys) *unknownDigestContext {
+	c := &unknownDigestContext{
+		Time:     t,
+		Groups:   groups,
+		schedule: s,
+	}
+	for _, g := range groups {
+		c.Total += len(g)
+	}
+	return c
+}
+
 // Ack returns the URL to acknowledge an alert.
 func (c *Context) Ack() string {
 	return c.schedule.Conf.MakeLink("/action", &url.Values{
@@ -76,13 +192,14 @@ func (c *Context) HostView(host string) string {
 	})
 }
 
-// Expr takes an expression in the form of a string, changes the tags to
-// match the context of the alert, and returns a link to the expression page.
+// Expr takes an expression in the form of a string, changes the tags and
+// $tagk variables to match the context of the alert, and returns a link to
+// the expression page.
 func (c *Context) Expr(v string) string {
 	p := url.Values{}
 	p.Add("date", c.runHistory.Start.Format(`2006-01-02`))
 	p.Add("time", c.runHistory.Start.Format(`15:04:05`))
-	p.Add("expr", base64.StdEncoding.EncodeToString([]byte(opentsdb.ReplaceTags(v, c.Group))))
+	p.Add("expr", base64.StdEncoding.EncodeToString([]byte(filterExpr(v, c.Group))))
 	return c.schedule.Conf.MakeLink("/expr", &p)
 }
 
@@ -107,6 +224,14 @@ func (c *Context) Rule() (string, error) {
 	return c.schedule.Conf.MakeLink("/config", &p), nil
 }
 
+// Note returns the text of the note attached to the alert, if any.
+func (c *Context) Note() string {
+	if note := c.schedule.GetAlertNote(c.Alert.Name); note != nil {
+		return note.Text
+	}
+	return ""
+}
+
 func (c *Context) Incident() string {
 	return c.schedule.Conf.MakeLink("/incident", &url.Values{
 		"id": []string{fmt.Sprint(c.State.Last().IncidentId)},
@@ -194,7 +319,7 @@ func (s *Schedule) ExecuteBadTemplate(s_err, b_err error, rh *RunHistory, a *con
 func (c *Context) evalExpr(e *expr.Expr, filter bool, series bool, autods int) (expr.ResultSlice, string, error) {
 	var err error
 	if filter {
-		e, err = expr.New(opentsdb.ReplaceTags(e.Text, c.State.Group), c.schedule.Conf.Funcs())
+		e, err = expr.New(filterExpr(e.Text, c.State.Group), c.schedule.Conf.Funcs())
 		if err != nil {
 			return nil, "", err
 		}
@@ -202,13 +327,20 @@ func (c *Context) evalExpr(e *expr.Expr, filter bool, series bool, autods int) (
 	if series && e.Root.Return() != parse.TypeSeriesSet {
 		return nil, "", fmt.Errorf("need a series, got %T (%v)", e, e)
 	}
-	res, _, err := e.Execute(c.runHistory.Context, c.runHistory.GraphiteContext, c.runHistory.Logstash, c.runHistory.InfluxConfig, c.runHistory.Cache, nil, c.runHistory.Start, autods, c.Alert.UnjoinedOK, c.schedule.Search, c.schedule.Conf.AlertSquelched(c.Alert), c.runHistory)
+	res, _, err := e.Execute(c.runHistory.Context, c.runHistory.GraphiteContext, c.runHistory.Logstash, c.runHistory.InfluxConfig, c.runHistory.SQL, c.runHistory.CloudWatch, c.runHistory.Cache, nil, c.runHistory.Start, autods, c.Alert.UnjoinedOK, c.schedule.Search, c.schedule.Conf.AlertSquelched(c.Alert), c.runHistory, c.schedule.DataAccess.Events())
 	if err != nil {
 		return nil, "", fmt.Errorf("%s: %v", e, err)
 	}
 	return res.Results, e.String(), nil
 }
 
+// filterExpr restricts the queries in text to group. OpenTSDB tag sets are
+// replaced with the matching tags of group, and $tagk variables, such as in
+// graphite targets, are replaced with the value of tagk.
+func filterExpr(text string, group opentsdb.TagSet) string {
+	return opentsdb.ReplaceTagVars(opentsdb.ReplaceTags(text, group), group)
+}
+
 // eval takes an expression or string (which it turns into an expression), executes it and returns the result.
 // It can also takes a ResultSlice so callers can transparantly handle different inputs.
 // The filter argument constrains the result to matching tags in the current context.
@@ -284,6 +416,35 @@ func (c *Context) Eval(v interface{}) (interface{}, error) {
 	return res[0].Value, nil
 }
 
+// Recent returns the most recent value of an OpenTSDB query over duration,
+// restricted to the context's tags, or NaN if there is none. It is for
+// including related current values, such as inode usage in a disk space
+// alert. A render may run at most templateQueryLimit queries, each bounded by
+// templateQueryTimeout. Results are cached for the check cycle, so renders
+// of other alert keys and re-renders do not query again.
+func (c *Context) Recent(query, duration string) (interface{}, error) {
+	limit, timeout := c.schedule.Conf.TemplateQueryLimit, c.schedule.Conf.TemplateQueryTimeout
+	if c.queries >= limit {
+		return nil, fmt.Errorf("template query limit of %d reached", limit)
+	}
+	c.queries++
+	// Eval is given the parsed expression, so its errors, such as the
+	// timeout, are returned.
+	e, err := expr.New(fmt.Sprintf(`last(q(%q, %q, ""))`, query, duration), c.schedule.Conf.Funcs())
+	if err != nil {
+		return nil, err
+	}
+	// The query itself is bounded, so nothing is left running when it
+	// times out.
+	rh := *c.runHistory
+	rh.Context = c.schedule.Conf.TSDBContextTimeout(timeout)
+	defer func(orig *RunHistory) {
+		c.runHistory = orig
+	}(c.runHistory)
+	c.runHistory = &rh
+	return c.Eval(e)
+}
+
 // EvalAll returns the executed expression (or the given result as is).
 func (c *Context) EvalAll(v interface{}) (interface{}, error) {
 	res, _, err := c.eval(v, false, false, 0)
@@ -301,15 +462,25 @@ func (c *Context) graph(v interface{}, unit string, filter bool) (val interface{
 	if err != nil {
 		return nil, err
 	}
+	labels := make([]string, len(res))
+	for i, r := range res {
+		labels[i] = r.Group.String()
+	}
+	return c.renderGraph(exprChart(unit, res, labels, nil), exprText, fmt.Sprint(v))
+}
+
+// renderGraph embeds ch, linking to the graph of exprText. Emails get a PNG
+// attachment, since clients don't render SVG.
+func (c *Context) renderGraph(ch chart.Chart, exprText, alt string) (interface{}, error) {
 	var buf bytes.Buffer
 	const width = 800
 	const height = 600
 	footerHTML := fmt.Sprintf(`<p><small>Query: %s<br>Time: %s</small></p>`,
-		template.HTMLEscapeString(exprText),
+		strings.Replace(template.HTMLEscapeString(exprText), "\n", "<br>", -1),
 		c.runHistory.Start.Format(time.RFC3339))
+	link := c.GraphLink(strings.SplitN(exprText, "\n", 2)[0])
 	if c.IsEmail {
-		err := c.schedule.ExprPNG(nil, &buf, width, height, unit, res)
-		if err != nil {
+		if err := chartPNG(&buf, width, height, ch); err != nil {
 			return nil, err
 		}
 		name := fmt.Sprintf("%d.png", len(c.Attachments)+1)
@@ -319,21 +490,71 @@ func (c *Context) graph(v interface{}, unit string, filter bool) (val interface{
 			ContentType: "image/png",
 		})
 		return template.HTML(fmt.Sprintf(`<a href="%s" style="text-decoration: none"><img alt="%s" src="cid:%s" /></a>%s`,
-			c.GraphLink(exprText),
-			template.HTMLEscapeString(fmt.Sprint(v)),
+			link,
+			template.HTMLEscapeString(alt),
 			name,
 			footerHTML,
 		)), nil
 	}
-	buf.WriteString(fmt.Sprintf(`<a href="%s" style="text-decoration: none">`, c.GraphLink(exprText)))
-	if err := c.schedule.ExprSVG(nil, &buf, width, height, unit, res); err != nil {
-		return nil, err
-	}
+	buf.WriteString(fmt.Sprintf(`<a href="%s" style="text-decoration: none">`, link))
+	chartSVG(&buf, width, height, ch)
 	buf.WriteString(`</a>`)
 	buf.WriteString(footerHTML)
 	return template.HTML(buf.String()), nil
 }
 
+// GraphMulti returns a graph of several expressions (strings or expressions)
+// with tags identical to the context's tags. The alert's warn and crit
+// thresholds are drawn as bands and the time it triggered is marked, so the
+// graph stands on its own in notifications.
+func (c *Context) GraphMulti(unit string, exprs ...interface{}) (val interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("panic rendering graph", p)
+			val = "error rendering graph"
+		}
+	}()
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("GraphMulti: no expressions")
+	}
+	var res expr.ResultSlice
+	var labels, texts []string
+	for i, v := range exprs {
+		r, text, err := c.eval(v, true, true, 1000)
+		if err != nil {
+			return nil, err
+		}
+		for _, x := range r {
+			res = append(res, x)
+			labels = append(labels, fmt.Sprintf("%d: %s", i+1, x.Group))
+		}
+		texts = append(texts, text)
+	}
+	marks := &graphMarks{
+		Warn:    threshold(c.Alert.Warn),
+		Crit:    threshold(c.Alert.Crit),
+		Trigger: c.triggerTime(),
+	}
+	return c.renderGraph(exprChart(unit, res, labels, marks), strings.Join(texts, "\n"), strings.Join(texts, ", "))
+}
+
+// triggerTime returns when the context's alert key became its current
+// abnormal status, or zero if it is normal.
+func (c *Context) triggerTime() time.Time {
+	if c.State == nil || len(c.History) == 0 {
+		return time.Time{}
+	}
+	last := c.History[len(c.History)-1]
+	if last.Status <= StNormal {
+		return time.Time{}
+	}
+	t := last.Time
+	for i := len(c.History) - 2; i >= 0 && c.History[i].Status == last.Status; i-- {
+		t = c.History[i].Time
+	}
+	return t
+}
+
 // Graph returns an SVG for the given result (or expression, for which it gets the result)
 // with same tags as the context's tags.
 func (c *Context) Graph(v interface{}, args ...string) (interface{}, error) {
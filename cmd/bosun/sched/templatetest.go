@@ -0,0 +1,144 @@
+package sched
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+// TemplateTest is synthetic state of an alert key to render its alert's
+// template with, so template authors can try branches that real alerts
+// rarely take.
+type TemplateTest struct {
+	// Group is the alert key's tags.
+	Group opentsdb.TagSet
+	// Status is normal, warning, critical, or unknown. Unknown alert keys
+	// are rendered with the unknownTemplate.
+	Status string
+	// Value is the result of the warn or crit expression.
+	Value float64
+	// Computations are the steps of the warn or crit expression.
+	Computations expr.Computations
+	// Time is when the status began, now if zero.
+	Time time.Time
+}
+
+// TemplateTestResult is the notification a TemplateTest renders. If the
+// template failed, Subject and Body are the error notification bosun sends
+// instead, and Errors holds the template errors.
+type TemplateTestResult struct {
+	Subject, Body           string
+	EmailSubject, EmailBody string
+	Errors                  []string `json:",omitempty"`
+}
+
+var templateTestStatuses = map[string]Status{
+	"normal":   StNormal,
+	"warning":  StWarning,
+	"critical": StCritical,
+	"unknown":  StUnknown,
+}
+
+// RenderTemplateTest renders the notification of a's alert key in the state
+// described by tt.
+func (s *Schedule) RenderTemplateTest(a *conf.Alert, tt *TemplateTest) (*TemplateTestResult, error) {
+	status, ok := templateTestStatuses[tt.Status]
+	if !ok {
+		return nil, fmt.Errorf("unknown status: %q", tt.Status)
+	}
+	now := tt.Time
+	if now.IsZero() {
+		now = time.Now().UTC()
+	}
+	ak := expr.NewAlertKey(a.Name, tt.Group)
+	res := &Result{
+		Result: &expr.Result{
+			Computations: tt.Computations,
+			Value:        expr.Number(tt.Value),
+			Group:        tt.Group,
+		},
+	}
+	event := Event{Status: status, Time: now}
+	switch status {
+	case StCritical:
+		event.Crit = res
+		if a.Crit != nil {
+			res.Expr = a.Crit.String()
+		}
+	case StWarning:
+		event.Warn = res
+		if a.Warn != nil {
+			res.Expr = a.Warn.String()
+		}
+	}
+	st := NewStatus(ak)
+	st.Result = res
+	st.History = []Event{event}
+	st.Severity = severity(a, status)
+	rh := s.NewRunHistory(now, nil)
+	rh.Events[ak] = &event
+
+	r := new(TemplateTestResult)
+	if status == StUnknown {
+		t := s.Conf.UnknownTemplate
+		if t == nil {
+			t = defaultUnknownTemplate
+		}
+		subject, body := new(bytes.Buffer), new(bytes.Buffer)
+		data := s.unknownData(now, a.Name, expr.AlertKeys{ak})
+		if t.Subject != nil {
+			if err := t.Subject.Execute(subject, data); err != nil {
+				r.Errors = append(r.Errors, err.Error())
+			}
+		}
+		if t.Body != nil {
+			if err := t.Body.Execute(body, data); err != nil {
+				r.Errors = append(r.Errors, err.Error())
+			}
+		}
+		r.Subject, r.Body = subject.String(), body.String()
+		r.EmailSubject, r.EmailBody = r.Subject, r.Body
+		return r, nil
+	}
+	render := func(isEmail bool) (subject, body []byte) {
+		var serr, berr error
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					serr = fmt.Errorf("%v", err)
+				}
+			}()
+			subject, serr = s.ExecuteSubject(rh, a, st, isEmail)
+		}()
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					berr = fmt.Errorf("%v", err)
+				}
+			}()
+			body, _, berr = s.ExecuteBody(rh, a, st, isEmail)
+		}()
+		if serr == nil && berr == nil {
+			return subject, body
+		}
+		for _, err := range []error{serr, berr} {
+			if err != nil && !isEmail {
+				r.Errors = append(r.Errors, err.Error())
+			}
+		}
+		subject, body, err := s.ExecuteBadTemplate(serr, berr, rh, a, st)
+		if err != nil {
+			subject = []byte(fmt.Sprintf("unable to create template error notification: %v", err))
+		}
+		return subject, body
+	}
+	subject, body := render(false)
+	r.Subject, r.Body = string(subject), string(body)
+	subject, body = render(true)
+	r.EmailSubject, r.EmailBody = string(subject), string(body)
+	return r, nil
+}
@@ -0,0 +1,393 @@
+package sched
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/collect"
+	"bosun.org/expr"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.ticket.created", metadata.Counter, metadata.Count,
+		"Number of tickets created for incidents.")
+	metadata.AddMetricMeta("bosun.ticket.closed", metadata.Counter, metadata.Count,
+		"Number of tickets closed because their incidents were.")
+	metadata.AddMetricMeta("bosun.ticket.failed", metadata.Counter, metadata.Count,
+		"Number of tickets not created or closed after all retries.")
+}
+
+const (
+	// ticketBuffer is how many ticket changes are queued before new ones
+	// are dropped.
+	ticketBuffer = 1000
+	// ticketRetries is how many times a failed request is retried, waiting
+	// ticketBackoff, doubled after each attempt, in between.
+	ticketRetries = 5
+	ticketBackoff = time.Second
+)
+
+// IncidentTicket is a ticket opened for an incident.
+type IncidentTicket struct {
+	Id string
+	// Closed is set once the ticket is closed, by bosun or in the
+	// ticketing system.
+	Closed bool `json:",omitempty"`
+}
+
+// TicketContext is the data the templates of a ticketing system are rendered
+// with.
+type TicketContext struct {
+	Incident Incident
+	Alert    string
+	Tags     opentsdb.TagSet
+	Status   Status
+	Severity conf.Severity
+	Subject  string
+	// URL is the incident's page.
+	URL string
+	// Ticket is the id of the ticket, or "" when it is created.
+	Ticket string
+}
+
+// ticketJob creates, or closes, the ticket of an incident.
+type ticketJob struct {
+	ticket   *conf.Ticket
+	incident uint64
+	close    bool
+}
+
+// ticketHook is a Hook that opens a ticket in each configured ticketing
+// system for incidents once their severity reaches its minSeverity, and
+// closes it when the incident closes. Requests are queued so slow ticketing
+// systems don't delay checks, and failed requests are retried.
+type ticketHook struct {
+	NopHook
+	s       *Schedule
+	client  *http.Client
+	backoff time.Duration
+	queue   chan *ticketJob
+
+	// creating are the tickets being created, by incident and name, so an
+	// incident is only queued once.
+	creating map[string]bool
+	sync.Mutex
+}
+
+func newTicketHook(s *Schedule) *ticketHook {
+	h := &ticketHook{
+		s:        s,
+		client:   &http.Client{Timeout: time.Minute},
+		backoff:  ticketBackoff,
+		queue:    make(chan *ticketJob, ticketBuffer),
+		creating: make(map[string]bool),
+	}
+	go h.run()
+	return h
+}
+
+func (h *ticketHook) OnStateChange(ak expr.AlertKey, from Status, event Event) {
+	a := h.s.Conf.Alerts[ak.Name()]
+	if event.IncidentId == 0 || a == nil {
+		return
+	}
+	sev := severity(a, event.Status)
+	tickets := h.s.incidentTickets(event.IncidentId)
+	for name, t := range h.s.Conf.Tickets {
+		if sev < t.MinSeverity || tickets[name] != nil {
+			continue
+		}
+		key := fmt.Sprint(event.IncidentId, name)
+		h.Lock()
+		queued := h.creating[key]
+		h.creating[key] = true
+		h.Unlock()
+		if !queued {
+			h.enqueue(&ticketJob{ticket: t, incident: event.IncidentId})
+		}
+	}
+}
+
+func (h *ticketHook) OnIncidentClose(incident Incident) {
+	for name, tk := range h.s.incidentTickets(incident.Id) {
+		if t := h.s.Conf.Tickets[name]; t != nil && !tk.Closed {
+			h.enqueue(&ticketJob{ticket: t, incident: incident.Id, close: true})
+		}
+	}
+}
+
+func (h *ticketHook) enqueue(j *ticketJob) {
+	select {
+	case h.queue <- j:
+	default:
+		slog.Errorf("ticket queue full, dropping ticket %s of incident %d", j.ticket.Name, j.incident)
+		collect.Add("ticket.failed", opentsdb.TagSet{"ticket": j.ticket.Name}, 1)
+	}
+}
+
+func (h *ticketHook) run() {
+	for j := range h.queue {
+		backoff := h.backoff
+		for attempt := 0; ; attempt++ {
+			var err error
+			if j.close {
+				err = h.close(j.ticket, j.incident)
+			} else {
+				err = h.create(j.ticket, j.incident)
+			}
+			if err == nil {
+				break
+			}
+			if attempt == ticketRetries {
+				slog.Errorf("ticket %s of incident %d: giving up: %v", j.ticket.Name, j.incident, err)
+				collect.Add("ticket.failed", opentsdb.TagSet{"ticket": j.ticket.Name}, 1)
+				break
+			}
+			slog.Warningf("ticket %s of incident %d: retrying in %v: %v", j.ticket.Name, j.incident, backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if !j.close {
+			h.Lock()
+			delete(h.creating, fmt.Sprint(j.incident, j.ticket.Name))
+			h.Unlock()
+		}
+	}
+}
+
+// create opens a ticket for incident id in t and records it on the incident.
+func (h *ticketHook) create(t *conf.Ticket, id uint64) error {
+	ctx, err := h.s.ticketContext(id, "")
+	if err != nil {
+		return err
+	}
+	body := new(bytes.Buffer)
+	if err := t.Body.Execute(body, ctx); err != nil {
+		return err
+	}
+	resp, err := h.send(t, "POST", t.Post.String(), body)
+	if err != nil {
+		return err
+	}
+	ticket, err := jsonField(resp, t.IDField)
+	if err != nil {
+		return err
+	}
+	closed := h.s.setIncidentTicket(id, t.Name, ticket)
+	collect.Add("ticket.created", opentsdb.TagSet{"ticket": t.Name}, 1)
+	if err := h.s.AddIncidentNote(id, "bosun", fmt.Sprintf("Opened %s ticket %s.", t.Name, ticket)); err != nil {
+		slog.Errorln(err)
+	}
+	// The incident closed while the ticket was being created.
+	if closed {
+		h.enqueue(&ticketJob{ticket: t, incident: id, close: true})
+	}
+	return nil
+}
+
+// close closes the ticket of incident id in t.
+func (h *ticketHook) close(t *conf.Ticket, id uint64) error {
+	tk := h.s.incidentTickets(id)[t.Name]
+	if tk == nil || tk.Closed {
+		return nil
+	}
+	ctx, err := h.s.ticketContext(id, tk.Id)
+	if err != nil {
+		return err
+	}
+	u := new(bytes.Buffer)
+	if err := t.Close.Execute(u, ctx); err != nil {
+		return err
+	}
+	body := new(bytes.Buffer)
+	if t.CloseBody != nil {
+		if err := t.CloseBody.Execute(body, ctx); err != nil {
+			return err
+		}
+	}
+	if _, err := h.send(t, t.CloseMethod, strings.TrimSpace(u.String()), body); err != nil {
+		return err
+	}
+	h.s.closeIncidentTicket(id, t.Name)
+	collect.Add("ticket.closed", opentsdb.TagSet{"ticket": t.Name}, 1)
+	return nil
+}
+
+// maxTicketResponse limits the size of a ticketing system's response.
+const maxTicketResponse = 1 << 20
+
+// send makes a request to t, and returns its response body.
+func (h *ticketHook) send(t *conf.Ticket, method, rawurl string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, rawurl, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range t.Headers {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", t.ContentType)
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTicketResponse))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("bad response: %s", resp.Status)
+	}
+	return b, nil
+}
+
+// jsonField returns the value at path, a dotted list of object keys, of the
+// JSON document b.
+func jsonField(b []byte, path string) (string, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return "", err
+	}
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("no %s in response", path)
+		}
+		v = m[k]
+	}
+	switch v := v.(type) {
+	case string:
+		if v != "" {
+			return v, nil
+		}
+	case json.Number:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("no %s in response", path)
+}
+
+// ticketContext returns the data to render the templates of the ticket of
+// incident id with.
+func (s *Schedule) ticketContext(id uint64, ticket string) (*TicketContext, error) {
+	incident, err := s.GetIncident(id)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &TicketContext{
+		Alert:  incident.AlertKey.Name(),
+		Tags:   incident.AlertKey.Group(),
+		Ticket: ticket,
+		URL: s.Conf.MakeLink("/incident", &url.Values{
+			"id": []string{fmt.Sprint(id)},
+		}),
+	}
+	s.incidentLock.Lock()
+	ctx.Incident = *incident
+	ctx.Incident.Tickets = nil
+	s.incidentLock.Unlock()
+	if st := s.GetStatus(incident.AlertKey); st != nil {
+		ctx.Status = st.Last().Status
+		ctx.Severity = st.Severity
+		ctx.Subject = st.Subject
+	}
+	return ctx, nil
+}
+
+// incidentTickets returns a copy of the tickets of incident id.
+func (s *Schedule) incidentTickets(id uint64) map[string]*IncidentTicket {
+	s.incidentLock.Lock()
+	defer s.incidentLock.Unlock()
+	incident, ok := s.Incidents[id]
+	if !ok {
+		return nil
+	}
+	tickets := make(map[string]*IncidentTicket, len(incident.Tickets))
+	for name, tk := range incident.Tickets {
+		c := *tk
+		tickets[name] = &c
+	}
+	return tickets
+}
+
+// setIncidentTicket records ticket as incident id's ticket in the ticketing
+// system name, and returns whether the incident is closed.
+func (s *Schedule) setIncidentTicket(id uint64, name, ticket string) bool {
+	s.incidentLock.Lock()
+	defer s.incidentLock.Unlock()
+	incident, ok := s.Incidents[id]
+	if !ok {
+		return false
+	}
+	if incident.Tickets == nil {
+		incident.Tickets = make(map[string]*IncidentTicket)
+	}
+	incident.Tickets[name] = &IncidentTicket{Id: ticket}
+	return incident.End != nil
+}
+
+func (s *Schedule) closeIncidentTicket(id uint64, name string) {
+	s.incidentLock.Lock()
+	defer s.incidentLock.Unlock()
+	if incident, ok := s.Incidents[id]; ok && incident.Tickets[name] != nil {
+		incident.Tickets[name].Closed = true
+	}
+}
+
+// TicketClosed closes the incident whose ticket in the ticketing system name
+// is ticket, after it was closed there. If the incident's alert key is still
+// abnormal it can't be closed, so it is acknowledged instead. It returns a
+// copy of the incident.
+func (s *Schedule) TicketClosed(name, ticket string) (*Incident, error) {
+	if s.Conf.Tickets[name] == nil {
+		return nil, fmt.Errorf("unknown ticketing system: %s", name)
+	}
+	var incident Incident
+	found := false
+	s.incidentLock.Lock()
+	for _, i := range s.Incidents {
+		if tk := i.Tickets[name]; tk != nil && tk.Id == ticket {
+			tk.Closed = true
+			incident = *i
+			found = true
+			break
+		}
+	}
+	s.incidentLock.Unlock()
+	if !found {
+		return nil, fmt.Errorf("no incident has %s ticket %s", name, ticket)
+	}
+	if incident.End != nil {
+		return &incident, nil
+	}
+	st := s.GetStatus(incident.AlertKey)
+	if st == nil || st.Last().IncidentId != incident.Id {
+		return &incident, nil
+	}
+	message := fmt.Sprintf("Ticket %s was closed in %s.", ticket, name)
+	var err error
+	switch {
+	case !st.IsActive():
+		err = s.Action("bosun", message, "", ActionClose, incident.AlertKey)
+	case st.NeedAck:
+		err = s.Action("bosun", message, "", ActionAcknowledge, incident.AlertKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetIncident(incident.Id)
+}
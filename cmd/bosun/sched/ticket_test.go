@@ -0,0 +1,128 @@
+package sched
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestTickets(t *testing.T) {
+	type request struct {
+		path, auth, body string
+	}
+	got := make(chan request, 10)
+	fail := true
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			fail = false
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		got <- request{r.URL.Path, r.Header.Get("Authorization"), string(b)}
+		if r.URL.Path == "/issue" {
+			fmt.Fprint(w, `{"id": 10001, "key": "OPS-1"}`)
+		}
+	}))
+	defer ts.Close()
+	c, err := conf.New("", fmt.Sprintf(`
+		ticket jira {
+			post = %[1]s/issue
+			body = {"summary": {{json .Subject}}, "alert": {{json .Alert}}}
+			idField = key
+			close = %[1]s/issue/{{.Ticket}}/close
+			closeBody = {"transition": 31}
+			header = Authorization: Basic abc
+		}
+		alert a {
+			crit = 1
+		}
+	`, ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ticketHook{
+		s:        s,
+		client:   http.DefaultClient,
+		backoff:  time.Millisecond,
+		queue:    make(chan *ticketJob, 10),
+		creating: make(map[string]bool),
+	}
+	go h.run()
+	expect := func(path, body string) {
+		select {
+		case r := <-got:
+			if r.path != path || r.auth != "Basic abc" || r.body != body {
+				t.Errorf("got request %+v, expected %s %s", r, path, body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", path)
+		}
+	}
+	wait := func(id uint64, closed bool) *IncidentTicket {
+		for i := 0; i < 500; i++ {
+			if tk := s.incidentTickets(id)["jira"]; tk != nil && tk.Closed == closed {
+				return tk
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for ticket of incident %d", id)
+		return nil
+	}
+
+	ak := expr.NewAlertKey("a", opentsdb.TagSet{"host": "h"})
+	incident := s.createIncident(ak, time.Now())
+	s.status[ak] = &State{Group: ak.Group(), Subject: "disk full", Open: true, NeedAck: true, History: []Event{{Status: StCritical, IncidentId: incident.Id}}}
+	h.OnStateChange(ak, StNormal, Event{Status: StWarning, IncidentId: incident.Id})
+	h.OnStateChange(ak, StWarning, Event{Status: StCritical, IncidentId: incident.Id})
+	h.OnStateChange(ak, StWarning, Event{Status: StCritical, IncidentId: incident.Id})
+	expect("/issue", `{"summary": "disk full", "alert": "a"}`)
+	if tk := wait(incident.Id, false); tk.Id != "OPS-1" {
+		t.Fatalf("got ticket %s, expected OPS-1", tk.Id)
+	}
+	h.OnStateChange(ak, StCritical, Event{Status: StUnknown, IncidentId: incident.Id})
+
+	if err := s.Action("u", "m", "", ActionAcknowledge, ak); err != nil {
+		t.Fatal(err)
+	}
+	s.status[ak].History = append(s.status[ak].History, Event{Status: StNormal, IncidentId: incident.Id})
+	if err := s.Action("u", "m", "", ActionClose, ak); err != nil {
+		t.Fatal(err)
+	}
+	closed, _ := s.GetIncident(incident.Id)
+	h.OnIncidentClose(*closed)
+	expect("/issue/OPS-1/close", `{"transition": 31}`)
+	wait(incident.Id, true)
+	select {
+	case r := <-got:
+		t.Errorf("unexpected request %+v", r)
+	default:
+	}
+
+	// Closing a ticket in the ticketing system closes its incident.
+	ak2 := expr.NewAlertKey("a", opentsdb.TagSet{"host": "h2"})
+	incident2 := s.createIncident(ak2, time.Now())
+	s.status[ak2] = &State{Group: ak2.Group(), Open: true, History: []Event{{Status: StCritical, IncidentId: incident2.Id}, {Status: StNormal, IncidentId: incident2.Id}}}
+	s.setIncidentTicket(incident2.Id, "jira", "OPS-2")
+	if _, err := s.TicketClosed("jira", "OPS-3"); err == nil {
+		t.Error("expected error for unknown ticket")
+	}
+	i, err := s.TicketClosed("jira", "OPS-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.End == nil || s.status[ak2].Open || !s.incidentTickets(incident2.Id)["jira"].Closed {
+		t.Errorf("expected incident %d closed", incident2.Id)
+	}
+}
@@ -0,0 +1,100 @@
+package sched
+
+import (
+	"sort"
+	"time"
+
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+// Transition is a change of an alert key's status.
+type Transition struct {
+	AlertKey expr.AlertKey
+	From     Status
+	Event
+}
+
+// matchKey returns whether ak matches alert and tags. Both may be glob
+// patterns, as in silences. Empty alert and tags match everything.
+func matchKey(alert string, tags opentsdb.TagSet, ak expr.AlertKey) bool {
+	if alert != "" {
+		if matched, _ := Match(alert, ak.Name()); !matched {
+			return false
+		}
+	}
+	group := ak.Group()
+	for k, pattern := range tags {
+		tagv, ok := group[k]
+		if !ok {
+			return false
+		}
+		if matched, _ := Match(pattern, tagv); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Transitions returns the status changes between from and to of alert keys
+// matching alert and tags, oldest first.
+func (s *Schedule) Transitions(alert string, tags opentsdb.TagSet, from, to time.Time) []Transition {
+	s.Lock("Transitions")
+	list := []Transition{}
+	for ak, st := range s.status {
+		if !matchKey(alert, tags, ak) {
+			continue
+		}
+		prev := StNone
+		for _, ev := range st.History {
+			if ev.Status != prev && !ev.Time.Before(from) && !ev.Time.After(to) {
+				list = append(list, Transition{AlertKey: ak, From: prev, Event: ev})
+			}
+			prev = ev.Status
+		}
+	}
+	s.Unlock()
+	sort.Sort(transitionsByTime(list))
+	return list
+}
+
+type transitionsByTime []Transition
+
+func (t transitionsByTime) Len() int      { return len(t) }
+func (t transitionsByTime) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t transitionsByTime) Less(i, j int) bool {
+	if !t[i].Time.Equal(t[j].Time) {
+		return t[i].Time.Before(t[j].Time)
+	}
+	return t[i].AlertKey < t[j].AlertKey
+}
+
+// MatchingIncidents returns the incidents open at any time between from and
+// to of alert keys matching alert and tags, oldest first.
+func (s *Schedule) MatchingIncidents(alert string, tags opentsdb.TagSet, from, to time.Time) []*Incident {
+	s.incidentLock.Lock()
+	list := []*Incident{}
+	for _, i := range s.Incidents {
+		if i.Start.After(to) || (i.End != nil && i.End.Before(from)) {
+			continue
+		}
+		if !matchKey(alert, tags, i.AlertKey) {
+			continue
+		}
+		list = append(list, i)
+	}
+	s.incidentLock.Unlock()
+	sort.Sort(incidentsByStart(list))
+	return list
+}
+
+type incidentsByStart []*Incident
+
+func (t incidentsByStart) Len() int      { return len(t) }
+func (t incidentsByStart) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t incidentsByStart) Less(i, j int) bool {
+	if !t[i].Start.Equal(t[j].Start) {
+		return t[i].Start.Before(t[j].Start)
+	}
+	return t[i].Id < t[j].Id
+}
@@ -0,0 +1,127 @@
+package sched
+
+import (
+	"sort"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+)
+
+// maxUpcomingChain is the most sends of a notification chain listed for one
+// scheduled notification, so chains that loop end.
+const maxUpcomingChain = 100
+
+// UpcomingNotification is a notification that will be sent for an alert key
+// unless it is acked, closed, or silenced first.
+type UpcomingNotification struct {
+	AlertKey     expr.AlertKey
+	Notification string
+	Due          time.Time
+	// In is how long until Due, negative if it is overdue.
+	In time.Duration
+	// Chained is set for sends that are not yet scheduled, but will be by
+	// the next of a notification chain being sent.
+	Chained    bool   `json:",omitempty"`
+	IncidentId uint64 `json:",omitempty"`
+	// Silenced is set if the alert key is silenced now.
+	Silenced bool `json:",omitempty"`
+	// Recipients are who or what the notification sends to.
+	Recipients []string `json:",omitempty"`
+}
+
+// UpcomingNotifications returns the notifications due before now plus horizon
+// for the alert keys of the named alert, or of all alerts if alert is "",
+// soonest first. The timeouts of notification chains are followed to list the
+// sends that will follow those scheduled.
+func (s *Schedule) UpcomingNotifications(alert string, now time.Time, horizon time.Duration) ([]*UpcomingNotification, error) {
+	scheduled, err := s.DataAccess.Notifications().GetNotifications()
+	if err != nil {
+		return nil, err
+	}
+	silenced := s.Silenced()
+	end := now.Add(horizon)
+	var l []*UpcomingNotification
+	s.Lock("UpcomingNotifications")
+	for key, ns := range scheduled {
+		ak := expr.AlertKey(key)
+		if alert != "" && ak.Name() != alert {
+			continue
+		}
+		var incident uint64
+		if st := s.status[ak]; st != nil {
+			incident = st.Last().IncidentId
+		}
+		_, isSilenced := silenced[ak]
+		for name, due := range ns {
+			n := s.Conf.Notifications[name]
+			if n == nil || due.After(end) {
+				continue
+			}
+			for i := 0; n != nil && !due.After(end) && i < maxUpcomingChain; i++ {
+				l = append(l, &UpcomingNotification{
+					AlertKey:     ak,
+					Notification: n.Name,
+					Due:          due,
+					In:           due.Sub(now),
+					Chained:      i > 0,
+					IncidentId:   incident,
+					Silenced:     isSilenced,
+					Recipients:   notificationRecipients(n, due),
+				})
+				if n = n.Next; n != nil {
+					due = due.Add(n.Timeout)
+				}
+			}
+		}
+	}
+	s.Unlock()
+	sort.Sort(upcomingNotifications(l))
+	return l, nil
+}
+
+type upcomingNotifications []*UpcomingNotification
+
+func (u upcomingNotifications) Len() int      { return len(u) }
+func (u upcomingNotifications) Swap(i, j int) { u[i], u[j] = u[j], u[i] }
+func (u upcomingNotifications) Less(i, j int) bool {
+	switch {
+	case !u[i].Due.Equal(u[j].Due):
+		return u[i].Due.Before(u[j].Due)
+	case u[i].AlertKey != u[j].AlertKey:
+		return u[i].AlertKey < u[j].AlertKey
+	}
+	return u[i].Notification < u[j].Notification
+}
+
+// notificationRecipients describes who n sends to at t. URLs are reduced to
+// their host, since their paths and queries often hold credentials.
+func notificationRecipients(n *conf.Notification, t time.Time) []string {
+	var r []string
+	for _, a := range n.Email {
+		r = append(r, a.Address)
+	}
+	if n.OnCall != nil {
+		if a, err := n.OnCall.OnDuty(t); err == nil {
+			r = append(r, a.Address)
+		} else {
+			r = append(r, "on-call "+n.OnCall.Name)
+		}
+	}
+	if n.Post != nil {
+		r = append(r, "post "+n.Post.Host)
+	}
+	if n.Get != nil {
+		r = append(r, "get "+n.Get.Host)
+	}
+	if n.PagerDuty != "" {
+		r = append(r, "pagerduty")
+	}
+	if n.Print {
+		r = append(r, "print")
+	}
+	for _, m := range n.Members {
+		r = append(r, "notification "+m.Name)
+	}
+	return r
+}
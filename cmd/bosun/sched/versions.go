@@ -0,0 +1,127 @@
+package sched
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/models"
+	"bosun.org/slog"
+)
+
+// alertVersion returns the hash identifying the version of a's definition.
+func alertVersion(a *conf.Alert) string {
+	sum := sha1.Sum([]byte(a.Text))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// recordAlertVersions records the versions of the alerts that changed since
+// they were last loaded.
+func (s *Schedule) recordAlertVersions(now time.Time) {
+	loader := loadingUser()
+	for name, a := range s.Conf.Alerts {
+		v := &models.AlertVersion{
+			Hash: alertVersion(a),
+			Text: a.Text,
+			Time: now.UTC(),
+			User: loader,
+			Host: s.Conf.Hostname,
+		}
+		added, err := s.DataAccess.Configs().AddAlertVersion(name, v)
+		if err != nil {
+			slog.Errorf("recording version of alert %s: %v", name, err)
+			continue
+		}
+		if added {
+			slog.Infof("alert %s is at version %s", name, v.Hash)
+		}
+	}
+}
+
+// loadingUser returns the user bosun runs as.
+func loadingUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// AlertVersionDiff is a version of an alert's definition and its changes
+// from the version before it.
+type AlertVersionDiff struct {
+	models.AlertVersion
+	// Diff is the version's text with each line prefixed by "+" if it was
+	// added since the previous version, or " " if not, and with removed
+	// lines prefixed by "-". It is empty for the oldest version.
+	Diff string `json:",omitempty"`
+	// Current is set for the version that is loaded.
+	Current bool `json:",omitempty"`
+}
+
+// AlertHistory returns the versions of alert's definition, newest first,
+// each with its diff from the one before it.
+func (s *Schedule) AlertHistory(alert string) ([]*AlertVersionDiff, error) {
+	versions, err := s.DataAccess.Configs().GetAlertVersions(alert)
+	if err != nil {
+		return nil, err
+	}
+	var current string
+	if a := s.Conf.Alerts[alert]; a != nil {
+		current = alertVersion(a)
+	}
+	history := make([]*AlertVersionDiff, len(versions))
+	for i, v := range versions {
+		d := &AlertVersionDiff{AlertVersion: *v, Current: v.Hash == current}
+		if i+1 < len(versions) {
+			d.Diff = strings.Join(diffLines(versions[i+1].Text, v.Text), "\n")
+		}
+		history[i] = d
+	}
+	return history, nil
+}
+
+// diffLines returns the lines of b, prefixed with "+" if they are not in a
+// and " " if they are, and the lines of a not in b prefixed with "-", in the
+// order of a longest common subsequence of their lines.
+func diffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	// lcs[i][j] is the length of the longest common subsequence of x[i:]
+	// and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			switch {
+			case x[i] == y[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff []string
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			diff = append(diff, " "+x[i])
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	return diff
+}
@@ -0,0 +1,69 @@
+package sched
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestAlertHistory(t *testing.T) {
+	load := func(text string) *Schedule {
+		c, err := conf.New("", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := initSched(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	s := load("alert a {\n\tcrit = 1\n\twarn = 0\n}")
+	data := s.DataAccess
+	s.recordAlertVersions(time.Now())
+	s.recordAlertVersions(time.Now())
+	old := s.createIncident(expr.NewAlertKey("a", opentsdb.TagSet{}), time.Now())
+
+	s = load("alert a {\n\tcrit = 2\n\twarn = 0\n}")
+	s.DataAccess = data
+	s.recordAlertVersions(time.Now())
+	incident := s.createIncident(expr.NewAlertKey("a", opentsdb.TagSet{}), time.Now())
+
+	history, err := s.AlertHistory("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected 2 versions, got %d", len(history))
+	}
+	if !history[0].Current || history[1].Current || history[1].Diff != "" {
+		t.Errorf("unexpected history %+v %+v", history[0], history[1])
+	}
+	if incident.AlertVersion != history[0].Hash || old.AlertVersion != history[1].Hash || old.AlertVersion == incident.AlertVersion {
+		t.Errorf("incidents have versions %s and %s, expected %s and %s", old.AlertVersion, incident.AlertVersion, history[1].Hash, history[0].Hash)
+	}
+	expected := " alert a {\n-\tcrit = 1\n+\tcrit = 2\n \twarn = 0\n }"
+	if history[0].Diff != expected {
+		t.Errorf("got diff\n%s\nexpected\n%s", history[0].Diff, expected)
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		expected []string
+	}{
+		{"x\ny", "x\ny", []string{" x", " y"}},
+		{"x", "x\ny", []string{" x", "+y"}},
+		{"x\ny\nz", "x\nz", []string{" x", "-y", " z"}},
+		{"x", "y", []string{"-x", "+y"}},
+	} {
+		if got := diffLines(test.a, test.b); strings.Join(got, "|") != strings.Join(test.expected, "|") {
+			t.Errorf("diff of %q and %q: got %q, expected %q", test.a, test.b, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package sched
+
+import (
+	"time"
+
+	"bosun.org/cmd/bosun/cache"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/slog"
+)
+
+// warmup replays a over the a.Warmup intervals before now, if it has no alert
+// keys yet, so a new alert's first check has history to compare against and
+// to show. Replayed events are added to the alert keys' history without
+// notifying. Abnormal periods open incidents as in a check, and those that
+// ended during the replay are closed. It returns the alert keys whose
+// incident is still open, for endWarmup.
+func (s *Schedule) warmup(a *conf.Alert, now time.Time) []expr.AlertKey {
+	s.Lock("Warmup")
+	for ak := range s.status {
+		if ak.Name() == a.Name {
+			s.Unlock()
+			return nil
+		}
+	}
+	s.Unlock()
+	interval := s.Conf.AlertInterval(a)
+	start := time.Now()
+	var last time.Time
+	for i := a.Warmup; i > 0; i-- {
+		t := now.Add(-time.Duration(i) * interval)
+		rh := s.NewRunHistory(t, cache.New(0))
+		if err := s.replay(rh, a); err != nil {
+			slog.Errorf("warmup of %s at %v: %v", a.Name, t, err)
+			continue
+		}
+		s.Lock("Warmup")
+		for ak, event := range rh.Events {
+			s.warmupEvent(ak, event, t)
+		}
+		s.Unlock()
+		last = t
+	}
+	if last.IsZero() {
+		return nil
+	}
+	var open []expr.AlertKey
+	s.Lock("Warmup")
+	for ak, st := range s.status {
+		if ak.Name() != a.Name {
+			continue
+		}
+		// Alert keys that stopped reporting during the replay are only
+		// unknown if they still are not reporting after the usual time.
+		st.Touched = last
+		if st.Last().IncidentId != 0 {
+			open = append(open, ak)
+		}
+	}
+	s.Unlock()
+	slog.Infof("warmup of %s replayed %d intervals in %v", a.Name, a.Warmup, time.Since(start))
+	return open
+}
+
+// replay evaluates a's expressions at rh.Start into rh's events, like
+// CheckAlert without recording the run.
+func (s *Schedule) replay(rh *RunHistory, a *conf.Alert) error {
+	d, err := s.executeExpr(nil, rh, a, a.Depends)
+	if err != nil {
+		return err
+	}
+	crits, err := s.CheckExpr(nil, rh, a, a.Crit, StCritical, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := s.CheckExpr(nil, rh, a, a.Warn, StWarning, crits); err != nil {
+		return err
+	}
+	markDependenciesUnevaluated(rh.Events, filterDependencyResults(d), a.Name)
+	return nil
+}
+
+// warmupEvent adds the replayed event of ak at t to its history. s must be
+// locked.
+func (s *Schedule) warmupEvent(ak expr.AlertKey, event *Event, t time.Time) {
+	if event.Unevaluated {
+		return
+	}
+	st := s.status[ak]
+	if st == nil {
+		st = NewStatus(ak)
+		s.status[ak] = st
+	}
+	event.Time = t
+	event.Warmup = true
+	if event.Crit != nil {
+		st.Result = event.Crit
+	} else if event.Warn != nil {
+		st.Result = event.Warn
+	}
+	prev := st.Last()
+	switch {
+	case prev.IncidentId != 0 && event.Status == StNormal:
+		s.incidentLock.Lock()
+		if incident, ok := s.Incidents[prev.IncidentId]; ok {
+			incident.End = &t
+		}
+		s.incidentLock.Unlock()
+		st.Action("bosun", "Closed because it recovered during warmup.", "", ActionClose, t)
+	case prev.IncidentId != 0:
+		event.IncidentId = prev.IncidentId
+	case event.Status != StNormal:
+		event.IncidentId = s.createIncident(ak, t).Id
+	}
+	st.Append(event)
+	st.Severity = severity(s.Conf.Alerts[ak.Name()], st.AbnormalStatus())
+	st.Touched = t
+	s.markDirty(ak)
+}
+
+// endWarmup closes the incidents left open by warmup of the alert keys the
+// first check did not open, since nobody will be asked to close them.
+func (s *Schedule) endWarmup(keys []expr.AlertKey, now time.Time) {
+	if len(keys) == 0 {
+		return
+	}
+	s.Lock("Warmup")
+	defer s.Unlock()
+	for _, ak := range keys {
+		st := s.status[ak]
+		if st == nil || st.Open {
+			continue
+		}
+		last := st.Last()
+		if last.IncidentId == 0 {
+			continue
+		}
+		s.incidentLock.Lock()
+		incident, ok := s.Incidents[last.IncidentId]
+		closing := ok && incident.End == nil
+		if closing {
+			incident.End = &now
+		}
+		s.incidentLock.Unlock()
+		if closing {
+			st.Action("bosun", "Closed because it was not abnormal after warmup.", "", ActionClose, now)
+			s.markDirty(ak)
+		}
+	}
+}
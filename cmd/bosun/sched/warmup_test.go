@@ -0,0 +1,118 @@
+package sched
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestWarmup(t *testing.T) {
+	now := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Values of each host by minutes before now: a was critical and recovered
+	// during the warmup, b is still critical, and c recovered at the first
+	// check.
+	values := map[string][]opentsdb.Point{
+		"a": {0, 1, 1, 0, 0, 0},
+		"b": {0, 0, 0, 1, 1, 1},
+		"c": {0, 0, 0, 0, 1, 0},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			End int64 `json:"end"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		i := 5 - int(now.Sub(time.Unix(req.End, 0))/time.Minute)
+		var resp opentsdb.ResponseSet
+		for host, v := range values {
+			resp = append(resp, &opentsdb.Response{
+				Metric: "m",
+				Tags:   opentsdb.TagSet{"host": host},
+				DPS:    map[string]opentsdb.Point{fmt.Sprint(req.End): v[i]},
+			})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := conf.New("", fmt.Sprintf(`
+		tsdbHost = %s
+		checkFrequency = 1m
+		alert w {
+			crit = max(q("avg:m{host=*}", "1m", ""))
+			warmup = 5
+		}
+	`, u.Host))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := initSched(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ctx.runTime = now
+	s.checkAlert(c.Alerts["w"], true)
+
+	type event struct {
+		ago      time.Duration
+		status   Status
+		incident bool
+	}
+	for host, expected := range map[string][]event{
+		"a": {{5, StNormal, false}, {4, StCritical, true}, {2, StNormal, false}},
+		"b": {{5, StNormal, false}, {2, StCritical, true}},
+		"c": {{5, StNormal, false}, {1, StCritical, true}, {0, StNormal, true}},
+	} {
+		st := s.status[expr.NewAlertKey("w", opentsdb.TagSet{"host": host})]
+		if st == nil {
+			t.Errorf("%s: no state", host)
+			continue
+		}
+		if len(st.History) != len(expected) {
+			t.Errorf("%s: got %d events, expected %d", host, len(st.History), len(expected))
+			continue
+		}
+		for i, e := range expected {
+			ev := st.History[i]
+			when := now.Add(-e.ago * time.Minute)
+			if ev.Status != e.status || !ev.Time.Equal(when) || (ev.IncidentId != 0) != e.incident || ev.Warmup != (e.ago > 0) {
+				t.Errorf("%s: event %d: got %v at %v in incident %d, expected %v at %v", host, i, ev.Status, ev.Time, ev.IncidentId, e.status, when)
+			}
+		}
+	}
+	open := 0
+	for _, incident := range s.Incidents {
+		if incident.End == nil {
+			open++
+			if incident.AlertKey != "w{host=b}" || !incident.Start.Equal(now.Add(-2*time.Minute)) {
+				t.Errorf("unexpected open incident %+v", incident)
+			}
+		}
+	}
+	if len(s.Incidents) != 3 || open != 1 {
+		t.Errorf("got %d incidents, %d open, expected 3 and 1", len(s.Incidents), open)
+	}
+	if st := s.status["w{host=b}"]; !st.Open || !st.NeedAck {
+		t.Errorf("expected w{host=b} open and needing acknowledgement")
+	}
+	if st := s.status["w{host=c}"]; st.Open || len(st.Actions) != 1 || st.Actions[0].Type != ActionClose {
+		t.Errorf("expected w{host=c} closed after warmup, got %+v", st.Actions)
+	}
+
+	// An alert with alert keys is not replayed again.
+	if keys := s.warmup(c.Alerts["w"], now.Add(time.Minute)); keys != nil {
+		t.Errorf("expected no warmup, got %v", keys)
+	}
+}
@@ -0,0 +1,164 @@
+package search
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"bosun.org/collect"
+	"bosun.org/opentsdb"
+)
+
+// Offender is a tag key of a metric that went over its cardinality limit.
+type Offender struct {
+	Metric string
+	TagKey string
+	Limit  int
+	// Values is the number of values of the tag key in the index.
+	Values int
+	// Dropped is the number of datapoints not indexed, and Refused the
+	// number of queries not expanded, because of the limit.
+	Dropped int64
+	Refused int64
+	// LastValue is the value of the last datapoint not indexed.
+	LastValue string `json:",omitempty"`
+	Last      time.Time
+}
+
+// SetCardinalityLimit sets the function that returns the most values of a
+// metric's tag key that are indexed, and that a query may expand to. It
+// returns 0 for no limit. Offenders of earlier limits are forgotten.
+func (s *Search) SetCardinalityLimit(limit func(metric, tagk string) int) {
+	s.Lock()
+	defer s.Unlock()
+	s.limit = limit
+	s.values = make(map[string]map[string]map[string]bool)
+	s.offenders = make(map[string]*Offender)
+}
+
+// withinLimits returns whether dp may be indexed without a tag key going
+// over its limit, and if so records its values. s must be locked.
+func (s *Search) withinLimits(dp *opentsdb.DataPoint) bool {
+	if s.limit == nil {
+		return true
+	}
+	type value struct {
+		values map[string]bool
+		v      string
+	}
+	var add []value
+	for k, v := range dp.Tags {
+		limit := s.limit(dp.Metric, k)
+		if limit <= 0 {
+			continue
+		}
+		values := s.tagValues(dp.Metric, k)
+		if values[v] {
+			continue
+		}
+		if len(values) >= limit {
+			o := s.offender(dp.Metric, k, limit)
+			o.Dropped++
+			o.LastValue = v
+			o.Last = time.Now().UTC()
+			return false
+		}
+		add = append(add, value{values, v})
+	}
+	for _, a := range add {
+		a.values[a.v] = true
+	}
+	return true
+}
+
+// tagValues returns the values of tagk of metric, seeding them from the
+// last datapoints the first time. s must be locked.
+func (s *Search) tagValues(metric, tagk string) map[string]bool {
+	tagks := s.values[metric]
+	if tagks == nil {
+		tagks = make(map[string]map[string]bool)
+		s.values[metric] = tagks
+	}
+	values := tagks[tagk]
+	if values == nil {
+		values = make(map[string]bool)
+		for tags := range s.last[metric] {
+			ts, err := opentsdb.ParseTags(tags)
+			if err != nil {
+				continue
+			}
+			if v, ok := ts[tagk]; ok {
+				values[v] = true
+			}
+		}
+		tagks[tagk] = values
+	}
+	return values
+}
+
+// resetValues forgets the values of limited tag keys, so they are seeded
+// again after datapoints are removed from last. s must be locked.
+func (s *Search) resetValues() {
+	s.values = make(map[string]map[string]map[string]bool)
+}
+
+// offender returns the offender for tagk of metric. s must be locked.
+func (s *Search) offender(metric, tagk string, limit int) *Offender {
+	key := metric + " " + tagk
+	o := s.offenders[key]
+	if o == nil {
+		o = &Offender{Metric: metric, TagKey: tagk}
+		s.offenders[key] = o
+	}
+	o.Limit = limit
+	return o
+}
+
+// checkExpansion returns an error if n values of tagk of metric are over its
+// limit.
+func (s *Search) checkExpansion(metric, tagk string, n int) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.limit == nil {
+		return nil
+	}
+	limit := s.limit(metric, tagk)
+	if limit <= 0 || n <= limit {
+		return nil
+	}
+	o := s.offender(metric, tagk, limit)
+	o.Refused++
+	o.Last = time.Now().UTC()
+	collect.Add("search.cardinality_dropped", opentsdb.TagSet{}, 1)
+	return fmt.Errorf("expr: %s of %s matches %d values, over its cardinality limit of %d", tagk, metric, n, limit)
+}
+
+// Offenders returns the tag keys that went over their cardinality limits,
+// those with the most dropped datapoints and refused queries first.
+func (s *Search) Offenders() []*Offender {
+	s.RLock()
+	defer s.RUnlock()
+	l := []*Offender{}
+	for _, o := range s.offenders {
+		c := *o
+		c.Values = len(s.values[o.Metric][o.TagKey])
+		l = append(l, &c)
+	}
+	sort.Sort(byOffense(l))
+	return l
+}
+
+type byOffense []*Offender
+
+func (b byOffense) Len() int      { return len(b) }
+func (b byOffense) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byOffense) Less(i, j int) bool {
+	ni, nj := b[i].Dropped+b[i].Refused, b[j].Dropped+b[j].Refused
+	if ni != nj {
+		return ni > nj
+	}
+	if b[i].Metric != b[j].Metric {
+		return b[i].Metric < b[j].Metric
+	}
+	return b[i].TagKey < b[j].TagKey
+}
@@ -0,0 +1,192 @@
+package search
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"bosun.org/collect"
+	"bosun.org/metadata"
+	"bosun.org/opentsdb"
+	"bosun.org/slog"
+)
+
+func init() {
+	metadata.AddMetricMeta("bosun.search.compaction.progress", metadata.Gauge, metadata.Pct,
+		"Percent of metrics processed by the running search index compaction, or 100 if none is running.")
+	metadata.AddMetricMeta("bosun.search.compaction.removed", metadata.Counter, metadata.Count,
+		"Number of search index entries removed by compaction or purges.")
+}
+
+// Compaction is the progress of a search index compaction.
+type Compaction struct {
+	Running  bool
+	Before   time.Time
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	// Metrics is the number of metrics to compact, and Done the number
+	// compacted so far.
+	Metrics int
+	Done    int
+	Removed int
+	Error   string `json:",omitempty"`
+}
+
+type compactor struct {
+	sync.Mutex
+	c Compaction
+}
+
+func (s *Search) initCompaction() {
+	collect.Set("search.compaction.progress", opentsdb.TagSet{}, func() interface{} {
+		c := s.CompactionStatus()
+		if !c.Running || c.Metrics == 0 {
+			return 100
+		}
+		return c.Done * 100 / c.Metrics
+	})
+}
+
+// CompactionStatus returns the progress of the running or last compaction.
+func (s *Search) CompactionStatus() Compaction {
+	s.compactor.Lock()
+	defer s.compactor.Unlock()
+	return s.compactor.c
+}
+
+// Compact removes metrics, tag keys, and tag values not seen since before
+// from the index. Only one compaction runs at a time.
+func (s *Search) Compact(before time.Time) error {
+	s.compactor.Lock()
+	if s.compactor.c.Running {
+		s.compactor.Unlock()
+		return fmt.Errorf("search: compaction already running")
+	}
+	s.compactor.c = Compaction{Running: true, Before: before, Started: time.Now().UTC()}
+	s.compactor.Unlock()
+	err := s.compact(before)
+	s.compactor.Lock()
+	s.compactor.c.Running = false
+	s.compactor.c.Finished = time.Now().UTC()
+	if err != nil {
+		s.compactor.c.Error = err.Error()
+	}
+	s.compactor.Unlock()
+	return err
+}
+
+func (s *Search) compact(before time.Time) error {
+	sd := s.DataAccess.Search()
+	metrics, err := sd.GetAllMetrics()
+	if err != nil {
+		return err
+	}
+	s.compactor.Lock()
+	s.compactor.c.Metrics = len(metrics)
+	s.compactor.Unlock()
+	tagKeys := make(map[string]bool)
+	for metric := range metrics {
+		keys, err := sd.GetTagKeysForMetric(metric)
+		if err != nil {
+			return err
+		}
+		for k := range keys {
+			tagKeys[k] = true
+		}
+		n, err := sd.PruneMetric(metric, before.Unix())
+		if err != nil {
+			return err
+		}
+		s.compacted(1, n)
+	}
+	for k := range tagKeys {
+		n, err := sd.PruneTagKey(k, before.Unix())
+		if err != nil {
+			return err
+		}
+		s.compacted(0, n)
+	}
+	s.pruneLast(func(metric string, li int64) bool { return li < before.Unix() })
+	return nil
+}
+
+// compacted records the progress of a compaction.
+func (s *Search) compacted(metrics, removed int) {
+	s.compactor.Lock()
+	s.compactor.c.Done += metrics
+	s.compactor.c.Removed += removed
+	s.compactor.Unlock()
+	collect.Add("search.compaction.removed", opentsdb.TagSet{}, int64(removed))
+}
+
+// pruneLast removes the last data points for which drop returns true.
+func (s *Search) pruneLast(drop func(metric string, timestamp int64) bool) {
+	s.Lock()
+	defer s.Unlock()
+	for metric, mmap := range s.last {
+		for tags, li := range mmap {
+			if drop(metric, li.Timestamp) {
+				delete(mmap, tags)
+			}
+		}
+		if len(mmap) == 0 {
+			delete(s.last, metric)
+		}
+	}
+	s.resetValues()
+}
+
+// CompactEvery compacts the index every interval, removing entries not seen
+// within retention. It compacts only while leader returns true.
+func (s *Search) CompactEvery(interval, retention time.Duration, leader func() bool) {
+	for range time.Tick(interval) {
+		if !leader() {
+			continue
+		}
+		slog.Infoln("compacting search index")
+		if err := s.Compact(time.Now().Add(-retention)); err != nil {
+			slog.Errorln("error compacting search index:", err)
+			continue
+		}
+		c := s.CompactionStatus()
+		slog.Infof("compacted search index: removed %d entries of %d metrics", c.Removed, c.Metrics)
+	}
+}
+
+// PurgeMetric removes metric from the index. It returns the number of entries
+// removed.
+func (s *Search) PurgeMetric(metric string) (int, error) {
+	n, err := s.DataAccess.Search().PruneMetric(metric, math.MaxInt64)
+	collect.Add("search.compaction.removed", opentsdb.TagSet{}, int64(n))
+	if err != nil {
+		return n, err
+	}
+	s.pruneLast(func(m string, _ int64) bool { return m == metric })
+	return n, nil
+}
+
+// PurgeTagKey removes tagK, and all of its values, from the index. It returns
+// the number of entries removed.
+func (s *Search) PurgeTagKey(tagK string) (int, error) {
+	n, err := s.DataAccess.Search().PurgeTagKey(tagK)
+	collect.Add("search.compaction.removed", opentsdb.TagSet{}, int64(n))
+	if err != nil {
+		return n, err
+	}
+	s.Lock()
+	defer s.Unlock()
+	for _, mmap := range s.last {
+		for tags := range mmap {
+			ts, err := opentsdb.ParseTags(tags)
+			if err != nil {
+				continue
+			}
+			if _, ok := ts[tagK]; ok {
+				delete(mmap, tags)
+			}
+		}
+	}
+	s.resetValues()
+	return n, nil
+}
@@ -29,11 +29,23 @@ type Search struct {
 
 	indexQueue chan *opentsdb.DataPoint
 	sync.RWMutex
+
+	compactor compactor
+
+	// limit returns the cardinality limit of a metric's tag key, 0 for
+	// none.
+	limit func(metric, tagk string) int
+	// values are the values of each metric's tag keys with a limit, by
+	// metric and tag key, seeded from last.
+	values map[string]map[string]map[string]bool
+	// offenders are the tag keys over their limit, by metric and tag key.
+	offenders map[string]*Offender
 }
 
 func init() {
 	metadata.AddMetricMeta("bosun.search.index_queue", metadata.Gauge, metadata.Count, "Number of datapoints queued for indexing to redis")
 	metadata.AddMetricMeta("bosun.search.dropped", metadata.Counter, metadata.Count, "Number of datapoints discarded without being saved to redis")
+	metadata.AddMetricMeta("bosun.search.cardinality_dropped", metadata.Counter, metadata.Count, "Number of datapoints not indexed, and queries not expanded, because a tag key has more values than its cardinality limit")
 }
 
 func NewSearch(data database.DataAccess) *Search {
@@ -41,8 +53,11 @@ func NewSearch(data database.DataAccess) *Search {
 		DataAccess: data,
 		last:       make(map[string]map[string]*database.LastInfo),
 		indexQueue: make(chan *opentsdb.DataPoint, 300000),
+		values:     make(map[string]map[string]map[string]bool),
+		offenders:  make(map[string]*Offender),
 	}
 	collect.Set("search.index_queue", opentsdb.TagSet{}, func() interface{} { return len(s.indexQueue) })
+	s.initCompaction()
 	s.loadLast()
 	go s.redisIndex(s.indexQueue)
 	go s.backupLoop()
@@ -52,6 +67,11 @@ func NewSearch(data database.DataAccess) *Search {
 func (s *Search) Index(mdp opentsdb.MultiDataPoint) {
 	for _, dp := range mdp {
 		s.Lock()
+		if !s.withinLimits(dp) {
+			s.Unlock()
+			collect.Add("search.cardinality_dropped", opentsdb.TagSet{}, 1)
+			continue
+		}
 		mmap := s.last[dp.Metric]
 		if mmap == nil {
 			mmap = make(map[string]*database.LastInfo)
@@ -243,6 +263,9 @@ func (s *Search) Expand(q *opentsdb.Query) error {
 		if len(nvs) == 0 {
 			return fmt.Errorf("expr: no tags matching %s=%s", k, ov)
 		}
+		if err := s.checkExpansion(q.Metric, k, len(nvs)); err != nil {
+			return err
+		}
 		q.Tags[k] = strings.Join(nvs, "|")
 	}
 	return nil
@@ -263,6 +286,84 @@ func (s *Search) UniqueMetrics() ([]string, error) {
 	return metrics, nil
 }
 
+// FirstSeenMetrics returns when each metric was first seen, as a Unix time,
+// or 0 if it was before first seen times were kept.
+func (s *Search) FirstSeenMetrics() (map[string]int64, error) {
+	return s.DataAccess.Search().GetFirstSeenMetrics()
+}
+
+// FirstSeenTagValues returns when each value of tagk was first seen, as for
+// FirstSeenMetrics.
+func (s *Search) FirstSeenTagValues(tagk string) (map[string]int64, error) {
+	return s.DataAccess.Search().GetFirstSeenTagValues(tagk)
+}
+
+// Seen is when a metric or tag value was first and last seen.
+type Seen struct {
+	Name string
+	// FirstSeen is nil if it was first seen before first seen times were
+	// kept.
+	FirstSeen *time.Time `json:",omitempty"`
+	LastSeen  time.Time
+}
+
+// FirstSeen returns when each value of tagk, or each metric if tagk is "",
+// was first and last seen, most recently first seen first. If since is not 0,
+// only those first seen within since are returned.
+func (s *Search) FirstSeen(tagk string, since time.Duration) ([]*Seen, error) {
+	var first, last map[string]int64
+	var err error
+	if tagk == "" {
+		if first, err = s.FirstSeenMetrics(); err != nil {
+			return nil, err
+		}
+		last, err = s.DataAccess.Search().GetAllMetrics()
+	} else {
+		if first, err = s.FirstSeenTagValues(tagk); err != nil {
+			return nil, err
+		}
+		last, err = s.DataAccess.Search().GetTagValues(database.Search_All, tagk)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var after int64
+	if since > 0 {
+		after = time.Now().Add(-since).Unix()
+	}
+	l := []*Seen{}
+	for name, t := range last {
+		f, ok := first[name]
+		if since > 0 && (!ok || f == 0 || f < after) {
+			continue
+		}
+		seen := &Seen{Name: name, LastSeen: time.Unix(t, 0).UTC()}
+		if ok && f != 0 {
+			ft := time.Unix(f, 0).UTC()
+			seen.FirstSeen = &ft
+		}
+		l = append(l, seen)
+	}
+	sort.Sort(bySeen(l))
+	return l, nil
+}
+
+type bySeen []*Seen
+
+func (b bySeen) Len() int      { return len(b) }
+func (b bySeen) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b bySeen) Less(i, j int) bool {
+	fi, fj := b[i].FirstSeen, b[j].FirstSeen
+	switch {
+	case fi == nil && fj == nil:
+	case fi == nil || fj == nil:
+		return fj == nil
+	case !fi.Equal(*fj):
+		return fi.After(*fj)
+	}
+	return b[i].Name < b[j].Name
+}
+
 func (s *Search) TagValuesByTagKey(Tagk string, since time.Duration) ([]string, error) {
 	return s.TagValuesByMetricTagKey(database.Search_All, Tagk, since)
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"bosun.org/cmd/bosun/database"
 	"bosun.org/cmd/bosun/database/test"
 	"bosun.org/opentsdb"
 )
@@ -65,3 +66,151 @@ func TestIndex(t *testing.T) {
 		t.Fatalf("Expected 2 filtered results. Found %d.", len(filtered))
 	}
 }
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompact(t *testing.T) {
+	sd := testSearch.DataAccess.Search()
+	add := func(metric, tagk, tagv string, ts int64) {
+		for _, err := range []error{
+			sd.AddMetric(metric, ts),
+			sd.AddTagKeyForMetric(metric, tagk, ts),
+			sd.AddTagValue(metric, tagk, tagv, ts),
+			sd.AddTagValue(database.Search_All, tagk, tagv, ts),
+			sd.AddMetricForTag(tagk, tagv, metric, ts),
+			sd.AddMetricTagSet(metric, tagk+"="+tagv, ts),
+		} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	add("compact.old", "chost", "h1", 50)
+	add("compact.mixed", "chost", "h1", 50)
+	add("compact.mixed", "chost", "h2", 200)
+	if err := testSearch.Compact(time.Unix(100, 0)); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := testSearch.UniqueMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(metrics, "compact.old") || !contains(metrics, "compact.mixed") {
+		t.Errorf("expected only compact.mixed to remain, got %v", metrics)
+	}
+	tagvs, err := testSearch.TagValuesByMetricTagKey("compact.mixed", "chost", 0)
+	checkEqual(t, err, "tagvs", []string{"h2"}, tagvs)
+	tagvs, err = testSearch.TagValuesByTagKey("chost", 0)
+	checkEqual(t, err, "all tagvs", []string{"h2"}, tagvs)
+	byPair, err := testSearch.MetricsByTagPair("chost", "h1")
+	checkEqual(t, err, "metrics by pair", []string{}, byPair)
+	sets, err := testSearch.FilteredTagSets("compact.mixed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sets) != 1 || sets[0]["chost"] != "h2" {
+		t.Errorf("expected only the h2 tag set, got %v", sets)
+	}
+	if c := testSearch.CompactionStatus(); c.Running || c.Removed == 0 || c.Done != c.Metrics {
+		t.Errorf("unexpected compaction status %+v", c)
+	}
+
+	add("purge.m", "pkey", "v", 200)
+	add("purge.m", "pother", "v", 200)
+	if _, err := testSearch.PurgeTagKey("pkey"); err != nil {
+		t.Fatal(err)
+	}
+	tagks, err := testSearch.TagKeysByMetric("purge.m")
+	checkEqual(t, err, "purged tagks", []string{"pother"}, tagks)
+	tagvs, err = testSearch.TagValuesByTagKey("pkey", 0)
+	checkEqual(t, err, "purged tagvs", []string{}, tagvs)
+	if _, err := testSearch.PurgeMetric("purge.m"); err != nil {
+		t.Fatal(err)
+	}
+	metrics, err = testSearch.UniqueMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(metrics, "purge.m") {
+		t.Errorf("expected purge.m to be purged, got %v", metrics)
+	}
+	byPair, err = testSearch.MetricsByTagPair("pother", "v")
+	checkEqual(t, err, "purged metrics by pair", []string{}, byPair)
+	if _, err := testSearch.PurgeMetric("compact.mixed"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	testSearch.Index(opentsdb.MultiDataPoint{
+		&opentsdb.DataPoint{Metric: "fs.new", Value: 1, Timestamp: 13, Tags: opentsdb.TagSet{"fshost": "a"}},
+	})
+	time.Sleep(1 * time.Second)
+	hosts, err := testSearch.FirstSeen("fshost", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "a" || hosts[0].FirstSeen == nil {
+		t.Fatalf("unexpected first seen hosts: %v", hosts)
+	}
+	if time.Since(*hosts[0].FirstSeen) > time.Minute {
+		t.Errorf("expected a recent first seen time, got %v", hosts[0].FirstSeen)
+	}
+	metrics, err := testSearch.FirstSeen("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, m := range metrics {
+		found = found || m.Name == "fs.new"
+	}
+	if !found {
+		t.Errorf("expected fs.new in %v", metrics)
+	}
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	testSearch.SetCardinalityLimit(func(metric, tagk string) int {
+		if metric == "card.m" && tagk == "id" {
+			return 2
+		}
+		return 0
+	})
+	defer testSearch.SetCardinalityLimit(nil)
+	dp := func(id, host string) *opentsdb.DataPoint {
+		return &opentsdb.DataPoint{Metric: "card.m", Value: 1, Timestamp: 13, Tags: opentsdb.TagSet{"id": id, "host": host}}
+	}
+	testSearch.Index(opentsdb.MultiDataPoint{dp("a1", "h"), dp("a2", "h"), dp("a3", "h"), dp("a1", "h2")})
+	time.Sleep(1 * time.Second)
+	tagvs, err := testSearch.TagValuesByMetricTagKey("card.m", "id", 0)
+	checkEqual(t, err, "limited tagvs", []string{"a1", "a2"}, tagvs)
+	tagvs, err = testSearch.TagValuesByMetricTagKey("card.m", "host", 0)
+	checkEqual(t, err, "unlimited tagvs", []string{"h", "h2"}, tagvs)
+	if _, _, err := testSearch.GetLast("card.m", "{host=h,id=a3}", false); err == nil {
+		t.Error("expected no last datapoint over the limit")
+	}
+
+	q := &opentsdb.Query{Metric: "card.m", Tags: opentsdb.TagSet{"id": "a1|a*"}}
+	if err := testSearch.Expand(q); err == nil {
+		t.Errorf("expected expansion over the limit to fail, got %v", q.Tags)
+	}
+	q = &opentsdb.Query{Metric: "card.m", Tags: opentsdb.TagSet{"id": "*1", "host": "h*"}}
+	if err := testSearch.Expand(q); err != nil {
+		t.Fatal(err)
+	}
+
+	offenders := testSearch.Offenders()
+	if len(offenders) != 1 {
+		t.Fatalf("expected one offender, got %v", offenders)
+	}
+	if o := offenders[0]; o.Metric != "card.m" || o.TagKey != "id" || o.Limit != 2 || o.Values != 2 || o.Dropped != 1 || o.Refused != 1 || o.LastValue != "a3" {
+		t.Errorf("unexpected offender %+v", o)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"bosun.org/_third_party/golang.org/x/sys/windows/svc"
+	"bosun.org/_third_party/golang.org/x/sys/windows/svc/eventlog"
+	"bosun.org/_third_party/golang.org/x/sys/windows/svc/mgr"
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/slog"
+	"bosun.org/version"
+)
+
+var win_service_command = flag.String("winsvc", "", "For Windows Service, can be install, remove, start, stop")
+
+func init() {
+	mains = append(mains, win_service_main)
+}
+
+func win_service_main() {
+	const svcName = "bosun"
+	var err error
+	switch *win_service_command {
+	case "install":
+		err = installService(svcName, "Bosun Monitoring and Alerting System")
+	case "remove":
+		err = removeService(svcName)
+	case "start":
+		err = startService(svcName)
+	case "stop":
+		err = controlService(svcName, svc.Stop, svc.Stopped)
+	case "":
+		isIntSess, err := svc.IsAnInteractiveSession()
+		if err != nil {
+			slog.Fatalf("failed to determine if we are running in an interactive session: %v", err)
+		}
+		if !isIntSess {
+			go runService(svcName)
+		}
+		return
+	default:
+		slog.Fatalf("unknown winsvc command: %v", *win_service_command)
+	}
+	if err != nil {
+		slog.Fatalf("failed to %s %s: %v", *win_service_command, svcName, err)
+	}
+	os.Exit(0)
+}
+
+func installService(name, desc string) error {
+	exepath, err := exePath()
+	if err != nil {
+		return err
+	}
+	conf, err := filepath.Abs(*flagConf)
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	s, err = m.CreateService(name, fmt.Sprintf(`"%s" -c "%s"`, exepath, conf), mgr.Config{DisplayName: name,
+		StartType:   mgr.StartAutomatic,
+		Description: desc})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	err = eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info)
+	if err != nil {
+		s.Delete()
+		return fmt.Errorf("SetupEventLogSource() failed: %s", err)
+	}
+	return nil
+}
+
+// exePath returns the absolute path of the running executable, adding .exe
+// if it was started without the extension.
+func exePath() (string, error) {
+	prog := os.Args[0]
+	p, err := filepath.Abs(prog)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(p)
+	if err == nil {
+		if !fi.Mode().IsDir() {
+			return p, nil
+		}
+		err = fmt.Errorf("%s is directory", p)
+	}
+	if filepath.Ext(p) == "" {
+		p += ".exe"
+		fi, err := os.Stat(p)
+		if err == nil {
+			if !fi.Mode().IsDir() {
+				return p, nil
+			}
+			err = fmt.Errorf("%s is directory", p)
+		}
+	}
+	return "", err
+}
+
+func removeService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", name)
+	}
+	defer s.Close()
+	err = s.Delete()
+	if err != nil {
+		return err
+	}
+	err = eventlog.Remove(name)
+	if err != nil {
+		return fmt.Errorf("RemoveEventLogSource() failed: %s", err)
+	}
+	return nil
+}
+
+func startService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("could not access service: %v", err)
+	}
+	defer s.Close()
+	err = s.Start()
+	if err != nil {
+		return fmt.Errorf("could not start service: %v", err)
+	}
+	return nil
+}
+
+func controlService(name string, c svc.Cmd, to svc.State) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("could not access service: %v", err)
+	}
+	defer s.Close()
+	status, err := s.Control(c)
+	if err != nil {
+		return fmt.Errorf("could not send control=%d: %v", c, err)
+	}
+	// Stopping saves state, which may take a while.
+	timeout := time.Now().Add(time.Minute)
+	for status.State != to {
+		if timeout.Before(time.Now()) {
+			return fmt.Errorf("timeout waiting for service to go to state=%d", to)
+		}
+		time.Sleep(300 * time.Millisecond)
+		status, err = s.Query()
+		if err != nil {
+			return fmt.Errorf("could not retrieve service status: %v", err)
+		}
+	}
+	return nil
+}
+
+// healthInterval is how often the service checks that alerts are still being
+// checked.
+const healthInterval = time.Minute
+
+type s struct{}
+
+func (m *s) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown
+	changes <- svc.Status{State: svc.StartPending}
+	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	health := time.NewTicker(healthInterval)
+	defer health.Stop()
+loop:
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				break loop
+			default:
+				slog.Errorf("unexpected control request #%d", c)
+			}
+		case <-health.C:
+			if !*flagNoChecks && !sched.DefaultSched.ChecksRunning() {
+				slog.Errorln("alerts have not been checked recently: scheduler may be wedged")
+			}
+		}
+	}
+	changes <- svc.Status{State: svc.StopPending}
+	shutdown()
+	return
+}
+
+func runService(name string) {
+	elog, err := eventlog.Open(name)
+	if err != nil {
+		return
+	}
+	slog.SetEventLog(elog, 1)
+	defer elog.Close()
+	slog.Infof("starting %s service version %v (%v)", name, version.Version, version.VersionSHA)
+	err = svc.Run(name, &s{})
+	if err != nil {
+		slog.Errorf("%s service failed: %v", name, err)
+		return
+	}
+	slog.Infof("%s service stopped", name)
+	os.Exit(0)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/slog"
+)
+
+func init() {
+	started = append(started, systemdReady)
+	stopping = append(stopping, func() { sdNotify("STOPPING=1") })
+}
+
+// sdNotify sends state to systemd if bosun was started by a service with
+// Type=notify. It does nothing otherwise.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdReady tells systemd bosun has started and, if WatchdogSec is set,
+// starts sending watchdog pings.
+func systemdReady() {
+	if err := sdNotify("READY=1"); err != nil {
+		slog.Errorln("systemd notify:", err)
+		return
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go systemdWatchdog(time.Duration(usec) * time.Microsecond / 2)
+}
+
+// systemdWatchdog pings the systemd watchdog every interval while alerts are
+// being checked, so systemd restarts bosun if the scheduler is wedged.
+func systemdWatchdog(interval time.Duration) {
+	for range time.Tick(interval) {
+		if !*flagNoChecks && !sched.DefaultSched.ChecksRunning() {
+			slog.Warningln("alerts have not been checked recently: not sending systemd watchdog ping")
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			slog.Errorln("systemd notify:", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+// annotationRequest is the request of the Grafana simple-json datasource
+// for annotations.
+type annotationRequest struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	// Annotation is echoed in the response. Its query is an annotationQuery.
+	Annotation map[string]interface{} `json:"annotation"`
+}
+
+// annotation is an annotation in the Grafana simple-json datasource format.
+// Times are in milliseconds.
+type annotation struct {
+	Annotation map[string]interface{} `json:"annotation"`
+	Time       int64                  `json:"time"`
+	TimeEnd    int64                  `json:"timeEnd,omitempty"`
+	IsRegion   bool                   `json:"isRegion,omitempty"`
+	Title      string                 `json:"title"`
+	Tags       []string               `json:"tags"`
+	Text       string                 `json:"text"`
+}
+
+// annotationQuery is a parsed annotation query: whitespace or comma
+// separated key=value pairs. The alert key matches alert names, type is
+// "incident" or "transition", and other keys match tags. Values may be glob
+// patterns, as in silences.
+type annotationQuery struct {
+	Alert string
+	Type  string
+	Tags  opentsdb.TagSet
+}
+
+func parseAnnotationQuery(q string) (*annotationQuery, error) {
+	aq := &annotationQuery{Tags: make(opentsdb.TagSet)}
+	for _, f := range strings.FieldsFunc(q, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("bad annotation query term %q, expected key=value", f)
+		}
+		switch kv[0] {
+		case "alert":
+			aq.Alert = kv[1]
+		case "type":
+			if kv[1] != "incident" && kv[1] != "transition" {
+				return nil, fmt.Errorf("unknown annotation type %q", kv[1])
+			}
+			aq.Type = kv[1]
+		default:
+			aq.Tags[kv[0]] = kv[1]
+		}
+	}
+	return aq, nil
+}
+
+// Annotations serves incidents and state transitions to Grafana's
+// simple-json datasource, so dashboards can overlay them on graphs.
+func Annotations(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req annotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	if req.Range.To.IsZero() {
+		req.Range.To = time.Now()
+	}
+	query, _ := req.Annotation["query"].(string)
+	aq, err := parseAnnotationQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return annotations(schedule, &req, aq), nil
+}
+
+func annotations(s *sched.Schedule, req *annotationRequest, aq *annotationQuery) []annotation {
+	list := []annotation{}
+	tags := func(ak expr.AlertKey, kind string) []string {
+		t := []string{"bosun", kind, ak.Name()}
+		if g := ak.Group(); len(g) > 0 {
+			t = append(t, strings.Split(g.Tags(), ",")...)
+		}
+		return t
+	}
+	if aq.Type == "" || aq.Type == "incident" {
+		for _, i := range s.MatchingIncidents(aq.Alert, aq.Tags, req.Range.From, req.Range.To) {
+			a := annotation{
+				Annotation: req.Annotation,
+				Time:       msecs(i.Start),
+				IsRegion:   true,
+				Title:      fmt.Sprintf("Incident #%d: %s", i.Id, i.AlertKey),
+				Tags:       tags(i.AlertKey, "incident"),
+				Text:       fmt.Sprintf(`<a href="%s">incident #%d</a>`, s.Conf.MakeLink("/incident", &url.Values{"id": []string{fmt.Sprint(i.Id)}}), i.Id),
+			}
+			if i.End != nil {
+				a.TimeEnd = msecs(*i.End)
+			} else {
+				a.TimeEnd = msecs(req.Range.To)
+			}
+			list = append(list, a)
+		}
+	}
+	if aq.Type == "" || aq.Type == "transition" {
+		for _, tr := range s.Transitions(aq.Alert, aq.Tags, req.Range.From, req.Range.To) {
+			list = append(list, annotation{
+				Annotation: req.Annotation,
+				Time:       msecs(tr.Time),
+				Title:      fmt.Sprintf("%s: %v -> %v", tr.AlertKey, tr.From, tr.Status),
+				Tags:       append(tags(tr.AlertKey, "transition"), tr.Status.String()),
+				Text:       fmt.Sprintf("%s became %v", tr.AlertKey, tr.Status),
+			})
+		}
+	}
+	return list
+}
+
+func msecs(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+	"bosun.org/opentsdb"
+)
+
+func TestParseAnnotationQuery(t *testing.T) {
+	aq, err := parseAnnotationQuery("alert=os.* host=ny-*, type=incident")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &annotationQuery{Alert: "os.*", Type: "incident", Tags: opentsdb.TagSet{"host": "ny-*"}}
+	if !reflect.DeepEqual(aq, expected) {
+		t.Fatalf("got %+v, expected %+v", aq, expected)
+	}
+	for _, q := range []string{"host", "type=deploy", "=a"} {
+		if _, err := parseAnnotationQuery(q); err == nil {
+			t.Errorf("%s: expected error", q)
+		}
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	c, err := conf.New("", `
+		hostname = bosun
+		alert a {
+			crit = 1
+		}
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.StateFile = ""
+	s := new(sched.Schedule)
+	s.DataAccess = testData
+	if err := s.Init(c); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	run := func(at time.Duration, st sched.Status, hosts ...string) {
+		events := make(map[expr.AlertKey]*sched.Event)
+		for _, h := range hosts {
+			events[expr.NewAlertKey("a", opentsdb.TagSet{"host": h})] = &sched.Event{Status: st}
+		}
+		s.RunHistory(&sched.RunHistory{Start: start.Add(at), Events: events})
+	}
+	// Open ny-1's incident first so it is incident 1.
+	run(0, sched.StCritical, "ny-1")
+	run(0, sched.StCritical, "la-1")
+	run(time.Minute, sched.StNormal, "ny-1", "la-1")
+	req := &annotationRequest{Annotation: map[string]interface{}{"name": "bosun"}}
+	req.Range.From = start.Add(-time.Hour)
+	req.Range.To = start.Add(time.Hour)
+	aq, err := parseAnnotationQuery("host=ny-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, a := range annotations(s, req, aq) {
+		if a.Annotation["name"] != "bosun" {
+			t.Errorf("annotation not echoed: %v", a.Annotation)
+		}
+		titles = append(titles, a.Title)
+	}
+	expected := []string{
+		"Incident #1: a{host=ny-1}",
+		"a{host=ny-1}: none -> critical",
+		"a{host=ny-1}: critical -> normal",
+	}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Fatalf("got %q, expected %q", titles, expected)
+	}
+	// Transitions before the range are excluded.
+	req.Range.From = start.Add(time.Second)
+	aq.Type = "transition"
+	if got := annotations(s, req, aq); len(got) != 1 || got[0].Time != msecs(start.Add(time.Minute)) {
+		t.Fatalf("unexpected annotations: %+v", got)
+	}
+}
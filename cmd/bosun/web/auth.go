@@ -0,0 +1,278 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/_third_party/github.com/gorilla/context"
+	"bosun.org/_third_party/gopkg.in/ldap.v2"
+	"bosun.org/cmd/bosun/conf"
+	"bosun.org/slog"
+)
+
+// adminPaths may only be used by admins: the configuration text has secrets
+// in it, and the others change how bosun runs. Paths ending in / match any
+// path with that prefix.
+var adminPaths = []string{
+	"/api/backup",
+	"/api/cache/clear",
+	"/api/config",
+	"/api/debug/",
+	"/api/search/cardinality",
+}
+
+// adminWritePaths may be read by anyone, but only changed by admins.
+var adminWritePaths = []string{
+	"/api/collect",
+	"/api/consistency",
+	"/api/dryrun",
+	"/api/errors",
+	"/api/metadata/delete",
+	"/api/search/compaction",
+	"/api/search/purge",
+}
+
+// operatorPaths act on alerts, so may only be changed by operators. The
+// clear paths change state on any request.
+var operatorPaths = []string{
+	"/api/exclusion/clear",
+	"/api/silence/clear",
+}
+
+// operatorWritePaths may be read by anyone, but only changed by operators.
+var operatorWritePaths = []string{
+	"/api/action",
+	"/api/alerts/note",
+	"/api/annotations",
+	"/api/events",
+	"/api/exclusion/set",
+	"/api/host/",
+	"/api/incidents/",
+	"/api/metadata/put",
+	"/api/notifications/log",
+	"/api/silence",
+	"/api/silence/set",
+	"/api/tickets/",
+}
+
+// requiredRole returns the role needed for r. Other requests that are not
+// reads, like expression and rule tests, are open to viewers. So are
+// subscriptions, but only admins may list or delete those of other users.
+func requiredRole(r *http.Request) conf.Role {
+	write := r.Method != "GET" && r.Method != "HEAD"
+	switch {
+	case matchPath(adminPaths, r.URL.Path), write && matchPath(adminWritePaths, r.URL.Path):
+		return conf.RoleAdmin
+	case matchPath(operatorPaths, r.URL.Path), write && matchPath(operatorWritePaths, r.URL.Path):
+		return conf.RoleOperator
+	}
+	return conf.RoleViewer
+}
+
+type userKey struct{}
+
+// authUser returns the authenticated user of r, or "" if authType is not
+// set.
+func authUser(r *http.Request) string {
+	user, _ := context.Get(r, userKey{}).(string)
+	return user
+}
+
+// actingUser returns who is acting in r: the authenticated user, or claimed,
+// the user given in the request, if authType is not set.
+func actingUser(r *http.Request, claimed string) string {
+	if user := authUser(r); user != "" {
+		return user
+	}
+	return claimed
+}
+
+// ownerOnly returns the authenticated user of r if they may only act on
+// their own objects, like subscriptions, or "" if authType is not set or they
+// are an admin.
+func ownerOnly(r *http.Request) string {
+	user := authUser(r)
+	if user == "" || schedule.Conf.UserRole(user) >= conf.RoleAdmin {
+		return ""
+	}
+	return user
+}
+
+// authenticator returns the user r is from, or "" if it is not
+// authenticated.
+type authenticator func(r *http.Request) (string, error)
+
+// authorize authenticates requests to h, other than to exempt paths, with
+// authenticate, and refuses those the user's role does not allow. The user
+// is stored in the request's context, for authUser.
+func authorize(c *conf.Conf, authenticate authenticator, h http.Handler, exempt map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		user, err := authenticate(r)
+		if err != nil {
+			slog.Errorf("authenticating %s: %v", r.RemoteAddr, err)
+			http.Error(w, "authentication failed", http.StatusServiceUnavailable)
+			return
+		}
+		if user == "" {
+			if c.AuthType == conf.AuthLDAP {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bosun"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if role, need := c.UserRole(user), requiredRole(r); role < need {
+			http.Error(w, fmt.Sprintf("%s %s requires the %s role; %s has %s", r.Method, r.URL.Path, need, user, role), http.StatusForbidden)
+			return
+		}
+		context.Set(r, userKey{}, user)
+		defer context.Clear(r)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// newAuthenticator returns the authenticator of c's authType, or nil if it
+// is not set.
+func newAuthenticator(c *conf.Conf) authenticator {
+	switch c.AuthType {
+	case conf.AuthHeader:
+		return func(r *http.Request) (string, error) {
+			return strings.TrimSpace(r.Header.Get(c.AuthHeader)), nil
+		}
+	case conf.AuthLDAP:
+		l := &ldapAuth{url: c.LDAPURL, bindDN: c.LDAPBindDN, binds: make(map[[sha256.Size]byte]time.Time)}
+		return l.authenticate
+	}
+	return nil
+}
+
+// Auth returns the authenticated user and their role.
+func Auth(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	user := authUser(r)
+	if user == "" {
+		return nil, fmt.Errorf("authType is not set")
+	}
+	return struct {
+		User string
+		Role conf.Role
+	}{user, schedule.Conf.UserRole(user)}, nil
+}
+
+// ldapBindTTL is how long a successful bind is remembered, so each request
+// does not bind again.
+const ldapBindTTL = time.Minute
+
+// ldapTimeout limits how long a bind may take.
+var ldapTimeout = 10 * time.Second
+
+// ldapAuth authenticates basic auth credentials with LDAP simple binds.
+type ldapAuth struct {
+	url    string
+	bindDN string
+
+	// binds are when the successful binds expire, by the hash of the user
+	// and password.
+	binds map[[sha256.Size]byte]time.Time
+	sync.Mutex
+}
+
+func (l *ldapAuth) authenticate(r *http.Request) (string, error) {
+	user, pass, ok := r.BasicAuth()
+	// An empty password is an unauthenticated bind, which servers allow.
+	if !ok || user == "" || pass == "" {
+		return "", nil
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + pass))
+	now := time.Now()
+	l.Lock()
+	expires := l.binds[key]
+	for k, t := range l.binds {
+		if !now.Before(t) {
+			delete(l.binds, k)
+		}
+	}
+	l.Unlock()
+	if now.Before(expires) {
+		return user, nil
+	}
+	ok, err := ldapBind(l.url, fmt.Sprintf(l.bindDN, ldapEscapeDN(user)), pass)
+	if err != nil || !ok {
+		return "", err
+	}
+	l.Lock()
+	l.binds[key] = now.Add(ldapBindTTL)
+	l.Unlock()
+	return user, nil
+}
+
+// ldapEscapeDN escapes the special characters of an LDAP distinguished name
+// attribute value.
+func ldapEscapeDN(s string) string {
+	var b bytes.Buffer
+	for i, c := range s {
+		switch {
+		case strings.ContainsRune(`,+"\<>;=`, c),
+			(c == ' ' || c == '#') && i == 0,
+			c == ' ' && i == len(s)-1:
+			b.WriteByte('\\')
+			b.WriteRune(c)
+		case c == 0:
+			b.WriteString(`\00`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// ldapBind returns whether a simple bind to the LDAP server at rawurl as dn
+// with password succeeds.
+func ldapBind(rawurl, dn, password string) (bool, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return false, err
+	}
+	host := u.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if u.Scheme == "ldaps" {
+			host = net.JoinHostPort(host, "636")
+		} else {
+			host = net.JoinHostPort(host, "389")
+		}
+	}
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	var conn net.Conn
+	if u.Scheme == "ldaps" {
+		name, _, _ := net.SplitHostPort(host)
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, &tls.Config{ServerName: name})
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return false, err
+	}
+	l := ldap.NewConn(conn, u.Scheme == "ldaps")
+	l.Start()
+	defer l.Close()
+	l.SetTimeout(ldapTimeout)
+	err = l.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ldap bind: %v", err)
+	}
+	return true, nil
+}
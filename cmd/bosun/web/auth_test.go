@@ -0,0 +1,190 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bosun.org/_third_party/github.com/gorilla/context"
+	"bosun.org/_third_party/gopkg.in/asn1-ber.v1"
+	"bosun.org/_third_party/gopkg.in/ldap.v2"
+	"bosun.org/cmd/bosun/conf"
+)
+
+func TestAuthorize(t *testing.T) {
+	c, err := conf.New("", `
+		authType = header
+		authHeader = x-remote-user
+		authAdmins = root
+		authOperators = op1, op2
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var acting string
+	h := authorize(c, newAuthenticator(c), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acting = actingUser(r, "claimed")
+	}), ingestPaths)
+	for i, test := range []struct {
+		method, path, user string
+		code               int
+	}{
+		{"GET", "/api/alerts", "", http.StatusUnauthorized},
+		{"POST", "/api/put", "", http.StatusOK},
+		{"GET", "/api/alerts", "someone", http.StatusOK},
+		{"POST", "/api/expr", "someone", http.StatusOK},
+		{"POST", "/api/action", "someone", http.StatusForbidden},
+		{"POST", "/api/action", "op2", http.StatusOK},
+		{"GET", "/api/silence/get", "someone", http.StatusOK},
+		{"POST", "/api/silence/set", "op1", http.StatusOK},
+		{"GET", "/api/silence/clear", "someone", http.StatusForbidden},
+		{"POST", "/api/incidents/3/notes", "op1", http.StatusOK},
+		{"GET", "/api/errors", "op1", http.StatusOK},
+		{"POST", "/api/errors", "op1", http.StatusForbidden},
+		{"POST", "/api/errors", "root", http.StatusOK},
+		{"GET", "/api/config", "op1", http.StatusForbidden},
+		{"GET", "/api/config", "root", http.StatusOK},
+		{"GET", "/api/events", "someone", http.StatusOK},
+		{"POST", "/api/events", "someone", http.StatusForbidden},
+		{"POST", "/api/events", "op1", http.StatusOK},
+		{"POST", "/api/metadata/put", "", http.StatusUnauthorized},
+		{"POST", "/api/metadata/put", "someone", http.StatusForbidden},
+		{"POST", "/api/metadata/put", "op1", http.StatusOK},
+		{"POST", "/api/metadata/delete", "op1", http.StatusForbidden},
+		{"POST", "/api/metadata/delete", "root", http.StatusOK},
+	} {
+		acting = ""
+		r, err := http.NewRequest(test.method, test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.user != "" {
+			r.Header.Set("X-Remote-User", test.user)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%d: %s %s as %q: got %d, expected %d: %s", i, test.method, test.path, test.user, w.Code, test.code, w.Body)
+			continue
+		}
+		expected := test.user
+		if test.user == "" && test.code == http.StatusOK {
+			expected = "claimed"
+		}
+		if test.code == http.StatusOK && acting != expected {
+			t.Errorf("%d: acting user %q, expected %q", i, acting, expected)
+		}
+	}
+}
+
+func TestOwnerOnly(t *testing.T) {
+	c, err := conf.New("", `
+		authType = header
+		authHeader = x-remote-user
+		authAdmins = root
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(c *conf.Conf) { schedule.Conf = c }(schedule.Conf)
+	schedule.Conf = c
+	for user, expected := range map[string]string{
+		"":        "",
+		"root":    "",
+		"someone": "someone",
+	} {
+		r, err := http.NewRequest("DELETE", "/api/subscriptions/1", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user != "" {
+			context.Set(r, userKey{}, user)
+		}
+		if owner := ownerOnly(r); owner != expected {
+			t.Errorf("%q: got owner %q, expected %q", user, owner, expected)
+		}
+		context.Clear(r)
+	}
+}
+
+func TestLDAPAuth(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	binds := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			req, err := ber.ReadPacket(conn)
+			if err != nil || len(req.Children) < 2 || len(req.Children[1].Children) < 3 {
+				conn.Close()
+				continue
+			}
+			// Take the name and password from the bind request: its
+			// children are the version, name, and password.
+			bind := req.Children[1].Children
+			binds <- bind[1].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			if bind[2].Data.String() == "secret" {
+				code = ldap.LDAPResultSuccess
+			}
+			resp := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+			resp.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, req.Children[0].Value, ""))
+			op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationBindResponse, nil, "")
+			op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+			resp.AppendChild(op)
+			conn.Write(resp.Bytes())
+			// Wait for the client's unbind.
+			ber.ReadPacket(conn)
+			conn.Close()
+		}
+	}()
+	c, err := conf.New("", `
+		authType = ldap
+		ldapURL = ldap://`+ln.Addr().String()+`
+		ldapBindDN = uid=%s,ou=people,dc=example,dc=com
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate := newAuthenticator(c)
+	auth := func(user, pass string) string {
+		r, err := http.NewRequest("GET", "/api/alerts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetBasicAuth(user, pass)
+		u, err := authenticate(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	if u := auth("a,b", "wrong"); u != "" {
+		t.Errorf("expected wrong password refused, got %q", u)
+	}
+	if dn := <-binds; dn != `uid=a\,b,ou=people,dc=example,dc=com` {
+		t.Errorf("unexpected bind DN %s", dn)
+	}
+	if u := auth("alice", "secret"); u != "alice" {
+		t.Errorf("expected alice, got %q", u)
+	}
+	<-binds
+	if u := auth("alice", "secret"); u != "alice" {
+		t.Errorf("expected remembered bind, got %q", u)
+	}
+	if u := auth("alice", ""); u != "" {
+		t.Errorf("expected empty password refused, got %q", u)
+	}
+	if len(binds) != 0 {
+		t.Errorf("expected no more binds, got %d", len(binds))
+	}
+}
@@ -16,11 +16,13 @@ import (
 	"bosun.org/_third_party/github.com/gorilla/mux"
 	"bosun.org/_third_party/github.com/vdobler/chart"
 	"bosun.org/_third_party/github.com/vdobler/chart/svgg"
-	"bosun.org/cmd/bosun/expr"
-	"bosun.org/cmd/bosun/expr/parse"
+	"bosun.org/cmd/bosun/database"
 	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+	"bosun.org/expr/parse"
 	"bosun.org/metadata"
 	"bosun.org/opentsdb"
+	"bosun.org/slog"
 )
 
 // Graph takes an OpenTSDB request data structure and queries OpenTSDB. Use the
@@ -72,20 +74,22 @@ func Graph(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interf
 			start = fmt.Sprintf("%vs", e-s)
 		}
 	}
-	m_units := make(map[string]string)
+	m_meta := make(map[string]*seriesMeta)
 	for i, q := range oreq.Queries {
-		if ar[i] {
-
-			meta, err := schedule.MetadataMetrics(q.Metric)
-			if err != nil {
+		meta, err := schedule.MetadataMetrics(q.Metric)
+		if err != nil {
+			// Without metadata the graph is only missing units, unless
+			// it is needed for the rate.
+			if ar[i] {
 				return nil, err
 			}
+			slog.Errorf("graph: metadata of %s: %v", q.Metric, err)
+			meta = nil
+		}
+		if ar[i] {
 			if meta == nil {
 				return nil, fmt.Errorf("no metadata for %s: cannot use auto rate", q)
 			}
-			if meta.Unit != "" {
-				m_units[q.Metric] = meta.Unit
-			}
 			if meta.Rate != "" {
 				switch meta.Rate {
 				case metadata.Gauge:
@@ -103,6 +107,13 @@ func Graph(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interf
 				}
 			}
 		}
+		if meta != nil {
+			m_meta[q.Metric] = &seriesMeta{
+				Unit:  meta.Unit,
+				Rate:  meta.Rate,
+				Rated: q.Rate,
+			}
+		}
 		queries[i] = fmt.Sprintf(`q("%v", "%v", "%v")`, q, start, end)
 		if err := schedule.Search.Expand(q); err != nil {
 			return nil, err
@@ -121,7 +132,7 @@ func Graph(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interf
 	if err != nil {
 		return nil, err
 	}
-	cs, err := makeChart(tr, m_units)
+	cs, err := makeChart(tr, m_meta)
 	if err != nil {
 		return nil, err
 	}
@@ -208,9 +219,9 @@ func ExprGraph(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (in
 	// it may not strictly be necessary to recreate the contexts each time, but we do to be safe
 	tsdbContext := schedule.Conf.TSDBContext()
 	graphiteContext := schedule.Conf.GraphiteContext()
-	ls := schedule.Conf.LogstashElasticHosts
+	ls := schedule.Conf.ElasticHosts
 	influx := schedule.Conf.InfluxConfig
-	res, _, err := e.Execute(tsdbContext, graphiteContext, ls, influx, cacheObj, t, now, autods, false, schedule.Search, nil, nil)
+	res, _, err := e.Execute(tsdbContext, graphiteContext, ls, influx, schedule.Conf.SQLDatabases, schedule.Conf.CloudWatch, cacheObj, t, now, autods, false, schedule.Search, nil, nil, schedule.DataAccess.Events())
 	if err != nil {
 		return nil, err
 	}
@@ -220,7 +231,7 @@ func ExprGraph(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (in
 	return nil, nil
 }
 
-func makeChart(r opentsdb.ResponseSet, m_units map[string]string) ([]*chartSeries, error) {
+func makeChart(r opentsdb.ResponseSet, m_meta map[string]*seriesMeta) ([]*chartSeries, error) {
 	var series []*chartSeries
 	for _, resp := range r {
 		dps := make([][2]float64, 0)
@@ -239,13 +250,17 @@ func makeChart(r opentsdb.ResponseSet, m_units map[string]string) ([]*chartSerie
 			if len(resp.Tags) > 0 {
 				name += resp.Tags.String()
 			}
-			series = append(series, &chartSeries{
+			cs := &chartSeries{
 				Name:   name,
 				Metric: resp.Metric,
 				Tags:   resp.Tags,
 				Data:   dps,
-				Unit:   m_units[resp.Metric],
-			})
+			}
+			if m := m_meta[resp.Metric]; m != nil {
+				cs.seriesMeta = *m
+				cs.Hint = m.hint()
+			}
+			series = append(series, cs)
 		}
 	}
 	return series, nil
@@ -256,5 +271,70 @@ type chartSeries struct {
 	Metric string
 	Tags   opentsdb.TagSet
 	Data   [][2]float64
-	Unit   string
+	seriesMeta
+	// Hint is a human readable note on how to display the series.
+	Hint string `json:",omitempty"`
+}
+
+// seriesMeta is the unit and rate metadata of a series' metric.
+type seriesMeta struct {
+	Unit string
+	// Rate is the metric's rate type from metadata: gauge, rate, or counter.
+	Rate string `json:",omitempty"`
+	// Rated is true if the series was queried as a per-second rate.
+	Rated bool `json:",omitempty"`
+}
+
+func (m *seriesMeta) hint() string {
+	switch {
+	case m.Rate == metadata.Counter && !m.Rated:
+		return "counter: values are cumulative, query as a rate to display per-second values"
+	case m.Rate == metadata.Counter && m.Rated:
+		return fmt.Sprintf("counter converted to a per-second rate: %s per second", unitOrValues(m.Unit))
+	case m.Rate != metadata.Counter && m.Rated:
+		return fmt.Sprintf("per-second rate of change: %s per second", unitOrValues(m.Unit))
+	}
+	return ""
+}
+
+// metricMeta is the seriesMeta and hint of a metric.
+type metricMeta struct {
+	seriesMeta
+	Hint string `json:",omitempty"`
+}
+
+// queriesMeta returns the metadata from get of the metrics of queries. Metrics
+// without metadata, or whose metadata can't be read, are left out.
+func queriesMeta(queries []opentsdb.Request, get func(metric string) (*database.MetricMetadata, error)) map[string]*metricMeta {
+	m := make(map[string]*metricMeta)
+	for _, r := range queries {
+		for _, q := range r.Queries {
+			if _, ok := m[q.Metric]; ok {
+				continue
+			}
+			meta, err := get(q.Metric)
+			if err != nil {
+				slog.Errorf("metadata of %s: %v", q.Metric, err)
+				continue
+			}
+			if meta == nil {
+				continue
+			}
+			mm := &metricMeta{seriesMeta: seriesMeta{
+				Unit:  meta.Unit,
+				Rate:  meta.Rate,
+				Rated: q.Rate,
+			}}
+			mm.Hint = mm.hint()
+			m[q.Metric] = mm
+		}
+	}
+	return m
+}
+
+func unitOrValues(unit string) string {
+	if unit == "" {
+		return "values"
+	}
+	return unit
 }
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -17,8 +18,9 @@ import (
 	"bosun.org/_third_party/github.com/bradfitz/slice"
 	"bosun.org/cmd/bosun/cache"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
 	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+	"bosun.org/expr/parse"
 	"bosun.org/opentsdb"
 )
 
@@ -39,28 +41,9 @@ func Expr(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (v inter
 	if err != nil {
 		return nil, err
 	}
-
-	lines := strings.Split(strings.TrimSpace(string(text)), "\n")
-	var expression string
-	vars := map[string]string{}
-	varRegex := regexp.MustCompile(`(\$\w+)\s*=(.*)`)
-	for i, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
-			continue
-		}
-		// last line is expression we care about
-		if i == len(lines)-1 {
-			expression = schedule.Conf.Expand(line, vars, false)
-		} else { // must be a variable declatation
-			matches := varRegex.FindStringSubmatch(line)
-			if len(matches) == 0 {
-				return nil, fmt.Errorf("Expext all lines before final expression to be variable declarations of form `$foo = something`")
-			}
-			name := strings.TrimSpace(matches[1])
-			value := strings.TrimSpace(matches[2])
-			vars[name] = schedule.Conf.Expand(value, vars, false)
-		}
+	expression, err := expandExpr(string(text))
+	if err != nil {
+		return nil, err
 	}
 	e, err := expr.New(expression, schedule.Conf.Funcs())
 	if err != nil {
@@ -73,9 +56,9 @@ func Expr(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (v inter
 	// it may not strictly be necessary to recreate the contexts each time, but we do to be safe
 	tsdbContext := schedule.Conf.TSDBContext()
 	graphiteContext := schedule.Conf.GraphiteContext()
-	ls := schedule.Conf.LogstashElasticHosts
+	ls := schedule.Conf.ElasticHosts
 	influx := schedule.Conf.InfluxConfig
-	res, queries, err := e.Execute(tsdbContext, graphiteContext, ls, influx, cacheObj, t, now, 0, false, schedule.Search, nil, nil)
+	res, queries, err := e.Execute(tsdbContext, graphiteContext, ls, influx, schedule.Conf.SQLDatabases, schedule.Conf.CloudWatch, cacheObj, t, now, 0, false, schedule.Search, nil, nil, schedule.DataAccess.Events())
 	if err != nil {
 		return nil, err
 	}
@@ -88,10 +71,13 @@ func Expr(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (v inter
 		Type    string
 		Results []*expr.Result
 		Queries map[string]opentsdb.Request
+		// Metadata is the unit and rate metadata of the queried metrics.
+		Metadata map[string]*metricMeta
 	}{
 		e.Tree.Root.Return().String(),
 		res.Results,
 		make(map[string]opentsdb.Request),
+		queriesMeta(queries, schedule.MetadataMetrics),
 	}
 	for _, q := range queries {
 		if e, err := url.QueryUnescape(q.String()); err == nil {
@@ -101,6 +87,64 @@ func Expr(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (v inter
 	return ret, nil
 }
 
+var varRegex = regexp.MustCompile(`(\$\w+)\s*=(.*)`)
+
+// expandExpr returns the expression on the last line of text, expanded with
+// the variables declared on the lines before it.
+func expandExpr(text string) (string, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	var expression string
+	vars := map[string]string{}
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// last line is expression we care about
+		if i == len(lines)-1 {
+			expression = schedule.Conf.Expand(line, vars, false)
+		} else { // must be a variable declatation
+			matches := varRegex.FindStringSubmatch(line)
+			if len(matches) == 0 {
+				return "", fmt.Errorf("Expext all lines before final expression to be variable declarations of form `$foo = something`")
+			}
+			name := strings.TrimSpace(matches[1])
+			value := strings.TrimSpace(matches[2])
+			vars[name] = schedule.Conf.Expand(value, vars, false)
+		}
+	}
+	return expression, nil
+}
+
+// ExprCheck parses an expression, given as for Expr, without running it. It
+// returns the expression after variables are expanded, and its type or the
+// error in it, with the error's position in the expanded expression and a
+// hint to fix it if there is one.
+func ExprCheck(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	text, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	expression, err := expandExpr(string(text))
+	if err != nil {
+		return nil, err
+	}
+	check := struct {
+		Expr  string
+		Type  string       `json:",omitempty"`
+		Error *parse.Error `json:",omitempty"`
+	}{Expr: expression}
+	e, err := expr.New(expression, schedule.Conf.Funcs())
+	if pe, ok := err.(*parse.Error); ok {
+		check.Error = pe
+		return check, nil
+	} else if err != nil {
+		return nil, err
+	}
+	check.Type = e.Tree.Root.Return().String()
+	return check, nil
+}
+
 func getTime(r *http.Request) (now time.Time, err error) {
 	now = time.Now().UTC()
 	if fd := r.FormValue("date"); len(fd) > 0 {
@@ -232,7 +276,7 @@ func procRule(t miniprofiler.Timer, c *conf.Conf, a *conf.Alert, now time.Time,
 			} else if s_err != nil {
 				warning = append(warning, s_err.Error())
 			} else {
-				n.DoEmail(email_subject, email, schedule.Conf, string(instance.AlertKey()), attachments...)
+				n.DoEmail(email_subject, email, schedule.Conf, string(instance.AlertKey()), "", attachments...)
 			}
 		}
 		data = s.Data(rh, instance, a, false)
@@ -431,6 +475,40 @@ func Rule(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interfa
 	return &ret, nil
 }
 
+// TemplateTest renders an alert's template against synthetic state POSTed as
+// JSON, without running its expressions. Config is the configuration to
+// render with, the running one if empty.
+func TemplateTest(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var req struct {
+		Config string
+		Alert  string
+		sched.TemplateTest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	c := schedule.Conf
+	if req.Config != "" {
+		var err error
+		c, err = conf.New("Test Config", req.Config)
+		if err != nil {
+			return nil, err
+		}
+		c.StateFile = ""
+	}
+	a, ok := c.Alerts[req.Alert]
+	if !ok {
+		return nil, fmt.Errorf("alert %s not found", req.Alert)
+	}
+	s := &sched.Schedule{}
+	s.DataAccess = schedule.DataAccess
+	s.Search = schedule.Search
+	if err := s.Init(c); err != nil {
+		return nil, err
+	}
+	return s.RenderTemplateTest(a, &req.TemplateTest)
+}
+
 func buildConfig(r *http.Request) (c *conf.Conf, a *conf.Alert, hash string, err error) {
 	config, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -442,6 +520,10 @@ func buildConfig(r *http.Request) (c *conf.Conf, a *conf.Alert, hash string, err
 		return nil, nil, "", err
 	}
 	c.StateFile = ""
+	// Share query results with the running checks.
+	if schedule.Conf.TSDBCache != nil {
+		c.TSDBCache = schedule.Conf.TSDBCache
+	}
 
 	hash, err = sched.DefaultSched.SaveTempConfig(string(config))
 	if err != nil {
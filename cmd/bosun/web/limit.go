@@ -0,0 +1,37 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+// sourceLimiter limits how many items each source may send per minute.
+type sourceLimiter struct {
+	sync.Mutex
+	// window is the start of the current minute, and counts are the items
+	// sent by each source in it.
+	window time.Time
+	counts map[string]int
+}
+
+// allow records n items from source at now, and returns whether they are
+// within limit. Otherwise it returns how long until the source may send again.
+// A limit of 0 is unlimited. The first put of a source each minute is always
+// allowed, so a batch larger than limit is slowed down but not refused
+// forever.
+func (l *sourceLimiter) allow(source string, n, limit int, now time.Time) (bool, time.Duration) {
+	if limit <= 0 {
+		return true, 0
+	}
+	l.Lock()
+	defer l.Unlock()
+	if w := now.Truncate(time.Minute); !w.Equal(l.window) || l.counts == nil {
+		l.window = w
+		l.counts = make(map[string]int)
+	}
+	if l.counts[source] > 0 && l.counts[source]+n > limit {
+		return false, l.window.Add(time.Minute).Sub(now)
+	}
+	l.counts[source] += n
+	return true, 0
+}
@@ -0,0 +1,36 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceLimiter(t *testing.T) {
+	var l sourceLimiter
+	now := time.Date(2016, 3, 1, 12, 0, 10, 0, time.UTC)
+	if ok, _ := l.allow("a", 8, 10, now); !ok {
+		t.Fatal("expected first put allowed")
+	}
+	ok, wait := l.allow("a", 3, 10, now)
+	if ok || wait != 50*time.Second {
+		t.Fatalf("expected put over limit refused for 50s, got %v %v", ok, wait)
+	}
+	if ok, _ := l.allow("b", 10, 10, now); !ok {
+		t.Fatal("expected other source allowed")
+	}
+	if ok, _ := l.allow("a", 2, 10, now.Add(time.Second)); !ok {
+		t.Fatal("expected put within limit allowed")
+	}
+	if ok, _ := l.allow("a", 10, 10, now.Add(time.Minute)); !ok {
+		t.Fatal("expected put allowed in next minute")
+	}
+	if ok, _ := l.allow("c", 500, 10, now); !ok {
+		t.Fatal("expected first put over limit allowed")
+	}
+	if ok, _ := l.allow("c", 1, 10, now); ok {
+		t.Fatal("expected put after a batch over limit refused")
+	}
+	if ok, _ := l.allow("a", 1000, 0, now); !ok {
+		t.Fatal("expected no limit")
+	}
+}
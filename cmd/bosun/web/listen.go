@@ -0,0 +1,135 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ingestPaths receive data from scollector and bosun itself, which cannot
+// authenticate, so they are exempt from httpAuth. Metadata is not: it is
+// shown and used by alerts, so only authenticated clients may change it.
+var ingestPaths = map[string]bool{
+	"/api/index": true,
+	"/api/put":   true,
+}
+
+// publicPaths are the read-only routes served on publicListen. Paths ending in
+// / match any path with that prefix.
+var publicPaths = []string{
+	"/",
+	"/favicon.ico",
+	"/partials/",
+	"/static/",
+	"/api/alerts",
+	"/api/egraph/",
+	"/api/graph",
+	"/api/health",
+	"/api/host",
+	"/api/incidents",
+	"/api/incidents/clusters",
+	"/api/incidents/events",
+	"/api/last",
+	"/api/metadata/get",
+	"/api/metadata/metrics",
+	"/api/metric",
+	"/api/metric/",
+	"/api/schema",
+	"/api/schema/",
+	"/api/status",
+	"/api/tagk/",
+	"/api/tagsets/",
+	"/api/tagv/",
+	"/api/version",
+}
+
+// statePaths are the routes that change alert state or send notifications. An
+// HA standby refuses requests to change them, since its state is replaced by
+// the leader's and only the leader notifies.
+var statePaths = []string{
+	"/api/action",
+	"/api/alerts/note",
+	"/api/consistency",
+	"/api/dryrun",
+	"/api/exclusion/clear",
+	"/api/exclusion/set",
+	"/api/host/",
+	"/api/incidents/",
+	"/api/notifications/log",
+	"/api/silence",
+	"/api/silence/clear",
+	"/api/silence/set",
+	"/api/subscriptions",
+	"/api/subscriptions/",
+	"/api/tickets/",
+}
+
+// matchPath returns true if path is in paths, or has a prefix in paths that
+// ends in /.
+func matchPath(paths []string, path string) bool {
+	for _, p := range paths {
+		if path == p || (strings.HasSuffix(p, "/") && p != "/" && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublic(path string) bool {
+	if matchPath(publicPaths, path) {
+		return true
+	}
+	// The UI is served from / for any non-API path.
+	return !strings.HasPrefix(path, "/api/")
+}
+
+// publicOnly serves read-only requests for public paths from h.
+func publicOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || !isPublic(r.URL.Path) {
+			http.Error(w, "not available on the public listener", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// leaderOnly refuses requests from h to change alert state unless isLeader
+// returns true.
+func leaderOnly(h http.Handler, isLeader func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" && matchPath(statePaths, r.URL.Path) && !isLeader() {
+			http.Error(w, "this bosun is an HA standby; make changes on the leader", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// basicAuth requires requests to h, other than to exempt paths, to have the
+// basic auth credentials creds, in user:password form. If creds is empty, h
+// is returned.
+func basicAuth(creds string, h http.Handler, exempt map[string]bool) http.Handler {
+	if creds == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !exempt[r.URL.Path] {
+			user, pass, _ := r.BasicAuth()
+			if subtle.ConstantTimeCompare([]byte(user+":"+pass), []byte(creds)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="bosun"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve serves h on addr, using TLS if cert and key are set.
+func serve(addr, cert, key string, h http.Handler) error {
+	if cert != "" {
+		return http.ListenAndServeTLS(addr, cert, key, h)
+	}
+	return http.ListenAndServe(addr, h)
+}
@@ -0,0 +1,201 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/slog"
+)
+
+// logTailSize is the number of recent log lines kept for /api/logs/tail.
+const logTailSize = 1000
+
+// logTail holds the recent log lines streamed by /api/logs/tail.
+var logTail = newLogRing(logTailSize)
+
+// TailLogs keeps recent log lines so they can be streamed by /api/logs/tail.
+// They are still logged by the current logger.
+func TailLogs() {
+	slog.Set(&tailLogger{Logger: slog.Get(), ring: logTail})
+}
+
+// logLevels ranks log levels, so lines can be filtered by a minimum level.
+var logLevels = map[string]int{
+	"info":    0,
+	"warning": 1,
+	"error":   2,
+	"fatal":   3,
+}
+
+type logLine struct {
+	Id      int64
+	Time    time.Time
+	Level   string
+	Message string
+}
+
+// tailLogger records each line in ring before passing it to Logger.
+type tailLogger struct {
+	slog.Logger
+	ring *logRing
+}
+
+func (t *tailLogger) Info(v string) {
+	t.ring.add("info", v)
+	t.Logger.Info(v)
+}
+
+func (t *tailLogger) Warning(v string) {
+	t.ring.add("warning", v)
+	t.Logger.Warning(v)
+}
+
+func (t *tailLogger) Error(v string) {
+	t.ring.add("error", v)
+	t.Logger.Error(v)
+}
+
+func (t *tailLogger) Fatal(v string) {
+	t.ring.add("fatal", v)
+	t.Logger.Fatal(v)
+}
+
+// logRing is a ring buffer of the most recent log lines.
+type logRing struct {
+	sync.Mutex
+	lines []*logLine
+	next  int64
+	// added is closed and replaced when a line is added, to wake streams
+	// waiting for it.
+	added chan struct{}
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{
+		lines: make([]*logLine, size),
+		added: make(chan struct{}),
+	}
+}
+
+func (r *logRing) add(level, message string) {
+	r.Lock()
+	defer r.Unlock()
+	r.lines[r.next%int64(len(r.lines))] = &logLine{
+		Id:      r.next,
+		Time:    time.Now().UTC(),
+		Level:   level,
+		Message: strings.TrimSuffix(message, "\n"),
+	}
+	r.next++
+	close(r.added)
+	r.added = make(chan struct{})
+}
+
+// since returns the lines still in r with ids of at least id, oldest first,
+// and a channel that is closed when another line is added.
+func (r *logRing) since(id int64) ([]*logLine, <-chan struct{}) {
+	r.Lock()
+	defer r.Unlock()
+	if oldest := r.next - int64(len(r.lines)); id < oldest {
+		id = oldest
+	}
+	if id < 0 {
+		id = 0
+	}
+	var lines []*logLine
+	for ; id < r.next; id++ {
+		lines = append(lines, r.lines[id%int64(len(r.lines))])
+	}
+	return lines, r.added
+}
+
+// LogTail streams log lines as server-sent events: first up to lines (100 by
+// default) of the most recent, then each one as it is logged. Only lines at
+// or above level that contain filter are sent.
+func LogTail(w http.ResponseWriter, r *http.Request) {
+	min := 0
+	if level := r.FormValue("level"); level != "" {
+		l, ok := logLevels[level]
+		if !ok {
+			serveError(w, fmt.Errorf("unknown level: %s", level))
+			return
+		}
+		min = l
+	}
+	filter := r.FormValue("filter")
+	backlog := 100
+	if l := r.FormValue("lines"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 0 {
+			serveError(w, fmt.Errorf("bad lines: %s", l))
+			return
+		}
+		backlog = n
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		serveError(w, fmt.Errorf("streaming not supported"))
+		return
+	}
+	// Without a CloseNotifier, a closed client is noticed at the next send.
+	var closed <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	match := func(l *logLine) bool {
+		return logLevels[l.Level] >= min && strings.Contains(l.Message, filter)
+	}
+	send := func(l *logLine) error {
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", l.Id, b)
+		return err
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	lines, added := logTail.since(0)
+	var recent []*logLine
+	for _, l := range lines {
+		if match(l) {
+			recent = append(recent, l)
+		}
+	}
+	if len(recent) > backlog {
+		recent = recent[len(recent)-backlog:]
+	}
+	for _, l := range recent {
+		if err := send(l); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	var next int64
+	if len(lines) > 0 {
+		next = lines[len(lines)-1].Id + 1
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case <-added:
+		}
+		lines, added = logTail.since(next)
+		for _, l := range lines {
+			next = l.Id + 1
+			if !match(l) {
+				continue
+			}
+			if err := send(l); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
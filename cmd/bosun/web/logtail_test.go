@@ -0,0 +1,74 @@
+package web
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRing(t *testing.T) {
+	r := newLogRing(3)
+	for _, m := range []string{"a", "b", "c", "d"} {
+		r.add("info", m)
+	}
+	lines, _ := r.since(0)
+	if len(lines) != 3 || lines[0].Message != "b" || lines[2].Id != 3 {
+		t.Fatalf("expected the last 3 lines, got %v", lines)
+	}
+	lines, added := r.since(4)
+	if len(lines) != 0 {
+		t.Fatalf("expected no new lines, got %v", lines)
+	}
+	r.add("error", "e")
+	select {
+	case <-added:
+	default:
+		t.Fatal("expected to be woken by a new line")
+	}
+}
+
+func TestLogTail(t *testing.T) {
+	logTail = newLogRing(10)
+	logTail.add("info", "check done")
+	logTail.add("error", "check a failed")
+	ts := httptest.NewServer(http.HandlerFunc(LogTail))
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "?level=error&filter=check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %s", ct)
+	}
+	lines := make(chan string, 10)
+	go func() {
+		s := bufio.NewScanner(resp.Body)
+		for s.Scan() {
+			if strings.HasPrefix(s.Text(), "data: ") {
+				lines <- s.Text()
+			}
+		}
+	}()
+	next := func() string {
+		select {
+		case l := <-lines:
+			return l
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a log line")
+		}
+		return ""
+	}
+	if l := next(); !strings.Contains(l, "check a failed") {
+		t.Fatalf("expected recent error, got %s", l)
+	}
+	logTail.add("error", "unrelated")
+	logTail.add("info", "check b")
+	logTail.add("error", "check b failed")
+	if l := next(); !strings.Contains(l, "check b failed") {
+		t.Fatalf("expected streamed error, got %s", l)
+	}
+}
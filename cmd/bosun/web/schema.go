@@ -0,0 +1,253 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/_third_party/github.com/gorilla/mux"
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+)
+
+// schemaTypes are the API payloads whose JSON Schemas are served at
+// /api/schema.
+var schemaTypes = map[string]interface{}{
+	"Action":      actionRequest{},
+	"Incident":    sched.Incident{},
+	"Silence":     sched.Silence{},
+	"StateGroups": sched.StateGroups{},
+}
+
+// silenceJSON is how sched.Silence marshals itself.
+type silenceJSON struct {
+	Start, End time.Time
+	Alert      string
+	Namespace  string `json:",omitempty"`
+	Tags       string
+	Forget     bool
+	User       string
+	Message    string
+	Filter     string `json:",omitempty"`
+	Suppressed int64
+}
+
+var (
+	numberSchema = map[string]interface{}{
+		// NaN and infinities are marshaled as strings.
+		"type": []string{"number", "string"},
+	}
+	// schemaOverrides are the schemas of types with their own JSON encoding.
+	schemaOverrides = map[reflect.Type]func() interface{}{
+		reflect.TypeOf(time.Time{}): func() interface{} {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		},
+		reflect.TypeOf(expr.Expr{}): func() interface{} {
+			return map[string]interface{}{"type": "string"}
+		},
+		reflect.TypeOf(expr.Number(0)): func() interface{} { return numberSchema },
+		reflect.TypeOf(expr.Scalar(0)): func() interface{} { return numberSchema },
+		reflect.TypeOf(expr.Series{}): func() interface{} {
+			return map[string]interface{}{"type": "object", "additionalProperties": numberSchema}
+		},
+		reflect.TypeOf(sched.StNone): func() interface{} {
+			return stringEnum(sched.StNone, sched.StNormal, sched.StWarning, sched.StCritical, sched.StUnknown)
+		},
+		reflect.TypeOf(sched.ActionNone): func() interface{} {
+			return stringEnum(sched.ActionNone, sched.ActionAcknowledge, sched.ActionClose, sched.ActionForget, sched.ActionSnooze)
+		},
+	}
+	// schemaAliases are types that marshal as another type.
+	schemaAliases = map[reflect.Type]reflect.Type{
+		reflect.TypeOf(sched.Silence{}): reflect.TypeOf(silenceJSON{}),
+	}
+)
+
+func stringEnum(values ...fmt.Stringer) interface{} {
+	enum := make([]string, len(values))
+	for i, v := range values {
+		enum[i] = v.String()
+	}
+	return map[string]interface{}{"type": "string", "enum": enum}
+}
+
+// JSONSchema returns a draft-04 JSON Schema of the JSON encoding of v.
+// Named struct types are in the definitions, so recursive types are
+// supported.
+func JSONSchema(title string, v interface{}) map[string]interface{} {
+	g := &schemaGen{defs: make(map[string]interface{})}
+	s := map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"title":   title,
+	}
+	for k, v := range g.schema(reflect.TypeOf(v)).(map[string]interface{}) {
+		s[k] = v
+	}
+	s["definitions"] = g.defs
+	return s
+}
+
+type schemaGen struct {
+	defs map[string]interface{}
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func (g *schemaGen) schema(t reflect.Type) interface{} {
+	if f, ok := schemaOverrides[t]; ok {
+		return f()
+	}
+	if a, ok := schemaAliases[t]; ok {
+		t = a
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Interface:
+		// Any value.
+		return map[string]interface{}{}
+	}
+	if t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType) {
+		// Unknown encoding.
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Base64 encoded.
+			return nullable(map[string]interface{}{"type": "string"})
+		}
+		return nullable(map[string]interface{}{"type": "array", "items": g.schema(t.Elem())})
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return nullable(map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := t.String()
+		if _, ok := g.defs[name]; !ok {
+			// Reserve the name so recursive references stop here.
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/definitions/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+// nullable allows null in addition to s.
+func nullable(s interface{}) interface{} {
+	m, ok := s.(map[string]interface{})
+	if !ok {
+		return s
+	}
+	if typ, ok := m["type"].(string); ok {
+		n := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			n[k] = v
+		}
+		n["type"] = []string{typ, "null"}
+		return n
+	}
+	if len(m) == 0 {
+		return m
+	}
+	return map[string]interface{}{"anyOf": []interface{}{m, map[string]interface{}{"type": "null"}}}
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) interface{} {
+	props := make(map[string]interface{})
+	var required []string
+	g.addFields(t, props, &required, true)
+	sort.Strings(required)
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// addFields adds the properties of t's fields following encoding/json:
+// fields of embedded structs are promoted unless a shallower field has the
+// same name. Fields of embedded pointers are not required, since they are
+// omitted when the pointer is nil.
+func (g *schemaGen) addFields(t reflect.Type, props map[string]interface{}, required *[]string, req bool) {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, f)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if _, ok := props[name]; ok {
+			continue
+		}
+		props[name] = g.schema(f.Type)
+		if req && !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+	for _, f := range embedded {
+		ft := f.Type
+		ptr := ft.Kind() == reflect.Ptr
+		if ptr {
+			ft = ft.Elem()
+		}
+		g.addFields(ft, props, required, req && !ptr)
+	}
+}
+
+// Schema returns the JSON Schemas of API payloads: all of them, or the one
+// named by the name route variable.
+func Schema(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if name := mux.Vars(r)["name"]; name != "" {
+		v, ok := schemaTypes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown schema: %s", name)
+		}
+		return JSONSchema(name, v), nil
+	}
+	schemas := make(map[string]interface{}, len(schemaTypes))
+	for name, v := range schemaTypes {
+		schemas[name] = JSONSchema(name, v)
+	}
+	return schemas, nil
+}
@@ -0,0 +1,238 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/expr"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+// TestSchemaContract checks the published schemas against the JSON of
+// populated payloads, so they stay in sync with the structs.
+func TestSchemaContract(t *testing.T) {
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	value := 2.5
+	result := &sched.Result{
+		Result: &expr.Result{
+			Computations: expr.Computations{{Text: "avg(q(...))", Value: expr.Number(2.5)}},
+			Value:        expr.Number(math.NaN()),
+			Group:        opentsdb.TagSet{"host": "a"},
+		},
+		Expr: "avg(q(...)) > 1",
+	}
+	state := &sched.State{
+		Result: result,
+		History: []sched.Event{
+			{Crit: result, Status: sched.StCritical, Time: now, IncidentId: 1, Value: &value},
+			{Status: sched.StNormal, Time: now},
+		},
+		Actions: []sched.Action{{User: "u", Message: "m", Reason: "r", Time: now, Type: sched.ActionAcknowledge}},
+		Touched: now,
+		Alert:   "a",
+		Tags:    "host=a",
+		Group:   opentsdb.TagSet{"host": "a"},
+		NeedAck: true,
+		Open:    true,
+	}
+	groups := &sched.StateGroups{
+		TimeAndDate:   []int{1},
+		FailingAlerts: 1,
+	}
+	groups.Groups.NeedAck = []*sched.StateGroup{{
+		Active:   true,
+		Status:   sched.StCritical,
+		Subject:  "s",
+		Alert:    "a",
+		Note:     &models.AlertNote{Text: "n", User: "u", Time: now},
+		AlertKey: "a{host=a}",
+		Children: []*sched.StateGroup{{Status: sched.StWarning, AlertKey: "a{host=a}", State: state}},
+	}}
+	samples := map[string][]interface{}{
+		"Action": {
+			actionRequest{Type: "ack", User: "u", Message: "m", Keys: []string{"a{host=a}"}, Notify: true},
+			actionRequest{},
+		},
+		"Incident": {
+			sched.Incident{Id: 1, Start: now, End: &now, AlertKey: "a{host=a}", Namespace: "n"},
+			sched.Incident{Id: 2, Start: now, AlertKey: "a{host=a}"},
+		},
+		"Silence": {
+			&sched.Silence{Start: now, End: now, Alert: "a", Namespace: "n", Tags: opentsdb.TagSet{"host": "a"}, Forget: true},
+			&sched.Silence{Start: now, End: now},
+		},
+		"StateGroups": {groups, &sched.StateGroups{}},
+	}
+	for name := range schemaTypes {
+		if len(samples[name]) == 0 {
+			t.Errorf("%s: no samples", name)
+		}
+	}
+	for name, vs := range samples {
+		v, ok := schemaTypes[name]
+		if !ok {
+			t.Errorf("%s: no schema", name)
+			continue
+		}
+		// Round trip the schema as it is served.
+		var schema map[string]interface{}
+		if err := roundTrip(JSONSchema(name, v), &schema); err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range vs {
+			var doc interface{}
+			if err := roundTrip(v, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if err := validate(schema, schema, doc, name); err != nil {
+				t.Errorf("%s sample %d: %v", name, i, err)
+			}
+		}
+	}
+}
+
+func roundTrip(v, into interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, into)
+}
+
+// validate checks doc against the subset of JSON Schema that JSONSchema
+// generates.
+func validate(root, schema map[string]interface{}, doc interface{}, path string) error {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		def, ok := root["definitions"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: missing definition %s", path, ref)
+		}
+		return validate(root, def, doc, path)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		var errs []string
+		for _, s := range anyOf {
+			err := validate(root, s.(map[string]interface{}), doc, path)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err.Error())
+		}
+		return fmt.Errorf("%s: no match: %s", path, strings.Join(errs, "; "))
+	}
+	if typ, ok := schema["type"]; ok {
+		var types []interface{}
+		if s, ok := typ.(string); ok {
+			types = []interface{}{s}
+		} else {
+			types = typ.([]interface{})
+		}
+		matched := false
+		for _, t := range types {
+			if jsonType(doc, t.(string)) {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: %T is not %v", path, doc, typ)
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		matched := false
+		for _, e := range enum {
+			if e == doc {
+				matched = true
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v not in %v", path, doc, enum)
+		}
+	}
+	switch doc := doc.(type) {
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, v := range doc {
+				if err := validate(root, items, v, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := doc[r.(string)]; !ok {
+					return fmt.Errorf("%s: missing required %s", path, r)
+				}
+			}
+		}
+		for k, v := range doc {
+			p := path + "." + k
+			if s, ok := props[k].(map[string]interface{}); ok {
+				if err := validate(root, s, v, p); err != nil {
+					return err
+				}
+				continue
+			}
+			switch extra := schema["additionalProperties"].(type) {
+			case bool:
+				if !extra {
+					return fmt.Errorf("%s: unexpected property", p)
+				}
+			case map[string]interface{}:
+				if err := validate(root, extra, v, p); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v interface{}, typ string) bool {
+	switch v := v.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == math.Trunc(v))
+	case string:
+		return typ == "string"
+	case []interface{}:
+		return typ == "array"
+	case map[string]interface{}:
+		return typ == "object"
+	}
+	return false
+}
+
+func TestSchemaRequired(t *testing.T) {
+	s := JSONSchema("Incident", sched.Incident{})
+	def := s["definitions"].(map[string]interface{})["sched.Incident"].(map[string]interface{})
+	got := strings.Join(def["required"].([]string), ",")
+	if want := "AlertKey,End,Id,Start"; got != want {
+		t.Errorf("got required %s, want %s", got, want)
+	}
+	// Fields of the embedded *Result are omitted when it is nil.
+	def = s["definitions"].(map[string]interface{})
+	if _, ok := def["sched.State"]; ok {
+		t.Errorf("unexpected sched.State definition in Incident schema")
+	}
+	state := JSONSchema("State", sched.State{})["definitions"].(map[string]interface{})["sched.State"].(map[string]interface{})
+	for _, r := range state["required"].([]string) {
+		if r == "Expr" || r == "Computations" {
+			t.Errorf("%s should not be required", r)
+		}
+	}
+	if _, ok := state["properties"].(map[string]interface{})["Computations"]; !ok {
+		t.Errorf("missing promoted Computations property")
+	}
+}
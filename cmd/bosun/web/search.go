@@ -1,6 +1,7 @@
 package web
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -79,3 +80,51 @@ func TagValuesByTagKey(t miniprofiler.Timer, w http.ResponseWriter, r *http.Requ
 	}
 	return schedule.Search.TagValuesByTagKey(tagk, time.Duration(since))
 }
+
+// SearchFirstSeen returns when each value of the tagk parameter, or each
+// metric, was first and last seen, optionally only those first seen within
+// the since parameter.
+func SearchFirstSeen(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var since opentsdb.Duration
+	if s := r.FormValue("since"); s != "" {
+		var err error
+		if since, err = opentsdb.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+	return schedule.Search.FirstSeen(r.FormValue("tagk"), time.Duration(since))
+}
+
+// SearchPurge removes the metric or tag key given by the metric or tagk
+// parameter from the search index.
+func SearchPurge(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	metric, tagk := r.FormValue("metric"), r.FormValue("tagk")
+	var n int
+	var err error
+	switch {
+	case metric != "" && tagk != "":
+		return nil, fmt.Errorf("only one of metric and tagk may be given")
+	case metric != "":
+		n, err = schedule.Search.PurgeMetric(metric)
+	case tagk != "":
+		n, err = schedule.Search.PurgeTagKey(tagk)
+	default:
+		return nil, fmt.Errorf("metric or tagk required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return struct{ Removed int }{n}, nil
+}
+
+// SearchCompaction returns the progress of the running or last search index
+// compaction.
+func SearchCompaction(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.Search.CompactionStatus(), nil
+}
+
+// SearchCardinality lists the tag keys that went over their cardinality
+// limits.
+func SearchCardinality(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.Search.Offenders(), nil
+}
@@ -8,9 +8,12 @@ import (
 	"html/template"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,9 +21,9 @@ import (
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
 	"bosun.org/_third_party/github.com/gorilla/mux"
 	"bosun.org/cmd/bosun/conf"
-	"bosun.org/cmd/bosun/expr"
 	"bosun.org/cmd/bosun/sched"
 	"bosun.org/collect"
+	"bosun.org/expr"
 	"bosun.org/metadata"
 	"bosun.org/models"
 	"bosun.org/opentsdb"
@@ -55,6 +58,8 @@ func init() {
 		"Bytes per second relayed from Bosun to the backend server.")
 	metadata.AddMetricMeta("bosun.relay.response", metadata.Counter, metadata.PerSecond,
 		"HTTP response codes from the backend server for request relayed through Bosun.")
+	metadata.AddMetricMeta("bosun.metadata.put_limited", metadata.Counter, metadata.Item,
+		"The count of metadata entries rejected by metadataPutLimit.")
 }
 
 func Listen(listenAddr string, devMode bool, tsdbHost string) error {
@@ -86,35 +91,82 @@ func Listen(listenAddr string, devMode bool, tsdbHost string) error {
 	router.HandleFunc("/api/", APIRedirect)
 	router.Handle("/api/action", JSON(Action))
 	router.Handle("/api/alerts", JSON(Alerts))
+	router.Handle("/api/alertmanager/alerts", JSON(AlertmanagerAlerts))
+	router.Handle("/api/alerts/critical", JSON(CriticalAlerts))
+	router.Handle("/api/alerts/last", JSON(LastRuns))
+	router.Handle("/api/alerts/next", JSON(NextRuns))
+	router.Handle("/api/alerts/note", JSON(AlertNote))
+	router.Handle("/api/alerts/{name}/history", JSON(AlertHistory))
+	router.Handle("/api/alerts/{name}/profile", JSON(AlertProfile))
 	router.Handle("/api/backup", JSON(Backup))
+	router.Handle("/api/cache", JSON(TSDBCache))
+	router.Handle("/api/cache/clear", JSON(TSDBCacheClear))
+	router.Handle("/api/certificates", JSON(Certificates))
+	router.Handle("/api/collect", JSON(Collect))
 	router.Handle("/api/config", miniprofiler.NewHandler(Config))
 	router.Handle("/api/config_test", miniprofiler.NewHandler(ConfigTest))
+	router.Handle("/api/consistency", JSON(Consistency))
+	router.Handle("/api/cycles", JSON(Cycles))
+	router.Handle("/api/dryrun", JSON(DryRun))
 	router.Handle("/api/egraph/{bs}.svg", JSON(ExprGraph))
 	router.Handle("/api/errors", JSON(ErrorHistory))
+	router.Handle("/api/events", JSON(Events))
+	router.Handle("/api/exclusion/clear", JSON(ExclusionClear))
+	router.Handle("/api/exclusion/get", JSON(ExclusionGet))
+	router.Handle("/api/exclusion/set", JSON(ExclusionSet))
 	router.Handle("/api/expr", JSON(Expr))
+	router.Handle("/api/expr/check", JSON(ExprCheck)).Methods("POST")
 	router.Handle("/api/graph", JSON(Graph))
 	router.Handle("/api/health", JSON(HealthCheck))
 	router.Handle("/api/host", JSON(Host))
+	router.Handle("/api/host/{host}/maintenance", JSON(HostMaintenance))
 	router.Handle("/api/last", JSON(Last))
+	router.HandleFunc("/api/logs/tail", LogTail)
+	router.Handle("/api/maintenance", JSON(MaintenanceGet))
+	router.Handle("/api/annotations", JSON(Annotations)).Methods("POST")
+	router.Handle("/api/auth", JSON(Auth))
 	router.Handle("/api/incidents", JSON(Incidents))
 	router.Handle("/api/incidents/events", JSON(IncidentEvents))
+	router.Handle("/api/incidents/clusters", JSON(IncidentClusters))
+	router.Handle("/api/incidents/{id}", JSON(IncidentUpdate))
+	router.Handle("/api/incidents/{id}/notes", JSON(IncidentNotes))
+	router.Handle("/api/incidents/{id}/snapshot", JSON(IncidentSnapshot))
 	router.Handle("/api/metadata/get", JSON(GetMetadata))
 	router.Handle("/api/metadata/metrics", JSON(MetadataMetrics))
 	router.Handle("/api/metadata/put", JSON(PutMetadata))
 	router.Handle("/api/metadata/delete", JSON(DeleteMetadata)).Methods("DELETE")
 	router.Handle("/api/metric", JSON(UniqueMetrics))
 	router.Handle("/api/metric/{tagk}/{tagv}", JSON(MetricsByTagPair))
+	router.Handle("/api/notifications/groups", JSON(NotificationGroups))
+	router.Handle("/api/notifications/log", JSON(NotificationLog))
+	router.Handle("/api/notifications/upcoming", JSON(UpcomingNotifications))
+	router.Handle("/api/oncall", JSON(OnCall))
+	router.Handle("/api/reasons", JSON(Reasons))
 	router.Handle("/api/rule", JSON(Rule))
+	router.Handle("/api/template/test", JSON(TemplateTest))
+	router.Handle("/api/runtime/config", JSON(RuntimeConfig))
+	router.Handle("/api/schema", JSON(Schema))
+	router.Handle("/api/schema/{name}", JSON(Schema))
+	router.Handle("/api/search/cardinality", JSON(SearchCardinality))
+	router.Handle("/api/search/compaction", JSON(SearchCompaction))
+	router.Handle("/api/search/firstseen", JSON(SearchFirstSeen))
+	router.Handle("/api/search/purge", JSON(SearchPurge)).Methods("POST")
 	router.HandleFunc("/api/shorten", Shorten)
+	router.Handle("/api/silence", JSON(Silence))
 	router.Handle("/api/silence/clear", JSON(SilenceClear))
 	router.Handle("/api/silence/get", JSON(SilenceGet))
+	router.Handle("/api/silence/effects", JSON(SilenceEffects))
 	router.Handle("/api/silence/set", JSON(SilenceSet))
 	router.Handle("/api/status", JSON(Status))
+	router.Handle("/api/subscriptions", JSON(Subscriptions))
+	router.Handle("/api/subscriptions/{id}", JSON(SubscriptionDelete)).Methods("DELETE")
 	router.Handle("/api/tagk/{metric}", JSON(TagKeysByMetric))
 	router.Handle("/api/tagv/{tagk}", JSON(TagValuesByTagKey))
 	router.Handle("/api/tagv/{tagk}/{metric}", JSON(TagValuesByMetricTagKey))
 	router.Handle("/api/tagsets/{metric}", JSON(FilteredTagsetsByMetric))
+	router.Handle("/api/tickets/{name}/closed", JSON(TicketClosed)).Methods("POST")
 	router.HandleFunc("/api/version", Version)
+	router.HandleFunc("/api/ws", DashboardSocket)
 	router.Handle("/api/debug/schedlock", JSON(ScheduleLockStatus))
 	http.Handle("/", miniprofiler.NewHandler(Index))
 	http.Handle("/api/", router)
@@ -122,9 +174,23 @@ func Listen(listenAddr string, devMode bool, tsdbHost string) error {
 	http.Handle("/partials/", fs)
 	http.Handle("/static/", http.StripPrefix("/static/", fs))
 	http.Handle("/favicon.ico", fs)
+	c := schedule.Conf
+	if c.PublicListen != "" {
+		go func() {
+			slog.Infoln("bosun public web listening on:", c.PublicListen)
+			h := basicAuth(c.PublicAuth, publicOnly(http.DefaultServeMux), nil)
+			slog.Fatal(serve(c.PublicListen, c.PublicTLSCert, c.PublicTLSKey, h))
+		}()
+	}
 	slog.Infoln("bosun web listening on:", listenAddr)
 	slog.Infoln("tsdb host:", tsdbHost)
-	return http.ListenAndServe(listenAddr, nil)
+	h := leaderOnly(http.DefaultServeMux, schedule.IsLeader)
+	if authenticate := newAuthenticator(c); authenticate != nil {
+		h = authorize(c, authenticate, h, ingestPaths)
+	} else {
+		h = basicAuth(c.HTTPAuth, h, ingestPaths)
+	}
+	return serve(listenAddr, c.HTTPTLSCert, c.HTTPTLSKey, h)
 }
 
 type relayProxy struct {
@@ -295,29 +361,52 @@ func Shorten(w http.ResponseWriter, r *http.Request) {
 type Health struct {
 	// RuleCheck is true if last check happened within the check frequency window.
 	RuleCheck bool
+	// Leader is true if this instance checks alerts: it holds the HA leader
+	// lease, or HA is not enabled.
+	Leader bool
+	// LeaderID is the HA id of the instance holding the leader lease.
+	LeaderID string `json:",omitempty"`
+	// Ready is true once checks have started, after the datasources
+	// passed their readiness probes or readinessTimeout elapsed. NotReady
+	// are the datasources that have not passed.
+	Ready    bool
+	NotReady []string `json:",omitempty"`
 }
 
 func HealthCheck(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	var h Health
 	h.RuleCheck = schedule.LastCheck.After(time.Now().Add(-schedule.Conf.CheckFrequency))
+	h.Leader = schedule.IsLeader()
+	id, err := schedule.Leader()
+	if err != nil {
+		return nil, err
+	}
+	h.LeaderID = id
+	h.Ready, h.NotReady = schedule.Ready()
 	return h, nil
 }
 
+// metadataLimiter enforces metadataPutLimit.
+var metadataLimiter sourceLimiter
+
 func PutMetadata(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	d := json.NewDecoder(r.Body)
 	var ms []metadata.Metasend
 	if err := d.Decode(&ms); err != nil {
 		return nil, err
 	}
-	for _, m := range ms {
-		err := schedule.PutMetadata(metadata.Metakey{
-			Metric: m.Metric,
-			Tags:   m.Tags.Tags(),
-			Name:   m.Name,
-		}, m.Value)
-		if err != nil {
-			return nil, err
-		}
+	source, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		source = r.RemoteAddr
+	}
+	if ok, wait := metadataLimiter.allow(source, len(ms), schedule.Conf.MetadataPutLimit, time.Now()); !ok {
+		collect.Add("metadata.put_limited", nil, int64(len(ms)))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "metadata put limit exceeded", 429)
+		return nil, nil
+	}
+	if err := schedule.PutMetadataBatch(ms); err != nil {
+		return nil, err
 	}
 	w.WriteHeader(204)
 	return nil, nil
@@ -363,15 +452,163 @@ func MetadataMetrics(t miniprofiler.Timer, w http.ResponseWriter, r *http.Reques
 }
 
 func Alerts(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	return schedule.MarshalGroups(t, r.FormValue("filter"))
+	filter, page, err := alertsQuery(r)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := schedule.MarshalGroups(t, filter, page)
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(groups.Total))
+	return groups, nil
 }
 
-func Backup(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	data, err := schedule.GetStateFileBackup()
+// alertsQuery returns the dashboard filter and page of the /api/alerts
+// parameters of r.
+func alertsQuery(r *http.Request) (filter string, page sched.GroupsPage, err error) {
+	filter = r.FormValue("filter")
+	if ns := r.FormValue("namespace"); ns != "" {
+		filter += " namespace:" + ns
+	}
+	page = sched.GroupsPage{
+		Search: r.FormValue("search"),
+		Sort:   r.FormValue("sort"),
+	}
+	for name, v := range map[string]*int{"offset": &page.Offset, "limit": &page.Limit} {
+		if f := r.FormValue(name); f != "" {
+			i, err := strconv.Atoi(f)
+			if err != nil {
+				return "", page, fmt.Errorf("bad %s: %v", name, err)
+			}
+			*v = i
+		}
+	}
+	return filter, page, nil
+}
+
+func CriticalAlerts(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.CriticalUnacked(), nil
+}
+
+// AlertmanagerAlerts returns the firing alert keys in the alert format of
+// the Alertmanager API.
+func AlertmanagerAlerts(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.AlertmanagerAlerts(), nil
+}
+
+func LastRuns(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.LastRuns(), nil
+}
+
+func NextRuns(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.NextRuns(), nil
+}
+
+func AlertNote(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		var data map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return nil, err
+		}
+		return nil, schedule.SetAlertNote(data["alert"], data["note"], actingUser(r, data["user"]))
+	}
+	if alert := r.FormValue("alert"); alert != "" {
+		return schedule.DataAccess.AlertNotes().GetAlertNote(alert)
+	}
+	return schedule.DataAccess.AlertNotes().GetAlertNotes()
+}
+
+// AlertProfile returns how long each step of the named alert's expressions
+// took in its last check.
+func AlertProfile(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	name := mux.Vars(r)["name"]
+	if schedule.Conf.Alerts[name] == nil {
+		return nil, fmt.Errorf("unknown alert: %s", name)
+	}
+	p, err := schedule.DataAccess.Profiles().GetAlertProfile(name)
 	if err != nil {
 		return nil, err
 	}
-	_, err = w.Write(data)
+	if p == nil {
+		return nil, fmt.Errorf("alert %s has not been checked", name)
+	}
+	return p, nil
+}
+
+// AlertHistory returns the versions of an alert's definition that were
+// loaded, newest first, with the diff of each from the one before it.
+func AlertHistory(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.AlertHistory(mux.Vars(r)["name"])
+}
+
+// TSDBCache returns the state of the OpenTSDB query cache.
+func TSDBCache(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	c := schedule.Conf
+	if c.TSDBCache == nil {
+		return nil, fmt.Errorf("tsdbCacheTTL is not set")
+	}
+	return struct {
+		TTL     string
+		MaxSize int64
+		Size    int64
+		Entries int
+	}{
+		c.TSDBCacheTTL.String(),
+		c.TSDBCacheSize,
+		c.TSDBCache.Size(),
+		c.TSDBCache.Len(),
+	}, nil
+}
+
+// TSDBCacheClear removes all results from the OpenTSDB query cache.
+func TSDBCacheClear(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	schedule.Conf.TSDBCache.Clear()
+	return nil, nil
+}
+
+// Collect gets or sets the destinations of bosun's own metrics. POST a JSON
+// list of hosts to change them; the first is the primary destination, and an
+// empty list disables sending.
+func Collect(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		var hosts []string
+		if err := json.NewDecoder(r.Body).Decode(&hosts); err != nil {
+			return nil, err
+		}
+		urls := make([]*url.URL, len(hosts))
+		for i, h := range hosts {
+			if !strings.Contains(h, "://") {
+				h = "http://" + h
+			}
+			u, err := url.Parse(h)
+			if err != nil {
+				return nil, err
+			}
+			urls[i] = u
+		}
+		if err := collect.SetDestinations(urls...); err != nil {
+			return nil, err
+		}
+	}
+	return struct {
+		Destinations []string
+		Tags         opentsdb.TagSet
+	}{
+		collect.Destinations(),
+		collect.DefaultTags,
+	}, nil
+}
+
+func Backup(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	// Export to memory first so an error is reported instead of a partial
+	// backup.
+	buf := new(bytes.Buffer)
+	if err := schedule.ExportState(buf); err != nil {
+		return nil, err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err := buf.WriteTo(w)
 	return nil, err
 }
 
@@ -388,11 +625,109 @@ func IncidentEvents(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return nil, err
 	}
+	notes, err := schedule.GetIncidentNotes(uint64(num))
+	if err != nil {
+		return nil, err
+	}
 	return struct {
 		Incident *sched.Incident
 		Events   []sched.Event
 		Actions  []sched.Action
-	}{incident, events, actions}, nil
+		Notes    []*models.IncidentNote
+	}{incident, events, actions, notes}, nil
+}
+
+func IncidentNotes(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == "POST" {
+		var data struct {
+			Author string
+			Body   string
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return nil, err
+		}
+		if err := schedule.AddIncidentNote(id, actingUser(r, data.Author), data.Body); err != nil {
+			return nil, err
+		}
+	}
+	return schedule.GetIncidentNotes(id)
+}
+
+// IncidentUpdate returns an incident, after applying the changes of a PATCH
+// request to its owner, severity, and tags.
+func IncidentUpdate(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if r.Method == "PATCH" {
+		var data struct {
+			User string
+			sched.IncidentUpdate
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return nil, err
+		}
+		return schedule.UpdateIncident(id, actingUser(r, data.User), &data.IncidentUpdate)
+	}
+	incident, err := schedule.GetIncident(id)
+	if err != nil {
+		return nil, err
+	}
+	c := *incident
+	return &c, nil
+}
+
+// Subscriptions lists the subscriptions of the user given, or of all users.
+// Users other than admins only see their own. POST a subscription to add it.
+func Subscriptions(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		var sub models.Subscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			return nil, err
+		}
+		sub.User = actingUser(r, sub.User)
+		if err := schedule.AddSubscription(&sub); err != nil {
+			return nil, err
+		}
+		return &sub, nil
+	}
+	user := r.FormValue("user")
+	if owner := ownerOnly(r); owner != "" {
+		user = owner
+	}
+	return schedule.Subscriptions(user)
+}
+
+// SubscriptionDelete removes a subscription. Users other than admins may only
+// remove their own.
+func SubscriptionDelete(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return nil, schedule.DeleteSubscription(id, ownerOnly(r))
+}
+
+// IncidentSnapshot returns the results of the alert's check that opened an
+// incident.
+func IncidentSnapshot(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := schedule.GetIncidentSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("incident %d has no snapshot", id)
+	}
+	return snapshot, nil
 }
 
 func Incidents(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -414,7 +749,7 @@ func Incidents(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (in
 		}
 		toTime = t
 	}
-	incidents := schedule.GetIncidents(alert, fromTime, toTime)
+	incidents := schedule.GetIncidents(alert, r.FormValue("namespace"), fromTime, toTime)
 	maxIncidents := 200
 	if len(incidents) > maxIncidents {
 		incidents = incidents[:maxIncidents]
@@ -422,6 +757,67 @@ func Incidents(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (in
 	return incidents, nil
 }
 
+// TicketClosed closes the incident of a ticket closed in a ticketing system.
+// It is the receiver of the ticketing system's webhook.
+func TicketClosed(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id := r.FormValue("id")
+	if id == "" {
+		return nil, fmt.Errorf("id must be specified")
+	}
+	return schedule.TicketClosed(mux.Vars(r)["name"], id)
+}
+
+// IncidentClusters returns the incidents that started close together on
+// alert keys with the same tags, to find common causes of incidents across
+// alerts.
+func IncidentClusters(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	toTime := time.Now().UTC()
+	fromTime := toTime.Add(-24 * time.Hour)
+	if from := r.FormValue("from"); from != "" {
+		t, err := time.Parse(tsdbFormatSecs, from)
+		if err != nil {
+			return nil, err
+		}
+		fromTime = t
+	}
+	if to := r.FormValue("to"); to != "" {
+		t, err := time.Parse(tsdbFormatSecs, to)
+		if err != nil {
+			return nil, err
+		}
+		toTime = t
+	}
+	gap := 10 * time.Minute
+	if v := r.FormValue("gap"); v != "" {
+		d, err := opentsdb.ParseDuration(v)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("gap must be positive")
+		}
+		gap = time.Duration(d)
+	}
+	min := 2
+	if v := r.FormValue("min"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		if n < 2 {
+			return nil, fmt.Errorf("min must be at least 2")
+		}
+		min = n
+	}
+	var by []string
+	for _, k := range strings.Split(r.FormValue("by"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			by = append(by, k)
+		}
+	}
+	return schedule.ClusterIncidents(by, gap, min, fromTime, toTime), nil
+}
+
 func Status(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
 	r.ParseForm()
 	type ExtStatus struct {
@@ -438,24 +834,39 @@ func Status(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (inter
 		if st.State == nil {
 			return nil, fmt.Errorf("unknown alert key: %v", k)
 		}
+		schedule.RenderBody(st.State)
 		st.AlertName = ak.Name()
 		m[k] = st
 	}
 	return m, nil
 }
 
+// actionRequest is the body of an /api/action request.
+type actionRequest struct {
+	Type    string
+	User    string
+	Message string
+	Reason  string
+	Keys    []string
+	Notify  bool
+	// Atomic changes no alert keys unless the action is valid for all of
+	// them.
+	Atomic bool
+	// Duration is how long a snooze lasts, such as "2h".
+	Duration string `json:",omitempty"`
+	// Related are tag keys, like "cluster". An acknowledgement of them also
+	// acknowledges the other alert keys of the same alerts that need it and
+	// have the same values of the tag keys.
+	Related []string `json:",omitempty"`
+}
+
 func Action(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	var data struct {
-		Type    string
-		User    string
-		Message string
-		Keys    []string
-		Notify  bool
-	}
+	var data actionRequest
 	j := json.NewDecoder(r.Body)
 	if err := j.Decode(&data); err != nil {
 		return nil, err
 	}
+	data.User = actingUser(r, data.User)
 	var at sched.ActionType
 	switch data.Type {
 	case "ack":
@@ -464,39 +875,121 @@ func Action(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (inter
 		at = sched.ActionClose
 	case "forget":
 		at = sched.ActionForget
+	case "snooze":
+		at = sched.ActionSnooze
 	}
-	errs := make(MultiError)
 	r.ParseForm()
-	successful := []expr.AlertKey{}
-	for _, key := range data.Keys {
+	keys := make([]expr.AlertKey, len(data.Keys))
+	for i, key := range data.Keys {
 		ak, err := expr.ParseAlertKey(key)
 		if err != nil {
 			return nil, err
 		}
-		err = schedule.Action(data.User, data.Message, at, ak)
-		if err != nil {
-			errs[key] = err
-		} else {
+		keys[i] = ak
+	}
+	var failed map[expr.AlertKey]error
+	var err error
+	if len(data.Related) != 0 {
+		if at != sched.ActionAcknowledge {
+			return nil, fmt.Errorf("related alert keys are only acknowledged")
+		}
+		keys, failed, err = schedule.BatchAcknowledgeRelated(data.User, data.Message, keys, data.Related, data.Atomic)
+	} else if at == sched.ActionSnooze {
+		if data.Reason != "" {
+			return nil, fmt.Errorf("reason codes are only valid for close and forget actions")
+		}
+		var d opentsdb.Duration
+		if d, err = opentsdb.ParseDuration(data.Duration); err != nil {
+			return nil, err
+		}
+		failed, err = schedule.BatchSnooze(data.User, data.Message, time.Duration(d), keys, data.Atomic)
+	} else {
+		failed, err = schedule.BatchAction(data.User, data.Message, data.Reason, at, keys, data.Atomic)
+	}
+	if err != nil {
+		return nil, err
+	}
+	errs := make(MultiError)
+	successful := []expr.AlertKey{}
+	for _, ak := range keys {
+		if err := failed[ak]; err != nil {
+			errs[string(ak)] = err
+		} else if !data.Atomic || len(failed) == 0 {
 			successful = append(successful, ak)
 		}
 	}
+	if data.Notify && len(successful) != 0 {
+		schedule.ActionNotify(at, data.User, data.Message, successful)
+	}
 	if len(errs) != 0 {
 		return nil, errs
 	}
-	if data.Notify && len(successful) != 0 {
-		schedule.ActionNotify(at, data.User, data.Message, successful)
+	if len(data.Related) != 0 {
+		return successful, nil
 	}
 	return nil, nil
 }
 
+func Reasons(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	toTime := time.Now().UTC()
+	fromTime := toTime.Add(-14 * 24 * time.Hour) // 2 weeks
+	if from := r.FormValue("from"); from != "" {
+		t, err := time.Parse(tsdbFormatSecs, from)
+		if err != nil {
+			return nil, err
+		}
+		fromTime = t
+	}
+	if to := r.FormValue("to"); to != "" {
+		t, err := time.Parse(tsdbFormatSecs, to)
+		if err != nil {
+			return nil, err
+		}
+		toTime = t
+	}
+	report, err := schedule.ReasonReport(r.FormValue("alert"), fromTime, toTime)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		Codes []string
+		*sched.ReasonReport
+	}{
+		schedule.Conf.ReasonCodes,
+		report,
+	}, nil
+}
+
 type MultiError map[string]error
 
+// Error lists the errors one per line, sorted by key.
 func (m MultiError) Error() string {
-	return fmt.Sprint(map[string]error(m))
+	lines := make([]string, 0, len(m))
+	for k, err := range m {
+		lines = append(lines, fmt.Sprintf("%s: %v", k, err))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
 }
 
 func SilenceGet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	return schedule.Silence, nil
+	ns := r.FormValue("namespace")
+	if ns == "" {
+		return schedule.Silence, nil
+	}
+	silences := make(map[string]*sched.Silence)
+	for id, s := range schedule.Silence {
+		if s.Namespace == ns {
+			silences[id] = s
+		}
+	}
+	return silences, nil
+}
+
+// SilenceEffects returns the open alert keys each active silence is
+// suppressing, and the notifications it suppressed.
+func SilenceEffects(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.SilenceEffects(r.FormValue("namespace")), nil
 }
 
 var silenceLayouts = []string{
@@ -511,14 +1004,22 @@ var silenceLayouts = []string{
 }
 
 func SilenceSet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
-	var start, end time.Time
-	var err error
 	var data map[string]string
 	j := json.NewDecoder(r.Body)
 	if err := j.Decode(&data); err != nil {
 		return nil, err
 	}
-	if s := data["start"]; s != "" {
+	start, end, err := parseSilenceTimes(data["start"], data["end"], data["duration"])
+	if err != nil {
+		return nil, err
+	}
+	return schedule.AddSilence(start, end, data["alert"], data["namespace"], data["tags"], data["filter"], data["forget"] == "true", len(data["confirm"]) > 0, data["edit"], actingUser(r, data["user"]), data["message"])
+}
+
+// parseSilenceTimes parses the start and end of a silence. start defaults to
+// now, and end defaults to start plus duration.
+func parseSilenceTimes(startText, endText, duration string) (start, end time.Time, err error) {
+	if s := startText; s != "" {
 		for _, layout := range silenceLayouts {
 			start, err = time.Parse(layout, s)
 			if err == nil {
@@ -526,10 +1027,10 @@ func SilenceSet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (i
 			}
 		}
 		if start.IsZero() {
-			return nil, fmt.Errorf("unrecognized start time format: %s", s)
+			return start, end, fmt.Errorf("unrecognized start time format: %s", s)
 		}
 	}
-	if s := data["end"]; s != "" {
+	if s := endText; s != "" {
 		for _, layout := range silenceLayouts {
 			end, err = time.Parse(layout, s)
 			if err == nil {
@@ -537,20 +1038,62 @@ func SilenceSet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (i
 			}
 		}
 		if end.IsZero() {
-			return nil, fmt.Errorf("unrecognized end time format: %s", s)
+			return start, end, fmt.Errorf("unrecognized end time format: %s", s)
 		}
 	}
 	if start.IsZero() {
 		start = time.Now().UTC()
 	}
 	if end.IsZero() {
-		d, err := opentsdb.ParseDuration(data["duration"])
+		d, err := opentsdb.ParseDuration(duration)
 		if err != nil {
-			return nil, err
+			return start, end, err
 		}
 		end = start.Add(time.Duration(d))
 	}
-	return schedule.AddSilence(start, end, data["alert"], data["tags"], data["forget"] == "true", len(data["confirm"]) > 0, data["edit"], data["user"], data["message"])
+	return start, end, nil
+}
+
+// Silence lists silences on GET, creates or previews a silence from a JSON
+// body on POST, and removes the silence with the given id on DELETE.
+func Silence(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case "GET":
+		return SilenceGet(t, w, r)
+	case "DELETE":
+		return nil, schedule.ClearSilence(r.FormValue("id"))
+	case "POST":
+	default:
+		return nil, fmt.Errorf("unsupported method: %s", r.Method)
+	}
+	var data struct {
+		Start, End, Duration   string
+		Alert, Namespace, Tags string
+		Filter                 string
+		Forget, Preview        bool
+		User, Message          string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	start, end, err := parseSilenceTimes(data.Start, data.End, data.Duration)
+	if err != nil {
+		return nil, err
+	}
+	si, err := sched.NewSilence(start, end, data.Alert, data.Namespace, data.Tags, data.Filter, data.Forget, actingUser(r, data.User), data.Message)
+	if err != nil {
+		return nil, err
+	}
+	res := struct {
+		Id        string `json:",omitempty"`
+		AlertKeys expr.AlertKeys
+	}{
+		AlertKeys: schedule.PreviewSilence(si),
+	}
+	if !data.Preview {
+		res.Id = schedule.CreateSilence(si)
+	}
+	return res, nil
 }
 
 func SilenceClear(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
@@ -558,6 +1101,32 @@ func SilenceClear(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request)
 	return nil, schedule.ClearSilence(id)
 }
 
+func ExclusionGet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.GetExclusions(), nil
+}
+
+func ExclusionSet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	var data map[string]string
+	j := json.NewDecoder(r.Body)
+	if err := j.Decode(&data); err != nil {
+		return nil, err
+	}
+	var expires time.Time
+	if s := data["duration"]; s != "" {
+		d, err := opentsdb.ParseDuration(s)
+		if err != nil {
+			return nil, err
+		}
+		expires = time.Now().UTC().Add(time.Duration(d))
+	}
+	return schedule.AddExclusion(data["alert"], data["tags"], expires, actingUser(r, data["user"]), data["message"])
+}
+
+func ExclusionClear(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	id := r.FormValue("id")
+	return nil, schedule.ClearExclusion(id)
+}
+
 func ConfigTest(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) {
 	b, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -574,6 +1143,12 @@ func ConfigTest(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// RuntimeConfig returns the effective configuration bosun is running with,
+// with secrets redacted.
+func RuntimeConfig(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.Conf.Runtime(), nil
+}
+
 func Config(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) {
 	var text string
 	var err error
@@ -597,6 +1172,98 @@ func Host(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interfa
 	return schedule.Host(r.FormValue("filter"))
 }
 
+// HostMaintenance returns the maintenance of a host on GET, puts the host into
+// maintenance from a JSON body on PUT, and takes it out on DELETE.
+func HostMaintenance(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	host := mux.Vars(r)["host"]
+	switch r.Method {
+	case "GET":
+		return schedule.HostMaintenance(time.Now(), host), nil
+	case "DELETE":
+		return nil, schedule.ClearMaintenance(host)
+	case "PUT":
+	default:
+		return nil, fmt.Errorf("unsupported method: %s", r.Method)
+	}
+	var data struct {
+		End, Duration string
+		User, Message string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil && err != io.EOF {
+		return nil, err
+	}
+	var end time.Time
+	if data.End != "" || data.Duration != "" {
+		var err error
+		_, end, err = parseSilenceTimes("", data.End, data.Duration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return schedule.SetMaintenance(host, end, actingUser(r, data.User), data.Message)
+}
+
+func MaintenanceGet(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.GetMaintenance(), nil
+}
+
+// Certificates returns the certificates found by tls probes, soonest to
+// expire first.
+// Consistency reports stored state that refers to alerts or notifications no
+// longer in the configuration. A POST also removes it.
+func Consistency(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.CheckConsistency(r.Method == "POST")
+}
+
+// Cycles returns the summaries of the last n check cycles, 60 by default,
+// newest first.
+func Cycles(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	n := 60
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("n must be > 0")
+		}
+	}
+	return schedule.CycleSummaries(n)
+}
+
+func Certificates(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.Certificates()
+}
+
+// Events stores events POSTed as a JSON event or list of events, to be
+// counted by the events expression function. A GET lists the events of the
+// optional type parameter in the last duration, 1d by default.
+func Events(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		var es []*models.Event
+		if err := json.Unmarshal(body, &es); err != nil {
+			e := new(models.Event)
+			if err := json.Unmarshal(body, e); err != nil {
+				return nil, err
+			}
+			es = []*models.Event{e}
+		}
+		return es, schedule.PutEvents(es)
+	}
+	d := opentsdb.Day
+	if v := r.FormValue("duration"); v != "" {
+		var err error
+		if d, err = opentsdb.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	return schedule.RecentEvents(r.FormValue("type"), time.Duration(d))
+}
+
 // Last returns the most recent datapoint for a metric+tagset. The metric+tagset
 // string should be formated like os.cpu{host=foo}. The tag porition expects the
 // that the keys will be in alphabetical order.
@@ -668,3 +1335,102 @@ func ErrorHistory(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request)
 	}
 	return nil, nil
 }
+
+func NotificationLog(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		ids := []int64{}
+		if err := json.NewDecoder(r.Body).Decode(&ids); err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if err := schedule.RetryDelivery(id); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	limit := 100
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil {
+			return nil, err
+		}
+	}
+	log, err := schedule.DataAccess.Deliveries().GetDeliveryLog(limit)
+	if err != nil {
+		return nil, err
+	}
+	if status := models.DeliveryStatus(r.FormValue("status")); status != "" {
+		filtered := log[:0]
+		for _, d := range log {
+			if d.Status == status {
+				filtered = append(filtered, d)
+			}
+		}
+		log = filtered
+	}
+	return log, nil
+}
+
+// DryRun returns which notifications are recorded instead of sent, or on
+// POST puts an alert, or all notifications, into or out of dry run mode.
+func DryRun(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	if r.Method == "POST" {
+		var data struct {
+			Alert   string
+			Enabled bool
+		}
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			return nil, err
+		}
+		if err := schedule.SetDryRun(data.Alert, data.Enabled); err != nil {
+			return nil, err
+		}
+	}
+	return schedule.DryRun(), nil
+}
+
+// NotificationGroups returns the notification groups and the delivery health
+// of their members.
+func NotificationGroups(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	return schedule.NotificationGroups(), nil
+}
+
+// UpcomingNotifications returns the notifications that will be sent within
+// the horizon, a day by default, unless their alert keys are acked first.
+func UpcomingNotifications(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	d := opentsdb.Day
+	if v := r.FormValue("horizon"); v != "" {
+		var err error
+		if d, err = opentsdb.ParseDuration(v); err != nil {
+			return nil, err
+		}
+	}
+	return schedule.UpcomingNotifications(r.FormValue("alert"), time.Now().UTC(), time.Duration(d))
+}
+
+// OnCall returns who is on duty now in each on-call schedule.
+func OnCall(t miniprofiler.Timer, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+	type onDuty struct {
+		Name   string
+		OnDuty string `json:",omitempty"`
+		Error  string `json:",omitempty"`
+	}
+	var names []string
+	for name := range schedule.Conf.OnCalls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	now := time.Now()
+	l := []onDuty{}
+	for _, name := range names {
+		d := onDuty{Name: name}
+		if a, err := schedule.Conf.OnCalls[name].OnDuty(now); err != nil {
+			d.Error = err.Error()
+		} else {
+			d.OnDuty = a.String()
+		}
+		l = append(l, d)
+	}
+	return l, nil
+}
@@ -1,10 +1,15 @@
 package web
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"bosun.org/cmd/bosun/conf"
+	"bosun.org/cmd/bosun/database"
+	"bosun.org/opentsdb"
 )
 
 func TestErrorTemplate(t *testing.T) {
@@ -25,3 +30,121 @@ func TestErrorTemplate(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseSilenceTimes(t *testing.T) {
+	start, end, err := parseSilenceTimes("2015-01-02 15:04", "", "1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2015, 1, 2, 15, 4, 0, 0, time.UTC)) || end.Sub(start) != time.Hour {
+		t.Errorf("unexpected times %v - %v", start, end)
+	}
+	if _, _, err := parseSilenceTimes("tomorrow", "", "1h"); err == nil {
+		t.Error("expected error for bad start time")
+	}
+	if _, _, err := parseSilenceTimes("", "", ""); err == nil {
+		t.Error("expected error for missing end and duration")
+	}
+}
+
+func TestMakeChartMeta(t *testing.T) {
+	rs := opentsdb.ResponseSet{
+		{Metric: "net.bytes", DPS: map[string]opentsdb.Point{"1": 1, "2": 2}},
+		{Metric: "cpu", DPS: map[string]opentsdb.Point{"1": 1}},
+	}
+	meta := map[string]*seriesMeta{
+		"net.bytes": {Unit: "bytes", Rate: "counter", Rated: true},
+	}
+	cs, err := makeChart(rs, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(cs))
+	}
+	if cs[0].Unit != "bytes" || !cs[0].Rated || cs[0].Hint != "counter converted to a per-second rate: bytes per second" {
+		t.Errorf("unexpected metadata for net.bytes: %+v", cs[0])
+	}
+	if cs[1].Unit != "" || cs[1].Hint != "" {
+		t.Errorf("unexpected metadata for cpu: %+v", cs[1])
+	}
+	if h := (&seriesMeta{Rate: "counter"}).hint(); h == "" {
+		t.Error("expected hint for unrated counter")
+	}
+}
+
+func TestQueriesMeta(t *testing.T) {
+	queries := []opentsdb.Request{
+		{Queries: []*opentsdb.Query{
+			{Metric: "net.bytes", Rate: true},
+			{Metric: "cpu"},
+		}},
+		{Queries: []*opentsdb.Query{
+			{Metric: "broken"},
+			{Metric: "net.bytes", Rate: true},
+		}},
+	}
+	get := func(metric string) (*database.MetricMetadata, error) {
+		switch metric {
+		case "net.bytes":
+			return &database.MetricMetadata{Unit: "bytes", Rate: "counter"}, nil
+		case "broken":
+			return nil, fmt.Errorf("redis down")
+		}
+		return nil, nil
+	}
+	m := queriesMeta(queries, get)
+	if len(m) != 1 {
+		t.Fatalf("expected metadata for only net.bytes, got %v", m)
+	}
+	if mm := m["net.bytes"]; mm.Unit != "bytes" || !mm.Rated || mm.Hint != "counter converted to a per-second rate: bytes per second" {
+		t.Errorf("unexpected metadata for net.bytes: %+v", mm)
+	}
+}
+
+func TestListenerFilters(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	public := basicAuth("u:p", publicOnly(ok), nil)
+	admin := basicAuth("u:p", ok, ingestPaths)
+	standby := leaderOnly(ok, func() bool { return false })
+	tests := []struct {
+		h      http.Handler
+		method string
+		path   string
+		auth   bool
+		code   int
+	}{
+		{public, "GET", "/api/graph", true, 200},
+		{public, "GET", "/api/graph", false, 401},
+		{public, "GET", "/api/tagv/host", true, 200},
+		{public, "GET", "/incident", true, 200},
+		{public, "GET", "/api/silence/get", true, 403},
+		{public, "POST", "/api/graph", true, 403},
+		{public, "POST", "/api/action", true, 403},
+		{admin, "POST", "/api/action", true, 200},
+		{admin, "POST", "/api/action", false, 401},
+		{admin, "POST", "/api/put", false, 200},
+		{admin, "POST", "/api/metadata/put", false, 401},
+		{admin, "POST", "/api/metadata/put", true, 200},
+		{standby, "POST", "/api/action", false, 503},
+		{standby, "POST", "/api/incidents/3/notes", false, 503},
+		{standby, "GET", "/api/incidents/3/notes", false, 200},
+		{standby, "POST", "/api/silence/get", false, 200},
+		{standby, "DELETE", "/api/silence", false, 503},
+		{standby, "POST", "/api/consistency", false, 503},
+		{standby, "POST", "/api/notifications/log", false, 503},
+		{standby, "GET", "/api/notifications/log", false, 200},
+		{standby, "POST", "/api/metadata/put", false, 200},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		if test.auth {
+			r.SetBasicAuth("u", "p")
+		}
+		w := httptest.NewRecorder()
+		test.h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s (auth %v): got %d, expected %d", test.method, test.path, test.auth, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/_third_party/golang.org/x/net/websocket"
+	"bosun.org/cmd/bosun/sched"
+	"bosun.org/slog"
+)
+
+// wsWriteLimit is the longest sending a message may take before the
+// connection is abandoned.
+const wsWriteLimit = 30 * time.Second
+
+// wsCheckOrigin rejects handshakes from pages of other hosts, so other sites
+// can't read the dashboard with a user's credentials. Clients that aren't
+// browsers may leave out the Origin header.
+func wsCheckOrigin(config *websocket.Config, r *http.Request) error {
+	if r.Header.Get("Origin") == "" {
+		return nil
+	}
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !strings.EqualFold(origin.Host, r.Host) {
+		return fmt.Errorf("websocket origin %s does not match host %s", r.Header.Get("Origin"), r.Host)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// dashboardInterval is the least time between dashboard updates, so the
+// many state changes of a check are sent together.
+var dashboardInterval = time.Second
+
+// DashboardUpdate is a message of /api/ws. Groups are identified by Key, the
+// alert key of single alert key groups, or the comma-separated alert keys of
+// their children.
+type DashboardUpdate struct {
+	// Reset is set on the first message: the client should discard the
+	// groups it has.
+	Reset bool `json:",omitempty"`
+	// Changed are the groups that are new or changed.
+	Changed []*DashboardGroup `json:",omitempty"`
+	// Removed are the keys of groups that are no longer shown.
+	Removed []string `json:",omitempty"`
+	// Order is the keys of all groups in dashboard order.
+	Order []string
+
+	Total                         int
+	FailingAlerts, UnclosedErrors int
+}
+
+// DashboardGroup is a changed group of a DashboardUpdate.
+type DashboardGroup struct {
+	Key     string
+	NeedAck bool
+	Group   *sched.StateGroup
+}
+
+// dashboardGroupKey returns the key of g in a DashboardUpdate.
+func dashboardGroupKey(g *sched.StateGroup) string {
+	if g.AlertKey != "" {
+		return string(g.AlertKey)
+	}
+	keys := make([]string, len(g.Children))
+	for i, c := range g.Children {
+		keys[i] = string(c.AlertKey)
+	}
+	return strings.Join(keys, ",")
+}
+
+// dashboardSignature returns what must differ for g to be sent as changed,
+// leaving out values and touch times, which change every check.
+func dashboardSignature(g *sched.StateGroup, needAck bool) string {
+	var b bytes.Buffer
+	fmt.Fprint(&b, needAck)
+	var sign func(g *sched.StateGroup)
+	sign = func(g *sched.StateGroup) {
+		fmt.Fprintf(&b, "|%v %v %v %v %v %q %q %q %q", g.Active, g.Status, g.Severity, g.Silenced, g.IsError, g.Subject, g.AlertKey, g.Ago, g.Owner)
+		if g.Note != nil {
+			fmt.Fprintf(&b, " %q %q", g.Note.Text, g.Note.User)
+		}
+		if st := g.State; st != nil {
+			fmt.Fprintf(&b, " %v %v %v %v", st.NeedAck, st.Open, st.Forgotten, st.Unevaluated)
+			if n := len(st.Actions); n > 0 {
+				fmt.Fprintf(&b, " %v", st.Actions[n-1].Time.UnixNano())
+			}
+		}
+		for _, c := range g.Children {
+			sign(c)
+		}
+	}
+	sign(g)
+	return b.String()
+}
+
+// dashboardDiff returns the update from the groups with signatures last to
+// groups, and the signatures of groups. If last is nil, the update resets the
+// client.
+func dashboardDiff(last map[string]string, groups *sched.StateGroups) (*DashboardUpdate, map[string]string) {
+	u := &DashboardUpdate{
+		Reset:          last == nil,
+		Total:          groups.Total,
+		FailingAlerts:  groups.FailingAlerts,
+		UnclosedErrors: groups.UnclosedErrors,
+	}
+	sigs := make(map[string]string)
+	add := func(gs []*sched.StateGroup, needAck bool) {
+		for _, g := range gs {
+			key := dashboardGroupKey(g)
+			sig := dashboardSignature(g, needAck)
+			sigs[key] = sig
+			u.Order = append(u.Order, key)
+			if prev, ok := last[key]; !ok || prev != sig {
+				u.Changed = append(u.Changed, &DashboardGroup{
+					Key:     key,
+					NeedAck: needAck,
+					Group:   g,
+				})
+			}
+		}
+	}
+	add(groups.Groups.NeedAck, true)
+	add(groups.Groups.Acknowledged, false)
+	for key := range last {
+		if _, ok := sigs[key]; !ok {
+			u.Removed = append(u.Removed, key)
+		}
+	}
+	return u, sigs
+}
+
+// DashboardSocket pushes the dashboard's groups over a WebSocket. It takes
+// the parameters of /api/alerts. The first message has all groups, and later
+// ones the groups that changed, sent when alert states change, alerts are
+// acknowledged, or silences or alert notes change.
+func DashboardSocket(w http.ResponseWriter, r *http.Request) {
+	filter, page, err := alertsQuery(r)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		http.Error(w, "websockets not supported", http.StatusBadRequest)
+		return
+	}
+	websocket.Server{
+		Handshake: wsCheckOrigin,
+		Handler: func(ws *websocket.Conn) {
+			dashboardSocket(ws, filter, page)
+		},
+	}.ServeHTTP(w, r)
+}
+
+func dashboardSocket(ws *websocket.Conn, filter string, page sched.GroupsPage) {
+	defer ws.Close()
+	// Clients send nothing: reading answers their pings and notices when
+	// they close.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(ioutil.Discard, ws)
+	}()
+	var (
+		sigs map[string]string
+		last *DashboardUpdate
+	)
+	for {
+		changed := schedule.Changed()
+		groups, err := schedule.MarshalGroups(new(miniprofiler.Profile), filter, page)
+		if err != nil {
+			slog.Errorln("dashboard socket:", err)
+			return
+		}
+		var u *DashboardUpdate
+		u, sigs = dashboardDiff(sigs, groups)
+		if u.Reset || len(u.Changed) > 0 || len(u.Removed) > 0 || u.Total != last.Total || u.FailingAlerts != last.FailingAlerts || u.UnclosedErrors != last.UnclosedErrors || !reflect.DeepEqual(u.Order, last.Order) {
+			ws.SetWriteDeadline(time.Now().Add(wsWriteLimit))
+			if err := websocket.JSON.Send(ws, u); err != nil {
+				return
+			}
+			last = u
+		}
+		select {
+		case <-done:
+			return
+		case <-changed:
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(dashboardInterval):
+		}
+	}
+}
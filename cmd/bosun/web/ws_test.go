@@ -0,0 +1,69 @@
+package web
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"bosun.org/_third_party/golang.org/x/net/websocket"
+	"bosun.org/cmd/bosun/sched"
+)
+
+func TestWSCheckOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"http://bosun.example.com:8070", true},
+		{"https://BOSUN.example.com:8070", true},
+		{"http://evil.example.com", false},
+		{"http://bosun.example.com", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "http://bosun.example.com:8070/api/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		err = wsCheckOrigin(&websocket.Config{Version: websocket.ProtocolVersionHybi13}, r)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v, expected ok %v", test.origin, err, test.ok)
+		}
+	}
+}
+
+func TestDashboardDiff(t *testing.T) {
+	a := &sched.StateGroup{AlertKey: "a{h=1}", Subject: "a"}
+	b := &sched.StateGroup{AlertKey: "b{h=1}", Subject: "b"}
+	groups := &sched.StateGroups{Total: 2}
+	groups.Groups.NeedAck = []*sched.StateGroup{a}
+	groups.Groups.Acknowledged = []*sched.StateGroup{b}
+	u, sigs := dashboardDiff(nil, groups)
+	if !u.Reset || len(u.Changed) != 2 || !u.Changed[0].NeedAck || u.Changed[1].NeedAck {
+		t.Fatalf("unexpected first update %+v", u)
+	}
+	if !reflect.DeepEqual(u.Order, []string{"a{h=1}", "b{h=1}"}) {
+		t.Fatalf("unexpected order %v", u.Order)
+	}
+
+	u, sigs = dashboardDiff(sigs, groups)
+	if u.Reset || len(u.Changed) != 0 || len(u.Removed) != 0 {
+		t.Fatalf("expected no changes, got %+v", u)
+	}
+
+	// a is acknowledged, and b goes away.
+	groups.Groups.NeedAck = nil
+	groups.Groups.Acknowledged = []*sched.StateGroup{a}
+	groups.Total = 1
+	u, _ = dashboardDiff(sigs, groups)
+	if len(u.Changed) != 1 || u.Changed[0].Key != "a{h=1}" || u.Changed[0].NeedAck {
+		t.Fatalf("expected a to change, got %+v", u.Changed)
+	}
+	if !reflect.DeepEqual(u.Removed, []string{"b{h=1}"}) || u.Total != 1 {
+		t.Fatalf("expected b to be removed, got %+v", u)
+	}
+}
@@ -24,7 +24,7 @@ import (
 	"os/signal"
 	"time"
 
-	"bosun.org/cmd/bosun/expr"
+	"bosun.org/expr"
 	"bosun.org/metadata"
 	"bosun.org/opentsdb"
 )
@@ -14,6 +14,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"bosun.org/metadata"
@@ -44,23 +45,30 @@ var (
 	// Tags is an opentsdb.TagSet used when sending self metrics.
 	Tags opentsdb.TagSet
 
-	// Dropped is the number of dropped data points due to a full queue.
+	// DefaultTags are added to every data point that does not already have
+	// those tag keys, for example to identify the environment or instance.
+	DefaultTags opentsdb.TagSet
+
+	// Dropped is the number of dropped data points due to a full queue. Use
+	// atomic operations to access it.
 	dropped int64
 
-	// Sent is the number of sent data points.
+	// Sent is the number of sent data points. Use atomic operations to
+	// access it.
 	sent int64
 
-	tchan               chan *opentsdb.DataPoint
-	tsdbURL             string
-	osHostname          string
-	metricRoot          string
-	queue               []*opentsdb.DataPoint
-	qlock, mlock, slock sync.Mutex // Locks for queues, maps, stats.
-	counters            = make(map[string]*addMetric)
-	sets                = make(map[string]*setMetric)
-	puts                = make(map[string]*putMetric)
-	aggs                = make(map[string]*agMetric)
-	client              = &http.Client{
+	tchan        chan *opentsdb.DataPoint
+	tsdbURLs     []string
+	osHostname   string
+	metricRoot   string
+	queue        []*opentsdb.DataPoint
+	qlock, mlock sync.Mutex // Locks for queues, sets and puts.
+	dlock        sync.Mutex // Lock for destinations.
+	counters     [numShards]counterShard
+	sets         = make(map[string]*setMetric)
+	puts         = make(map[string]*putMetric)
+	aggs         [numShards]sampleShard
+	client       = &http.Client{
 		Transport: &timeoutTransport{Transport: new(http.Transport)},
 		Timeout:   time.Minute,
 	}
@@ -106,14 +114,11 @@ func InitChan(tsdbhost *url.URL, root string, ch chan *opentsdb.DataPoint) error
 	if err := checkClean(root, "metric root"); err != nil {
 		return err
 	}
-	u, err := tsdbhost.Parse("/api/put")
+	u, err := putURL(tsdbhost)
 	if err != nil {
 		return err
 	}
-	if strings.HasPrefix(u.Host, ":") {
-		u.Host = "localhost" + u.Host
-	}
-	tsdbURL = u.String()
+	tsdbURLs = []string{u}
 	metricRoot = root + "."
 	tchan = ch
 	go queuer()
@@ -122,17 +127,11 @@ func InitChan(tsdbhost *url.URL, root string, ch chan *opentsdb.DataPoint) error
 	if DisableDefaultCollectors {
 		return nil
 	}
-	Set("collect.dropped", Tags, func() (i interface{}) {
-		slock.Lock()
-		i = dropped
-		slock.Unlock()
-		return
+	Set("collect.dropped", Tags, func() interface{} {
+		return atomic.LoadInt64(&dropped)
 	})
-	Set("collect.sent", Tags, func() (i interface{}) {
-		slock.Lock()
-		i = sent
-		slock.Unlock()
-		return
+	Set("collect.sent", Tags, func() interface{} {
+		return atomic.LoadInt64(&sent)
 	})
 	Set("collect.queued", Tags, func() (i interface{}) {
 		qlock.Lock()
@@ -186,6 +185,47 @@ func Init(tsdbhost *url.URL, root string) error {
 	return InitChan(tsdbhost, root, make(chan *opentsdb.DataPoint))
 }
 
+func putURL(host *url.URL) (string, error) {
+	u, err := host.Parse("/api/put")
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(u.Host, ":") {
+		u.Host = "localhost" + u.Host
+	}
+	return u.String(), nil
+}
+
+// SetDestinations changes where data points are sent. Batches that fail to
+// send to the first host are queued and retried. Batches successfully sent
+// to the first host are also sent once to each additional host on a best
+// effort basis. With no hosts, data points are discarded.
+func SetDestinations(hosts ...*url.URL) error {
+	if tchan == nil {
+		return fmt.Errorf("collect: not initialized")
+	}
+	urls := make([]string, len(hosts))
+	for i, h := range hosts {
+		u, err := putURL(h)
+		if err != nil {
+			return err
+		}
+		urls[i] = u
+	}
+	dlock.Lock()
+	tsdbURLs = urls
+	dlock.Unlock()
+	return nil
+}
+
+// Destinations returns the URLs data points are sent to. The first is the
+// primary destination.
+func Destinations() []string {
+	dlock.Lock()
+	defer dlock.Unlock()
+	return append([]string(nil), tsdbURLs...)
+}
+
 func SetHostname(host string) error {
 	if err := checkClean(host, "host tag"); err != nil {
 		return err
@@ -286,20 +326,51 @@ func (am *agMetric) Process(now int64) {
 	}
 }
 
+// numShards is the number of shards counters and samples are spread over, so
+// goroutines recording different metrics rarely contend for the same lock.
+const numShards = 32
+
+type counterShard struct {
+	sync.RWMutex
+	m map[string]*addMetric
+}
+
+type sampleShard struct {
+	sync.Mutex
+	m map[string]*agMetric
+}
+
+// shard returns the shard index of the metric and tag set key tss.
+func shard(tss string) int {
+	// FNV-1a, inlined to avoid allocating a hash.Hash32 on every call.
+	h := uint32(2166136261)
+	for i := 0; i < len(tss); i++ {
+		h ^= uint32(tss[i])
+		h *= 16777619
+	}
+	return int(h % numShards)
+}
+
 func Sample(metric string, ts opentsdb.TagSet, v float64) error {
 	if err := check(metric, &ts); err != nil {
 		return err
 	}
 	tss := metric + ts.String()
-	mlock.Lock()
-	if aggs[tss] == nil {
-		aggs[tss] = &agMetric{
+	s := &aggs[shard(tss)]
+	s.Lock()
+	if s.m == nil {
+		s.m = make(map[string]*agMetric)
+	}
+	am := s.m[tss]
+	if am == nil {
+		am = &agMetric{
 			metric: metric,
 			ts:     ts.Copy(),
 		}
+		s.m[tss] = am
 	}
-	aggs[tss].values = append(aggs[tss].values, v)
-	mlock.Unlock()
+	am.values = append(am.values, v)
+	s.Unlock()
 	return nil
 }
 
@@ -338,27 +409,39 @@ func Set(metric string, ts opentsdb.TagSet, f func() interface{}) error {
 }
 
 type addMetric struct {
+	// value is first so it is 64-bit aligned for atomic operations.
+	value  int64
 	metric string
 	ts     opentsdb.TagSet
-	value  int64
 }
 
 // Add takes a metric and increments a counter for that metric. The metric name
-// is appended to the basename specified in the Init function.
+// is appended to the basename specified in the Init function. Once a counter
+// exists, incrementing it takes only a shared lock and an atomic add.
 func Add(metric string, ts opentsdb.TagSet, inc int64) error {
 	if err := check(metric, &ts); err != nil {
 		return err
 	}
 	tss := metric + ts.String()
-	mlock.Lock()
-	if counters[tss] == nil {
-		counters[tss] = &addMetric{
-			metric: metric,
-			ts:     ts.Copy(),
+	s := &counters[shard(tss)]
+	s.RLock()
+	c := s.m[tss]
+	s.RUnlock()
+	if c == nil {
+		s.Lock()
+		if s.m == nil {
+			s.m = make(map[string]*addMetric)
+		}
+		if c = s.m[tss]; c == nil {
+			c = &addMetric{
+				metric: metric,
+				ts:     ts.Copy(),
+			}
+			s.m[tss] = c
 		}
+		s.Unlock()
 	}
-	counters[tss].value += inc
-	mlock.Unlock()
+	atomic.AddInt64(&c.value, inc)
 	return nil
 }
 
@@ -401,6 +484,18 @@ func check(metric string, ts *opentsdb.TagSet) error {
 	if *ts == nil {
 		*ts = make(opentsdb.TagSet)
 	}
+	copied := false
+	for k, v := range DefaultTags {
+		if _, present := (*ts)[k]; present {
+			continue
+		}
+		// Don't modify a tag set that may be shared by the caller.
+		if !copied {
+			*ts = ts.Copy()
+			copied = true
+		}
+		(*ts)[k] = v
+	}
 	if host, present := (*ts)["host"]; !present {
 		(*ts)["host"] = osHostname
 	} else if host == "" {
@@ -419,43 +514,59 @@ func checkClean(s, t string) error {
 	return nil
 }
 
+// collect queues the current value of all metrics every Freq. Values are
+// gathered under the locks, and queued after they are released so a slow
+// queue does not block goroutines recording metrics.
 func collect() {
 	for {
-		mlock.Lock()
 		now := time.Now().Unix()
-		for _, c := range counters {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + c.metric,
+		var dps []*opentsdb.DataPoint
+		add := func(metric string, ts opentsdb.TagSet, v interface{}) {
+			dps = append(dps, &opentsdb.DataPoint{
+				Metric:    metricRoot + metric,
 				Timestamp: now,
-				Value:     c.value,
-				Tags:      c.ts,
-			}
-			tchan <- dp
+				Value:     v,
+				Tags:      ts,
+			})
 		}
-		for _, s := range sets {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + s.metric,
-				Timestamp: now,
-				Value:     s.f(),
-				Tags:      s.ts,
+		for i := range counters {
+			s := &counters[i]
+			s.RLock()
+			for _, c := range s.m {
+				add(c.metric, c.ts, atomic.LoadInt64(&c.value))
 			}
-			tchan <- dp
+			s.RUnlock()
 		}
-		for _, s := range puts {
-			dp := &opentsdb.DataPoint{
-				Metric:    metricRoot + s.metric,
-				Timestamp: now,
-				Value:     s.value,
-				Tags:      s.ts,
+		var ams []*agMetric
+		for i := range aggs {
+			s := &aggs[i]
+			s.Lock()
+			for _, am := range s.m {
+				ams = append(ams, am)
 			}
-			tchan <- dp
+			s.m = nil
+			s.Unlock()
 		}
-		for _, am := range aggs {
-			am.Process(now)
+		mlock.Lock()
+		setms := make([]*setMetric, 0, len(sets))
+		for _, s := range sets {
+			setms = append(setms, s)
 		}
+		putms := puts
 		puts = make(map[string]*putMetric)
-		aggs = make(map[string]*agMetric)
 		mlock.Unlock()
+		for _, s := range setms {
+			add(s.metric, s.ts, s.f())
+		}
+		for _, p := range putms {
+			add(p.metric, p.ts, p.value)
+		}
+		for _, dp := range dps {
+			tchan <- dp
+		}
+		for _, am := range ams {
+			am.Process(now)
+		}
 		time.Sleep(Freq)
 	}
 }
@@ -0,0 +1,101 @@
+package collect
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"bosun.org/opentsdb"
+)
+
+func counterValue(metric string, ts opentsdb.TagSet) int64 {
+	check(metric, &ts)
+	tss := metric + ts.String()
+	s := &counters[shard(tss)]
+	s.RLock()
+	defer s.RUnlock()
+	if c := s.m[tss]; c != nil {
+		return atomic.LoadInt64(&c.value)
+	}
+	return 0
+}
+
+func TestAddConcurrent(t *testing.T) {
+	const goroutines, adds = 16, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ts := opentsdb.TagSet{"worker": fmt.Sprint(i % 4)}
+			for j := 0; j < adds; j++ {
+				if err := Add("test.add.concurrent", ts, 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 4; i++ {
+		ts := opentsdb.TagSet{"worker": fmt.Sprint(i)}
+		if v := counterValue("test.add.concurrent", ts); v != goroutines/4*adds {
+			t.Errorf("worker %d: got %d, expected %d", i, v, goroutines/4*adds)
+		}
+	}
+}
+
+func TestSampleConcurrent(t *testing.T) {
+	const goroutines, samples = 8, 500
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < samples; j++ {
+				Sample("test.sample.concurrent", nil, float64(j))
+			}
+		}()
+	}
+	wg.Wait()
+	ts := opentsdb.TagSet(nil)
+	check("test.sample.concurrent", &ts)
+	tss := "test.sample.concurrent" + ts.String()
+	s := &aggs[shard(tss)]
+	s.Lock()
+	n := len(s.m[tss].values)
+	s.Unlock()
+	if n != goroutines*samples {
+		t.Errorf("got %d samples, expected %d", n, goroutines*samples)
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	ts := opentsdb.TagSet{"bench": "add"}
+	for i := 0; i < b.N; i++ {
+		Add("bench.add", ts, 1)
+	}
+}
+
+// BenchmarkAddParallel records to a few metrics from many goroutines, as the
+// scheduler's checks and timers do.
+func BenchmarkAddParallel(b *testing.B) {
+	var n int32
+	b.RunParallel(func(pb *testing.PB) {
+		ts := opentsdb.TagSet{"bench": fmt.Sprint(atomic.AddInt32(&n, 1) % 8)}
+		for pb.Next() {
+			Add("bench.add.parallel", ts, 1)
+		}
+	})
+}
+
+func BenchmarkSampleParallel(b *testing.B) {
+	var n int32
+	b.RunParallel(func(pb *testing.PB) {
+		ts := opentsdb.TagSet{"bench": fmt.Sprint(atomic.AddInt32(&n, 1) % 8)}
+		for pb.Next() {
+			Sample("bench.sample.parallel", ts, 1)
+		}
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"bosun.org/opentsdb"
@@ -17,9 +18,7 @@ func queuer() {
 		qlock.Lock()
 		for {
 			if len(queue) > MaxQueueLen {
-				slock.Lock()
-				dropped++
-				slock.Unlock()
+				atomic.AddInt64(&dropped, 1)
 				break
 			}
 			queue = append(queue, dp)
@@ -86,8 +85,13 @@ func sendBatch(batch []*opentsdb.DataPoint) {
 		recordSent(len(batch))
 		return
 	}
+	urls := Destinations()
+	if len(urls) == 0 {
+		atomic.AddInt64(&dropped, int64(len(batch)))
+		return
+	}
 	now := time.Now()
-	resp, err := SendDataPoints(batch, tsdbURL)
+	resp, err := SendDataPoints(batch, urls[0])
 	if err == nil {
 		defer resp.Body.Close()
 	}
@@ -123,15 +127,24 @@ func sendBatch(batch []*opentsdb.DataPoint) {
 		return
 	}
 	recordSent(len(batch))
+	for _, u := range urls[1:] {
+		resp, err := SendDataPoints(batch, u)
+		if err != nil {
+			slog.Errorln(u, err)
+			continue
+		}
+		if resp.StatusCode != http.StatusNoContent {
+			slog.Errorln(u, resp.Status)
+		}
+		resp.Body.Close()
+	}
 }
 
 func recordSent(num int) {
 	if Debug {
 		slog.Infoln("sent", num)
 	}
-	slock.Lock()
-	sent += int64(num)
-	slock.Unlock()
+	atomic.AddInt64(&sent, int64(num))
 }
 
 func SendDataPoints(dps []*opentsdb.DataPoint, tsdb string) (*http.Response, error) {
@@ -0,0 +1,321 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/_third_party/github.com/aws/aws-sdk-go/aws"
+	"bosun.org/_third_party/github.com/aws/aws-sdk-go/aws/credentials"
+	"bosun.org/_third_party/github.com/aws/aws-sdk-go/service/cloudwatch"
+	"bosun.org/expr/parse"
+	"bosun.org/opentsdb"
+)
+
+// CloudWatch is a map of functions to query AWS CloudWatch.
+var CloudWatch = map[string]parse.Func{
+	"cloudwatch": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeSeriesSet,
+		Tags:   cloudwatchTag,
+		F:      CloudWatchQuery,
+	},
+}
+
+// cloudwatchMaxDatapoints is the most datapoints CloudWatch returns for one
+// GetMetricStatistics request.
+const cloudwatchMaxDatapoints = 1440
+
+// CloudWatchAccount is an AWS account and region queried by the cloudwatch
+// function. If AccessKey and SecretKey are empty, credentials are taken from
+// the environment, the shared credentials file, or the EC2 instance role.
+type CloudWatchAccount struct {
+	Region    string
+	AccessKey string `json:"-"`
+	SecretKey string `json:"-"`
+}
+
+// CloudWatchAccounts are the CloudWatch accounts by name.
+type CloudWatchAccounts map[string]*CloudWatchAccount
+
+// cloudwatchAPI is the part of the CloudWatch client the cloudwatch function
+// uses.
+type cloudwatchAPI interface {
+	GetMetricStatistics(*cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error)
+	ListMetrics(*cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error)
+}
+
+// newCloudWatchClient returns a client for a. It is replaced in tests.
+var newCloudWatchClient = func(a *CloudWatchAccount) cloudwatchAPI {
+	c := &aws.Config{Region: aws.String(a.Region)}
+	if a.AccessKey != "" {
+		c.Credentials = credentials.NewStaticCredentials(a.AccessKey, a.SecretKey, "")
+	}
+	return cloudwatch.New(c)
+}
+
+func cloudwatchTag(args []parse.Node) (parse.Tags, error) {
+	dims, err := cloudwatchDimensions(args[3].(*parse.StringNode).Text)
+	if err != nil {
+		return nil, err
+	}
+	t := make(parse.Tags)
+	for _, d := range dims {
+		t[d.name] = struct{}{}
+	}
+	return t, nil
+}
+
+type cloudwatchDimension struct {
+	name, value string
+}
+
+// cloudwatchDimensions parses a comma-separated list of name=value
+// dimensions. A value of * matches every value of the dimension.
+func cloudwatchDimensions(s string) ([]cloudwatchDimension, error) {
+	var dims []cloudwatchDimension
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, fmt.Errorf("cloudwatch: bad dimension %q, expected name=value", pair)
+		}
+		d := cloudwatchDimension{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])}
+		if !opentsdb.ValidTag(d.name) {
+			return nil, fmt.Errorf("cloudwatch: dimension %q is not a valid tag key", d.name)
+		}
+		if seen[d.name] {
+			return nil, fmt.Errorf("cloudwatch: duplicate dimension %s", d.name)
+		}
+		seen[d.name] = true
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+// cloudwatchStatistics are the statistics GetMetricStatistics returns, by
+// lower case name.
+var cloudwatchStatistics = map[string]string{
+	"average":     "Average",
+	"sum":         "Sum",
+	"minimum":     "Minimum",
+	"maximum":     "Maximum",
+	"samplecount": "SampleCount",
+}
+
+func cloudwatchValue(d *cloudwatch.Datapoint, statistic string) *float64 {
+	switch statistic {
+	case "Average":
+		return d.Average
+	case "Sum":
+		return d.Sum
+	case "Minimum":
+		return d.Minimum
+	case "Maximum":
+		return d.Maximum
+	case "SampleCount":
+		return d.SampleCount
+	}
+	return nil
+}
+
+// CloudWatchQuery returns the statistic of the named metric in namespace, at
+// each period from startDuration to endDuration ago, from the CloudWatch
+// account named account. dimensions is a comma-separated list of name=value
+// pairs, which become the tags of the results. A value of * returns a series
+// for every value of the dimension.
+func CloudWatchQuery(e *State, T miniprofiler.Timer, account, namespace, metric, dimensions, statistic, period, startDuration, endDuration string) (*Results, error) {
+	a := e.cloudwatchAccounts[account]
+	if a == nil {
+		return nil, fmt.Errorf("cloudwatch: unknown account %s", account)
+	}
+	dims, err := cloudwatchDimensions(dimensions)
+	if err != nil {
+		return nil, err
+	}
+	stat := cloudwatchStatistics[strings.ToLower(statistic)]
+	if stat == "" {
+		return nil, fmt.Errorf("cloudwatch: unknown statistic %s", statistic)
+	}
+	pd, err := opentsdb.ParseDuration(period)
+	if err != nil {
+		return nil, err
+	}
+	p := time.Duration(pd)
+	if p < time.Minute || p%time.Minute != 0 {
+		return nil, fmt.Errorf("cloudwatch: period must be a positive multiple of 1m")
+	}
+	sd, err := opentsdb.ParseDuration(startDuration)
+	if err != nil {
+		return nil, err
+	}
+	var ed opentsdb.Duration
+	if endDuration != "" {
+		ed, err = opentsdb.ParseDuration(endDuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	start := e.now.Add(time.Duration(-sd)).UTC()
+	end := e.now.Add(time.Duration(-ed)).UTC()
+	if !start.Before(end) {
+		return nil, fmt.Errorf("cloudwatch: start must be before end")
+	}
+	if end.Sub(start)/p > cloudwatchMaxDatapoints {
+		return nil, fmt.Errorf("cloudwatch: more than %d periods of %s in the time range", cloudwatchMaxDatapoints, period)
+	}
+	q := &cloudwatchQuery{
+		namespace:  namespace,
+		metric:     metric,
+		dimensions: dims,
+		statistic:  stat,
+		period:     p,
+		start:      start,
+		end:        end,
+	}
+	var series []*cloudwatchSeries
+	key := fmt.Sprintf("cloudwatch|%s|%s|%s|%v|%s|%s|%s|%s", account, namespace, metric, dims, stat, p, start, end)
+	T.StepCustomTiming("cloudwatch", "query", fmt.Sprintf("%s %s{%s} %s", namespace, metric, dimensions, stat), func() {
+		var val interface{}
+		val, err = e.cacheGet(key, func() (interface{}, error) {
+			return q.run(newCloudWatchClient(a))
+		})
+		if err == nil {
+			series = val.([]*cloudwatchSeries)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	r := new(Results)
+	for _, s := range series {
+		if e.squelched(s.tags) {
+			continue
+		}
+		r.Results = append(r.Results, &Result{
+			Value: s.values,
+			Group: s.tags,
+		})
+	}
+	return r, nil
+}
+
+type cloudwatchQuery struct {
+	namespace, metric string
+	dimensions        []cloudwatchDimension
+	statistic         string
+	period            time.Duration
+	start, end        time.Time
+}
+
+type cloudwatchSeries struct {
+	tags   opentsdb.TagSet
+	values Series
+}
+
+// run queries the statistics of each dimension set q matches.
+func (q *cloudwatchQuery) run(c cloudwatchAPI) ([]*cloudwatchSeries, error) {
+	sets, err := q.dimensionSets(c)
+	if err != nil {
+		return nil, err
+	}
+	var series []*cloudwatchSeries
+	for _, dims := range sets {
+		s := &cloudwatchSeries{tags: make(opentsdb.TagSet), values: make(Series)}
+		for _, d := range dims {
+			v, err := opentsdb.Clean(*d.Value)
+			if err != nil || v == "" {
+				return nil, fmt.Errorf("cloudwatch: bad value %q for dimension %s", *d.Value, *d.Name)
+			}
+			s.tags[*d.Name] = v
+		}
+		out, err := c.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+			Namespace:  aws.String(q.namespace),
+			MetricName: aws.String(q.metric),
+			Dimensions: dims,
+			Statistics: []*string{aws.String(q.statistic)},
+			Period:     aws.Int64(int64(q.period / time.Second)),
+			StartTime:  aws.Time(q.start),
+			EndTime:    aws.Time(q.end),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range out.Datapoints {
+			v := cloudwatchValue(d, q.statistic)
+			if d.Timestamp == nil || v == nil {
+				continue
+			}
+			s.values[d.Timestamp.UTC()] = *v
+		}
+		if len(s.values) > 0 {
+			series = append(series, s)
+		}
+	}
+	return series, nil
+}
+
+// dimensionSets returns the dimension sets q matches. Without wildcards that
+// is the one set of q's dimensions. Otherwise the metrics listed with q's
+// dimension names whose dimensions are exactly those names are matched, since
+// CloudWatch only returns statistics of an exact dimension set.
+func (q *cloudwatchQuery) dimensionSets(c cloudwatchAPI) ([][]*cloudwatch.Dimension, error) {
+	wildcard := false
+	var filters []*cloudwatch.DimensionFilter
+	var dims []*cloudwatch.Dimension
+	for _, d := range q.dimensions {
+		f := &cloudwatch.DimensionFilter{Name: aws.String(d.name)}
+		if d.value == "*" {
+			wildcard = true
+		} else {
+			f.Value = aws.String(d.value)
+			dims = append(dims, &cloudwatch.Dimension{Name: aws.String(d.name), Value: aws.String(d.value)})
+		}
+		filters = append(filters, f)
+	}
+	if !wildcard {
+		return [][]*cloudwatch.Dimension{dims}, nil
+	}
+	in := &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(q.namespace),
+		MetricName: aws.String(q.metric),
+		Dimensions: filters,
+	}
+	var sets [][]*cloudwatch.Dimension
+	seen := make(map[string]bool)
+	for {
+		out, err := c.ListMetrics(in)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range out.Metrics {
+			if len(m.Dimensions) != len(q.dimensions) {
+				continue
+			}
+			var ids []string
+			for _, d := range m.Dimensions {
+				if d.Name == nil || d.Value == nil {
+					continue
+				}
+				ids = append(ids, *d.Name+"="+*d.Value)
+			}
+			sort.Strings(ids)
+			id := strings.Join(ids, ",")
+			if len(ids) != len(q.dimensions) || seen[id] {
+				continue
+			}
+			seen[id] = true
+			sets = append(sets, m.Dimensions)
+		}
+		if out.NextToken == nil || *out.NextToken == "" {
+			break
+		}
+		in.NextToken = out.NextToken
+	}
+	return sets, nil
+}
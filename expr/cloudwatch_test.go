@@ -0,0 +1,144 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"bosun.org/_third_party/github.com/aws/aws-sdk-go/aws"
+	"bosun.org/_third_party/github.com/aws/aws-sdk-go/service/cloudwatch"
+	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
+)
+
+// cloudwatchTestClient answers ListMetrics with metrics, in pages of one, and
+// GetMetricStatistics with an average of the number of the request's
+// dimensions plus the minutes since start, and records the requests.
+type cloudwatchTestClient struct {
+	metrics []*cloudwatch.Metric
+	stats   []*cloudwatch.GetMetricStatisticsInput
+}
+
+func (c *cloudwatchTestClient) ListMetrics(in *cloudwatch.ListMetricsInput) (*cloudwatch.ListMetricsOutput, error) {
+	i := 0
+	if in.NextToken != nil {
+		i = int((*in.NextToken)[0] - '0')
+	}
+	out := &cloudwatch.ListMetricsOutput{}
+	if i < len(c.metrics) {
+		out.Metrics = c.metrics[i : i+1]
+	}
+	if i+1 < len(c.metrics) {
+		out.NextToken = aws.String(string('0' + byte(i+1)))
+	}
+	return out, nil
+}
+
+func (c *cloudwatchTestClient) GetMetricStatistics(in *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	c.stats = append(c.stats, in)
+	out := &cloudwatch.GetMetricStatisticsOutput{}
+	for t := *in.StartTime; t.Before(*in.EndTime); t = t.Add(time.Duration(*in.Period) * time.Second) {
+		out.Datapoints = append(out.Datapoints, &cloudwatch.Datapoint{
+			Timestamp: aws.Time(t),
+			Average:   aws.Float64(float64(len(in.Dimensions)) + t.Sub(*in.StartTime).Minutes()),
+		})
+	}
+	return out, nil
+}
+
+func cloudwatchTestMetric(dims ...string) *cloudwatch.Metric {
+	m := &cloudwatch.Metric{}
+	for i := 0; i < len(dims); i += 2 {
+		m.Dimensions = append(m.Dimensions, &cloudwatch.Dimension{Name: aws.String(dims[i]), Value: aws.String(dims[i+1])})
+	}
+	return m
+}
+
+func TestCloudWatchQuery(t *testing.T) {
+	c := &cloudwatchTestClient{
+		metrics: []*cloudwatch.Metric{
+			cloudwatchTestMetric("InstanceId", "i-1"),
+			cloudwatchTestMetric("InstanceId", "i-2"),
+			cloudwatchTestMetric("InstanceId", "i-1", "ImageId", "ami-1"),
+			cloudwatchTestMetric("InstanceId", "i-1"),
+		},
+	}
+	defer func(f func(*CloudWatchAccount) cloudwatchAPI) { newCloudWatchClient = f }(newCloudWatchClient)
+	newCloudWatchClient = func(*CloudWatchAccount) cloudwatchAPI { return c }
+	accounts := CloudWatchAccounts{"prod": {Region: "us-east-1"}}
+	now := time.Date(2016, 1, 1, 0, 10, 0, 0, time.UTC)
+	e, err := New(`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "InstanceId=*", "average", "5m", "10m", "")`, CloudWatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := e.Execute(nil, nil, nil, client.Config{}, nil, accounts, nil, nil, now, 0, false, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := now.Add(-10 * time.Minute)
+	expected := map[string]Series{
+		"{InstanceId=i-1}": {start: 1, start.Add(5 * time.Minute): 6},
+		"{InstanceId=i-2}": {start: 1, start.Add(5 * time.Minute): 6},
+	}
+	got := make(map[string]Series)
+	for _, res := range r.Results {
+		got[res.Group.String()] = res.Value.(Series)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	if len(c.stats) != 2 || *c.stats[0].Period != 300 || *c.stats[0].Statistics[0] != "Average" || !c.stats[0].EndTime.Equal(now) {
+		t.Errorf("unexpected requests: %v", c.stats)
+	}
+	tags, err := e.Root.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tags["InstanceId"]; !ok || len(tags) != 1 {
+		t.Errorf("expected tag InstanceId, got %v", tags)
+	}
+
+	// Without wildcards the dimensions are queried as given.
+	c.stats = nil
+	e, err = New(`cloudwatch("prod", "AWS/ELB", "Latency", "LoadBalancerName=web,AvailabilityZone=us-east-1a", "Average", "1m", "2m", "1m")`, CloudWatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err = e.Execute(nil, nil, nil, client.Config{}, nil, accounts, nil, nil, now, 0, false, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.stats) != 1 || len(r.Results) != 1 || r.Results[0].Group.String() != "{AvailabilityZone=us-east-1a,LoadBalancerName=web}" {
+		t.Errorf("unexpected results %v of requests %v", r.Results, c.stats)
+	}
+}
+
+func TestCloudWatchQueryErrors(t *testing.T) {
+	defer func(f func(*CloudWatchAccount) cloudwatchAPI) { newCloudWatchClient = f }(newCloudWatchClient)
+	newCloudWatchClient = func(*CloudWatchAccount) cloudwatchAPI { return &cloudwatchTestClient{} }
+	accounts := CloudWatchAccounts{"prod": {Region: "us-east-1"}}
+	for _, test := range []struct {
+		expr, err string
+	}{
+		{`cloudwatch("dev", "AWS/EC2", "CPUUtilization", "", "Average", "5m", "1h", "")`, "cloudwatch: unknown account dev"},
+		{`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "", "p99", "5m", "1h", "")`, "cloudwatch: unknown statistic p99"},
+		{`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "", "Average", "90s", "1h", "")`, "cloudwatch: period must be a positive multiple of 1m"},
+		{`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "", "Average", "1m", "2d", "")`, "cloudwatch: more than 1440 periods of 1m in the time range"},
+		{`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "", "Average", "1m", "1h", "2h")`, "cloudwatch: start must be before end"},
+	} {
+		e, err := New(test.expr, CloudWatch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = e.Execute(nil, nil, nil, client.Config{}, nil, accounts, nil, nil, time.Now(), 0, false, nil, nil, nil, nil)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: got error %v, expected %s", test.expr, err, test.err)
+		}
+	}
+	e, err := New(`cloudwatch("prod", "AWS/EC2", "CPUUtilization", "InstanceId", "Average", "5m", "1h", "")`, CloudWatch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Root.Tags(); err == nil {
+		t.Error("expected error for a dimension without a value")
+	}
+}
@@ -0,0 +1,32 @@
+package expr
+
+import (
+	"go/build"
+	"strings"
+	"testing"
+)
+
+// TestStandalone checks expr doesn't depend on bosun's own packages, so it
+// can be embedded in other applications.
+func TestStandalone(t *testing.T) {
+	seen := make(map[string]bool)
+	var visit func(path, from string)
+	visit = func(path, from string) {
+		if seen[path] || !strings.HasPrefix(path, "bosun.org/") || strings.HasPrefix(path, "bosun.org/_third_party/") {
+			return
+		}
+		seen[path] = true
+		if strings.HasPrefix(path, "bosun.org/cmd/") {
+			t.Errorf("%s imports %s", from, path)
+			return
+		}
+		pkg, err := build.Import(path, "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range pkg.Imports {
+			visit(imp, path)
+		}
+	}
+	visit("bosun.org/expr", "")
+}
@@ -0,0 +1,145 @@
+package expr
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/expr/parse"
+	"bosun.org/models"
+	"bosun.org/opentsdb"
+)
+
+// Events is a map of functions to count events pushed to bosun.
+var Events = map[string]parse.Func{
+	"events": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeNumberSet,
+		Tags:   eventsTag,
+		F:      EventsCount,
+	},
+}
+
+// EventSource provides the events pushed to bosun. It is implemented by
+// bosun's database.
+type EventSource interface {
+	GetEvents(typ string, start, end time.Time) ([]*models.Event, error)
+}
+
+// parseEventTags parses the tags argument of events, which may be empty.
+func parseEventTags(s string) (opentsdb.TagSet, error) {
+	if strings.TrimSpace(s) == "" {
+		return opentsdb.TagSet{}, nil
+	}
+	return opentsdb.ParseTags(s)
+}
+
+func eventsTag(args []parse.Node) (parse.Tags, error) {
+	tags, err := parseEventTags(args[1].(*parse.StringNode).Text)
+	if err != nil {
+		return nil, err
+	}
+	t := make(parse.Tags)
+	for k := range tags {
+		t[k] = struct{}{}
+	}
+	return t, nil
+}
+
+// EventsCount returns the number of events of type typ from startDuration to
+// endDuration ago, grouped by the keys of tags. As in OpenTSDB queries, a tag
+// value may be * to match any value, or values separated by |; events without
+// all of the tags are not counted.
+// Without tags, the result is a single count, which may be 0.
+func EventsCount(e *State, T miniprofiler.Timer, typ, tags, startDuration, endDuration string) (*Results, error) {
+	if e.events == nil {
+		return nil, fmt.Errorf("events: no event source")
+	}
+	ts, err := parseEventTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	sd, err := opentsdb.ParseDuration(startDuration)
+	if err != nil {
+		return nil, err
+	}
+	var ed opentsdb.Duration
+	if endDuration != "" {
+		ed, err = opentsdb.ParseDuration(endDuration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	start := e.now.Add(time.Duration(-sd))
+	end := e.now.Add(time.Duration(-ed))
+	var es []*models.Event
+	key := fmt.Sprintf("events|%s|%d|%d", typ, start.Unix(), end.Unix())
+	T.StepCustomTiming("events", "query", typ, func() {
+		var val interface{}
+		val, err = e.cacheGet(key, func() (interface{}, error) {
+			return e.events.GetEvents(typ, start, end)
+		})
+		if err == nil {
+			es = val.([]*models.Event)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]*Result)
+	for _, ev := range es {
+		group, ok := eventGroup(ev, ts)
+		if !ok {
+			continue
+		}
+		k := group.String()
+		if counts[k] == nil {
+			counts[k] = &Result{Group: group, Value: Number(0)}
+		}
+		counts[k].Value = counts[k].Value.(Number) + 1
+	}
+	if len(ts) == 0 && len(counts) == 0 {
+		counts[""] = &Result{Group: opentsdb.TagSet{}, Value: Number(0)}
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	r := new(Results)
+	for _, k := range keys {
+		if e.squelched(counts[k].Group) {
+			continue
+		}
+		r.Results = append(r.Results, counts[k])
+	}
+	return r, nil
+}
+
+// eventGroup returns the values of the tags of ev that match the patterns of
+// ts, and whether ev has all of them.
+func eventGroup(ev *models.Event, ts opentsdb.TagSet) (opentsdb.TagSet, bool) {
+	group := make(opentsdb.TagSet, len(ts))
+	for k, pattern := range ts {
+		v, ok := ev.Tags[k]
+		if !ok {
+			return nil, false
+		}
+		if pattern != "*" && !eventTagMatch(pattern, v) {
+			return nil, false
+		}
+		group[k] = v
+	}
+	return group, true
+}
+
+func eventTagMatch(pattern, v string) bool {
+	for _, p := range strings.Split(pattern, "|") {
+		if p == v {
+			return true
+		}
+	}
+	return false
+}
@@ -1,4 +1,9 @@
-package expr // import "bosun.org/cmd/bosun/expr"
+// Package expr implements bosun's expression language. It can be embedded in
+// other applications: parse an expression with New and the functions of the
+// backends it may query, and run it with Execute. Bosun's alert state,
+// search index, query cache, and events are optional, through the Searcher,
+// Cache, AlertStatusProvider, and EventSource interfaces.
+package expr // import "bosun.org/expr"
 
 import (
 	"encoding/json"
@@ -12,9 +17,7 @@ import (
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
 	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
 	"bosun.org/_third_party/github.com/olivere/elastic"
-	"bosun.org/cmd/bosun/cache"
-	"bosun.org/cmd/bosun/expr/parse"
-	"bosun.org/cmd/bosun/search"
+	"bosun.org/expr/parse"
 	"bosun.org/graphite"
 	"bosun.org/opentsdb"
 )
@@ -22,11 +25,11 @@ import (
 type State struct {
 	*Expr
 	now                time.Time
-	cache              *cache.Cache
+	cache              Cache
 	enableComputations bool
 
 	// OpenTSDB
-	Search      *search.Search
+	Search      Searcher
 	autods      int
 	tsdbContext opentsdb.Context
 	tsdbQueries []opentsdb.Request
@@ -39,18 +42,70 @@ type State struct {
 
 	// LogstashElastic
 	logstashQueries []elastic.SearchSource
-	logstashHosts   LogstashElasticHosts
+	logstashHosts   ElasticHosts
 
 	// InfluxDB
 	InfluxConfig client.Config
 
+	// SQL
+	sqlDatabases SQLDatabases
+
+	// CloudWatch
+	cloudwatchAccounts CloudWatchAccounts
+
+	// Events
+	events EventSource
+
 	History AlertStatusProvider
 }
 
+// Searcher finds the tags of series, to expand OpenTSDB tag value globs and
+// for lookup tables. It is implemented by bosun's search index.
+type Searcher interface {
+	Expand(q *opentsdb.Query) error
+	TagValuesByTagKey(tagk string, since time.Duration) ([]string, error)
+	// FirstSeenMetrics returns when each metric was first seen, and
+	// FirstSeenTagValues when each value of tagk was, as Unix times. Those
+	// seen before first seen times were kept are 0.
+	FirstSeenMetrics() (map[string]int64, error)
+	FirstSeenTagValues(tagk string) (map[string]int64, error)
+}
+
+// Cache caches query responses by the text of the query. It is implemented
+// by bosun.org/cmd/bosun/cache.
+type Cache interface {
+	Get(key string, getFn func() (interface{}, error)) (interface{}, error)
+}
+
+// cacheGet returns the cached result of getFn for key.
+func (e *State) cacheGet(key string, getFn func() (interface{}, error)) (interface{}, error) {
+	if e.cache == nil {
+		return getFn()
+	}
+	return e.cache.Get(key, getFn)
+}
+
 // Alert Status Provider is used to provide information about alert results.
 // This facilitates alerts referencing other alerts, even when they go unknown or unevaluated.
 type AlertStatusProvider interface {
 	GetUnknownAndUnevaluatedAlertKeys(alertName string) (unknown, unevaluated []AlertKey)
+	// CountAbnormalAlertKeys returns the number of alert keys of alertName
+	// that are warning or critical, or only critical if critical is true, at
+	// the time of the check and d before it.
+	CountAbnormalAlertKeys(alertName string, critical bool, d time.Duration) (now, before int)
+	// MinStatuses returns the best status each alert key of alertName had
+	// during d before the check, as 0 normal, 1 warning, 2 critical, or 3
+	// unknown.
+	MinStatuses(alertName string, d time.Duration) map[AlertKey]int
+	// LastAckTimes returns when each acknowledged alert key of alertName was
+	// last acknowledged.
+	LastAckTimes(alertName string) map[AlertKey]time.Time
+	// IncidentCounts returns the number of incidents of each alert key of
+	// alertName that started during d before the check.
+	IncidentCounts(alertName string, d time.Duration) map[AlertKey]int
+	// AnomalyScores returns the last anomaly score of each series of the
+	// anomaly name, grouped by the series' tags.
+	AnomalyScores(name string) []*Result
 }
 
 var ErrUnknownOp = fmt.Errorf("expr: unknown op type")
@@ -76,35 +131,48 @@ func New(expr string, funcs ...map[string]parse.Func) (*Expr, error) {
 }
 
 // Execute applies a parse expression to the specified OpenTSDB context, and
-// returns one result per group. T may be nil to ignore timings.
-func (e *Expr) Execute(c opentsdb.Context, g graphite.Context, l LogstashElasticHosts, influxConfig client.Config, cache *cache.Cache, T miniprofiler.Timer, now time.Time, autods int, unjoinedOk bool, search *search.Search, squelched func(tags opentsdb.TagSet) bool, history AlertStatusProvider) (r *Results, queries []opentsdb.Request, err error) {
+// returns one result per group. T may be nil to ignore timings. cache,
+// search, squelched, history, and events may be nil. Without a Searcher, OpenTSDB
+// tag value globs other than * are sent to OpenTSDB as is.
+func (e *Expr) Execute(c opentsdb.Context, g graphite.Context, l ElasticHosts, influxConfig client.Config, sqlDBs SQLDatabases, cloudwatchAccounts CloudWatchAccounts, cache Cache, T miniprofiler.Timer, now time.Time, autods int, unjoinedOk bool, search Searcher, squelched func(tags opentsdb.TagSet) bool, history AlertStatusProvider, events EventSource) (r *Results, queries []opentsdb.Request, err error) {
 	if squelched == nil {
 		squelched = func(tags opentsdb.TagSet) bool {
 			return false
 		}
 	}
 	s := &State{
-		Expr:            e,
-		cache:           cache,
-		tsdbContext:     c,
-		graphiteContext: g,
-		logstashHosts:   l,
-		InfluxConfig:    influxConfig,
-		now:             now,
-		autods:          autods,
-		unjoinedOk:      unjoinedOk,
-		Search:          search,
-		squelched:       squelched,
-		History:         history,
+		Expr:               e,
+		cache:              cache,
+		tsdbContext:        c,
+		graphiteContext:    g,
+		logstashHosts:      l,
+		InfluxConfig:       influxConfig,
+		sqlDatabases:       sqlDBs,
+		cloudwatchAccounts: cloudwatchAccounts,
+		now:                now,
+		autods:             autods,
+		unjoinedOk:         unjoinedOk,
+		Search:             search,
+		squelched:          squelched,
+		History:            history,
+		events:             events,
 	}
 	return e.ExecuteState(s, T)
 }
 
+// A StepTimer is a Timer that only records how long steps take. Executing
+// with one does not record the computations of results, as executing with
+// other Timers does.
+type StepTimer interface {
+	miniprofiler.Timer
+	StepsOnly()
+}
+
 func (e *Expr) ExecuteState(s *State, T miniprofiler.Timer) (r *Results, queries []opentsdb.Request, err error) {
 	defer errRecover(&err)
 	if T == nil {
 		T = new(miniprofiler.Profile)
-	} else {
+	} else if _, ok := T.(StepTimer); !ok {
 		s.enableComputations = true
 	}
 	T.Step("expr execute", func(T miniprofiler.Timer) {
@@ -199,6 +267,14 @@ type Result struct {
 	Computations
 	Value
 	Group opentsdb.TagSet
+	// Bounds is the expected range of a series Value, if known.
+	Bounds *Bounds `json:",omitempty"`
+}
+
+// Bounds are the lower and upper limits of the expected range of a series,
+// like the confidence interval of a forecast.
+type Bounds struct {
+	Lower, Upper Series
 }
 
 type Results struct {
@@ -542,6 +618,7 @@ func (e *State) walkUnary(node *parse.UnaryNode, T miniprofiler.Timer) *Results
 	a := e.walk(node.Arg, T)
 	T.Step("walkUnary: "+node.OpStr, func(T miniprofiler.Timer) {
 		for _, r := range a.Results {
+			r.Bounds = nil
 			if an, aok := r.Value.(Scalar); aok && math.IsNaN(float64(an)) {
 				r.Value = Scalar(math.NaN())
 				continue
@@ -612,7 +689,7 @@ func (e *State) walkFunc(node *parse.FuncNode, T miniprofiler.Timer) *Results {
 		if len(fr) > 1 && !fr[1].IsNil() {
 			err := fr[1].Interface().(error)
 			if err != nil {
-				panic(err)
+				panic(e.Tree.ErrorAt(node, err))
 			}
 		}
 		if node.Return() == parse.TypeNumberSet {
@@ -64,7 +64,7 @@ func TestExprSimple(t *testing.T) {
 			t.Error(err)
 			break
 		}
-		r, _, err := e.Execute(nil, nil, nil, client.Config{}, nil, nil, time.Now(), 0, false, nil, nil, nil)
+		r, _, err := e.Execute(nil, nil, nil, client.Config{}, nil, nil, nil, nil, time.Now(), 0, false, nil, nil, nil, nil)
 		if err != nil {
 			t.Error(err)
 			break
@@ -205,7 +205,7 @@ func TestQueryExpr(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		results, _, err := e.Execute(opentsdb.Host(u.Host), nil, nil, client.Config{}, nil, nil, queryTime, 0, false, nil, nil, nil)
+		results, _, err := e.Execute(opentsdb.Host(u.Host), nil, nil, client.Config{}, nil, nil, nil, nil, queryTime, 0, false, nil, nil, nil, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -248,3 +248,145 @@ func TestQueryExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestForecastFuncs(t *testing.T) {
+	now := time.Date(2016, time.March, 1, 12, 0, 0, 0, time.UTC)
+	e := &State{now: now}
+	// Rising by 1 a second, and at 100 now.
+	rising := Series{
+		now.Add(-2 * time.Second): 98,
+		now.Add(-time.Second):     99,
+		now:                       100,
+	}
+	flat := Series{now.Add(-time.Second): 5, now: 5}
+	for i, test := range []struct {
+		f        func(Series, ...float64) float64
+		dps      Series
+		y        float64
+		expected float64
+	}{
+		{e.forecast, rising, 110, 10},
+		{e.forecast, rising, 90, math.Inf(1)},
+		{e.forecast, flat, 10, math.Inf(1)},
+		{e.forecast_lr, rising, 110, 10},
+		{e.forecast_lr, rising, 90, -10},
+		{e.forecast_lr, flat, 10, (time.Hour * 24 * 365 * 10).Seconds()},
+	} {
+		if got := test.f(test.dps, test.y); math.Abs(got-test.expected) > 1e-6 && got != test.expected {
+			t.Errorf("%d: got %v, expected %v", i, got, test.expected)
+		}
+	}
+}
+
+func TestAnomalyFuncs(t *testing.T) {
+	d := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	seasonal := func(n int) *Results {
+		s := make(Series)
+		for i := 0; i < n; i++ {
+			s[d.Add(time.Duration(i)*time.Minute)] = float64(i%4) * 10
+		}
+		return &Results{Results: []*Result{{Value: s}}}
+	}
+
+	// A perfectly repeating series is forecast exactly.
+	r, err := HoltWinters(nil, nil, seasonal(12), .5, .1, .1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hw := r.Results[0].Value.(Series)
+	if len(hw) != 8 {
+		t.Fatalf("expected 8 forecast points, got %d", len(hw))
+	}
+	for ts, v := range hw {
+		expected := float64(int(ts.Sub(d)/time.Minute)%4) * 10
+		if math.Abs(v-expected) > 1e-9 {
+			t.Errorf("%v: expected %v, got %v", ts, expected, v)
+		}
+	}
+	r, err = HoltWinters(nil, nil, seasonal(7), .5, .1, .1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := len(r.Results[0].Value.(Series)); l != 0 {
+		t.Errorf("expected no forecast for less than two seasons, got %d points", l)
+	}
+	if _, err := HoltWinters(nil, nil, seasonal(12), 2, .1, .1, 4); err == nil {
+		t.Error("expected error for alpha > 1")
+	}
+
+	r, err = ZScore(nil, nil, &Results{Results: []*Result{{Value: Series{d: 1, d.Add(time.Second): 3}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := r.Results[0].Value.(Series)
+	if math.Abs(z[d]+math.Sqrt2/2) > 1e-9 || math.Abs(z[d.Add(time.Second)]-math.Sqrt2/2) > 1e-9 {
+		t.Errorf("unexpected zscores: %v", z)
+	}
+
+	if m := mad(Series{d: 1, d.Add(1): 2, d.Add(2): 3, d.Add(3): 4, d.Add(4): 100}); m != 1 {
+		t.Errorf("expected mad of 1, got %v", m)
+	}
+}
+
+func TestForecast(t *testing.T) {
+	d := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	e := &State{now: d.Add(time.Hour)}
+	// A disk filling by 1% a minute for the last hour.
+	filling := make(Series)
+	for i := 0; i <= 60; i++ {
+		filling[d.Add(time.Duration(i)*time.Minute)] = float64(i)
+	}
+	flat := Series{d: 5, d.Add(time.Minute): 5}
+	tests := []struct {
+		s        Series
+		y        float64
+		expected float64
+	}{
+		{filling, 100, 40 * 60},
+		{filling, 60, 0},
+		{filling, 10, math.Inf(1)},
+		{filling, -10, math.Inf(1)},
+		{flat, 10, math.Inf(1)},
+		{Series{d: 5}, 10, math.Inf(1)},
+	}
+	for i, test := range tests {
+		y := &Results{Results: []*Result{{Value: Number(test.y)}}}
+		r, err := Forecast(e, nil, &Results{Results: []*Result{{Value: test.s}}}, y)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := float64(r.Results[0].Value.(Number))
+		if math.Abs(got-test.expected) > 1e-6 && got != test.expected {
+			t.Errorf("%d: expected %v, got %v", i, test.expected, got)
+		}
+	}
+}
+
+func TestForecastBounds(t *testing.T) {
+	d := time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := make(Series)
+	for i := 0; i < 10; i++ {
+		s[d.Add(time.Duration(i)*time.Minute)] = float64(i%2) * 2
+	}
+	r := Des(nil, nil, &Results{Results: []*Result{{Value: s}}}, .5, .1)
+	res := r.Results[0]
+	if res.Bounds == nil {
+		t.Fatal("expected bounds")
+	}
+	fit := res.Value.(Series)
+	if len(res.Bounds.Lower) != len(fit) || len(res.Bounds.Upper) != len(fit) {
+		t.Fatalf("expected %d bound points, got %d and %d", len(fit), len(res.Bounds.Lower), len(res.Bounds.Upper))
+	}
+	for ts, v := range fit {
+		if !(res.Bounds.Lower[ts] < v && v < res.Bounds.Upper[ts]) {
+			t.Errorf("%v: %v not within %v and %v", ts, v, res.Bounds.Lower[ts], res.Bounds.Upper[ts])
+		}
+	}
+	r, err := Avg(nil, nil, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Results[0].Bounds != nil {
+		t.Error("expected bounds to be dropped when reducing a series")
+	}
+}
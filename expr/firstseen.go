@@ -0,0 +1,84 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/expr/parse"
+	"bosun.org/opentsdb"
+)
+
+func tagFirstSeen(args []parse.Node) (parse.Tags, error) {
+	return parse.Tags{args[0].(*parse.StringNode).Text: struct{}{}}, nil
+}
+
+func tagMetricFirstSeen(args []parse.Node) (parse.Tags, error) {
+	return parse.Tags{"metric": struct{}{}}, nil
+}
+
+// FirstSeen returns how many seconds ago each value of tagk matching pattern,
+// a glob, was first seen, grouped by tagk. An empty pattern matches all
+// values. Values seen before first seen times were kept are left out, so
+// that they are not mistaken for new ones.
+func FirstSeen(e *State, T miniprofiler.Timer, tagk, pattern string) (*Results, error) {
+	if e.Search == nil {
+		return nil, fmt.Errorf("firstSeen: no search index")
+	}
+	var first map[string]int64
+	var err error
+	T.Step("firstSeen", func(T miniprofiler.Timer) {
+		first, err = e.Search.FirstSeenTagValues(tagk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.firstSeenResults(tagk, pattern, first)
+}
+
+// MetricFirstSeen returns how many seconds ago each metric matching pattern,
+// a glob, was first seen, grouped by the tag metric. An empty pattern matches
+// all metrics. As for firstSeen, metrics seen before first seen times were
+// kept are left out.
+func MetricFirstSeen(e *State, T miniprofiler.Timer, pattern string) (*Results, error) {
+	if e.Search == nil {
+		return nil, fmt.Errorf("metricFirstSeen: no search index")
+	}
+	var first map[string]int64
+	var err error
+	T.Step("metricFirstSeen", func(T miniprofiler.Timer) {
+		first, err = e.Search.FirstSeenMetrics()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.firstSeenResults("metric", pattern, first)
+}
+
+func (e *State) firstSeenResults(tagk, pattern string, first map[string]int64) (*Results, error) {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		re, err = regexp.Compile("^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$")
+		if err != nil {
+			return nil, err
+		}
+	}
+	r := new(Results)
+	now := e.now.Unix()
+	for v, t := range first {
+		if t == 0 || (re != nil && !re.MatchString(v)) {
+			continue
+		}
+		group := opentsdb.TagSet{tagk: v}
+		if e.squelched(group) {
+			continue
+		}
+		r.Results = append(r.Results, &Result{
+			Value: Number(now - t),
+			Group: group,
+		})
+	}
+	return r, nil
+}
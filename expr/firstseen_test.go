@@ -0,0 +1,60 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
+	"bosun.org/opentsdb"
+)
+
+type firstSeenSearch struct {
+	metrics, hosts map[string]int64
+}
+
+func (s *firstSeenSearch) Expand(q *opentsdb.Query) error { return nil }
+func (s *firstSeenSearch) TagValuesByTagKey(tagk string, since time.Duration) ([]string, error) {
+	return nil, nil
+}
+func (s *firstSeenSearch) FirstSeenMetrics() (map[string]int64, error) { return s.metrics, nil }
+func (s *firstSeenSearch) FirstSeenTagValues(tagk string) (map[string]int64, error) {
+	if tagk != "host" {
+		return nil, nil
+	}
+	return s.hosts, nil
+}
+
+func TestFirstSeen(t *testing.T) {
+	now := time.Unix(10000, 0)
+	search := &firstSeenSearch{
+		metrics: map[string]int64{"os.cpu": 0, "app.requests": 9000},
+		hosts:   map[string]int64{"web01": 9900, "web02": 0, "db01": 4000},
+	}
+	for _, test := range []struct {
+		expr     string
+		expected map[string]float64
+	}{
+		{`firstSeen("host", "")`, map[string]float64{"{host=web01}": 100, "{host=db01}": 6000}},
+		{`firstSeen("host", "web*")`, map[string]float64{"{host=web01}": 100}},
+		{`firstSeen("dc", "")`, map[string]float64{}},
+		{`metricFirstSeen("")`, map[string]float64{"{metric=app.requests}": 1000}},
+		{`metricFirstSeen("os.*")`, map[string]float64{}},
+	} {
+		e, err := New(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, err := e.Execute(nil, nil, nil, client.Config{}, nil, nil, nil, nil, now, 0, false, search, nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make(map[string]float64)
+		for _, res := range r.Results {
+			got[res.Group.String()] = float64(res.Value.(Number))
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: got %v, expected %v", test.expr, got, test.expected)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 
 	"bosun.org/_third_party/github.com/GaryBoone/GoStats/stats"
 	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
-	"bosun.org/cmd/bosun/expr/parse"
+	"bosun.org/expr/parse"
 	"bosun.org/graphite"
 	"bosun.org/opentsdb"
 	"bosun.org/slog"
@@ -166,6 +166,12 @@ var builtins = map[string]parse.Func{
 		Tags:   tagFirst,
 		F:      First,
 	},
+	"forecast": {
+		Args:   []parse.FuncType{parse.TypeSeriesSet, parse.TypeNumberSet},
+		Return: parse.TypeNumberSet,
+		Tags:   tagFirst,
+		F:      Forecast,
+	},
 	"forecastlr": {
 		Args:   []parse.FuncType{parse.TypeSeriesSet, parse.TypeNumberSet},
 		Return: parse.TypeNumberSet,
@@ -184,6 +190,12 @@ var builtins = map[string]parse.Func{
 		Tags:   tagFirst,
 		F:      Length,
 	},
+	"mad": {
+		Args:   []parse.FuncType{parse.TypeSeriesSet},
+		Return: parse.TypeNumberSet,
+		Tags:   tagFirst,
+		F:      MAD,
+	},
 	"max": {
 		Args:   []parse.FuncType{parse.TypeSeriesSet},
 		Return: parse.TypeNumberSet,
@@ -296,6 +308,18 @@ var builtins = map[string]parse.Func{
 		Tags:   tagFirst,
 		F:      DropNA,
 	},
+	"firstSeen": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString},
+		Return: parse.TypeNumberSet,
+		Tags:   tagFirstSeen,
+		F:      FirstSeen,
+	},
+	"metricFirstSeen": {
+		Args:   []parse.FuncType{parse.TypeString},
+		Return: parse.TypeNumberSet,
+		Tags:   tagMetricFirstSeen,
+		F:      MetricFirstSeen,
+	},
 	"epoch": {
 		Args:   []parse.FuncType{},
 		Return: parse.TypeScalar,
@@ -307,6 +331,12 @@ var builtins = map[string]parse.Func{
 		Tags:   tagFirst,
 		F:      Filter,
 	},
+	"holtwinters": {
+		Args:   []parse.FuncType{parse.TypeSeriesSet, parse.TypeScalar, parse.TypeScalar, parse.TypeScalar, parse.TypeScalar},
+		Return: parse.TypeSeriesSet,
+		Tags:   tagFirst,
+		F:      HoltWinters,
+	},
 	"limit": {
 		Args:   []parse.FuncType{parse.TypeNumberSet, parse.TypeScalar},
 		Return: parse.TypeNumberSet,
@@ -325,6 +355,12 @@ var builtins = map[string]parse.Func{
 		Tags:   tagFirst,
 		F:      Sort,
 	},
+	"zscore": {
+		Args:   []parse.FuncType{parse.TypeSeriesSet},
+		Return: parse.TypeSeriesSet,
+		Tags:   tagFirst,
+		F:      ZScore,
+	},
 }
 
 func Epoch(e *State, T miniprofiler.Timer) (*Results, error) {
@@ -435,7 +471,7 @@ func DropNA(e *State, T miniprofiler.Timer, series *Results) (*Results, error) {
 	return DropValues(e, T, series, fromScalar(0), dropFunction)
 }
 
-func parseGraphiteResponse(req *graphite.Request, s *graphite.Response, formatTags []string) ([]*Result, error) {
+func parseGraphiteResponse(req *graphite.Request, s *graphite.Response, format *graphiteFormat) ([]*Result, error) {
 	const parseErrFmt = "graphite ParseError (%s): %s"
 	if len(*s) == 0 {
 		return nil, fmt.Errorf(parseErrFmt, req.URL, "empty response")
@@ -444,20 +480,9 @@ func parseGraphiteResponse(req *graphite.Request, s *graphite.Response, formatTa
 	results := make([]*Result, 0)
 	for _, res := range *s {
 		// build tag set
-		tags := make(opentsdb.TagSet)
-		if len(formatTags) == 1 && formatTags[0] == "" {
-			tags["key"] = res.Target
-		} else {
-			nodes := strings.Split(res.Target, ".")
-			if len(nodes) < len(formatTags) {
-				msg := fmt.Sprintf("returned target '%s' does not match format '%s'", res.Target, strings.Join(formatTags, ","))
-				return nil, fmt.Errorf(parseErrFmt, req.URL, msg)
-			}
-			for i, key := range formatTags {
-				if len(key) > 0 {
-					tags[key] = nodes[i]
-				}
-			}
+		tags, err := format.tags(res.Target)
+		if err != nil {
+			return nil, fmt.Errorf(parseErrFmt, req.URL, err)
 		}
 		if ts := tags.String(); !seen[ts] {
 			seen[ts] = true
@@ -511,6 +536,12 @@ func GraphiteBand(e *State, T miniprofiler.Timer, query, duration, period, forma
 		}
 		if num < 1 || num > 100 {
 			err = fmt.Errorf("expr: Band: num out of bounds")
+			return
+		}
+		var f *graphiteFormat
+		f, err = parseGraphiteFormat(format)
+		if err != nil {
+			return
 		}
 		req := &graphite.Request{
 			Targets: []string{query},
@@ -529,9 +560,8 @@ func GraphiteBand(e *State, T miniprofiler.Timer, query, duration, period, forma
 			if err != nil {
 				return
 			}
-			formatTags := strings.Split(format, ".")
 			var results []*Result
-			results, err = parseGraphiteResponse(req, &s, formatTags)
+			results, err = parseGraphiteResponse(req, &s, f)
 			if err != nil {
 				return
 			}
@@ -588,7 +618,7 @@ func bandTSDB(e *State, T miniprofiler.Timer, query, duration, period string, nu
 		if err != nil {
 			return
 		}
-		if err = e.Search.Expand(q); err != nil {
+		if err = e.expand(q); err != nil {
 			return
 		}
 		req := opentsdb.Request{
@@ -748,6 +778,10 @@ func GraphiteQuery(e *State, T miniprofiler.Timer, query string, sduration, edur
 			return
 		}
 	}
+	f, err := parseGraphiteFormat(format)
+	if err != nil {
+		return
+	}
 	st := e.now.Add(-time.Duration(sd))
 	et := e.now.Add(-time.Duration(ed))
 	req := &graphite.Request{
@@ -759,9 +793,8 @@ func GraphiteQuery(e *State, T miniprofiler.Timer, query string, sduration, edur
 	if err != nil {
 		return nil, err
 	}
-	formatTags := strings.Split(format, ".")
 	r = new(Results)
-	results, err := parseGraphiteResponse(req, &s, formatTags)
+	results, err := parseGraphiteResponse(req, &s, f)
 	if err != nil {
 		return nil, err
 	}
@@ -773,21 +806,31 @@ func GraphiteQuery(e *State, T miniprofiler.Timer, query string, sduration, edur
 func graphiteTagQuery(args []parse.Node) (parse.Tags, error) {
 	t := make(parse.Tags)
 	n := args[3].(*parse.StringNode)
-	for _, s := range strings.Split(n.Text, ".") {
-		if s != "" {
-			t[s] = struct{}{}
-		}
+	f, err := parseGraphiteFormat(n.Text)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range f.tagKeys() {
+		t[k] = struct{}{}
 	}
 	return t, nil
 }
 
+// expand expands the tag value globs of q if there is a Searcher.
+func (e *State) expand(q *opentsdb.Query) error {
+	if e.Search == nil {
+		return nil
+	}
+	return e.Search.Expand(q)
+}
+
 func Query(e *State, T miniprofiler.Timer, query, sduration, eduration string) (r *Results, err error) {
 	r = new(Results)
 	q, err := opentsdb.ParseQuery(query)
 	if q == nil && err != nil {
 		return
 	}
-	if err = e.Search.Expand(q); err != nil {
+	if err = e.expand(q); err != nil {
 		return
 	}
 	sd, err := opentsdb.ParseDuration(sduration)
@@ -843,7 +886,7 @@ func timeGraphiteRequest(e *State, T miniprofiler.Timer, req *graphite.Request)
 			return e.graphiteContext.Query(req)
 		}
 		var val interface{}
-		val, err = e.cache.Get(key, getFn)
+		val, err = e.cacheGet(key, getFn)
 		resp = val.(graphite.Response)
 	})
 	return
@@ -870,7 +913,7 @@ func timeTSDBRequest(e *State, T miniprofiler.Timer, req *opentsdb.Request) (s o
 				return e.tsdbContext.Query(req)
 			}
 			var val interface{}
-			val, err = e.cache.Get(string(b), getFn)
+			val, err = e.cacheGet(string(b), getFn)
 			s = val.(opentsdb.ResponseSet).Copy()
 
 		})
@@ -951,6 +994,7 @@ func reduce(e *State, T miniprofiler.Timer, series *Results, F func(Series, ...f
 			return nil
 		}
 		s.Value = Number(F(t, floats...))
+		s.Bounds = nil
 		res.Results = append(res.Results, s)
 		return nil
 	}
@@ -1037,17 +1081,132 @@ func Des(e *State, T miniprofiler.Timer, series *Results, alpha float64, beta fl
 		des := make(Series)
 		s := make([]float64, len(sorted))
 		b := make([]float64, len(sorted))
+		errs := make([]float64, 0, len(sorted))
 		s[0] = sorted[0].V
 		for i := 1; i < len(sorted); i++ {
+			errs = append(errs, sorted[i].V-(s[i-1]+b[i-1]))
 			s[i] = alpha*sorted[i].V + (1-alpha)*(s[i-1]+b[i-1])
 			b[i] = beta*(s[i]-s[i-1]) + (1-beta)*b[i-1]
 			des[sorted[i].T] = s[i]
 		}
 		res.Value = des
+		res.Bounds = forecastBounds(des, errs)
 	}
 	return series
 }
 
+// HoltWinters returns the one-step-ahead forecast of each series using
+// additive Holt-Winters triple exponential smoothing. season is the number of
+// points in a season. The forecast starts after the first season, which is used
+// with the second to initialize the model, so series shorter than two seasons
+// are returned empty.
+func HoltWinters(e *State, T miniprofiler.Timer, series *Results, alpha, beta, gamma, season float64) (*Results, error) {
+	for _, f := range []float64{alpha, beta, gamma} {
+		if f < 0 || f > 1 {
+			return nil, fmt.Errorf("holtwinters: smoothing factors must be between 0 and 1")
+		}
+	}
+	l := int(season)
+	if l < 1 {
+		return nil, fmt.Errorf("holtwinters: season must be at least 1")
+	}
+	for _, res := range series.Results {
+		sorted := NewSortedSeries(res.Value.Value().(Series))
+		hw := make(Series)
+		res.Value = hw
+		res.Bounds = nil
+		if len(sorted) < 2*l {
+			continue
+		}
+		var errs []float64
+		var first, second float64
+		for i := 0; i < l; i++ {
+			first += sorted[i].V
+			second += sorted[i+l].V
+		}
+		level := first / float64(l)
+		trend := (second - first) / float64(l*l)
+		seasonal := make([]float64, len(sorted))
+		for i := 0; i < l; i++ {
+			seasonal[i] = sorted[i].V - level
+		}
+		for i := l; i < len(sorted); i++ {
+			hw[sorted[i].T] = level + trend + seasonal[i-l]
+			errs = append(errs, sorted[i].V-hw[sorted[i].T])
+			prev := level
+			level = alpha*(sorted[i].V-seasonal[i-l]) + (1-alpha)*(level+trend)
+			trend = beta*(level-prev) + (1-beta)*trend
+			seasonal[i] = gamma*(sorted[i].V-level) + (1-gamma)*seasonal[i-l]
+		}
+		res.Bounds = forecastBounds(hw, errs)
+	}
+	return series, nil
+}
+
+// boundsZ is the number of standard errors either side of a forecast its
+// bounds span, giving a 95% interval if errors are normally distributed.
+const boundsZ = 1.96
+
+// forecastBounds returns the interval around fit given the one-step forecast
+// errors errs of the model that produced it, or nil if there are too few
+// errors to estimate it.
+func forecastBounds(fit Series, errs []float64) *Bounds {
+	if len(errs) < 2 {
+		return nil
+	}
+	var sum float64
+	for _, e := range errs {
+		sum += e * e
+	}
+	d := boundsZ * math.Sqrt(sum/float64(len(errs)))
+	b := &Bounds{
+		Lower: make(Series, len(fit)),
+		Upper: make(Series, len(fit)),
+	}
+	for t, v := range fit {
+		b.Lower[t] = v - d
+		b.Upper[t] = v + d
+	}
+	return b
+}
+
+// ZScore replaces each point of each series with its number of standard
+// deviations from the series mean.
+func ZScore(e *State, T miniprofiler.Timer, series *Results) (*Results, error) {
+	for _, res := range series.Results {
+		dps := res.Value.Value().(Series)
+		if len(dps) == 0 {
+			continue
+		}
+		a, d := avg(dps), dev(dps)
+		z := make(Series, len(dps))
+		for t, v := range dps {
+			if d == 0 {
+				z[t] = 0
+				continue
+			}
+			z[t] = (v - a) / d
+		}
+		res.Value = z
+		res.Bounds = nil
+	}
+	return series, nil
+}
+
+func MAD(e *State, T miniprofiler.Timer, series *Results) (*Results, error) {
+	return reduce(e, T, series, mad)
+}
+
+// mad returns the median absolute deviation of x.
+func mad(dps Series, args ...float64) float64 {
+	m := percentile(dps, .5)
+	devs := make(Series, len(dps))
+	for t, v := range dps {
+		devs[t] = math.Abs(v - m)
+	}
+	return percentile(devs, .5)
+}
+
 func Streak(e *State, T miniprofiler.Timer, series *Results) (*Results, error) {
 	return reduce(e, T, series, streak)
 }
@@ -1152,36 +1311,53 @@ func Forecast_lr(e *State, T miniprofiler.Timer, series *Results, y *Results) (r
 }
 
 // forecast_lr returns the number of seconds a linear regression predicts the
-// series will take to reach y_val.
+// series will take to reach y_val, limited to ten years either way.
 func (e *State) forecast_lr(dps Series, args ...float64) float64 {
-	const tenYears = time.Hour * 24 * 365 * 10
-	yVal := args[0]
+	tenYears := (time.Hour * 24 * 365 * 10).Seconds()
+	s := e.regressionCrossing(dps, args[0])
+	switch {
+	case math.IsNaN(s):
+		return 0
+	case s < -tenYears:
+		return -tenYears
+	case s > tenYears:
+		return tenYears
+	}
+	return s
+}
+
+func Forecast(e *State, T miniprofiler.Timer, series *Results, y *Results) (r *Results, err error) {
+	return reduce(e, T, series, e.forecast, y)
+}
+
+// forecast returns the number of seconds from now until a linear regression
+// of the series reaches y_val, or +Inf if it is not trending toward it: it is
+// flat, or it crossed y_val before now.
+func (e *State) forecast(dps Series, args ...float64) float64 {
+	s := e.regressionCrossing(dps, args[0])
+	if math.IsNaN(s) || math.IsInf(s, 0) || s < 0 {
+		return math.Inf(1)
+	}
+	return s
+}
+
+// regressionCrossing returns the number of seconds from now until a linear
+// regression of dps reaches yVal: negative if it did before now, and NaN or
+// infinite if the regression is flat.
+func (e *State) regressionCrossing(dps Series, yVal float64) float64 {
 	var x []float64
 	var y []float64
 	for k, v := range dps {
-		x = append(x, float64(k.Unix()))
+		x = append(x, k.Sub(e.now).Seconds())
 		y = append(y, v)
 	}
-	var slope, intercept, _, _, _, _ = stats.LinearRegression(x, y)
-	it := (yVal - intercept) / slope
-	var i64 int64
-	if it < math.MinInt64 {
-		i64 = math.MinInt64
-	} else if it > math.MaxInt64 {
-		i64 = math.MaxInt64
-	} else if math.IsNaN(it) {
-		i64 = e.now.Unix()
-	} else {
-		i64 = int64(it)
-	}
-	t := time.Unix(i64, 0)
-	s := -e.now.Sub(t)
-	if s < -tenYears {
-		s = -tenYears
-	} else if s > tenYears {
-		s = tenYears
+	// With x relative to now, the intercept is the value of the regression
+	// now.
+	slope, intercept, _, _, _, _ := stats.LinearRegression(x, y)
+	if intercept == yVal {
+		return 0
 	}
-	return s.Seconds()
+	return (yVal - intercept) / slope
 }
 
 func Percentile(e *State, T miniprofiler.Timer, series *Results, p *Results) (r *Results, err error) {
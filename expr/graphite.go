@@ -0,0 +1,139 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"bosun.org/opentsdb"
+)
+
+// graphiteFormat maps the nodes of a graphite target to tags. A format is
+// either a dot-separated list of tag keys, one per node, where an empty key
+// skips a node ("host..cpu"), or a comma-separated list of tagk=node pairs
+// ("dc=0,host=1,disk=-1,path=2:4"). A node is a zero-based index, negative to
+// count from the end, or a start:end range of nodes joined by dots, where
+// either bound may be omitted.
+type graphiteFormat struct {
+	keys  []string
+	nodes []graphiteNode
+}
+
+type graphiteNode struct {
+	key        string
+	start, end int
+	isRange    bool
+	hasStart   bool
+	hasEnd     bool
+}
+
+func parseGraphiteFormat(format string) (*graphiteFormat, error) {
+	f := &graphiteFormat{}
+	if !strings.Contains(format, "=") {
+		f.keys = strings.Split(format, ".")
+		return f, nil
+	}
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(format, ",") {
+		sp := strings.SplitN(pair, "=", 2)
+		if len(sp) != 2 {
+			return nil, fmt.Errorf("graphite: bad format %q: expected tagk=node", pair)
+		}
+		n := graphiteNode{key: strings.TrimSpace(sp[0])}
+		if n.key == "" || !opentsdb.ValidTag(n.key) {
+			return nil, fmt.Errorf("graphite: bad tag key in format: %q", sp[0])
+		}
+		if seen[n.key] {
+			return nil, fmt.Errorf("graphite: duplicate tag key in format: %s", n.key)
+		}
+		seen[n.key] = true
+		node := strings.TrimSpace(sp[1])
+		var err error
+		if i := strings.Index(node, ":"); i >= 0 {
+			n.isRange = true
+			if s := node[:i]; s != "" {
+				n.hasStart = true
+				if n.start, err = strconv.Atoi(s); err != nil {
+					return nil, fmt.Errorf("graphite: bad node range in format: %s", node)
+				}
+			}
+			if s := node[i+1:]; s != "" {
+				n.hasEnd = true
+				if n.end, err = strconv.Atoi(s); err != nil {
+					return nil, fmt.Errorf("graphite: bad node range in format: %s", node)
+				}
+			}
+		} else if n.start, err = strconv.Atoi(node); err != nil {
+			return nil, fmt.Errorf("graphite: bad node index in format: %s", node)
+		}
+		f.nodes = append(f.nodes, n)
+	}
+	return f, nil
+}
+
+// tagKeys returns the tag keys produced by f.
+func (f *graphiteFormat) tagKeys() []string {
+	if f.nodes == nil {
+		var keys []string
+		for _, k := range f.keys {
+			if k != "" {
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	keys := make([]string, len(f.nodes))
+	for i, n := range f.nodes {
+		keys[i] = n.key
+	}
+	return keys
+}
+
+// tags returns the tag set of target.
+func (f *graphiteFormat) tags(target string) (opentsdb.TagSet, error) {
+	tags := make(opentsdb.TagSet)
+	if f.nodes == nil && len(f.keys) == 1 && f.keys[0] == "" {
+		tags["key"] = target
+		return tags, nil
+	}
+	nodes := strings.Split(target, ".")
+	if f.nodes == nil {
+		if len(nodes) < len(f.keys) {
+			return nil, fmt.Errorf("returned target '%s' does not match format '%s'", target, strings.Join(f.keys, "."))
+		}
+		for i, key := range f.keys {
+			if len(key) > 0 {
+				tags[key] = nodes[i]
+			}
+		}
+		return tags, nil
+	}
+	index := func(i int) int {
+		if i < 0 {
+			return len(nodes) + i
+		}
+		return i
+	}
+	for _, n := range f.nodes {
+		if !n.isRange {
+			i := index(n.start)
+			if i < 0 || i >= len(nodes) {
+				return nil, fmt.Errorf("returned target '%s' has no node %d for tag %s", target, n.start, n.key)
+			}
+			tags[n.key] = nodes[i]
+			continue
+		}
+		start, end := 0, len(nodes)
+		if n.hasStart {
+			start = index(n.start)
+		}
+		if n.hasEnd {
+			end = index(n.end)
+		}
+		if start < 0 || end > len(nodes) || start >= end {
+			return nil, fmt.Errorf("returned target '%s' has no nodes %d:%d for tag %s", target, n.start, n.end, n.key)
+		}
+		tags[n.key] = strings.Join(nodes[start:end], ".")
+	}
+	return tags, nil
+}
@@ -0,0 +1,65 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/opentsdb"
+)
+
+func TestGraphiteFormat(t *testing.T) {
+	const target = "ny.web01.disk.sda.used"
+	tests := []struct {
+		format string
+		tags   opentsdb.TagSet
+		keys   int
+	}{
+		{"", opentsdb.TagSet{"key": target}, 0},
+		{"dc.host", opentsdb.TagSet{"dc": "ny", "host": "web01"}, 2},
+		{".host..disk", opentsdb.TagSet{"host": "web01", "disk": "sda"}, 2},
+		{"host=1,disk=-2", opentsdb.TagSet{"host": "web01", "disk": "sda"}, 2},
+		{"dc=0, path=2:4", opentsdb.TagSet{"dc": "ny", "path": "disk.sda"}, 2},
+		{"head=:2,tail=-2:", opentsdb.TagSet{"head": "ny.web01", "tail": "sda.used"}, 2},
+	}
+	for _, test := range tests {
+		f, err := parseGraphiteFormat(test.format)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if n := len(f.tagKeys()); n != test.keys {
+			t.Errorf("%s: got %d tag keys, expected %d", test.format, n, test.keys)
+		}
+		tags, err := f.tags(target)
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if !tags.Equal(test.tags) {
+			t.Errorf("%s: got %v, expected %v", test.format, tags, test.tags)
+		}
+	}
+	for _, format := range []string{"host=x", "host=1,host=2", "=1", "a=1:b"} {
+		if _, err := parseGraphiteFormat(format); err == nil {
+			t.Errorf("%s: expected error", format)
+		}
+	}
+	for _, format := range []string{"host=5", "host=-6", "path=3:2", "a.b.c.d.e.f"} {
+		f, err := parseGraphiteFormat(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.tags(target); err == nil {
+			t.Errorf("%s: expected error", format)
+		}
+	}
+}
+
+func TestGraphiteBandNum(t *testing.T) {
+	e := State{}
+	_, err := GraphiteBand(&e, new(miniprofiler.Profile), "a.b", "1h", "1d", "", 101)
+	if err == nil || !strings.Contains(err.Error(), "num out of bounds") {
+		t.Fatalf("expected num out of bounds error, got %v", err)
+	}
+}
@@ -10,7 +10,7 @@ import (
 	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
 	"bosun.org/_third_party/github.com/influxdb/influxdb/influxql"
 	"bosun.org/_third_party/github.com/influxdb/influxdb/models"
-	"bosun.org/cmd/bosun/expr/parse"
+	"bosun.org/expr/parse"
 	"bosun.org/opentsdb"
 )
 
@@ -215,7 +215,7 @@ func timeInfluxRequest(e *State, T miniprofiler.Timer, db, query, startDuration,
 		}
 		var val interface{}
 		var ok bool
-		val, err = e.cache.Get(q, getFn)
+		val, err = e.cacheGet(q, getFn)
 		if s, ok = val.([]models.Row); !ok {
 			err = fmt.Errorf("influx: did not get a valid result from InfluxDB")
 		}
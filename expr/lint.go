@@ -0,0 +1,178 @@
+package expr
+
+import (
+	"fmt"
+	"time"
+
+	"bosun.org/expr/parse"
+	"bosun.org/opentsdb"
+)
+
+// LintMaxRange is the longest time range a query may span before Lint
+// reports it as unbounded.
+var LintMaxRange = opentsdb.Week
+
+// lintFunc describes where the interesting arguments of a query function
+// are. An index of -1 means the function has no such argument.
+type lintFunc struct {
+	query, start, period, num int
+	// downsample is the argument holding the downsample interval, or -2 if
+	// the downsample is part of an OpenTSDB query string.
+	downsample int
+}
+
+const lintTSDBDownsample = -2
+
+var lintFuncs = map[string]lintFunc{
+	"q":            {0, 1, -1, -1, lintTSDBDownsample},
+	"change":       {0, 1, -1, -1, lintTSDBDownsample},
+	"count":        {0, 1, -1, -1, lintTSDBDownsample},
+	"band":         {0, 1, 2, 3, lintTSDBDownsample},
+	"window":       {0, 1, 2, 3, lintTSDBDownsample},
+	"graphite":     {0, 1, -1, -1, -1},
+	"graphiteBand": {0, 1, 2, 4, -1},
+	"influx":       {1, 2, -1, -1, 4},
+	"lscount":      {2, 4, -1, -1, 3},
+	"lsstat":       {2, 6, -1, -1, 5},
+	"esAggr":       {2, 5, -1, -1, -1},
+	"sql":          {1, 3, -1, -1, -1},
+	"sqlSeries":    {1, 4, -1, -1, -1},
+	"cloudwatch":   {2, 6, -1, -1, 5},
+	"events":       {0, 2, -1, -1, -1},
+}
+
+// Lint is the result of statically analyzing an expression.
+type Lint struct {
+	// Queries maps each distinct query function call in the expression to
+	// the number of datasource requests it makes.
+	Queries map[string]int
+	// Requests is the number of datasource requests one evaluation makes.
+	// Identical calls are counted once since they are served from the cache.
+	Requests int
+	Warnings []string
+}
+
+// Lint statically analyzes e for queries with overly long time ranges,
+// OpenTSDB queries without downsampling, and repeated subexpressions, and
+// estimates the number of datasource requests it makes.
+func (e *Expr) Lint() *Lint {
+	l := &Lint{Queries: make(map[string]int)}
+	counts := make(map[string]int)
+	parse.Walk(e.Tree.Root, func(n parse.Node) {
+		f, ok := n.(*parse.FuncNode)
+		if !ok {
+			return
+		}
+		s := f.String()
+		counts[s]++
+		lf, ok := lintFuncs[f.Name]
+		if _, dup := l.Queries[s]; !ok || dup {
+			return
+		}
+		l.Queries[s] = lintNum(f, lf)
+		l.Requests += l.Queries[s]
+		l.Warnings = append(l.Warnings, lintQuery(f, lf)...)
+	})
+	var dups []string
+	var walk func(parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.FuncNode:
+			if counts[n.String()] > 1 && lintHasQuery(n) {
+				dups = append(dups, n.String())
+				return
+			}
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *parse.BinaryNode:
+			walk(n.Args[0])
+			walk(n.Args[1])
+		case *parse.UnaryNode:
+			walk(n.Arg)
+		}
+	}
+	walk(e.Tree.Root)
+	reported := make(map[string]bool)
+	for _, d := range dups {
+		if reported[d] {
+			continue
+		}
+		reported[d] = true
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%s: repeated %d times; consider a variable", d, counts[d]))
+	}
+	return l
+}
+
+// lintHasQuery reports whether n contains a query function call.
+func lintHasQuery(n parse.Node) bool {
+	found := false
+	parse.Walk(n, func(n parse.Node) {
+		if f, ok := n.(*parse.FuncNode); ok {
+			if _, ok := lintFuncs[f.Name]; ok {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+func lintString(f *parse.FuncNode, i int) (string, bool) {
+	if i < 0 || i >= len(f.Args) {
+		return "", false
+	}
+	s, ok := f.Args[i].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return s.Text, true
+}
+
+func lintNum(f *parse.FuncNode, lf lintFunc) int {
+	if lf.num < 0 || lf.num >= len(f.Args) {
+		return 1
+	}
+	n, ok := f.Args[lf.num].(*parse.NumberNode)
+	if !ok || n.Float64 < 1 {
+		return 1
+	}
+	return int(n.Float64)
+}
+
+// lintDuration formats d in days when it is a whole number of them.
+func lintDuration(d opentsdb.Duration) string {
+	if d%opentsdb.Day == 0 {
+		return fmt.Sprintf("%dd", d/opentsdb.Day)
+	}
+	return time.Duration(d).String()
+}
+
+func lintQuery(f *parse.FuncNode, lf lintFunc) []string {
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, f.String()+": "+fmt.Sprintf(format, args...))
+	}
+	if s, ok := lintString(f, lf.start); ok {
+		if d, err := opentsdb.ParseDuration(s); err == nil {
+			span := d
+			if p, ok := lintString(f, lf.period); ok {
+				if pd, err := opentsdb.ParseDuration(p); err == nil {
+					span = pd*opentsdb.Duration(lintNum(f, lf)) + d
+				}
+			}
+			if span > LintMaxRange {
+				warn("time range %s exceeds %s", lintDuration(span), lintDuration(LintMaxRange))
+			}
+		}
+	}
+	if lf.downsample == lintTSDBDownsample {
+		if s, ok := lintString(f, lf.query); ok {
+			if q, err := opentsdb.ParseQuery(s); err == nil && q.Downsample == "" {
+				warn("query is not downsampled")
+			}
+		}
+	} else if s, ok := lintString(f, lf.downsample); ok && s == "" {
+		warn("query is not downsampled")
+	}
+	return warnings
+}
@@ -0,0 +1,52 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		expr     string
+		requests int
+		warnings []string
+	}{
+		{`avg(q("avg:5m-avg:os.cpu", "1h", ""))`, 1, nil},
+		{`avg(q("avg:os.cpu", "1h", ""))`, 1, []string{"not downsampled"}},
+		{`avg(q("avg:1h-avg:os.cpu", "30d", ""))`, 1, []string{"time range 30d exceeds 7d"}},
+		{`avg(band("avg:1h-avg:os.cpu", "1d", "1w", 4))`, 4, []string{"time range 29d exceeds 7d"}},
+		{`avg(q("avg:5m-avg:os.cpu", "1h", "")) > 1 && avg(q("avg:5m-avg:os.cpu", "1h", "")) < 5`, 1, []string{"repeated 2 times"}},
+		{`avg(q("avg:5m-avg:os.cpu", "1h", "")) + max(q("avg:5m-avg:os.cpu", "1h", ""))`, 1, []string{`q("avg:5m-avg:os.cpu", "1h", ""): repeated 2 times`}},
+		{`epoch() - epoch()`, 0, nil},
+	}
+	for _, test := range tests {
+		e, err := New(test.expr, TSDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l := e.Lint()
+		if l.Requests != test.requests {
+			t.Errorf("%s: expected %d requests, got %d", test.expr, test.requests, l.Requests)
+		}
+		if len(l.Warnings) != len(test.warnings) {
+			t.Errorf("%s: expected warnings %q, got %q", test.expr, test.warnings, l.Warnings)
+			continue
+		}
+		for i, w := range test.warnings {
+			if !strings.Contains(l.Warnings[i], w) {
+				t.Errorf("%s: expected warning containing %q, got %q", test.expr, w, l.Warnings[i])
+			}
+		}
+	}
+}
+
+func TestLintSQLSeries(t *testing.T) {
+	e, err := New(`sqlSeries("shop", "select queue, at, depth from queue_depths", "queue", "at", "30d", "")`, SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := e.Lint()
+	if l.Requests != 1 || len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0], "time range 30d exceeds 7d") {
+		t.Errorf("expected 1 request and a time range warning, got %d and %q", l.Requests, l.Warnings)
+	}
+}
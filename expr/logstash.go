@@ -0,0 +1,583 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	"bosun.org/_third_party/github.com/olivere/elastic"
+	"bosun.org/expr/parse"
+	"bosun.org/opentsdb"
+)
+
+// Clients are global since the elastic client handles connections. They
+// are keyed by the cluster's hosts, so they are reused across reloads.
+var (
+	esClients = make(map[string]*elastic.Client)
+	esLock    sync.Mutex
+)
+
+// The following are specific functions that query an elastic instance populated by
+// logstash. They are only loaded when the elastic hosts are set in the config file
+var LogstashElastic = map[string]parse.Func{
+	"lscount": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeSeriesSet,
+		Tags:   logstashTagQuery,
+		F:      LSCount,
+	},
+	"lsstat": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeSeriesSet,
+		Tags:   logstashTagQuery,
+		F:      LSStat,
+	},
+	"esAggr": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeNumberSet,
+		Tags:   logstashTagQuery,
+		F:      ESAggr,
+	},
+}
+
+// DefaultElasticCluster is the name of the cluster queried by indices without
+// a cluster name.
+const DefaultElasticCluster = "default"
+
+// ElasticCluster is an elastic cluster and how its time based indices are
+// named: root, IndexSeparator, then the start of the period the index covers
+// formatted with DateFormat, as in logstash-2015.06.24.
+type ElasticCluster struct {
+	Hosts          []string // All part of the same cluster, i.e http://ny-elastic01:9200
+	IndexSeparator string
+	DateFormat     string // Go time layout
+	TimeField      string
+	Version        int // Major version of elasticsearch
+}
+
+// NewElasticCluster returns a cluster with logstash's index naming.
+func NewElasticCluster(hosts []string) *ElasticCluster {
+	return &ElasticCluster{
+		Hosts:          hosts,
+		IndexSeparator: "-",
+		DateFormat:     "2006.01.02",
+		TimeField:      "@timestamp",
+		Version:        1,
+	}
+}
+
+// ElasticHosts are the elastic clusters by name. An index argument is
+// queried on the cluster named by its prefix, as in prod:logstash, or else on
+// the default cluster.
+type ElasticHosts map[string]*ElasticCluster
+
+// Cluster returns the cluster and index root of index.
+func (e ElasticHosts) Cluster(index string) (*ElasticCluster, string, error) {
+	if i := strings.Index(index, ":"); i >= 0 {
+		if c := e[index[:i]]; c != nil {
+			return c, index[i+1:], nil
+		}
+	}
+	c := e[DefaultElasticCluster]
+	if c == nil {
+		return nil, "", fmt.Errorf("no default elastic cluster for index %s, prefix it with a cluster name", index)
+	}
+	return c, index, nil
+}
+
+// client returns the elastic client for c, creating it if needed.
+func (c *ElasticCluster) client() (*elastic.Client, error) {
+	esLock.Lock()
+	defer esLock.Unlock()
+	key := strings.Join(c.Hosts, ",")
+	if client := esClients[key]; client != nil {
+		return client, nil
+	}
+	// The client discovers the cluster's other hosts from the nodes info of
+	// 1.x, whose format later versions changed.
+	client, err := elastic.NewClient(elastic.SetURL(c.Hosts...), elastic.SetMaxRetries(10), elastic.SetSniff(c.Version < 2))
+	if err != nil {
+		return nil, err
+	}
+	esClients[key] = client
+	return client, nil
+}
+
+// termsSize is the size of terms aggregations, which must return all terms.
+func (c *ElasticCluster) termsSize() int {
+	if c.Version >= 5 {
+		// 5.x no longer accepts 0 for unlimited.
+		return math.MaxInt32
+	}
+	return 0
+}
+
+// Query takes a Logstash request, applies it a search service, and then queries
+// elasticsearch.
+func (e ElasticHosts) Query(r *LogstashRequest) (*elastic.SearchResult, error) {
+	client, err := r.Cluster.client()
+	if err != nil {
+		return nil, err
+	}
+	indicies, err := r.Cluster.GenIndices(r)
+	if err != nil {
+		return nil, err
+	}
+	return client.Search().Indices(indicies).SearchSource(r.Source).Do()
+}
+
+// LogstashRequest is a container for the information needed to query elasticsearch.
+type LogstashRequest struct {
+	Cluster    *ElasticCluster
+	IndexRoot  string // The root of all index names i.e. logstash in logstash-2014-04-25
+	Start      *time.Time
+	End        *time.Time
+	Source     *elastic.SearchSource // This the object that we build queries in
+	KeyMatches []lsKeyMatch
+}
+
+// CacheKey returns the text of the elastic query. That text is the indentifer for
+// the query in the cache
+func (r *LogstashRequest) CacheKey() string {
+	return r.Source.Source().(string)
+}
+
+// timeLSRequest execute the elasticsearch query (which may set or hit cache) and returns
+// the search results.
+func timeLSRequest(e *State, T miniprofiler.Timer, req *LogstashRequest) (resp *elastic.SearchResult, err error) {
+	e.logstashQueries = append(e.logstashQueries, *req.Source)
+	b, _ := json.MarshalIndent(req.Source.Source(), "", "  ")
+	// The same query may be sent to other indices or clusters.
+	key := fmt.Sprintf("%s %s\n%s", strings.Join(req.Cluster.Hosts, ","), req.IndexRoot, b)
+	T.StepCustomTiming("logstash", "query", string(b), func() {
+		getFn := func() (interface{}, error) {
+			return e.logstashHosts.Query(req)
+		}
+		var val interface{}
+		val, err = e.cacheGet(key, getFn)
+		resp = val.(*elastic.SearchResult)
+	})
+	return
+}
+
+// The regexs in keystring are applied twice. First as a regexp filter to
+// elastic, and then as a go regexp to the keys of the result. This is because
+// the value could be an array and you will get groups that should be filtered.
+type lsKeyMatch struct {
+	Key        string
+	RawPattern string
+	Pattern    *regexp.Regexp
+}
+
+// GenIndicies generates the indexes to hit based on the timeframe of the query.
+// An index covers from its date until the date of the next index, so indices
+// may be hourly, daily, monthly, or any other period.
+func (c *ElasticCluster) GenIndices(r *LogstashRequest) (string, error) {
+	// Short-circut when using concrete ES index name
+	if strings.HasSuffix(r.IndexRoot, "/") {
+		return r.IndexRoot[:len(r.IndexRoot)-1], nil
+	}
+	client, err := c.client()
+	if err != nil {
+		return "", err
+	}
+	indices, err := client.IndexNames()
+	if err != nil {
+		return "", err
+	}
+	selectedIndices := c.selectIndices(indices, r.IndexRoot, *r.Start, *r.End)
+	if len(selectedIndices) == 0 {
+		return "", fmt.Errorf("no elastic indices available during this time range, index[%s], start/end [%s|%s]", r.IndexRoot, r.Start.Format(c.DateFormat), r.End.Format(c.DateFormat))
+	}
+	return strings.Join(selectedIndices, ","), nil
+}
+
+type datedIndex struct {
+	name string
+	date time.Time
+}
+
+type datedIndices []datedIndex
+
+func (d datedIndices) Len() int           { return len(d) }
+func (d datedIndices) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d datedIndices) Less(i, j int) bool { return d[i].date.Before(d[j].date) }
+
+// selectIndices returns the indices named root that cover start to end.
+func (c *ElasticCluster) selectIndices(indices []string, root string, start, end time.Time) []string {
+	prefix := root + c.IndexSeparator
+	var dated datedIndices
+	for _, index := range indices {
+		if !strings.HasPrefix(index, prefix) {
+			continue
+		}
+		d, err := time.Parse(c.DateFormat, index[len(prefix):])
+		if err != nil {
+			continue
+		}
+		dated = append(dated, datedIndex{index, d})
+	}
+	sort.Sort(dated)
+	var selected []string
+	for i, d := range dated {
+		if d.date.After(end) {
+			break
+		}
+		if i+1 < len(dated) && !dated[i+1].date.After(start) {
+			continue
+		}
+		selected = append(selected, d.name)
+	}
+	return selected
+}
+
+// LScount takes 6 arguments and returns the per second for matching documents.
+// index_root is the root name of the index to hit, the format is expected to be
+// fmt.Sprintf("%s-%s", index_root, d.Format("2006.01.02")).
+// keystring creates groups (like tagsets) and can also filter those groups. It
+// is the format of "field:regex,field:regex..." The :regex can be ommited.
+// filter is an Elastic regexp query that can be applied to any field. It is in
+// the same format as the keystring argument.
+// interval is in the format of an opentsdb time duration, and tells elastic
+// what the bucket size should be. The result will be normalized to a per second
+// rate regardless of what this is set to.
+// sduration and end duration are the time bounds for the query and are in
+// opentsdb's relative time format:
+// http://opentsdb.net/docs/build/html/user_guide/query/dates.html
+// Caveats:
+// 1) There is currently no escaping in the keystring, so if you regex needs to
+// have a comma or double quote you are out of luck.
+// 2) If the type of the field value in Elastic (aka the mapping) is a number
+// then the regexes won't act as a regex. The only thing you can do is an exact
+// match on the number, ie "eventlogid:1234". It is recommended that anything
+// that is a identifer should be stored as a string since they are not numbers
+// even if they are made up entirely of numerals.
+func LSCount(e *State, T miniprofiler.Timer, index_root, keystring, filter, interval, sduration, eduration string) (r *Results, err error) {
+	return LSDateHistogram(e, T, index_root, keystring, filter, interval, sduration, eduration, "", "", 0)
+}
+
+// LSStat returns a bucketed statistical reduction for the specified field.
+// The arguments are the same LSCount with the addition of the following:
+// The field is the field to generate stats for - this must be a number type in
+// elastic.
+// rstat is the reduction function to use per bucket and can be one of the
+// following: avg, min, max, sum, sum_of_squares, variance, std_deviation
+func LSStat(e *State, T miniprofiler.Timer, index_root, keystring, filter, field, rstat, interval, sduration, eduration string) (r *Results, err error) {
+	return LSDateHistogram(e, T, index_root, keystring, filter, interval, sduration, eduration, field, rstat, 0)
+}
+
+// LSDateHistorgram builds the aggregation query using subaggregations. The result is a grouped timer series
+// that Bosun can understand
+func LSDateHistogram(e *State, T miniprofiler.Timer, index_root, keystring, filter, interval, sduration, eduration, stat_field, rstat string, size int) (r *Results, err error) {
+	r = new(Results)
+	req, err := LSBaseQuery(e.now, index_root, e.logstashHosts, keystring, filter, sduration, eduration, size)
+	if err != nil {
+		return nil, err
+	}
+	ts := elastic.NewDateHistogramAggregation().Field(req.Cluster.TimeField).Interval(strings.Replace(interval, "M", "n", -1)).MinDocCount(0)
+	ds, err := opentsdb.ParseDuration(interval)
+	if err != nil {
+		return nil, err
+	}
+	if stat_field != "" {
+		ts = ts.SubAggregation("stats", elastic.NewExtendedStatsAggregation().Field(stat_field))
+		if !validStat(rstat) {
+			return r, fmt.Errorf("stat function %v not a valid option", rstat)
+		}
+	}
+	addSeries := func(aggs elastic.Aggregations, tags opentsdb.TagSet) error {
+		ts, found := aggs.DateHistogram("ts")
+		if !found {
+			return fmt.Errorf("expected time series not found in elastic reply")
+		}
+		series := make(Series)
+		for _, v := range ts.Buckets {
+			val := processBucketItem(v, rstat, ds)
+			if val != nil {
+				series[time.Unix(v.Key/1000, 0).UTC()] = *val
+			}
+		}
+		if len(series) == 0 {
+			return nil
+		}
+		r.Results = append(r.Results, &Result{
+			Value: series,
+			Group: tags,
+		})
+		return nil
+	}
+	if keystring == "" {
+		req.Source = req.Source.Aggregation("ts", ts)
+		result, err := timeLSRequest(e, T, req)
+		if err != nil {
+			return nil, err
+		}
+		if err := addSeries(result.Aggregations, make(opentsdb.TagSet)); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	req.Source = req.termsAggregation("ts", ts)
+	result, err := timeLSRequest(e, T, req)
+	if err != nil {
+		return nil, err
+	}
+	err = walkLSTerms(result.Aggregations, req.KeyMatches, make(opentsdb.TagSet), func(b *elastic.AggregationBucketKeyItem, tags opentsdb.TagSet) error {
+		if e.squelched(tags) {
+			return nil
+		}
+		return addSeries(b.Aggregations, tags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// termsAggregation returns r's search source with nested terms aggregations
+// grouping by each of its keys, with leaf, if not nil, as a subaggregation of
+// the innermost.
+func (r *LogstashRequest) termsAggregation(name string, leaf elastic.Aggregation) *elastic.SearchSource {
+	keys := r.KeyMatches
+	size := r.Cluster.termsSize()
+	aggregation := elastic.NewTermsAggregation().Field(keys[len(keys)-1].Key).Size(size)
+	if leaf != nil {
+		aggregation = aggregation.SubAggregation(name, leaf)
+	}
+	for i := len(keys) - 2; i > -1; i-- {
+		aggregation = elastic.NewTermsAggregation().Field(keys[i].Key).Size(size).SubAggregation("g_"+keys[i+1].Key, aggregation)
+	}
+	return r.Source.Aggregation("g_"+keys[0].Key, aggregation)
+}
+
+// walkLSTerms calls leaf with each innermost bucket of the aggregations
+// built by termsAggregation and its tags, skipping keys that don't match
+// their pattern.
+func walkLSTerms(aggs elastic.Aggregations, keys []lsKeyMatch, tags opentsdb.TagSet, leaf func(*elastic.AggregationBucketKeyItem, opentsdb.TagSet) error) error {
+	terms, ok := aggs.Terms("g_" + keys[0].Key)
+	if !ok {
+		return fmt.Errorf("key g_%v not found in result", keys[0].Key)
+	}
+	for _, b := range terms.Buckets {
+		key := fmt.Sprint(b.Key)
+		if keys[0].Pattern != nil && !keys[0].Pattern.MatchString(key) {
+			continue
+		}
+		t := tags.Copy()
+		t[keys[0].Key] = key
+		var err error
+		if len(keys) == 1 {
+			err = leaf(b, t)
+		} else {
+			err = walkLSTerms(b.Aggregations, keys[1:], t, leaf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func processBucketItem(b *elastic.AggregationBucketHistogramItem, rstat string, ds opentsdb.Duration) *float64 {
+	if stats, found := b.ExtendedStats("stats"); found {
+		return extendedStat(stats, rstat)
+	}
+	v := float64(b.DocCount) / ds.Seconds()
+	return &v
+}
+
+func validStat(rstat string) bool {
+	switch rstat {
+	case "avg", "min", "max", "sum", "sum_of_squares", "variance", "std_deviation":
+		return true
+	}
+	return false
+}
+
+func extendedStat(stats *elastic.AggregationExtendedStatsMetric, rstat string) *float64 {
+	switch rstat {
+	case "avg":
+		return stats.Avg
+	case "min":
+		return stats.Min
+	case "max":
+		return stats.Max
+	case "sum":
+		return stats.Sum
+	case "sum_of_squares":
+		return stats.SumOfSquares
+	case "variance":
+		return stats.Variance
+	case "std_deviation":
+		return stats.StdDeviation
+	}
+	return nil
+}
+
+// ESAggr returns one value per group of the documents in the time range,
+// grouped by terms aggregations on the keys of keystring. index, keystring,
+// filter, sduration, and eduration are as for LSCount. agg is one of:
+// count, the number of documents; avg, min, max, sum, sum_of_squares,
+// variance, or std_deviation of field; or pN, the Nth percentile of field,
+// as in p95 or p99.9. Groups without a value, like the average of no
+// documents, are omitted.
+func ESAggr(e *State, T miniprofiler.Timer, index, keystring, filter, field, agg, sduration, eduration string) (*Results, error) {
+	var metric elastic.Aggregation
+	var value func(elastic.Aggregations, int64) *float64
+	switch {
+	case agg == "count":
+		value = func(_ elastic.Aggregations, docs int64) *float64 {
+			v := float64(docs)
+			return &v
+		}
+	case strings.HasPrefix(agg, "p"):
+		p, err := strconv.ParseFloat(agg[1:], 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("esaggr: bad percentile %v", agg)
+		}
+		metric = elastic.NewPercentilesAggregation().Field(field).Percentiles(p)
+		value = func(aggs elastic.Aggregations, docs int64) *float64 {
+			m, found := aggs.Percentiles("m")
+			if !found || docs == 0 {
+				return nil
+			}
+			// Keys are the percentile formatted as a float, as in 95.0.
+			for _, v := range m.Values {
+				if !math.IsNaN(v) {
+					return &v
+				}
+			}
+			return nil
+		}
+	default:
+		if !validStat(agg) {
+			return nil, fmt.Errorf("esaggr: aggregation %v not a valid option", agg)
+		}
+		metric = elastic.NewExtendedStatsAggregation().Field(field)
+		value = func(aggs elastic.Aggregations, _ int64) *float64 {
+			stats, found := aggs.ExtendedStats("m")
+			if !found {
+				return nil
+			}
+			return extendedStat(stats, agg)
+		}
+	}
+	if metric != nil && field == "" {
+		return nil, fmt.Errorf("esaggr: %v requires a field", agg)
+	}
+	req, err := LSBaseQuery(e.now, index, e.logstashHosts, keystring, filter, sduration, eduration, 0)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Results)
+	add := func(aggs elastic.Aggregations, docs int64, tags opentsdb.TagSet) {
+		if v := value(aggs, docs); v != nil {
+			r.Results = append(r.Results, &Result{
+				Value: Number(*v),
+				Group: tags,
+			})
+		}
+	}
+	if keystring == "" {
+		if metric != nil {
+			req.Source = req.Source.Aggregation("m", metric)
+		}
+		result, err := timeLSRequest(e, T, req)
+		if err != nil {
+			return nil, err
+		}
+		add(result.Aggregations, result.Hits.TotalHits, make(opentsdb.TagSet))
+		return r, nil
+	}
+	req.Source = req.termsAggregation("m", metric)
+	result, err := timeLSRequest(e, T, req)
+	if err != nil {
+		return nil, err
+	}
+	err = walkLSTerms(result.Aggregations, req.KeyMatches, make(opentsdb.TagSet), func(b *elastic.AggregationBucketKeyItem, tags opentsdb.TagSet) error {
+		if !e.squelched(tags) {
+			add(b.Aggregations, b.DocCount, tags)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LSBaseQuery builds the base query that both LSCount and LSStat share
+func LSBaseQuery(now time.Time, index string, l ElasticHosts, keystring string, filter, sduration, eduration string, size int) (*LogstashRequest, error) {
+	cluster, indexRoot, err := l.Cluster(index)
+	if err != nil {
+		return nil, err
+	}
+	start, err := opentsdb.ParseDuration(sduration)
+	if err != nil {
+		return nil, err
+	}
+	var end opentsdb.Duration
+	if eduration != "" {
+		end, err = opentsdb.ParseDuration(eduration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	st := now.Add(time.Duration(-start))
+	en := now.Add(time.Duration(-end))
+	r := LogstashRequest{
+		Cluster:   cluster,
+		IndexRoot: indexRoot,
+		Start:     &st,
+		End:       &en,
+		Source:    elastic.NewSearchSource().Size(size),
+	}
+	var queries []elastic.Query
+	r.KeyMatches, queries, err = ProcessLSKeys(keystring, filter)
+	if err != nil {
+		return nil, err
+	}
+	// A bool query of queries, rather than a filtered query, is supported by
+	// all versions of elastic.
+	tr := elastic.NewRangeQuery(cluster.TimeField).Gte(st).Lte(en)
+	r.Source = r.Source.Query(elastic.NewBoolQuery().Must(append([]elastic.Query{tr}, queries...)...))
+	return &r, nil
+}
+
+// ProcessLSKeys returns the keys of keystring and the regexp queries of
+// keystring and filter.
+func ProcessLSKeys(keystring, filter string) ([]lsKeyMatch, []elastic.Query, error) {
+	var keys []lsKeyMatch
+	var queries []elastic.Query
+	for _, section := range strings.Split(keystring, ",") {
+		sp := strings.SplitN(section, ":", 2)
+		k := lsKeyMatch{Key: sp[0]}
+		if len(sp) == 2 {
+			k.RawPattern = sp[1]
+			var err error
+			k.Pattern, err = regexp.Compile(k.RawPattern)
+			if err != nil {
+				return nil, nil, err
+			}
+			queries = append(queries, elastic.NewRegexpQuery(k.Key, k.RawPattern))
+		}
+		keys = append(keys, k)
+	}
+	if filter != "" {
+		for _, section := range strings.Split(filter, ",") {
+			sp := strings.SplitN(section, ":", 2)
+			if len(sp) != 2 {
+				return nil, nil, fmt.Errorf("error parsing filter string")
+			}
+			queries = append(queries, elastic.NewRegexpQuery(sp[0], sp[1]))
+		}
+	}
+	return keys, queries, nil
+}
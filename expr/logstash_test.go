@@ -0,0 +1,98 @@
+package expr
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectIndices(t *testing.T) {
+	daily := NewElasticCluster(nil)
+	monthly := &ElasticCluster{IndexSeparator: "_", DateFormat: "2006-01"}
+	indices := []string{
+		"logstash-2015.06.22",
+		"logstash-2015.06.24",
+		"logstash-2015.06.23",
+		"logstash-2015.06.25",
+		"logstash-other",
+		"other-2015.06.24",
+		"access_2015-04",
+		"access_2015-05",
+		"access_2015-06",
+		"access_2015-07",
+	}
+	date := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		cluster    *ElasticCluster
+		root       string
+		start, end string
+		expect     []string
+	}{
+		{daily, "logstash", "2015-06-23 10:00", "2015-06-23 11:00", []string{"logstash-2015.06.23"}},
+		{daily, "logstash", "2015-06-23 23:00", "2015-06-24 01:00", []string{"logstash-2015.06.23", "logstash-2015.06.24"}},
+		// The last index covers anything after it.
+		{daily, "logstash", "2015-06-27 10:00", "2015-06-27 11:00", []string{"logstash-2015.06.25"}},
+		{daily, "logstash", "2015-06-01 10:00", "2015-06-01 11:00", nil},
+		{monthly, "access", "2015-05-20 10:00", "2015-05-20 11:00", []string{"access_2015-05"}},
+		{monthly, "access", "2015-05-31 23:00", "2015-06-01 01:00", []string{"access_2015-05", "access_2015-06"}},
+	}
+	for _, test := range tests {
+		got := test.cluster.selectIndices(indices, test.root, date(test.start), date(test.end))
+		if !reflect.DeepEqual(got, test.expect) {
+			t.Errorf("%s %s-%s: got %v, expected %v", test.root, test.start, test.end, got, test.expect)
+		}
+	}
+}
+
+func TestLSBaseQuery(t *testing.T) {
+	weblogs := &ElasticCluster{Hosts: []string{"http://es5:9200"}, TimeField: "timestamp", Version: 5}
+	hosts := ElasticHosts{
+		DefaultElasticCluster: NewElasticCluster([]string{"http://es1:9200"}),
+		"weblogs":             weblogs,
+	}
+	now := time.Date(2015, 6, 24, 12, 0, 0, 0, time.UTC)
+	r, err := LSBaseQuery(now, "weblogs:access", hosts, "vhost:a.*,status", "method:GET", "5m", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cluster != weblogs || r.IndexRoot != "access" {
+		t.Errorf("got cluster %v index %s, expected weblogs access", r.Cluster.Hosts, r.IndexRoot)
+	}
+	if len(r.KeyMatches) != 2 || r.KeyMatches[0].Key != "vhost" || r.KeyMatches[1].Pattern != nil {
+		t.Errorf("bad key matches: %v", r.KeyMatches)
+	}
+	b, err := json.Marshal(r.Source.Source())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := string(b)
+	for _, s := range []string{`"bool"`, `"timestamp"`, `"regexp":{"vhost":{"value":"a.*"}}`, `"regexp":{"method":{"value":"GET"}}`} {
+		if !strings.Contains(q, s) {
+			t.Errorf("expected %s in query %s", s, q)
+		}
+	}
+	if strings.Contains(q, "filtered") {
+		t.Errorf("unexpected filtered query, removed in elastic 5: %s", q)
+	}
+
+	// Unknown cluster names are part of the index on the default cluster.
+	r, err = LSBaseQuery(now, "other:logs", hosts, "", "", "5m", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cluster != hosts[DefaultElasticCluster] || r.IndexRoot != "other:logs" {
+		t.Errorf("got index %s, expected other:logs on the default cluster", r.IndexRoot)
+	}
+	delete(hosts, DefaultElasticCluster)
+	if _, err := LSBaseQuery(now, "logstash", hosts, "", "", "5m", "", 0); err == nil {
+		t.Error("expected error without a default cluster")
+	}
+}
@@ -0,0 +1,190 @@
+package parse
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Error is an error in an expression, with where it is and how it might be
+// fixed.
+type Error struct {
+	Msg string
+	// Pos is the byte offset of Token, the text the error is at, in the
+	// expression, and Line and Col its 1-based line and column.
+	Pos   Pos
+	Line  int
+	Col   int
+	Token string `json:",omitempty"`
+	// Hint suggests a fix, like "did you mean avg()?".
+	Hint string `json:",omitempty"`
+	// Err is the error of a function while the expression was evaluated.
+	// Its message is kept as is, since it may be shown in alert history.
+	Err error `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	s := fmt.Sprintf("expr: %d:%d: %s", e.Line, e.Col, e.Msg)
+	if e.Hint != "" {
+		s += "; " + e.Hint
+	}
+	return s
+}
+
+// nodeError returns an error at n.
+func nodeError(n Node, hint, format string, args ...interface{}) *Error {
+	return &Error{
+		Msg:   fmt.Sprintf(format, args...),
+		Pos:   n.Position(),
+		Token: nodeToken(n),
+		Hint:  hint,
+	}
+}
+
+// nodeToken returns the text identifying n in errors: the name of a
+// function, or the operator of a unary or binary node.
+func nodeToken(n Node) string {
+	switch n := n.(type) {
+	case *FuncNode:
+		return n.Name
+	case *BinaryNode:
+		return n.OpStr
+	case *UnaryNode:
+		return n.OpStr
+	}
+	return n.String()
+}
+
+// ErrorAt returns err, from evaluating n, as an Error at n with its line and
+// column in t. The Error keeps the message of err. An Error is returned as
+// is, only locating it if it isn't yet.
+func (t *Tree) ErrorAt(n Node, err error) *Error {
+	e, ok := err.(*Error)
+	if !ok {
+		e = &Error{
+			Msg:   strings.TrimPrefix(err.Error(), "expr: "),
+			Pos:   n.Position(),
+			Token: nodeToken(n),
+			Err:   err,
+		}
+	}
+	if e.Line == 0 {
+		t.locate(e)
+	}
+	return e
+}
+
+// checkError returns err, from checking n, as an Error at n whose message
+// has its position.
+func (t *Tree) checkError(n Node, err error) *Error {
+	e := t.ErrorAt(n, err)
+	e.Err = nil
+	return e
+}
+
+// locate sets the line and column of e from its position in t.
+func (t *Tree) locate(e *Error) {
+	pos := int(e.Pos)
+	if pos > len(t.Text) {
+		pos = len(t.Text)
+	}
+	before := t.Text[:pos]
+	e.Line = 1 + strings.Count(before, "\n")
+	e.Col = 1 + len(before) - (strings.LastIndex(before, "\n") + 1)
+}
+
+// usage returns the signature of function name, like avg(series).
+func usage(name string, f Func) string {
+	args := make([]string, len(f.Args))
+	for i, a := range f.Args {
+		args[i] = a.String()
+	}
+	return fmt.Sprintf("usage: %s(%s)", name, strings.Join(args, ", "))
+}
+
+// typeHint suggests how to pass an argument of type got where want is
+// expected.
+func typeHint(want, got FuncType) string {
+	switch {
+	case got == TypeSeriesSet && (want == TypeNumberSet || want == TypeScalar):
+		return "reduce the series to a number with a function like avg() or last()"
+	case want == TypeSeriesSet:
+		return "pass a query like q() that returns a series"
+	case want == TypeString:
+		return `strings must be quoted, like "5m"`
+	}
+	return ""
+}
+
+// funcAliases are names of functions in other languages, and their names
+// here.
+var funcAliases = map[string]string{
+	"average": "avg",
+	"mean":    "avg",
+	"stddev":  "dev",
+	"std":     "dev",
+	"maximum": "max",
+	"minimum": "min",
+	"query":   "q",
+}
+
+// suggestFunction returns the name of a known function name may have been
+// meant as, or "" if none is close.
+func (t *Tree) suggestFunction(name string) string {
+	if alias, ok := funcAliases[strings.ToLower(name)]; ok {
+		if _, ok := t.GetFunction(alias); ok {
+			return alias
+		}
+	}
+	var names []string
+	for _, funcMap := range t.funcs {
+		for n := range funcMap {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	best, bestDist := "", len(name)/3+1
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(n)); d <= bestDist && (best == "" || d < bestDist) {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -102,11 +102,10 @@ func (f *FuncNode) StringAST() string {
 }
 
 func (f *FuncNode) Check(t *Tree) error {
-	const errFuncType = "parse: bad argument type in %s, expected %s, got %s"
 	if len(f.Args) < len(f.F.Args) {
-		return fmt.Errorf("parse: not enough arguments for %s", f.Name)
+		return nodeError(f, usage(f.Name, f.F), "not enough arguments for %s", f.Name)
 	} else if len(f.Args) > len(f.F.Args) {
-		return fmt.Errorf("parse: too many arguments for %s", f.Name)
+		return nodeError(f, usage(f.Name, f.F), "too many arguments for %s", f.Name)
 	}
 	for i, a := range f.Args {
 		ft := f.F.Args[i]
@@ -114,14 +113,16 @@ func (f *FuncNode) Check(t *Tree) error {
 		if ft == TypeNumberSet && at == TypeScalar {
 			// Scalars are promoted to NumberSets during execution.
 		} else if ft != at {
-			return fmt.Errorf("parse: expected %v, got %v", ft, at)
+			return nodeError(a, typeHint(ft, at), "argument %d of %s: expected %v, got %v", i+1, f.Name, ft, at)
 		}
 		if err := a.Check(t); err != nil {
 			return err
 		}
 	}
 	if f.F.Check != nil {
-		return f.F.Check(t, f)
+		if err := f.F.Check(t, f); err != nil {
+			return t.checkError(f, err)
+		}
 	}
 	return nil
 }
@@ -257,14 +258,14 @@ func (b *BinaryNode) Check(t *Tree) error {
 	t1 := b.Args[0].Return()
 	t2 := b.Args[1].Return()
 	if t1 == TypeSeriesSet && t2 == TypeSeriesSet {
-		return fmt.Errorf("parse: type error in %s: at least one side must be a number", b)
+		return nodeError(b, typeHint(TypeNumberSet, TypeSeriesSet), "type error in %s: at least one side must be a number", b)
 	}
 	check := t1
 	if t1 == TypeSeriesSet {
 		check = t2
 	}
 	if check != TypeNumberSet && check != TypeScalar {
-		return fmt.Errorf("parse: type error in %s: expected a number", b)
+		return nodeError(b, typeHint(TypeNumberSet, check), "type error in %s: expected a number", b)
 	}
 	if err := b.Args[0].Check(t); err != nil {
 		return err
@@ -274,14 +275,14 @@ func (b *BinaryNode) Check(t *Tree) error {
 	}
 	g1, err := b.Args[0].Tags()
 	if err != nil {
-		return err
+		return t.checkError(b.Args[0], err)
 	}
 	g2, err := b.Args[1].Tags()
 	if err != nil {
-		return err
+		return t.checkError(b.Args[1], err)
 	}
 	if g1 != nil && g2 != nil && !g1.Subset(g2) && !g2.Subset(g1) {
-		return fmt.Errorf("parse: incompatible tags (%v and %v) in %s", g1, g2, b)
+		return nodeError(b, "the tag keys of one side must be a subset of the other's", "incompatible tags (%v and %v) in %s", g1, g2, b)
 	}
 	return nil
 }
@@ -332,7 +333,7 @@ func (u *UnaryNode) Check(t *Tree) error {
 	case TypeNumberSet, TypeSeriesSet, TypeScalar:
 		return u.Arg.Check(t)
 	default:
-		return fmt.Errorf("parse: type error in %s, expected %s, got %s", u, "number", rt)
+		return nodeError(u, typeHint(TypeNumberSet, rt), "type error in %s, expected %s, got %s", u, "number", rt)
 	}
 }
 
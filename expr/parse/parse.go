@@ -5,7 +5,7 @@
 // Package parse builds parse trees for expressions as defined by expr. Clients
 // should use that package to construct expressions rather than this one, which
 // provides shared internal data structures not intended for general use.
-package parse // import "bosun.org/cmd/bosun/expr/parse"
+package parse // import "bosun.org/expr/parse"
 
 import (
 	"fmt"
@@ -160,6 +160,19 @@ func (t *Tree) error(err error) {
 	t.errorf("%s", err)
 }
 
+// errorAt terminates processing with an Error at token.
+func (t *Tree) errorAt(token item, hint, format string, args ...interface{}) {
+	t.Root = nil
+	e := &Error{
+		Msg:   fmt.Sprintf(format, args...),
+		Pos:   token.pos,
+		Token: token.val,
+		Hint:  hint,
+	}
+	t.locate(e)
+	panic(e)
+}
+
 // expect consumes the next token and guarantees it has the required type.
 func (t *Tree) expect(expected itemType, context string) item {
 	token := t.next()
@@ -180,7 +193,16 @@ func (t *Tree) expectOneOf(expected1, expected2 itemType, context string) item {
 
 // unexpected complains about the token and terminates processing.
 func (t *Tree) unexpected(token item, context string) {
-	t.errorf("unexpected %s in %s", token, context)
+	var hint string
+	switch token.typ {
+	case itemEOF:
+		hint = "is a ) or an operand missing?"
+	case itemRightParen:
+		hint = "is there an extra )?"
+	case itemString, itemTripleQuotedString:
+		hint = "strings may only be function arguments"
+	}
+	t.errorAt(token, hint, "unexpected %s in %s", token, context)
 }
 
 // recover is the handler that turns panics into returns from the top level of Parse.
@@ -241,7 +263,9 @@ func (t *Tree) parse() {
 	t.Root = t.O()
 	t.expect(itemEOF, "input")
 	if err := t.Root.Check(t); err != nil {
-		t.error(err)
+		e := t.checkError(t.Root, err)
+		t.Root = nil
+		panic(e)
 	}
 }
 
@@ -340,7 +364,7 @@ func (t *Tree) v() Node {
 	case itemNumber:
 		n, err := newNumber(token.pos, token.val)
 		if err != nil {
-			t.error(err)
+			t.errorAt(token, "", "%s", err)
 		}
 		return n
 	case itemFunc:
@@ -356,7 +380,11 @@ func (t *Tree) Func() (f *FuncNode) {
 	token := t.next()
 	funcv, ok := t.GetFunction(token.val)
 	if !ok {
-		t.errorf("non existent function %s", token.val)
+		var hint string
+		if name := t.suggestFunction(token.val); name != "" {
+			hint = fmt.Sprintf("did you mean %s()?", name)
+		}
+		t.errorAt(token, hint, "non existent function %s", token.val)
 	}
 	f = newFunc(token.pos, token.val, funcv)
 	t.expect(itemLeftParen, "func")
@@ -370,7 +398,7 @@ func (t *Tree) Func() (f *FuncNode) {
 		case itemString:
 			s, err := strconv.Unquote(token.val)
 			if err != nil {
-				t.errorf("Unquoting error: %s", err)
+				t.errorAt(token, "", "Unquoting error: %s", err)
 			}
 			f.append(newString(token.pos, token.val, s))
 		case itemRightParen:
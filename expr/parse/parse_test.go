@@ -7,6 +7,7 @@ package parse
 import (
 	"flag"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -178,3 +179,31 @@ var builtins = map[string]Func{
 		nil,
 	},
 }
+
+func TestParseErrors(t *testing.T) {
+	for _, test := range []struct {
+		input     string
+		line, col int
+		token     string
+		err       string
+	}{
+		{"avg(", 1, 5, "", `expr: 1:5: unexpected EOF in input; is a ) or an operand missing?`},
+		{`mean(q("q", "1m"))`, 1, 1, "mean", `expr: 1:1: non existent function mean; did you mean avg()?`},
+		{`1 +\nforcastlr(q("q", "1m"), 1)`, 2, 1, "forcastlr", `expr: 2:1: non existent function forcastlr; did you mean forecastlr()?`},
+		{`nope(1)`, 1, 1, "nope", `expr: 1:1: non existent function nope`},
+		{`avg(q("q", "1m"), 1)`, 1, 1, "avg", `expr: 1:1: too many arguments for avg; usage: avg(series)`},
+		{`1 + forecastlr(avg(q("q", "1m")), 1)`, 1, 16, "avg", `expr: 1:16: argument 1 of forecastlr: expected series, got number; pass a query like q() that returns a series`},
+		{`q("q", "1m") + q("q", "1m")`, 1, 14, "+", `expr: 1:14: type error in q("q", "1m") + q("q", "1m"): at least one side must be a number; reduce the series to a number with a function like avg() or last()`},
+	} {
+		test.input = strings.Replace(test.input, `\n`, "\n", -1)
+		err := New().Parse(test.input, builtins)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s: expected an Error, got %v", test.input, err)
+			continue
+		}
+		if e.Line != test.line || e.Col != test.col || e.Token != test.token || e.Error() != test.err {
+			t.Errorf("%s: got %d:%d %q %s, expected %d:%d %q %s", test.input, e.Line, e.Col, e.Token, e, test.line, test.col, test.token, test.err)
+		}
+	}
+}
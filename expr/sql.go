@@ -0,0 +1,425 @@
+package expr
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"bosun.org/_third_party/github.com/MiniProfiler/go/miniprofiler"
+	_ "bosun.org/_third_party/github.com/go-sql-driver/mysql"
+	_ "bosun.org/_third_party/github.com/lib/pq"
+	"bosun.org/expr/parse"
+	"bosun.org/opentsdb"
+)
+
+// SQL is a map of functions to query SQL databases.
+var SQL = map[string]parse.Func{
+	"sql": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeNumberSet,
+		Tags:   sqlTag,
+		F:      SQLQuery,
+	},
+	"sqlSeries": {
+		Args:   []parse.FuncType{parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString, parse.TypeString},
+		Return: parse.TypeSeriesSet,
+		Tags:   sqlTag,
+		F:      SQLSeries,
+	},
+}
+
+// DefaultSQLTimeout is the Timeout of SQL databases that do not specify one.
+const DefaultSQLTimeout = time.Second * 30
+
+// sqlMaxConns is the most connections opened to each SQL database.
+const sqlMaxConns = 4
+
+// sqlTxSetup are the statements run at the start of each query's
+// transaction, by driver, for databases that can make a transaction
+// read-only and bound its statements. %d is the timeout in milliseconds.
+var sqlTxSetup = map[string][]string{
+	"postgres": {
+		"SET TRANSACTION READ ONLY",
+		"SET LOCAL statement_timeout = %d",
+	},
+}
+
+// SQLDatabase is a database queried by the sql function. Driver must be
+// registered with database/sql; the mysql and postgres drivers are built in.
+type SQLDatabase struct {
+	Driver  string
+	DSN     string `json:"-"` // may contain a password
+	Timeout time.Duration
+}
+
+// SQLDatabases are the SQL databases by name.
+type SQLDatabases map[string]*SQLDatabase
+
+var (
+	sqlLock sync.Mutex
+	sqlDBs  = make(map[string]*sql.DB)
+)
+
+// db returns the connection pool of d, opening it if needed.
+func (d *SQLDatabase) db() (*sql.DB, error) {
+	sqlLock.Lock()
+	defer sqlLock.Unlock()
+	key := d.Driver + "|" + d.DSN
+	if db := sqlDBs[key]; db != nil {
+		return db, nil
+	}
+	db, err := sql.Open(d.Driver, d.DSN)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(sqlMaxConns)
+	sqlDBs[key] = db
+	return db, nil
+}
+
+// Ping checks that a connection to d can be made.
+func (d *SQLDatabase) Ping() error {
+	db, err := d.db()
+	if err != nil {
+		return err
+	}
+	return d.wait(func() error {
+		return db.Ping()
+	})
+}
+
+func (d *SQLDatabase) timeout() time.Duration {
+	if d.Timeout == 0 {
+		return DefaultSQLTimeout
+	}
+	return d.Timeout
+}
+
+// wait runs f, returning an error if it does not finish within the timeout
+// of d. f keeps running in the background after a timeout until the
+// database or the connection's own timeouts end it.
+func (d *SQLDatabase) wait(f func() error) error {
+	timeout := d.timeout()
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("sql: timed out after %v", timeout)
+	}
+}
+
+func sqlTag(args []parse.Node) (parse.Tags, error) {
+	t := make(parse.Tags)
+	for _, c := range sqlTagColumns(args[2].(*parse.StringNode).Text) {
+		t[c] = struct{}{}
+	}
+	return t, nil
+}
+
+func sqlTagColumns(s string) []string {
+	var columns []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// sqlReadOnly returns an error unless query is a SELECT, optionally with a
+// WITH clause. It catches mistakes, not malice: a SELECT can still call
+// functions with side effects, so the database user must be read-only.
+func sqlReadOnly(query string) error {
+	fields := strings.Fields(query)
+	if len(fields) > 0 {
+		switch strings.ToLower(fields[0]) {
+		case "select", "with":
+			return nil
+		}
+	}
+	return fmt.Errorf("sql: only SELECT queries are allowed")
+}
+
+// SQLQuery runs query on the database named db. The tagColumns, a
+// comma-separated list, become the tags of each row, and the one remaining
+// column its value. If startDuration is not empty, the query is given the
+// start and end of the time range as its two parameters.
+func SQLQuery(e *State, T miniprofiler.Timer, db, query, tagColumns, startDuration, endDuration string) (*Results, error) {
+	rows, err := e.sqlRows(T, db, query, tagColumns, "", startDuration, endDuration)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Results)
+	for _, row := range rows {
+		if e.squelched(row.tags) {
+			continue
+		}
+		r.Results = append(r.Results, &Result{
+			Value: Number(row.value),
+			Group: row.tags,
+		})
+	}
+	return r, nil
+}
+
+// SQLSeries runs query on the database named db, as SQLQuery, and returns a
+// series for each set of tags, with a point at the time in timeColumn of
+// each of its rows.
+func SQLSeries(e *State, T miniprofiler.Timer, db, query, tagColumns, timeColumn, startDuration, endDuration string) (*Results, error) {
+	if timeColumn == "" {
+		return nil, fmt.Errorf("sql: timeColumn is required")
+	}
+	rows, err := e.sqlRows(T, db, query, tagColumns, timeColumn, startDuration, endDuration)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Results)
+	series := make(map[string]Series)
+	for _, row := range rows {
+		if e.squelched(row.tags) {
+			continue
+		}
+		key := row.tags.String()
+		if series[key] == nil {
+			series[key] = make(Series)
+			r.Results = append(r.Results, &Result{
+				Value: series[key],
+				Group: row.tags,
+			})
+		}
+		series[key][row.time] = row.value
+	}
+	return r, nil
+}
+
+// sqlRows runs query on the database named db, caching its rows for the
+// check.
+func (e *State) sqlRows(T miniprofiler.Timer, db, query, tagColumns, timeColumn, startDuration, endDuration string) ([]*sqlRow, error) {
+	if err := sqlReadOnly(query); err != nil {
+		return nil, err
+	}
+	d := e.sqlDatabases[db]
+	if d == nil {
+		return nil, fmt.Errorf("sql: unknown database %s", db)
+	}
+	var args []interface{}
+	if startDuration != "" {
+		sd, err := opentsdb.ParseDuration(startDuration)
+		if err != nil {
+			return nil, err
+		}
+		var ed opentsdb.Duration
+		if endDuration != "" {
+			ed, err = opentsdb.ParseDuration(endDuration)
+			if err != nil {
+				return nil, err
+			}
+		}
+		args = []interface{}{e.now.Add(time.Duration(-sd)).UTC(), e.now.Add(time.Duration(-ed)).UTC()}
+	}
+	tags := sqlTagColumns(tagColumns)
+	var rows []*sqlRow
+	var err error
+	key := fmt.Sprintf("sql|%s|%s|%s|%s|%v", db, query, tagColumns, timeColumn, args)
+	T.StepCustomTiming("sql", "query", query, func() {
+		var val interface{}
+		val, err = e.cacheGet(key, func() (interface{}, error) {
+			return d.query(query, tags, timeColumn, args)
+		})
+		if err == nil {
+			rows = val.([]*sqlRow)
+		}
+	})
+	return rows, err
+}
+
+type sqlRow struct {
+	tags  opentsdb.TagSet
+	time  time.Time
+	value float64
+}
+
+// query runs query in a transaction and returns its rows. If timeColumn is
+// not empty, each row has the time in that column. Rows with a NULL value are
+// skipped.
+func (d *SQLDatabase) query(query string, tags []string, timeColumn string, args []interface{}) ([]*sqlRow, error) {
+	db, err := d.db()
+	if err != nil {
+		return nil, err
+	}
+	var rows []*sqlRow
+	err = d.wait(func() error {
+		var err error
+		rows, err = d.queryTx(db, query, tags, timeColumn, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (d *SQLDatabase) queryTx(db *sql.DB, query string, tags []string, timeColumn string, args []interface{}) ([]*sqlRow, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for _, stmt := range sqlTxSetup[d.Driver] {
+		if strings.Contains(stmt, "%d") {
+			stmt = fmt.Sprintf(stmt, d.timeout()/time.Millisecond)
+		}
+		if _, err := tx.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+	rs, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+	columns, err := rs.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if timeColumn == "" && len(columns) != len(tags)+1 {
+		return nil, fmt.Errorf("sql: expected %d tag columns and one value column, got %d columns", len(tags), len(columns))
+	}
+	if timeColumn != "" && len(columns) != len(tags)+2 {
+		return nil, fmt.Errorf("sql: expected %d tag columns, a time column, and one value column, got %d columns", len(tags), len(columns))
+	}
+	isTag := make(map[string]bool)
+	for _, t := range tags {
+		isTag[t] = true
+	}
+	valueColumn, timeIndex := -1, -1
+	for i, c := range columns {
+		switch {
+		case timeColumn != "" && c == timeColumn:
+			timeIndex = i
+		case !isTag[c]:
+			valueColumn = i
+		}
+	}
+	if valueColumn < 0 {
+		return nil, fmt.Errorf("sql: no value column")
+	}
+	if timeColumn != "" && timeIndex < 0 {
+		return nil, fmt.Errorf("sql: no time column %s", timeColumn)
+	}
+	var rows []*sqlRow
+	seen := make(map[string]bool)
+	for rs.Next() {
+		values := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rs.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		if values[valueColumn] == nil {
+			continue
+		}
+		row := &sqlRow{tags: make(opentsdb.TagSet)}
+		row.value, err = sqlFloat(values[valueColumn])
+		if err != nil {
+			return nil, fmt.Errorf("sql: column %s: %v", columns[valueColumn], err)
+		}
+		if timeIndex >= 0 {
+			row.time, err = sqlTime(values[timeIndex])
+			if err != nil {
+				return nil, fmt.Errorf("sql: column %s: %v", timeColumn, err)
+			}
+		}
+		for i, c := range columns {
+			if i == valueColumn || i == timeIndex {
+				continue
+			}
+			v, err := opentsdb.Clean(sqlString(values[i]))
+			if err != nil || v == "" {
+				return nil, fmt.Errorf("sql: bad value %q for tag %s", sqlString(values[i]), c)
+			}
+			row.tags[c] = v
+		}
+		id := row.tags.String()
+		if timeIndex >= 0 {
+			id += " at " + row.time.Format(time.RFC3339)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("sql: duplicate row for %s", id)
+		}
+		seen[id] = true
+		rows = append(rows, row)
+	}
+	return rows, rs.Err()
+}
+
+func sqlString(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func sqlFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case []byte:
+		return strconv.ParseFloat(string(v), 64)
+	case string:
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, fmt.Errorf("unsupported value type %T", v)
+}
+
+// sqlTimeLayouts are the layouts of times returned as text, as by MySQL
+// without parseTime.
+var sqlTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// sqlTime returns v as a time: a time value, seconds since the epoch, or text
+// in one of sqlTimeLayouts, taken as UTC.
+func sqlTime(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), nil
+	case int64:
+		return time.Unix(v, 0).UTC(), nil
+	case float64:
+		return time.Unix(int64(v), 0).UTC(), nil
+	case []byte:
+		return sqlTime(string(v))
+	case string:
+		for _, layout := range sqlTimeLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return t.UTC(), nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("bad time %q", v)
+	}
+	return time.Time{}, fmt.Errorf("unsupported time type %T", v)
+}
@@ -0,0 +1,201 @@
+package expr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"bosun.org/_third_party/github.com/influxdb/influxdb/client"
+	"bosun.org/expr/parse"
+)
+
+// sqlTestDriver answers every query with its columns and rows, and records
+// the arguments and statements it was given.
+type sqlTestDriver struct {
+	columns []string
+	rows    [][]driver.Value
+	args    []driver.Value
+	execs   []string
+}
+
+func (d *sqlTestDriver) Open(name string) (driver.Conn, error) { return &sqlTestConn{d}, nil }
+
+type sqlTestConn struct{ d *sqlTestDriver }
+
+func (c *sqlTestConn) Prepare(query string) (driver.Stmt, error) {
+	return &sqlTestStmt{c.d, query}, nil
+}
+func (c *sqlTestConn) Close() error              { return nil }
+func (c *sqlTestConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *sqlTestConn) Commit() error             { return nil }
+func (c *sqlTestConn) Rollback() error           { return nil }
+
+type sqlTestStmt struct {
+	d     *sqlTestDriver
+	query string
+}
+
+func (s *sqlTestStmt) Close() error  { return nil }
+func (s *sqlTestStmt) NumInput() int { return -1 }
+func (s *sqlTestStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if !strings.HasPrefix(s.query, "SET ") {
+		return nil, fmt.Errorf("read only")
+	}
+	s.d.execs = append(s.d.execs, s.query)
+	return driver.RowsAffected(0), nil
+}
+
+func (s *sqlTestStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.args = args
+	return &sqlTestRows{d: s.d}, nil
+}
+
+type sqlTestRows struct {
+	d *sqlTestDriver
+	i int
+}
+
+func (r *sqlTestRows) Columns() []string { return r.d.columns }
+func (r *sqlTestRows) Close() error      { return nil }
+
+func (r *sqlTestRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.d.rows) {
+		return io.EOF
+	}
+	copy(dest, r.d.rows[r.i])
+	r.i++
+	return nil
+}
+
+var sqlTest = &sqlTestDriver{}
+
+func init() {
+	sql.Register("bosuntest", sqlTest)
+}
+
+func TestSQLQuery(t *testing.T) {
+	sqlTest.columns = []string{"region", "shop", "orders"}
+	sqlTest.rows = [][]driver.Value{
+		{"us", []byte("main"), int64(12)},
+		{"eu", "main", []byte("3.5")},
+		{"ap", "main", nil},
+	}
+	sqlTest.execs = nil
+	sqlTxSetup["bosuntest"] = sqlTxSetup["postgres"]
+	defer delete(sqlTxSetup, "bosuntest")
+	dbs := SQLDatabases{"shop": {Driver: "bosuntest", DSN: "shop"}}
+	now := time.Date(2016, 1, 1, 0, 10, 0, 0, time.UTC)
+	e, err := New(`sql("shop", "select region, shop, count(*) as orders from orders where created between ? and ? group by region, shop", "region,shop", "10m", "")`, SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := e.Execute(nil, nil, nil, client.Config{}, dbs, nil, nil, nil, now, 0, false, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]float64{
+		"{region=us,shop=main}": 12,
+		"{region=eu,shop=main}": 3.5,
+	}
+	got := make(map[string]float64)
+	for _, res := range r.Results {
+		got[res.Group.String()] = float64(res.Value.(Number))
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	execs := []string{"SET TRANSACTION READ ONLY", "SET LOCAL statement_timeout = 30000"}
+	if !reflect.DeepEqual(sqlTest.execs, execs) {
+		t.Errorf("got statements %q, expected %q", sqlTest.execs, execs)
+	}
+	start, end := now.Add(-10*time.Minute), now
+	if !reflect.DeepEqual(sqlTest.args, []driver.Value{start, end}) {
+		t.Errorf("got args %v, expected %v and %v", sqlTest.args, start, end)
+	}
+	tags, err := e.Root.Tags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 {
+		t.Errorf("expected tags region and shop, got %v", tags)
+	}
+}
+
+func TestSQLSeries(t *testing.T) {
+	sqlTest.columns = []string{"queue", "at", "depth"}
+	sqlTest.rows = [][]driver.Value{
+		{"billing", time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC), int64(3)},
+		{"billing", []byte("2016-01-01 00:05:00"), int64(5)},
+		{"mail", int64(1451606400), 1.5},
+		{"mail", "2016-01-01T00:05:00Z", nil},
+	}
+	dbs := SQLDatabases{"shop": {Driver: "bosuntest", DSN: "shop"}}
+	now := time.Date(2016, 1, 1, 0, 10, 0, 0, time.UTC)
+	e, err := New(`sqlSeries("shop", "select queue, at, depth from queue_depths where at between ? and ?", "queue", "at", "10m", "")`, SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := e.Execute(nil, nil, nil, client.Config{}, dbs, nil, nil, nil, now, 0, false, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	expected := map[string]Series{
+		"{queue=billing}": {start: 3, start.Add(5 * time.Minute): 5},
+		"{queue=mail}":    {start: 1.5},
+	}
+	got := make(map[string]Series)
+	for _, res := range r.Results {
+		got[res.Group.String()] = res.Value.(Series)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+
+	sqlTest.rows = [][]driver.Value{{"billing", "yesterday", int64(3)}}
+	e, err = New(`sqlSeries("shop", "select queue, at, depth from queue_depths", "queue", "at", "", "")`, SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = e.Execute(nil, nil, nil, client.Config{}, dbs, nil, nil, nil, now, 0, false, nil, nil, nil, nil)
+	if err == nil || err.Error() != `sql: column at: bad time "yesterday"` {
+		t.Errorf("got error %v, expected a bad time", err)
+	}
+}
+
+func TestSQLQueryErrors(t *testing.T) {
+	sqlTest.columns = []string{"region", "orders"}
+	sqlTest.rows = [][]driver.Value{
+		{"us", int64(1)},
+		{"us", int64(2)},
+	}
+	dbs := SQLDatabases{"shop": {Driver: "bosuntest", DSN: "shop"}}
+	for _, test := range []struct {
+		expr, err string
+	}{
+		{`sql("shop", "delete from orders", "", "", "")`, "sql: only SELECT queries are allowed"},
+		{`sql("other", "select 1", "", "", "")`, "sql: unknown database other"},
+		{`sql("shop", "select region, orders from orders", "region", "", "")`, "sql: duplicate row for {region=us}"},
+		{`sql("shop", "select region, orders from orders", "", "", "")`, "sql: expected 0 tag columns and one value column, got 2 columns"},
+	} {
+		e, err := New(test.expr, SQL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = e.Execute(nil, nil, nil, client.Config{}, dbs, nil, nil, nil, time.Now(), 0, false, nil, nil, nil, nil)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: got error %v, expected %s", test.expr, err, test.err)
+		}
+		if pe, ok := err.(*parse.Error); !ok || pe.Token != "sql" || pe.Line != 1 || pe.Col != 1 {
+			t.Errorf("%s: expected an error at sql, got %#v", test.expr, err)
+		}
+	}
+	if _, err := sqlFloat("x"); err == nil {
+		t.Error("expected error for a non-numeric value")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
 	"sync"
 	"time"
 
@@ -51,6 +52,7 @@ const (
 	Context              = "contexts"
 	ContextSwitch        = "context switches"
 	Count                = ""
+	Day                  = "days"
 	Document             = "documents"
 	Entropy              = "entropy"
 	Error                = "errors"
@@ -134,8 +136,18 @@ var (
 	metadata  = make(map[Metakey]interface{})
 	metalock  sync.Mutex
 	metahost  string
+	metaput   func([]Metasend) time.Duration
 	metafuncs []func()
 	metadebug bool
+	// changed is metadata changed since the last send. It is sent every
+	// changedInterval, so agents don't make a request per change.
+	changed = make(map[Metakey]interface{})
+)
+
+const (
+	changedInterval = time.Second * 10
+	// sendBatchSize is the most metadata entries sent in one request.
+	sendBatchSize = 500
 )
 
 // AddMeta adds a metadata entry to memory, which is queued for later sending.
@@ -156,12 +168,7 @@ func AddMeta(metric string, tags opentsdb.TagSet, name string, value interface{}
 	prev, present := metadata[Metakey{metric, ts, name}]
 	if present && !reflect.DeepEqual(prev, value) {
 		slog.Infof("metadata changed for %s/%s/%s: %v to %v", metric, ts, name, prev, value)
-		go sendMetadata([]Metasend{{
-			Metric: metric,
-			Tags:   tags,
-			Name:   name,
-			Value:  value,
-		}})
+		changed[Metakey{metric, ts, name}] = value
 	} else if metadebug {
 		slog.Infof("AddMeta for %s/%s/%s: %v", metric, ts, name, value)
 	}
@@ -177,16 +184,58 @@ func AddMetricMeta(metric string, rate RateType, unit Unit, desc string) {
 	AddMeta(metric, nil, "desc", desc, false)
 }
 
-// Init initializes the metadata send queue.
+// Init initializes the metadata send queue, which posts to the bosun at u.
 func Init(u *url.URL, debug bool) error {
 	mh, err := u.Parse("/api/metadata/put")
 	if err != nil {
 		return err
 	}
 	metahost = mh.String()
+	start(postMetadata, debug)
+	return nil
+}
+
+// InitFunc initializes the metadata send queue, which sends with put. Bosun
+// uses it to store its own metadata without an HTTP request.
+func InitFunc(put func([]Metasend) error, debug bool) {
+	start(func(ms []Metasend) time.Duration {
+		if err := put(ms); err != nil {
+			slog.Error(err)
+		}
+		return 0
+	}, debug)
+}
+
+func start(put func([]Metasend) time.Duration, debug bool) {
+	metaput = put
 	metadebug = debug
 	go collectMetadata()
-	return nil
+	go sendChanged()
+}
+
+func sendChanged() {
+	for range time.Tick(changedInterval) {
+		metalock.Lock()
+		ms := metasends(changed)
+		changed = make(map[Metakey]interface{})
+		metalock.Unlock()
+		if len(ms) > 0 {
+			sendMetadata(ms)
+		}
+	}
+}
+
+func metasends(m map[Metakey]interface{}) []Metasend {
+	ms := make([]Metasend, 0, len(m))
+	for k, v := range m {
+		ms = append(ms, Metasend{
+			Metric: k.Metric,
+			Tags:   k.TagSet(),
+			Name:   k.Name,
+			Value:  v,
+		})
+	}
+	return ms
 }
 
 func collectMetadata() {
@@ -202,17 +251,7 @@ func collectMetadata() {
 			metalock.Unlock()
 			continue
 		}
-		ms := make([]Metasend, len(metadata))
-		i := 0
-		for k, v := range metadata {
-			ms[i] = Metasend{
-				Metric: k.Metric,
-				Tags:   k.TagSet(),
-				Name:   k.Name,
-				Value:  v,
-			}
-			i++
-		}
+		ms := metasends(metadata)
 		metalock.Unlock()
 		sendMetadata(ms)
 		time.Sleep(time.Hour)
@@ -228,19 +267,46 @@ type Metasend struct {
 	Time   *time.Time `json:",omitempty"`
 }
 
+// sendMetadata sends ms in batches of sendBatchSize. Batches bosun refuses
+// with 429 Too Many Requests are retried after its Retry-After.
 func sendMetadata(ms []Metasend) {
+	for len(ms) > 0 {
+		n := len(ms)
+		if n > sendBatchSize {
+			n = sendBatchSize
+		}
+		if wait := metaput(ms[:n]); wait > 0 {
+			slog.Infof("metadata put throttled, retrying in %v", wait)
+			time.Sleep(wait)
+			continue
+		}
+		ms = ms[n:]
+	}
+}
+
+// postMetadata sends ms, and returns how long to wait before retrying if
+// bosun throttled the request.
+func postMetadata(ms []Metasend) time.Duration {
 	b, err := json.Marshal(&ms)
 	if err != nil {
 		slog.Error(err)
-		return
+		return 0
 	}
 	resp, err := http.Post(metahost, "application/json", bytes.NewBuffer(b))
 	if err != nil {
 		slog.Error(err)
-		return
+		return 0
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 429 {
+		secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || secs < 1 {
+			secs = 60
+		}
+		return time.Duration(secs) * time.Second
 	}
 	if resp.StatusCode != 204 {
 		slog.Errorln("bad metadata return:", resp.Status)
-		return
 	}
+	return 0
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// CycleSummary is a summary of the alert keys at the end of a check cycle,
+// and of how their statuses changed during it.
+type CycleSummary struct {
+	Time time.Time
+	// Normal, Warning, Critical, and Unknown count the alert keys by their
+	// current status.
+	Normal   int
+	Warning  int
+	Critical int
+	Unknown  int
+	// NewCritical and NewUnknown count the alert keys that became critical
+	// or unknown during the cycle, and Recovered those that became normal
+	// from an abnormal status.
+	NewCritical int
+	NewUnknown  int
+	Recovered   int
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+
+	"bosun.org/opentsdb"
+)
+
+// Event is something that happened, like a deploy or a failed backup, pushed
+// to bosun by an external system so alerts can be written on it.
+type Event struct {
+	Id      int64
+	Type    string
+	Time    time.Time
+	Tags    opentsdb.TagSet `json:",omitempty"`
+	Message string          `json:",omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// AlertNote is a note attached to an alert definition at runtime, such as
+// "known issue, fix rolling out Thursday".
+type AlertNote struct {
+	Text string
+	User string
+	Time time.Time
+}
+
+// IncidentNote records investigation context on an incident. Body is
+// markdown.
+type IncidentNote struct {
+	Author string
+	Time   time.Time
+	Body   string
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"time"
+)
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending DeliveryStatus = "pending"
+	DeliverySent    DeliveryStatus = "sent"
+	DeliveryFailed  DeliveryStatus = "failed"
+	// DeliverySuppressed deliveries were dropped by a notification's quiet
+	// hours or rate limit.
+	DeliverySuppressed DeliveryStatus = "suppressed"
+	// DeliveryDryRun deliveries were recorded but not sent because of dry
+	// run mode.
+	DeliveryDryRun DeliveryStatus = "dryrun"
+)
+
+// NotificationDelivery records a single outgoing notification and the
+// outcome of each attempt to send it.
+type NotificationDelivery struct {
+	Id           int64
+	Notification string
+	AlertKey     string
+	Status       DeliveryStatus
+	Attempts     int
+	LastError    string `json:",omitempty"`
+	Created      time.Time
+	LastAttempt  time.Time
+	NextAttempt  time.Time
+	// AttemptLog records each attempt to send the notification, oldest
+	// first.
+	AttemptLog []DeliveryAttempt `json:",omitempty"`
+
+	// Key identifies the notification to receivers, which can use it to
+	// drop duplicates; see the X-Bosun-Notification-Key header.
+	Key string `json:",omitempty"`
+	// IncidentId is the incident the notification is about, if any.
+	IncidentId uint64 `json:",omitempty"`
+
+	Subject      string
+	Body         string
+	EmailSubject []byte
+	EmailBody    []byte
+	// PostBody is the rendered bodyTemplate of the notification, if any.
+	PostBody []byte `json:",omitempty"`
+	// Attachments are sent with the email on each attempt.
+	Attachments []DeliveryAttachment `json:",omitempty"`
+
+	// Pending are the destinations of the notification, like email or
+	// post, not yet sent. Retries send only these.
+	Pending []string `json:",omitempty"`
+}
+
+// DeliveryAttachment is a file attached to a notification's email.
+type DeliveryAttachment struct {
+	Data        []byte
+	Filename    string
+	ContentType string
+}
+
+// DeliveryAttempt is the outcome of one attempt to send a notification.
+type DeliveryAttempt struct {
+	Time     time.Time
+	Duration time.Duration
+	Error    string `json:",omitempty"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// ExprProfile is how long the expressions of an alert took in a check.
+type ExprProfile struct {
+	Alert    string
+	Time     time.Time
+	Duration time.Duration
+	Steps    []*ExprStep
+}
+
+// ExprStep is a step of evaluating an expression, such as a function, a
+// reduction, or a datasource query. Command is the query of datasource
+// steps.
+type ExprStep struct {
+	Name     string
+	Command  string `json:",omitempty"`
+	Duration time.Duration
+	Steps    []*ExprStep `json:",omitempty"`
+}
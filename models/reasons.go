@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// ReasonAction is a close or forget action on an alert key. It is kept
+// after the alert key's state is gone, for reports on reason codes.
+type ReasonAction struct {
+	Alert    string
+	AlertKey string
+	Type     string
+	Reason   string `json:",omitempty"`
+	Time     time.Time
+}
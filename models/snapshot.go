@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// IncidentSnapshot is what bosun saw when an incident opened: the evaluated
+// results of every group of the alert in that check.
+type IncidentSnapshot struct {
+	Alert string
+	Time  time.Time
+	// Results are by alert key.
+	Results map[string]*SnapshotResult
+}
+
+// SnapshotResult is the status and the evaluated warn and crit results, with
+// their computations, of one alert key.
+type SnapshotResult struct {
+	Status string
+	Warn   json.RawMessage `json:",omitempty"`
+	Crit   json.RawMessage `json:",omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// Subscription is a user's watch of alert keys, which notifies them of their
+// state changes independently of the alerts' notifications.
+type Subscription struct {
+	Id   int64
+	User string
+	// Alert, if set, limits the subscription to the named alert.
+	Alert string `json:",omitempty"`
+	// Tags, if set, limits the subscription to alert keys whose tags match,
+	// such as "host=web*,env=prod".
+	Tags string `json:",omitempty"`
+	// Email and Post are where notifications are sent.
+	Email   string `json:",omitempty"`
+	Post    string `json:",omitempty"`
+	Created time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// AlertVersion is a version of an alert's definition that bosun loaded.
+type AlertVersion struct {
+	// Hash identifies the version by its Text.
+	Hash string
+	Text string
+	// Time is when the version was first loaded, and User and Host the
+	// user and host bosun loaded it as.
+	Time time.Time
+	User string
+	Host string `json:",omitempty"`
+}
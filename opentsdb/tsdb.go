@@ -523,6 +523,22 @@ func ReplaceTags(text string, group TagSet) string {
 	})
 }
 
+var tagVarRE = regexp.MustCompile(`\$(?:\w+|\{[^}]+\})`)
+
+// ReplaceTagVars replaces $tagk and ${tagk} in text with the value of tagk
+// in group. Variables not in group are left unchanged. For example, given the
+// string "web.$host.cpu" and a TagSet with host=ny-web01, this returns
+// "web.ny-web01.cpu".
+func ReplaceTagVars(text string, group TagSet) string {
+	return tagVarRE.ReplaceAllStringFunc(text, func(s string) string {
+		k := strings.TrimSuffix(strings.TrimPrefix(s[1:], "{"), "}")
+		if v, ok := group[k]; ok && v != "" {
+			return v
+		}
+		return s
+	})
+}
+
 func (q Query) String() string {
 	s := q.Aggregator + ":"
 	if q.Downsample != "" {
@@ -822,6 +838,8 @@ type LimitContext struct {
 	Limit int64
 	// FilterTags removes tagks from results if that tagk was not in the request
 	FilterTags bool
+	// Client makes the requests. If nil, DefaultClient is used.
+	Client *http.Client
 }
 
 // NewLimitContext returns a new context for the given host with response sizes limited
@@ -837,7 +855,7 @@ func NewLimitContext(host string, limit int64) *LimitContext {
 // Query returns the result of the request. r may be cached. The request is
 // byte-limited and filtered by c's properties.
 func (c *LimitContext) Query(r *Request) (tr ResponseSet, err error) {
-	resp, err := r.QueryResponse(c.Host, nil)
+	resp, err := r.QueryResponse(c.Host, c.Client)
 	if err != nil {
 		return
 	}
@@ -176,6 +176,20 @@ func TestValidTags(t *testing.T) {
 	}
 }
 
+func TestReplaceTagVars(t *testing.T) {
+	group := TagSet{"host": "ny-web01", "dc.name": "ny"}
+	tests := map[string]string{
+		`graphite("web.$host.cpu", "5m", "", "..")`: `graphite("web.ny-web01.cpu", "5m", "", "..")`,
+		"${dc.name}.${host}":                        "ny.ny-web01",
+		"$disk.$host":                               "$disk.ny-web01",
+	}
+	for in, out := range tests {
+		if s := ReplaceTagVars(in, group); s != out {
+			t.Errorf("%v: got %v, expected %v", in, s, out)
+		}
+	}
+}
+
 func TestAllSubsets(t *testing.T) {
 	ts, _ := ParseTags("a=1,b=2,c=3,d=4")
 	subsets := ts.AllSubsets()
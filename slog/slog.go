@@ -66,6 +66,11 @@ func Set(l Logger) {
 	logging = l
 }
 
+// Get returns the default logger for slog, so it can be wrapped.
+func Get() Logger {
+	return logging
+}
+
 // Info logs an info message.
 func Info(v ...interface{}) {
 	output(logging.Info, v...)